@@ -4,9 +4,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/cmd"
 	"github.com/cppforlife/cobrautil"
@@ -14,6 +19,9 @@ import (
 	"github.com/cppforlife/go-cli-ui/ui"
 )
 
+// exitCodeInterrupted exit code when the command is stopped by an interruption, as shells report for SIGINT
+const exitCodeInterrupted = 130
+
 func main() {
 	log.SetOutput(io.Discard)
 
@@ -25,15 +33,23 @@ func main() {
 
 	command := cmd.NewDefaultImgpkgCmd(confUI)
 
-	// Deprecation warning section
-	_, found := os.LookupEnv("IMGPKG_ENABLE_IAAS_AUTH")
-	if found {
-		confUI.PrintLinef("IMGPKG_ENABLE_IAAS_AUTH environment variable will be deprecated, please use the flag --activate-keychain to activate the needed keychains")
-	}
-	// End
+	// the first interruption cancels the context so that the commands stop and clean up what they were writing,
+	// a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	err := command.Execute()
+	err := command.ExecuteContext(ctx)
 	if err != nil {
+		if cmd.UsesJSONOutput(command) {
+			printJSONError(ctx, err)
+		}
+		if ctx.Err() != nil {
+			confUI.ErrorLinef("imgpkg: Error: Interrupted: %v", uierrs.NewMultiLineError(err))
+			os.Exit(exitCodeInterrupted)
+		}
 		confUI.ErrorLinef("imgpkg: Error: %v", uierrs.NewMultiLineError(err))
 		os.Exit(1)
 	}
@@ -41,3 +57,17 @@ func main() {
 		confUI.PrintLinef("Succeeded")
 	}
 }
+
+// printJSONError writes the error to stderr as a JSON document with a stable code, so that scripts can tell why
+// the command failed. The error is also part of the JSON written to stdout, like every other line
+func printJSONError(ctx context.Context, err error) {
+	code := cmd.ErrorCode(err)
+	if ctx.Err() != nil {
+		code = cmd.ErrorCodeInterrupted
+	}
+	doc, marshalErr := json.Marshal(cmd.JSONError{Error: err.Error(), Code: code})
+	if marshalErr != nil {
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(doc))
+}
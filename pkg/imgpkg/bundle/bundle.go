@@ -66,6 +66,12 @@ type Bundle struct {
 	// discovered as part of reading the bundle.
 	// Includes refs only directly referenced by the bundle.
 	cachedImageRefs *imageRefCache
+
+	// imagesLockFormat format of the ImagesLock rewritten by pull
+	imagesLockFormat lockconfig.LockFormat
+
+	// omittedImages images of the ImagesLock filtered out when the bundle was copied to its repository, found by pull
+	omittedImages []string
 }
 
 // NewBundleFromPlainImage Creates a new Bundle with a PlainImage and uses Registry Fetcher
@@ -97,12 +103,32 @@ func (o *Bundle) Digest() string { return o.plainImg.Digest() }
 // Repo Bundle registry and Repository
 func (o *Bundle) Repo() string { return o.plainImg.Repo() }
 
+// MediaType media type of the manifest of the Bundle image
+func (o *Bundle) MediaType() (string, error) { return o.plainImg.MediaType() }
+
 // Tag Bundle Tag
 func (o *Bundle) Tag() string { return o.plainImg.Tag() }
 
+// Annotations annotations of the manifest of the bundle image
+func (o *Bundle) Annotations() (map[string]string, error) {
+	img, err := o.plainImg.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("Getting bundle manifest: %s", err)
+	}
+	return manifest.Annotations, nil
+}
+
 // NestedBundles Provides information about the Graph of nested bundles associated with the current bundle
 func (o *Bundle) NestedBundles() []GraphNode { return o.cachedNestedBundleGraph }
 
+// SetImagesLockFormat selects the format of the ImagesLock rewritten by pull when the images are in the repository of
+// the bundle, for it and its nested bundles
+func (o *Bundle) SetImagesLockFormat(format lockconfig.LockFormat) { o.imagesLockFormat = format }
+
 func (o *Bundle) findCachedImageRef(digestRef string) (ImageRef, bool) {
 	ref, found := o.cachedImageRefs.ImageRef(digestRef)
 	if found {
@@ -120,8 +146,41 @@ func (o *Bundle) findCachedImageRef(digestRef string) (ImageRef, bool) {
 	return ImageRef{}, false
 }
 
+// AllImagesCopied checks if all the images of the bundle are part of processedImages, which is not the case when the
+// bundle is copied from a tar written by push --to-tar, since the tar only has the bundle
+func (o *Bundle) AllImagesCopied(processedImages *imageset.ProcessedImages) bool {
+	return o.AllImagesCopiedOrOmitted(processedImages, nil)
+}
+
+// AllImagesCopiedOrOmitted checks if all the images of the bundle are part of processedImages, or have their digest in
+// omittedDigests, the images filtered out of the copy
+func (o *Bundle) AllImagesCopiedOrOmitted(processedImages *imageset.ProcessedImages, omittedDigests map[string]bool) bool {
+	foundImages := map[string]bool{}
+	for _, ref := range o.cachedImageRefs.All() {
+		if omittedDigests[ref.Digest()] {
+			foundImages[ref.Digest()] = true
+		}
+	}
+	for _, image := range processedImages.All() {
+		imgDigest, err := regname.NewDigest(image.UnprocessedImageRef.DigestRef)
+		if err != nil {
+			panic(fmt.Sprintf("Internal inconsistency: Image '%s' is not a valid Digest Reference", err))
+		}
+		if _, found := o.findCachedImageRef(image.UnprocessedImageRef.DigestRef); found {
+			foundImages[imgDigest.DigestStr()] = true
+		}
+	}
+	return len(foundImages) == o.cachedImageRefs.Size()
+}
+
 // NoteCopy writes an image-location representing the bundle / images that have been copied
 func (o *Bundle) NoteCopy(processedImages *imageset.ProcessedImages, reg ImagesMetadataWriter, ui util.LoggerWithLevels) error {
+	return o.NoteCopyWithOmitted(processedImages, nil, reg, ui)
+}
+
+// NoteCopyWithOmitted writes an image-location representing the bundle / images that have been copied, the images with
+// their digest in omittedDigests being recorded as omitted, so that pull keeps their original location
+func (o *Bundle) NoteCopyWithOmitted(processedImages *imageset.ProcessedImages, omittedDigests map[string]bool, reg ImagesMetadataWriter, ui util.LoggerWithLevels) error {
 	locationsCfg := ImageLocationsConfig{
 		APIVersion: LocationAPIVersion,
 		Kind:       ImageLocationsKind,
@@ -149,6 +208,17 @@ func (o *Bundle) NoteCopy(processedImages *imageset.ProcessedImages, reg ImagesM
 			bundleProcessedImage = image
 		}
 	}
+	for _, ref := range o.cachedImageRefs.All() {
+		if foundImages[ref.Digest()] || !omittedDigests[ref.Digest()] {
+			continue
+		}
+		locationsCfg.Images = append(locationsCfg.Images, ImageLocation{
+			Image:    ref.Image,
+			IsBundle: *ref.IsBundle,
+			Omitted:  true,
+		})
+		foundImages[ref.Digest()] = true
+	}
 
 	if len(locationsCfg.Images) != o.cachedImageRefs.Size() {
 		panic(fmt.Sprintf("Expected: on bundle %s %d images to be written to Location OCI. Actual: %d were written", o.DigestRef(), o.cachedImageRefs.Size(), len(locationsCfg.Images)))
@@ -167,21 +237,42 @@ func (o *Bundle) NoteCopy(processedImages *imageset.ProcessedImages, reg ImagesM
 
 // Pull Downloads bundle image to disk and checks if it can update the ImagesLock file
 func (o *Bundle) Pull(outputPath string, logger Logger, pullNestedBundles bool) (bool, error) {
-	isRootBundleRelocated, err := o.pull(outputPath, logger, pullNestedBundles, "", map[string]bool{}, 0)
+	return o.PullWithOpts(outputPath, ctlimg.DirImageOpts{}, logger, pullNestedBundles)
+}
+
+// PullWithOpts Downloads bundle image to disk, using the provided extraction options, and checks if it can update the ImagesLock file
+func (o *Bundle) PullWithOpts(outputPath string, opts ctlimg.DirImageOpts, logger Logger, pullNestedBundles bool) (bool, error) {
+	return o.pullWithNestedDepth(outputPath, opts, logger, pullNestedBundles, 0)
+}
+
+// PullNestedWithOpts Downloads bundle image and its nested bundles to disk, going down at most maxDepth levels of
+// nested bundles, or every level when maxDepth is 0, and checks if it can update the ImagesLock file
+func (o *Bundle) PullNestedWithOpts(outputPath string, opts ctlimg.DirImageOpts, logger Logger, maxDepth int) (bool, error) {
+	return o.pullWithNestedDepth(outputPath, opts, logger, true, maxDepth)
+}
+
+func (o *Bundle) pullWithNestedDepth(outputPath string, opts ctlimg.DirImageOpts, logger Logger, pullNestedBundles bool, maxDepth int) (bool, error) {
+	isRootBundleRelocated, err := o.pull(outputPath, opts, logger, pullNestedBundles, "", map[string]bool{}, 0, nil, maxDepth)
 	if err != nil {
 		return false, err
 	}
 
 	logger.Logf("\nLocating image lock file images...\n")
-	if isRootBundleRelocated {
+	switch {
+	case isRootBundleRelocated && len(o.omittedImages) > 0:
+		logger.Logf("The bundle repo (%s) is hosting every image specified in the bundle's Images Lock file (.imgpkg/images.yml), "+
+			"except for the %d image(s) omitted when the bundle was copied, which keep their original location\n", o.Repo(), len(o.omittedImages))
+	case isRootBundleRelocated:
 		logger.Logf("The bundle repo (%s) is hosting every image specified in the bundle's Images Lock file (.imgpkg/images.yml)\n", o.Repo())
-	} else {
+	default:
 		logger.Logf("One or more images not found in bundle repo; skipping lock file update\n")
 	}
 	return isRootBundleRelocated, nil
 }
 
-func (o *Bundle) pull(baseOutputPath string, logger Logger, pullNestedBundles bool, bundlePath string, imagesProcessed map[string]bool, numSubBundles int) (bool, error) {
+// pull extracts the bundle into bundlePath, and its nested bundles when pullNestedBundles is true. ancestors are the
+// digests of the bundles referencing this one, from the root bundle, which are used to detect cycles between bundles
+func (o *Bundle) pull(baseOutputPath string, opts ctlimg.DirImageOpts, logger Logger, pullNestedBundles bool, bundlePath string, imagesProcessed map[string]bool, numSubBundles int, ancestors []string, maxDepth int) (bool, error) {
 	img, err := o.checkedImage()
 	if err != nil {
 		return false, err
@@ -198,7 +289,14 @@ func (o *Bundle) pull(baseOutputPath string, logger Logger, pullNestedBundles bo
 		return false, err
 	}
 
-	err = ctlimg.NewDirImage(filepath.Join(baseOutputPath, bundlePath), img, util.NewIndentedLevelLogger(logger)).AsDirectory()
+	bundleOpts := opts
+	if len(opts.IncludePaths) > 0 {
+		// the bundle metadata is needed to process the images of the bundle
+		bundleOpts.IncludePaths = append(append([]string{}, opts.IncludePaths...), ImgpkgDir)
+	}
+	bundleOpts.ExcludedPaths = withoutBundleMetadata(opts.ExcludedPaths)
+
+	err = ctlimg.NewDirImageWithOpts(filepath.Join(baseOutputPath, bundlePath), img, bundleOpts, util.NewIndentedLevelLogger(logger)).AsDirectory()
 	if err != nil {
 		return false, fmt.Errorf("Extracting bundle into directory: %s", err)
 	}
@@ -222,8 +320,20 @@ func (o *Bundle) pull(baseOutputPath string, logger Logger, pullNestedBundles bo
 		return false, err
 	}
 
+	o.omittedImages = bundleImageRefs.OmittedImages()
+	for _, image := range o.omittedImages {
+		logger.Warnf("Image '%s' was omitted when the bundle was copied to '%s', it is referenced at its original location\n", image, o.Repo())
+	}
+
 	if pullNestedBundles {
+		// a registry serving content that does not match its digests could make bundles reference each other
+		bundleChain := append(append([]string{}, ancestors...), bundleDigestRef.DigestStr())
 		for _, bundleImgRef := range bundleImageRefs.ImageRefs() {
+			err := checkBundleCycle(bundleChain, bundleImgRef.Image)
+			if err != nil {
+				return false, err
+			}
+
 			if isBundle, alreadyProcessedImage := imagesProcessed[bundleImgRef.Image]; alreadyProcessedImage {
 				if isBundle {
 					util.NewIndentedLevelLogger(logger).Logf("Pulling nested bundle '%s'\n", bundleImgRef.Image)
@@ -233,6 +343,7 @@ func (o *Bundle) pull(baseOutputPath string, logger Logger, pullNestedBundles bo
 			}
 
 			subBundle := NewBundleFromRef(bundleImgRef.PrimaryLocation(), o.imgRetriever, o.imagesLockReader, o.bundleFetcher)
+			subBundle.imagesLockFormat = o.imagesLockFormat
 
 			var isBundle bool
 			if bundleImgRef.IsBundle != nil {
@@ -259,7 +370,16 @@ func (o *Bundle) pull(baseOutputPath string, logger Logger, pullNestedBundles bo
 			if err != nil {
 				return false, err
 			}
-			_, err = subBundle.pull(baseOutputPath, util.NewIndentedLevelLogger(logger), pullNestedBundles, o.subBundlePath(bundleDigest), imagesProcessed, numSubBundles)
+			if maxDepth > 0 && len(bundleChain) > maxDepth {
+				util.NewIndentedLevelLogger(logger).Logf("Pulling nested bundle '%s'\n", bundleImgRef.Image)
+				util.NewIndentedLevelLogger(logger).Logf("Skipped, deeper than %d level(s) of nested bundles\n", maxDepth)
+				continue
+			}
+			// include and excluded paths refer to the paths of the root bundle, nested bundles are always fully extracted
+			nestedOpts := opts
+			nestedOpts.IncludePaths = nil
+			nestedOpts.ExcludedPaths = nil
+			_, err = subBundle.pull(baseOutputPath, nestedOpts, util.NewIndentedLevelLogger(logger), pullNestedBundles, o.subBundlePath(bundleDigest), imagesProcessed, numSubBundles, bundleChain, maxDepth)
 			if err != nil {
 				return false, err
 			}
@@ -273,7 +393,7 @@ func (o *Bundle) pull(baseOutputPath string, logger Logger, pullNestedBundles bo
 	}
 
 	if isRelocatedToBundle {
-		err := bundleImageRefs.ImagesLock().WriteToPath(filepath.Join(baseOutputPath, bundlePath, ImgpkgDir, ImagesLockFile))
+		err := bundleImageRefs.ImagesLock().WriteToPathInFormat(filepath.Join(baseOutputPath, bundlePath, ImgpkgDir, ImagesLockFile), o.imagesLockFormat)
 		if err != nil {
 			return false, fmt.Errorf("Rewriting image lock file: %s", err)
 		}
@@ -286,6 +406,37 @@ func (*Bundle) subBundlePath(bundleDigest regname.Digest) string {
 	return filepath.Join(ImgpkgDir, BundlesDir, strings.ReplaceAll(bundleDigest.DigestStr(), "sha256:", "sha256-"))
 }
 
+// checkBundleCycle fails when the image is one of the bundles of the chain, from the root bundle to the bundle
+// referencing the image
+func checkBundleCycle(bundleChain []string, imageRef string) error {
+	digest, err := regname.NewDigest(imageRef)
+	if err != nil {
+		// only images referenced by digest can be bundles of the chain
+		return nil
+	}
+	for idx, bundleDigest := range bundleChain {
+		if bundleDigest == digest.DigestStr() {
+			cycle := append(append([]string{}, bundleChain[idx:]...), bundleDigest)
+			return fmt.Errorf("Found a cycle between nested bundles: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return nil
+}
+
+// withoutBundleMetadata returns the excluded paths without the ones inside of the bundle metadata directory, which
+// is always extracted since it is needed to process the images of the bundle
+func withoutBundleMetadata(excludedPaths []string) []string {
+	var result []string
+	for _, excludedPath := range excludedPaths {
+		excludedPath = strings.Trim(filepath.ToSlash(filepath.Clean(excludedPath)), "/")
+		if excludedPath == ImgpkgDir || strings.HasPrefix(excludedPath, ImgpkgDir+"/") {
+			continue
+		}
+		result = append(result, excludedPath)
+	}
+	return result
+}
+
 func (o *Bundle) shouldPrintNestedBundlesHeader(bundlePath string, bundlesProcessed int) bool {
 	return o.rootBundle(bundlePath) && bundlesProcessed == 1
 }
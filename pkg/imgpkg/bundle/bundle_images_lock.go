@@ -183,11 +183,13 @@ func (o *SingleLayerReader) Read(img regv1.Image) (lockconfig.ImagesLock, error)
 		return conf, err
 	}
 
-	if mediaType != types.DockerLayer {
-		return conf, fmt.Errorf("Expected layer to have docker layer media type, was %s", mediaType)
+	switch mediaType {
+	case types.DockerLayer, types.DockerUncompressedLayer, types.OCILayer, types.OCILayerZStd, types.OCIUncompressedLayer:
+	default:
+		return conf, fmt.Errorf("Expected layer to have docker or OCI layer media type, was %s", mediaType)
 	}
 
-	// here we know layer is .tgz so decompress and read tar headers
+	// the layer is pushed with gzip, zstd or no compression, Uncompressed detects which one
 	unzippedReader, err := layer.Uncompressed()
 	if err != nil {
 		return conf, fmt.Errorf("Could not read bundle image layer contents: %v", err)
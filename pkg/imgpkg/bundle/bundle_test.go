@@ -12,6 +12,7 @@ import (
 	"testing"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/imageset"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
@@ -181,6 +182,29 @@ func TestPullNestedBundlesWritingContentsToDisk(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, string(expectedConfigFile), string(actualConfigFile))
 	})
+
+	t.Run("bundle referencing another bundle that references another bundle only pulls the nested bundles up to the maximum depth", func(t *testing.T) {
+		fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+		defer fakeRegistry.CleanUp()
+
+		// repo/bundle_icecream_with_single_bundle - dependsOn - icecream/bundle - dependsOn - apples/bundle
+		applesBundle := fakeRegistry.WithBundleFromPath("apples/bundle", "test_assets/bundle_with_mult_images").WithEveryImageFromPath("test_assets/image_with_config", map[string]string{})
+		iceCreamBundle := fakeRegistry.WithBundleFromPath("icecream/bundle", "test_assets/bundle_apples_with_single_bundle").WithEveryImageFromPath("test_assets/bundle_with_mult_images", map[string]string{"dev.carvel.imgpkg.bundle": ""})
+		fakeRegistry.WithBundleFromPath("repo/bundle_icecream_with_single_bundle", "test_assets/bundle_icecream_with_single_bundle").WithEveryImageFromPath("test_assets/bundle_apples_with_single_bundle", map[string]string{"dev.carvel.imgpkg.bundle": ""})
+
+		reg := fakeRegistry.Build()
+		imagesLockReader := bundle.NewImagesLockReader()
+		subject := bundle.NewBundleFromRef(fakeRegistry.ReferenceOnTestServer("repo/bundle_icecream_with_single_bundle"), reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
+		outputPath := t.TempDir()
+		output := bytes.NewBufferString("")
+
+		_, err := subject.PullNestedWithOpts(outputPath, ctlimg.DirImageOpts{}, util.NewUILevelLogger(util.LogWarn, util.NewBufferLogger(output)), 1)
+		require.NoError(t, err)
+
+		require.FileExists(t, filepath.Join(outputPath, ".imgpkg", "bundles", strings.ReplaceAll(iceCreamBundle.Digest, "sha256:", "sha256-"), "config.yml"))
+		require.NoDirExists(t, filepath.Join(outputPath, ".imgpkg", "bundles", strings.ReplaceAll(applesBundle.Digest, "sha256:", "sha256-")))
+		assert.Contains(t, output.String(), "Skipped, deeper than 1 level(s) of nested bundles")
+	})
 }
 
 func TestPullNestedBundlesLocalizesImagesLockFile(t *testing.T) {
@@ -581,6 +605,63 @@ kind: ImagesLock
 	})
 }
 
+func TestPullBundleWithOmittedImages(t *testing.T) {
+	output := bytes.NewBufferString("")
+	logger := util.NewUILevelLogger(util.LogWarn, util.NewBufferLogger(output))
+
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer fakeRegistry.CleanUp()
+
+	appImage := fakeRegistry.WithRandomImage("app/image")
+	postgresImage := fakeRegistry.WithRandomImage("library/postgres")
+	relocatedAppImage := fakeRegistry.WithImage("repo/bundle", appImage.Image)
+	rootBundle := fakeRegistry.WithBundleFromPath("repo/bundle", "test_assets/bundle_with_mult_images").WithImageRefs([]lockconfig.ImageRef{
+		{Image: appImage.RefDigest},
+		{Image: postgresImage.RefDigest, Annotations: map[string]string{"hello": "world"}},
+	})
+
+	locationPath, err := os.MkdirTemp(os.TempDir(), "test-location-path")
+	require.NoError(t, err)
+	defer os.Remove(locationPath)
+
+	fakeRegistry.WithLocationsImage("repo/bundle@"+rootBundle.Digest, locationPath, bundle.ImageLocationsConfig{
+		APIVersion: "imgpkg.carvel.dev/v1alpha1",
+		Kind:       "ImageLocations",
+		Images: []bundle.ImageLocation{
+			{Image: appImage.RefDigest},
+			{Image: postgresImage.RefDigest, Omitted: true},
+		},
+	})
+	reg := fakeRegistry.Build()
+
+	imagesLockReader := bundle.NewImagesLockReader()
+	subject := bundle.NewBundleFromRef(rootBundle.RefDigest, reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
+	outputPath, err := os.MkdirTemp(os.TempDir(), "test-output-bundle-path")
+	require.NoError(t, err)
+	defer os.Remove(outputPath)
+
+	isRelocated, err := subject.Pull(outputPath, logger, false)
+	require.NoError(t, err)
+	assert.True(t, isRelocated)
+
+	imagesYml, err := os.ReadFile(filepath.Join(outputPath, ".imgpkg", "images.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`---
+apiVersion: imgpkg.carvel.dev/v1alpha1
+images:
+- image: %s
+- annotations:
+    hello: world
+    imgpkg.carvel.dev/omitted: "true"
+  image: %s
+kind: ImagesLock
+`, relocatedAppImage.RefDigest, postgresImage.RefDigest), string(imagesYml))
+
+	assert.Contains(t, output.String(), fmt.Sprintf("Warning: Image '%s' was omitted when the bundle was copied to '%s', it is referenced at its original location",
+		postgresImage.RefDigest, fakeRegistry.ReferenceOnTestServer("repo/bundle")))
+	assert.Contains(t, output.String(), "except for the 1 image(s) omitted when the bundle was copied, which keep their original location")
+}
+
 func TestPullBundleOutputToUser(t *testing.T) {
 	pullNestedBundles := false
 
@@ -607,6 +688,8 @@ func TestPullBundleOutputToUser(t *testing.T) {
 		assert.Regexp(t,
 			fmt.Sprintf(`Pulling bundle '%s@sha256:.*'
   Extracting layer 'sha256:.*' \(1/1\)
+  Extracted .* from 1 layer\(s\) in .*
+    Layer 'sha256:.*': .*
 
 Locating image lock file images...
 One or more images not found in bundle repo; skipping lock file update`, bundleName), output.String())
@@ -636,6 +719,8 @@ One or more images not found in bundle repo; skipping lock file update`, bundleN
 		assert.Regexp(t,
 			fmt.Sprintf(`Pulling bundle '%s@sha256:.*'
   Extracting layer 'sha256:.*' \(1/1\)
+  Extracted .* from 1 layer\(s\) in .*
+    Layer 'sha256:.*': .*
 
 Locating image lock file images...
 One or more images not found in bundle repo; skipping lock file update`, bundleName), output.String())
@@ -680,10 +765,14 @@ func TestPullAllNestedBundlesOutputToUser(t *testing.T) {
 		assert.Regexp(t,
 			fmt.Sprintf(`Pulling bundle .*
   Extracting layer .*
+  Extracted .*
+    Layer .*
 
 Nested bundles
   Pulling nested bundle .*
     Extracting layer .*
+    Extracted .*
+      Layer .*
 
 Locating image lock file images...
 The bundle repo \(%s\) is hosting every image specified in the bundle's Images Lock file \(\.imgpkg/images\.yml\)
@@ -715,10 +804,14 @@ The bundle repo \(%s\) is hosting every image specified in the bundle's Images L
 		assert.Regexp(t,
 			fmt.Sprintf(`Pulling bundle '%s@sha256:.*'
   Extracting layer 'sha256:.*' \(1/1\)
+  Extracted .* from 1 layer\(s\) in .*
+    Layer 'sha256:.*': .*
 
 Nested bundles
   Pulling nested bundle '%s@sha256:.*'
     Extracting layer 'sha256:.*' \(1/1\)
+    Extracted .* from 1 layer\(s\) in .*
+      Layer 'sha256:.*': .*
 
 Locating image lock file images...
 One or more images not found in bundle repo; skipping lock file update`, bundleName, icecreamBundleName), output.String())
@@ -762,14 +855,22 @@ One or more images not found in bundle repo; skipping lock file update`, bundleN
 		assert.Regexp(t,
 			fmt.Sprintf(`Pulling bundle '%s'
   Extracting layer 'sha256:.*' \(1/1\)
+  Extracted .* from 1 layer\(s\) in .*
+    Layer 'sha256:.*': .*
 
 Nested bundles
   Pulling nested bundle '%s'
     Extracting layer 'sha256:.*' \(1/1\)
+    Extracted .* from 1 layer\(s\) in .*
+      Layer 'sha256:.*': .*
     Pulling nested bundle '%s'
       Extracting layer 'sha256:.*' \(1/1\)
+      Extracted .* from 1 layer\(s\) in .*
+        Layer 'sha256:.*': .*
   Pulling nested bundle '%s'
     Extracting layer 'sha256:.*' \(1/1\)
+    Extracted .* from 1 layer\(s\) in .*
+      Layer 'sha256:.*': .*
     Pulling nested bundle '%s'
     Skipped, already downloaded
 
@@ -807,12 +908,18 @@ One or more images not found in bundle repo; skipping lock file update`, bundleW
 		assert.Regexp(t,
 			fmt.Sprintf(`Pulling bundle '%s'
   Extracting layer 'sha256:.*' \(1/1\)
+  Extracted .* from 1 layer\(s\) in .*
+    Layer 'sha256:.*': .*
 
 Nested bundles
   Pulling nested bundle '%s'
     Extracting layer 'sha256:.*' \(1/1\)
+    Extracted .* from 1 layer\(s\) in .*
+      Layer 'sha256:.*': .*
     Pulling nested bundle '%s'
       Extracting layer 'sha256:.*' \(1/1\)
+      Extracted .* from 1 layer\(s\) in .*
+        Layer 'sha256:.*': .*
 
 Locating image lock file images...
 One or more images not found in bundle repo; skipping lock file update`, icecreamWithSingleBundle.RefDigest, icecreamBundle.RefDigest, applesBundle.RefDigest), output.String())
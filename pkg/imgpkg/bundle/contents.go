@@ -9,24 +9,40 @@ import (
 	"path/filepath"
 	"strings"
 
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
 	regname "github.com/google/go-containerregistry/pkg/name"
 	regv1 "github.com/google/go-containerregistry/pkg/v1"
 	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"sigs.k8s.io/yaml"
 )
 
 const (
 	ImgpkgDir      = ".imgpkg"
 	BundlesDir     = "bundles"
 	ImagesLockFile = "images.yml"
+
+	// ResolvedTagAnnotation annotation of the images of the images lock pushed with --resolve-tags, with the tag the
+	// image was referenced by before it was resolved to its digest
+	ResolvedTagAnnotation = "imgpkg.carvel.dev/resolved-tag"
+	// OmittedImageAnnotation annotation of the images of an images lock that were filtered out of the copy of the
+	// bundle, or of the images lock, and kept their original location
+	OmittedImageAnnotation = "imgpkg.carvel.dev/omitted"
 )
 
 type Contents struct {
-	paths               []string
-	excludedPaths       []string
-	preservePermissions bool
+	paths         []string
+	excludedPaths []string
+	tarImageOpts  ctlimg.TarImageOpts
+	// tar content of the bundle instead of paths, nil when the paths are pushed
+	tar *ctlimg.TarStream
+	// resolveTags accepts images referenced by tag in the images lock, pushing it with the digests they point to
+	resolveTags bool
+	// onImagesLock is called with the images lock pushed, once it is checked and before the bundle is built
+	onImagesLock func(lockconfig.ImagesLock) error
 }
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . ImagesMetadataWriter
@@ -38,23 +54,63 @@ type ImagesMetadataWriter interface {
 }
 
 // NewContents creates Contents struct
-func NewContents(paths []string, excludedPaths []string, preservePermissions bool) Contents {
-	return Contents{paths: paths, excludedPaths: excludedPaths, preservePermissions: preservePermissions}
+func NewContents(paths []string, excludedPaths []string, tarImageOpts ctlimg.TarImageOpts) Contents {
+	return Contents{paths: paths, excludedPaths: excludedPaths, tarImageOpts: tarImageOpts}
+}
+
+// NewContentsFromTar creates Contents struct with the entries of the provided tar
+func NewContentsFromTar(tar *ctlimg.TarStream, excludedPaths []string, tarImageOpts ctlimg.TarImageOpts) Contents {
+	return Contents{tar: tar, excludedPaths: excludedPaths, tarImageOpts: tarImageOpts}
+}
+
+// WithResolveTags returns the contents accepting images referenced by tag in the images lock. The tags are resolved
+// to the digests they point to when pushing, and the images lock is pushed with them instead of the one provided
+func (b Contents) WithResolveTags(resolveTags bool) Contents {
+	b.resolveTags = resolveTags
+	return b
+}
+
+// WithOnImagesLock returns the contents calling onImagesLock with the images lock of the bundle, as it is pushed, once
+// it is checked and before the bundle is built, so that the bundle is not pushed when onImagesLock fails
+func (b Contents) WithOnImagesLock(onImagesLock func(lockconfig.ImagesLock) error) Contents {
+	b.onImagesLock = onImagesLock
+	return b
 }
 
 // Push the contents of the bundle to the registry as an OCI Image
-func (b Contents) Push(uploadRef regname.Tag, labels map[string]string, registry ImagesMetadataWriter, logger Logger) (string, error) {
-	err := b.validate()
+func (b Contents) Push(uploadRef regname.Tag, imageOpts ctlimg.FileImageOpts, registry ImagesMetadataWriter, logger Logger) (string, error) {
+	imgpkgDir, err := b.validate()
 	if err != nil {
 		return "", err
 	}
 
-	if labels == nil {
-		labels = map[string]string{}
+	imagesLockData, replacedFiles, err := b.checkImagesLock(imgpkgDir, registry)
+	if err != nil {
+		return "", err
+	}
+	if b.onImagesLock != nil {
+		imagesLock, err := lockconfig.NewImagesLockFromBytes(imagesLockData)
+		if err != nil {
+			return "", err
+		}
+		err = b.onImagesLock(imagesLock)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	labels := map[string]string{}
+	for key, value := range imageOpts.Labels {
+		labels[key] = value
 	}
 	labels[BundleConfigLabel] = "true"
+	imageOpts.Labels = labels
 
-	return plainimage.NewContents(b.paths, b.excludedPaths, b.preservePermissions).Push(uploadRef, labels, registry, logger)
+	contents := plainimage.NewContents(b.paths, b.excludedPaths, b.tarImageOpts)
+	if b.tar != nil {
+		contents = plainimage.NewContentsFromTar(b.tar, b.excludedPaths, b.tarImageOpts)
+	}
+	return contents.WithReplacedFiles(replacedFiles).Push(uploadRef, imageOpts, registry, logger)
 }
 
 // PresentsAsBundle checks if the provided folders have the needed structure to be a bundle
@@ -75,22 +131,113 @@ func (b Contents) PresentsAsBundle() (bool, error) {
 	return true, nil
 }
 
-func (b Contents) validate() error {
+// validate checks the structure of the bundle, returning its imgpkgDir
+func (b Contents) validate() (string, error) {
 	imgpkgDirs, err := b.findImgpkgDirs()
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	err = b.validateImgpkgDirs(imgpkgDirs)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return imgpkgDirs[0], nil
+}
+
+// checkImagesLock checks the images lock of imgpkgDir before pushing it, returning its content as it is pushed. When
+// tags are resolved, the images lock with the digests of the tags is returned as the content of the images lock of the
+// image, if it references any tag
+func (b Contents) checkImagesLock(imgpkgDir string, metadata ImagesMetadata) ([]byte, map[string][]byte, error) {
+	lockPath := filepath.Join(imgpkgDir, ImagesLockFile)
+	lockRelPath := ImgpkgDir + "/" + ImagesLockFile
+
+	var data []byte
+	var err error
+	if b.tar != nil {
+		lockPath = b.tar.Name() + ":" + lockRelPath
+		data, err = b.tar.ReadFile(lockRelPath)
+	} else {
+		data, err = os.ReadFile(lockPath)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Reading images lock '%s': %s", lockPath, err)
+	}
+
+	err = lockconfig.CheckImagesLock(lockPath, data, lockconfig.ImagesLockCheckOpts{AllowTags: b.resolveTags})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var replacedFiles map[string][]byte
+	if b.resolveTags {
+		resolved, err := resolveImagesLockTags(lockPath, data, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resolved != nil {
+			data = resolved
+			replacedFiles = map[string][]byte{lockRelPath: resolved}
+		}
+	}
+
+	return data, replacedFiles, nil
+}
+
+// resolveImagesLockTags returns the images lock in data with the images referenced by tag replaced by the digest the
+// tag points to, in the same repository, the tag being recorded with ResolvedTagAnnotation. The tags that cannot be
+// resolved are all reported. nil is returned when all the images are already referenced by digest
+func resolveImagesLockTags(lockPath string, data []byte, metadata ImagesMetadata) ([]byte, error) {
+	var lock lockconfig.ImagesLock
+	err := yaml.UnmarshalStrict(data, &lock)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshaling images lock '%s': %s", lockPath, err)
+	}
+
+	resolved := false
+	var failures []string
+	for idx, image := range lock.Images {
+		tag, err := regname.NewTag(image.Image)
+		if err != nil {
+			// referenced by digest
+			continue
+		}
+
+		// each image is resolved with the authentication of its own registry
+		digest, err := metadata.Digest(tag)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("- %s: %s", image.Image, err))
+			continue
+		}
+		if lock.Images[idx].Annotations == nil {
+			lock.Images[idx].Annotations = map[string]string{}
+		}
+		lock.Images[idx].Annotations[ResolvedTagAnnotation] = image.Image
+		lock.Images[idx].Image = tag.Context().Digest(digest.String()).Name()
+		resolved = true
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("Resolving the tags of %d image(s) of images lock '%s':\n%s", len(failures), lockPath, strings.Join(failures, "\n"))
+	}
+	if !resolved {
+		return nil, nil
 	}
 
-	return nil
+	return lock.AsBytes()
 }
 
 func (b *Contents) findImgpkgDirs() ([]string, error) {
 	var bundlePaths []string
+	if b.tar != nil {
+		for _, path := range b.tar.Paths() {
+			if filepath.Base(path) == ImgpkgDir {
+				bundlePaths = append(bundlePaths, path)
+			}
+		}
+		return bundlePaths, nil
+	}
+
 	for _, path := range b.paths {
 		err := filepath.Walk(path, func(currPath string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -133,6 +280,9 @@ func (b Contents) validateImgpkgDirs(imgpkgDirs []string) error {
 
 	// make sure it is a child of one input dir
 	path := imgpkgDirs[0]
+	if b.tar != nil {
+		return b.validateTarImgpkgDir(path)
+	}
 	for _, flagPath := range b.paths {
 		flagPath, err := filepath.Abs(flagPath)
 		if err != nil {
@@ -157,6 +307,21 @@ func (b Contents) validateImgpkgDirs(imgpkgDirs []string) error {
 	return bundleValidationError{msg}
 }
 
+// validateTarImgpkgDir checks that the imgpkgDir of the tar is at its root and has the images lock
+func (b Contents) validateTarImgpkgDir(imgpkgDir string) error {
+	if imgpkgDir != ImgpkgDir {
+		return bundleValidationError{fmt.Sprintf("Expected '%s' directory, to be at the root of the tar; was %s", ImgpkgDir, imgpkgDir)}
+	}
+
+	imgpkgPath := ImgpkgDir + "/" + ImagesLockFile
+	for _, path := range b.tar.Paths() {
+		if path == imgpkgPath {
+			return nil
+		}
+	}
+	return bundleValidationError{"The bundle expected .imgpkg/images.yml to exist, but it wasn't found in the tar"}
+}
+
 type bundleValidationError struct {
 	msg string
 }
@@ -4,15 +4,23 @@
 package bundle_test
 
 import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
 	"carvel.dev/imgpkg/pkg/imgpkg/bundle/bundlefakes"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/test/helpers"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewContentsBundleWithBundles(t *testing.T) {
@@ -38,13 +46,13 @@ images:
 	fakeRegistry.ImageReturns(bundleImg, nil)
 
 	t.Run("push is successful", func(t *testing.T) {
-		subject := bundle.NewContents([]string{bundleDir}, nil, false)
+		subject := bundle.NewContents([]string{bundleDir}, nil, ctlimg.TarImageOpts{})
 		imgTag, err := name.NewTag("my.registry.io/new-bundle:tag")
 		if err != nil {
 			t.Fatalf("failed to read tag: %s", err)
 		}
 
-		_, err = subject.Push(imgTag, map[string]string{}, fakeRegistry, util.NewNoopLevelLogger())
+		_, err = subject.Push(imgTag, ctlimg.FileImageOpts{}, fakeRegistry, util.NewNoopLevelLogger())
 		if err != nil {
 			t.Fatalf("not expecting push to fail: %s", err)
 		}
@@ -72,15 +80,110 @@ images:
 	fakeRegistry.ImageReturns(bundleImg, nil)
 
 	t.Run("push is successful", func(t *testing.T) {
-		subject := bundle.NewContents([]string{bundleDir}, nil, false)
+		subject := bundle.NewContents([]string{bundleDir}, nil, ctlimg.TarImageOpts{})
 		imgTag, err := name.NewTag("my.registry.io/new-bundle:tag")
 		if err != nil {
 			t.Fatalf("failed to read tag: %s", err)
 		}
 
-		_, err = subject.Push(imgTag, map[string]string{}, fakeRegistry, util.NewNoopLevelLogger())
+		_, err = subject.Push(imgTag, ctlimg.FileImageOpts{}, fakeRegistry, util.NewNoopLevelLogger())
 		if err != nil {
 			t.Fatalf("not expecting push to fail: %s", err)
 		}
 	})
 }
+
+func TestNewContentsBundleImagesLock(t *testing.T) {
+	imagesLockYAML := `---
+apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: my.registry.io/image1:v1
+  annotations:
+    kbld.carvel.dev/id: image1
+`
+	assets := &helpers.Assets{T: t}
+	defer assets.CleanCreatedFolders()
+	bundleBuilder := helpers.NewBundleDir(t, assets)
+	bundleDir := bundleBuilder.CreateBundleDir(helpers.BundleYAML, imagesLockYAML)
+	imgTag, err := name.NewTag("my.registry.io/new-bundle:tag")
+	require.NoError(t, err)
+
+	t.Run("fails when an image is referenced by tag", func(t *testing.T) {
+		subject := bundle.NewContents([]string{bundleDir}, nil, ctlimg.TarImageOpts{})
+		_, err := subject.Push(imgTag, ctlimg.FileImageOpts{}, &bundlefakes.FakeImagesMetadataWriter{}, util.NewNoopLevelLogger())
+		require.ErrorContains(t, err, "line 5: Expected image 'my.registry.io/image1:v1' to be referenced by digest")
+	})
+
+	t.Run("pushes the images lock with the digests of the tags when resolving them", func(t *testing.T) {
+		fakeRegistry := &bundlefakes.FakeImagesMetadataWriter{}
+		fakeRegistry.DigestReturns(v1.Hash{Algorithm: "sha256", Hex: "703218c0465075f4425e58fac086e09e1de5c340b12976ab9eb8ad26615c3715"}, nil)
+		// the image is removed once pushed
+		var pushedLock string
+		fakeRegistry.WriteImageStub = func(_ name.Reference, img v1.Image, _ chan v1.Update) error {
+			pushedLock = imageFile(t, img, ".imgpkg/images.yml")
+			return nil
+		}
+
+		subject := bundle.NewContents([]string{bundleDir}, nil, ctlimg.TarImageOpts{}).WithResolveTags(true)
+		_, err := subject.Push(imgTag, ctlimg.FileImageOpts{}, fakeRegistry, util.NewNoopLevelLogger())
+		require.NoError(t, err)
+
+		require.Equal(t, 1, fakeRegistry.DigestCallCount())
+		assert.Equal(t, "my.registry.io/image1:v1", fakeRegistry.DigestArgsForCall(0).Name())
+
+		assert.Equal(t, `---
+apiVersion: imgpkg.carvel.dev/v1alpha1
+images:
+- annotations:
+    imgpkg.carvel.dev/resolved-tag: my.registry.io/image1:v1
+    kbld.carvel.dev/id: image1
+  image: my.registry.io/image1@sha256:703218c0465075f4425e58fac086e09e1de5c340b12976ab9eb8ad26615c3715
+kind: ImagesLock
+`, pushedLock)
+
+		lockOnDisk, err := os.ReadFile(filepath.Join(bundleDir, ".imgpkg", "images.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, imagesLockYAML, string(lockOnDisk))
+	})
+
+	t.Run("fails listing each image whose tag cannot be resolved", func(t *testing.T) {
+		lockDir := bundleBuilder.CreateBundleDir(helpers.BundleYAML, `---
+apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: my.registry.io/image1:v1
+- image: other.registry.io/image2:v2
+`)
+		fakeRegistry := &bundlefakes.FakeImagesMetadataWriter{}
+		fakeRegistry.DigestReturns(v1.Hash{}, fmt.Errorf("MANIFEST_UNKNOWN"))
+
+		subject := bundle.NewContents([]string{lockDir}, nil, ctlimg.TarImageOpts{}).WithResolveTags(true)
+		_, err := subject.Push(imgTag, ctlimg.FileImageOpts{}, fakeRegistry, util.NewNoopLevelLogger())
+		require.ErrorContains(t, err, "Resolving the tags of 2 image(s) of images lock")
+		require.ErrorContains(t, err, "- my.registry.io/image1:v1: MANIFEST_UNKNOWN\n- other.registry.io/image2:v2: MANIFEST_UNKNOWN")
+		assert.Equal(t, 0, fakeRegistry.WriteImageCallCount())
+	})
+}
+
+// imageFile returns the content of the file at path in the layer of img
+func imageFile(t *testing.T, img v1.Image, path string) string {
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+
+	reader, err := layers[0].Uncompressed()
+	require.NoError(t, err)
+	defer reader.Close()
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		require.NoError(t, err, "Expected image to have file '%s'", path)
+		if header.Name == path {
+			content, err := io.ReadAll(tarReader)
+			require.NoError(t, err)
+			return string(content)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package bundle
 
 import (
 	"fmt"
+	"strings"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/imageset"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
@@ -65,6 +66,10 @@ func (t *FetcherFromProcessedImages) Bundle(_ *util.Throttle, imgRef ImageRef) (
 		}
 	}
 	if img.DigestRef == "" {
+		// images filtered out of the copy are not bundles, bundles are always copied
+		if t.omitted(imgRef.Digest()) {
+			return imgRef.ImageRef, nil, nil
+		}
 		panic(fmt.Sprintf("Internal inconsistency: was not able to find '%s' in the list of procced images", imgRef.Image))
 	}
 	if img.ImageIndex != nil {
@@ -83,6 +88,20 @@ func (t *FetcherFromProcessedImages) Bundle(_ *util.Throttle, imgRef ImageRef) (
 	return imgRef.ImageRef, nil, nil
 }
 
+// omitted returns true when the image with digest was filtered out of the copy of the bundle, as recorded by
+// imageset.OmittedImagesLabelKey
+func (t *FetcherFromProcessedImages) omitted(digest string) bool {
+	for _, image := range t.processedImages {
+		for _, omittedRef := range strings.Split(image.Labels[imageset.OmittedImagesLabelKey], ",") {
+			omittedDigest, err := regname.NewDigest(omittedRef, regname.WeakValidation)
+			if err == nil && omittedDigest.DigestStr() == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type registryImagesRefCacheEntry struct {
 	bundle *Bundle
 	imgRef lockconfig.ImageRef
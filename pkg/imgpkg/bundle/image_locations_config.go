@@ -26,6 +26,9 @@ type ImageLocationsConfig struct {
 type ImageLocation struct {
 	Image    string `json:"image"`    // This generated yaml, but due to lib we need to use `json`
 	IsBundle bool   `json:"isBundle"` // This generated yaml, but due to lib we need to use `json`
+	// Omitted the image was filtered out of the copy with --include-image or --exclude-image, and is not in the
+	// repository of the bundle
+	Omitted bool `json:"omitted,omitempty"` // This generated yaml, but due to lib we need to use `json`
 }
 
 func NewLocationConfigFromPath(path string) (ImageLocationsConfig, error) {
@@ -123,15 +123,50 @@ func NewImageRefsFromImagesLock(imagesLock lockconfig.ImagesLock, imageRefsLocat
 	return imageRefs, nil
 }
 
+// LocalizeToRepo adds the location of the images in relativeToRepo, except for the images omitted when the bundle was
+// copied to it, which are not there
 func (i *ImageRefs) LocalizeToRepo(relativeToRepo string) {
 	i.refsLock.Lock()
 	defer i.refsLock.Unlock()
 
+	omitted := i.omittedImages()
 	for j, imgRef := range i.refs {
+		if omitted[imgRef.Image] {
+			continue
+		}
 		i.refs[j].AddLocation(replaceImageRepo(imgRef.Image, relativeToRepo))
 	}
 }
 
+// OmittedImages the images of the ImagesLock that were filtered out when the bundle was copied to its repository
+func (i *ImageRefs) OmittedImages() []string {
+	i.refsLock.Lock()
+	defer i.refsLock.Unlock()
+
+	omitted := i.omittedImages()
+	var images []string
+	for _, imgRef := range i.refs {
+		if omitted[imgRef.Image] {
+			images = append(images, imgRef.Image)
+		}
+	}
+	return images
+}
+
+// omittedImages the images recorded as omitted by the locations of the bundle
+func (i *ImageRefs) omittedImages() map[string]bool {
+	omitted := map[string]bool{}
+	if i.imageLocationsConfig == nil {
+		return omitted
+	}
+	for _, imgLoc := range i.imageLocationsConfig.Images {
+		if imgLoc.Omitted {
+			omitted[imgLoc.Image] = true
+		}
+	}
+	return omitted
+}
+
 func (i *ImageRefs) UpdateRelativeToRepo(imgRetriever ImagesMetadata, relativeToRepo string) (bool, error) {
 	if i.imageLocationsConfig != nil {
 		i.LocalizeToRepo(relativeToRepo)
@@ -208,6 +243,7 @@ func (i ImageRefs) ImagesLock() lockconfig.ImagesLock {
 		panic("Internal inconsistency: ImagesLock was not provided")
 	}
 
+	omitted := i.omittedImages()
 	imgLock := lockconfig.NewEmptyImagesLock()
 	for _, originalImg := range i.originalImagesLock.Images {
 		ref, found := i.Find(originalImg.Image)
@@ -215,9 +251,16 @@ func (i ImageRefs) ImagesLock() lockconfig.ImagesLock {
 			panic(fmt.Errorf("Internal inconsistency: '%s' could not be found", originalImg.Image))
 		}
 
+		annotations := originalImg.Annotations
+		if omitted[originalImg.Image] {
+			annotations = map[string]string{OmittedImageAnnotation: "true"}
+			for key, value := range originalImg.Annotations {
+				annotations[key] = value
+			}
+		}
 		imgLock.Images = append(imgLock.Images, lockconfig.ImageRef{
 			Image:       ref.PrimaryLocation(),
-			Annotations: originalImg.Annotations,
+			Annotations: annotations,
 		})
 	}
 
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -132,7 +133,7 @@ func (r LocationsConfigs) Save(reg ImagesMetadataWriter, bundleRef name.Digest,
 
 	r.ui.Tracef("Pushing image\n")
 
-	_, err = plainimage.NewContents([]string{tmpDir}, nil, false).Push(locRef, nil, reg.CloneWithLogger(util.NewNoopProgressBar()), logger)
+	_, err = plainimage.NewContents([]string{tmpDir}, nil, ctlimg.TarImageOpts{}).Push(locRef, ctlimg.FileImageOpts{}, reg.CloneWithLogger(util.NewNoopProgressBar()), logger)
 	if err != nil {
 		// Immutable tag errors within registries are not standardized.
 		// Assume word "immutable" would be present in most cases.
@@ -194,8 +195,8 @@ func (o *locationsSingleLayerReader) Read(img regv1.Image) (ImageLocationsConfig
 		return conf, err
 	}
 
-	if mediaType != types.DockerLayer {
-		return conf, fmt.Errorf("Expected layer to have docker layer media type, was %s", mediaType)
+	if mediaType != types.DockerLayer && mediaType != types.OCILayer {
+		return conf, fmt.Errorf("Expected layer to have docker or OCI layer media type, was %s", mediaType)
 	}
 
 	// here we know layer is .tgz so decompress and read tar headers
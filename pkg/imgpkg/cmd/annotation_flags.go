@@ -0,0 +1,54 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// AnnotationFlags annotations of the manifest, and of the layer, of the pushed image
+type AnnotationFlags struct {
+	Annotations      []string
+	LayerAnnotations []string
+}
+
+// Set sets the annotations flags
+func (a *AnnotationFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&a.Annotations, "annotation", nil, "Set annotation on the image manifest (format: key=value) (can be specified multiple times)")
+	cmd.Flags().StringArrayVar(&a.LayerAnnotations, "layer-annotation", nil, "Set annotation on the layer with the content of the image (format: key=value) (can be specified multiple times)")
+}
+
+// AsAnnotations returns the annotations of the manifest and of the layer, a key can only be provided once per flag
+func (a *AnnotationFlags) AsAnnotations() (map[string]string, map[string]string, error) {
+	annotations, err := parseAnnotations("--annotation", a.Annotations)
+	if err != nil {
+		return nil, nil, err
+	}
+	layerAnnotations, err := parseAnnotations("--layer-annotation", a.LayerAnnotations)
+	if err != nil {
+		return nil, nil, err
+	}
+	return annotations, layerAnnotations, nil
+}
+
+func parseAnnotations(flag string, values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	annotations := map[string]string{}
+	for _, value := range values {
+		key, annotation, found := strings.Cut(value, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("Expected %s '%s' to be in the format key=value", flag, value)
+		}
+		if _, present := annotations[key]; present {
+			return nil, fmt.Errorf("Expected %s '%s' to be provided only once", flag, key)
+		}
+		annotations[key] = annotation
+	}
+	return annotations, nil
+}
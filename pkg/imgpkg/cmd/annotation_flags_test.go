@@ -0,0 +1,55 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationFlagsAsAnnotations(t *testing.T) {
+	t.Run("parses the annotations of the manifest and of the layer", func(t *testing.T) {
+		flags := AnnotationFlags{Annotations: []string{"team=platform", "url=https://example.com/?a=b"}, LayerAnnotations: []string{"title=config"}}
+		annotations, layerAnnotations, err := flags.AsAnnotations()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"team": "platform", "url": "https://example.com/?a=b"}, annotations)
+		assert.Equal(t, map[string]string{"title": "config"}, layerAnnotations)
+	})
+
+	t.Run("the same key can be used for the manifest and the layer", func(t *testing.T) {
+		flags := AnnotationFlags{Annotations: []string{"title=bundle"}, LayerAnnotations: []string{"title=config"}}
+		_, _, err := flags.AsAnnotations()
+		require.NoError(t, err)
+	})
+
+	errorCases := []struct {
+		name          string
+		flags         AnnotationFlags
+		expectedError string
+	}{
+		{
+			name:          "duplicate --annotation",
+			flags:         AnnotationFlags{Annotations: []string{"team=a", "team=b"}},
+			expectedError: "Expected --annotation 'team' to be provided only once",
+		},
+		{
+			name:          "duplicate --layer-annotation",
+			flags:         AnnotationFlags{LayerAnnotations: []string{"title=a", "title=b"}},
+			expectedError: "Expected --layer-annotation 'title' to be provided only once",
+		},
+		{
+			name:          "missing value",
+			flags:         AnnotationFlags{Annotations: []string{"team"}},
+			expectedError: "Expected --annotation 'team' to be in the format key=value",
+		},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := tc.flags.AsAnnotations()
+			require.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
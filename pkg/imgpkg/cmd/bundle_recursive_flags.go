@@ -9,10 +9,12 @@ import (
 
 type BundleRecursiveFlags struct {
 	Recursive bool
+	MaxDepth  int
 }
 
 func (b *BundleRecursiveFlags) Set(cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(&b.Recursive, "recursive", "r", false, "Recursively iterate and fetch content of every bundle")
+	cmd.Flags().IntVar(&b.MaxDepth, "max-depth", 0, "Maximum levels of nested bundles fetched with --recursive (-r), 0 fetches every level")
 }
 
 func (b *BundleRecursiveFlags) SetCopy(cmd *cobra.Command) {
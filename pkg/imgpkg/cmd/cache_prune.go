@@ -0,0 +1,102 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"github.com/cppforlife/go-cli-ui/ui"
+	"github.com/spf13/cobra"
+)
+
+type CachePruneOptions struct {
+	ui ui.UI
+
+	CacheDir string
+	MaxSize  string
+}
+
+func NewCachePruneOptions(ui ui.UI) *CachePruneOptions {
+	return &CachePruneOptions{ui: ui}
+}
+
+func NewCachePruneCmd(o *CachePruneOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the least recently used layers from the cache",
+		RunE:  func(_ *cobra.Command, _ []string) error { return o.Run() },
+		Example: `
+  # Keep at most 10GB of layers in the cache
+  imgpkg cache prune --max-size 10GB
+
+  # Empty the cache
+  imgpkg cache prune --max-size 0`,
+	}
+	cmd.Flags().StringVar(&o.CacheDir, "cache-dir", "", "Set the directory of the cache of downloaded layers (default ~/.imgpkg/cache) ($IMGPKG_CACHE_DIR)")
+	cmd.Flags().StringVar(&o.MaxSize, "max-size", "", "Maximum size of the cache after pruning (format: 500MB, 10GB, 2GiB)")
+	return cmd
+}
+
+func (o *CachePruneOptions) Run() error {
+	if o.MaxSize == "" {
+		return fmt.Errorf("Expected --max-size to be provided")
+	}
+	maxSize, err := parseByteSize(o.MaxSize)
+	if err != nil {
+		return fmt.Errorf("Parsing --max-size: %s", err)
+	}
+
+	cacheDir := o.CacheDir
+	if cacheDir == "" {
+		cacheDir = os.Getenv("IMGPKG_CACHE_DIR")
+	}
+	if cacheDir == "" {
+		cacheDir = defaultCacheDir()
+	}
+	if cacheDir == "" {
+		return fmt.Errorf("Unable to find the cache directory (hint: Use --cache-dir to provide it)")
+	}
+
+	result, err := registry.NewBlobCache(cacheDir).Prune(maxSize)
+	if err != nil {
+		return fmt.Errorf("Pruning cache '%s': %s", cacheDir, err)
+	}
+
+	o.ui.BeginLinef("Removed %d layers (%d bytes) from cache '%s', %d bytes remain\n",
+		result.RemovedEntries, result.RemovedSize, cacheDir, result.Size)
+	return nil
+}
+
+// parseByteSize parses a size in bytes with an optional decimal (KB, MB, GB, TB) or binary (KiB, MiB, GiB, TiB) unit
+func parseByteSize(size string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+		{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+		{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"t", 1e12},
+		{"b", 1},
+	}
+
+	value := strings.ToLower(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("Expected size '%s' to be a positive number of bytes, optionally followed by a unit like MB or GiB", size)
+	}
+	return int64(number * float64(multiplier)), nil
+}
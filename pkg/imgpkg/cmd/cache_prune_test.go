@@ -0,0 +1,43 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for size, expected := range map[string]int64{
+		"0":       0,
+		"1024":    1024,
+		"500MB":   500 * 1e6,
+		"10GB":    10 * 1e9,
+		"10gb":    10 * 1e9,
+		"1.5G":    1.5 * 1e9,
+		"2GiB":    2 << 30,
+		"64 KiB":  64 << 10,
+		"100B":    100,
+		"1TB":     1e12,
+		" 3 mib ": 3 << 20,
+	} {
+		result, err := parseByteSize(size)
+		require.NoError(t, err, size)
+		assert.Equal(t, expected, result, size)
+	}
+
+	for _, size := range []string{"", "GB", "-1GB", "10XB", "ten"} {
+		_, err := parseByteSize(size)
+		require.ErrorContains(t, err, "to be a positive number of bytes", size)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	t.Run("when --max-size is not provided it fails", func(t *testing.T) {
+		err := NewCachePruneOptions(nil).Run()
+		require.ErrorContains(t, err, "Expected --max-size to be provided")
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ConfigFlags environment variables, labels and working directory of the config of the pushed image, for the tools
+// processing its content, the image not being runnable
+type ConfigFlags struct {
+	Env        []string
+	ConfigFile string
+}
+
+// configFile content of --config-file, the fields of the config of an image that push sets, named as in the config
+type configFile struct {
+	Env        []string          `json:"Env"`
+	Labels     map[string]string `json:"Labels"`
+	WorkingDir string            `json:"WorkingDir"`
+}
+
+// Set sets the config flags
+func (c *ConfigFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&c.Env, "env", nil, "Set environment variable in the image config, for the tools processing its content "+
+		"(format: KEY=VALUE) (can be specified multiple times)")
+	cmd.Flags().StringVar(&c.ConfigFile, "config-file", "", "JSON file with the Env, Labels and WorkingDir of the image config, merged with --env and --label "+
+		"(format: {\"Env\": [\"KEY=VALUE\"], \"Labels\": {\"key\": \"value\"}, \"WorkingDir\": \"/dir\"})")
+}
+
+// AsConfig returns the environment variables of --env and --config-file, labels with the labels of --config-file,
+// and the working directory of --config-file. A key can only be provided once
+func (c *ConfigFlags) AsConfig(labels map[string]string) (map[string]string, map[string]string, string, error) {
+	file, err := c.readConfigFile()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var env map[string]string
+	for _, entry := range append(append([]string{}, c.Env...), file.Env...) {
+		key, value, found := strings.Cut(entry, "=")
+		if !found || key == "" {
+			return nil, nil, "", fmt.Errorf("Expected environment variable '%s' to be in the format KEY=VALUE", entry)
+		}
+		if _, present := env[key]; present {
+			return nil, nil, "", fmt.Errorf("Expected environment variable '%s' to be provided only once", key)
+		}
+		if env == nil {
+			env = map[string]string{}
+		}
+		env[key] = value
+	}
+
+	if len(file.Labels) > 0 {
+		mergedLabels := map[string]string{}
+		for key, value := range labels {
+			mergedLabels[key] = value
+		}
+		for key, value := range file.Labels {
+			if _, present := mergedLabels[key]; present {
+				return nil, nil, "", fmt.Errorf("Expected label '%s' to be provided only once", key)
+			}
+			mergedLabels[key] = value
+		}
+		labels = mergedLabels
+	}
+
+	if file.WorkingDir != "" && !path.IsAbs(file.WorkingDir) {
+		return nil, nil, "", fmt.Errorf("Expected WorkingDir '%s' of --config-file to be an absolute path", file.WorkingDir)
+	}
+	return env, labels, file.WorkingDir, nil
+}
+
+// readConfigFile returns the content of --config-file, empty when it is not provided
+func (c *ConfigFlags) readConfigFile() (configFile, error) {
+	if c.ConfigFile == "" {
+		return configFile{}, nil
+	}
+
+	content, err := os.ReadFile(c.ConfigFile)
+	if err != nil {
+		return configFile{}, fmt.Errorf("Reading --config-file: %s", err)
+	}
+
+	var file configFile
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// the fields that make an image runnable, such as Entrypoint, are not set by push
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&file)
+	if err != nil {
+		return configFile{}, fmt.Errorf("Expected --config-file '%s' to be a JSON object with only Env, Labels and WorkingDir: %s", c.ConfigFile, err)
+	}
+	return file, nil
+}
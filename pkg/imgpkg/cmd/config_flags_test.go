@@ -0,0 +1,88 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFlagsAsConfig(t *testing.T) {
+	writeConfigFile := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "image-config.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	t.Run("merges --env and --config-file with the labels", func(t *testing.T) {
+		flags := ConfigFlags{Env: []string{"CONTENT_TYPE=helm-chart", "EMPTY=", "QUERY=a=b"},
+			ConfigFile: writeConfigFile(t, `{"Env": ["CHART=app"], "Labels": {"team": "platform"}, "WorkingDir": "/charts"}`)}
+		env, labels, workingDir, err := flags.AsConfig(map[string]string{"git-sha": "abc123"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"CONTENT_TYPE": "helm-chart", "EMPTY": "", "QUERY": "a=b", "CHART": "app"}, env)
+		assert.Equal(t, map[string]string{"git-sha": "abc123", "team": "platform"}, labels)
+		assert.Equal(t, "/charts", workingDir)
+	})
+
+	t.Run("returns the labels when nothing is provided", func(t *testing.T) {
+		env, labels, workingDir, err := (&ConfigFlags{}).AsConfig(map[string]string{"git-sha": "abc123"})
+		require.NoError(t, err)
+		assert.Nil(t, env)
+		assert.Equal(t, map[string]string{"git-sha": "abc123"}, labels)
+		assert.Equal(t, "", workingDir)
+	})
+
+	errorCases := []struct {
+		name          string
+		flags         ConfigFlags
+		configFile    string
+		expectedError string
+	}{
+		{
+			name:          "duplicate --env",
+			flags:         ConfigFlags{Env: []string{"CHART=a", "CHART=b"}},
+			expectedError: "Expected environment variable 'CHART' to be provided only once",
+		},
+		{
+			name:          "variable provided by --env and --config-file",
+			flags:         ConfigFlags{Env: []string{"CHART=a"}},
+			configFile:    `{"Env": ["CHART=b"]}`,
+			expectedError: "Expected environment variable 'CHART' to be provided only once",
+		},
+		{
+			name:          "missing value",
+			flags:         ConfigFlags{Env: []string{"CHART"}},
+			expectedError: "Expected environment variable 'CHART' to be in the format KEY=VALUE",
+		},
+		{
+			name:          "label provided by --label and --config-file",
+			configFile:    `{"Labels": {"git-sha": "def456"}}`,
+			expectedError: "Expected label 'git-sha' to be provided only once",
+		},
+		{
+			name:          "relative working directory",
+			configFile:    `{"WorkingDir": "charts"}`,
+			expectedError: "Expected WorkingDir 'charts' of --config-file to be an absolute path",
+		},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.configFile != "" {
+				tc.flags.ConfigFile = writeConfigFile(t, tc.configFile)
+			}
+			_, _, _, err := tc.flags.AsConfig(map[string]string{"git-sha": "abc123"})
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+
+	t.Run("fails on the fields push does not set", func(t *testing.T) {
+		path := writeConfigFile(t, `{"Entrypoint": ["/bin/app"]}`)
+		_, _, _, err := (&ConfigFlags{ConfigFile: path}).AsConfig(nil)
+		require.ErrorContains(t, err, "Expected --config-file '"+path+"' to be a JSON object with only Env, Labels and WorkingDir: json: unknown field \"Entrypoint\"")
+	})
+}
@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
 	ctlimgset "carvel.dev/imgpkg/pkg/imgpkg/imageset"
@@ -14,27 +15,48 @@ import (
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
 	"carvel.dev/imgpkg/pkg/imgpkg/signature"
 	"github.com/cppforlife/go-cli-ui/ui"
+	regname "github.com/google/go-containerregistry/pkg/name"
 	"github.com/spf13/cobra"
 )
 
-const rootBundleLabelKey string = "dev.carvel.imgpkg.copy.root-bundle"
+const rootBundleLabelKey = ctlimgset.RootBundleLabelKey
 
 type CopyOptions struct {
 	ui ui.UI
+	// events stream of --progress-format json-lines, nil otherwise
+	events *util.EventStream
 
 	ImageFlags      ImageFlags
 	BundleFlags     BundleFlags
 	LockInputFlags  LockInputFlags
 	LockOutputFlags LockOutputFlags
+	DigestFileFlags DigestFileFlags
 	TarFlags        TarFlags
 	RegistryFlags   RegistryFlags
 	SignatureFlags  SignatureFlags
 
-	RepoDst string
+	ProgressFormatFlags ProgressFormatFlags
+	// ImageFilterFlags select the images of the bundle, or of the images lock, that are copied
+	ImageFilterFlags ImageFilterFlags
+
+	RepoDst      string
+	OCILayoutSrc string
+	OCILayoutDst string
+	// OCILayoutRef selects the image copied from --from-oci-layout when the layout has several
+	OCILayoutRef string
 
 	Concurrency             int
 	IncludeNonDistributable bool
 	UseRepoBasedTags        bool
+	// Force uploads every blob and manifest, even those --to-repo already has
+	Force bool
+	// MountFrom repository of the registry of --to-repo the blobs missing in --to-repo are mounted from
+	MountFrom string
+	// PreserveTags tags the images copied to --to-repo with a tag derived from the tag they were resolved from
+	PreserveTags bool
+
+	// transfer blobs uploaded, mounted and skipped while copying to --to-repo
+	transfer *transferTrackingWriter
 }
 
 // NewCopyOptions constructor for building a CopyOptions, holding values derived via flags
@@ -54,6 +76,19 @@ func NewCopyCmd(o *CopyOptions) *cobra.Command {
     # Copy bundle dkalinin/app1-bundle to another registry (or repository)
     imgpkg copy -b dkalinin/app1-bundle --to-repo internal-registry/app1-bundle
 
+    # Copy bundle dkalinin/app1-bundle to another registry and write its reference there, internal-registry/app1-bundle@sha256:<hex>, to app1-bundle.digest
+    imgpkg copy -b dkalinin/app1-bundle --to-repo internal-registry/app1-bundle --digest-file app1-bundle.digest
+
+    # Copy the bundle written by push --to-oci-layout in out/ to the registry
+    imgpkg copy --from-oci-layout out/ --to-repo internal-registry/app1-bundle
+
+    # Copy bundle dkalinin/app1-bundle, with all its images, to an OCI image layout in app1-bundle/, and from there to another registry
+    imgpkg copy -b dkalinin/app1-bundle --to-oci-layout app1-bundle/
+    imgpkg copy --from-oci-layout app1-bundle/ --to-repo internal-registry/app1-bundle
+
+    # Copy one of the images of an OCI image layout written by another tool to the registry
+    imgpkg copy --from-oci-layout out/ --oci-layout-ref app1:latest --to-repo internal-registry/app1-image
+
     # Copy image dkalinin/app1-image to another registry (or repository)
     # ##########################################################################
     # NOTE: if not using ~/.docker.config for authn, use env vars as described  #
@@ -61,6 +96,15 @@ func NewCopyCmd(o *CopyOptions) *cobra.Command {
     # ##########################################################################
     imgpkg copy -i dkalinin/app1-image --to-repo internal-registry/app1-image
 
+    # Copy a new version of bundle dkalinin/app1-bundle, mounting the blobs it shares with the version copied to internal-registry/app1-bundle-v1
+    imgpkg copy -b dkalinin/app1-bundle:v2 --to-repo internal-registry/app1-bundle-v2 --mount-from internal-registry/app1-bundle-v1
+
+    # Copy bundle dkalinin/app1-bundle to another registry, also tagging its images resolved from tags, such as postgres:14.9, with tags like postgres-14.9
+    imgpkg copy -b dkalinin/app1-bundle --to-repo internal-registry/app1-bundle --preserve-tags --lock-output app1-bundle.lock.yml
+
+    # Copy bundle dkalinin/app1-bundle to another registry with only its postgres images, the images omitted being recorded in app1-bundle.lock.yml
+    imgpkg copy -b dkalinin/app1-bundle --to-repo internal-registry/app1-bundle --include-image '**/postgres' --lock-output app1-bundle.lock.yml
+
     # Copy using image --repo-based-tags flag
     imgpkg copy -i registry.foo.bar/some/application/app \
                 --to-repo other-reg.faz.baz/my-app --repo-based-tags
@@ -75,28 +119,90 @@ func NewCopyCmd(o *CopyOptions) *cobra.Command {
 	o.BundleFlags.SetCopy(cmd)
 	o.LockInputFlags.Set(cmd)
 	o.LockOutputFlags.SetOnCopy(cmd)
+	o.DigestFileFlags.SetOnCopy(cmd)
 	o.TarFlags.Set(cmd)
 	o.RegistryFlags.Set(cmd)
+	o.RegistryFlags.SetBandwidthLimit(cmd)
 	o.SignatureFlags.Set(cmd)
+	o.ImageFilterFlags.Set(cmd)
+	o.ProgressFormatFlags.Set(cmd)
 	cmd.Flags().StringVar(&o.RepoDst, "to-repo", "", "Location to upload assets")
-	cmd.Flags().IntVar(&o.Concurrency, "concurrency", 5, "Concurrency")
+	cmd.Flags().StringVar(&o.OCILayoutSrc, "from-oci-layout", "", "OCI image layout, such as the one written by push, or copy, --to-oci-layout, with the images to upload")
+	cmd.Flags().StringVar(&o.OCILayoutRef, "oci-layout-ref", "", "Image, or bundle, of --from-oci-layout to copy, as name@digest, name or digest, "+
+		"required when the layout has several images and no single bundle")
+	cmd.Flags().StringVar(&o.OCILayoutDst, "to-oci-layout", "", "Directory where the images are written as an OCI image layout, keeping their digests")
+	cmd.Flags().IntVar(&o.Concurrency, "concurrency", 5, "Number of blobs transferred at once, across all the images copied (manifests are read, and checked for, at least 10 images at once)")
 	cmd.Flags().BoolVar(&o.IncludeNonDistributable, "include-non-distributable-layers", false,
 		"Include non-distributable layers when copying an image/bundle")
 	cmd.Flags().BoolVar(&o.UseRepoBasedTags, "repo-based-tags", false,
 		"Allow imgpkg to use repository-based tags for convenience")
+	cmd.Flags().BoolVar(&o.Force, "force", false, "Upload every blob and manifest, even those --to-repo already has, without mounting blobs from other repositories, "+
+		"to verify the copy end to end")
+	cmd.Flags().StringVar(&o.MountFrom, "mount-from", "", "Repository of the registry of --to-repo the blobs missing in --to-repo are mounted from, "+
+		"instead of being uploaded, such as the repository an earlier version of the bundle was copied to")
+	cmd.Flags().BoolVar(&o.PreserveTags, "preserve-tags", false, "Also tag the images copied to --to-repo that were resolved from a tag, such as postgres:14.9, "+
+		"with a tag derived from it, such as postgres-14.9, suffixed with -2, -3... when it already points to another image, recording the tags in --lock-output")
 	return cmd
 }
 
-func (c *CopyOptions) Run() error {
+func (c *CopyOptions) Run() (err error) {
+	c.events, err = c.ProgressFormatFlags.EventStream("copy")
+	if err != nil {
+		return err
+	}
+	// processedImages images copied to --to-repo, once they are
+	var processedImages *ctlimgset.ProcessedImages
+	defer func() {
+		c.finishEvents(processedImages, err)
+	}()
+
 	if !c.hasOneSrc() {
-		return fmt.Errorf("Expected either --lock, --bundle (-b), --image (-i), or --tar as a source")
+		return fmt.Errorf("Expected either --lock, --bundle (-b), --image (-i), --tar, or --from-oci-layout as a source")
 	}
 	if !c.hasOneDst() {
-		return fmt.Errorf("Expected either --to-tar or --to-repo")
+		return fmt.Errorf("Expected either --to-tar, --to-oci-layout or --to-repo")
+	}
+	if c.OCILayoutRef != "" && c.OCILayoutSrc == "" {
+		return fmt.Errorf("Cannot use --oci-layout-ref without --from-oci-layout")
+	}
+	if c.ImageFilterFlags.IsSet() {
+		if c.ImageFlags.Image != "" || c.TarFlags.IsSrc() || c.OCILayoutSrc != "" {
+			return fmt.Errorf("Cannot use --include-image or --exclude-image with --image (-i), --tar or --from-oci-layout, they filter the images of a bundle or of an images lock")
+		}
+		if _, err := c.ImageFilterFlags.Filter(); err != nil {
+			return err
+		}
+	}
+	if _, err := c.LockOutputFlags.LockFormat(); err != nil {
+		return err
+	}
+	if c.LockOutputFlags.LockFilePath == stdoutOutputPath && c.DigestFileFlags.DigestFilePath == stdoutOutputPath {
+		return fmt.Errorf("Expected only one of --lock-output or --digest-file to be -")
+	}
+	attachedScope, err := c.SignatureFlags.AttachedScope()
+	if err != nil {
+		return err
 	}
 
+	mountFrom, err := c.mountFromRepo()
+	if err != nil {
+		return err
+	}
+
+	c.RegistryFlags.events = c.events
+	err = c.RegistryFlags.limitBandwidth()
+	if err != nil {
+		return err
+	}
 	registryOpts := c.RegistryFlags.AsRegistryOpts()
 	registryOpts.IncludeNonDistributableLayers = c.IncludeNonDistributable
+	registryOpts.ForceUpload = c.Force
+	registryOpts.OnBlobMounted = func(digest string) {
+		// the registry is created before the writer tracking the copy
+		if c.transfer != nil {
+			c.transfer.blobMounted(digest)
+		}
+	}
 
 	reg, err := registry.NewSimpleRegistry(registryOpts)
 	if err != nil {
@@ -106,6 +212,9 @@ func (c *CopyOptions) Run() error {
 	prefixedLogger := util.NewPrefixedLogger("copy | ", util.NewLogger(c.ui))
 	levelLogger := util.NewUILevelLogger(util.LogWarn, prefixedLogger)
 	imagesUploaderLogger := util.NewProgressBar(levelLogger, "done uploading images", "Error uploading images")
+	if c.events != nil {
+		imagesUploaderLogger = c.events.NewProgress("", "", time.Second)
+	}
 
 	var tagGen util.TagGenerator
 	tagGen = util.DefaultTagGenerator{}
@@ -113,13 +222,17 @@ func (c *CopyOptions) Run() error {
 		tagGen = util.RepoBasedTagGenerator{}
 	}
 
+	c.transfer = newTransferTrackingWriter(registry.NewRegistryWithProgress(reg, imagesUploaderLogger), mountFrom, c.IncludeNonDistributable)
 	imageSet := ctlimgset.NewImageSet(c.Concurrency, prefixedLogger, tagGen)
 	tarImageSet := ctlimgset.NewTarImageSet(imageSet, c.Concurrency, prefixedLogger)
 
 	var signatureRetriever SignatureRetriever
-	if c.SignatureFlags.CopyCosignSignatures {
+	switch {
+	case attachedScope.Any():
+		signatureRetriever = signature.NewAttached(reg, attachedScope, c.Concurrency)
+	case c.SignatureFlags.CopyCosignSignatures:
 		signatureRetriever = signature.NewSignatures(signature.NewCosign(reg), c.Concurrency)
-	} else {
+	default:
 		signatureRetriever = signature.NewNoop()
 	}
 
@@ -128,11 +241,16 @@ func (c *CopyOptions) Run() error {
 		BundleFlags:             c.BundleFlags,
 		LockInputFlags:          c.LockInputFlags,
 		TarFlags:                c.TarFlags,
+		ImageFilterFlags:        c.ImageFilterFlags,
+		OCILayoutSrc:            c.OCILayoutSrc,
+		OCILayoutRef:            c.OCILayoutRef,
 		IncludeNonDistributable: c.IncludeNonDistributable,
 		Concurrency:             c.Concurrency,
+		PreserveTags:            c.PreserveTags,
 
 		logger:             levelLogger,
-		registry:           registry.NewRegistryWithProgress(reg, imagesUploaderLogger),
+		ctx:                c.RegistryFlags.context(),
+		registry:           c.transfer,
 		imageSet:           imageSet,
 		tarImageSet:        tarImageSet,
 		signatureRetriever: signatureRetriever,
@@ -143,21 +261,72 @@ func (c *CopyOptions) Run() error {
 		if c.TarFlags.IsSrc() {
 			return fmt.Errorf("Cannot use tar source (--tar) with tar destination (--to-tar)")
 		}
+		if c.OCILayoutSrc != "" {
+			return fmt.Errorf("Cannot use --from-oci-layout with tar destination (--to-tar)")
+		}
 		if c.LockOutputFlags.LockFilePath != "" {
 			return fmt.Errorf("Cannot output lock file with tar destination")
 		}
+		if c.DigestFileFlags.DigestFilePath != "" {
+			return fmt.Errorf("Cannot use --digest-file with tar destination (--to-tar)")
+		}
+		if c.Force || c.MountFrom != "" {
+			return fmt.Errorf("Cannot use --force or --mount-from with tar destination (--to-tar)")
+		}
+		if c.PreserveTags {
+			return fmt.Errorf("Cannot use --preserve-tags with tar destination (--to-tar), the original tags are kept in the tar for the copy to a repository")
+		}
+		c.events.Phase("write-tar", c.TarFlags.TarDst)
 		return repoSrc.CopyToTar(c.TarFlags.TarDst, c.TarFlags.Resume)
 
+	case c.isOCILayoutDst():
+		if c.TarFlags.IsSrc() || c.OCILayoutSrc != "" {
+			return fmt.Errorf("Cannot use --tar or --from-oci-layout with OCI image layout destination (--to-oci-layout)")
+		}
+		if c.TarFlags.Resume {
+			return fmt.Errorf("Flag --resume can only be used when copying to tar")
+		}
+		if c.LockOutputFlags.LockFilePath != "" {
+			return fmt.Errorf("Cannot output lock file with OCI image layout destination (--to-oci-layout)")
+		}
+		if c.DigestFileFlags.DigestFilePath != "" {
+			return fmt.Errorf("Cannot use --digest-file with OCI image layout destination (--to-oci-layout)")
+		}
+		if c.Force || c.MountFrom != "" {
+			return fmt.Errorf("Cannot use --force or --mount-from with OCI image layout destination (--to-oci-layout)")
+		}
+		if c.PreserveTags {
+			return fmt.Errorf("Cannot use --preserve-tags with OCI image layout destination (--to-oci-layout), the original tags are kept in the layout for the copy to a repository")
+		}
+		c.events.Phase("write-oci-layout", c.OCILayoutDst)
+		return repoSrc.CopyToOCILayout(c.OCILayoutDst)
+
 	case c.isRepoDst():
 		if c.TarFlags.Resume {
 			return fmt.Errorf("Flag --resume can only be used when copying to tar")
 		}
 
-		processedImages, err := repoSrc.CopyToRepo(c.RepoDst)
+		if c.DigestFileFlags.DigestFilePath != "" && c.LockInputFlags.LockFilePath != "" {
+			_, imagesLock, err := lockconfig.NewLockFromPath(c.LockInputFlags.LockFilePath)
+			if err != nil {
+				return err
+			}
+			if imagesLock != nil {
+				return fmt.Errorf("Cannot use --digest-file with an images lock (--lock), there is no single image copied")
+			}
+		}
+
+		c.events.Phase("upload", c.RepoDst)
+		processedImages, err = repoSrc.CopyToRepo(c.RepoDst)
 		if err != nil {
 			return err
 		}
-		return c.writeLockOutput(processedImages, reg)
+		util.NewLoggerNoTTY(c.ui).Logf("%s\n", c.transfer.stats)
+		err = c.writeLockOutput(processedImages, reg)
+		if err != nil {
+			return err
+		}
+		return c.writeDigestFile(processedImages)
 
 	default:
 		panic("Unreachable")
@@ -188,7 +357,7 @@ func (c *CopyOptions) writeLockOutput(processedImages *ctlimgset.ProcessedImages
 			panic(fmt.Errorf("Internal inconsistency: '%s' should be a bundle but it is not", processedImageRootBundle.DigestRef))
 		}
 
-		return c.writeBundleLockOutput(foundBundle)
+		return c.writeBundleLockOutput(foundBundle, processedImages)
 	}
 
 	// if the tarball was created with an older version (prior to assign a label to the root bundle) and it contains a bundle
@@ -201,6 +370,73 @@ func (c *CopyOptions) writeLockOutput(processedImages *ctlimgset.ProcessedImages
 	return c.writeImagesLockOutput(processedImages)
 }
 
+// writeDigestFile writes the reference of the bundle, or of the image provided with -i, in the destination repository
+// to --digest-file
+func (c *CopyOptions) writeDigestFile(processedImages *ctlimgset.ProcessedImages) error {
+	if c.DigestFileFlags.DigestFilePath == "" {
+		return nil
+	}
+
+	copiedRef, err := c.copiedRef(processedImages)
+	if err != nil {
+		return err
+	}
+	if copiedRef == "" {
+		return fmt.Errorf("Expected a bundle to have been copied to write --digest-file, but only images were (hint: Use --lock-output to record the images copied)")
+	}
+	return c.DigestFileFlags.WriteDigest(copiedRef)
+}
+
+// copiedRef returns the reference of the bundle, or of the image provided with -i, in the destination repository,
+// empty when only the images of a lock, or of a tar without a bundle, were copied
+func (c *CopyOptions) copiedRef(processedImages *ctlimgset.ProcessedImages) (string, error) {
+	if rootBundle := c.findProcessedImageRootBundle(processedImages); rootBundle != nil {
+		return rootBundle.DigestRef, nil
+	}
+
+	if c.ImageFlags.Image != "" {
+		ref, err := regname.ParseReference(c.ImageFlags.Image, regname.WeakValidation)
+		if err != nil {
+			return "", err
+		}
+		tag := ""
+		if tagRef, ok := ref.(regname.Tag); ok {
+			tag = tagRef.TagStr()
+		}
+		// the signatures copied with the image are always tagged, with tags that cannot be the one of the image, the
+		// attached artifacts found with the referrers API are not tagged
+		for _, processedImage := range processedImages.All() {
+			if _, attached := processedImage.Labels[ctlimgset.AttachedKindLabelKey]; attached {
+				continue
+			}
+			if processedImage.UnprocessedImageRef.Tag == tag {
+				return processedImage.DigestRef, nil
+			}
+		}
+		panic(fmt.Errorf("Internal inconsistency: '%s' should have been copied", c.ImageFlags.Image))
+	}
+
+	return "", nil
+}
+
+// finishEvents writes the terminal event of --progress-format json-lines, with the reference of the bundle, or of the
+// image provided with -i, and the number of images copied to --to-repo. What was transferred under --bandwidth-limit
+// is reported either way
+func (c *CopyOptions) finishEvents(processedImages *ctlimgset.ProcessedImages, err error) {
+	if err != nil || processedImages == nil {
+		c.events.Finish("", c.RegistryFlags.bandwidthStats(util.EventStats{}, err), err)
+		return
+	}
+	if c.events == nil {
+		c.RegistryFlags.bandwidthStats(util.EventStats{}, nil)
+		return
+	}
+
+	// the reference of -i was parsed to copy the image, parsing it again cannot fail
+	copiedRef, _ := c.copiedRef(processedImages)
+	c.events.Finish(copiedRef, c.RegistryFlags.bandwidthStats(c.transfer.stats.eventStats(len(processedImages.All())), nil), nil)
+}
+
 func (c *CopyOptions) findProcessedImageRootBundle(processedImages *ctlimgset.ProcessedImages) *ctlimgset.ProcessedImage {
 	var bundleProcessedImage *ctlimgset.ProcessedImage
 
@@ -242,18 +478,52 @@ func (c *CopyOptions) informUserIfTarballNeedsToBeRecreated(processedImages *ctl
 	return nil
 }
 
+// mountFromRepo the repository of --mount-from, nil when it is not provided. Registries only mount blobs from their
+// own repositories, so it has to be in the registry of --to-repo
+func (c *CopyOptions) mountFromRepo() (*regname.Repository, error) {
+	if c.MountFrom == "" {
+		return nil, nil
+	}
+	if c.Force {
+		return nil, fmt.Errorf("Cannot use --mount-from with --force, every blob is uploaded")
+	}
+
+	repo, err := regname.NewRepository(c.MountFrom, regname.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing --mount-from '%s': %s", c.MountFrom, err)
+	}
+	if !c.isRepoDst() {
+		// the lack of --to-repo is reported with the other flags of the destination
+		return &repo, nil
+	}
+	dest, err := regname.NewRepository(c.RepoDst, regname.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing --to-repo '%s': %s", c.RepoDst, err)
+	}
+	if repo.RegistryStr() != dest.RegistryStr() {
+		return nil, fmt.Errorf("Expected --mount-from '%s' to be in the registry '%s' of --to-repo", c.MountFrom, dest.RegistryStr())
+	}
+	return &repo, nil
+}
+
 func (c *CopyOptions) isRepoDst() bool { return c.RepoDst != "" }
 
+func (c *CopyOptions) isOCILayoutDst() bool { return c.OCILayoutDst != "" }
+
 func (c *CopyOptions) hasOneDst() bool {
-	repoSet := c.isRepoDst()
-	tarSet := c.TarFlags.IsDst()
-	return (repoSet || tarSet) && !(repoSet && tarSet)
+	var dsts int
+	for _, set := range []bool{c.isRepoDst(), c.TarFlags.IsDst(), c.isOCILayoutDst()} {
+		if set {
+			dsts++
+		}
+	}
+	return dsts == 1
 }
 
 func (c *CopyOptions) hasOneSrc() bool {
 	var seen bool
 	for _, ref := range []string{c.LockInputFlags.LockFilePath, c.TarFlags.TarSrc,
-		c.BundleFlags.Bundle, c.ImageFlags.Image} {
+		c.BundleFlags.Bundle, c.ImageFlags.Image, c.OCILayoutSrc} {
 		if ref != "" {
 			if seen {
 				return false
@@ -278,35 +548,57 @@ func (c *CopyOptions) writeImagesLockOutput(processedImages *ctlimgset.Processed
 		if err != nil {
 			return err
 		}
+		// the flags were validated before copying
+		filter, _ := c.ImageFilterFlags.Filter()
 		for i, image := range imagesLock.Images {
+			// the images omitted keep their original location
+			if !filter.Includes(image.Image) {
+				if image.Annotations == nil {
+					imagesLock.Images[i].Annotations = map[string]string{}
+				}
+				imagesLock.Images[i].Annotations[bundle.OmittedImageAnnotation] = "true"
+				continue
+			}
 			img, found := processedImages.FindByURL(ctlimgset.UnprocessedImageRef{DigestRef: image.Image})
 			if !found {
 				return fmt.Errorf("Expected image '%s' to have been copied but was not", image.Image)
 			}
 			imagesLock.Images[i].Image = img.DigestRef
+			imagesLock.Images[i].Annotations = preservedTagAnnotations(image.Annotations, img)
 		}
 	} else {
 		for _, img := range processedImages.All() {
 			imagesLock.Images = append(imagesLock.Images, lockconfig.ImageRef{
-				Image: img.DigestRef,
+				Image:       img.DigestRef,
+				Annotations: preservedTagAnnotations(nil, img),
 			})
 		}
 	}
 
-	return imagesLock.WriteToPath(c.LockOutputFlags.LockFilePath)
+	return c.LockOutputFlags.WriteLock(imagesLock)
 }
 
-func (c *CopyOptions) writeBundleLockOutput(bundle *bundle.Bundle) error {
+func (c *CopyOptions) writeBundleLockOutput(bundle *bundle.Bundle, processedImages *ctlimgset.ProcessedImages) error {
+	// the annotations set when the bundle was pushed are kept by the copy
+	annotations, err := bundle.Annotations()
+	if err != nil {
+		return err
+	}
+
 	bundleLock := lockconfig.BundleLock{
 		LockVersion: lockconfig.LockVersion{
 			APIVersion: lockconfig.BundleLockAPIVersion,
 			Kind:       lockconfig.BundleLockKind,
 		},
 		Bundle: lockconfig.BundleRef{
-			Image: bundle.DigestRef(),
-			Tag:   bundle.Tag(),
+			Image:       bundle.DigestRef(),
+			Tag:         bundle.Tag(),
+			Annotations: annotations,
+			// the tags given to the images by --preserve-tags
+			PreservedTags: preservedTagRefs(processedImages),
+			OmittedImages: omittedImages(processedImages),
 		},
 	}
 
-	return bundleLock.WriteToPath(c.LockOutputFlags.LockFilePath)
+	return c.LockOutputFlags.WriteLock(bundleLock)
 }
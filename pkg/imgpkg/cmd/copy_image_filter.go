@@ -0,0 +1,71 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ctlbundle "carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimgset "carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+// filterBundleImages returns the images of the bundle, and of its nested bundles, selected by --include-image and
+// --exclude-image, with the labels of the root bundle, which record the images filtered out. Bundles are always
+// selected, they are needed to pull the bundle
+func (c CopyRepoSrc) filterBundleImages(imagesRef ctlbundle.ImageRefs) ([]ctlbundle.ImageRef, map[string]string, error) {
+	filter, err := c.ImageFilterFlags.Filter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var selected []ctlbundle.ImageRef
+	var omitted []string
+	for _, img := range imagesRef.ImageRefs() {
+		if img.ImageType == ctlbundle.BundleImage || filter.Includes(img.Image) {
+			selected = append(selected, img)
+			continue
+		}
+		omitted = append(omitted, img.Image)
+	}
+
+	labels := map[string]string{rootBundleLabelKey: ""}
+	if len(omitted) > 0 {
+		sort.Strings(omitted)
+		for _, image := range omitted {
+			c.logger.Logf("Omitting image '%s' filtered out by --include-image or --exclude-image\n", image)
+		}
+		labels[ctlimgset.OmittedImagesLabelKey] = strings.Join(omitted, ",")
+	}
+	return selected, labels, nil
+}
+
+// omittedImages the images of the bundle copied, and of its nested bundles, that were filtered out of the copy, as
+// recorded on the root bundle
+func omittedImages(processedImages *ctlimgset.ProcessedImages) []string {
+	for _, processedImage := range processedImages.All() {
+		if _, ok := processedImage.Labels[rootBundleLabelKey]; !ok {
+			continue
+		}
+		if omitted := processedImage.Labels[ctlimgset.OmittedImagesLabelKey]; omitted != "" {
+			return strings.Split(omitted, ",")
+		}
+	}
+	return nil
+}
+
+// omittedDigests the digests of omittedImages
+func omittedDigests(processedImages *ctlimgset.ProcessedImages) (map[string]bool, error) {
+	digests := map[string]bool{}
+	for _, image := range omittedImages(processedImages) {
+		digest, err := regname.NewDigest(image, regname.WeakValidation)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing image '%s' omitted from the copy: %s", image, err)
+		}
+		digests[digest.DigestStr()] = true
+	}
+	return digests, nil
+}
@@ -0,0 +1,351 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ctlbundle "carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/imagedesc"
+	ctlimgset "carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+const (
+	// ociLayoutLabelsAnnotation annotation of the descriptors of the index of an OCI image layout written by copy
+	// --to-oci-layout, with the labels of the image as JSON, such as the one of the root bundle
+	ociLayoutLabelsAnnotation = "dev.carvel.imgpkg.labels"
+	// ociLayoutOrigRefAnnotation annotation of the descriptors of the index of an OCI image layout written by copy
+	// --to-oci-layout, with the reference of the image in the images lock, or in the flags, it was copied from
+	ociLayoutOrigRefAnnotation = "dev.carvel.imgpkg.orig-ref"
+)
+
+// CopyToOCILayout copies the image, or the bundle with all the images it references, to an OCI image layout in dstPath,
+// keeping their digests. The location, tag and labels of each one are recorded in the layout, as CopyToTar records
+// them in the tar, for copy --from-oci-layout
+func (c CopyRepoSrc) CopyToOCILayout(dstPath string) error {
+	c.logger.Tracef("CopyToOCILayout\n")
+
+	unprocessedImageRefs, _, err := c.getAllSourceImages()
+	if err != nil {
+		return err
+	}
+
+	c.logger.Tracef("Writing images to OCI image layout\n")
+	// the images are recorded as processed into the layout to report their non-distributable layers
+	written := ctlimgset.NewProcessedImages()
+	var entries []ctlimg.OCILayoutEntry
+	for _, ref := range unprocessedImageRefs.All() {
+		entry, err := c.ociLayoutEntry(ref)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		written.Add(ctlimgset.ProcessedImage{UnprocessedImageRef: ref, DigestRef: ref.DigestRef, Image: entry.Image, ImageIndex: entry.Index})
+	}
+
+	err = ctlimg.WriteOCILayoutEntries(c.ctx, dstPath, entries, c.IncludeNonDistributable)
+	if err != nil {
+		return err
+	}
+
+	informUserToUseTheNonDistributableFlagWithDescriptors(
+		c.logger, c.IncludeNonDistributable, processedImagesNonDistLayer(written))
+
+	for _, ref := range unprocessedImageRefs.All() {
+		c.logAttached("Included", ref.DigestRef, ref.Labels)
+	}
+	return nil
+}
+
+// ociLayoutEntry reads the image, or image index, of ref and the annotations recording it in the OCI image layout
+func (c CopyRepoSrc) ociLayoutEntry(ref ctlimgset.UnprocessedImageRef) (ctlimg.OCILayoutEntry, error) {
+	digestRef, err := regname.NewDigest(ref.DigestRef)
+	if err != nil {
+		panic(fmt.Sprintf("Internal consistency: %s should be a digest", ref.DigestRef))
+	}
+
+	refName := digestRef.Name()
+	if ref.Tag != "" {
+		refName = digestRef.Context().Tag(ref.Tag).Name()
+	}
+	labels, err := json.Marshal(ref.Labels)
+	if err != nil {
+		return ctlimg.OCILayoutEntry{}, err
+	}
+	entry := ctlimg.OCILayoutEntry{Annotations: map[string]string{
+		ctlimg.OCILayoutRefNameAnnotation: refName,
+		ociLayoutLabelsAnnotation:         string(labels),
+	}}
+	if ref.OrigRef != "" {
+		entry.Annotations[ociLayoutOrigRefAnnotation] = ref.OrigRef
+	}
+
+	desc, err := c.registry.Get(digestRef)
+	if err != nil {
+		return ctlimg.OCILayoutEntry{}, fmt.Errorf("Fetching '%s': %s", ref.DigestRef, err)
+	}
+	if desc.MediaType.IsIndex() {
+		entry.Index, err = c.registry.Index(digestRef)
+	} else {
+		entry.Image, err = c.registry.Image(digestRef)
+	}
+	if err != nil {
+		return ctlimg.OCILayoutEntry{}, fmt.Errorf("Fetching '%s': %s", ref.DigestRef, err)
+	}
+	return entry, nil
+}
+
+// ociLayoutImages returns the images and image indexes of the OCI image layout at path, and whether it was written by
+// copy --to-oci-layout. Each one keeps the reference recorded by push, or copy, --to-oci-layout, or the tag recorded by
+// other tools, and is in importRepo otherwise.
+// The labels recorded by copy tell the root bundle, otherwise a single bundle in the layout is the root bundle, so that
+// the lock output describes it. A layout with several images and no root bundle is ambiguous, --oci-layout-ref
+// selecting the image to copy
+func (c CopyRepoSrc) ociLayoutImages(path string, importRepo regname.Repository) ([]imagedesc.ImageOrIndex, bool, error) {
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("Reading OCI image layout '%s': %s", path, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, false, fmt.Errorf("Reading OCI image layout '%s': %s", path, err)
+	}
+
+	var items []imagedesc.ImageOrIndex
+	var bundleItems []int
+	writtenByCopy := len(manifest.Manifests) > 0
+	for _, desc := range manifest.Manifests {
+		repo, tag, origRef, err := ociLayoutRef(desc, importRepo)
+		if err != nil {
+			return nil, false, err
+		}
+		digestRef := repo.Digest(desc.Digest.String())
+		if recordedOrigRef, found := desc.Annotations[ociLayoutOrigRefAnnotation]; found {
+			origRef = recordedOrigRef
+		}
+		labels := map[string]string{}
+		if recordedLabels, found := desc.Annotations[ociLayoutLabelsAnnotation]; found {
+			err := json.Unmarshal([]byte(recordedLabels), &labels)
+			if err != nil {
+				return nil, false, fmt.Errorf("Parsing the labels of '%s' in the OCI image layout: %s", desc.Digest, err)
+			}
+			if labels == nil {
+				labels = map[string]string{}
+			}
+		} else {
+			writtenByCopy = false
+		}
+
+		switch {
+		case desc.MediaType.IsImage():
+			img, err := index.Image(desc.Digest)
+			if err != nil {
+				return nil, false, err
+			}
+			var imageWithRef imagedesc.ImageWithRef = ociLayoutImage{Image: img, ref: digestRef.Name(), tag: tag}
+			items = append(items, imagedesc.ImageOrIndex{Image: &imageWithRef, Labels: labels, OrigRef: origRef})
+
+			isBundle, err := ctlbundle.NewBundleFromPlainImage(plainimage.NewFetchedPlainImageWithTag(digestRef.Name(), tag, img), c.registry).IsBundle()
+			if err != nil {
+				return nil, false, err
+			}
+			if isBundle {
+				bundleItems = append(bundleItems, len(items)-1)
+			}
+
+		case desc.MediaType.IsIndex():
+			imgIndex, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, false, err
+			}
+			var indexWithRef imagedesc.ImageIndexWithRef = ociLayoutIndex{imageIndex: imgIndex, ref: digestRef.Name(), tag: tag}
+			items = append(items, imagedesc.ImageOrIndex{Index: &indexWithRef, Labels: labels, OrigRef: origRef})
+
+		default:
+			return nil, false, fmt.Errorf("Expected '%s' in the OCI image layout to be an image or an image index, was %s", desc.Digest, desc.MediaType)
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, false, fmt.Errorf("Expected OCI image layout '%s' to have at least one image", path)
+	}
+	if !writtenByCopy && len(bundleItems) == 1 {
+		items[bundleItems[0]].Labels[rootBundleLabelKey] = ""
+	}
+
+	if c.OCILayoutRef != "" {
+		items, err = c.selectOCILayoutImages(path, manifest.Manifests, items, bundleItems)
+		return items, writtenByCopy, err
+	}
+	if len(items) > 1 && !writtenByCopy && len(bundleItems) != 1 {
+		return nil, false, fmt.Errorf("Expected --oci-layout-ref to select the image to copy from OCI image layout '%s', "+
+			"which has %d images and no single bundle: %s", path, len(items), ociLayoutEntryNames(manifest.Manifests))
+	}
+	return items, writtenByCopy, nil
+}
+
+// selectOCILayoutImages returns the item of descs selected by --oci-layout-ref. A bundle is selected with the images
+// it references, and those its nested bundles reference, that are in the layout, and the artifacts attached to them
+func (c CopyRepoSrc) selectOCILayoutImages(path string, descs []regv1.Descriptor, items []imagedesc.ImageOrIndex, bundleItems []int) ([]imagedesc.ImageOrIndex, error) {
+	refName, digest := splitOCILayoutRef(c.OCILayoutRef)
+	if digest == "" && strings.HasPrefix(refName, "sha256:") {
+		refName, digest = "", refName
+	}
+
+	var matches []int
+	for i, desc := range descs {
+		descName, _ := splitOCILayoutRef(desc.Annotations[ctlimg.OCILayoutRefNameAnnotation])
+		if (refName == "" || descName == refName) && (digest == "" || desc.Digest.String() == digest) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("Expected --oci-layout-ref '%s' to match an image of OCI image layout '%s', which has: %s", c.OCILayoutRef, path, ociLayoutEntryNames(descs))
+	}
+	if len(matches) > 1 {
+		var matched []regv1.Descriptor
+		for _, i := range matches {
+			matched = append(matched, descs[i])
+		}
+		return nil, fmt.Errorf("Expected --oci-layout-ref '%s' to match a single image of OCI image layout '%s', it matches: %s", c.OCILayoutRef, path, ociLayoutEntryNames(matched))
+	}
+
+	bundles := map[int]bool{}
+	for _, i := range bundleItems {
+		bundles[i] = true
+	}
+	itemsByDigest := map[string]int{}
+	for i, desc := range descs {
+		itemsByDigest[desc.Digest.String()] = i
+	}
+
+	selected := matches[0]
+	included := map[string]bool{descs[selected].Digest.String(): true}
+	if bundles[selected] {
+		err := includeOCILayoutBundleImages(items, selected, bundles, itemsByDigest, included)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, item := range items {
+		if _, attached := item.Labels[ctlimgset.AttachedKindLabelKey]; attached && included[item.Labels[ctlimgset.AttachedToLabelKey]] {
+			included[descs[i].Digest.String()] = true
+		}
+	}
+
+	var selectedItems []imagedesc.ImageOrIndex
+	for i, item := range items {
+		if !included[descs[i].Digest.String()] {
+			continue
+		}
+		delete(item.Labels, rootBundleLabelKey)
+		if i == selected && bundles[i] {
+			item.Labels[rootBundleLabelKey] = ""
+		}
+		selectedItems = append(selectedItems, item)
+	}
+	return selectedItems, nil
+}
+
+// includeOCILayoutBundleImages adds the digests of the images the bundle items[bundle] references, and of those its
+// nested bundles reference, that are in the layout to included
+func includeOCILayoutBundleImages(items []imagedesc.ImageOrIndex, bundle int, bundles map[int]bool, itemsByDigest map[string]int, included map[string]bool) error {
+	imagesLock, err := ctlbundle.NewImagesLockReader().Read(*items[bundle].Image)
+	if err != nil {
+		return fmt.Errorf("Reading the images lock of bundle '%s' in the OCI image layout: %s", items[bundle].Ref(), err)
+	}
+	for _, imgRef := range imagesLock.Images {
+		imgDigest, err := regname.NewDigest(imgRef.Image)
+		if err != nil {
+			return fmt.Errorf("Parsing image '%s' of bundle '%s' in the OCI image layout: %s", imgRef.Image, items[bundle].Ref(), err)
+		}
+		i, found := itemsByDigest[imgDigest.DigestStr()]
+		if !found || included[imgDigest.DigestStr()] {
+			continue
+		}
+		included[imgDigest.DigestStr()] = true
+		if bundles[i] {
+			err := includeOCILayoutBundleImages(items, i, bundles, itemsByDigest, included)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ociLayoutEntryNames lists the descriptors of the index of an OCI image layout as the values --oci-layout-ref selects
+// them with
+func ociLayoutEntryNames(descs []regv1.Descriptor) string {
+	var names []string
+	for _, desc := range descs {
+		name, _ := splitOCILayoutRef(desc.Annotations[ctlimg.OCILayoutRefNameAnnotation])
+		names = append(names, name+"@"+desc.Digest.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// splitOCILayoutRef splits ref, as name@digest, in its name and its digest. The reference names recorded by copy
+// --to-oci-layout for the images without a tag have the digest of the image
+func splitOCILayoutRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// ociLayoutRef returns the repository, tag and full reference recorded in the OCILayoutRefNameAnnotation of desc.
+// The annotation only has a tag when the layout is written by other tools
+func ociLayoutRef(desc regv1.Descriptor, importRepo regname.Repository) (regname.Repository, string, string, error) {
+	refName, found := desc.Annotations[ctlimg.OCILayoutRefNameAnnotation]
+	if !found {
+		return importRepo, "", "", nil
+	}
+
+	if !strings.Contains(refName, "/") {
+		tagRef, err := regname.NewTag(importRepo.Name()+":"+refName, regname.WeakValidation)
+		if err != nil {
+			return regname.Repository{}, "", "", fmt.Errorf("Parsing tag '%s' of '%s' in the OCI image layout: %s", refName, desc.Digest, err)
+		}
+		return importRepo, tagRef.TagStr(), "", nil
+	}
+
+	ref, err := regname.ParseReference(refName, regname.WeakValidation)
+	if err != nil {
+		return regname.Repository{}, "", "", fmt.Errorf("Parsing reference '%s' of '%s' in the OCI image layout: %s", refName, desc.Digest, err)
+	}
+	tag := ""
+	if tagRef, ok := ref.(regname.Tag); ok {
+		tag = tagRef.TagStr()
+	}
+	return ref.Context(), tag, ref.Name(), nil
+}
+
+type ociLayoutImage struct {
+	regv1.Image
+	ref string
+	tag string
+}
+
+func (i ociLayoutImage) Ref() string { return i.ref }
+func (i ociLayoutImage) Tag() string { return i.tag }
+
+// imageIndex is embedded in ociLayoutIndex with a name other than ImageIndex, which is one of its methods
+type imageIndex = regv1.ImageIndex
+
+type ociLayoutIndex struct {
+	imageIndex
+	ref string
+	tag string
+}
+
+func (i ociLayoutIndex) Ref() string { return i.ref }
+func (i ociLayoutIndex) Tag() string { return i.tag }
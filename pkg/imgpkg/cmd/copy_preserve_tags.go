@@ -0,0 +1,178 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	ctlbundle "carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimgset "carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	// PreservedTagAnnotation annotation of the images of the images lock written by copy --lock-output, with the tag
+	// they were given by --preserve-tags
+	PreservedTagAnnotation = "imgpkg.carvel.dev/preserved-tag"
+	// PreservedTagOrigAnnotation annotation of the images of the images lock written by copy --lock-output, with the
+	// tag reference the tag of PreservedTagAnnotation was derived from
+	PreservedTagOrigAnnotation = "imgpkg.carvel.dev/preserved-tag-orig"
+
+	kbldIDAnnotation = "kbld.carvel.dev/id"
+)
+
+// origTagLabels labels of an image of a lock with the tag reference it was resolved from, recorded by push
+// --resolve-tags or by kbld, nil when the lock does not record one
+func origTagLabels(annotations map[string]string) map[string]string {
+	for _, key := range []string{ctlbundle.ResolvedTagAnnotation, kbldIDAnnotation} {
+		origRef := annotations[key]
+		tag, err := regname.NewTag(origRef, regname.WeakValidation)
+		// images built by kbld have their name, without a tag, as id
+		if err != nil || !strings.HasSuffix(origRef, ":"+tag.TagStr()) {
+			continue
+		}
+		return map[string]string{ctlimgset.OrigTagLabelKey: origRef}
+	}
+	return nil
+}
+
+// preserveTags tags the images copied to repo with a tag derived from the tag reference they were resolved from,
+// postgres-14.9 for postgres:14.9, recording it with PreservedTagLabelKey. The images are tagged in order of their
+// original reference and digest, so that a tag already given to another image, or pointing to another image in repo,
+// deterministically gets the first free suffix of -2, -3 and so on. Tags already pointing to the image are kept
+func (c CopyRepoSrc) preserveTags(processedImages *ctlimgset.ProcessedImages, repo regname.Repository) error {
+	var toTag []ctlimgset.ProcessedImage
+	for _, processedImage := range processedImages.All() {
+		if _, found := processedImage.Labels[ctlimgset.OrigTagLabelKey]; found {
+			toTag = append(toTag, processedImage)
+		}
+	}
+	sort.SliceStable(toTag, func(i, j int) bool {
+		if toTag[i].Labels[ctlimgset.OrigTagLabelKey] != toTag[j].Labels[ctlimgset.OrigTagLabelKey] {
+			return toTag[i].Labels[ctlimgset.OrigTagLabelKey] < toTag[j].Labels[ctlimgset.OrigTagLabelKey]
+		}
+		return toTag[i].DigestRef < toTag[j].DigestRef
+	})
+
+	// givenTags digests of the images given each tag by this copy
+	givenTags := map[string]string{}
+	for _, item := range toTag {
+		origRef := item.Labels[ctlimgset.OrigTagLabelKey]
+		origTag, err := regname.NewTag(origRef, regname.WeakValidation)
+		if err != nil {
+			return fmt.Errorf("Parsing original tag '%s' of image '%s': %s", origRef, item.DigestRef, err)
+		}
+		digest, err := regname.NewDigest(item.DigestRef)
+		if err != nil {
+			panic(fmt.Sprintf("Internal consistency: %s should be a digest", item.DigestRef))
+		}
+
+		baseTag := util.BuildPreservedTag(origTag)
+		tag := baseTag
+		for suffix := 2; ; suffix++ {
+			taggedDigest, err := c.preservedTagDigest(repo.Tag(tag), givenTags)
+			if err != nil {
+				return err
+			}
+			if taggedDigest == "" {
+				if err := c.writePreservedTag(repo.Tag(tag), item); err != nil {
+					return err
+				}
+				break
+			}
+			if taggedDigest == digest.DigestStr() {
+				break
+			}
+			tag = fmt.Sprintf("%s-%d", baseTag, suffix)
+		}
+		if tag != baseTag {
+			c.logger.Warnf("Tag '%s' already points to another image in '%s', preserving tag '%s' of image '%s' as '%s'\n",
+				baseTag, repo.Name(), origRef, item.DigestRef, tag)
+		}
+		givenTags[tag] = digest.DigestStr()
+
+		labels := map[string]string{}
+		for key, value := range item.Labels {
+			labels[key] = value
+		}
+		labels[ctlimgset.PreservedTagLabelKey] = tag
+		item.Labels = labels
+		processedImages.Add(item)
+	}
+	return nil
+}
+
+// preservedTagDigest digest of the image tagRef was given by this copy, or points to in the repository, empty when
+// the tag is free
+func (c CopyRepoSrc) preservedTagDigest(tagRef regname.Tag, givenTags map[string]string) (string, error) {
+	if givenDigest, found := givenTags[tagRef.TagStr()]; found {
+		return givenDigest, nil
+	}
+
+	existingDigest, err := c.registry.Digest(tagRef)
+	if err != nil {
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("Checking tag '%s': %s", tagRef.Name(), err)
+	}
+	return existingDigest.String(), nil
+}
+
+func (c CopyRepoSrc) writePreservedTag(tagRef regname.Tag, item ctlimgset.ProcessedImage) error {
+	switch {
+	case item.Image != nil:
+		if err := c.registry.WriteTag(tagRef, item.Image); err != nil {
+			return fmt.Errorf("Tagging image %s: %s", item.DigestRef, err)
+		}
+	case item.ImageIndex != nil:
+		if err := c.registry.WriteTag(tagRef, item.ImageIndex); err != nil {
+			return fmt.Errorf("Tagging image index %s: %s", item.DigestRef, err)
+		}
+	default:
+		panic("Unknown item")
+	}
+	return nil
+}
+
+// preservedTagRefs the tags given by --preserve-tags to the images copied, in order of their original reference
+func preservedTagRefs(processedImages *ctlimgset.ProcessedImages) []lockconfig.PreservedTagRef {
+	var refs []lockconfig.PreservedTagRef
+	for _, processedImage := range processedImages.All() {
+		tag, found := processedImage.Labels[ctlimgset.PreservedTagLabelKey]
+		if !found {
+			continue
+		}
+		refs = append(refs, lockconfig.PreservedTagRef{
+			Image:     processedImage.DigestRef,
+			Tag:       tag,
+			OrigImage: processedImage.Labels[ctlimgset.OrigTagLabelKey],
+		})
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].OrigImage < refs[j].OrigImage })
+	return refs
+}
+
+// preservedTagAnnotations adds PreservedTagAnnotation and PreservedTagOrigAnnotation to annotations when
+// the image was given a tag by --preserve-tags
+func preservedTagAnnotations(annotations map[string]string, processedImage ctlimgset.ProcessedImage) map[string]string {
+	tag, found := processedImage.Labels[ctlimgset.PreservedTagLabelKey]
+	if !found {
+		return annotations
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[PreservedTagAnnotation] = tag
+	annotations[PreservedTagOrigAnnotation] = processedImage.Labels[ctlimgset.OrigTagLabelKey]
+	return annotations
+}
@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	ctlbundle "carvel.dev/imgpkg/pkg/imgpkg/bundle"
@@ -22,14 +23,21 @@ type SignatureRetriever interface {
 }
 
 type CopyRepoSrc struct {
-	ImageFlags              ImageFlags
-	BundleFlags             BundleFlags
-	LockInputFlags          LockInputFlags
-	TarFlags                TarFlags
+	ImageFlags       ImageFlags
+	BundleFlags      BundleFlags
+	LockInputFlags   LockInputFlags
+	TarFlags         TarFlags
+	ImageFilterFlags ImageFilterFlags
+	OCILayoutSrc     string
+	// OCILayoutRef selects the image, or bundle, copied from OCILayoutSrc, as name@digest, name or digest
+	OCILayoutRef            string
 	IncludeNonDistributable bool
 	Concurrency             int
+	// PreserveTags tags the images copied to the repository with a tag derived from the tag they were resolved from
+	PreserveTags bool
 
 	logger             util.LoggerWithLevels
+	ctx                context.Context
 	imageSet           ctlimgset.ImageSet
 	tarImageSet        ctlimgset.TarImageSet
 	registry           registry.ImagesReaderWriter
@@ -54,6 +62,9 @@ func (c CopyRepoSrc) CopyToTar(dstPath string, resume bool) error {
 	informUserToUseTheNonDistributableFlagWithDescriptors(
 		c.logger, c.IncludeNonDistributable, getNonDistributableLayersFromImageDescriptors(ids))
 
+	for _, ref := range unprocessedImageRefs.All() {
+		c.logAttached("Included", ref.DigestRef, ref.Labels)
+	}
 	return nil
 }
 
@@ -66,47 +77,43 @@ func (c CopyRepoSrc) CopyToRepo(repo string) (*ctlimgset.ProcessedImages, error)
 		return nil, fmt.Errorf("Building import repository ref: %s", err)
 	}
 
-	if c.TarFlags.IsSrc() {
-		if c.TarFlags.IsDst() {
-			return nil, fmt.Errorf("Cannot use tar source (--tar) with tar destination (--to-tar)")
+	switch {
+	case c.OCILayoutSrc != "":
+		items, writtenByCopy, err := c.ociLayoutImages(c.OCILayoutSrc, importRepo)
+		if err != nil {
+			return nil, err
 		}
 
-		processedImages, err = c.tarImageSet.Import(c.TarFlags.TarSrc, importRepo, c.registry)
+		processedImages, err = c.imageSet.Import(items, importRepo, c.registry)
 		if err != nil {
 			return nil, err
 		}
 
-		var parentBundle *ctlbundle.Bundle
-		foundRootBundle := false
-		for _, processedImage := range processedImages.All() {
-			if processedImage.ImageIndex != nil {
-				continue
+		// the layouts written by push --to-oci-layout only have the bundle, its images staying where its images lock
+		// points to, copy --to-oci-layout writes them with the bundle
+		if writtenByCopy {
+			err = c.noteBundlesCopy(processedImages)
+			if err != nil {
+				return nil, err
 			}
+		}
 
-			if _, ok := processedImage.Labels[rootBundleLabelKey]; ok {
-				if foundRootBundle {
-					panic("Internal inconsistency: expected only 1 root bundle")
-				}
-				foundRootBundle = true
-				pImage := plainimage.NewFetchedPlainImageWithTag(processedImage.DigestRef, processedImage.Tag, processedImage.Image)
-				lockReader := ctlbundle.NewImagesLockReader()
-				parentBundle = ctlbundle.NewBundle(pImage, c.registry, lockReader, ctlbundle.NewFetcherFromProcessedImages(processedImages.All(), c.registry, lockReader))
-			}
+	case c.TarFlags.IsSrc():
+		if c.TarFlags.IsDst() {
+			return nil, fmt.Errorf("Cannot use tar source (--tar) with tar destination (--to-tar)")
 		}
 
-		if foundRootBundle {
-			bundles, _, err := parentBundle.AllImagesLockRefs(c.Concurrency, c.logger)
-			if err != nil {
-				return nil, err
-			}
+		processedImages, err = c.tarImageSet.Import(c.TarFlags.TarSrc, importRepo, c.registry)
+		if err != nil {
+			return nil, err
+		}
 
-			for _, bundle := range bundles {
-				if err := bundle.NoteCopy(processedImages, c.registry, c.logger); err != nil {
-					return nil, fmt.Errorf("Creating copy information for bundle %s: %s", bundle.DigestRef(), err)
-				}
-			}
+		err = c.noteBundlesCopy(processedImages)
+		if err != nil {
+			return nil, err
 		}
-	} else {
+
+	default:
 		unprocessedImageRefs, bundles, err := c.getAllSourceImages()
 		if err != nil {
 			return nil, err
@@ -117,8 +124,12 @@ func (c CopyRepoSrc) CopyToRepo(repo string) (*ctlimgset.ProcessedImages, error)
 			return nil, err
 		}
 
+		omitted, err := omittedDigests(processedImages)
+		if err != nil {
+			return nil, err
+		}
 		for _, bundle := range bundles {
-			if err := bundle.NoteCopy(processedImages, c.registry, c.logger); err != nil {
+			if err := bundle.NoteCopyWithOmitted(processedImages, omitted, c.registry, c.logger); err != nil {
 				return nil, fmt.Errorf("Creating copy information for bundle %s: %s", bundle.DigestRef(), err)
 			}
 		}
@@ -133,9 +144,79 @@ func (c CopyRepoSrc) CopyToRepo(repo string) (*ctlimgset.ProcessedImages, error)
 		return nil, fmt.Errorf("Tagging images: %s", err)
 	}
 
+	if c.PreserveTags {
+		c.logger.Logf("Preserving original tags\n")
+		err = c.preserveTags(processedImages, importRepo)
+		if err != nil {
+			return nil, fmt.Errorf("Preserving original tags: %s", err)
+		}
+	}
+
+	for _, processedImage := range processedImages.All() {
+		c.logAttached("Copied", processedImage.DigestRef, processedImage.Labels)
+	}
 	return processedImages, nil
 }
 
+// noteBundlesCopy writes the locations of the images of the root bundle copied from a tar, or an OCI image layout, and
+// of its nested bundles, whose images were all copied with it
+func (c CopyRepoSrc) noteBundlesCopy(processedImages *ctlimgset.ProcessedImages) error {
+	var parentBundle *ctlbundle.Bundle
+	foundRootBundle := false
+	for _, processedImage := range processedImages.All() {
+		if processedImage.ImageIndex != nil {
+			continue
+		}
+
+		if _, ok := processedImage.Labels[rootBundleLabelKey]; ok {
+			if foundRootBundle {
+				panic("Internal inconsistency: expected only 1 root bundle")
+			}
+			foundRootBundle = true
+			pImage := plainimage.NewFetchedPlainImageWithTag(processedImage.DigestRef, processedImage.Tag, processedImage.Image)
+			lockReader := ctlbundle.NewImagesLockReader()
+			parentBundle = ctlbundle.NewBundle(pImage, c.registry, lockReader, ctlbundle.NewFetcherFromProcessedImages(processedImages.All(), c.registry, lockReader))
+		}
+	}
+	if !foundRootBundle {
+		return nil
+	}
+
+	bundles, _, err := parentBundle.AllImagesLockRefs(c.Concurrency, c.logger)
+	if err != nil {
+		return err
+	}
+
+	omitted, err := omittedDigests(processedImages)
+	if err != nil {
+		return err
+	}
+	for _, bundle := range bundles {
+		// the images of bundles written by push --to-tar stay where their images lock points to
+		if !bundle.AllImagesCopiedOrOmitted(processedImages, omitted) {
+			continue
+		}
+		if err := bundle.NoteCopyWithOmitted(processedImages, omitted, c.registry, c.logger); err != nil {
+			return fmt.Errorf("Creating copy information for bundle %s: %s", bundle.DigestRef(), err)
+		}
+	}
+	return nil
+}
+
+// logAttached lists the artifact at digestRef when it was copied because it is attached to an image, with the
+// reference of the image in the same repository
+func (c CopyRepoSrc) logAttached(verb string, digestRef string, labels map[string]string) {
+	kind, found := labels[ctlimgset.AttachedKindLabelKey]
+	if !found {
+		return
+	}
+	digest, err := regname.NewDigest(digestRef)
+	if err != nil {
+		panic(fmt.Sprintf("Internal consistency: %s should be a digest", digestRef))
+	}
+	c.logger.Logf("%s %s '%s' attached to '%s'\n", verb, kind, digestRef, digest.Context().Digest(labels[ctlimgset.AttachedToLabelKey]).Name())
+}
+
 func (c CopyRepoSrc) getAllSourceImages() (*ctlimgset.UnprocessedImageRefs, []*ctlbundle.Bundle, error) {
 	unprocessedImageRefs, bundles, err := c.getProvidedSourceImages()
 	if err != nil {
@@ -173,23 +254,34 @@ func (c CopyRepoSrc) getProvidedSourceImages() (*ctlimgset.UnprocessedImageRefs,
 				return nil, nil, err
 			}
 
-			for _, img := range imagesRef.ImageRefs() {
-				unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{DigestRef: img.PrimaryLocation()})
+			images, rootBundleLabels, err := c.filterBundleImages(imagesRef)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, img := range images {
+				unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{DigestRef: img.PrimaryLocation(), Labels: origTagLabels(img.Annotations)})
 			}
 
 			unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{
 				DigestRef: bundleLock.Bundle.Image,
 				Tag:       bundleLock.Bundle.Tag,
-				Labels: map[string]string{
-					rootBundleLabelKey: "",
-				},
+				Labels:    rootBundleLabels,
 			})
 
 			return unprocessedImageRefs, bundles, nil
 
 		case imagesLock != nil:
 			c.logger.Tracef("get images from ImagesLock file\n")
+			filter, err := c.ImageFilterFlags.Filter()
+			if err != nil {
+				return nil, nil, err
+			}
 			for _, img := range imagesLock.Images {
+				if !filter.Includes(img.Image) {
+					c.logger.Logf("Omitting image '%s' filtered out by --include-image or --exclude-image\n", img.Image)
+					continue
+				}
+
 				plainImg := plainimage.NewPlainImage(img.Image, c.registry)
 
 				ok, err := ctlbundle.NewBundleFromPlainImage(plainImg, c.registry).IsBundle()
@@ -200,7 +292,10 @@ func (c CopyRepoSrc) getProvidedSourceImages() (*ctlimgset.UnprocessedImageRefs,
 					return nil, nil, fmt.Errorf("Unable to copy bundles using an Images Lock file (hint: Create a bundle with these images)")
 				}
 
-				unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{DigestRef: plainImg.DigestRef()})
+				unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{DigestRef: plainImg.DigestRef(), Labels: origTagLabels(img.Annotations)})
+			}
+			if unprocessedImageRefs.Length() == 0 {
+				return nil, nil, fmt.Errorf("Expected --include-image and --exclude-image to select at least one image of the images lock")
 			}
 			return unprocessedImageRefs, nil, nil
 
@@ -230,17 +325,19 @@ func (c CopyRepoSrc) getProvidedSourceImages() (*ctlimgset.UnprocessedImageRefs,
 			return nil, nil, err
 		}
 
-		for _, img := range imagesRef.ImageRefs() {
-			unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{DigestRef: img.PrimaryLocation(), OrigRef: img.Image})
+		images, rootBundleLabels, err := c.filterBundleImages(imagesRef)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, img := range images {
+			unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{DigestRef: img.PrimaryLocation(), Labels: origTagLabels(img.Annotations), OrigRef: img.Image})
 		}
 
 		unprocessedImageRefs.Add(ctlimgset.UnprocessedImageRef{
 			DigestRef: bundle.DigestRef(),
 			Tag:       bundle.Tag(),
-			Labels: map[string]string{
-				rootBundleLabelKey: "",
-			},
-			OrigRef: bundle.DigestRef()},
+			Labels:    rootBundleLabels,
+			OrigRef:   bundle.DigestRef()},
 		)
 		return unprocessedImageRefs, allBundles, nil
 	}
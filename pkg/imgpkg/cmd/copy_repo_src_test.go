@@ -6,12 +6,14 @@ package cmd
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 
@@ -23,11 +25,15 @@ import (
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
 	"carvel.dev/imgpkg/test/helpers"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -349,6 +355,63 @@ func TestToTarImage(t *testing.T) {
 	})
 }
 
+func TestToTarResumeInPlace(t *testing.T) {
+	imageName := "library/image"
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	image := fakeRegistry.WithRandomImageWithLayers(imageName, 10)
+	defer fakeRegistry.CleanUp()
+
+	subject := subject
+	subject.ImageFlags = ImageFlags{image.RefDigest}
+	subject.registry = fakeRegistry.Build()
+	subject.tarImageSet = imageset.NewTarImageSet(subject.imageSet, 3, subject.logger)
+
+	imageTarPath := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, subject.CopyToTar(imageTarPath, false))
+
+	_, writtenLayers, err := imagetar.NewTarReader(imageTarPath).WrittenLayers()
+	require.NoError(t, err)
+	require.Len(t, writtenLayers, 10)
+
+	var entries []string
+	for name := range writtenLayers {
+		entries = append(entries, name)
+	}
+	sort.Slice(entries, func(i, j int) bool { return writtenLayers[entries[i]].Offset < writtenLayers[entries[j]].Offset })
+	corrupted := writtenLayers[entries[3]]
+	truncated := writtenLayers[entries[9]]
+
+	// the contents of a layer are overwritten, and the tar ends in the middle of the last one
+	tarFile, err := os.OpenFile(imageTarPath, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = tarFile.WriteAt(make([]byte, 100), corrupted.Offset+512)
+	require.NoError(t, err)
+	require.NoError(t, tarFile.Truncate(truncated.Offset+512+truncated.Size/2))
+	require.NoError(t, tarFile.Close())
+
+	var requestedLayers []string
+	fakeRegistry.WithCustomHandler(func(writer http.ResponseWriter, request *http.Request) bool {
+		if request.Method == http.MethodGet && strings.Contains(request.URL.Path, "/blobs/") {
+			parts := strings.Split(request.URL.Path, "/")
+			digest := strings.Replace(parts[len(parts)-1], ":", "-", 1) + ".tar.gz"
+			if _, isLayer := writtenLayers[digest]; isLayer {
+				requestedLayers = append(requestedLayers, digest)
+			}
+		}
+		return false
+	})
+
+	require.NoError(t, subject.CopyToTar(imageTarPath, true))
+
+	assert.ElementsMatch(t, []string{entries[3], entries[9]}, requestedLayers)
+	presentLayers, err := imagetar.NewTarReader(imageTarPath).PresentLayers()
+	require.NoError(t, err)
+	assert.Len(t, presentLayers, 10)
+	_, resumedLayers, err := imagetar.NewTarReader(imageTarPath).WrittenLayers()
+	require.NoError(t, err)
+	assert.Equal(t, writtenLayers, resumedLayers)
+}
+
 func TestToTarImageContainingNonDistributableLayers(t *testing.T) {
 	imageName := "library/image"
 	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
@@ -1234,6 +1297,226 @@ images:
 	})
 }
 
+func TestToRepoImageWithAttachedArtifacts(t *testing.T) {
+	imageName := "library/image"
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer fakeRegistry.CleanUp()
+	image := fakeRegistry.WithRandomImage(imageName)
+	reg := fakeRegistry.Build()
+
+	imageDigest, err := name.NewDigest(image.RefDigest)
+	require.NoError(t, err)
+	signatureImg, err := random.Image(100, 1)
+	require.NoError(t, err)
+	signatureTag := strings.Replace(imageDigest.DigestStr(), ":", "-", 1) + ".sig"
+	require.NoError(t, remote.Write(imageDigest.Context().Tag(signatureTag), signatureImg))
+	signatureDigest, err := signatureImg.Digest()
+	require.NoError(t, err)
+
+	subjectDesc, err := remote.Head(imageDigest)
+	require.NoError(t, err)
+	sbomImg, err := random.Image(100, 1)
+	require.NoError(t, err)
+	sbomImg = mutate.Subject(mutate.ConfigMediaType(mutate.MediaType(sbomImg, types.OCIManifestSchema1), "application/spdx+json"), *subjectDesc).(regv1.Image)
+	sbomDigest, err := sbomImg.Digest()
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(imageDigest.Context().Digest(sbomDigest.String()), sbomImg))
+
+	subject := subject
+	subject.ImageFlags = ImageFlags{image.RefDigest}
+	subject.registry = reg
+	subject.signatureRetriever = signature.NewAttached(reg, signature.AttachedScope{Signatures: true, SBOMs: true}, 1)
+
+	assertAttachedCopied := func(t *testing.T, destRepo string) {
+		dest, err := name.NewRepository(destRepo)
+		require.NoError(t, err)
+
+		sourceManifest, err := remote.Get(imageDigest.Context().Digest(sbomDigest.String()))
+		require.NoError(t, err)
+		destManifest, err := remote.Get(dest.Digest(sbomDigest.String()))
+		require.NoError(t, err)
+		assert.Equal(t, string(sourceManifest.Manifest), string(destManifest.Manifest))
+
+		referrers, err := remote.Referrers(dest.Digest(imageDigest.DigestStr()))
+		require.NoError(t, err)
+		referrersManifest, err := referrers.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, referrersManifest.Manifests, 1)
+		assert.Equal(t, sbomDigest, referrersManifest.Manifests[0].Digest)
+
+		taggedSignature, err := remote.Head(dest.Tag(signatureTag))
+		require.NoError(t, err)
+		assert.Equal(t, signatureDigest, taggedSignature.Digest)
+
+		assert.Contains(t, stdOut.String(), fmt.Sprintf("Copied sbom '%s' attached to '%s'", dest.Digest(sbomDigest.String()).Name(), dest.Digest(imageDigest.DigestStr()).Name()))
+		assert.Contains(t, stdOut.String(), fmt.Sprintf("Copied signature '%s' attached to '%s'", dest.Digest(signatureDigest.String()).Name(), dest.Digest(imageDigest.DigestStr()).Name()))
+	}
+
+	t.Run("copies the signatures and SBOMs attached to the image, with their manifests unchanged", func(t *testing.T) {
+		stdOut.Reset()
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/copied-img")
+
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+		require.Len(t, processedImages.All(), 3)
+
+		assertAttachedCopied(t, destRepo)
+	})
+
+	t.Run("copies the attached artifacts through a tar", func(t *testing.T) {
+		stdOut.Reset()
+		tarPath := filepath.Join(t.TempDir(), "image.tar")
+		require.NoError(t, subject.CopyToTar(tarPath, false))
+		assert.Contains(t, stdOut.String(), fmt.Sprintf("Included sbom '%s' attached to '%s'", imageDigest.Context().Digest(sbomDigest.String()).Name(), image.RefDigest))
+
+		subject := subject
+		subject.ImageFlags = ImageFlags{}
+		subject.TarFlags.TarSrc = tarPath
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/copied-from-tar")
+		_, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+
+		assertAttachedCopied(t, destRepo)
+	})
+}
+
+func TestOCILayoutRoundTrip(t *testing.T) {
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer fakeRegistry.CleanUp()
+	randomImage := fakeRegistry.WithRandomImage("library/image_with_config")
+	randomImage2 := fakeRegistry.WithRandomImage("library/image_with_config_2")
+	imageIndex := fakeRegistry.WithARandomImageIndex("library/imageindex", 2)
+
+	nestedBundle := fakeRegistry.WithBundleFromPath("library/nested-bundle", "test_assets/bundle_with_mult_images").
+		WithImageRefs([]lockconfig.ImageRef{
+			{Image: randomImage.RefDigest},
+			{Image: randomImage2.RefDigest},
+		})
+	rootBundle := fakeRegistry.WithBundleFromPath("library/bundle", "test_assets/bundle_with_mult_images").
+		WithImageRefs([]lockconfig.ImageRef{
+			{Image: nestedBundle.RefDigest},
+			{Image: imageIndex.RefDigest},
+		})
+	reg := fakeRegistry.Build()
+
+	processedDigests := func(processedImages *imageset.ProcessedImages) ([]string, string) {
+		var digestRefs []string
+		var rootBundleRef string
+		for _, processedImage := range processedImages.All() {
+			digestRefs = append(digestRefs, processedImage.DigestRef)
+			if _, ok := processedImage.Labels[rootBundleLabelKey]; ok {
+				rootBundleRef = processedImage.DigestRef
+			}
+		}
+		return digestRefs, rootBundleRef
+	}
+
+	parseRef := func(t *testing.T, ref string) name.Reference {
+		parsed, err := name.ParseReference(ref)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	copyToLayout := func(t *testing.T) string {
+		subject := subject
+		subject.BundleFlags.Bundle = rootBundle.RefDigest
+		subject.registry = reg
+
+		layoutPath := filepath.Join(t.TempDir(), "layout")
+		require.NoError(t, subject.CopyToOCILayout(layoutPath))
+		return layoutPath
+	}
+
+	t.Run("copies a bundle, with its nested bundle and images, to a layout and from it to a repository keeping the digests", func(t *testing.T) {
+		layoutPath := copyToLayout(t)
+
+		subject := subject
+		subject.OCILayoutSrc = layoutPath
+		subject.registry = reg
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/bundle-copy")
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+
+		digestRefs, rootBundleRef := processedDigests(processedImages)
+		assert.ElementsMatch(t, []string{
+			destRepo + "@" + rootBundle.Digest,
+			destRepo + "@" + nestedBundle.Digest,
+			destRepo + "@" + imageIndex.Digest,
+			destRepo + "@" + randomImage.Digest,
+			destRepo + "@" + randomImage2.Digest,
+		}, digestRefs)
+		assert.Equal(t, destRepo+"@"+rootBundle.Digest, rootBundleRef)
+
+		copiedIndex, err := reg.Index(parseRef(t, destRepo+"@"+imageIndex.Digest))
+		require.NoError(t, err)
+		indexManifest, err := copiedIndex.IndexManifest()
+		require.NoError(t, err)
+		assert.Len(t, indexManifest.Manifests, 2)
+
+		// the locations of the images of both bundles are noted, as when copying from a tar
+		for _, bundleDigest := range []string{rootBundle.Digest, nestedBundle.Digest} {
+			_, err := reg.Digest(parseRef(t, destRepo+":"+strings.Replace(bundleDigest, ":", "-", 1)+".image-locations.imgpkg"))
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("copies the bundle selected with --oci-layout-ref, with its images", func(t *testing.T) {
+		layoutPath := copyToLayout(t)
+
+		subject := subject
+		subject.OCILayoutSrc = layoutPath
+		subject.OCILayoutRef = nestedBundle.Digest
+		subject.registry = reg
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/nested-bundle-copy")
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+
+		digestRefs, rootBundleRef := processedDigests(processedImages)
+		assert.ElementsMatch(t, []string{
+			destRepo + "@" + nestedBundle.Digest,
+			destRepo + "@" + randomImage.Digest,
+			destRepo + "@" + randomImage2.Digest,
+		}, digestRefs)
+		assert.Equal(t, destRepo+"@"+nestedBundle.Digest, rootBundleRef)
+	})
+
+	t.Run("requires --oci-layout-ref when the layout has several images and no bundle", func(t *testing.T) {
+		img, err := reg.Image(parseRef(t, randomImage.RefDigest))
+		require.NoError(t, err)
+		img2, err := reg.Image(parseRef(t, randomImage2.RefDigest))
+		require.NoError(t, err)
+
+		// the layouts written by other tools only record a tag
+		layoutPath := filepath.Join(t.TempDir(), "layout")
+		require.NoError(t, ctlimg.WriteOCILayoutEntries(context.Background(), layoutPath, []ctlimg.OCILayoutEntry{
+			{Image: img, Annotations: map[string]string{ctlimg.OCILayoutRefNameAnnotation: "app1"}},
+			{Image: img2, Annotations: map[string]string{ctlimg.OCILayoutRefNameAnnotation: "app2"}},
+		}, true))
+
+		subject := subject
+		subject.OCILayoutSrc = layoutPath
+		subject.registry = reg
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/app-copy")
+		_, err = subject.CopyToRepo(destRepo)
+		require.EqualError(t, err, fmt.Sprintf("Expected --oci-layout-ref to select the image to copy from OCI image layout '%s', "+
+			"which has 2 images and no single bundle: app1@%s, app2@%s", layoutPath, randomImage.Digest, randomImage2.Digest))
+
+		subject.OCILayoutRef = "app3"
+		_, err = subject.CopyToRepo(destRepo)
+		require.EqualError(t, err, fmt.Sprintf("Expected --oci-layout-ref 'app3' to match an image of OCI image layout '%s', "+
+			"which has: app1@%s, app2@%s", layoutPath, randomImage.Digest, randomImage2.Digest))
+
+		subject.OCILayoutRef = "app2@" + randomImage2.Digest
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+		digestRefs, _ := processedDigests(processedImages)
+		assert.Equal(t, []string{destRepo + "@" + randomImage2.Digest}, digestRefs)
+		taggedDigest, err := reg.Digest(parseRef(t, destRepo+":app2"))
+		require.NoError(t, err)
+		assert.Equal(t, randomImage2.Digest, taggedDigest.String())
+	})
+}
+
 type fakeSignatureRetriever struct {
 }
 
@@ -1369,3 +1652,257 @@ func downloadImagesLocation(t *testing.T, imgRef, location string) {
 	err = ctlimg.NewDirImage(filepath.Join(location), img, util.NewBufferLogger(output)).AsDirectory()
 	require.NoError(t, err)
 }
+
+func TestToRepoTransferStats(t *testing.T) {
+	sourceRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer sourceRegistry.CleanUp()
+	image := sourceRegistry.WithRandomImageWithLayers("library/image", 2)
+	sourceRegistry.Build()
+
+	destRegistry := helpers.NewFakeRegistryWithRepoSeparation(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer destRegistry.CleanUp()
+
+	imageSize := func(t *testing.T) int64 {
+		manifest, err := image.Image.Manifest()
+		require.NoError(t, err)
+		size := manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		return size
+	}(t)
+
+	copyWith := func(t *testing.T, tarFlags TarFlags, destRepo string, mountFrom *name.Repository, force bool) transferStats {
+		var writer *transferTrackingWriter
+		reg := destRegistry.BuildWithRegistryOpts(registry.Opts{
+			EnvironFunc:   os.Environ,
+			RetryCount:    3,
+			ForceUpload:   force,
+			OnBlobMounted: func(digest string) { writer.blobMounted(digest) },
+		})
+		writer = newTransferTrackingWriter(reg, mountFrom, false)
+
+		subject := subject
+		subject.ImageFlags = ImageFlags{image.RefDigest}
+		subject.TarFlags = tarFlags
+		subject.registry = writer
+		_, err := subject.CopyToRepo(destRegistry.ReferenceOnTestServer(destRepo))
+		require.NoError(t, err)
+		return writer.stats
+	}
+
+	t.Run("uploads the blobs the destination does not have", func(t *testing.T) {
+		stats := copyWith(t, TarFlags{}, "library/copied-img", nil, false)
+		assert.Equal(t, transferStats{BlobsUploaded: 3, BytesUploaded: imageSize}, stats)
+	})
+
+	t.Run("skips the blobs the destination already has", func(t *testing.T) {
+		stats := copyWith(t, TarFlags{}, "library/copied-img", nil, false)
+		assert.Equal(t, transferStats{BlobsSkipped: 3, BytesSkipped: imageSize}, stats)
+	})
+
+	t.Run("mounts the blobs from the repository provided", func(t *testing.T) {
+		mountFrom, err := name.NewRepository(destRegistry.ReferenceOnTestServer("library/copied-img"))
+		require.NoError(t, err)
+
+		stats := copyWith(t, TarFlags{}, "library/other-copied-img", &mountFrom, false)
+		assert.Equal(t, transferStats{BlobsMounted: 3, BytesMounted: imageSize}, stats)
+	})
+
+	t.Run("uploads every blob again when forced", func(t *testing.T) {
+		stats := copyWith(t, TarFlags{}, "library/copied-img", nil, true)
+		assert.Equal(t, transferStats{BlobsUploaded: 3, BytesUploaded: imageSize}, stats)
+	})
+
+	t.Run("skips the blobs the destination already has when copying from a tar again", func(t *testing.T) {
+		subject := subject
+		subject.ImageFlags = ImageFlags{image.RefDigest}
+		subject.registry = sourceRegistry.Build()
+		tarFlags := TarFlags{TarSrc: filepath.Join(t.TempDir(), "image.tar")}
+		require.NoError(t, subject.CopyToTar(tarFlags.TarSrc, false))
+
+		stats := copyWith(t, tarFlags, "library/copied-from-tar-img", nil, false)
+		assert.Equal(t, transferStats{BlobsUploaded: 3, BytesUploaded: imageSize}, stats)
+
+		stats = copyWith(t, tarFlags, "library/copied-from-tar-img", nil, false)
+		assert.Equal(t, transferStats{BlobsSkipped: 3, BytesSkipped: imageSize}, stats)
+	})
+}
+
+func TestToRepoPreserveTags(t *testing.T) {
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer fakeRegistry.CleanUp()
+	postgres := fakeRegistry.WithRandomImage("library/postgres")
+	otherPostgres := fakeRegistry.WithRandomImage("other/postgres")
+	app := fakeRegistry.WithRandomImage("library/app")
+	unrelated := fakeRegistry.WithRandomImage("library/unrelated")
+
+	rootBundle := fakeRegistry.WithBundleFromPath("library/bundle", "test_assets/bundle_with_mult_images").
+		WithImageRefs([]lockconfig.ImageRef{
+			{Image: postgres.RefDigest, Annotations: map[string]string{bundle.ResolvedTagAnnotation: "index.docker.io/library/postgres:14.9"}},
+			{Image: otherPostgres.RefDigest, Annotations: map[string]string{"kbld.carvel.dev/id": "registry.example.com/other/postgres:14.9"}},
+			// images built by kbld have no tag to preserve
+			{Image: app.RefDigest, Annotations: map[string]string{"kbld.carvel.dev/id": "app"}},
+		})
+	reg := fakeRegistry.Build()
+
+	parseRef := func(t *testing.T, ref string) name.Reference {
+		parsed, err := name.ParseReference(ref)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	copyToRepo := func(t *testing.T, destRepo string, tarFlags TarFlags) *imageset.ProcessedImages {
+		subject := subject
+		subject.BundleFlags = BundleFlags{rootBundle.RefDigest}
+		subject.TarFlags = tarFlags
+		subject.PreserveTags = true
+		subject.registry = reg
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+		return processedImages
+	}
+
+	assertPreservedTags := func(t *testing.T, destRepo string, processedImages *imageset.ProcessedImages, postgresTag, otherPostgresTag string) {
+		assert.Equal(t, []lockconfig.PreservedTagRef{
+			{Image: destRepo + "@" + postgres.Digest, Tag: postgresTag, OrigImage: "index.docker.io/library/postgres:14.9"},
+			{Image: destRepo + "@" + otherPostgres.Digest, Tag: otherPostgresTag, OrigImage: "registry.example.com/other/postgres:14.9"},
+		}, preservedTagRefs(processedImages))
+
+		for tag, digest := range map[string]string{postgresTag: postgres.Digest, otherPostgresTag: otherPostgres.Digest} {
+			taggedDigest, err := reg.Digest(parseRef(t, destRepo+":"+tag))
+			require.NoError(t, err)
+			assert.Equal(t, digest, taggedDigest.String())
+		}
+	}
+
+	t.Run("tags the images with their original repository and tag, suffixing the tags given to another image", func(t *testing.T) {
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/preserved")
+		processedImages := copyToRepo(t, destRepo, TarFlags{})
+		assertPreservedTags(t, destRepo, processedImages, "postgres-14.9", "postgres-14.9-2")
+	})
+
+	t.Run("keeps the tags already given to the images when copying again", func(t *testing.T) {
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/preserved")
+		processedImages := copyToRepo(t, destRepo, TarFlags{})
+		assertPreservedTags(t, destRepo, processedImages, "postgres-14.9", "postgres-14.9-2")
+	})
+
+	t.Run("suffixes the tags pointing to another image in the destination", func(t *testing.T) {
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/preserved-taken")
+		require.NoError(t, reg.WriteImage(parseRef(t, destRepo+":postgres-14.9"), unrelated.Image, nil))
+
+		processedImages := copyToRepo(t, destRepo, TarFlags{})
+		assertPreservedTags(t, destRepo, processedImages, "postgres-14.9-2", "postgres-14.9-3")
+
+		taggedDigest, err := reg.Digest(parseRef(t, destRepo+":postgres-14.9"))
+		require.NoError(t, err)
+		assert.Equal(t, unrelated.Digest, taggedDigest.String())
+	})
+
+	t.Run("preserves the tags of the images copied through a tar", func(t *testing.T) {
+		subject := subject
+		subject.BundleFlags = BundleFlags{rootBundle.RefDigest}
+		subject.registry = reg
+		tarFlags := TarFlags{TarSrc: filepath.Join(t.TempDir(), "bundle.tar")}
+		require.NoError(t, subject.CopyToTar(tarFlags.TarSrc, false))
+
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/preserved-from-tar")
+		processedImages := copyToRepo(t, destRepo, tarFlags)
+		assertPreservedTags(t, destRepo, processedImages, "postgres-14.9", "postgres-14.9-2")
+	})
+}
+
+func TestToRepoFilterImages(t *testing.T) {
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	defer fakeRegistry.CleanUp()
+	postgres := fakeRegistry.WithRandomImage("library/postgres")
+	redis := fakeRegistry.WithRandomImage("library/redis")
+	app := fakeRegistry.WithRandomImage("team/app")
+
+	nestedBundle := fakeRegistry.WithBundleFromPath("library/nested-bundle", "test_assets/bundle_with_mult_images").
+		WithImageRefs([]lockconfig.ImageRef{
+			{Image: redis.RefDigest},
+		})
+	rootBundle := fakeRegistry.WithBundleFromPath("library/bundle", "test_assets/bundle_with_mult_images").
+		WithImageRefs([]lockconfig.ImageRef{
+			{Image: nestedBundle.RefDigest},
+			{Image: postgres.RefDigest},
+			{Image: app.RefDigest},
+		})
+	reg := fakeRegistry.Build()
+
+	processedDigests := func(processedImages *imageset.ProcessedImages) []string {
+		var digests []string
+		for _, processedImage := range processedImages.All() {
+			digest, err := name.NewDigest(processedImage.DigestRef)
+			require.NoError(t, err)
+			digests = append(digests, digest.DigestStr())
+		}
+		return digests
+	}
+
+	omittedLocations := func(t *testing.T, destRepo string, bundleDigest string) []string {
+		digest, err := name.NewDigest(destRepo + "@" + bundleDigest)
+		require.NoError(t, err)
+		locations, err := bundle.NewLocations(util.NewNoopLevelLogger()).Fetch(reg, digest)
+		require.NoError(t, err)
+
+		var omitted []string
+		for _, location := range locations.Images {
+			if location.Omitted {
+				omitted = append(omitted, location.Image)
+			}
+		}
+		return omitted
+	}
+
+	t.Run("copies the bundles with the images included only, recording the images omitted in their locations", func(t *testing.T) {
+		subject := subject
+		subject.BundleFlags = BundleFlags{rootBundle.RefDigest}
+		subject.ImageFilterFlags = ImageFilterFlags{IncludeImages: []string{"**/postgres"}}
+		subject.registry = reg
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/filtered")
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []string{rootBundle.Digest, nestedBundle.Digest, postgres.Digest}, processedDigests(processedImages))
+		assert.Equal(t, []string{redis.RefDigest, app.RefDigest}, omittedImages(processedImages))
+		assert.Equal(t, []string{app.RefDigest}, omittedLocations(t, destRepo, rootBundle.Digest))
+		assert.Equal(t, []string{redis.RefDigest}, omittedLocations(t, destRepo, nestedBundle.Digest))
+	})
+
+	t.Run("records the images excluded in a tar, for the copy from it", func(t *testing.T) {
+		subject := subject
+		subject.BundleFlags = BundleFlags{rootBundle.RefDigest}
+		subject.ImageFilterFlags = ImageFilterFlags{ExcludeImages: []string{app.Digest}}
+		subject.registry = reg
+		tarFlags := TarFlags{TarSrc: filepath.Join(t.TempDir(), "bundle.tar")}
+		require.NoError(t, subject.CopyToTar(tarFlags.TarSrc, false))
+
+		subject.BundleFlags = BundleFlags{}
+		subject.ImageFilterFlags = ImageFilterFlags{}
+		subject.TarFlags = tarFlags
+		destRepo := fakeRegistry.ReferenceOnTestServer("library/filtered-from-tar")
+		processedImages, err := subject.CopyToRepo(destRepo)
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []string{rootBundle.Digest, nestedBundle.Digest, postgres.Digest, redis.Digest}, processedDigests(processedImages))
+		assert.Equal(t, []string{app.RefDigest}, omittedLocations(t, destRepo, rootBundle.Digest))
+		assert.Empty(t, omittedLocations(t, destRepo, nestedBundle.Digest))
+	})
+
+	t.Run("requires an image of an images lock to be included", func(t *testing.T) {
+		lockPath := filepath.Join(t.TempDir(), "images.yml")
+		imagesLock := lockconfig.NewEmptyImagesLock()
+		imagesLock.AddImageRef(lockconfig.ImageRef{Image: postgres.RefDigest})
+		require.NoError(t, imagesLock.WriteToPath(lockPath))
+
+		subject := subject
+		subject.LockInputFlags = LockInputFlags{LockFilePath: lockPath}
+		subject.ImageFilterFlags = ImageFilterFlags{IncludeImages: []string{"**/redis"}}
+		subject.registry = reg
+		_, err := subject.CopyToRepo(fakeRegistry.ReferenceOnTestServer("library/filtered-lock"))
+		require.EqualError(t, err, "Expected --include-image and --exclude-image to select at least one image of the images lock")
+	})
+}
@@ -14,7 +14,7 @@ func TestMultiDest(t *testing.T) {
 		t.Fatalf("Expected Run() to err")
 	}
 
-	if !strings.Contains(err.Error(), "Expected either --to-tar or --to-repo") {
+	if !strings.Contains(err.Error(), "Expected either --to-tar, --to-oci-layout or --to-repo") {
 		t.Fatalf("Expected error message related to destinations, got: %s", err)
 	}
 }
@@ -25,7 +25,7 @@ func TestNoDest(t *testing.T) {
 		t.Fatalf("Expected Run() to err")
 	}
 
-	if !strings.Contains(err.Error(), "Expected either --to-tar or --to-repo") {
+	if !strings.Contains(err.Error(), "Expected either --to-tar, --to-oci-layout or --to-repo") {
 		t.Fatalf("Expected error message related to destinations, got: %s", err)
 	}
 }
@@ -36,7 +36,7 @@ func TestMultiSrc(t *testing.T) {
 		t.Fatalf("Expected Run() to err")
 	}
 
-	if !strings.Contains(err.Error(), "Expected either --lock, --bundle (-b), --image (-i), or --tar as a source") {
+	if !strings.Contains(err.Error(), "Expected either --lock, --bundle (-b), --image (-i), --tar, or --from-oci-layout as a source") {
 		t.Fatalf("Expected error message related to destinations, got: %s", err)
 	}
 }
@@ -47,7 +47,7 @@ func TestNoSrc(t *testing.T) {
 		t.Fatalf("Expected Run() to err")
 	}
 
-	if !strings.Contains(err.Error(), "Expected either --lock, --bundle (-b), --image (-i), or --tar as a source") {
+	if !strings.Contains(err.Error(), "Expected either --lock, --bundle (-b), --image (-i), --tar, or --from-oci-layout as a source") {
 		t.Fatalf("Expected error message related to destinations, got: %s", err)
 	}
 }
@@ -62,3 +62,91 @@ func TestTarSrcWithTarDst(t *testing.T) {
 		t.Fatalf("Expected error message related to destinations, got: %s", err)
 	}
 }
+
+func TestOCILayoutSrcWithTarDst(t *testing.T) {
+	err := (&CopyOptions{OCILayoutSrc: "foo", TarFlags: TarFlags{TarDst: "bar"}}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --from-oci-layout with tar destination (--to-tar)") {
+		t.Fatalf("Expected error message related to destinations, got: %s", err)
+	}
+}
+
+func TestOCILayoutSrcWithOCILayoutDst(t *testing.T) {
+	err := (&CopyOptions{OCILayoutSrc: "foo", OCILayoutDst: "bar"}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --tar or --from-oci-layout with OCI image layout destination (--to-oci-layout)") {
+		t.Fatalf("Expected error message related to destinations, got: %s", err)
+	}
+}
+
+func TestOCILayoutRefWithoutOCILayoutSrc(t *testing.T) {
+	err := (&CopyOptions{BundleFlags: BundleFlags{"my-bundle"}, RepoDst: "foo", OCILayoutRef: "app1"}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --oci-layout-ref without --from-oci-layout") {
+		t.Fatalf("Expected error message related to sources, got: %s", err)
+	}
+}
+
+func TestDigestFileWithTarDst(t *testing.T) {
+	err := (&CopyOptions{BundleFlags: BundleFlags{"my-bundle"}, TarFlags: TarFlags{TarDst: "bar"}, DigestFileFlags: DigestFileFlags{"bundle.digest"}}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --digest-file with tar destination (--to-tar)") {
+		t.Fatalf("Expected error message related to the digest file, got: %s", err)
+	}
+}
+
+func TestForceWithMountFrom(t *testing.T) {
+	err := (&CopyOptions{BundleFlags: BundleFlags{"my-bundle"}, RepoDst: "registry.io/repo", Force: true, MountFrom: "registry.io/other-repo"}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --mount-from with --force, every blob is uploaded") {
+		t.Fatalf("Expected error message related to --mount-from, got: %s", err)
+	}
+}
+
+func TestMountFromInOtherRegistry(t *testing.T) {
+	err := (&CopyOptions{BundleFlags: BundleFlags{"my-bundle"}, RepoDst: "registry.io/repo", MountFrom: "other-registry.io/other-repo"}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Expected --mount-from 'other-registry.io/other-repo' to be in the registry 'registry.io' of --to-repo") {
+		t.Fatalf("Expected error message related to --mount-from, got: %s", err)
+	}
+}
+
+func TestForceWithTarDst(t *testing.T) {
+	err := (&CopyOptions{BundleFlags: BundleFlags{"my-bundle"}, TarFlags: TarFlags{TarDst: "bar"}, Force: true}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --force or --mount-from with tar destination (--to-tar)") {
+		t.Fatalf("Expected error message related to destinations, got: %s", err)
+	}
+}
+
+func TestPreserveTagsWithTarDst(t *testing.T) {
+	err := (&CopyOptions{BundleFlags: BundleFlags{"my-bundle"}, TarFlags: TarFlags{TarDst: "foo.tar"}, PreserveTags: true}).Run()
+	if err == nil {
+		t.Fatalf("Expected Run() to err")
+	}
+
+	if !strings.Contains(err.Error(), "Cannot use --preserve-tags with tar destination (--to-tar)") {
+		t.Fatalf("Expected error message related to --preserve-tags, got: %s", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// transferStats blobs of the images copied to the destination. Blobs the registry already has are not read, so they
+// are the ones skipped, unless the registry reported that it mounted them from another repository
+type transferStats struct {
+	BlobsUploaded int
+	BytesUploaded int64
+	BlobsMounted  int
+	BytesMounted  int64
+	BlobsSkipped  int
+	BytesSkipped  int64
+}
+
+// String summary of the blobs copied
+func (s transferStats) String() string {
+	return fmt.Sprintf("Uploaded %d bytes in %d blob(s), mounted %d bytes in %d blob(s), skipped %d bytes in %d blob(s) already in the registry",
+		s.BytesUploaded, s.BlobsUploaded, s.BytesMounted, s.BlobsMounted, s.BytesSkipped, s.BlobsSkipped)
+}
+
+// transferTrackingWriter writes the images and indexes copied, recording which of their blobs were uploaded, mounted
+// or skipped in stats. The registry checks whether it has each blob and manifest before they are uploaded, the ones it
+// has being skipped
+type transferTrackingWriter struct {
+	registry.Registry
+
+	// mountFrom repository of the destination registry the blobs missing in the destination are mounted from, nil
+	// when not provided. The blobs of images in the destination registry are mounted from their repository either way
+	mountFrom *regname.Repository
+	// includeNonDistributable non-distributable layers are uploaded, they are not otherwise
+	includeNonDistributable bool
+	stats                   transferStats
+
+	lock    sync.Mutex
+	blobs   map[string]int64
+	read    map[string]int64
+	mounted map[string]bool
+}
+
+func newTransferTrackingWriter(reg registry.Registry, mountFrom *regname.Repository, includeNonDistributable bool) *transferTrackingWriter {
+	return &transferTrackingWriter{Registry: reg, mountFrom: mountFrom, includeNonDistributable: includeNonDistributable,
+		blobs: map[string]int64{}, read: map[string]int64{}, mounted: map[string]bool{}}
+}
+
+// MultiWrite writes the images and indexes, with their blobs read through transferTrackedLayer, and adds their blobs to
+// the stats once they are written
+func (w *transferTrackingWriter) MultiWrite(imageOrIndexesToUpload map[regname.Reference]regremote.Taggable, concurrency int, updatesCh chan regv1.Update) error {
+	tracked := map[regname.Reference]regremote.Taggable{}
+	for ref, taggable := range imageOrIndexesToUpload {
+		switch artifact := taggable.(type) {
+		case regv1.Image:
+			img, err := w.trackedImage(artifact)
+			if err != nil {
+				return err
+			}
+			tracked[ref] = img
+		case regv1.ImageIndex:
+			idx, err := w.trackedIndex(artifact)
+			if err != nil {
+				return err
+			}
+			tracked[ref] = idx
+		default:
+			tracked[ref] = taggable
+		}
+	}
+
+	err := w.Registry.MultiWrite(tracked, concurrency, updatesCh)
+	if err != nil {
+		return err
+	}
+	w.recordBlobs()
+	return nil
+}
+
+// blobMounted records that the registry mounted the blob with digest instead of having it uploaded
+func (w *transferTrackingWriter) blobMounted(digest string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.mounted[digest] = true
+}
+
+// recordBlobs adds the blobs of the images written to the stats, those that were neither read nor mounted were already
+// in the registry
+func (w *transferTrackingWriter) recordBlobs() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for digest, size := range w.blobs {
+		switch readBytes, read := w.read[digest]; {
+		case w.mounted[digest]:
+			w.stats.BlobsMounted++
+			w.stats.BytesMounted += size
+		case read:
+			w.stats.BlobsUploaded++
+			w.stats.BytesUploaded += readBytes
+		default:
+			w.stats.BlobsSkipped++
+			w.stats.BytesSkipped += size
+		}
+	}
+	w.blobs = map[string]int64{}
+	w.read = map[string]int64{}
+	w.mounted = map[string]bool{}
+}
+
+// trackedImage returns img with its layers and config read through transferTrackedLayer, recording its blobs
+func (w *transferTrackingWriter) trackedImage(img regv1.Image) (regv1.Image, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, desc := range append([]regv1.Descriptor{manifest.Config}, manifest.Layers...) {
+		if desc.MediaType.IsDistributable() || w.includeNonDistributable {
+			w.blobs[desc.Digest.String()] = desc.Size
+		}
+	}
+	return transferTrackedImage{Image: img, writer: w}, nil
+}
+
+// trackedIndex returns idx with the images it references, and those of the indexes it references, tracked
+func (w *transferTrackingWriter) trackedIndex(idx regv1.ImageIndex) (regv1.ImageIndex, error) {
+	indexManifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	// records the blobs of the images referenced
+	for _, desc := range indexManifest.Manifests {
+		switch {
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			_, err = w.trackedImage(img)
+			if err != nil {
+				return nil, err
+			}
+		case desc.MediaType.IsIndex():
+			childIdx, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			_, err = w.trackedIndex(childIdx)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return transferTrackedIndex{index: idx, writer: w}, nil
+}
+
+// transferTrackedIndex index whose images are written as transferTrackedImage
+type transferTrackedIndex struct {
+	index  regv1.ImageIndex
+	writer *transferTrackingWriter
+}
+
+var _ regv1.ImageIndex = transferTrackedIndex{}
+
+func (i transferTrackedIndex) MediaType() (types.MediaType, error) { return i.index.MediaType() }
+func (i transferTrackedIndex) Digest() (regv1.Hash, error)         { return i.index.Digest() }
+func (i transferTrackedIndex) Size() (int64, error)                { return i.index.Size() }
+func (i transferTrackedIndex) IndexManifest() (*regv1.IndexManifest, error) {
+	return i.index.IndexManifest()
+}
+func (i transferTrackedIndex) RawManifest() ([]byte, error) { return i.index.RawManifest() }
+
+func (i transferTrackedIndex) Image(digest regv1.Hash) (regv1.Image, error) {
+	img, err := i.index.Image(digest)
+	if err != nil {
+		return nil, err
+	}
+	return i.writer.trackedImage(img)
+}
+
+func (i transferTrackedIndex) ImageIndex(digest regv1.Hash) (regv1.ImageIndex, error) {
+	idx, err := i.index.ImageIndex(digest)
+	if err != nil {
+		return nil, err
+	}
+	return i.writer.trackedIndex(idx)
+}
+
+// transferTrackedImage image whose layers and config are read through transferTrackedLayer
+type transferTrackedImage struct {
+	regv1.Image
+	writer *transferTrackingWriter
+}
+
+func (i transferTrackedImage) Layers() ([]regv1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var trackedLayers []regv1.Layer
+	for _, layer := range layers {
+		trackedLayer, err := i.trackedLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		trackedLayers = append(trackedLayers, trackedLayer)
+	}
+	return trackedLayers, nil
+}
+
+func (i transferTrackedImage) LayerByDigest(digest regv1.Hash) (regv1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return i.trackedLayer(layer)
+}
+
+// ConfigLayer the config blob, which is uploaded like the layers
+func (i transferTrackedImage) ConfigLayer() (regv1.Layer, error) {
+	layer, err := partial.ConfigLayer(i.Image)
+	if err != nil {
+		return nil, err
+	}
+	return i.trackedLayer(layer)
+}
+
+// trackedLayer wraps layer in transferTrackedLayer. The layers of images read from the destination registry keep being
+// mounted from their repository, the others are mounted from --mount-from when it is provided
+func (i transferTrackedImage) trackedLayer(layer regv1.Layer) (regv1.Layer, error) {
+	if mountable, ok := layer.(*regremote.MountableLayer); ok {
+		return &regremote.MountableLayer{Layer: transferTrackedLayer{Layer: mountable.Layer, writer: i.writer}, Reference: mountable.Reference}, nil
+	}
+
+	trackedLayer := transferTrackedLayer{Layer: layer, writer: i.writer}
+	if i.writer.mountFrom == nil {
+		return trackedLayer, nil
+	}
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return &regremote.MountableLayer{Layer: trackedLayer, Reference: i.writer.mountFrom.Digest(digest.String())}, nil
+}
+
+type transferTrackedLayer struct {
+	regv1.Layer
+	writer *transferTrackingWriter
+}
+
+// Compressed opens the blob when it is uploaded, a blob being opened again when its upload is retried
+func (l transferTrackedLayer) Compressed() (io.ReadCloser, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	blob, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	l.writer.lock.Lock()
+	l.writer.read[digest.String()] = 0
+	l.writer.lock.Unlock()
+	return &transferReader{ReadCloser: blob, writer: l.writer, digest: digest.String()}, nil
+}
+
+// transferReader records the bytes of the blob read by the upload
+type transferReader struct {
+	io.ReadCloser
+	writer *transferTrackingWriter
+	digest string
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.writer.lock.Lock()
+		r.writer.read[r.digest] += int64(n)
+		r.writer.lock.Unlock()
+	}
+	return n, err
+}
+
+// eventStats totals of the copy reported by the terminal event of --progress-format json-lines
+func (s transferStats) eventStats(images int) util.EventStats {
+	return util.EventStats{Images: images, Blobs: s.BlobsUploaded, BlobsMounted: s.BlobsMounted, BlobsSkipped: s.BlobsSkipped, Bytes: s.BytesUploaded}
+}
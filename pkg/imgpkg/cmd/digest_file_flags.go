@@ -0,0 +1,62 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// digestFileStdout is where the digest reference is written when the digest file is -
+var digestFileStdout io.Writer = os.Stdout
+
+// DigestFileFlags file the fully qualified digest reference of the image, or bundle, is written to, such as
+// docker buildx --iidfile does, so that scripts do not have to parse the output of the command
+type DigestFileFlags struct {
+	DigestFilePath string
+}
+
+// SetOnPush Sets the digest-file flag for Push command
+func (d *DigestFileFlags) SetOnPush(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&d.DigestFilePath, "digest-file", "",
+		"File where the reference of the image pushed is written (format: repo@sha256:<hex>), or - to write it to stdout")
+}
+
+// SetOnPull Sets the digest-file flag for Pull command
+func (d *DigestFileFlags) SetOnPull(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&d.DigestFilePath, "digest-file", "",
+		"File where the reference of the image, or bundle, extracted is written (format: repo@sha256:<hex>), or - to write it to stdout. "+
+			"Option only available when extracting into a directory")
+}
+
+// SetOnCopy Sets the digest-file flag for Copy command
+func (d *DigestFileFlags) SetOnCopy(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&d.DigestFilePath, "digest-file", "",
+		"File where the reference of the image, or bundle, in the destination repository is written (format: repo@sha256:<hex>), or - to write it to stdout. "+
+			"Option only available when copying an image or a bundle with --to-repo")
+}
+
+// WriteDigest writes ref to the digest file, without a trailing newline, or to stdout when it is -
+func (d DigestFileFlags) WriteDigest(ref string) error {
+	if d.DigestFilePath == "" {
+		return nil
+	}
+
+	if d.DigestFilePath == stdoutOutputPath {
+		_, err := fmt.Fprintln(digestFileStdout, ref)
+		if err != nil {
+			return fmt.Errorf("Writing digest to stdout: %s", err)
+		}
+		return nil
+	}
+
+	err := os.WriteFile(d.DigestFilePath, []byte(ref), 0600)
+	if err != nil {
+		return fmt.Errorf("Writing digest file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/spf13/cobra"
+)
+
+// Codes identifying why a command failed, printed with the error when --json is provided so that automation
+// does not depend on the error messages. The values are part of the interface, they must not change
+const (
+	ErrorCodeIsBundle       = "image-is-bundle"
+	ErrorCodeIsNotBundle    = "image-is-not-bundle"
+	ErrorCodeDigestMismatch = "digest-mismatch"
+	ErrorCodeUnauthorized   = "unauthorized"
+	ErrorCodeNotFound       = "not-found"
+	ErrorCodeInterrupted    = "interrupted"
+	// ErrorCodeAdditionalTagFailed the image was pushed, but not all of its --additional-tag were written
+	ErrorCodeAdditionalTagFailed = "additional-tag-failed"
+	// ErrorCodeTagExists the tag pushed to already points to a different image, and cannot be overwritten
+	ErrorCodeTagExists = "tag-exists"
+	// ErrorCodeDestinationFailed the image was pushed, but not to all the repositories of --also-push-to
+	ErrorCodeDestinationFailed = "destination-failed"
+	// ErrorCodeSignFailed the image was pushed, but not signed with --sign-key
+	ErrorCodeSignFailed = "sign-failed"
+	// ErrorCodeAttachFailed the image was pushed, but not all the files of --attach were attached to it
+	ErrorCodeAttachFailed = "attach-failed"
+	ErrorCodeUnknown      = "error"
+)
+
+// JSONError document written to stderr when a command fails with --json
+type JSONError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// codedError error whose code is known where it is created
+type codedError struct {
+	code string
+	err  error
+}
+
+func newCodedError(code string, err error) error { return codedError{code: code, err: err} }
+
+func (e codedError) Error() string { return e.err.Error() }
+func (e codedError) Unwrap() error { return e.err }
+
+// registryErrorCodes codes of the registry API errors, which errors include in their message even when they
+// were formatted as text on the way up
+var registryErrorCodes = map[transport.ErrorCode]string{
+	transport.UnauthorizedErrorCode:    ErrorCodeUnauthorized,
+	transport.DeniedErrorCode:          ErrorCodeUnauthorized,
+	transport.ManifestUnknownErrorCode: ErrorCodeNotFound,
+	transport.NameUnknownErrorCode:     ErrorCodeNotFound,
+	transport.BlobUnknownErrorCode:     ErrorCodeNotFound,
+}
+
+// ErrorCode returns the code of the error returned by a command
+func ErrorCode(err error) string {
+	var coded codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorCodeInterrupted
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		for _, diagnostic := range transportErr.Errors {
+			if code, found := registryErrorCodes[diagnostic.Code]; found {
+				return code
+			}
+		}
+		switch transportErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrorCodeUnauthorized
+		case http.StatusNotFound:
+			return ErrorCodeNotFound
+		}
+	}
+
+	msg := err.Error()
+	for registryCode, code := range registryErrorCodes {
+		if strings.Contains(msg, string(registryCode)+":") {
+			return code
+		}
+	}
+	switch {
+	case strings.Contains(msg, "unexpected status code 401"), strings.Contains(msg, "unexpected status code 403"):
+		return ErrorCodeUnauthorized
+	case strings.Contains(msg, "unexpected status code 404"):
+		return ErrorCodeNotFound
+	}
+	return ErrorCodeUnknown
+}
+
+// UsesJSONOutput checks if the --json flag parsed by cmd, the root imgpkg command, asks for the output as JSON
+func UsesJSONOutput(cmd *cobra.Command) bool {
+	json, err := cmd.PersistentFlags().GetBool("json")
+	return err == nil && json
+}
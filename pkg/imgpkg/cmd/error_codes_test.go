@@ -0,0 +1,68 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cppforlife/go-cli-ui/ui"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected string
+	}{
+		"coded error": {
+			err:      fmt.Errorf("Pulling: %w", newCodedError(ErrorCodeIsBundle, fmt.Errorf("Expected bundle flag"))),
+			expected: ErrorCodeIsBundle,
+		},
+		"canceled context": {
+			err:      fmt.Errorf("Extracting layer: %w", context.Canceled),
+			expected: ErrorCodeInterrupted,
+		},
+		"registry error code": {
+			err:      &transport.Error{Errors: []transport.Diagnostic{{Code: transport.DeniedErrorCode}}, StatusCode: http.StatusForbidden},
+			expected: ErrorCodeUnauthorized,
+		},
+		"registry status code": {
+			err:      &transport.Error{StatusCode: http.StatusNotFound},
+			expected: ErrorCodeNotFound,
+		},
+		"registry error formatted as text": {
+			err:      fmt.Errorf("Fetching image: %s", &transport.Error{Errors: []transport.Diagnostic{{Code: transport.UnauthorizedErrorCode, Message: "authentication required"}}}),
+			expected: ErrorCodeUnauthorized,
+		},
+		"other error": {
+			err:      fmt.Errorf("Expected --output to be none empty"),
+			expected: ErrorCodeUnknown,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ErrorCode(test.err))
+		})
+	}
+}
+
+func TestUsesJSONOutput(t *testing.T) {
+	usesJSONOutputWith := func(args ...string) bool {
+		imgpkgCmd := NewDefaultImgpkgCmd(ui.NewConfUI(ui.NewNoopLogger()))
+		cmd, flags, err := imgpkgCmd.Find(args)
+		require.NoError(t, err)
+		require.NoError(t, cmd.ParseFlags(flags))
+		return UsesJSONOutput(imgpkgCmd)
+	}
+
+	assert.True(t, usesJSONOutputWith("pull", "-i", "image", "--json"))
+	assert.True(t, usesJSONOutputWith("pull", "--json=true", "-o", "dir"))
+	assert.False(t, usesJSONOutputWith("pull", "-i", "image", "--json=false"))
+	assert.False(t, usesJSONOutputWith("pull", "-i", "--json", "-o", "dir"))
+}
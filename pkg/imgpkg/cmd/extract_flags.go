@@ -0,0 +1,288 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"github.com/spf13/cobra"
+)
+
+// ExtractFlags command line flags that control how image contents are written to disk
+type ExtractFlags struct {
+	PreserveSymlinks    bool
+	PreservePermissions bool
+	PreserveSpecialBits bool
+	DefaultPerms        string
+	PreserveXattrs      bool
+	AllowDevices        bool
+	NoClean             bool
+	IncludePaths        []string
+	ExcludedPaths       []string
+	MaxExtractSize      int64
+	MaxFileSize         int64
+	MaxEntries          int
+	Concurrency         int
+	AllowCaseCollisions bool
+	Incremental         bool
+	Force               bool
+	ForceExtract        bool
+	SkipSpaceCheck      bool
+	IncludeMetadata     bool
+	MetadataDir         string
+	WindowsSafeNames    bool
+	ChecksumsOutput     string
+	UIDMaps             []string
+	GIDMaps             []string
+	StrictIDMaps        bool
+	Chown               string
+	Ownership           string
+	Dedupe              string
+	DedupeDir           string
+
+	// cmd command the flags are registered to, whose context stops the extraction when the command is interrupted
+	cmd *cobra.Command
+}
+
+// dedupeHardlink value of --dedupe that shares the extracted files between images by hardlinking them
+const dedupeHardlink = "hardlink"
+
+// Set Registers the flags available to the provided command
+func (e *ExtractFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&e.PreserveSymlinks, "preserve-symlinks", false, "Recreate symlinks found in the image, as long as their target stays inside the output directory")
+	cmd.Flags().BoolVar(&e.PreservePermissions, "preserve-permissions", false, "Apply file and directory modes from the image verbatim, the setuid, setgid and sticky bits are only kept with --preserve-special-bits. "+
+		"Modes of the entries in the image are set explicitly so the umask does not apply to them, "+
+		"parent directories that are not present in the image are still created following the umask")
+	cmd.Flags().StringVar(&e.DefaultPerms, "default-perms", string(ctlimg.DefaultPermsMirror), "Decide the modes of the extracted files and directories when --preserve-permissions is not provided: "+
+		"mirror copies the user permissions to group and other for the entries that have none, strict uses the modes of the image, "+
+		"both following the umask, and mask=VALUE removes the permissions of the octal mask instead of the umask, including from the parent directories created "+
+		"(one of: mirror, strict, mask=VALUE)")
+	cmd.Flags().BoolVar(&e.PreserveSpecialBits, "preserve-special-bits", false, "Keep the setuid, setgid and sticky bits of the files and directories in the image, they are removed by default")
+	cmd.Flags().BoolVar(&e.PreserveXattrs, "preserve-xattrs", false, "Restore the extended attributes of the files in the image, like security capabilities and SELinux labels. "+
+		"Only supported on Linux, attributes not supported by the platform or filesystem are skipped with a warning")
+	cmd.Flags().BoolVar(&e.AllowDevices, "allow-devices", false, "Create the character and block devices, and named pipes, of the image instead of skipping them. "+
+		"Creating devices requires privileges, like running as root, the pull fails when a device cannot be created. Not supported on Windows")
+	cmd.Flags().StringSliceVar(&e.IncludePaths, "include-path", nil, "Only extract the entries, or directories, whose path in the image matches the glob, '**' matches any number of directories. "+
+		"The .imgpkg directory of bundles is always extracted and nested bundles are extracted in full (format: config, config/**/*.yml) (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&e.ExcludedPaths, "file-exclusion", nil, "Do not extract the entries whose path, relative to the root of the image, matches, "+
+		"the content of matching directories is not extracted either. The .imgpkg directory of bundles is always extracted (format: bar.yaml, nested-dir/baz.txt) (can be specified multiple times)")
+	cmd.Flags().BoolVar(&e.NoClean, "no-clean", false, "Extract into the output directory without removing its existing content, only files present in the image are overwritten")
+	cmd.Flags().Int64Var(&e.MaxExtractSize, "max-extract-size", 0, "Maximum number of bytes extracted from the image, 0 uses 10 times the compressed size of the layers with a minimum of 1GiB and -1 disables the limit")
+	cmd.Flags().Int64Var(&e.MaxFileSize, "max-file-size", 0, "Maximum size in bytes of a single extracted file, 0 or -1 disables the limit")
+	cmd.Flags().StringSliceVar(&e.UIDMaps, "uid-map", nil, "Map the user ids of the files in the image to user ids in the host, files are owned by the mapped ids even when not running as root "+
+		"(format: container-id:host-id:size) (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&e.GIDMaps, "gid-map", nil, "Map the group ids of the files in the image to group ids in the host (format: container-id:host-id:size) (can be specified multiple times)")
+	cmd.Flags().BoolVar(&e.StrictIDMaps, "strict-id-map", false, "Error when an id of the image is not in the mappings, instead of using the id of the invoking user")
+	cmd.Flags().StringVar(&e.Ownership, "ownership", string(ctlimg.OwnershipAuto), "Decide if the extracted files are owned by the owner recorded in the image: "+
+		"preserve always sets it and fails when it cannot, current keeps the invoking user as owner, "+
+		"auto only sets it when running as root and warns when it cannot (one of: preserve, current, auto)")
+	cmd.Flags().StringVar(&e.Chown, "chown", "", "Set the owner of every extracted file, using user and group names or ids (format: user:group)")
+	cmd.Flags().IntVar(&e.Concurrency, "concurrency", 5, "Number of layers downloaded concurrently while the layers downloaded before are extracted. "+
+		"Downloaded layers are kept next to the extracted files until extracted, using up to the compressed size of that many layers of disk space, 1 streams the layers one at a time instead")
+	cmd.Flags().BoolVar(&e.AllowCaseCollisions, "allow-case-collisions", false, "Extract entries whose paths only differ in case, like README and readme, into a case-insensitive output directory, "+
+		"only one of them is kept")
+	cmd.Flags().BoolVar(&e.Incremental, "incremental", false, "Skip the extraction when the output directory was already pulled from the same image with the same flags, "+
+		"the pulled image is recorded in the "+ctlimg.PullStateFile+" file of the output directory, which is never pushed")
+	cmd.Flags().BoolVar(&e.Force, "force", false, "Extract the image even when --incremental finds the output directory unchanged")
+	cmd.Flags().BoolVar(&e.ForceExtract, "force-extract", false, "Extract the image even when its manifest marks it as not extractable with the annotation "+
+		ctlimg.ExtractAnnotation+": \"false\", which images only meant to be copied or relocated have")
+	cmd.Flags().BoolVar(&e.SkipSpaceCheck, "skip-space-check", false, "Extract without checking first that the output directory has enough disk space available for the image")
+	cmd.Flags().BoolVar(&e.IncludeMetadata, "include-metadata", false, "Write the manifest, config and digest of the image in the "+ctlimg.MetadataDir+
+		" directory of the output directory, which is never pushed")
+	cmd.Flags().StringVar(&e.MetadataDir, "metadata-dir", "", "Directory where --include-metadata writes the image metadata instead of the output directory")
+	cmd.Flags().BoolVar(&e.WindowsSafeNames, "windows-safe-names", false, "On Windows, rename the entries with reserved names, like aux or con.txt, or ending with a dot or a space, "+
+		"by replacing their last character with its %XX hexadecimal code, instead of failing")
+	cmd.Flags().StringVar(&e.ChecksumsOutput, "checksums-output", "", "Write the sha256 checksums of the extracted files to this file, in the format of sha256sum, "+
+		"with the paths relative to the output directory")
+	cmd.Flags().IntVar(&e.MaxEntries, "max-entries", 0, "Maximum number of entries extracted from the image, 0 uses 1000000 and -1 disables the limit")
+	cmd.Flags().StringVar(&e.Dedupe, "dedupe", "", "Keep the files of each extracted layer in --dedupe-dir and hardlink them into the output directory, "+
+		"so that layers shared by several images are written once. Files are copied when their owner, mode or times differ, "+
+		"or when --dedupe-dir is on another filesystem. Extracted files must not be changed in place (one of: hardlink)")
+	cmd.Flags().StringVar(&e.DedupeDir, "dedupe-dir", "", "Directory where --dedupe keeps the files of the extracted layers, "+
+		"it should be on the filesystem of the output directories (default ~/.imgpkg/layers)")
+	e.cmd = cmd
+}
+
+// AsDirImageOpts convert command flags into ctlimg.DirImageOpts
+func (e *ExtractFlags) AsDirImageOpts() (ctlimg.DirImageOpts, error) {
+	ownership, err := e.ownership()
+	if err != nil {
+		return ctlimg.DirImageOpts{}, err
+	}
+
+	if e.AllowDevices && runtime.GOOS == "windows" {
+		return ctlimg.DirImageOpts{}, fmt.Errorf("Creating devices with --allow-devices is not supported on Windows")
+	}
+
+	defaultPerms, err := e.defaultPerms()
+	if err != nil {
+		return ctlimg.DirImageOpts{}, err
+	}
+
+	if len(e.MetadataDir) > 0 && !e.IncludeMetadata {
+		return ctlimg.DirImageOpts{}, fmt.Errorf("Expected --include-metadata when --metadata-dir is provided")
+	}
+
+	layerStorePath, err := e.layerStorePath()
+	if err != nil {
+		return ctlimg.DirImageOpts{}, err
+	}
+
+	opts := ctlimg.DirImageOpts{
+		PreserveSymlinks:    e.PreserveSymlinks,
+		PreservePermissions: e.PreservePermissions,
+		PreserveSpecialBits: e.PreserveSpecialBits,
+		DefaultPerms:        defaultPerms,
+		PreserveXattrs:      e.PreserveXattrs,
+		AllowDevices:        e.AllowDevices,
+		NoClean:             e.NoClean,
+		IncludePaths:        e.IncludePaths,
+		ExcludedPaths:       e.ExcludedPaths,
+		Ownership:           ownership,
+		Concurrency:         e.Concurrency,
+		AllowCaseCollisions: e.AllowCaseCollisions,
+		Incremental:         e.Incremental,
+		Force:               e.Force,
+		ForceExtract:        e.ForceExtract,
+		SkipSpaceCheck:      e.SkipSpaceCheck,
+		IncludeMetadata:     e.IncludeMetadata,
+		MetadataPath:        e.MetadataDir,
+		WindowsSafeNames:    e.WindowsSafeNames,
+		ChecksumsPath:       e.ChecksumsOutput,
+		LayerStorePath:      layerStorePath,
+		Limits: ctlimg.ExtractLimits{
+			MaxSize:     e.MaxExtractSize,
+			MaxFileSize: e.MaxFileSize,
+			MaxEntries:  e.MaxEntries,
+		},
+	}
+	if e.cmd != nil {
+		opts.Context = e.cmd.Context()
+	}
+	return opts, nil
+}
+
+// layerStorePath returns the directory where the files of the layers are kept by --dedupe, or an empty string when
+// the extracted files are not deduplicated
+func (e *ExtractFlags) layerStorePath() (string, error) {
+	switch e.Dedupe {
+	case "":
+		if len(e.DedupeDir) > 0 {
+			return "", fmt.Errorf("Expected --dedupe when --dedupe-dir is provided")
+		}
+		return "", nil
+	case dedupeHardlink:
+	default:
+		return "", fmt.Errorf("Expected --dedupe '%s' to be one of: %s", e.Dedupe, dedupeHardlink)
+	}
+
+	if len(e.DedupeDir) > 0 {
+		return filepath.Abs(e.DedupeDir)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Finding the default --dedupe-dir: %s (hint: Use --dedupe-dir to provide it)", err)
+	}
+	return filepath.Join(home, ".imgpkg", "layers"), nil
+}
+
+func (e *ExtractFlags) defaultPerms() (ctlimg.DefaultPerms, error) {
+	if len(e.DefaultPerms) == 0 {
+		return ctlimg.DefaultPerms{}, nil
+	}
+	defaultPerms, err := ctlimg.ParseDefaultPerms(e.DefaultPerms)
+	if err != nil {
+		return ctlimg.DefaultPerms{}, fmt.Errorf("Parsing --default-perms: %s", err)
+	}
+	if e.PreservePermissions && defaultPerms.Mode != ctlimg.DefaultPermsMirror {
+		return ctlimg.DefaultPerms{}, fmt.Errorf("Expected only one of --preserve-permissions or --default-perms")
+	}
+	return defaultPerms, nil
+}
+
+func (e *ExtractFlags) ownership() (ctlimg.OwnershipOpts, error) {
+	ownership := ctlimg.OwnershipOpts{StrictIDMaps: e.StrictIDMaps}
+
+	if len(e.Ownership) > 0 {
+		mode, err := ctlimg.ParseOwnershipMode(e.Ownership)
+		if err != nil {
+			return ctlimg.OwnershipOpts{}, fmt.Errorf("Parsing --ownership: %s", err)
+		}
+		if mode == ctlimg.OwnershipCurrent && (len(e.Chown) > 0 || len(e.UIDMaps) > 0 || len(e.GIDMaps) > 0) {
+			return ctlimg.OwnershipOpts{}, fmt.Errorf("Expected --ownership=current to not be used with --chown, --uid-map or --gid-map")
+		}
+		ownership.Mode = mode
+	}
+
+	if len(e.Chown) > 0 {
+		if len(e.UIDMaps) > 0 || len(e.GIDMaps) > 0 {
+			return ctlimg.OwnershipOpts{}, fmt.Errorf("Expected only one of --chown or --uid-map/--gid-map")
+		}
+		uid, gid, err := lookupOwner(e.Chown)
+		if err != nil {
+			return ctlimg.OwnershipOpts{}, err
+		}
+		ownership.Chown = true
+		ownership.UID = uid
+		ownership.GID = gid
+		return ownership, nil
+	}
+
+	for _, value := range e.UIDMaps {
+		idMap, err := ctlimg.ParseIDMap(value)
+		if err != nil {
+			return ctlimg.OwnershipOpts{}, fmt.Errorf("Parsing --uid-map: %s", err)
+		}
+		ownership.UIDMaps = append(ownership.UIDMaps, idMap)
+	}
+	for _, value := range e.GIDMaps {
+		idMap, err := ctlimg.ParseIDMap(value)
+		if err != nil {
+			return ctlimg.OwnershipOpts{}, fmt.Errorf("Parsing --gid-map: %s", err)
+		}
+		ownership.GIDMaps = append(ownership.GIDMaps, idMap)
+	}
+	return ownership, nil
+}
+
+// lookupOwner resolves the user and group, provided as names or ids in the format user:group
+func lookupOwner(owner string) (int, int, error) {
+	pieces := strings.Split(owner, ":")
+	if len(pieces) != 2 || len(pieces[0]) == 0 || len(pieces[1]) == 0 {
+		return 0, 0, fmt.Errorf("Expected --chown '%s' to be in the format user:group", owner)
+	}
+
+	uid, err := strconv.Atoi(pieces[0])
+	if err != nil {
+		u, err := user.Lookup(pieces[0])
+		if err != nil {
+			return 0, 0, fmt.Errorf("Looking up user '%s': %s", pieces[0], err)
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Expected user '%s' to have a numeric id, but got '%s'", pieces[0], u.Uid)
+		}
+	}
+
+	gid, err := strconv.Atoi(pieces[1])
+	if err != nil {
+		g, err := user.LookupGroup(pieces[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("Looking up group '%s': %s", pieces[1], err)
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Expected group '%s' to have a numeric id, but got '%s'", pieces[1], g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractFlagsOwnership(t *testing.T) {
+	t.Run("it parses the id mappings", func(t *testing.T) {
+		flags := ExtractFlags{UIDMaps: []string{"0:100000:65536"}, GIDMaps: []string{"0:200000:1", "1000:300000:10"}, StrictIDMaps: true}
+
+		opts, err := flags.AsDirImageOpts()
+		require.NoError(t, err)
+
+		assert.Equal(t, ctlimg.OwnershipOpts{
+			UIDMaps:      []ctlimg.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			GIDMaps:      []ctlimg.IDMap{{ContainerID: 0, HostID: 200000, Size: 1}, {ContainerID: 1000, HostID: 300000, Size: 10}},
+			StrictIDMaps: true,
+		}, opts.Ownership)
+	})
+
+	t.Run("it parses the owner ids", func(t *testing.T) {
+		flags := ExtractFlags{Chown: "1234:5678"}
+
+		opts, err := flags.AsDirImageOpts()
+		require.NoError(t, err)
+
+		assert.Equal(t, ctlimg.OwnershipOpts{Chown: true, UID: 1234, GID: 5678}, opts.Ownership)
+	})
+
+	t.Run("it parses the default permissions", func(t *testing.T) {
+		flags := ExtractFlags{DefaultPerms: "mask=022"}
+
+		opts, err := flags.AsDirImageOpts()
+		require.NoError(t, err)
+
+		assert.Equal(t, ctlimg.DefaultPerms{Mode: ctlimg.DefaultPermsMask, Mask: 0022}, opts.DefaultPerms)
+	})
+
+	t.Run("it parses the ownership mode", func(t *testing.T) {
+		flags := ExtractFlags{Ownership: "preserve", UIDMaps: []string{"0:100000:1"}}
+
+		opts, err := flags.AsDirImageOpts()
+		require.NoError(t, err)
+
+		assert.Equal(t, ctlimg.OwnershipPreserve, opts.Ownership.Mode)
+	})
+
+	for _, test := range []struct {
+		flags       ExtractFlags
+		expectedErr string
+	}{
+		{ExtractFlags{UIDMaps: []string{"0:100000"}}, "Parsing --uid-map: Expected id mapping '0:100000' to be in the format container-id:host-id:size"},
+		{ExtractFlags{GIDMaps: []string{"0:-1:10"}}, "Parsing --gid-map: Expected id mapping '0:-1:10' to only contain positive numbers"},
+		{ExtractFlags{UIDMaps: []string{"0:100000:0"}}, "Expected id mapping '0:100000:0' size to be greater than 0"},
+		{ExtractFlags{Chown: "1234"}, "Expected --chown '1234' to be in the format user:group"},
+		{ExtractFlags{Chown: "imgpkg-missing-user:0"}, "Looking up user 'imgpkg-missing-user'"},
+		{ExtractFlags{Chown: "0:0", UIDMaps: []string{"0:100000:1"}}, "Expected only one of --chown or --uid-map/--gid-map"},
+		{ExtractFlags{MetadataDir: "/tmp/metadata"}, "Expected --include-metadata when --metadata-dir is provided"},
+		{ExtractFlags{Ownership: "root"}, "Parsing --ownership: Expected ownership mode 'root' to be one of preserve, current or auto"},
+		{ExtractFlags{Ownership: "current", Chown: "0:0"}, "Expected --ownership=current to not be used with --chown, --uid-map or --gid-map"},
+		{ExtractFlags{Dedupe: "reflink"}, "Expected --dedupe 'reflink' to be one of: hardlink"},
+		{ExtractFlags{DedupeDir: "/tmp/layers"}, "Expected --dedupe when --dedupe-dir is provided"},
+		{ExtractFlags{DefaultPerms: "mask=999"}, "Parsing --default-perms: Expected mask '999' to be an octal number between 000 and 777, like 022"},
+		{ExtractFlags{DefaultPerms: "strict", PreservePermissions: true}, "Expected only one of --preserve-permissions or --default-perms"},
+	} {
+		t.Run("it fails with "+test.expectedErr, func(t *testing.T) {
+			_, err := test.flags.AsDirImageOpts()
+			require.ErrorContains(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestExtractFlagsContext(t *testing.T) {
+	t.Run("it uses the context of the command", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cmd := &cobra.Command{}
+		cmd.SetContext(ctx)
+		flags := ExtractFlags{}
+		flags.Set(cmd)
+
+		opts, err := flags.AsDirImageOpts()
+		require.NoError(t, err)
+		assert.Equal(t, ctx, opts.Context)
+	})
+
+	t.Run("it has no context when the flags are not registered to a command", func(t *testing.T) {
+		opts, err := (&ExtractFlags{}).AsDirImageOpts()
+		require.NoError(t, err)
+		assert.Nil(t, opts.Context)
+	})
+}
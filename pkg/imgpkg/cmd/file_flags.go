@@ -4,23 +4,142 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"github.com/spf13/cobra"
 )
 
+// fromTarStdin is where the tar is read from when --from-tar is -
+var fromTarStdin io.Reader = os.Stdin
+
 type FileFlags struct {
 	Files []string
+	// FromTar tar with the content of the image, instead of Files
+	FromTar string
+	// FromGit git repository, with the ref and the path of its directory, cloned as the content of the image instead of Files
+	FromGit string
 
 	ExcludedFilePaths   []string
 	PreservePermissions bool
+	IncludeIgnoreFile   bool
+	Strict              bool
+	// IncludeVCS pushes the directories of version control systems, such as .git, left out otherwise
+	IncludeVCS bool
+	// ExclusionsFromGitignore leaves out the paths listed in the .gitignore files of the directories
+	ExclusionsFromGitignore bool
+
+	FollowSymlinks     bool
+	FollowSymlinksRoot []string
+
+	MaxFileSize  string
+	MaxTotalSize string
+
+	ScanSecrets      bool
+	ScanSecretsAllow []string
 }
 
 func (f *FileFlags) Set(cmd *cobra.Command) {
-	cmd.Flags().StringSliceVarP(&f.Files, "file", "f", nil, "Set file (format: /tmp/foo) (can be specified multiple times)")
+	cmd.Flags().StringSliceVarP(&f.Files, "file", "f", nil, "Set file (format: /tmp/foo) (can be specified multiple times, "+
+		"the content of each one replaces what is at the same path in the ones before it)")
+	cmd.Flags().StringVar(&f.FromTar, "from-tar", "", "Set tar, or - to read it from stdin, whose entries are the content of the image instead of the files of --file. "+
+		"The entries are sorted and get the permissions and modification time of the files of --file, hardlinks are pushed as the files they link to and symlinks are skipped")
+	cmd.Flags().StringVar(&f.FromGit, "from-git", "", "Clone the commit of the ref of a git repository, and push the content of its path instead of the files of --file, "+
+		"recording the remote, the ref and the commit as annotations. The credentials of git, and the ssh agent for ssh remotes, are used "+
+		"(format: https://github.com/org/repo//deploy/base?ref=v1.4.2, git@github.com:org/repo.git//deploy)")
+	cmd.Flags().BoolVar(&f.Strict, "strict", false, "Fail when files at the same path in more than one --file have different content, or a file and a directory are at the same path")
 
-	cmd.Flags().StringSliceVar(&f.ExcludedFilePaths, "file-exclude-defaults", []string{".git"}, "Excluded file paths by default (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&f.ExcludedFilePaths, "file-exclude-defaults", nil, "Excluded file paths by default (can be specified multiple times)")
 	cmd.Flags().MarkDeprecated("file-exclude-defaults", "use '--file-exclusion' instead")
 
-	cmd.Flags().StringSliceVar(&f.ExcludedFilePaths, "file-exclusion", []string{".git"}, "Exclude file whose path, relative to the bundle root, matches (format: bar.yaml, nested-dir/baz.txt) (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&f.ExcludedFilePaths, "file-exclusion", nil, "Exclude file whose path, relative to the bundle root, matches (format: bar.yaml, nested-dir/baz.txt) (can be specified multiple times)")
+	cmd.Flags().BoolVar(&f.IncludeVCS, "include-vcs", false, "Include the .git, .hg and .svn directories, which are left out at any depth otherwise, "+
+		"unless .imgpkgignore or --file-exclusion leave them out")
+
+	cmd.Flags().BoolVar(&f.IncludeIgnoreFile, "include-ignore-file", false, "Include the .imgpkgignore file of the directories in the image, the paths it lists are left out either way. "+
+		"Paths of --file-exclusion are left out even when .imgpkgignore includes them again with '!'")
+	cmd.Flags().BoolVar(&f.ExclusionsFromGitignore, "exclusions-from-gitignore", false, "Leave out the paths listed in the .gitignore files of the directories, "+
+		"at any depth, as git does. The most specific rules decide: --file-exclusion, then .imgpkgignore, then the .gitignore of the deepest directory")
+
+	cmd.Flags().BoolVar(&f.FollowSymlinks, "follow-symlinks", false, "Add the file, or directory tree, each symlink of the directories points to instead of skipping the symlink")
+	cmd.Flags().StringSliceVar(&f.FollowSymlinksRoot, "follow-symlinks-root", nil, "Allow the symlinks followed to point inside of this directory, besides the directory they are in "+
+		"(format: ../common) (can be specified multiple times)")
+
+	cmd.Flags().BoolVar(&f.PreservePermissions, "preserve-permissions", false, "Preserve the group and all permissions of all the files and folders "+
+		"(on Windows, folders get 0755 and files 0644, or 0755 for .sh and .exe files and files starting with #!)")
+
+	cmd.Flags().StringVar(&f.MaxFileSize, "max-file-size", "", "Fail before pushing when a file of the image, once the paths left out are, is larger than this "+
+		"(format: 500MB, 10GB, 2GiB) (default no limit)")
+	cmd.Flags().StringVar(&f.MaxTotalSize, "max-total-size", "", "Fail before pushing when the files of the image, once the paths left out are, are larger than this together "+
+		"(format: 500MB, 10GB, 2GiB) (default no limit, with a warning above 1GB)")
+
+	cmd.Flags().BoolVar(&f.ScanSecrets, "scan-secrets", false, "Fail before pushing when a file of the image has a secret, such as an AWS access key ID, "+
+		"a private key or a GitHub, GitLab, Slack, Google or Stripe token (the first 1MB of each file is scanned)")
+	cmd.Flags().StringSliceVar(&f.ScanSecretsAllow, "scan-secrets-allow", nil, "Glob of the paths of the image not scanned by --scan-secrets, "+
+		"matching all the content of a directory, and a name at any depth when it has no '/' (format: test/fixtures, *.pem) (can be specified multiple times)")
+}
+
+// AsTarImageOpts returns how the files and directories are added to the image
+func (f *FileFlags) AsTarImageOpts() ctlimg.TarImageOpts {
+	return ctlimg.TarImageOpts{
+		KeepPermissions:   f.PreservePermissions,
+		IncludeIgnoreFile: f.IncludeIgnoreFile,
+		Symlinks:          ctlimg.SymlinkOpts{Follow: f.FollowSymlinks, AllowedRoots: f.FollowSymlinksRoot},
+		Strict:            f.Strict,
+	}
+}
+
+// AsSizeLimits returns the limits of the size of the files of the image
+func (f *FileFlags) AsSizeLimits() (ctlimg.PushSizeLimits, error) {
+	var limits ctlimg.PushSizeLimits
+	var err error
+	if f.MaxFileSize != "" {
+		limits.MaxFileSize, err = parseByteSize(f.MaxFileSize)
+		if err != nil {
+			return ctlimg.PushSizeLimits{}, fmt.Errorf("Parsing --max-file-size: %s", err)
+		}
+	}
+	if f.MaxTotalSize != "" {
+		limits.MaxTotalSize, err = parseByteSize(f.MaxTotalSize)
+		if err != nil {
+			return ctlimg.PushSizeLimits{}, fmt.Errorf("Parsing --max-total-size: %s", err)
+		}
+	}
+	return limits, nil
+}
+
+// AsSecretScanOpts returns how the files of the image are scanned for secrets
+func (f *FileFlags) AsSecretScanOpts() (ctlimg.SecretScanOpts, error) {
+	if len(f.ScanSecretsAllow) > 0 && !f.ScanSecrets {
+		return ctlimg.SecretScanOpts{}, fmt.Errorf("Expected --scan-secrets when --scan-secrets-allow is provided")
+	}
+	for _, glob := range f.ScanSecretsAllow {
+		if _, err := path.Match(glob, ""); err != nil {
+			return ctlimg.SecretScanOpts{}, fmt.Errorf("Expected --scan-secrets-allow '%s' to be a glob: %s", glob, err)
+		}
+	}
+	return ctlimg.SecretScanOpts{Enabled: f.ScanSecrets, AllowedPaths: f.ScanSecretsAllow}, nil
+}
+
+// CloneFromGit clones the repository of --from-git, nil is returned when it is not provided. The clone has to be removed
+func (f *FileFlags) CloneFromGit() (*gitClone, error) {
+	if f.FromGit == "" {
+		return nil, nil
+	}
+	source, err := parseGitSource(f.FromGit)
+	if err != nil {
+		return nil, err
+	}
+	return cloneGitSource(source)
+}
 
-	cmd.Flags().BoolVar(&f.PreservePermissions, "preserve-permissions", false, "Preserve the group and all permissions of all the files and folders")
+// OpenFromTar opens the tar of --from-tar, nil is returned when it is not provided
+func (f *FileFlags) OpenFromTar() (*ctlimg.TarStream, error) {
+	if f.FromTar == "" {
+		return nil, nil
+	}
+	return ctlimg.OpenTarStream(f.FromTar, fromTarStdin)
 }
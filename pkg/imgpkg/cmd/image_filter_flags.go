@@ -0,0 +1,107 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/spf13/cobra"
+)
+
+// ImageFilterFlags select the images of a bundle, or of an images lock, that are copied
+type ImageFilterFlags struct {
+	IncludeImages []string
+	ExcludeImages []string
+}
+
+func (f *ImageFilterFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.IncludeImages, "include-image", nil, "Only copy the images of the bundle, or of the images lock (--lock), "+
+		"whose repository, or a repository it is in, matches the glob, '**' matching any number of path segments, or whose digest is provided. "+
+		"Bundles are always copied, the images omitted being recorded in their locations and in --lock-output "+
+		"(format: index.docker.io/library/postgres, **/postgres, sha256:<hex>) (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&f.ExcludeImages, "exclude-image", nil, "Do not copy the images of the bundle, or of the images lock (--lock), "+
+		"whose repository, or a repository it is in, matches the glob, or whose digest is provided, even when they are included by --include-image "+
+		"(format: index.docker.io/library/postgres, **/postgres, sha256:<hex>) (can be specified multiple times)")
+}
+
+// IsSet returns true when images are filtered
+func (f ImageFilterFlags) IsSet() bool {
+	return len(f.IncludeImages) > 0 || len(f.ExcludeImages) > 0
+}
+
+// Filter parses the patterns of --include-image and --exclude-image
+func (f ImageFilterFlags) Filter() (imageFilter, error) {
+	include, err := newImageMatcher(f.IncludeImages)
+	if err != nil {
+		return imageFilter{}, fmt.Errorf("Parsing --include-image: %s", err)
+	}
+	exclude, err := newImageMatcher(f.ExcludeImages)
+	if err != nil {
+		return imageFilter{}, fmt.Errorf("Parsing --exclude-image: %s", err)
+	}
+	return imageFilter{include: include, exclude: exclude}, nil
+}
+
+// imageFilter images selected by --include-image and --exclude-image, every image when none is provided
+type imageFilter struct {
+	include imageMatcher
+	exclude imageMatcher
+}
+
+// Includes returns true when the image at digestRef is copied
+func (f imageFilter) Includes(digestRef string) bool {
+	digest, err := regname.NewDigest(digestRef, regname.WeakValidation)
+	if err != nil {
+		panic(fmt.Sprintf("Internal inconsistency: '%s' should be a digest", digestRef))
+	}
+	if !f.include.empty() && !f.include.matches(digest) {
+		return false
+	}
+	return !f.exclude.matches(digest)
+}
+
+// imageMatcher matches images by their repository, or their digest
+type imageMatcher struct {
+	repos   ctlimg.PathGlobs
+	digests map[string]bool
+}
+
+func newImageMatcher(patterns []string) (imageMatcher, error) {
+	matcher := imageMatcher{digests: map[string]bool{}}
+	var repoPatterns []string
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "sha256:") {
+			if _, err := regv1.NewHash(pattern); err != nil {
+				return imageMatcher{}, fmt.Errorf("Invalid digest '%s': %s", pattern, err)
+			}
+			matcher.digests[pattern] = true
+			continue
+		}
+		repoPatterns = append(repoPatterns, pattern)
+	}
+
+	repos, err := ctlimg.NewPathGlobs(repoPatterns)
+	if err != nil {
+		return imageMatcher{}, err
+	}
+	matcher.repos = repos
+	return matcher, nil
+}
+
+func (m imageMatcher) empty() bool {
+	return m.repos.Empty() && len(m.digests) == 0
+}
+
+// matches the digest of the image, or its repository, as written and with the defaults of the registry
+func (m imageMatcher) matches(digest regname.Digest) bool {
+	if m.digests[digest.DigestStr()] {
+		return true
+	}
+	asWritten := strings.SplitN(digest.String(), "@", 2)[0]
+	return len(m.repos.MatchedPatterns(asWritten)) > 0 || len(m.repos.MatchedPatterns(digest.Context().Name())) > 0
+}
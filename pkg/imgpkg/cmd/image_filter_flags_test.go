@@ -0,0 +1,49 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageFilterFlagsFilter(t *testing.T) {
+	digest := "sha256:" + "a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2c3d4e5f6a1b2"
+	postgres := "index.docker.io/library/postgres@" + digest
+	redis := "my.registry.io/team/redis@sha256:" + "0000000000000000000000000000000000000000000000000000000000000001"
+
+	testCases := []struct {
+		name     string
+		flags    ImageFilterFlags
+		included []string
+		excluded []string
+	}{
+		{name: "includes every image by default", included: []string{postgres, redis}},
+		{name: "includes the repositories matching the glob", flags: ImageFilterFlags{IncludeImages: []string{"**/postgres"}},
+			included: []string{postgres}, excluded: []string{redis}},
+		{name: "matches the repository with the defaults of the registry", flags: ImageFilterFlags{IncludeImages: []string{"index.docker.io/library/postgres"}},
+			included: []string{"postgres@" + digest}, excluded: []string{redis}},
+		{name: "includes the digests provided", flags: ImageFilterFlags{IncludeImages: []string{digest}},
+			included: []string{postgres}, excluded: []string{redis}},
+		{name: "excludes images even when they are included", flags: ImageFilterFlags{IncludeImages: []string{"**"}, ExcludeImages: []string{"my.registry.io/team/*"}},
+			included: []string{postgres}, excluded: []string{redis}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := tc.flags.Filter()
+			require.NoError(t, err)
+			for _, image := range tc.included {
+				assert.True(t, filter.Includes(image), image)
+			}
+			for _, image := range tc.excluded {
+				assert.False(t, filter.Includes(image), image)
+			}
+		})
+	}
+
+	_, err := ImageFilterFlags{ExcludeImages: []string{"sha256:abc"}}.Filter()
+	require.ErrorContains(t, err, "Parsing --exclude-image: Invalid digest 'sha256:abc'")
+}
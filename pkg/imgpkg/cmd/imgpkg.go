@@ -47,8 +47,8 @@ func NewImgpkgCmd(o *ImgpkgOptions) *cobra.Command {
 	o.UIFlags.Set(cmd)
 	o.DebugFlags.Set(cmd)
 
-	cmd.AddCommand(NewPushCmd(NewPushOptions(o.ui)))
-	cmd.AddCommand(NewPullCmd(NewPullOptions(o.ui)))
+	cmd.AddCommand(NewPushCmd(NewPushOptions(o.ui, &o.UIFlags)))
+	cmd.AddCommand(NewPullCmd(NewPullOptions(o.ui, &o.UIFlags)))
 	cmd.AddCommand(NewVersionCmd(NewVersionOptions(o.ui)))
 	cmd.AddCommand(NewCopyCmd(NewCopyOptions(o.ui)))
 	cmd.AddCommand(NewDescribeCmd(NewDescribeOptions(o.ui)))
@@ -58,6 +58,10 @@ func NewImgpkgCmd(o *ImgpkgOptions) *cobra.Command {
 	tagCmd.AddCommand(NewTagResolveCmd(NewTagResolveOptions(o.ui)))
 	cmd.AddCommand(tagCmd)
 
+	cacheCmd := NewCacheCmd()
+	cacheCmd.AddCommand(NewCachePruneCmd(NewCachePruneOptions(o.ui)))
+	cmd.AddCommand(cacheCmd)
+
 	// Last one runs first
 	cobrautil.VisitCommands(cmd, cobrautil.ReconfigureCmdWithSubcmd)
 	cobrautil.VisitCommands(cmd, cobrautil.DisallowExtraArgs)
@@ -66,7 +70,20 @@ func NewImgpkgCmd(o *ImgpkgOptions) *cobra.Command {
 	// This configurations forces all nodes to do not accept extra args, but the completion requires 1 extra arg
 	cmd.AddCommand(NewCompletionCmd())
 
-	cobrautil.VisitCommands(cmd, cobrautil.WrapRunEForCmd(func(*cobra.Command, []string) error {
+	cobrautil.VisitCommands(cmd, cobrautil.WrapRunEForCmd(func(cmd *cobra.Command, _ []string) error {
+		if reservesStdout(cmd) {
+			// the image contents, the lock or the digest are written to stdout, everything else goes to stderr to keep
+			// the stream clean
+			*o.ui = *ui.NewWrappingConfUI(ui.NewPaddingUI(ui.NewWriterUI(os.Stderr, os.Stderr, ui.NewNoopLogger())), ui.NewNoopLogger())
+		}
+
+		// Deprecation warning section
+		_, found := os.LookupEnv("IMGPKG_ENABLE_IAAS_AUTH")
+		if found {
+			o.ui.PrintLinef("IMGPKG_ENABLE_IAAS_AUTH environment variable will be deprecated, please use the flag --activate-keychain to activate the needed keychains")
+		}
+		// End
+
 		o.UIFlags.ConfigureUI(o.ui)
 		o.DebugFlags.ConfigureDebug()
 		return nil
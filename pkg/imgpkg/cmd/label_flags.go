@@ -4,15 +4,52 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
 )
 
 // LabelFlags is a struct that holds the labels for an OCI artifact
 type LabelFlags struct {
 	Labels map[string]string
+	// LabelValues labels provided one by one, the value is read from a file when it starts with '@'
+	LabelValues []string
 }
 
 // Set sets the labels for an OCI artifact
 func (l *LabelFlags) Set(cmd *cobra.Command) {
 	cmd.Flags().StringToStringVarP(&l.Labels, "labels", "l", map[string]string{}, "Set labels on image")
+	cmd.Flags().StringArrayVar(&l.LabelValues, "label", nil, "Set label on image, the value is read from a file with key=@path "+
+		"(format: key=value) (can be specified multiple times)")
+}
+
+// AsLabels returns the labels of --labels and --label, a key can only be provided once
+func (l *LabelFlags) AsLabels() (map[string]string, error) {
+	labels := map[string]string{}
+	for key, value := range l.Labels {
+		labels[key] = value
+	}
+
+	for _, label := range l.LabelValues {
+		key, value, found := strings.Cut(label, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("Expected --label '%s' to be in the format key=value", label)
+		}
+		if _, present := labels[key]; present {
+			return nil, fmt.Errorf("Expected label '%s' to be provided only once", key)
+		}
+
+		if path, fromFile := strings.CutPrefix(value, "@"); fromFile {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("Reading the value of label '%s': %s", key, err)
+			}
+			// editors end files with a new line that is not part of the value
+			value = strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+		}
+		labels[key] = value
+	}
+	return labels, nil
 }
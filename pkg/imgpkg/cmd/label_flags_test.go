@@ -0,0 +1,77 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelFlagsAsLabels(t *testing.T) {
+	valueFile := filepath.Join(t.TempDir(), "build-url")
+	require.NoError(t, os.WriteFile(valueFile, []byte("https://ci.example.com/builds/42\n"), 0600))
+
+	t.Run("merges --labels and --label", func(t *testing.T) {
+		flags := LabelFlags{Labels: map[string]string{"team": "platform"}, LabelValues: []string{"git-sha=abc123", "empty="}}
+		labels, err := flags.AsLabels()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"team": "platform", "git-sha": "abc123", "empty": ""}, labels)
+	})
+
+	t.Run("keeps the separators of the value", func(t *testing.T) {
+		flags := LabelFlags{LabelValues: []string{"query=a=b,c=d"}}
+		labels, err := flags.AsLabels()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"query": "a=b,c=d"}, labels)
+	})
+
+	t.Run("reads the value from a file", func(t *testing.T) {
+		flags := LabelFlags{LabelValues: []string{"build-url=@" + valueFile}}
+		labels, err := flags.AsLabels()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"build-url": "https://ci.example.com/builds/42"}, labels)
+	})
+
+	errorCases := []struct {
+		name          string
+		flags         LabelFlags
+		expectedError string
+	}{
+		{
+			name:          "duplicate --label",
+			flags:         LabelFlags{LabelValues: []string{"team=a", "team=b"}},
+			expectedError: "Expected label 'team' to be provided only once",
+		},
+		{
+			name:          "key provided by --labels and --label",
+			flags:         LabelFlags{Labels: map[string]string{"team": "a"}, LabelValues: []string{"team=b"}},
+			expectedError: "Expected label 'team' to be provided only once",
+		},
+		{
+			name:          "missing value",
+			flags:         LabelFlags{LabelValues: []string{"team"}},
+			expectedError: "Expected --label 'team' to be in the format key=value",
+		},
+		{
+			name:          "missing key",
+			flags:         LabelFlags{LabelValues: []string{"=value"}},
+			expectedError: "Expected --label '=value' to be in the format key=value",
+		},
+		{
+			name:          "missing file",
+			flags:         LabelFlags{LabelValues: []string{"notes=@" + filepath.Join(t.TempDir(), "missing")}},
+			expectedError: "Reading the value of label 'notes'",
+		},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.flags.AsLabels()
+			require.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
@@ -4,21 +4,71 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"os"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	"github.com/spf13/cobra"
 )
 
+// lockOutputStdout is where the lock is written when the lock output is -
+var lockOutputStdout io.Writer = os.Stdout
+
 type LockOutputFlags struct {
 	LockFilePath string
+	// Format of the lock written, yaml or json
+	Format string
+}
+
+// lockFile lock written to the lock output
+type lockFile interface {
+	AsBytesInFormat(format lockconfig.LockFormat) ([]byte, error)
+	WriteToPathInFormat(path string, format lockconfig.LockFormat) error
 }
 
 // SetOnCopy Sets the lock-output flag for Copy command
 func (l *LockOutputFlags) SetOnCopy(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&l.LockFilePath, "lock-output", "",
-		"Location to output the generated lockfile. Option only available when using --bundle or --lock flags")
+		"Location to output the generated lockfile, or - to write it to stdout. Option only available when using --bundle or --lock flags")
+	l.setFormat(cmd)
 }
 
 // SetOnPush Sets the lock-output flag for Push command
 func (l *LockOutputFlags) SetOnPush(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&l.LockFilePath, "lock-output", "",
-		"Location to output the generated lockfile. Option only available when using --bundle flag")
+		"Location to output the generated lockfile, or - to write it to stdout. Option only available when using --bundle flag")
+	l.setFormat(cmd)
+}
+
+func (l *LockOutputFlags) setFormat(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&l.Format, "lock-output-format", string(lockconfig.YAMLLockFormat),
+		"Format of the lockfile written to --lock-output (yaml, json)")
+}
+
+// LockFormat returns the format selected for the lock
+func (l LockOutputFlags) LockFormat() (lockconfig.LockFormat, error) {
+	return lockconfig.NewLockFormat(l.Format)
+}
+
+// WriteLock writes lock in the format selected to the lock output, stdout when it is -
+func (l LockOutputFlags) WriteLock(lock lockFile) error {
+	format, err := l.LockFormat()
+	if err != nil {
+		return err
+	}
+
+	if l.LockFilePath != stdoutOutputPath {
+		return lock.WriteToPathInFormat(l.LockFilePath, format)
+	}
+
+	bs, err := lock.AsBytesInFormat(format)
+	if err != nil {
+		return err
+	}
+	_, err = lockOutputStdout.Write(bs)
+	if err != nil {
+		return fmt.Errorf("Writing lock to stdout: %s", err)
+	}
+	return nil
 }
@@ -0,0 +1,48 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// progressFormatText progress displayed for people, as bars with --tty or as periodic lines
+	progressFormatText = "text"
+	// progressFormatJSONLines progress written to stderr as newline-delimited JSON events, for CI systems
+	progressFormatJSONLines = "json-lines"
+)
+
+// progressEventsLog is where the events of --progress-format json-lines are written
+var progressEventsLog io.Writer = os.Stderr
+
+// ProgressFormatFlags format of the progress of push, copy and pull, which write the same events so that a single
+// consumer handles the three commands
+type ProgressFormatFlags struct {
+	ProgressFormat string
+}
+
+// Set sets the progress-format flag
+func (p *ProgressFormatFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&p.ProgressFormat, "progress-format", progressFormatText, "Format of the progress, text, or json-lines to write to stderr "+
+		"one JSON event per line, with the phase, the bytes of each blob transferred and the retries, followed by a done, or failed, event "+
+		"with the digest and the stats, stdout keeping the other outputs")
+}
+
+// EventStream returns the stream the events of command are written to with json-lines, nil otherwise
+func (p ProgressFormatFlags) EventStream(command string) (*util.EventStream, error) {
+	switch p.ProgressFormat {
+	case "", progressFormatText:
+		return nil, nil
+	case progressFormatJSONLines:
+		return util.NewEventStream(progressEventsLog, command), nil
+	default:
+		return nil, fmt.Errorf("Expected --progress-format to be %s or %s, was '%s'", progressFormatText, progressFormatJSONLines, p.ProgressFormat)
+	}
+}
@@ -6,28 +6,64 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	v1 "carvel.dev/imgpkg/pkg/imgpkg/v1"
 	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 )
 
+// stdoutOutputPath output path used to write the image contents as a tar to stdout
+const stdoutOutputPath = "-"
+
 type PullOptions struct {
-	ui ui.UI
+	ui      ui.UI
+	uiFlags *UIFlags
+	// events stream of --progress-format json-lines, nil otherwise
+	events *util.EventStream
+	// imagesPulled number of images of --images-file pulled, reported by the terminal event
+	imagesPulled int
 
 	ImageFlags           ImageFlags
 	ImageIsBundleCheck   bool
 	RegistryFlags        RegistryFlags
 	BundleFlags          BundleFlags
 	LockInputFlags       LockInputFlags
+	DigestFileFlags      DigestFileFlags
 	BundleRecursiveFlags BundleRecursiveFlags
+	ExtractFlags         ExtractFlags
 	OutputPath           string
+	DryRun               bool
+	File                 string
+	Layer                string
+	Uncompressed         bool
+	OCILayoutPath        string
+	IncludeIndex         bool
+	Platform             string
+	ImagesFile           string
+	ImagesConcurrency    int
+	FailFast             bool
+	LayersDir            string
+	ExpectedDigest       string
+	TarPath              string
+	LockOutputFormat     string
+
+	ProgressFormatFlags ProgressFormatFlags
 }
 
-func NewPullOptions(ui ui.UI) *PullOptions {
-	return &PullOptions{ui: ui}
+// NewPullOptions constructor for PullOptions, the UI flags are used to select how the extraction progress is displayed
+func NewPullOptions(ui ui.UI, uiFlags *UIFlags) *PullOptions {
+	return &PullOptions{ui: ui, uiFlags: uiFlags}
 }
 
 func NewPullCmd(o *PullOptions) *cobra.Command {
@@ -40,22 +76,94 @@ func NewPullCmd(o *PullOptions) *cobra.Command {
   imgpkg pull -b repo/app1-bundle -o /tmp/app1-bundle
 
   # Pull image repo/app1-image and extract into /tmp/app1-image
-  imgpkg pull -i repo/app1-image -o /tmp/app1-image`,
+  imgpkg pull -i repo/app1-image -o /tmp/app1-image
+
+  # List the files that would be extracted from image repo/app1-image
+  imgpkg pull -i repo/app1-image --dry-run
+
+  # Write the contents of image repo/app1-image as a tar to stdout
+  imgpkg pull -i repo/app1-image -o - | tar -tv
+
+  # Write the file config/values.yml of image repo/app1-image to stdout
+  imgpkg pull -i repo/app1-image --file config/values.yml -o -
+
+  # Download one layer of image repo/app1-image
+  imgpkg pull -i repo/app1-image --layer sha256:<digest> -o layer.tar.gz
+
+  # Extract each layer of image repo/app1-image into its own directory of /tmp/app1-layers
+  imgpkg pull -i repo/app1-image --layers-dir /tmp/app1-layers
+
+  # Pull image repo/app1-image:v1 only when the tag still points to the vetted digest
+  imgpkg pull -i repo/app1-image:v1 --expected-digest sha256:<digest> -o /tmp/app1-image
+
+  # Pull the linux/arm64 image of the multi-platform image repo/app1-image
+  imgpkg pull -i repo/app1-image --platform linux/arm64 -o /tmp/app1-image
+
+  # Pull image repo/app1-image:v1 and write the reference of the image extracted, repo/app1-image@sha256:<hex>, to app1-image.digest
+  imgpkg pull -i repo/app1-image:v1 -o /tmp/app1-image --digest-file app1-image.digest
+
+  # Pull the images listed in images.yml, each one into its own output directory
+  imgpkg pull --images-file images.yml
+
+  # Pull the bundle copied with copy -b repo/app1-bundle --to-tar /tmp/app1-bundle.tar without a registry
+  imgpkg pull --tar /tmp/app1-bundle.tar -o /tmp/app1-bundle
+
+  # Save image repo/app1-image as an OCI image layout in /tmp/app1-layout
+  imgpkg pull -i repo/app1-image --to-oci-layout /tmp/app1-layout`,
 	}
 	o.ImageFlags.Set(cmd)
 	cmd.Flags().BoolVar(&o.ImageIsBundleCheck, "image-is-bundle-check", true, "Error when image is a bundle (disable pulling bundles via -i)")
 	o.RegistryFlags.Set(cmd)
+	o.RegistryFlags.SetBandwidthLimit(cmd)
 	o.BundleFlags.Set(cmd)
 	o.BundleRecursiveFlags.Set(cmd)
 	o.LockInputFlags.Set(cmd)
-	cmd.Flags().StringVarP(&o.OutputPath, "output", "o", "", "Output directory path")
-	cmd.MarkFlagRequired("output")
+	o.ExtractFlags.Set(cmd)
+	o.DigestFileFlags.SetOnPull(cmd)
+	o.ProgressFormatFlags.Set(cmd)
+	cmd.Flags().StringVarP(&o.OutputPath, "output", "o", "", "Output directory path, a .tar file or - for a tar written to stdout (required unless --dry-run is provided)")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", false, "List the files that would be extracted, with their size, mode and layer, without writing anything")
+	cmd.Flags().StringVar(&o.File, "file", "", "Path in the image of a file whose content is written to stdout, used with -o -, nothing else is extracted. "+
+		"The immediate children of a directory are listed instead")
+	cmd.Flags().StringVar(&o.Layer, "layer", "", "Digest of a layer of the image to write to the output path, or - for stdout, without extracting it")
+	cmd.Flags().BoolVar(&o.Uncompressed, "uncompressed", false, "Write the tar of the layer instead of the compressed blob (used with --layer)")
+	cmd.Flags().StringVar(&o.OCILayoutPath, "to-oci-layout", "", "Directory where the image is written as an OCI image layout, with its manifest, config and compressed layers, instead of extracting it")
+	cmd.Flags().BoolVar(&o.IncludeIndex, "include-index", false, "Write image indexes with all the images they reference (used with --to-oci-layout)")
+	cmd.Flags().StringVar(&o.ImagesFile, "images-file", "", "YAML file listing images to pull and the directory each one is extracted to, instead of -i and --output "+
+		"(format: images: [{image: repo/app1, output: /tmp/app1}])")
+	cmd.Flags().IntVar(&o.ImagesConcurrency, "images-concurrency", 3, "Number of images of --images-file pulled at the same time")
+	cmd.Flags().BoolVar(&o.FailFast, "fail-fast", false, "Stop pulling the images of --images-file that did not start yet once one of them fails")
+	cmd.Flags().StringVar(&o.LayersDir, "layers-dir", "", "Directory where each layer of the image is extracted on its own, into <index>-<short digest> directories "+
+		"described by "+ctlimg.LayersFile+", without applying the whiteouts of newer layers (can be used with or without --output)")
+	cmd.Flags().StringVar(&o.ExpectedDigest, "expected-digest", "", "Fail without extracting when the image or bundle does not resolve to this digest, "+
+		"--lock with an ImagesLock file can be used instead to take the digest recorded for the repository (format: sha256:<hex>)")
+	cmd.Flags().StringVar(&o.TarPath, "tar", "", "Tar created by copy --to-tar to pull the image or bundle from instead of a registry, "+
+		"the reference can be omitted when the tar was created with -b")
+	cmd.Flags().StringVar(&o.LockOutputFormat, "lock-output-format", string(lockconfig.YAMLLockFormat),
+		"Format of the images lock rewritten in .imgpkg/images.yml when the images of the bundle are in its repository (yaml, json)")
+	cmd.Flags().StringVar(&o.Platform, "platform", runtime.GOOS+"/"+runtime.GOARCH, "Platform of the image pulled when the reference points to an image index (format: os/arch[/variant])")
 
 	return cmd
 }
 
-func (po *PullOptions) Run() error {
-	err := po.validate()
+func (po *PullOptions) Run() (err error) {
+	po.events, err = po.ProgressFormatFlags.EventStream("pull")
+	if err != nil {
+		return err
+	}
+	// status is only filled when extracting into the output directory
+	var status v1.PullStatus
+	extractStats := po.extractStats()
+	defer func() {
+		po.finishEvents(status, extractStats, err)
+	}()
+
+	err = po.validate()
+	if err != nil {
+		return err
+	}
+	po.RegistryFlags.events = po.events
+	err = po.RegistryFlags.limitBandwidth()
 	if err != nil {
 		return err
 	}
@@ -63,14 +171,15 @@ func (po *PullOptions) Run() error {
 	levelLogger := util.NewUILevelLogger(util.LogWarn, util.NewLogger(po.ui))
 	imageRef := ""
 	switch {
-	case len(po.LockInputFlags.LockFilePath) > 0:
-		if len(po.LockInputFlags.LockFilePath) > 0 {
-			bundleLock, err := lockconfig.NewBundleLockFromPath(po.LockInputFlags.LockFilePath)
-			if err != nil {
-				return err
-			}
-			imageRef = bundleLock.Bundle.Image
+	case len(po.ImagesFile) > 0:
+	case po.TarPath != "" && len(po.BundleFlags.Bundle) == 0 && len(po.ImageFlags.Image) == 0:
+		// the bundle copied into the tar is pulled
+	case len(po.LockInputFlags.LockFilePath) > 0 && !po.verifiesWithLock():
+		bundleLock, err := lockconfig.NewBundleLockFromPath(po.LockInputFlags.LockFilePath)
+		if err != nil {
+			return err
 		}
+		imageRef = bundleLock.Bundle.Image
 	case len(po.BundleFlags.Bundle) > 0:
 		imageRef = po.BundleFlags.Bundle
 	case len(po.ImageFlags.Image) > 0:
@@ -79,43 +188,427 @@ func (po *PullOptions) Run() error {
 		panic("Unreachable code")
 	}
 
+	providedRef := imageRef
+	if po.verifiesDigest() {
+		imageRef, err = po.verifyDigest(imageRef)
+		if err != nil {
+			return err
+		}
+	}
+
+	extractOpts, err := po.ExtractFlags.AsDirImageOpts()
+	if err != nil {
+		return err
+	}
+	// layers whose download fails while they are extracted are extracted again, the same way requests are retried
+	extractOpts.LayerRetries = po.RegistryFlags.RetryCount
+	extractOpts.LayerRetryBackoff = po.RegistryFlags.RetryBackoff
+
+	platform, err := po.platform()
+	if err != nil {
+		return err
+	}
+
+	imagesLockFormat, err := lockconfig.NewLockFormat(po.LockOutputFormat)
+	if err != nil {
+		return err
+	}
+
 	pullOpts := v1.PullOpts{
 		Logger:   levelLogger,
 		AsImage:  !po.ImageIsBundleCheck,
 		IsBundle: len(po.ImageFlags.Image) == 0,
+		Platform: platform,
+
+		ExtractOpts:      extractOpts,
+		LayersDir:        po.LayersDir,
+		ImagesLockFormat: imagesLockFormat,
 	}
-	if po.BundleRecursiveFlags.Recursive {
-		_, err = v1.PullRecursive(imageRef, po.OutputPath, pullOpts, po.RegistryFlags.AsRegistryOpts())
+	po.events.Phase("extract", imageRef)
+	if po.ImagesFile != "" {
+		pullOpts.IsBundle = false
+		err = po.pullImages(pullOpts)
+	} else if po.DryRun {
+		err = po.listEntries(imageRef, pullOpts)
+	} else if po.OCILayoutPath != "" {
+		layoutOpts := v1.PullOCILayoutOpts{IncludeIndex: po.IncludeIndex}
+		err = v1.PullToOCILayout(imageRef, po.OCILayoutPath, pullOpts, layoutOpts, po.RegistryFlags.AsRegistryOpts())
+	} else if po.Layer != "" {
+		layerOpts := v1.PullLayerOpts{Digest: po.Layer, Uncompressed: po.Uncompressed, Platform: platform}
+		err = po.writeOutput(func(out io.Writer) error {
+			return v1.PullLayer(imageRef, out, layerOpts, po.RegistryFlags.AsRegistryOpts())
+		})
+	} else if po.File != "" {
+		err = po.writeOutput(func(out io.Writer) error {
+			return v1.PullFile(imageRef, po.File, out, pullOpts, po.RegistryFlags.AsRegistryOpts())
+		})
+		var notFound ctlimg.FileNotFoundError
+		if errors.As(err, &notFound) {
+			err = newCodedError(ErrorCodeNotFound, err)
+		}
+	} else if po.tarOutput() {
+		pullOpts.ExtractOpts.Progress = po.progressLogger(levelLogger)
+		if po.OutputPath != stdoutOutputPath {
+			// the layers are kept next to the tar until it is written
+			pullOpts.ExtractOpts.SpoolDir = filepath.Dir(po.OutputPath)
+		}
+		err = po.pullAsTar(imageRef, pullOpts)
+	} else if po.TarPath != "" {
+		pullOpts.ExtractOpts.Progress = po.progressLogger(levelLogger)
+		pullOpts.ExtractOpts.Stats = extractStats
+		pullOpts.NestedBundlesMaxDepth = po.BundleRecursiveFlags.MaxDepth
+		if po.BundleRecursiveFlags.Recursive {
+			status, err = v1.PullRecursiveFromTar(po.TarPath, imageRef, po.OutputPath, pullOpts)
+		} else {
+			status, err = v1.PullFromTar(po.TarPath, imageRef, po.OutputPath, pullOpts)
+		}
+	} else if po.BundleRecursiveFlags.Recursive {
+		pullOpts.ExtractOpts.Progress = po.progressLogger(levelLogger)
+		pullOpts.ExtractOpts.Stats = extractStats
+		pullOpts.NestedBundlesMaxDepth = po.BundleRecursiveFlags.MaxDepth
+		status, err = v1.PullRecursive(imageRef, po.OutputPath, pullOpts, po.RegistryFlags.AsRegistryOpts())
 	} else {
-		_, err = v1.Pull(imageRef, po.OutputPath, pullOpts, po.RegistryFlags.AsRegistryOpts())
+		pullOpts.ExtractOpts.Progress = po.progressLogger(levelLogger)
+		pullOpts.ExtractOpts.Stats = extractStats
+		status, err = v1.Pull(imageRef, po.OutputPath, pullOpts, po.RegistryFlags.AsRegistryOpts())
 	}
 
 	if errors.Is(err, &v1.ErrIsBundle{}) {
 		if len(po.ImageFlags.Image) == 0 {
 			if po.ImageIsBundleCheck {
-				return fmt.Errorf("Expected bundle flag when pulling a bundle (hint: Use -b instead of -i for bundles)")
+				return newCodedError(ErrorCodeIsBundle, fmt.Errorf("Expected bundle flag when pulling a bundle (hint: Use -b instead of -i for bundles)"))
 			}
 		} else {
-			return fmt.Errorf("Expected bundle flag when pulling a bundle (hint: Use -b instead of -i for bundles)")
+			return newCodedError(ErrorCodeIsBundle, fmt.Errorf("Expected bundle flag when pulling a bundle (hint: Use -b instead of -i for bundles)"))
 		}
 	} else if len(po.ImageFlags.Image) == 0 && errors.Is(err, &v1.ErrIsNotBundle{}) {
-		return fmt.Errorf("Expected bundle image but found plain image (hint: Did you use -i instead of -b?)")
+		return newCodedError(ErrorCodeIsNotBundle, fmt.Errorf("Expected bundle image but found plain image (hint: Did you use -i instead of -b?)"))
 	}
 
+	if err == nil && po.verifiesDigest() {
+		po.printVerifiedDigest(providedRef, imageRef)
+	}
+	if err == nil {
+		po.printExtractStats(pullOpts.ExtractOpts.Stats)
+		po.printPullResult(providedRef, status, pullOpts.ExtractOpts.Stats)
+		err = po.DigestFileFlags.WriteDigest(status.ImageRef)
+	}
 	return err
 }
 
+// platform parses the platform used to select the image from image indexes, none is used when the flag is empty
+func (po *PullOptions) platform() (*regv1.Platform, error) {
+	if po.Platform == "" {
+		return nil, nil
+	}
+	platform, err := regv1.ParsePlatform(po.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing --platform: %s", err)
+	}
+	if platform.OS == "" || platform.Architecture == "" {
+		return nil, fmt.Errorf("Expected --platform '%s' to be in the format os/arch[/variant]", po.Platform)
+	}
+	return platform, nil
+}
+
+// listEntries prints the entries that would be extracted from the image, nested bundles are not listed
+func (po *PullOptions) listEntries(imageRef string, pullOpts v1.PullOpts) error {
+	entries, err := v1.ListEntries(imageRef, pullOpts, po.RegistryFlags.AsRegistryOpts())
+	if err != nil {
+		return err
+	}
+
+	table := uitable.Table{
+		Title:   "Files",
+		Content: "files",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Path"),
+			uitable.NewHeader("Size"),
+			uitable.NewHeader("Mode"),
+			uitable.NewHeader("Layer Digest"),
+		},
+
+		SortBy: []uitable.ColumnSort{
+			{Column: 0, Asc: true},
+		},
+	}
+
+	for _, entry := range entries {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(entry.Path),
+			uitable.NewValueInt(int(entry.Size)),
+			uitable.NewValueString(entry.Mode.String()),
+			uitable.NewValueString(entry.LayerDigest),
+		})
+	}
+
+	po.ui.PrintTable(table)
+
+	return nil
+}
+
+// pullAsTar writes the merged contents of the image as a tar to stdout or to the .tar file provided as output
+func (po *PullOptions) pullAsTar(imageRef string, pullOpts v1.PullOpts) error {
+	return po.writeOutput(func(out io.Writer) error {
+		return v1.PullAsTar(imageRef, out, pullOpts, po.RegistryFlags.AsRegistryOpts())
+	})
+}
+
+// writeOutput calls write with stdout, or with the file provided as output, which is removed when write fails
+func (po *PullOptions) writeOutput(write func(io.Writer) error) error {
+	if po.OutputPath == stdoutOutputPath {
+		return write(os.Stdout)
+	}
+
+	file, err := os.Create(po.OutputPath)
+	if err != nil {
+		return fmt.Errorf("Creating output file: %s", err)
+	}
+
+	err = write(file)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Closing output file: %s", closeErr)
+	}
+	if err != nil {
+		_ = os.Remove(po.OutputPath)
+		return err
+	}
+	return nil
+}
+
+// tarOutput checks if the image contents are written as a tar instead of being extracted to a directory
+func (po *PullOptions) tarOutput() bool {
+	return po.OutputPath == stdoutOutputPath || filepath.Ext(po.OutputPath) == ".tar"
+}
+
+// progressLogger selects how the progress of the layers extraction is displayed: the events of --progress-format
+// json-lines, JSON events when --json is provided, a progress bar when --tty is provided and periodic log lines otherwise
+func (po *PullOptions) progressLogger(levelLogger util.LoggerWithLevels) util.ProgressLogger {
+	uiFlags := UIFlags{}
+	if po.uiFlags != nil {
+		uiFlags = *po.uiFlags
+	}
+
+	switch {
+	case po.events != nil:
+		return po.events.NewProgress("", "", time.Second)
+	case uiFlags.JSON:
+		return util.NewProgressJSON(util.NewLoggerNoTTY(po.ui), time.Second)
+	case uiFlags.TTY && po.OutputPath == stdoutOutputPath:
+		// stdout only receives the tar, the progress bar is displayed in stderr
+		return util.NewTTYProgressBarWithOutput(levelLogger, os.Stderr, "", "Error extracting layer")
+	case uiFlags.TTY:
+		return util.NewTTYProgressBar(levelLogger, "", "Error extracting layer")
+	default:
+		return util.NewProgressLines(util.NewLoggerNoTTY(po.ui), "Extracted", 10*time.Second)
+	}
+}
+
 func (po *PullOptions) validate() error {
+	if po.BundleRecursiveFlags.MaxDepth < 0 {
+		return fmt.Errorf("Expected --max-depth to be 0 or more")
+	}
+	if po.BundleRecursiveFlags.MaxDepth > 0 && !po.BundleRecursiveFlags.Recursive {
+		return fmt.Errorf("Expected --recursive (-r) when --max-depth is provided")
+	}
+
+	if po.ImagesFile != "" {
+		switch {
+		case len(po.ImageFlags.Image) > 0 || len(po.BundleFlags.Bundle) > 0 || len(po.LockInputFlags.LockFilePath) > 0:
+			return fmt.Errorf("Expected only one of image, bundle, lock or --images-file")
+		case po.OutputPath != "":
+			return fmt.Errorf("Expected the output directories to be listed in --images-file instead of --output")
+		case po.DryRun || po.File != "" || po.Layer != "" || po.LayersDir != "" || po.OCILayoutPath != "" || po.TarPath != "" || po.BundleRecursiveFlags.Recursive:
+			return fmt.Errorf("Cannot use --images-file with --dry-run, --file, --layer, --layers-dir, --to-oci-layout, --tar or --recursive (-r)")
+		case po.ExtractFlags.MetadataDir != "" || po.ExtractFlags.ChecksumsOutput != "":
+			// every image would write to the same path
+			return fmt.Errorf("Cannot use --images-file with --metadata-dir or --checksums-output")
+		case po.ExpectedDigest != "" || po.DigestFileFlags.DigestFilePath != "":
+			return fmt.Errorf("Cannot use --images-file with --expected-digest or --digest-file")
+		case po.ImagesConcurrency < 1:
+			return fmt.Errorf("Expected --images-concurrency to be greater than 0")
+		}
+		return nil
+	}
+	if po.FailFast {
+		return fmt.Errorf("Expected --images-file when --fail-fast is provided")
+	}
+
+	if po.DigestFileFlags.DigestFilePath != "" && (po.DryRun || po.File != "" || po.Layer != "" || po.OCILayoutPath != "" || po.OutputPath == "" || po.tarOutput()) {
+		return fmt.Errorf("Expected --digest-file to only be used when extracting into a directory")
+	}
+
+	if po.TarPath != "" {
+		switch {
+		case po.DryRun || po.File != "" || po.Layer != "" || po.LayersDir != "" || po.OCILayoutPath != "":
+			return fmt.Errorf("Cannot use --tar with --dry-run, --file, --layer, --layers-dir or --to-oci-layout")
+		case po.ExpectedDigest != "" || len(po.LockInputFlags.LockFilePath) > 0:
+			// the digests of the content of the tar are verified against the digests of the tar
+			return fmt.Errorf("Cannot use --tar with --expected-digest or --lock")
+		case po.OutputPath == "" || po.tarOutput():
+			return fmt.Errorf("Expected --output to be a directory when --tar is provided")
+		}
+	}
+
+	if po.ExpectedDigest != "" {
+		if _, err := regv1.NewHash(po.ExpectedDigest); err != nil {
+			return fmt.Errorf("Expected --expected-digest '%s' to be a digest (format: sha256:<hex>)", po.ExpectedDigest)
+		}
+		if po.verifiesWithLock() {
+			return fmt.Errorf("Expected only one of --expected-digest or --lock to verify the image digest")
+		}
+	}
+
+	if po.LayersDir != "" {
+		err := po.validateLayersDir()
+		if err != nil || po.OutputPath == "" {
+			return err
+		}
+	}
+
+	if po.File != "" {
+		switch {
+		case po.OutputPath != stdoutOutputPath:
+			return fmt.Errorf("Expected -o - when --file is provided, the content of the file is written to stdout")
+		case po.DryRun || po.Layer != "" || po.OCILayoutPath != "" || po.LayersDir != "" || po.BundleRecursiveFlags.Recursive:
+			return fmt.Errorf("Cannot use --file with --dry-run, --layer, --to-oci-layout, --layers-dir or --recursive (-r)")
+		}
+		return po.validateInput()
+	}
+
+	if po.Uncompressed && po.Layer == "" {
+		return fmt.Errorf("Expected --layer when --uncompressed is provided")
+	}
+
+	if po.ExtractFlags.ChecksumsOutput != "" && (po.OCILayoutPath != "" || po.Layer != "" || po.DryRun || po.tarOutput()) {
+		return fmt.Errorf("Expected --checksums-output to only be used when extracting into a directory")
+	}
+
+	if po.IncludeIndex && po.OCILayoutPath == "" {
+		return fmt.Errorf("Expected --to-oci-layout when --include-index is provided")
+	}
+
+	if po.OCILayoutPath != "" {
+		switch {
+		case po.OutputPath != "":
+			return fmt.Errorf("Expected only one of --output or --to-oci-layout")
+		case po.DryRun:
+			return fmt.Errorf("Cannot use --to-oci-layout with --dry-run")
+		case po.Layer != "":
+			return fmt.Errorf("Cannot use --to-oci-layout with --layer")
+		case po.BundleRecursiveFlags.Recursive:
+			return fmt.Errorf("Cannot use --recursive (-r) flag with --to-oci-layout")
+		case po.OCILayoutPath == "/":
+			return fmt.Errorf("Disallowed OCI image layout directory (trying to avoid accidental deletion)")
+		}
+		return po.validateInput()
+	}
+
+	if po.Layer != "" {
+		switch {
+		case po.DryRun:
+			return fmt.Errorf("Cannot use --layer with --dry-run")
+		case po.BundleRecursiveFlags.Recursive:
+			return fmt.Errorf("Cannot use --recursive (-r) flag with --layer")
+		case po.OutputPath == "":
+			return fmt.Errorf("Expected --output to be none empty")
+		}
+		return po.validateInput()
+	}
+
+	if po.DryRun {
+		if po.BundleRecursiveFlags.Recursive {
+			return fmt.Errorf("Cannot use --recursive (-r) flag with --dry-run")
+		}
+		return po.validateInput()
+	}
+
 	if po.OutputPath == "" {
 		return fmt.Errorf("Expected --output to be none empty")
 	}
 
-	if po.OutputPath == "/" || po.OutputPath == "." || po.OutputPath == ".." {
+	if po.BundleRecursiveFlags.Recursive && len(po.ExtractFlags.MetadataDir) > 0 {
+		// every bundle would write its metadata to the same directory
+		return fmt.Errorf("Cannot use --recursive (-r) flag with --metadata-dir")
+	}
+
+	if po.BundleRecursiveFlags.Recursive && len(po.ExtractFlags.ChecksumsOutput) > 0 {
+		// every bundle would write its checksums to the same file
+		return fmt.Errorf("Cannot use --recursive (-r) flag with --checksums-output")
+	}
+
+	if po.tarOutput() {
+		if po.BundleRecursiveFlags.Recursive {
+			return fmt.Errorf("Cannot use --recursive (-r) flag when writing a tar (-o - or a .tar file)")
+		}
+		return po.validateInput()
+	}
+
+	if po.OutputPath == "/" {
 		return fmt.Errorf("Disallowed output directory (trying to avoid accidental deletion)")
 	}
 
+	// the current and parent directories are only allowed when their content is not going to be removed
+	if !po.ExtractFlags.NoClean && (po.OutputPath == "." || po.OutputPath == "..") {
+		return fmt.Errorf("Disallowed output directory, trying to avoid accidental deletion (hint: Use --no-clean to extract without removing the existing content)")
+	}
+
+	return po.validateInput()
+}
+
+// validateLayersDir checks the flags used with --layers-dir, when no --output is provided the input is validated too
+func (po *PullOptions) validateLayersDir() error {
+	switch {
+	case po.DryRun || po.Layer != "" || po.OCILayoutPath != "":
+		return fmt.Errorf("Cannot use --layers-dir with --dry-run, --layer or --to-oci-layout")
+	case po.BundleRecursiveFlags.Recursive:
+		return fmt.Errorf("Cannot use --recursive (-r) flag with --layers-dir")
+	case len(po.BundleFlags.Bundle) > 0 || len(po.LockInputFlags.LockFilePath) > 0:
+		return fmt.Errorf("Cannot use --layers-dir when pulling a bundle (hint: Use -i with --image-is-bundle-check=false to extract the layers of the bundle image)")
+	case po.LayersDir == "/" || po.LayersDir == "." || po.LayersDir == "..":
+		// the content of the layers directory is always removed
+		return fmt.Errorf("Disallowed layers directory (trying to avoid accidental deletion)")
+	case po.OutputPath != "" && po.tarOutput():
+		return fmt.Errorf("Cannot use --layers-dir when writing a tar (-o - or a .tar file)")
+	}
+
+	if po.OutputPath == "" {
+		if po.ExtractFlags.Incremental || po.ExtractFlags.IncludeMetadata || len(po.ExtractFlags.IncludePaths) > 0 || len(po.ExtractFlags.ExcludedPaths) > 0 || po.ExtractFlags.ChecksumsOutput != "" {
+			return fmt.Errorf("Expected --output when --include-path, --file-exclusion, --incremental, --include-metadata or --checksums-output is provided, they do not apply to --layers-dir")
+		}
+		return po.validateInput()
+	}
+
+	output, err := filepath.Abs(po.OutputPath)
+	if err != nil {
+		return err
+	}
+	layersDir, err := filepath.Abs(po.LayersDir)
+	if err != nil {
+		return err
+	}
+	if isSubPath(output, layersDir) || isSubPath(layersDir, output) {
+		return fmt.Errorf("Expected --output and --layers-dir to not be inside of each other")
+	}
+	return nil
+}
+
+// isSubPath checks if path is dir or one of its children
+func isSubPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// validateInput checks the flags that select what is pulled
+func (po *PullOptions) validateInput() error {
+	inputParams := []string{po.LockInputFlags.LockFilePath, po.BundleFlags.Bundle, po.ImageFlags.Image}
+	if po.verifiesWithLock() {
+		// the lock is only used to verify the digest of the image or bundle
+		inputParams = inputParams[1:]
+	}
 	presentInputParams := 0
-	for _, inputParam := range []string{po.LockInputFlags.LockFilePath, po.BundleFlags.Bundle, po.ImageFlags.Image} {
+	for _, inputParam := range inputParams {
 		if len(inputParam) > 0 {
 			presentInputParams++
 		}
@@ -123,7 +616,8 @@ func (po *PullOptions) validate() error {
 	if presentInputParams > 1 {
 		return fmt.Errorf("Expected only one of image, bundle, or lock")
 	}
-	if presentInputParams == 0 {
+	// the bundle copied into the tar is pulled when there is no reference
+	if presentInputParams == 0 && po.TarPath == "" {
 		return fmt.Errorf("Expected either image or bundle reference")
 	}
 
@@ -136,3 +630,17 @@ func (po *PullOptions) validate() error {
 	}
 	return nil
 }
+
+// reservesStdout checks if the parsed flags of cmd make it write the image contents as a tar, the lock or the digest
+// to stdout, in which case nothing else can be written to stdout
+func reservesStdout(cmd *cobra.Command) bool {
+	writesToStdout := func(name string) bool {
+		flag := cmd.Flags().Lookup(name)
+		return flag != nil && flag.Value.String() == stdoutOutputPath
+	}
+	if writesToStdout("lock-output") || writesToStdout("digest-file") {
+		return true
+	}
+	dryRun := cmd.Flags().Lookup("dry-run")
+	return writesToStdout("output") && (dryRun == nil || dryRun.Value.String() != "true")
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	v1 "carvel.dev/imgpkg/pkg/imgpkg/v1"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"sigs.k8s.io/yaml"
+)
+
+// pullImagesFile images pulled by pull --images-file and the directories they are extracted to
+type pullImagesFile struct {
+	Images []pullImagesFileEntry `json:"images"`
+}
+
+type pullImagesFileEntry struct {
+	Image  string `json:"image"`
+	Output string `json:"output"`
+}
+
+// pullImageResult outcome of the pull of one of the images of --images-file
+type pullImageResult struct {
+	entry    pullImagesFileEntry
+	imageRef string
+	status   string
+	err      error
+}
+
+// readPullImagesFile reads and validates the images file, which looks like:
+//
+//	images:
+//	- image: registry.example.com/app1
+//	  output: /tmp/app1
+func (po *PullOptions) readPullImagesFile() (pullImagesFile, error) {
+	bs, err := os.ReadFile(po.ImagesFile)
+	if err != nil {
+		return pullImagesFile{}, fmt.Errorf("Reading images file: %s", err)
+	}
+
+	var imagesFile pullImagesFile
+	err = yaml.UnmarshalStrict(bs, &imagesFile)
+	if err != nil {
+		return pullImagesFile{}, fmt.Errorf("Unmarshaling images file '%s': %s", po.ImagesFile, err)
+	}
+
+	if len(imagesFile.Images) == 0 {
+		return pullImagesFile{}, fmt.Errorf("Expected images file '%s' to list at least one image", po.ImagesFile)
+	}
+
+	outputs := map[string]string{}
+	for _, entry := range imagesFile.Images {
+		switch {
+		case entry.Image == "":
+			return pullImagesFile{}, fmt.Errorf("Expected every image of images file '%s' to have an image reference", po.ImagesFile)
+		case entry.Output == "":
+			return pullImagesFile{}, fmt.Errorf("Expected image '%s' of images file '%s' to have an output directory", entry.Image, po.ImagesFile)
+		case entry.Output == stdoutOutputPath || filepath.Ext(entry.Output) == ".tar":
+			return pullImagesFile{}, fmt.Errorf("Expected output of image '%s' of images file '%s' to be a directory", entry.Image, po.ImagesFile)
+		case entry.Output == "/":
+			return pullImagesFile{}, fmt.Errorf("Disallowed output directory for image '%s' (trying to avoid accidental deletion)", entry.Image)
+		case !po.ExtractFlags.NoClean && (entry.Output == "." || entry.Output == ".."):
+			return pullImagesFile{}, fmt.Errorf("Disallowed output directory for image '%s', trying to avoid accidental deletion "+
+				"(hint: Use --no-clean to extract without removing the existing content)", entry.Image)
+		}
+
+		output := filepath.Clean(entry.Output)
+		if otherImage, found := outputs[output]; found {
+			return pullImagesFile{}, fmt.Errorf("Expected images '%s' and '%s' of images file '%s' to have different output directories", otherImage, entry.Image, po.ImagesFile)
+		}
+		outputs[output] = entry.Image
+	}
+	return imagesFile, nil
+}
+
+// pullImages pulls the images of --images-file, at most --images-concurrency at the same time, sharing the registry
+// connections and credentials. Every image is pulled even when some fail, unless --fail-fast is provided, in which
+// case the images that did not start yet are skipped after the first failure
+func (po *PullOptions) pullImages(pullOpts v1.PullOpts) error {
+	imagesFile, err := po.readPullImagesFile()
+	if err != nil {
+		return err
+	}
+
+	reg, err := registry.NewSimpleRegistry(po.RegistryFlags.AsRegistryOpts())
+	if err != nil {
+		return err
+	}
+
+	// the pulls log concurrently through the same ui
+	uiLock := &sync.Mutex{}
+	logger := &lockedLogger{logger: util.NewLogger(po.ui), lock: uiLock}
+	noTTYLogger := &lockedLogger{logger: util.NewLoggerNoTTY(po.ui), lock: uiLock}
+
+	results := make([]pullImageResult, len(imagesFile.Images))
+	failed := false
+	failedLock := &sync.Mutex{}
+
+	// the workers take the images in the order of the file
+	indexes := make(chan int, len(imagesFile.Images))
+	for idx := range imagesFile.Images {
+		indexes <- idx
+	}
+	close(indexes)
+
+	pull := func(idx int) {
+		entry := imagesFile.Images[idx]
+		results[idx] = pullImageResult{entry: entry, status: "skipped"}
+		failedLock.Lock()
+		skip := failed && po.FailFast
+		failedLock.Unlock()
+		if skip {
+			return
+		}
+
+		prefix := fmt.Sprintf("%s | ", entry.Image)
+		imagePullOpts := pullOpts
+		imagePullOpts.Logger = util.NewUILevelLogger(util.LogWarn, util.NewPrefixedLogger(prefix, logger))
+		switch {
+		case po.events != nil:
+			imagePullOpts.ExtractOpts.Progress = po.events.NewProgress(entry.Image, "", time.Second)
+		case po.uiFlags != nil && po.uiFlags.JSON:
+			imagePullOpts.ExtractOpts.Progress = util.NewImageProgressJSON(noTTYLogger, entry.Image, time.Second)
+		default:
+			// progress bars of concurrent pulls would overwrite each other
+			imagePullOpts.ExtractOpts.Progress = util.NewProgressLines(util.NewPrefixedLogger(prefix, noTTYLogger), "Extracted", 10*time.Second)
+		}
+
+		status, err := v1.PullWithRegistry(entry.Image, entry.Output, imagePullOpts, reg)
+		if err != nil {
+			imagePullOpts.Logger.Errorf("%s\n", err)
+			results[idx].status = "failed"
+			results[idx].err = err
+			failedLock.Lock()
+			failed = true
+			failedLock.Unlock()
+			return
+		}
+		results[idx].status = "pulled"
+		results[idx].imageRef = status.ImageRef
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < po.ImagesConcurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				pull(idx)
+			}
+		}()
+	}
+	wg.Wait()
+
+	po.printPullImagesResults(results)
+
+	failures, skipped := 0, 0
+	for _, result := range results {
+		switch result.status {
+		case "pulled":
+			po.imagesPulled++
+		case "failed":
+			failures++
+		case "skipped":
+			skipped++
+		}
+	}
+	switch {
+	case skipped > 0:
+		return fmt.Errorf("Failed to pull %d of %d images, %d were skipped after the first failure", failures, len(results), skipped)
+	case failures > 0:
+		return fmt.Errorf("Failed to pull %d of %d images", failures, len(results))
+	}
+	return nil
+}
+
+func (po *PullOptions) printPullImagesResults(results []pullImageResult) {
+	table := uitable.Table{
+		Title:   "Images",
+		Content: "images",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Image"),
+			uitable.NewHeader("Output"),
+			uitable.NewHeader("Status"),
+			uitable.NewHeader("Digest Reference"),
+			uitable.NewHeader("Error"),
+		},
+	}
+
+	for _, result := range results {
+		errMsg := ""
+		if result.err != nil {
+			errMsg = result.err.Error()
+		}
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(result.entry.Image),
+			uitable.NewValueString(result.entry.Output),
+			uitable.NewValueString(result.status),
+			uitable.NewValueString(result.imageRef),
+			uitable.NewValueString(errMsg),
+		})
+	}
+
+	po.ui.PrintTable(table)
+}
+
+// lockedLogger serializes the logs written by concurrent pulls
+type lockedLogger struct {
+	logger util.Logger
+	lock   *sync.Mutex
+}
+
+func (l *lockedLogger) Logf(msg string, args ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.logger.Logf(msg, args...)
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	v1 "carvel.dev/imgpkg/pkg/imgpkg/v1"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+// extractStats returns the statistics filled by the extraction when --json is provided, so that they are part of
+// the output, or when the terminal event of --progress-format json-lines reports them. Otherwise only the summary
+// logged by the extraction is displayed
+func (po *PullOptions) extractStats() *ctlimg.ExtractStats {
+	if po.events == nil && (po.uiFlags == nil || !po.uiFlags.JSON) {
+		return nil
+	}
+	return &ctlimg.ExtractStats{}
+}
+
+// printExtractStats prints the statistics of the extracted layers, and the totals of the pull
+func (po *PullOptions) printExtractStats(stats *ctlimg.ExtractStats) {
+	if stats == nil || po.uiFlags == nil || !po.uiFlags.JSON {
+		return
+	}
+
+	entryHeaders := []uitable.Header{
+		uitable.NewHeader("Files"),
+		uitable.NewHeader("Directories"),
+		uitable.NewHeader("Symlinks"),
+		uitable.NewHeader("Hardlinks"),
+		uitable.NewHeader("Devices"),
+		uitable.NewHeader("Bytes"),
+		uitable.NewHeader("Skipped Symlinks"),
+		uitable.NewHeader("Skipped Devices"),
+		uitable.NewHeader("Whiteouts"),
+		uitable.NewHeader("Excluded"),
+		uitable.NewHeader("Stripped Special Bits"),
+		uitable.NewHeader("Duration"),
+	}
+	entryValues := func(entries ctlimg.EntryStats, duration time.Duration) []uitable.Value {
+		return []uitable.Value{
+			uitable.NewValueInt(entries.Files),
+			uitable.NewValueInt(entries.Directories),
+			uitable.NewValueInt(entries.Symlinks),
+			uitable.NewValueInt(entries.Hardlinks),
+			uitable.NewValueInt(entries.Devices),
+			uitable.NewValueInt(int(entries.Bytes)),
+			uitable.NewValueInt(entries.SkippedSymlinks),
+			uitable.NewValueInt(entries.SkippedDevices),
+			uitable.NewValueInt(entries.Whiteouts),
+			uitable.NewValueInt(entries.Excluded),
+			uitable.NewValueInt(entries.StrippedSpecialBits),
+			uitable.NewValueString(duration.Round(time.Millisecond).String()),
+		}
+	}
+
+	layersTable := uitable.Table{
+		Title:   "Extracted layers",
+		Content: "layers",
+		Header: append(append([]uitable.Header{uitable.NewHeader("Image"), uitable.NewHeader("Layer Digest")}, entryHeaders...),
+			uitable.NewHeader("Compressed Bytes"), uitable.NewHeader("MB/s")),
+	}
+	for _, layer := range stats.Layers {
+		row := []uitable.Value{uitable.NewValueString(layer.ImageDigest), uitable.NewValueString(layer.Digest)}
+		row = append(row, entryValues(layer.EntryStats, layer.Duration)...)
+		layersTable.Rows = append(layersTable.Rows, append(row,
+			uitable.NewValueInt(int(layer.CompressedSize)), uitable.NewValueString(fmt.Sprintf("%.1f", layer.Throughput()))))
+	}
+	po.ui.PrintTable(layersTable)
+
+	summaryTable := uitable.Table{
+		Title:   "Extraction summary",
+		Content: "images",
+		Header:  append(append([]uitable.Header{uitable.NewHeader("Images"), uitable.NewHeader("Layers")}, entryHeaders...), uitable.NewHeader("Slowest Layer")),
+	}
+	slowest, _ := stats.SlowestLayer()
+	row := []uitable.Value{uitable.NewValueInt(stats.Images), uitable.NewValueInt(len(stats.Layers))}
+	row = append(row, entryValues(stats.EntryStats, stats.Duration)...)
+	summaryTable.Rows = append(summaryTable.Rows, append(row, uitable.NewValueString(slowest.Digest)))
+	po.ui.PrintTable(summaryTable)
+}
+
+// printPullResult prints what was pulled into the output directory when --json is provided, so that scripts do not
+// have to parse the logs to know the digest and the layers of the image
+func (po *PullOptions) printPullResult(providedRef string, status v1.PullStatus, stats *ctlimg.ExtractStats) {
+	if stats == nil || status.ImageRef == "" || po.uiFlags == nil || !po.uiFlags.JSON {
+		return
+	}
+
+	digest := ""
+	if digestRef, err := regname.NewDigest(status.ImageRef); err == nil {
+		digest = digestRef.DigestStr()
+	}
+	var layers []string
+	for _, layer := range stats.Layers {
+		if layer.ImageDigest == digest {
+			layers = append(layers, layer.Digest)
+		}
+	}
+
+	po.ui.PrintTable(uitable.Table{
+		Title:   "Pull result",
+		Content: "result",
+		Header: []uitable.Header{
+			uitable.NewHeader("Reference"),
+			uitable.NewHeader("Digest"),
+			uitable.NewHeader("Media Type"),
+			uitable.NewHeader("Bundle"),
+			uitable.NewHeader("Output"),
+			uitable.NewHeader("Layers"),
+			uitable.NewHeader("Skipped Symlinks"),
+			uitable.NewHeader("Skipped Devices"),
+			uitable.NewHeader("Excluded"),
+			uitable.NewHeader("Stripped Special Bits"),
+		},
+		Rows: [][]uitable.Value{{
+			uitable.NewValueString(providedRef),
+			uitable.NewValueString(digest),
+			uitable.NewValueString(status.MediaType),
+			uitable.NewValueBool(status.IsBundle),
+			uitable.NewValueString(po.OutputPath),
+			uitable.NewValueStrings(layers),
+			uitable.NewValueInt(stats.SkippedSymlinks),
+			uitable.NewValueInt(stats.SkippedDevices),
+			uitable.NewValueInt(stats.Excluded),
+			uitable.NewValueInt(stats.StrippedSpecialBits),
+		}},
+	})
+}
+
+// finishEvents writes the terminal event of --progress-format json-lines, with the reference of the image, or bundle,
+// extracted, and the totals of the extraction
+func (po *PullOptions) finishEvents(status v1.PullStatus, stats *ctlimg.ExtractStats, err error) {
+	eventStats := util.EventStats{Images: po.imagesPulled}
+	if stats != nil {
+		eventStats = util.EventStats{Images: stats.Images, Blobs: len(stats.Layers), Files: stats.Files, Bytes: stats.Bytes}
+	}
+	po.events.Finish(status.ImageRef, po.RegistryFlags.bandwidthStats(eventStats, err), err)
+}
@@ -4,6 +4,9 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"carvel.dev/imgpkg/test/helpers"
@@ -33,6 +36,214 @@ func TestPullErrors(t *testing.T) {
 		require.ErrorContains(t, err, "Cannot use --recursive (-r) flag when pulling a bundle")
 	})
 
+	t.Run("fails when --max-depth is provided without the recursive flag", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", BundleFlags: BundleFlags{"my-bundle"}, BundleRecursiveFlags: BundleRecursiveFlags{MaxDepth: 2}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --recursive (-r) when --max-depth is provided")
+	})
+
+	t.Run("fails when output is the current directory and --no-clean is not provided", func(t *testing.T) {
+		pull := PullOptions{OutputPath: ".", ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Disallowed output directory, trying to avoid accidental deletion (hint: Use --no-clean to extract without removing the existing content)")
+	})
+
+	t.Run("fails when output is not provided and --dry-run is not provided", func(t *testing.T) {
+		pull := PullOptions{ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --output to be none empty")
+	})
+
+	t.Run("fails when --digest-file is provided without extracting into a directory", func(t *testing.T) {
+		for _, pull := range []PullOptions{
+			{ImageFlags: ImageFlags{"image@123456"}, DryRun: true},
+			{ImageFlags: ImageFlags{"image@123456"}, OutputPath: "-"},
+			{ImageFlags: ImageFlags{"image@123456"}, OutputPath: "image.tar"},
+			{ImageFlags: ImageFlags{"image@123456"}, OCILayoutPath: "/tmp/some/layout"},
+		} {
+			pull.DigestFileFlags = DigestFileFlags{"image.digest"}
+			err := pull.Run()
+			require.EqualError(t, err, "Expected --digest-file to only be used when extracting into a directory")
+		}
+	})
+
+	t.Run("fails when --tar is provided with --dry-run", func(t *testing.T) {
+		pull := PullOptions{DryRun: true, TarPath: "bundle.tar"}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --tar with --dry-run, --file, --layer, --layers-dir or --to-oci-layout")
+	})
+
+	t.Run("fails when --tar is provided with --expected-digest", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", TarPath: "bundle.tar", ExpectedDigest: "sha256:" + strings.Repeat("a", 64)}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --tar with --expected-digest or --lock")
+	})
+
+	t.Run("fails when --tar is provided with a tar output", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "-", TarPath: "bundle.tar"}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --output to be a directory when --tar is provided")
+	})
+
+	t.Run("fails when --dry-run is provided with the recursive flag", func(t *testing.T) {
+		pull := PullOptions{DryRun: true, BundleFlags: BundleFlags{"my-bundle"}, BundleRecursiveFlags: BundleRecursiveFlags{Recursive: true}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --recursive (-r) flag with --dry-run")
+	})
+
+	t.Run("fails when writing a tar with the recursive flag", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "-", BundleFlags: BundleFlags{"my-bundle"}, BundleRecursiveFlags: BundleRecursiveFlags{Recursive: true}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --recursive (-r) flag when writing a tar (-o - or a .tar file)")
+	})
+
+	t.Run("fails when --checksums-output is provided with the recursive flag", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", BundleFlags: BundleFlags{"my-bundle"}, BundleRecursiveFlags: BundleRecursiveFlags{Recursive: true},
+			ExtractFlags: ExtractFlags{ChecksumsOutput: "/tmp/SHA256SUMS"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --recursive (-r) flag with --checksums-output")
+	})
+
+	t.Run("fails when --checksums-output is provided while writing a tar", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "image.tar", ImageFlags: ImageFlags{"image@123456"}, ExtractFlags: ExtractFlags{ChecksumsOutput: "/tmp/SHA256SUMS"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --checksums-output to only be used when extracting into a directory")
+	})
+
+	t.Run("fails when --layers-dir is provided while pulling a bundle", func(t *testing.T) {
+		pull := PullOptions{LayersDir: "/tmp/layers", BundleFlags: BundleFlags{"my-bundle"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --layers-dir when pulling a bundle")
+	})
+
+	t.Run("fails when --layers-dir is inside of the output directory", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", LayersDir: "/tmp/some/place/layers", ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --output and --layers-dir to not be inside of each other")
+	})
+
+	t.Run("fails when --layers-dir is provided without output and with an option of the output directory", func(t *testing.T) {
+		pull := PullOptions{LayersDir: "/tmp/layers", ImageFlags: ImageFlags{"image@123456"}, ExtractFlags: ExtractFlags{Incremental: true}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --output when --include-path, --file-exclusion, --incremental, --include-metadata or --checksums-output is provided")
+	})
+
+	t.Run("fails when --layers-dir is the current directory", func(t *testing.T) {
+		pull := PullOptions{LayersDir: ".", ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Disallowed layers directory (trying to avoid accidental deletion)")
+	})
+
+	t.Run("fails when --platform does not have an architecture", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", ImageFlags: ImageFlags{"image@123456"}, Platform: "linux"}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --platform 'linux' to be in the format os/arch[/variant]")
+	})
+
+	t.Run("fails when --expected-digest is not a digest", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", ImageFlags: ImageFlags{"image:v1"}, ExpectedDigest: "v1"}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --expected-digest 'v1' to be a digest (format: sha256:<hex>)")
+	})
+
+	t.Run("fails when --expected-digest is provided with --lock", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", ImageFlags: ImageFlags{"image:v1"}, LockInputFlags: LockInputFlags{LockFilePath: "lockpath"},
+			ExpectedDigest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected only one of --expected-digest or --lock to verify the image digest")
+	})
+
+	t.Run("fails when --lock is a BundleLock and an image is provided", func(t *testing.T) {
+		lockPath := filepath.Join(t.TempDir(), "bundle.lock.yml")
+		require.NoError(t, os.WriteFile(lockPath, []byte(`apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: BundleLock
+bundle:
+  image: my-bundle@sha256:0000000000000000000000000000000000000000000000000000000000000000
+`), 0600))
+		pull := PullOptions{OutputPath: "/tmp/some/place", ImageFlags: ImageFlags{"image:v1"}, LockInputFlags: LockInputFlags{LockFilePath: lockPath}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --lock '"+lockPath+"' to be an ImagesLock when used with -i or -b")
+	})
+
+	t.Run("fails when the repository is not listed in the ImagesLock", func(t *testing.T) {
+		lockPath := filepath.Join(t.TempDir(), "images.lock.yml")
+		require.NoError(t, os.WriteFile(lockPath, []byte(`apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: other-image@sha256:0000000000000000000000000000000000000000000000000000000000000000
+`), 0600))
+		pull := PullOptions{OutputPath: "/tmp/some/place", ImageFlags: ImageFlags{"image:v1"}, LockInputFlags: LockInputFlags{LockFilePath: lockPath}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected repository 'index.docker.io/library/image' to be listed in images lock '"+lockPath+"'")
+	})
+
+	t.Run("fails when --images-file is provided with an image", func(t *testing.T) {
+		pull := PullOptions{ImagesFile: "images.yml", ImageFlags: ImageFlags{"image@123456"}, ImagesConcurrency: 1}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected only one of image, bundle, lock or --images-file")
+	})
+
+	t.Run("fails when --images-file is provided with --output", func(t *testing.T) {
+		pull := PullOptions{ImagesFile: "images.yml", OutputPath: "/tmp/some/place", ImagesConcurrency: 1}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected the output directories to be listed in --images-file instead of --output")
+	})
+
+	t.Run("fails when --images-file lists the same output twice", func(t *testing.T) {
+		imagesFile := filepath.Join(t.TempDir(), "images.yml")
+		require.NoError(t, os.WriteFile(imagesFile, []byte(`images:
+- image: image-1@123456
+  output: /tmp/some/place
+- image: image-2@123456
+  output: /tmp/some/place/
+`), 0600))
+		pull := PullOptions{ImagesFile: imagesFile, ImagesConcurrency: 1}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected images 'image-1@123456' and 'image-2@123456' of images file '"+imagesFile+"' to have different output directories")
+	})
+
+	t.Run("fails when --fail-fast is provided without --images-file", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/some/place", ImageFlags: ImageFlags{"image@123456"}, FailFast: true}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --images-file when --fail-fast is provided")
+	})
+
+	t.Run("fails when --uncompressed is provided without --layer", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "layer.tar", Uncompressed: true, ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --layer when --uncompressed is provided")
+	})
+
+	t.Run("fails when --layer is provided with --dry-run", func(t *testing.T) {
+		pull := PullOptions{DryRun: true, Layer: "sha256:123456", ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --layer with --dry-run")
+	})
+
+	t.Run("fails when --file is provided without -o -", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/out", File: "config/values.yml", ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected -o - when --file is provided, the content of the file is written to stdout")
+	})
+
+	t.Run("fails when --file is provided with --dry-run", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "-", File: "config/values.yml", DryRun: true, ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Cannot use --file with --dry-run, --layer, --to-oci-layout, --layers-dir or --recursive (-r)")
+	})
+
+	t.Run("fails when --to-oci-layout is provided with --output", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/out", OCILayoutPath: "/tmp/layout", ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected only one of --output or --to-oci-layout")
+	})
+
+	t.Run("fails when --include-index is provided without --to-oci-layout", func(t *testing.T) {
+		pull := PullOptions{OutputPath: "/tmp/out", IncludeIndex: true, ImageFlags: ImageFlags{"image@123456"}}
+		err := pull.Run()
+		require.ErrorContains(t, err, "Expected --to-oci-layout when --include-index is provided")
+	})
+
 	t.Run("fails when arguments are provided without a flag", func(t *testing.T) {
 		confUI := ui.NewConfUI(ui.NewNoopLogger())
 		defer confUI.Flush()
@@ -89,3 +300,25 @@ func TestPullErrors(t *testing.T) {
 		require.ErrorContains(t, err, "Expected bundle image but found plain image (hint: Did you use -i instead of -b?)")
 	})
 }
+
+func TestReservesStdout(t *testing.T) {
+	reservesStdoutWith := func(args ...string) bool {
+		cmd, flags, err := NewDefaultImgpkgCmd(ui.NewConfUI(ui.NewNoopLogger())).Find(args)
+		require.NoError(t, err)
+		require.NoError(t, cmd.ParseFlags(flags))
+		return reservesStdout(cmd)
+	}
+
+	require.True(t, reservesStdoutWith("pull", "-i", "image", "-o", "-"))
+	require.True(t, reservesStdoutWith("pull", "-i", "image", "--output=-"))
+	require.True(t, reservesStdoutWith("pull", "-i", "image", "-o", "-", "--dry-run=false"))
+	require.False(t, reservesStdoutWith("pull", "-i", "image", "-o", "-", "--dry-run"))
+	require.False(t, reservesStdoutWith("pull", "-i", "image", "-o", "out.tar"))
+	require.False(t, reservesStdoutWith("pull", "-i", "-o", "-o", "out.tar"))
+	require.True(t, reservesStdoutWith("push", "-b", "bundle", "-f", "dir", "--lock-output", "-"))
+	require.True(t, reservesStdoutWith("copy", "-b", "bundle", "--to-repo", "repo", "--lock-output=-"))
+	require.False(t, reservesStdoutWith("push", "-b", "bundle", "-f", "dir", "--lock-output", "bundle.lock.yml"))
+	require.True(t, reservesStdoutWith("push", "-i", "image", "-f", "dir", "--digest-file", "-"))
+	require.True(t, reservesStdoutWith("pull", "-b", "bundle", "-o", "dir", "--digest-file=-"))
+	require.False(t, reservesStdoutWith("copy", "-b", "bundle", "--to-repo", "repo", "--digest-file", "bundle.digest"))
+}
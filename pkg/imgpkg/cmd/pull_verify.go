@@ -0,0 +1,126 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// verifiesDigest checks if the pulled reference is verified against --expected-digest, or against the images lock
+// provided with --lock together with -i or -b
+func (po *PullOptions) verifiesDigest() bool {
+	return po.ExpectedDigest != "" || po.verifiesWithLock()
+}
+
+func (po *PullOptions) verifiesWithLock() bool {
+	return len(po.LockInputFlags.LockFilePath) > 0 && (len(po.ImageFlags.Image) > 0 || len(po.BundleFlags.Bundle) > 0)
+}
+
+// verifyDigest resolves imageRef and fails when it does not point to the expected digest. Returns the digest
+// reference, which is what gets pulled so that the tag cannot move between the check and the pull
+func (po *PullOptions) verifyDigest(imageRef string) (string, error) {
+	ref, err := regname.ParseReference(imageRef, regname.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+
+	expected, source, err := po.expectedDigest(ref)
+	if err != nil {
+		return "", err
+	}
+
+	reg, err := registry.NewSimpleRegistry(po.RegistryFlags.AsRegistryOpts())
+	if err != nil {
+		return "", err
+	}
+	digest, err := reg.Digest(ref)
+	if err != nil {
+		return "", fmt.Errorf("Resolving image '%s': %s", imageRef, err)
+	}
+
+	if digest != expected {
+		return "", newCodedError(ErrorCodeDigestMismatch, fmt.Errorf("Expected image '%s' to resolve to digest '%s' (from %s), but it resolves to digest '%s'", imageRef, expected, source, digest))
+	}
+	return ref.Context().Digest(digest.String()).String(), nil
+}
+
+// expectedDigest returns the digest provided with --expected-digest or recorded for the repository of ref in the
+// images lock, and where it comes from
+func (po *PullOptions) expectedDigest(ref regname.Reference) (regv1.Hash, string, error) {
+	if po.ExpectedDigest != "" {
+		digest, err := regv1.NewHash(po.ExpectedDigest)
+		if err != nil {
+			return regv1.Hash{}, "", fmt.Errorf("Expected --expected-digest '%s' to be a digest (format: sha256:<hex>)", po.ExpectedDigest)
+		}
+		return digest, "--expected-digest", nil
+	}
+
+	lockPath := po.LockInputFlags.LockFilePath
+	_, imagesLock, err := lockconfig.NewLockFromPath(lockPath)
+	if err != nil {
+		return regv1.Hash{}, "", err
+	}
+	if imagesLock == nil {
+		return regv1.Hash{}, "", fmt.Errorf("Expected --lock '%s' to be an ImagesLock when used with -i or -b, a BundleLock selects the bundle to pull", lockPath)
+	}
+
+	source := fmt.Sprintf("images lock '%s'", lockPath)
+	var found []regname.Digest
+	for _, lockImage := range imagesLock.Images {
+		lockRef, err := regname.NewDigest(lockImage.Image)
+		if err != nil {
+			return regv1.Hash{}, "", err
+		}
+		if lockRef.Context().Name() != ref.Context().Name() {
+			continue
+		}
+		// a digest reference selects its own entry when the repository is listed more than once
+		if refDigest, isDigest := ref.(regname.Digest); isDigest && refDigest.DigestStr() == lockRef.DigestStr() {
+			found = []regname.Digest{lockRef}
+			break
+		}
+		found = append(found, lockRef)
+	}
+
+	switch len(found) {
+	case 0:
+		return regv1.Hash{}, "", fmt.Errorf("Expected repository '%s' to be listed in %s", ref.Context().Name(), source)
+	case 1:
+		digest, err := regv1.NewHash(found[0].DigestStr())
+		return digest, source, err
+	default:
+		return regv1.Hash{}, "", fmt.Errorf("Expected repository '%s' to be listed only once in %s (hint: Use a digest reference to select the image)", ref.Context().Name(), source)
+	}
+}
+
+// printVerifiedDigest prints the digest that was verified and pulled, so that it can be recorded
+func (po *PullOptions) printVerifiedDigest(imageRef, digestRef string) {
+	digest, err := regname.NewDigest(digestRef)
+	if err != nil {
+		return
+	}
+
+	table := uitable.Table{
+		Title:   "Verified image",
+		Content: "images",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Image"),
+			uitable.NewHeader("Digest"),
+			uitable.NewHeader("Digest Reference"),
+		},
+	}
+	table.Rows = append(table.Rows, []uitable.Value{
+		uitable.NewValueString(imageRef),
+		uitable.NewValueString(digest.DigestStr()),
+		uitable.NewValueString(digestRef),
+	})
+	po.ui.PrintTable(table)
+}
@@ -5,30 +5,83 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
 	"github.com/cppforlife/go-cli-ui/ui"
 	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 )
 
+// sourceDateEpochEnv environment variable with the time of reproducible builds, see https://reproducible-builds.org/specs/source-date-epoch/
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
 type PushOptions struct {
-	ui ui.UI
-
-	ImageFlags      ImageFlags
-	BundleFlags     BundleFlags
-	LockOutputFlags LockOutputFlags
-	FileFlags       FileFlags
-	RegistryFlags   RegistryFlags
-	LabelFlags      LabelFlags
+	ui      ui.UI
+	uiFlags *UIFlags
+	// sizeEstimate size of the files of the image, set once it is built, see sizeEstimated
+	sizeEstimate *ctlimg.PushSizeEstimate
+	// events stream of --progress-format json-lines, nil otherwise
+	events *util.EventStream
+
+	ImageFlags          ImageFlags
+	BundleFlags         BundleFlags
+	LockOutputFlags     LockOutputFlags
+	DigestFileFlags     DigestFileFlags
+	FileFlags           FileFlags
+	RegistryFlags       RegistryFlags
+	LabelFlags          LabelFlags
+	AnnotationFlags     AnnotationFlags
+	ConfigFlags         ConfigFlags
+	ProgressFormatFlags ProgressFormatFlags
+	MediaType           string
+
+	Compression      string
+	CompressionLevel int
+	BuildTimestamp   string
+	// BuildCache reuses the layer built before from the same files, from the cache of --cache-dir
+	BuildCache        bool
+	BuildCacheMaxSize string
+	// AccurateSize compresses the layer before uploading it, so that its compressed size is reported exactly
+	AccurateSize bool
+	// VerboseContents prints the entries of the layer, ContentsOutput is the file they are written to
+	VerboseContents bool
+	ContentsOutput  string
+
+	OCILayoutPath string
+	TarPath       string
+	DryRun        bool
+	MountFrom     string
+	Concurrency   int
+	// AdditionalTags tags the image is also tagged with, in the repository it is pushed to
+	AdditionalTags []string
+	// AlsoPushTo repositories, or tags, the image is also pushed to
+	AlsoPushTo  []string
+	NoOverwrite bool
+	Overwrite   bool
+	ResolveTags bool
+	Platform    string
+	// SignKey key the image pushed is signed with, a path or env://<variable>
+	SignKey string
+	// Attach files pushed as artifacts referencing the image pushed (format: <media-type>=<path>)
+	Attach []string
+	// IncludeReferencedImages relocates the images of the images lock of the bundle to each repository it is pushed to
+	IncludeReferencedImages bool
+	// SkipPreflight does not check that each repository pushed to can be pushed to before uploading the blobs
+	SkipPreflight bool
 }
 
-func NewPushOptions(ui ui.UI) *PushOptions {
-	return &PushOptions{ui: ui}
+func NewPushOptions(ui ui.UI, uiFlags *UIFlags) *PushOptions {
+	return &PushOptions{ui: ui, uiFlags: uiFlags}
 }
 
 func NewPushCmd(o *PushOptions) *cobra.Command {
@@ -41,34 +94,238 @@ func NewPushCmd(o *PushOptions) *cobra.Command {
   imgpkg push -b repo/app1-config -f config/
 
   # Push image repo/app1-config with contents from multiple locations
-  imgpkg push -i repo/app1-config -f config/ -f additional-config.yml`,
+  imgpkg push -i repo/app1-config -f config/ -f additional-config.yml
+
+  # Push bundle repo/app1-config with the files of overlays/prod/ replacing the ones at the same path in config/
+  imgpkg push -b repo/app1-config -f config/ -f overlays/prod/
+
+  # Push image repo/app1-config with the content of the tar written to stdin
+  tar -C config/ -cf - . | imgpkg push -i repo/app1-config --from-tar -
+
+  # Push bundle repo/app1-config with OCI media types
+  imgpkg push -b repo/app1-config -f config/ --media-type oci
+
+  # Push bundle repo/app1-config with its layer compressed with zstd
+  imgpkg push -b repo/app1-config -f config/ --compression zstd
+
+  # Push bundle repo/app1-config with the content the symlinks of config/ point to in ../common/
+  imgpkg push -b repo/app1-config -f config/ --follow-symlinks --follow-symlinks-root ../common/
+
+  # Push image repo/app1-config with linux/amd64 as the platform of its config
+  imgpkg push -i repo/app1-config -f config/ --platform linux/amd64
+
+  # Push image repo/app1-config with environment variables in its config, for the tools processing its content
+  imgpkg push -i repo/app1-config -f config/ --env CONTENT_TYPE=helm-chart --config-file image-config.json
+
+  # Push bundle repo/app1-config reusing the layer built from the same files by a previous push, from ~/.imgpkg/cache
+  imgpkg push -b repo/app1-config -f config/ --build-cache
+
+  # Push bundle repo/app1-config with the time of the last commit as the time of its files
+  SOURCE_DATE_EPOCH=$(git log -1 --format=%ct) imgpkg push -b repo/app1-config -f config/
+
+  # Write bundle repo/app1-config as an OCI image layout in out/, and copy it to the registry later
+  imgpkg push -b repo/app1-config -f config/ --to-oci-layout out/ --lock-output bundle.lock.yml
+  imgpkg copy --from-oci-layout out/ --to-repo repo/app1-config
+
+  # Print the digest bundle repo/app1-config would have, without pushing it
+  imgpkg push -b repo/app1-config -f config/ --dry-run
+
+  # Write the entries of bundle repo/app1-config to compare them with those of another build that has a different digest
+  imgpkg push -b repo/app1-config -f config/ --dry-run --contents-output contents.json
+
+  # Push bundle repo/app1-config:v1.2.3 also tagged as v1.2 and latest
+  imgpkg push -b repo/app1-config:v1.2.3 -f config/ --additional-tag v1.2 --additional-tag latest
+
+  # Push bundle repo/app1-config:v1.2.3 to an internal registry and to another one, as other.io/app1-config:v1.2.3
+  imgpkg push -b internal.io/app1-config:v1.2.3 -f config/ --also-push-to other.io/app1-config
+
+  # Push bundle repo/app1-config:v1.0.0 unless the tag already points to a different bundle
+  imgpkg push -b repo/app1-config:v1.0.0 -f config/ --no-overwrite
+
+  # Push bundle repo/app1-config with the images of .imgpkg/images.yml referenced by tag resolved to digests
+  imgpkg push -b repo/app1-config -f config/ --resolve-tags
+
+  # Push bundle repo/app1-config unless one of its files is larger than 100MB, or all of them are larger than 1GB
+  imgpkg push -b repo/app1-config -f config/ --max-file-size 100MB --max-total-size 1GB
+
+  # Push image repo/app1-config and write its reference, repo/app1-config@sha256:<hex>, to app1-config.digest
+  imgpkg push -i repo/app1-config -f config/ --digest-file app1-config.digest
+
+  # Push bundle repo/app1-config unless one of its files has a secret, the files of config/test/fixtures/ not being scanned
+  imgpkg push -b repo/app1-config -f config/ --scan-secrets --scan-secrets-allow test/fixtures
+
+  # Push bundle repo/app1-config signed with the cosign key cosign.key, whose passphrase is in $COSIGN_PASSWORD
+  imgpkg push -b repo/app1-config -f config/ --sign-key cosign.key
+
+  # Push bundle repo/app1-config with its CycloneDX SBOM attached, as an artifact referencing it
+  imgpkg push -b repo/app1-config -f config/ --attach application/vnd.cyclonedx+json=sbom.cdx.json
+
+  # Push bundle repo/app1-config mounting the blobs repo/base already has instead of uploading them
+  imgpkg push -b repo/app1-config -f config/ --mount-from repo/base`,
 	}
 	o.ImageFlags.Set(cmd)
 	o.BundleFlags.Set(cmd)
 	o.LockOutputFlags.SetOnPush(cmd)
+	o.DigestFileFlags.SetOnPush(cmd)
 	o.FileFlags.Set(cmd)
 	o.RegistryFlags.Set(cmd)
+	o.RegistryFlags.SetBandwidthLimit(cmd)
 	o.LabelFlags.Set(cmd)
+	o.AnnotationFlags.Set(cmd)
+	o.ProgressFormatFlags.Set(cmd)
+	o.ConfigFlags.Set(cmd)
+	cmd.Flags().StringVar(&o.MediaType, "media-type", "", "Media types of the manifest, config and layer of the image, oci or docker "+
+		"(default docker, or oci when --annotation, --layer-annotation or --compression zstd is provided)")
+	cmd.Flags().StringVar(&o.Compression, "compression", string(ctlimg.GzipCompression), "Compression of the layer of the image, gzip, zstd or none")
+	cmd.Flags().IntVar(&o.CompressionLevel, "compression-level", 0, "Level of the compression, from 1 to 9 for gzip and from 1 to 22 for zstd (default 1 for gzip and 3 for zstd)")
+	cmd.Flags().BoolVar(&o.BuildCache, "build-cache", false, "Reuse the compressed layer built by a previous push from the same files, with the same exclusions, modes and compression, "+
+		"from the cache of --cache-dir, and add the layer built to it otherwise")
+	cmd.Flags().StringVar(&o.BuildCacheMaxSize, "build-cache-max-size", "10GB", "Maximum size of the cache of --cache-dir once the layer built is added, "+
+		"the least recently used layers being removed (format: 500MB, 10GB, 2GiB)")
+	cmd.Flags().StringVar(&o.Platform, "platform", "", "Platform set in the image config, the content of the image being the same (format: os/arch[/variant]) (default none)")
+	cmd.Flags().StringVar(&o.BuildTimestamp, "build-timestamp", "", "Time of the image config and of the modification of the files, "+
+		"in seconds since the Unix epoch (default $SOURCE_DATE_EPOCH, or the zero time when not set)")
+	cmd.Flags().StringVar(&o.OCILayoutPath, "to-oci-layout", "", "Directory where the image is written as an OCI image layout instead of pushing it, "+
+		"the reference provided is recorded in the layout for copy --from-oci-layout")
+	cmd.Flags().StringVar(&o.TarPath, "to-tar", "", "Tar where the image is written, in the format of copy --to-tar, instead of pushing it")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", false, "Build the image as it would be pushed and print its digest and size, without contacting the registry")
+	cmd.Flags().BoolVar(&o.AccurateSize, "accurate-size", false, "Compress the layer before uploading it, to report its exact compressed size "+
+		"instead of an estimate from chunks of it, which compresses it twice when it is compressed while uploaded")
+	cmd.Flags().BoolVar(&o.VerboseContents, "verbose-contents", false, "Print each entry of the layer, with its path, type, mode, size and sha256, "+
+		"and the parameters it is compressed with, before uploading it")
+	cmd.Flags().StringVar(&o.ContentsOutput, "contents-output", "", "File the entries of the layer, with their path, type, mode, size and sha256, and the parameters it is compressed with, "+
+		"are written to as JSON before uploading it, so that those of two pushes can be diffed. It is left out of the image when it is in a directory pushed")
+	cmd.Flags().StringVar(&o.MountFrom, "mount-from", "", "Repository of the same registry the blobs missing in the destination are mounted from, "+
+		"instead of being uploaded, when it has them")
+	cmd.Flags().StringSliceVar(&o.AdditionalTags, "additional-tag", nil, "Tag the image is also tagged with, in the repository it is pushed to, "+
+		"its blobs and manifest being uploaded once (format: v1.2) (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&o.AlsoPushTo, "also-push-to", nil, "Repository the image is also pushed to, with the tag of --bundle (-b) or --image (-i) unless one is provided, "+
+		"the image being built once. The image is pushed to the other repositories when one fails (format: other.io/repo[:tag]) (can be specified multiple times)")
+	cmd.Flags().BoolVar(&o.NoOverwrite, "no-overwrite", false, "Fail when the tag pushed to already points to a different image, pushing the image it points to being a no-op "+
+		"(default true for tags other than latest when $"+noOverwriteEnv+" is true)")
+	cmd.Flags().BoolVar(&o.Overwrite, "overwrite", false, "Replace the image the tag pushed to points to, even when $"+noOverwriteEnv+" is true")
+	cmd.Flags().BoolVar(&o.ResolveTags, "resolve-tags", false, "Accept images referenced by tag in .imgpkg/images.yml of the bundle, "+
+		"pushing it with the digests the tags point to instead, and the tags as annotations (the files provided are not modified)")
+	cmd.Flags().StringVar(&o.SignKey, "sign-key", "", "Private key the image pushed is signed with, writing a cosign signature to the sha256-<digest>.sig tag of each repository it is pushed to, "+
+		"the key being a file or env://<variable>, keys of a KMS are not supported (passphrase of the keys of cosign generate-key-pair read from $"+signKeyPassphraseEnv+", or asked for)")
+	cmd.Flags().StringSliceVar(&o.Attach, "attach", nil, "File pushed, once the image is, as an artifact referencing it in each repository it is pushed to, "+
+		"listed by the referrers API of the registry or in the sha256-<digest> tag when it does not have it (format: <media-type>=<path>) (can be specified multiple times)")
+	cmd.Flags().BoolVar(&o.IncludeReferencedImages, "include-referenced-images", false, "Copy the images of .imgpkg/images.yml of the bundle, and those of the bundles it references, "+
+		"to each repository the bundle is pushed to before pushing it, as copy --to-repo does, recording where they were copied to in the annotations of --lock-output")
+	cmd.Flags().BoolVar(&o.SkipPreflight, "skip-preflight", false, "Skip checking, before uploading the blobs, that each repository pushed to exists and can be pushed to, "+
+		"by starting and cancelling the upload of a blob, for the registries whose permissions do not allow it")
+	cmd.Flags().IntVar(&o.Concurrency, "concurrency", 3, "Number of blobs uploaded at the same time, the manifest being uploaded once they all are")
 
 	return cmd
 }
 
-func (po *PushOptions) Run() error {
-	reg, err := registry.NewSimpleRegistry(po.RegistryFlags.AsRegistryOpts())
+func (po *PushOptions) Run() (err error) {
+	po.events, err = po.ProgressFormatFlags.EventStream("push")
 	if err != nil {
 		return err
 	}
+	// the blobs mounted are recorded by the writer of the destinations, which is created once the flags are validated
+	var destinationsWriter *multiDestinationWriter
+	var imageURL string
+	defer func() {
+		po.events.Finish(imageURL, po.RegistryFlags.bandwidthStats(destinationsWriter.eventStats(), err), err)
+	}()
 
-	err = po.validateFlags()
+	po.RegistryFlags.events = po.events
+	err = po.RegistryFlags.limitBandwidth()
+	if err != nil {
+		return err
+	}
+	regOpts := po.RegistryFlags.AsRegistryOpts()
+	regOpts.OnBlobMounted = func(digest string) {
+		if destinationsWriter != nil {
+			destinationsWriter.blobMounted(digest)
+		}
+	}
+	reg, err := registry.NewSimpleRegistry(regOpts)
 	if err != nil {
 		return err
 	}
 
-	var imageURL string
+	imageOpts, err := po.imageOpts()
+	if err != nil {
+		return err
+	}
+	imageOpts.LayerCache, err = po.layerCache(regOpts.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	err = po.validateFlags(imageOpts.Labels)
+	if err != nil {
+		return err
+	}
+	err = po.excludeContentsOutput()
+	if err != nil {
+		return err
+	}
+
+	signer, err := po.signer(reg)
+	if err != nil {
+		return err
+	}
+	attachments, err := po.attachments()
+	if err != nil {
+		return err
+	}
+
+	mountFrom, err := po.mountFromRepo()
+	if err != nil {
+		return err
+	}
+
+	destTags, err := po.destinationTags()
+	if err != nil {
+		return err
+	}
+
+	fromTar, err := po.FileFlags.OpenFromTar()
+	if err != nil {
+		return err
+	}
+	if fromTar != nil {
+		defer fromTar.Close()
+	}
+	gitClone, err := po.cloneFromGit(&imageOpts)
+	if err != nil {
+		return err
+	}
+	if gitClone != nil {
+		defer gitClone.Remove()
+	}
 
 	isBundle := po.BundleFlags.Bundle != ""
 	isImage := po.ImageFlags.Image != ""
 
+	start := time.Now()
+	var writer bundle.ImagesMetadataWriter
+	var dryRunWriter *dryRunImagesWriter
+	localPath := po.OCILayoutPath + po.TarPath
+	switch {
+	case po.DryRun:
+		dryRunWriter = &dryRunImagesWriter{ImagesMetadataWriter: reg}
+		writer = dryRunWriter
+	case localPath != "":
+		writer = localImagesWriter{ImagesMetadataWriter: reg, ociLayoutPath: po.OCILayoutPath, tarPath: po.TarPath, isBundle: isBundle, ctx: po.RegistryFlags.context()}
+	default:
+		err = po.checkPushPermission(reg, destTags)
+		if err != nil {
+			return err
+		}
+		destinationsWriter, err = po.newMultiDestinationWriter(reg, destTags, mountFrom, start)
+		if err != nil {
+			return err
+		}
+		writer = destinationsWriter
+	}
+	relocator := po.newReferencedImagesRelocator(reg, destTags)
+
+	po.events.Phase("build", po.BundleFlags.Bundle+po.ImageFlags.Image)
 	switch {
 	case isBundle && isImage:
 		return fmt.Errorf("Expected only one of image or bundle")
@@ -77,13 +334,13 @@ func (po *PushOptions) Run() error {
 		return fmt.Errorf("Expected either image or bundle")
 
 	case isBundle:
-		imageURL, err = po.pushBundle(reg)
+		imageURL, err = po.pushBundle(writer, imageOpts, fromTar, relocator)
 		if err != nil {
 			return err
 		}
 
 	case isImage:
-		imageURL, err = po.pushImage(reg)
+		imageURL, err = po.pushImage(writer, imageOpts, fromTar)
 		if err != nil {
 			return err
 		}
@@ -92,45 +349,77 @@ func (po *PushOptions) Run() error {
 		panic("Unreachable code")
 	}
 
-	po.ui.BeginLinef("Pushed '%s'", imageURL)
+	if po.DryRun {
+		po.printDryRun(imageURL, dryRunWriter.size)
+		return nil
+	}
+
+	if localPath == "" {
+		po.events.Phase("finish", "")
+		lockAnnotations, err := po.finishReferencedImages(relocator, destinationsWriter, imageURL, imageOpts.Annotations)
+		if err != nil {
+			return err
+		}
+		return po.finishDestinations(reg, destinationsWriter, signer, attachments, imageURL, lockAnnotations, isBundle)
+	}
 
+	if isBundle {
+		err = po.writeBundleLock(imageURL, destTags[0].TagStr(), nil, imageOpts.Annotations, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	err = po.DigestFileFlags.WriteDigest(imageURL)
+	if err != nil {
+		return err
+	}
+
+	po.ui.BeginLinef("Wrote '%s' to '%s'", imageURL, localPath)
 	return nil
 }
 
-func (po *PushOptions) pushBundle(registry registry.Registry) (string, error) {
+// pushBundle pushes the bundle, once relocator, when it is not nil, relocated the images of its images lock
+func (po *PushOptions) pushBundle(registry bundle.ImagesMetadataWriter, imageOpts ctlimg.FileImageOpts, fromTar *ctlimg.TarStream,
+	relocator *referencedImagesRelocator) (string, error) {
 	uploadRef, err := regname.NewTag(po.BundleFlags.Bundle, regname.WeakValidation)
 	if err != nil {
 		return "", fmt.Errorf("Parsing '%s': %s", po.BundleFlags.Bundle, err)
 	}
 
-	logger := util.NewUILevelLogger(util.LogWarn, util.NewLogger(po.ui))
-	imageURL, err := bundle.NewContents(po.FileFlags.Files, po.FileFlags.ExcludedFilePaths, po.FileFlags.PreservePermissions).Push(uploadRef, po.LabelFlags.Labels, registry, logger)
-	if err != nil {
-		return "", err
+	contents := po.bundleContents(fromTar)
+	if relocator != nil {
+		contents = contents.WithOnImagesLock(relocator.relocate)
 	}
+	logger := util.NewUILevelLogger(util.LogWarn, util.NewLogger(po.ui))
+	return contents.Push(uploadRef, imageOpts, registry, logger)
+}
 
-	if po.LockOutputFlags.LockFilePath != "" {
-		bundleLock := lockconfig.BundleLock{
-			LockVersion: lockconfig.LockVersion{
-				APIVersion: lockconfig.BundleLockAPIVersion,
-				Kind:       lockconfig.BundleLockKind,
-			},
-			Bundle: lockconfig.BundleRef{
-				Image: imageURL,
-				Tag:   uploadRef.TagStr(),
-			},
-		}
-
-		err := bundleLock.WriteToPath(po.LockOutputFlags.LockFilePath)
-		if err != nil {
-			return "", err
-		}
+// writeBundleLock writes the lock of the bundle pushed to --lock-output, with its tag, the additionalTags written, the
+// other repositories it was pushed to and the artifacts attached to it
+func (po *PushOptions) writeBundleLock(imageURL, tag string, additionalTags []string, annotations map[string]string,
+	alsoPushedTo []lockconfig.BundleDestinationRef, attachments []lockconfig.BundleAttachmentRef) error {
+	if po.LockOutputFlags.LockFilePath == "" {
+		return nil
 	}
 
-	return imageURL, nil
+	bundleLock := lockconfig.BundleLock{
+		LockVersion: lockconfig.LockVersion{
+			APIVersion: lockconfig.BundleLockAPIVersion,
+			Kind:       lockconfig.BundleLockKind,
+		},
+		Bundle: lockconfig.BundleRef{
+			Image:          imageURL,
+			Tag:            tag,
+			AdditionalTags: additionalTags,
+			Annotations:    annotations,
+			AlsoPushedTo:   alsoPushedTo,
+			Attachments:    attachments,
+		},
+	}
+	return po.LockOutputFlags.WriteLock(bundleLock)
 }
 
-func (po *PushOptions) pushImage(registry registry.Registry) (string, error) {
+func (po *PushOptions) pushImage(registry bundle.ImagesMetadataWriter, imageOpts ctlimg.FileImageOpts, fromTar *ctlimg.TarStream) (string, error) {
 	if po.LockOutputFlags.LockFilePath != "" {
 		return "", fmt.Errorf("Lock output is not compatible with image, use bundle for lock output")
 	}
@@ -140,7 +429,7 @@ func (po *PushOptions) pushImage(registry registry.Registry) (string, error) {
 		return "", fmt.Errorf("Parsing '%s': %s", po.ImageFlags.Image, err)
 	}
 
-	isBundle, err := bundle.NewContents(po.FileFlags.Files, po.FileFlags.ExcludedFilePaths, po.FileFlags.PreservePermissions).PresentsAsBundle()
+	isBundle, err := po.bundleContents(fromTar).PresentsAsBundle()
 	if err != nil {
 		return "", err
 	}
@@ -149,19 +438,236 @@ func (po *PushOptions) pushImage(registry registry.Registry) (string, error) {
 	}
 
 	logger := util.NewUILevelLogger(util.LogWarn, util.NewLogger(po.ui))
-	return plainimage.NewContents(po.FileFlags.Files, po.FileFlags.ExcludedFilePaths, po.FileFlags.PreservePermissions).Push(uploadRef, po.LabelFlags.Labels, registry, logger)
+	if fromTar != nil {
+		return plainimage.NewContentsFromTar(fromTar, po.FileFlags.ExcludedFilePaths, po.FileFlags.AsTarImageOpts()).Push(uploadRef, imageOpts, registry, logger)
+	}
+	return plainimage.NewContents(po.FileFlags.Files, po.FileFlags.ExcludedFilePaths, po.FileFlags.AsTarImageOpts()).Push(uploadRef, imageOpts, registry, logger)
+}
+
+// bundleContents returns the contents of the bundle, the entries of fromTar when --from-tar is provided
+func (po *PushOptions) bundleContents(fromTar *ctlimg.TarStream) bundle.Contents {
+	if fromTar != nil {
+		return bundle.NewContentsFromTar(fromTar, po.FileFlags.ExcludedFilePaths, po.FileFlags.AsTarImageOpts()).WithResolveTags(po.ResolveTags)
+	}
+	return bundle.NewContents(po.FileFlags.Files, po.FileFlags.ExcludedFilePaths, po.FileFlags.AsTarImageOpts()).WithResolveTags(po.ResolveTags)
+}
+
+// imageOpts returns the labels and annotations of the pushed image
+func (po *PushOptions) imageOpts() (ctlimg.FileImageOpts, error) {
+	labels, err := po.LabelFlags.AsLabels()
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+	annotations, layerAnnotations, err := po.AnnotationFlags.AsAnnotations()
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+	env, labels, workingDir, err := po.ConfigFlags.AsConfig(labels)
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+
+	mediaTypes := ctlimg.MediaTypes(po.MediaType)
+	switch {
+	case mediaTypes != "" && mediaTypes != ctlimg.OCIMediaTypes && mediaTypes != ctlimg.DockerMediaTypes:
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Expected --media-type '%s' to be one of oci or docker", po.MediaType)
+	case mediaTypes == ctlimg.DockerMediaTypes && (len(annotations) > 0 || len(layerAnnotations) > 0):
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Cannot use --annotation or --layer-annotation with --media-type docker, Docker manifests do not have annotations (hint: Use --media-type oci)")
+	}
+
+	compression := ctlimg.Compression(po.Compression)
+	if compression == "" {
+		compression = ctlimg.GzipCompression
+	}
+	switch {
+	case compression != ctlimg.GzipCompression && compression != ctlimg.ZstdCompression && compression != ctlimg.NoCompression:
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Expected --compression '%s' to be one of gzip, zstd or none", po.Compression)
+	case compression == ctlimg.NoCompression && po.CompressionLevel != 0:
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Cannot use --compression-level with --compression none")
+	case compression == ctlimg.ZstdCompression && mediaTypes == ctlimg.DockerMediaTypes:
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Cannot use --compression zstd with --media-type docker, Docker manifests do not have zstd layers (hint: Use --media-type oci)")
+	case compression == ctlimg.GzipCompression && (po.CompressionLevel < 0 || po.CompressionLevel > 9):
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Expected --compression-level to be between 1 and 9 for gzip")
+	case compression == ctlimg.ZstdCompression && (po.CompressionLevel < 0 || po.CompressionLevel > 22):
+		return ctlimg.FileImageOpts{}, fmt.Errorf("Expected --compression-level to be between 1 and 22 for zstd")
+	}
+
+	created, err := po.buildTimestamp()
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+
+	sizeLimits, err := po.FileFlags.AsSizeLimits()
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+
+	platform, err := po.platform()
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+
+	secretScan, err := po.FileFlags.AsSecretScanOpts()
+	if err != nil {
+		return ctlimg.FileImageOpts{}, err
+	}
+
+	return ctlimg.FileImageOpts{MediaTypes: mediaTypes, Compression: compression, CompressionLevel: po.CompressionLevel,
+		Labels: labels, Annotations: annotations, LayerAnnotations: layerAnnotations, Created: created, Platform: platform, Env: env, WorkingDir: workingDir,
+		SizeLimits: sizeLimits, SecretScan: secretScan,
+		IncludeVCS: po.FileFlags.IncludeVCS, ExclusionsFromGitignore: po.FileFlags.ExclusionsFromGitignore, AccurateSize: po.AccurateSize, OnSizeEstimated: po.sizeEstimated,
+		OnContentsListed: po.contentsListed}, nil
+}
+
+// platform returns the platform of --platform, nil when it is not provided
+func (po *PushOptions) platform() (*regv1.Platform, error) {
+	if po.Platform == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(po.Platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] == "") {
+		return nil, fmt.Errorf("Expected --platform '%s' to be in the format os/arch[/variant], for example linux/amd64 or linux/arm64/v8", po.Platform)
+	}
+
+	platform := &regv1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// buildTimestamp returns the time of --build-timestamp, or of the SOURCE_DATE_EPOCH environment variable, which other
+// build tools set for reproducible builds. The zero time is returned when neither is set
+func (po *PushOptions) buildTimestamp() (time.Time, error) {
+	timestamp, source := po.BuildTimestamp, "--build-timestamp"
+	if timestamp == "" {
+		timestamp, source = os.Getenv(sourceDateEpochEnv), sourceDateEpochEnv
+	}
+	if timestamp == "" {
+		return time.Time{}, nil
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, fmt.Errorf("Expected %s '%s' to be a number of seconds since the Unix epoch", source, timestamp)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
 }
 
 // validateFlags checks if the provided flags are valid
-func (po *PushOptions) validateFlags() error {
+func (po *PushOptions) validateFlags(labels map[string]string) error {
 
 	// Verify the user did NOT specify a reserved OCI label
-	_, present := po.LabelFlags.Labels[bundle.BundleConfigLabel]
+	_, present := labels[bundle.BundleConfigLabel]
 
 	if present {
 		return fmt.Errorf("label '%s' is reserved and cannot be overriden. Please use a different key", bundle.BundleConfigLabel)
 	}
 
+	if po.OCILayoutPath != "" && po.TarPath != "" {
+		return fmt.Errorf("Expected only one of --to-oci-layout or --to-tar")
+	}
+
+	if po.DryRun && (po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --dry-run with --to-oci-layout or --to-tar")
+	}
+
+	if po.DryRun && po.LockOutputFlags.LockFilePath != "" {
+		return fmt.Errorf("Cannot use --dry-run with --lock-output, the lock would reference an image that was not pushed")
+	}
+
+	if po.DryRun && po.DigestFileFlags.DigestFilePath != "" {
+		return fmt.Errorf("Cannot use --dry-run with --digest-file, the digest would reference an image that was not pushed")
+	}
+
+	if po.LockOutputFlags.LockFilePath == stdoutOutputPath && po.DigestFileFlags.DigestFilePath == stdoutOutputPath {
+		return fmt.Errorf("Expected only one of --lock-output or --digest-file to be -")
+	}
+
+	if po.FileFlags.FromTar != "" && len(po.FileFlags.Files) > 0 {
+		return fmt.Errorf("Expected only one of --file (-f) or --from-tar")
+	}
+
+	if po.FileFlags.FromTar != "" && po.FileFlags.FollowSymlinks {
+		return fmt.Errorf("Cannot use --follow-symlinks with --from-tar, the symlinks of the tar are skipped")
+	}
+
+	if po.FileFlags.FromGit != "" && (len(po.FileFlags.Files) > 0 || po.FileFlags.FromTar != "") {
+		return fmt.Errorf("Expected only one of --file (-f), --from-tar or --from-git")
+	}
+
+	if po.FileFlags.FromGit != "" {
+		_, err := parseGitSource(po.FileFlags.FromGit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if po.FileFlags.FromGit != "" && po.FileFlags.IncludeVCS {
+		return fmt.Errorf("Cannot use --include-vcs with --from-git, the history of a clone is not the same from one push to the other")
+	}
+
+	if po.FileFlags.FromGit != "" && po.FileFlags.PreservePermissions {
+		return fmt.Errorf("Cannot use --preserve-permissions with --from-git, git only records if files are executable")
+	}
+
+	if po.FileFlags.FromGit != "" && ctlimg.MediaTypes(po.MediaType) == ctlimg.DockerMediaTypes {
+		return fmt.Errorf("Cannot use --from-git with --media-type docker, the remote, the ref and the commit are recorded as annotations (hint: Use --media-type oci)")
+	}
+
+	if po.FileFlags.FromTar != "" && po.FileFlags.ExclusionsFromGitignore {
+		return fmt.Errorf("Cannot use --exclusions-from-gitignore with --from-tar, only the .imgpkgignore at the root of the tar applies")
+	}
+
+	if len(po.FileFlags.FollowSymlinksRoot) > 0 && !po.FileFlags.FollowSymlinks {
+		return fmt.Errorf("Cannot use --follow-symlinks-root without --follow-symlinks")
+	}
+
+	if len(po.AdditionalTags) > 0 && (po.DryRun || po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --additional-tag with --dry-run, --to-oci-layout or --to-tar")
+	}
+
+	if len(po.AlsoPushTo) > 0 && (po.DryRun || po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --also-push-to with --dry-run, --to-oci-layout or --to-tar")
+	}
+
+	if po.NoOverwrite && po.Overwrite {
+		return fmt.Errorf("Expected only one of --no-overwrite or --overwrite")
+	}
+
+	if po.NoOverwrite && (po.DryRun || po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --no-overwrite with --dry-run, --to-oci-layout or --to-tar")
+	}
+
+	if po.ResolveTags && po.BundleFlags.Bundle == "" {
+		return fmt.Errorf("Cannot use --resolve-tags without --bundle (-b), only bundles have an images lock")
+	}
+
+	if po.ResolveTags && po.DryRun {
+		return fmt.Errorf("Cannot use --resolve-tags with --dry-run, resolving the tags contacts the registry")
+	}
+
+	if po.MountFrom != "" && (po.DryRun || po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --mount-from with --dry-run, --to-oci-layout or --to-tar")
+	}
+
+	if po.IncludeReferencedImages && po.BundleFlags.Bundle == "" {
+		return fmt.Errorf("Cannot use --include-referenced-images without --bundle (-b), only bundles have an images lock")
+	}
+
+	if po.IncludeReferencedImages && (po.DryRun || po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --include-referenced-images with --dry-run, --to-oci-layout or --to-tar, the images are copied to the repository the bundle is pushed to")
+	}
+
+	if po.SkipPreflight && (po.DryRun || po.OCILayoutPath != "" || po.TarPath != "") {
+		return fmt.Errorf("Cannot use --skip-preflight with --dry-run, --to-oci-layout or --to-tar, no repository is pushed to")
+	}
+
+	if _, err := po.LockOutputFlags.LockFormat(); err != nil {
+		return err
+	}
+
 	return nil
 
 }
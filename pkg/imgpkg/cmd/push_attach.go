@@ -0,0 +1,139 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ociTitleAnnotation annotation of the layer of an attachment with the name of its file, as oras pull expects
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+// pushAttachment file of --attach, pushed as an artifact referencing the image pushed
+type pushAttachment struct {
+	mediaType string
+	path      string
+	content   []byte
+}
+
+// attachments returns the files of --attach, read before pushing so that a missing one does not leave the image
+// pushed without the others
+func (po *PushOptions) attachments() ([]pushAttachment, error) {
+	if len(po.Attach) == 0 {
+		return nil, nil
+	}
+	if po.DryRun || po.OCILayoutPath != "" || po.TarPath != "" {
+		return nil, fmt.Errorf("Cannot use --attach with --dry-run, --to-oci-layout or --to-tar, only the images pushed to a registry have attachments")
+	}
+
+	var attachments []pushAttachment
+	for _, attach := range po.Attach {
+		mediaType, path, found := strings.Cut(attach, "=")
+		if !found || mediaType == "" || path == "" {
+			return nil, fmt.Errorf("Expected --attach '%s' to be formatted as <media-type>=<path>", attach)
+		}
+		if !strings.Contains(mediaType, "/") {
+			return nil, fmt.Errorf("Expected media type '%s' of --attach '%s' to be formatted as type/subtype, such as application/vnd.cyclonedx+json", mediaType, attach)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Reading --attach '%s': %s", attach, err)
+		}
+		attachments = append(attachments, pushAttachment{mediaType: mediaType, path: path, content: content})
+	}
+	return attachments, nil
+}
+
+// attach pushes each attachment to the repository of imageRef as an artifact referencing it, which registries list
+// with the referrers API, or in the index of the sha256-<digest> tag when they do not support it
+func attach(reg registry.Registry, imageRef regname.Digest, attachments []pushAttachment) ([]lockconfig.BundleAttachmentRef, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	desc, err := reg.Get(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("Reading image '%s': %s", imageRef.Name(), err)
+	}
+	subject := regv1.Descriptor{MediaType: desc.MediaType, Size: desc.Size, Digest: desc.Digest}
+
+	var attached []lockconfig.BundleAttachmentRef
+	for _, attachment := range attachments {
+		artifact, err := attachment.artifact(subject)
+		if err != nil {
+			return attached, err
+		}
+		digest, err := artifact.Digest()
+		if err != nil {
+			return attached, err
+		}
+
+		artifactRef := imageRef.Context().Digest(digest.String())
+		err = reg.WriteImage(artifactRef, artifact, nil)
+		if err != nil {
+			return attached, fmt.Errorf("Attaching '%s': %s", attachment.path, err)
+		}
+		attached = append(attached, lockconfig.BundleAttachmentRef{Image: artifactRef.Name(), MediaType: attachment.mediaType})
+	}
+	return attached, nil
+}
+
+// artifact returns the artifact of the attachment referencing subject: its file is the only layer, and its media type
+// the one of the config, which is the artifact type registries list the referrers with
+func (a pushAttachment) artifact(subject regv1.Descriptor) (regv1.Image, error) {
+	artifact := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.MediaType(a.mediaType))
+	artifact, err := mutate.Append(artifact, mutate.Addendum{
+		Layer:       static.NewLayer(a.content, types.MediaType(a.mediaType)),
+		Annotations: map[string]string{ociTitleAnnotation: filepath.Base(a.path)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutate.Subject(artifact, subject).(regv1.Image), nil
+}
+
+// printAttachments reports the attachments of imageURL
+func (po *PushOptions) printAttachments(imageURL string, attached []lockconfig.BundleAttachmentRef) {
+	if len(attached) == 0 {
+		return
+	}
+	if po.uiFlags == nil || !po.uiFlags.JSON {
+		for _, attachment := range attached {
+			po.ui.BeginLinef("Attached '%s' to '%s' as '%s'", attachment.MediaType, imageURL, attachment.Image)
+		}
+		return
+	}
+
+	table := uitable.Table{
+		Title:   "Attachments",
+		Content: "attachments",
+		Header: []uitable.Header{
+			uitable.NewHeader("Subject"),
+			uitable.NewHeader("Media Type"),
+			uitable.NewHeader("Reference"),
+		},
+	}
+	for _, attachment := range attached {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(imageURL),
+			uitable.NewValueString(attachment.MediaType),
+			uitable.NewValueString(attachment.Image),
+		})
+	}
+	po.ui.PrintTable(table)
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// buildLayerCache cache of the layers built by push --build-cache, stored with the blobs of the cache of --cache-dir
+type buildLayerCache struct {
+	cache *registry.BlobCache
+	// maxSize size of the cache once a layer is added, the least recently used blobs being removed
+	maxSize int64
+}
+
+var _ ctlimg.LayerCache = buildLayerCache{}
+
+func (c buildLayerCache) Layer(key string) (ctlimg.CachedLayer, bool) {
+	layer, found := c.cache.BuiltLayer(key)
+	return ctlimg.CachedLayer{Digest: layer.Digest, DiffID: layer.DiffID, Size: layer.Size}, found
+}
+
+// Add adds the layer, and prunes the cache when it is larger than maxSize
+func (c buildLayerCache) Add(key string, layer ctlimg.CachedLayer, compressedPath string) error {
+	err := c.cache.AddBuiltLayer(key, registry.BuiltLayer{Digest: layer.Digest, DiffID: layer.DiffID, Size: layer.Size}, compressedPath)
+	if err != nil {
+		return err
+	}
+	_, err = c.cache.Prune(c.maxSize)
+	return err
+}
+
+func (c buildLayerCache) Open(digest regv1.Hash) (io.ReadCloser, error) {
+	file, _, found := c.cache.Open(digest)
+	if !found {
+		return nil, fmt.Errorf("Expected layer '%s' to be in the cache (hint: Push again to build it)", digest)
+	}
+	return file, nil
+}
+
+// layerCache returns the cache of --build-cache in cacheDir, the cache of --cache-dir, nil when it is not provided
+func (po *PushOptions) layerCache(cacheDir string) (ctlimg.LayerCache, error) {
+	if !po.BuildCache {
+		return nil, nil
+	}
+
+	switch {
+	case po.RegistryFlags.NoCache:
+		return nil, fmt.Errorf("Cannot use --build-cache with --no-cache, the layers built are stored in the cache")
+	case po.FileFlags.FromTar != "":
+		return nil, fmt.Errorf("Cannot use --build-cache with --from-tar, only the layers built from files are cached")
+	case cacheDir == "":
+		return nil, fmt.Errorf("Unable to find the cache directory (hint: Use --cache-dir to provide it)")
+	}
+
+	maxSize, err := parseByteSize(po.BuildCacheMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing --build-cache-max-size: %s", err)
+	}
+	return buildLayerCache{cache: registry.NewBlobCache(cacheDir), maxSize: maxSize}, nil
+}
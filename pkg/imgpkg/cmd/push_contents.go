@@ -0,0 +1,88 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+)
+
+// contentsListed prints the entries of the layer of the image, and the parameters it is compressed with, with
+// --verbose-contents, and writes them to --contents-output, so that those of two images expected to have the same
+// digest can be diffed
+func (po *PushOptions) contentsListed(contents ctlimg.LayerContents) error {
+	if po.VerboseContents {
+		table := uitable.Table{
+			Title:   "Contents",
+			Content: "entries",
+			Header: []uitable.Header{
+				uitable.NewHeader("Path"),
+				uitable.NewHeader("Type"),
+				uitable.NewHeader("Mode"),
+				uitable.NewHeader("Size"),
+				uitable.NewHeader("SHA256"),
+			},
+		}
+		for _, entry := range contents.Entries {
+			table.Rows = append(table.Rows, []uitable.Value{
+				uitable.NewValueString(entry.Path),
+				uitable.NewValueString(entry.Type),
+				uitable.NewValueString(entry.Mode),
+				uitable.NewValueInt(int(entry.Size)),
+				uitable.NewValueString(entry.SHA256),
+			})
+		}
+		if po.uiFlags == nil || !po.uiFlags.JSON {
+			util.NewLoggerNoTTY(po.ui).Logf("Compression: %s\n", contents.Compression)
+		} else {
+			table.Notes = []string{"Compression: " + contents.Compression.String()}
+		}
+		po.ui.PrintTable(table)
+	}
+
+	if po.ContentsOutput == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(po.ContentsOutput, append(content, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("Writing --contents-output: %s", err)
+	}
+	return nil
+}
+
+// excludeContentsOutput leaves --contents-output out of the image when it is in one of the directories pushed, so
+// that the listing of a push is not part of the image of the next one
+func (po *PushOptions) excludeContentsOutput() error {
+	if po.ContentsOutput == "" {
+		return nil
+	}
+	outputPath, err := filepath.Abs(po.ContentsOutput)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range po.FileFlags.Files {
+		dirPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dirPath, outputPath)
+		if err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			continue
+		}
+		po.FileFlags.ExcludedFilePaths = append(po.FileFlags.ExcludedFilePaths, filepath.ToSlash(relPath))
+	}
+	return nil
+}
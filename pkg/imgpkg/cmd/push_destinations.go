@@ -0,0 +1,296 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushDestination tag push writes the image to, the one of --bundle (-b) or --image (-i), or one of --also-push-to
+type pushDestination struct {
+	tag            regname.Tag
+	additionalTags []regname.Tag
+
+	upload *uploadProgressWriter
+	// overwrite checks the tag before the image is written, nil when the tag can be overwritten
+	overwrite *noOverwriteWriter
+	// writer writes the image, through overwrite when it is not nil
+	writer bundle.ImagesMetadataWriter
+	// err why the image could not be written to the destination
+	err error
+}
+
+// multiDestinationWriter writes the image built by push to each destination, one after the other, their blobs being
+// read from the image built once. A destination failing does not stop the others, the image being only reported as
+// not written when it could not be written to any of them. The registry authentication of each destination is the
+// one of its registry
+type multiDestinationWriter struct {
+	bundle.ImagesMetadataWriter
+
+	destinations []*pushDestination
+	// current destination the image is written to
+	current *pushDestination
+	// started time the write to the next destination started, the first one including the build of the image
+	started time.Time
+	// events stream the upload to each destination is reported to, nil when not enabled
+	events *util.EventStream
+}
+
+var _ bundle.ImagesMetadataWriter = &multiDestinationWriter{}
+
+// WriteImage writes img to the tag of each destination, push writing a single image
+func (w *multiDestinationWriter) WriteImage(_ regname.Reference, img regv1.Image, updates chan regv1.Update) error {
+	for _, dest := range w.destinations {
+		w.current = dest
+		w.events.Phase("upload", dest.tag.Name())
+		dest.err = dest.writer.WriteImage(dest.tag, img, updates)
+		dest.upload.stats.Duration = time.Since(w.started)
+		w.started = time.Now()
+	}
+	w.current = nil
+	return w.err()
+}
+
+// WriteTag writes tag in the repository of each destination the image was written to
+func (w *multiDestinationWriter) WriteTag(tag regname.Tag, taggable regremote.Taggable) error {
+	for _, dest := range w.destinations {
+		if dest.err == nil {
+			dest.err = dest.writer.WriteTag(dest.tag.Context().Tag(tag.TagStr()), taggable)
+		}
+	}
+	return w.err()
+}
+
+// blobMounted records that the registry of the current destination mounted the blob with digest
+func (w *multiDestinationWriter) blobMounted(digest string) {
+	if w.current != nil {
+		w.current.upload.blobMounted(digest)
+	}
+}
+
+// err returns the failures of the destinations when none of them was written, nil otherwise
+func (w *multiDestinationWriter) err() error {
+	if len(w.destinations) == 1 {
+		return w.destinations[0].err
+	}
+
+	var failures []string
+	for _, dest := range w.destinations {
+		if dest.err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("Destination: '%s'\nError: %s", dest.tag.Name(), dest.err))
+	}
+	return fmt.Errorf("Pushing to each of the %d destination(s) failed:\n%s", len(w.destinations), strings.Join(failures, "\n"))
+}
+
+// eventStats totals of the upload to the destinations the image was written to, reported by the terminal event of
+// --progress-format json-lines
+func (w *multiDestinationWriter) eventStats() util.EventStats {
+	stats := util.EventStats{}
+	if w == nil {
+		return stats
+	}
+	for _, dest := range w.destinations {
+		if dest.err != nil {
+			continue
+		}
+		stats.Images++
+		stats.Blobs += dest.upload.stats.BlobsUploaded
+		stats.BlobsMounted += dest.upload.stats.BlobsMounted
+		stats.BlobsSkipped += dest.upload.stats.BlobsSkipped
+		stats.Bytes += dest.upload.stats.BytesUploaded
+	}
+	return stats
+}
+
+// newMultiDestinationWriter creates the writer of the image to the destinations of destTags, each with its own
+// uploadProgressWriter so that what is uploaded is reported for each of them
+func (po *PushOptions) newMultiDestinationWriter(reg registry.Registry, destTags []regname.Tag, mountFrom *regname.Repository, started time.Time) (*multiDestinationWriter, error) {
+	writer := &multiDestinationWriter{ImagesMetadataWriter: reg, started: started, events: po.events}
+	progress := po.uploadProgress(util.NewUILevelLogger(util.LogWarn, util.NewLogger(po.ui)))
+
+	for _, tag := range destTags {
+		additionalTags, err := po.additionalTags(tag.Repository)
+		if err != nil {
+			return nil, err
+		}
+
+		// registries only mount blobs from their own repositories
+		destMountFrom := mountFrom
+		if mountFrom != nil && mountFrom.RegistryStr() != tag.RegistryStr() {
+			destMountFrom = nil
+		}
+
+		dest := &pushDestination{tag: tag, additionalTags: additionalTags,
+			upload: newUploadProgressWriter(reg, po.Concurrency, progress, destMountFrom)}
+		dest.writer = dest.upload
+		dest.overwrite = po.newNoOverwriteWriter(dest.upload, reg)
+		if dest.overwrite != nil {
+			dest.writer = dest.overwrite
+		}
+		writer.destinations = append(writer.destinations, dest)
+	}
+	return writer, nil
+}
+
+// checkPushPermission checks that each repository of destTags can be pushed to, before the image is built and its
+// blobs uploaded, so that a repository that does not exist, or that the credentials cannot push to, fails the push early
+func (po *PushOptions) checkPushPermission(reg registry.Registry, destTags []regname.Tag) error {
+	if po.SkipPreflight {
+		return nil
+	}
+
+	checked := map[string]bool{}
+	for _, tag := range destTags {
+		if checked[tag.Context().Name()] {
+			continue
+		}
+		checked[tag.Context().Name()] = true
+
+		po.events.Phase("check", tag.Context().Name())
+		err := reg.CheckPushPermission(tag.Context())
+		if err != nil {
+			return fmt.Errorf("%s (use --skip-preflight to push without checking it first)", err)
+		}
+	}
+	return nil
+}
+
+// destinationTags returns the tags the image is pushed to: the one of --bundle (-b) or --image (-i), followed by those
+// of --also-push-to, which have its tag unless they provide one. They are parsed before pushing so that an invalid one
+// does not leave the image pushed to only some of them
+func (po *PushOptions) destinationTags() ([]regname.Tag, error) {
+	if (po.BundleFlags.Bundle == "") == (po.ImageFlags.Image == "") {
+		// the lack of, or the conflict between, destinations is reported when pushing
+		return nil, nil
+	}
+
+	uploadTag, err := regname.NewTag(po.BundleFlags.Bundle+po.ImageFlags.Image, regname.WeakValidation)
+	if err != nil {
+		// an invalid destination is reported when pushing, after the other checks of the image or bundle
+		return nil, nil
+	}
+
+	tags := []regname.Tag{uploadTag}
+	found := map[string]bool{uploadTag.Name(): true}
+	for _, dest := range po.AlsoPushTo {
+		tag, err := regname.NewTag(dest, regname.WeakValidation)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing --also-push-to '%s': %s", dest, err)
+		}
+		if repo, err := regname.NewRepository(dest, regname.WeakValidation); err == nil {
+			tag = repo.Tag(uploadTag.TagStr())
+		}
+		if found[tag.Name()] {
+			return nil, fmt.Errorf("Expected --also-push-to '%s' to be a different destination than '%s' and the other --also-push-to", dest, uploadTag.Name())
+		}
+		found[tag.Name()] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// finishDestinations tags the image in each destination it was pushed to with --additional-tag, attaches the
+// attachments to it there, signs it when signer is not nil, records them in --lock-output and --digest-file, the first
+// one as the bundle, and reports what was pushed to each of them. The destinations the image could not be pushed to,
+// attached to or signed in, are reported in the error returned, once the others are finished
+func (po *PushOptions) finishDestinations(reg registry.Registry, writer *multiDestinationWriter, signer *signature.Signer, attachments []pushAttachment,
+	imageURL string, annotations map[string]string, isBundle bool) error {
+	digestRef, err := regname.NewDigest(imageURL)
+	if err != nil {
+		return err
+	}
+
+	var pushed []lockconfig.BundleDestinationRef
+	var pushedDests []*pushDestination
+	var signatures []string
+	var attached [][]lockconfig.BundleAttachmentRef
+	var failures, tagFailures, attachFailures, signFailures []string
+	var tagErr error
+	for _, dest := range writer.destinations {
+		if dest.err != nil {
+			failures = append(failures, fmt.Sprintf("Destination: '%s'\nError: %s", dest.tag.Name(), dest.err))
+			continue
+		}
+
+		// the image stays pushed when tagging it fails, so it is reported, and recorded in the lock, with the tags written
+		destURL := fmt.Sprintf("%s@%s", dest.tag.Context(), digestRef.DigestStr())
+		writtenTags, err := po.writeAdditionalTags(reg, destURL, dest.additionalTags)
+		if err != nil {
+			tagErr = err
+			tagFailures = append(tagFailures, err.Error())
+		}
+		pushed = append(pushed, lockconfig.BundleDestinationRef{Image: destURL, Tag: dest.tag.TagStr(), AdditionalTags: writtenTags})
+		pushedDests = append(pushedDests, dest)
+
+		destAttached, err := attach(reg, dest.tag.Context().Digest(digestRef.DigestStr()), attachments)
+		if err != nil {
+			attachFailures = append(attachFailures, fmt.Sprintf("Destination: '%s'\nError: %s", dest.tag.Name(), err))
+		}
+		attached = append(attached, destAttached)
+
+		signatures = append(signatures, "")
+		if signer != nil {
+			sigTag, err := signer.Sign(dest.tag.Context().Digest(digestRef.DigestStr()))
+			if err != nil {
+				signFailures = append(signFailures, fmt.Sprintf("Destination: '%s'\nError: %s", dest.tag.Name(), err))
+				continue
+			}
+			signatures[len(signatures)-1] = sigTag.Name()
+		}
+	}
+
+	if isBundle {
+		err = po.writeBundleLock(pushed[0].Image, pushed[0].Tag, pushed[0].AdditionalTags, annotations, pushed[1:], attached[0])
+		if err != nil {
+			return err
+		}
+	}
+	err = po.DigestFileFlags.WriteDigest(pushed[0].Image)
+	if err != nil {
+		return err
+	}
+
+	for idx, dest := range pushedDests {
+		if dest.overwrite != nil && dest.overwrite.unchanged {
+			po.ui.BeginLinef("Tag '%s' already points to '%s', nothing was pushed", dest.tag.Name(), pushed[idx].Image)
+		}
+		po.printUploadStats(pushed[idx].Image, append([]string{dest.tag.TagStr()}, pushed[idx].AdditionalTags...), *dest.upload.stats)
+		po.ui.BeginLinef("Pushed '%s'", pushed[idx].Image)
+		for _, tag := range pushed[idx].AdditionalTags {
+			po.ui.BeginLinef("Tagged '%s' as '%s'", pushed[idx].Image, tag)
+		}
+		po.printAttachments(pushed[idx].Image, attached[idx])
+		if signatures[idx] != "" {
+			po.ui.BeginLinef("Signed '%s' with signature '%s'", pushed[idx].Image, signatures[idx])
+		}
+	}
+
+	if len(failures) > 0 {
+		return newCodedError(ErrorCodeDestinationFailed, fmt.Errorf("Pushed '%s' to %d of %d destination(s), but pushing to the others failed:\n%s",
+			digestRef.DigestStr(), len(pushed), len(writer.destinations), strings.Join(append(append(append(failures, attachFailures...), signFailures...), tagFailures...), "\n")))
+	}
+	if len(attachFailures) > 0 {
+		return newCodedError(ErrorCodeAttachFailed, fmt.Errorf("Pushed '%s', but attaching --attach to it failed:\n%s", digestRef.DigestStr(), strings.Join(attachFailures, "\n")))
+	}
+	if len(signFailures) > 0 {
+		return newCodedError(ErrorCodeSignFailed, fmt.Errorf("Pushed '%s', but signing it failed:\n%s", digestRef.DigestStr(), strings.Join(signFailures, "\n")))
+	}
+	if len(tagFailures) > 1 {
+		return newCodedError(ErrorCodeAdditionalTagFailed, fmt.Errorf("%s", strings.Join(tagFailures, "\n")))
+	}
+	return tagErr
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+)
+
+const (
+	// GitRefAnnotation annotation of the images pushed with --from-git, with the ref of the repository cloned
+	GitRefAnnotation = "imgpkg.carvel.dev/git-ref"
+	// ociSourceAnnotation annotation of the images pushed with --from-git, with the remote of the repository cloned
+	ociSourceAnnotation = "org.opencontainers.image.source"
+	// ociRevisionAnnotation annotation of the images pushed with --from-git, with the commit cloned
+	ociRevisionAnnotation = "org.opencontainers.image.revision"
+)
+
+// cloneFromGit clones the repository of --from-git, nil is returned when it is not provided. The content of the clone
+// is pushed instead of the files of --file, with the annotations of the clone added to imageOpts. The clone has to be
+// removed, it is removed when it fails
+func (po *PushOptions) cloneFromGit(imageOpts *ctlimg.FileImageOpts) (*gitClone, error) {
+	if po.FileFlags.FromGit == "" {
+		return nil, nil
+	}
+	po.events.Phase("clone", "")
+	clone, err := po.FileFlags.CloneFromGit()
+	if err != nil {
+		return nil, err
+	}
+
+	contentDir, err := clone.ContentDir()
+	if err == nil {
+		imageOpts.Annotations, err = clone.Annotations(imageOpts.Annotations)
+	}
+	if err != nil {
+		_ = clone.Remove()
+		return nil, err
+	}
+
+	po.FileFlags.Files = []string{contentDir}
+	if clone.source.path == "" {
+		// left out without being reported, the history of the clone is not part of the repository
+		po.FileFlags.ExcludedFilePaths = append(po.FileFlags.ExcludedFilePaths, ".git")
+	}
+	return clone, nil
+}
+
+// gitSource repository, ref and path of --from-git
+type gitSource struct {
+	remote string
+	// ref branch, tag or commit cloned, the default branch of the remote when empty
+	ref string
+	// path of the directory of the repository that is the content of the image, with '/', empty for the root
+	path string
+}
+
+// parseGitSource parses source in the format REMOTE[//PATH][?ref=REF], REMOTE being a URL, or a scp-like address for
+// ssh, that git clones
+func parseGitSource(source string) (gitSource, error) {
+	parsed := gitSource{remote: source}
+	if idx := strings.LastIndex(parsed.remote, "?"); idx >= 0 {
+		query, err := url.ParseQuery(parsed.remote[idx+1:])
+		if err != nil {
+			return gitSource{}, fmt.Errorf("Parsing the query of --from-git '%s': %s", source, err)
+		}
+		for key := range query {
+			if key != "ref" {
+				return gitSource{}, fmt.Errorf("Expected --from-git '%s' to only have the ref parameter, but it has '%s'", source, key)
+			}
+		}
+		parsed.ref = query.Get("ref")
+		parsed.remote = parsed.remote[:idx]
+	}
+
+	start := 0
+	if idx := strings.Index(parsed.remote, "://"); idx >= 0 {
+		start = idx + len("://")
+	}
+	if idx := strings.Index(parsed.remote[start:], "//"); idx >= 0 {
+		parsed.path = strings.Trim(parsed.remote[start+idx+len("//"):], "/")
+		parsed.remote = parsed.remote[:start+idx]
+	}
+
+	switch {
+	case parsed.remote == "":
+		return gitSource{}, fmt.Errorf("Expected --from-git '%s' to have the remote of a repository (format: https://github.com/org/repo//path?ref=v1.0.0)", source)
+	// arguments starting with '-' would be options of git
+	case strings.HasPrefix(parsed.remote, "-"), strings.HasPrefix(parsed.ref, "-"):
+		return gitSource{}, fmt.Errorf("Expected the remote and the ref of --from-git '%s' to not start with '-'", source)
+	}
+	for _, segment := range strings.Split(parsed.path, "/") {
+		if segment == ".." {
+			return gitSource{}, fmt.Errorf("Expected the path of --from-git '%s' to be inside of the repository", source)
+		}
+	}
+	return parsed, nil
+}
+
+// redactedRemote returns the remote without the credentials of its URL, so that they are not recorded in the image
+func (s gitSource) redactedRemote() string {
+	remoteURL, err := url.Parse(s.remote)
+	if err != nil || remoteURL.User == nil {
+		return s.remote
+	}
+	remoteURL.User = nil
+	return remoteURL.String()
+}
+
+// gitClone shallow clone of a gitSource in a temporary directory
+type gitClone struct {
+	source gitSource
+	dir    string
+	// commit cloned
+	commit string
+}
+
+// cloneGitSource clones the commit of the ref of source, and only that commit, with the git executable, so that the
+// credential helpers of git and the ssh agent authenticate as they do for git. The lines are checked out as they are
+// committed, so that the same commit results in the same image wherever it is pushed from. The clone is removed when
+// cloning fails, it has to be removed with Remove otherwise
+func cloneGitSource(source gitSource) (*gitClone, error) {
+	dir, err := os.MkdirTemp("", "imgpkg-from-git")
+	if err != nil {
+		return nil, err
+	}
+	clone := &gitClone{source: source, dir: dir}
+
+	err = clone.checkout()
+	if err != nil {
+		_ = clone.Remove()
+		return nil, fmt.Errorf("Cloning --from-git '%s': %s", source.redactedRemote(), err)
+	}
+	return clone, nil
+}
+
+// checkout fetches the commit of the ref of the source in the directory of the clone, and checks it out
+func (c *gitClone) checkout() error {
+	ref := c.source.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", c.source.remote},
+		{"fetch", "--quiet", "--depth", "1", "--no-tags", "origin", ref},
+		{"checkout", "--quiet", "--detach", "FETCH_HEAD"},
+	} {
+		_, err := c.git(args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	commit, err := c.git("rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	c.commit = strings.TrimSpace(commit)
+	return nil
+}
+
+// git runs git in the directory of the clone with args, returning its output
+func (c *gitClone) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", c.dir, "-c", "core.autocrlf=false", "-c", "core.eol=lf", "-c", "advice.detachedHead=false"}, args...)...)
+	// the credentials come from the credential helpers and the ssh agent, git cannot prompt for them
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("Running 'git %s': %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// ContentDir returns the directory of the clone that is the content of the image, the path of the source
+func (c *gitClone) ContentDir() (string, error) {
+	contentDir := filepath.Join(c.dir, filepath.FromSlash(c.source.path))
+	info, err := os.Stat(contentDir)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("Expected path '%s' to be a directory of the commit '%s' of '%s'", c.source.path, c.commit, c.source.redactedRemote())
+	}
+
+	// a symlink of the repository could point outside of the clone
+	realDir, err := filepath.EvalSymlinks(contentDir)
+	if err != nil {
+		return "", err
+	}
+	realClone, err := filepath.EvalSymlinks(c.dir)
+	if err != nil {
+		return "", err
+	}
+	if !isSubPath(realClone, realDir) {
+		return "", fmt.Errorf("Expected path '%s' of '%s' to be inside of the repository, but it points to '%s'", c.source.path, c.source.redactedRemote(), realDir)
+	}
+	return contentDir, nil
+}
+
+// Annotations returns annotations with the remote, the ref and the commit of the clone. They cannot be provided with
+// --annotation as well
+func (c *gitClone) Annotations(annotations map[string]string) (map[string]string, error) {
+	gitAnnotations := map[string]string{ociSourceAnnotation: c.source.redactedRemote(), ociRevisionAnnotation: c.commit}
+	if c.source.ref != "" {
+		gitAnnotations[GitRefAnnotation] = c.source.ref
+	}
+
+	merged := map[string]string{}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	for key, value := range gitAnnotations {
+		if _, found := merged[key]; found {
+			return nil, fmt.Errorf("Expected annotation '%s' to be provided only once, --from-git sets it", key)
+		}
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// Remove removes the clone
+func (c *gitClone) Remove() error {
+	return os.RemoveAll(c.dir)
+}
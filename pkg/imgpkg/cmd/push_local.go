@@ -0,0 +1,183 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/imagedesc"
+	ctlimgset "carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/imagetar"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// localImagesWriter writes the image built by push to an OCI image layout, or to a tar in the format of copy --to-tar,
+// instead of the registry. Only the writes are replaced, push does not read images from the registry
+type localImagesWriter struct {
+	bundle.ImagesMetadataWriter
+
+	ociLayoutPath string
+	tarPath       string
+	isBundle      bool
+	// ctx stops writing the OCI image layout when the command is interrupted
+	ctx context.Context
+}
+
+var _ bundle.ImagesMetadataWriter = localImagesWriter{}
+
+// WriteImage writes img, recording ref as the reference it is meant for
+func (w localImagesWriter) WriteImage(ref regname.Reference, img regv1.Image, _ chan regv1.Update) error {
+	if w.ociLayoutPath != "" {
+		return ctlimg.WriteOCILayoutWithRefName(w.ctx, w.ociLayoutPath, img, ref.Name())
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	digestRef := ref.Context().Digest(digest.String())
+
+	metadata := imagedesc.Metadata{Ref: digestRef, OrigRef: ref.Name(), Labels: map[string]string{}}
+	if tagRef, ok := ref.(regname.Tag); ok {
+		metadata.Tag = tagRef.TagStr()
+	}
+	if w.isBundle {
+		metadata.Labels[ctlimgset.RootBundleLabelKey] = ""
+	}
+
+	ids, err := imagedesc.NewImageRefDescriptors([]imagedesc.Metadata{metadata}, localImage{digestRef: digestRef, img: img}, 1)
+	if err != nil {
+		return err
+	}
+
+	tarOpener := func() (io.WriteCloser, error) { return os.Create(w.tarPath) }
+	err = imagetar.NewTarWriter(ids, tarOpener, imagetar.TarWriterOpts{Concurrency: 1}, util.NewNoopLogger(), imagetar.NewImageLayerWriterCheck(true), nil).Write()
+	if err != nil {
+		return fmt.Errorf("Writing tar '%s': %s", w.tarPath, err)
+	}
+	return nil
+}
+
+// WriteTag does nothing, the reference recorded by WriteImage has the tag, and copy tags the image when it is copied
+func (w localImagesWriter) WriteTag(regname.Tag, regremote.Taggable) error { return nil }
+
+// localImage provides the image written by localImagesWriter to imagedesc, as if it was in a registry
+type localImage struct {
+	digestRef regname.Digest
+	img       regv1.Image
+}
+
+var _ imagedesc.Registry = localImage{}
+
+func (l localImage) Get(ref regname.Reference) (*regremote.Descriptor, error) {
+	if ref.Name() != l.digestRef.Name() {
+		return nil, fmt.Errorf("Expected image '%s' to be '%s'", ref.Name(), l.digestRef.Name())
+	}
+	mediaType, err := l.img.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	digest, err := l.img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.img.Size()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := l.img.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	return &regremote.Descriptor{Descriptor: regv1.Descriptor{MediaType: mediaType, Digest: digest, Size: size}, Manifest: manifest}, nil
+}
+
+func (l localImage) Digest(ref regname.Reference) (regv1.Hash, error) {
+	desc, err := l.Get(ref)
+	if err != nil {
+		return regv1.Hash{}, err
+	}
+	return desc.Digest, nil
+}
+
+func (l localImage) Index(ref regname.Reference) (regv1.ImageIndex, error) {
+	return nil, fmt.Errorf("Expected '%s' to be an image", ref.Name())
+}
+
+func (l localImage) Image(ref regname.Reference) (regv1.Image, error) {
+	_, err := l.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return l.img, nil
+}
+
+// dryRunImagesWriter records the size of the image built by push --dry-run instead of writing it, nothing is sent to
+// the registry
+type dryRunImagesWriter struct {
+	bundle.ImagesMetadataWriter
+
+	// size of the manifest, config and layers of the image
+	size int64
+}
+
+var _ bundle.ImagesMetadataWriter = &dryRunImagesWriter{}
+
+// WriteImage records the size of img
+func (w *dryRunImagesWriter) WriteImage(_ regname.Reference, img regv1.Image, _ chan regv1.Update) error {
+	manifestSize, err := img.Size()
+	if err != nil {
+		return err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	w.size = manifestSize + manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		w.size += layer.Size
+	}
+	return nil
+}
+
+// WriteTag does nothing, the image is not written
+func (w *dryRunImagesWriter) WriteTag(regname.Tag, regremote.Taggable) error { return nil }
+
+// printDryRun prints the digest and the size the image would have when pushed, as a result when --json is provided
+func (po *PushOptions) printDryRun(imageURL string, size int64) {
+	digest := ""
+	if digestRef, err := regname.NewDigest(imageURL); err == nil {
+		digest = digestRef.DigestStr()
+	}
+
+	if po.uiFlags == nil || !po.uiFlags.JSON {
+		util.NewLoggerNoTTY(po.ui).Logf("Dry run, nothing was pushed: '%s' would have digest %s and %d bytes\n", imageURL, digest, size)
+		return
+	}
+
+	po.ui.PrintTable(uitable.Table{
+		Title:   "Push dry run result",
+		Content: "result",
+		Header: append([]uitable.Header{
+			uitable.NewHeader("Reference"),
+			uitable.NewHeader("Digest"),
+			uitable.NewHeader("Size"),
+		}, sizeEstimateHeaders()...),
+		Rows: [][]uitable.Value{append([]uitable.Value{
+			uitable.NewValueString(imageURL),
+			uitable.NewValueString(digest),
+			uitable.NewValueInt(int(size)),
+		}, po.sizeEstimateValues()...)},
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// noOverwriteEnv environment variable that, when true, makes push behave as with --no-overwrite for every tag but latest
+const noOverwriteEnv = "IMGPKG_PUSH_NO_OVERWRITE"
+
+// noOverwriteWriter refuses to write an image to a tag that already points to a different image. Writing an image to
+// a tag that already points to it is skipped, together with its tags, so that pushing the same content is a no-op
+type noOverwriteWriter struct {
+	bundle.ImagesMetadataWriter
+
+	reg registry.Registry
+	// latestAllowed lets the image replace what the tag latest points to
+	latestAllowed bool
+	// unchanged is set when the tag already points to the image written
+	unchanged bool
+}
+
+var _ bundle.ImagesMetadataWriter = &noOverwriteWriter{}
+
+// WriteImage writes img to ref unless ref already points to an image. The registry is asked for the digest of ref
+// only once the image is built, so that its digest can be compared, but before any of its blobs are uploaded
+func (w *noOverwriteWriter) WriteImage(ref regname.Reference, img regv1.Image, updates chan regv1.Update) error {
+	if tag, ok := ref.(regname.Tag); ok && w.latestAllowed && tag.TagStr() == "latest" {
+		return w.ImagesMetadataWriter.WriteImage(ref, img, updates)
+	}
+
+	existing, err := w.reg.Digest(ref)
+	if err != nil {
+		if ErrorCode(err) != ErrorCodeNotFound {
+			return fmt.Errorf("Checking if tag '%s' exists: %s", ref.Name(), err)
+		}
+		return w.ImagesMetadataWriter.WriteImage(ref, img, updates)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	if existing != digest {
+		return newCodedError(ErrorCodeTagExists, fmt.Errorf("Expected tag '%s' to not exist, or to point to the image pushed '%s', but it points to '%s' (hint: Use --overwrite to replace it)",
+			ref.Name(), digest, existing))
+	}
+
+	w.unchanged = true
+	return nil
+}
+
+// WriteTag skips the tags of an image that was not written since it was already there
+func (w *noOverwriteWriter) WriteTag(tag regname.Tag, taggable regremote.Taggable) error {
+	if w.unchanged {
+		return nil
+	}
+	return w.ImagesMetadataWriter.WriteTag(tag, taggable)
+}
+
+// newNoOverwriteWriter wraps writer in a noOverwriteWriter with --no-overwrite, and when IMGPKG_PUSH_NO_OVERWRITE is
+// true, in which case the tag latest can still be replaced. nil is returned when --overwrite is provided or neither is set
+func (po *PushOptions) newNoOverwriteWriter(writer bundle.ImagesMetadataWriter, reg registry.Registry) *noOverwriteWriter {
+	if po.Overwrite || (!po.NoOverwrite && os.Getenv(noOverwriteEnv) != "true") {
+		return nil
+	}
+	return &noOverwriteWriter{ImagesMetadataWriter: writer, reg: reg, latestAllowed: !po.NoOverwrite}
+}
@@ -0,0 +1,418 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// uploadStats what was uploaded by push. Blobs the registry already has are not read, so they are the ones skipped,
+// unless the registry reported that it mounted them from --mount-from
+type uploadStats struct {
+	BytesUploaded int64
+	BlobsUploaded int
+	BlobsMounted  int
+	BlobsSkipped  int
+	Duration      time.Duration
+}
+
+// uploadProgressWriter uploads the blobs of the images written concurrently, reporting the progress of the upload of
+// each one, before writing their manifest. What was uploaded is recorded in stats
+type uploadProgressWriter struct {
+	registry.Registry
+
+	// concurrency number of blobs uploaded at the same time
+	concurrency int
+	newProgress func(blob string) util.ProgressLogger
+	stats       *uploadStats
+	// mountFrom repository the blobs missing in the destination are mounted from, nil when not provided
+	mountFrom *regname.Repository
+
+	lock     sync.Mutex
+	uploaded map[string]int64
+	mounted  map[string]bool
+	readers  []*uploadReader
+}
+
+var _ bundle.ImagesMetadataWriter = &uploadProgressWriter{}
+
+func newUploadProgressWriter(writer registry.Registry, concurrency int, newProgress func(blob string) util.ProgressLogger, mountFrom *regname.Repository) *uploadProgressWriter {
+	return &uploadProgressWriter{Registry: writer, concurrency: concurrency, newProgress: newProgress, stats: &uploadStats{}, mountFrom: mountFrom,
+		uploaded: map[string]int64{}, mounted: map[string]bool{}}
+}
+
+// WriteImage uploads the blobs of img, reading them through uploadReader, and writes its manifest once they are all
+// uploaded
+func (w *uploadProgressWriter) WriteImage(ref regname.Reference, img regv1.Image, _ chan regv1.Update) error {
+	trackedImg := uploadTrackedImage{Image: img, writer: w}
+	err := w.writeBlobs(ref.Context(), trackedImg)
+	if err == nil {
+		// the registry has all the blobs, so only the manifest is uploaded
+		err = w.Registry.WriteImage(ref, trackedImg, nil)
+	}
+
+	w.lock.Lock()
+	readers := w.readers
+	w.readers = nil
+	w.lock.Unlock()
+	// readers are closed by the upload, unless it fails while they are read
+	for _, reader := range readers {
+		reader.endProgress()
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.recordBlobs(img)
+}
+
+// writeBlobs uploads the config and the layers of img, at most concurrency of them at the same time. Every blob is
+// uploaded even when others fail, so that all the failures are reported
+func (w *uploadProgressWriter) writeBlobs(repo regname.Repository, img uploadTrackedImage) error {
+	if w.concurrency < 1 {
+		return fmt.Errorf("Expected --concurrency to be at least 1, was %d", w.concurrency)
+	}
+
+	config, err := img.ConfigLayer()
+	if err != nil {
+		return err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	var blobs []regv1.Layer
+	var digests []string
+	found := map[string]bool{}
+	for _, blob := range append([]regv1.Layer{config}, layers...) {
+		digest, err := blob.Digest()
+		if err != nil {
+			return err
+		}
+		if found[digest.String()] {
+			continue
+		}
+		found[digest.String()] = true
+		blobs = append(blobs, blob)
+		digests = append(digests, digest.String())
+	}
+
+	errs := make([]error, len(blobs))
+	throttle := util.NewThrottle(w.concurrency)
+	wg := sync.WaitGroup{}
+	for idx, blob := range blobs {
+		idx, blob := idx, blob
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle.Take()
+			defer throttle.Done()
+			errs[idx] = w.Registry.WriteLayer(repo, blob)
+		}()
+	}
+	wg.Wait()
+
+	var failures []string
+	for idx, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("Blob: '%s'\nError: %s", digests[idx], err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Uploading %d of %d blob(s) failed:\n%s", len(failures), len(blobs), strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// blobMounted records that the registry mounted the blob with digest instead of having it uploaded
+func (w *uploadProgressWriter) blobMounted(digest string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.mounted[digest] = true
+}
+
+// recordBlobs adds the blobs of img to the stats, those that were neither read nor mounted were already in the registry
+func (w *uploadProgressWriter) recordBlobs(img regv1.Image) error {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, desc := range append([]regv1.Descriptor{manifest.Config}, manifest.Layers...) {
+		if w.mounted[desc.Digest.String()] {
+			w.stats.BlobsMounted++
+			delete(w.mounted, desc.Digest.String())
+			continue
+		}
+		uploadedBytes, found := w.uploaded[desc.Digest.String()]
+		if !found {
+			w.stats.BlobsSkipped++
+			continue
+		}
+		w.stats.BlobsUploaded++
+		w.stats.BytesUploaded += uploadedBytes
+		delete(w.uploaded, desc.Digest.String())
+	}
+	return nil
+}
+
+// uploadTrackedImage image whose layers and config are read through uploadReader
+type uploadTrackedImage struct {
+	regv1.Image
+	writer *uploadProgressWriter
+}
+
+func (i uploadTrackedImage) Layers() ([]regv1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var trackedLayers []regv1.Layer
+	for _, layer := range layers {
+		trackedLayer, err := i.trackedLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		trackedLayers = append(trackedLayers, trackedLayer)
+	}
+	return trackedLayers, nil
+}
+
+func (i uploadTrackedImage) LayerByDigest(digest regv1.Hash) (regv1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return i.trackedLayer(layer)
+}
+
+// ConfigLayer the config blob, which is uploaded like the layers
+func (i uploadTrackedImage) ConfigLayer() (regv1.Layer, error) {
+	layer, err := partial.ConfigLayer(i.Image)
+	if err != nil {
+		return nil, err
+	}
+	return i.trackedLayer(layer)
+}
+
+// trackedLayer wraps layer in uploadTrackedLayer, and in a MountableLayer when --mount-from is provided so that the
+// registry is asked to mount the blob from that repository before it is uploaded
+func (i uploadTrackedImage) trackedLayer(layer regv1.Layer) (regv1.Layer, error) {
+	trackedLayer := uploadTrackedLayer{Layer: layer, writer: i.writer}
+	if i.writer.mountFrom == nil {
+		return trackedLayer, nil
+	}
+
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return &regremote.MountableLayer{Layer: trackedLayer, Reference: i.writer.mountFrom.Digest(digest.String())}, nil
+}
+
+type uploadTrackedLayer struct {
+	regv1.Layer
+	writer *uploadProgressWriter
+}
+
+// Compressed opens the blob when it is uploaded, a blob being opened again when its upload is retried
+func (l uploadTrackedLayer) Compressed() (io.ReadCloser, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.Size()
+	if err != nil {
+		return nil, err
+	}
+	blob, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &uploadReader{ReadCloser: blob, writer: l.writer, digest: digest.String(), size: size,
+		progress: l.writer.newProgress(digest.String()), updates: make(chan regv1.Update)}
+	reader.progress.Start(context.Background(), reader.updates)
+
+	l.writer.lock.Lock()
+	l.writer.uploaded[reader.digest] = 0
+	l.writer.readers = append(l.writer.readers, reader)
+	l.writer.lock.Unlock()
+	return reader, nil
+}
+
+// uploadReader reports the bytes of the blob read by the upload
+type uploadReader struct {
+	io.ReadCloser
+	writer   *uploadProgressWriter
+	digest   string
+	size     int64
+	complete int64
+
+	progress util.ProgressLogger
+	updates  chan regv1.Update
+	endOnce  sync.Once
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.complete += int64(n)
+		r.writer.lock.Lock()
+		r.writer.uploaded[r.digest] = r.complete
+		r.writer.lock.Unlock()
+		r.updates <- regv1.Update{Total: r.size, Complete: r.complete}
+	}
+	return n, err
+}
+
+func (r *uploadReader) Close() error {
+	r.endProgress()
+	return r.ReadCloser.Close()
+}
+
+func (r *uploadReader) endProgress() {
+	r.endOnce.Do(r.progress.End)
+}
+
+// uploadProgress selects how the progress of the upload of each blob is displayed, as pull does for the extraction:
+// the events of --progress-format json-lines, JSON events when --json is provided, progress bars when --tty is provided
+// and periodic log lines otherwise. The bars of the blobs uploaded at the same time are displayed together, one per line
+func (po *PushOptions) uploadProgress(levelLogger util.LoggerWithLevels) func(blob string) util.ProgressLogger {
+	uiFlags := UIFlags{}
+	if po.uiFlags != nil {
+		uiFlags = *po.uiFlags
+	}
+	bars := util.NewProgressBars(os.Stderr)
+
+	return func(blob string) util.ProgressLogger {
+		switch {
+		case po.events != nil:
+			return po.events.NewProgress("", blob, time.Second)
+		case uiFlags.JSON:
+			return util.NewBlobProgressJSON(util.NewLoggerNoTTY(po.ui), blob, time.Second)
+		case uiFlags.TTY:
+			return bars.NewBlobProgressBar(levelLogger, blob, "Error uploading blob")
+		default:
+			return util.NewProgressLines(util.NewLoggerNoTTY(po.ui), "Uploaded "+blob, 10*time.Second)
+		}
+	}
+}
+
+// sizeEstimated reports the size of the files of the image before it is uploaded, so that an image much larger than
+// expected can be stopped. With --json, the size is in the result instead
+func (po *PushOptions) sizeEstimated(estimate ctlimg.PushSizeEstimate) {
+	po.sizeEstimate = &estimate
+	if po.uiFlags == nil || !po.uiFlags.JSON {
+		util.NewLoggerNoTTY(po.ui).Logf("Content: %s\n", estimate)
+	}
+}
+
+// sizeEstimateValues values of the size of the files of the image in the results of --json, empty when it was not
+// built from files
+func (po *PushOptions) sizeEstimateValues() []uitable.Value {
+	if po.sizeEstimate == nil {
+		return []uitable.Value{uitable.NewValueString(""), uitable.NewValueString(""), uitable.NewValueString(""), uitable.NewValueBool(false)}
+	}
+	return []uitable.Value{
+		uitable.NewValueInt(po.sizeEstimate.Files),
+		uitable.NewValueInt(int(po.sizeEstimate.Size)),
+		uitable.NewValueInt(int(po.sizeEstimate.CompressedSize)),
+		uitable.NewValueBool(po.sizeEstimate.Accurate),
+	}
+}
+
+// sizeEstimateHeaders headers of sizeEstimateValues
+func sizeEstimateHeaders() []uitable.Header {
+	return []uitable.Header{
+		uitable.NewHeader("Files"),
+		uitable.NewHeader("Content Size"),
+		uitable.NewHeader("Compressed Size"),
+		uitable.NewHeader("Compressed Size Accurate"),
+	}
+}
+
+// printUploadStats prints what was uploaded, as a result with the digest of the image when --json is provided, so that
+// scripts do not have to parse the logs to know the digest
+func (po *PushOptions) printUploadStats(imageURL string, tags []string, stats uploadStats) {
+	if po.uiFlags == nil || !po.uiFlags.JSON {
+		util.NewLoggerNoTTY(po.ui).Logf("Uploaded %d bytes in %d blob(s), mounted %d blob(s), skipped %d blob(s) already in the registry, in %s\n",
+			stats.BytesUploaded, stats.BlobsUploaded, stats.BlobsMounted, stats.BlobsSkipped, stats.Duration.Round(time.Millisecond))
+		return
+	}
+
+	digest := ""
+	if digestRef, err := regname.NewDigest(imageURL); err == nil {
+		digest = digestRef.DigestStr()
+	}
+	po.ui.PrintTable(uitable.Table{
+		Title:   "Push result",
+		Content: "result",
+		Header: append([]uitable.Header{
+			uitable.NewHeader("Reference"),
+			uitable.NewHeader("Digest"),
+			uitable.NewHeader("Tags"),
+			uitable.NewHeader("Bytes Uploaded"),
+			uitable.NewHeader("Blobs Uploaded"),
+			uitable.NewHeader("Blobs Mounted"),
+			uitable.NewHeader("Blobs Skipped"),
+			uitable.NewHeader("Duration"),
+		}, sizeEstimateHeaders()...),
+		Rows: [][]uitable.Value{append([]uitable.Value{
+			uitable.NewValueString(imageURL),
+			uitable.NewValueString(digest),
+			uitable.NewValueStrings(tags),
+			uitable.NewValueInt(int(stats.BytesUploaded)),
+			uitable.NewValueInt(stats.BlobsUploaded),
+			uitable.NewValueInt(stats.BlobsMounted),
+			uitable.NewValueInt(stats.BlobsSkipped),
+			uitable.NewValueString(stats.Duration.Round(time.Millisecond).String()),
+		}, po.sizeEstimateValues()...)},
+	})
+}
+
+// mountFromRepo the repository of --mount-from, nil when it is not provided. Registries only mount blobs from their own
+// repositories, so it has to be in the registry the image is pushed to
+func (po *PushOptions) mountFromRepo() (*regname.Repository, error) {
+	if po.MountFrom == "" {
+		return nil, nil
+	}
+
+	repo, err := regname.NewRepository(po.MountFrom, regname.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing --mount-from '%s': %s", po.MountFrom, err)
+	}
+
+	if (po.BundleFlags.Bundle == "") == (po.ImageFlags.Image == "") {
+		// the lack of, or the conflict between, destinations is reported when pushing
+		return &repo, nil
+	}
+	dest, err := regname.ParseReference(po.BundleFlags.Bundle+po.ImageFlags.Image, regname.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	if repo.RegistryStr() != dest.Context().RegistryStr() {
+		return nil, fmt.Errorf("Expected --mount-from '%s' to be in the registry '%s' the image is pushed to", po.MountFrom, dest.Context().RegistryStr())
+	}
+	return &repo, nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ctlbundle "carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimgset "carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+// RelocatedImagesAnnotation annotation of the lock of a bundle pushed with --include-referenced-images, with the
+// location each image it references was relocated to, in the repository of the bundle, as a JSON object
+const RelocatedImagesAnnotation = "imgpkg.carvel.dev/relocated-images"
+
+// referencedImagesRelocator relocates the images of the images lock of the bundle pushed with
+// --include-referenced-images to each repository the bundle is pushed to, as copy --to-repo does once it is pushed
+type referencedImagesRelocator struct {
+	logger      util.LoggerWithLevels
+	imageSet    ctlimgset.ImageSet
+	registry    registry.Registry
+	concurrency int
+	repos       []regname.Repository
+	// events stream the relocation to each repository is reported to, nil when not enabled
+	events *util.EventStream
+
+	// images relocated, the images of the images lock and those of the bundles it references
+	images []ctlimgset.UnprocessedImageRef
+	// relocated images relocated to each of repos
+	relocated []*ctlimgset.ProcessedImages
+}
+
+// newReferencedImagesRelocator creates the relocator of the referenced images of the bundle pushed to destTags, nil
+// without --include-referenced-images
+func (po *PushOptions) newReferencedImagesRelocator(reg registry.Registry, destTags []regname.Tag) *referencedImagesRelocator {
+	if !po.IncludeReferencedImages {
+		return nil
+	}
+
+	prefixedLogger := util.NewPrefixedLogger("push | ", util.NewLogger(po.ui))
+	levelLogger := util.NewUILevelLogger(util.LogWarn, prefixedLogger)
+	relocator := &referencedImagesRelocator{
+		logger:      levelLogger,
+		imageSet:    ctlimgset.NewImageSet(po.Concurrency, prefixedLogger, util.DefaultTagGenerator{}),
+		registry:    registry.NewRegistryWithProgress(reg, util.NewProgressBar(levelLogger, "done uploading referenced images", "Error uploading referenced images")),
+		concurrency: po.Concurrency,
+		events:      po.events,
+	}
+	if po.events != nil {
+		relocator.registry = registry.NewRegistryWithProgress(reg, po.events.NewProgress("", "", time.Second))
+	}
+	for _, tag := range destTags {
+		relocator.repos = append(relocator.repos, tag.Context())
+	}
+	return relocator
+}
+
+// relocate copies the images of imagesLock, and the images of the bundles it references, to each repository, before
+// the bundle is built, so that the bundle is not pushed when they cannot all be copied
+func (r *referencedImagesRelocator) relocate(imagesLock lockconfig.ImagesLock) error {
+	refs := ctlimgset.NewUnprocessedImageRefs()
+	var bundles []*ctlbundle.Bundle
+	for _, image := range imagesLock.Images {
+		lockReader := ctlbundle.NewImagesLockReader()
+		bundle := ctlbundle.NewBundleFromRef(image.Image, r.registry, lockReader, ctlbundle.NewRegistryFetcher(r.registry, lockReader))
+		isBundle, err := bundle.IsBundle()
+		if err != nil {
+			return fmt.Errorf("Reading referenced image '%s': %s", image.Image, err)
+		}
+		refs.Add(ctlimgset.UnprocessedImageRef{DigestRef: image.Image, OrigRef: image.Image})
+		if !isBundle {
+			continue
+		}
+
+		nestedBundles, imageRefs, err := bundle.AllImagesLockRefs(r.concurrency, r.logger)
+		if err != nil {
+			return fmt.Errorf("Reading the images of referenced bundle '%s': %s", image.Image, err)
+		}
+		bundles = append(bundles, nestedBundles...)
+		for _, imageRef := range imageRefs.ImageRefs() {
+			refs.Add(ctlimgset.UnprocessedImageRef{DigestRef: imageRef.PrimaryLocation(), OrigRef: imageRef.Image})
+		}
+	}
+	r.images = refs.All()
+	if len(r.images) == 0 {
+		return nil
+	}
+
+	for _, repo := range r.repos {
+		r.events.Phase("relocate", repo.Name())
+		processedImages, err := r.imageSet.Relocate(refs, repo, r.registry)
+		if err != nil {
+			return fmt.Errorf("Relocating the referenced images to '%s': %s", repo.Name(), err)
+		}
+		for _, bundle := range bundles {
+			err = bundle.NoteCopy(processedImages, r.registry, r.logger)
+			if err != nil {
+				return fmt.Errorf("Creating copy information for bundle %s: %s", bundle.DigestRef(), err)
+			}
+		}
+		r.relocated = append(r.relocated, processedImages)
+	}
+	return nil
+}
+
+// noteBundleCopied writes the image-location of the bundle pushed to imageURL, as copy does, recording that the
+// images of its images lock are in its repository, so that pull and copy find them there
+func (r *referencedImagesRelocator) noteBundleCopied(imageURL string, repoIdx int) error {
+	if len(r.images) == 0 {
+		return nil
+	}
+
+	digestRef, err := regname.NewDigest(imageURL)
+	if err != nil {
+		return err
+	}
+	img, err := r.registry.Image(digestRef)
+	if err != nil {
+		return err
+	}
+
+	lockReader := ctlbundle.NewImagesLockReader()
+	bundle := ctlbundle.NewBundleFromRef(imageURL, r.registry, lockReader, ctlbundle.NewRegistryFetcher(r.registry, lockReader))
+	_, _, err = bundle.AllImagesLockRefs(r.concurrency, r.logger)
+	if err != nil {
+		return err
+	}
+
+	processedImages := ctlimgset.NewProcessedImages()
+	for _, image := range r.relocated[repoIdx].All() {
+		processedImages.Add(image)
+	}
+	processedImages.Add(ctlimgset.ProcessedImage{UnprocessedImageRef: ctlimgset.UnprocessedImageRef{DigestRef: imageURL}, DigestRef: imageURL, Image: img})
+	return bundle.NoteCopy(processedImages, r.registry, r.logger)
+}
+
+// relocatedImages returns the location each image was relocated to in the repository of repoIdx
+func (r *referencedImagesRelocator) relocatedImages(repoIdx int) map[string]string {
+	relocated := map[string]string{}
+	for _, image := range r.images {
+		digestRef, err := regname.NewDigest(image.DigestRef)
+		if err != nil {
+			panic(fmt.Sprintf("Internal inconsistency: '%s' have to be a digest", image.DigestRef))
+		}
+		relocated[image.OrigRef] = r.repos[repoIdx].Digest(digestRef.DigestStr()).Name()
+	}
+	return relocated
+}
+
+// lockAnnotations returns annotations with RelocatedImagesAnnotation, recording where the images were relocated to in
+// the repository of repoIdx, the one of the bundle of the lock
+func (r *referencedImagesRelocator) lockAnnotations(annotations map[string]string, repoIdx int) (map[string]string, error) {
+	if len(r.images) == 0 {
+		return annotations, nil
+	}
+
+	relocated, err := json.Marshal(r.relocatedImages(repoIdx))
+	if err != nil {
+		return nil, err
+	}
+	lockAnnotations := map[string]string{RelocatedImagesAnnotation: string(relocated)}
+	for key, value := range annotations {
+		lockAnnotations[key] = value
+	}
+	return lockAnnotations, nil
+}
+
+// printReferencedImages reports the images relocated to the repository of each destination the bundle was pushed to
+func (po *PushOptions) printReferencedImages(relocator *referencedImagesRelocator, pushedRepos []int) {
+	if po.uiFlags == nil || !po.uiFlags.JSON {
+		for _, repoIdx := range pushedRepos {
+			util.NewLoggerNoTTY(po.ui).Logf("Relocated %d referenced image(s) to '%s'\n", len(relocator.images), relocator.repos[repoIdx].Name())
+		}
+		return
+	}
+
+	table := uitable.Table{
+		Title:   "Referenced images",
+		Content: "referenced images",
+		Header: []uitable.Header{
+			uitable.NewHeader("Image"),
+			uitable.NewHeader("Relocated To"),
+		},
+	}
+	for _, repoIdx := range pushedRepos {
+		relocated := relocator.relocatedImages(repoIdx)
+		for _, image := range relocator.images {
+			table.Rows = append(table.Rows, []uitable.Value{
+				uitable.NewValueString(image.OrigRef),
+				uitable.NewValueString(relocated[image.OrigRef]),
+			})
+		}
+	}
+	po.ui.PrintTable(table)
+}
+
+// finishReferencedImages writes the image-location of the bundle in each destination it was pushed to, once relocator
+// relocated its images there, reports them, and returns the annotations of --lock-output
+func (po *PushOptions) finishReferencedImages(relocator *referencedImagesRelocator, writer *multiDestinationWriter, imageURL string,
+	annotations map[string]string) (map[string]string, error) {
+	if relocator == nil {
+		return annotations, nil
+	}
+	digestRef, err := regname.NewDigest(imageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var pushedRepos []int
+	for idx, dest := range writer.destinations {
+		if dest.err != nil {
+			continue
+		}
+		err = relocator.noteBundleCopied(dest.tag.Context().Digest(digestRef.DigestStr()).Name(), idx)
+		if err != nil {
+			return nil, fmt.Errorf("Pushed '%s', but recording that its referenced images were relocated to '%s' failed: %s", digestRef.DigestStr(), dest.tag.Context().Name(), err)
+		}
+		pushedRepos = append(pushedRepos, idx)
+	}
+	po.printReferencedImages(relocator, pushedRepos)
+	// the lock records the first destination the bundle was pushed to
+	return relocator.lockAnnotations(annotations, pushedRepos[0])
+}
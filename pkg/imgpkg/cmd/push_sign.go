@@ -0,0 +1,54 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
+	"github.com/mattn/go-isatty"
+)
+
+// signKeyPassphraseEnv environment variable with the passphrase of the key of --sign-key, the one cosign reads it from
+const signKeyPassphraseEnv = "COSIGN_PASSWORD"
+
+// stdinIsTerminal checks if the passphrase of the key of --sign-key can be asked for
+var stdinIsTerminal = func() bool { return isatty.IsTerminal(os.Stdin.Fd()) }
+
+// signer returns the signer of the image pushed with the key of --sign-key, nil when it is not provided. The key is
+// loaded before pushing, so that an invalid key, or passphrase, does not leave the image pushed without a signature
+func (po *PushOptions) signer(reg registry.Registry) (*signature.Signer, error) {
+	if po.SignKey == "" {
+		return nil, nil
+	}
+	if po.DryRun || po.OCILayoutPath != "" || po.TarPath != "" {
+		return nil, fmt.Errorf("Cannot use --sign-key with --dry-run, --to-oci-layout or --to-tar, only the images pushed to a registry are signed")
+	}
+
+	key, err := signature.LoadSigningKey(po.SignKey, po.signKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("Loading --sign-key: %s", err)
+	}
+	return signature.NewSigner(key, reg), nil
+}
+
+// signKeyPassphrase returns the passphrase of the key of --sign-key, from $COSIGN_PASSWORD or, when it is not set,
+// asked for unless the prompts are disabled with --yes or stdin is not a terminal
+func (po *PushOptions) signKeyPassphrase() ([]byte, error) {
+	if passphrase, found := os.LookupEnv(signKeyPassphraseEnv); found {
+		return []byte(passphrase), nil
+	}
+	if !po.ui.IsInteractive() || !stdinIsTerminal() {
+		return nil, fmt.Errorf("Expected $%s to have the passphrase of the key, which cannot be asked for (hint: Set $%s, or run without --yes in a terminal)",
+			signKeyPassphraseEnv, signKeyPassphraseEnv)
+	}
+
+	passphrase, err := po.ui.AskForPassword("Passphrase of --sign-key")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(passphrase), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+// additionalTags returns the tags of --additional-tag in repo, a repository the image is pushed to. They are parsed
+// before pushing so that an invalid tag does not leave the image pushed with only some of its tags
+func (po *PushOptions) additionalTags(repo regname.Repository) ([]regname.Tag, error) {
+	var tags []regname.Tag
+	for _, tag := range po.AdditionalTags {
+		additionalTag, err := regname.NewTag(repo.Name()+":"+tag, regname.WeakValidation)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing --additional-tag '%s': %s", tag, err)
+		}
+		tags = append(tags, additionalTag)
+	}
+	return tags, nil
+}
+
+// writeAdditionalTags tags the image pushed, at imageURL, with each of the tags, uploading its manifest again for each
+// one. All the tags are written even when some fail, since the image stays pushed, and the tags written are returned
+func (po *PushOptions) writeAdditionalTags(reg registry.Registry, imageURL string, tags []regname.Tag) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	digestRef, err := regname.NewDigest(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := reg.Get(digestRef)
+	if err != nil {
+		return nil, newCodedError(ErrorCodeAdditionalTagFailed, fmt.Errorf("Pushed '%s', but reading it to tag it with --additional-tag failed: %s", imageURL, err))
+	}
+
+	var written []string
+	var failures []string
+	for _, tag := range tags {
+		err := reg.WriteTag(tag, desc)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("Tag: '%s'\nError: %s", tag.TagStr(), err))
+			continue
+		}
+		written = append(written, tag.TagStr())
+	}
+
+	if len(failures) > 0 {
+		return written, newCodedError(ErrorCodeAdditionalTagFailed, fmt.Errorf("Pushed '%s', but tagging it with %d of %d additional tag(s) failed:\n%s",
+			imageURL, len(failures), len(tags), strings.Join(failures, "\n")))
+	}
+	return written, nil
+}
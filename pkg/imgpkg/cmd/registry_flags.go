@@ -4,14 +4,24 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
 	v1 "carvel.dev/imgpkg/pkg/imgpkg/v1"
 	"github.com/spf13/cobra"
 )
 
+// rateLimitLog is where the commands report that a registry rate limits the requests, and what they transferred under
+// --bandwidth-limit
+var rateLimitLog io.Writer = os.Stderr
+
 // RegistryFlags command line flags to configure the registry connection
 type RegistryFlags struct {
 	CACertPaths []string
@@ -23,10 +33,27 @@ type RegistryFlags struct {
 	Token    string
 	Anon     bool
 
-	RetryCount int
+	RetryCount       int
+	RetryBackoff     time.Duration
+	RateLimitMaxWait time.Duration
 
 	ResponseHeaderTimeout time.Duration
+	MaxConnections        int
 	ActiveKeychains       string
+
+	CacheDir string
+	NoCache  bool
+
+	BandwidthLimit string
+	// bandwidthLimiter limiter of --bandwidth-limit shared by the registries of the command, nil when there is no limit
+	bandwidthLimiter *registry.BandwidthLimiter
+
+	// defaultCache the blob cache is only used by default by the commands, not when the flags are used as a library
+	defaultCache bool
+	// cmd command the flags are registered to, whose context stops the requests when the command is interrupted
+	cmd *cobra.Command
+	// events stream the retries and the rate limiting are reported to instead of rateLimitLog, nil when not enabled
+	events *util.EventStream
 }
 
 // Set Registers the flags available to the provided command
@@ -41,7 +68,66 @@ func (r *RegistryFlags) Set(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&r.Anon, "registry-anon", false, "Set anonymous auth ($IMGPKG_ANON)")
 
 	cmd.Flags().DurationVar(&r.ResponseHeaderTimeout, "registry-response-header-timeout", 30*time.Second, "Maximum time to allow a request to wait for a server's response headers from the registry (ms|s|m|h)")
-	cmd.Flags().IntVar(&r.RetryCount, "registry-retry-count", 5, "Set the number of times imgpkg retries to send requests to the registry in case of an error")
+	cmd.Flags().IntVar(&r.MaxConnections, "registry-max-connections", 0, "Set the most connections opened to each registry at once, the requests waiting for one of them to be available (0 for no limit)")
+	cmd.Flags().IntVar(&r.RetryCount, "registry-retry-count", 5, "Set the number of times imgpkg sends a request to the registry, when it fails with a network error, a 429 or a 5xx response")
+	cmd.Flags().DurationVar(&r.RetryBackoff, "registry-retry-backoff", 100*time.Millisecond, "Set the wait before the first retry of a request to the registry, doubled for each following retry, unless the registry asks for a longer one with Retry-After or RateLimit-Reset (ms|s|m|h)")
+	cmd.Flags().DurationVar(&r.RateLimitMaxWait, "registry-rate-limit-max-wait", 5*time.Minute, "Set the longest wait asked for by a registry rate limiting the requests, with Retry-After or RateLimit-Reset, that is waited for before retrying (ms|s|m|h)")
+
+	cmd.Flags().StringVar(&r.CacheDir, "cache-dir", "", "Set the directory of the cache of downloaded layers, shared with other imgpkg processes (default ~/.imgpkg/cache) ($IMGPKG_CACHE_DIR)")
+	cmd.Flags().BoolVar(&r.NoCache, "no-cache", false, "Download the layers from the registry without reading or writing the cache of downloaded layers")
+	r.defaultCache = true
+	r.cmd = cmd
+}
+
+// SetBandwidthLimit Registers the flag limiting the bandwidth, for the commands transferring the images
+func (r *RegistryFlags) SetBandwidthLimit(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&r.BandwidthLimit, "bandwidth-limit", "", "Set the most bytes per second uploaded to, and downloaded from, the registries, shared by the concurrent transfers (format: 50MB, 10MiB) ($IMGPKG_BANDWIDTH_LIMIT)")
+}
+
+// limitBandwidth creates the limiter of --bandwidth-limit, or of $IMGPKG_BANDWIDTH_LIMIT when the flag is not
+// provided. A limit of 0 does not limit the bandwidth
+func (r *RegistryFlags) limitBandwidth() error {
+	limit, source := r.BandwidthLimit, "--bandwidth-limit"
+	if limit == "" {
+		limit, source = os.Getenv("IMGPKG_BANDWIDTH_LIMIT"), "IMGPKG_BANDWIDTH_LIMIT"
+	}
+	if limit == "" {
+		return nil
+	}
+
+	bytesPerSecond, err := parseByteSize(strings.TrimSuffix(limit, "/s"))
+	if err != nil {
+		return fmt.Errorf("Parsing %s: %s", source, err)
+	}
+	if bytesPerSecond > 0 {
+		r.bandwidthLimiter = registry.NewBandwidthLimiter(bytesPerSecond)
+	}
+	return nil
+}
+
+// bandwidthStats adds what was transferred under the bandwidth limit to stats, and reports it when the command
+// succeeded, unless the events of --progress-format json-lines are written instead
+func (r *RegistryFlags) bandwidthStats(stats util.EventStats, err error) util.EventStats {
+	if r.bandwidthLimiter == nil {
+		return stats
+	}
+	bandwidth := r.bandwidthLimiter.Stats()
+	if err == nil && r.events == nil {
+		fmt.Fprintf(rateLimitLog, "%s\n", bandwidth)
+	}
+	stats.BandwidthLimit = bandwidth.BytesPerSecondLimit
+	stats.BytesTransferred = bandwidth.Bytes
+	stats.BytesPerSecond = bandwidth.BytesPerSecond()
+	return stats
+}
+
+// context returns the context of the command, which is done when the command is interrupted, or nil when the flags
+// are not registered to a command
+func (r *RegistryFlags) context() context.Context {
+	if r.cmd == nil {
+		return nil
+	}
+	return r.cmd.Context()
 }
 
 // AsRegistryOpts convert command flags and environment variables into registry.Opts
@@ -57,10 +143,44 @@ func (r *RegistryFlags) AsRegistryOpts() registry.Opts {
 		Anon:     r.Anon,
 
 		RetryCount:            r.RetryCount,
+		RetryBackoff:          r.RetryBackoff,
+		RateLimitMaxWait:      r.RateLimitMaxWait,
 		ResponseHeaderTimeout: r.ResponseHeaderTimeout,
 
+		MaxConnectionsPerRegistry: r.MaxConnections,
+		BandwidthLimiter:          r.bandwidthLimiter,
+
+		CacheDir: r.CacheDir,
+
 		EnvironFunc: os.Environ,
 	}
+	if r.cmd != nil {
+		opts.Context = r.cmd.Context()
+		opts.OnRateLimited = func(host string, wait time.Duration) {
+			fmt.Fprintf(rateLimitLog, "Registry '%s' is rate limiting the requests, waiting %s before sending them again, one at a time\n", host, wait.Round(time.Millisecond))
+		}
+	}
+	if r.events != nil {
+		opts.OnRateLimited = r.events.RateLimited
+		opts.OnRetry = r.events.Retry
+	}
 
-	return v1.OptsFromEnv(opts, os.LookupEnv)
+	opts = v1.OptsFromEnv(opts, os.LookupEnv)
+	switch {
+	case r.NoCache:
+		opts.CacheDir = ""
+	case opts.CacheDir == "" && r.defaultCache:
+		opts.CacheDir = defaultCacheDir()
+	}
+	return opts
+}
+
+// defaultCacheDir returns the directory of the blob cache used when none is configured, or an empty string when the
+// home directory of the user is unknown
+func defaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".imgpkg", "cache")
 }
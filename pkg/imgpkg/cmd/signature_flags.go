@@ -3,12 +3,50 @@
 
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
+	"github.com/spf13/cobra"
+)
 
 type SignatureFlags struct {
 	CopyCosignSignatures bool
+	// IncludeAttached kinds of the artifacts attached to the images that are copied with them
+	IncludeAttached []string
 }
 
 func (s *SignatureFlags) Set(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&s.CopyCosignSignatures, "cosign-signatures", false, "Find and copy cosign signatures for images")
+	cmd.Flags().StringSliceVar(&s.IncludeAttached, "include-attached", []string{"none"}, "Find and copy the artifacts attached to the images, "+
+		"with the referrers API of the registry or the tags of cosign (sha256-<hex>.sig, .att and .sbom), their manifests unchanged (one of: signatures, sboms, all, none) (can be specified multiple times)")
+}
+
+// AttachedScope returns the kinds of the attached artifacts of --include-attached, --cosign-signatures adding the
+// signatures to them when any kind is included
+func (s SignatureFlags) AttachedScope() (signature.AttachedScope, error) {
+	var scope signature.AttachedScope
+	none := false
+	for _, kind := range s.IncludeAttached {
+		switch kind {
+		case "signatures":
+			scope.Signatures = true
+		case "sboms":
+			scope.SBOMs = true
+		case "all":
+			scope = signature.AttachedScope{Signatures: true, SBOMs: true, Others: true}
+		case "none":
+			none = true
+		default:
+			return signature.AttachedScope{}, fmt.Errorf("Expected --include-attached '%s' to be one of signatures, sboms, all or none", kind)
+		}
+	}
+	if none && scope.Any() {
+		return signature.AttachedScope{}, fmt.Errorf("Expected --include-attached none to not be provided with other kinds of attached artifacts")
+	}
+
+	if scope.Any() && s.CopyCosignSignatures {
+		scope.Signatures = true
+	}
+	return scope, nil
 }
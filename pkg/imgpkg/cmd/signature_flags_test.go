@@ -0,0 +1,42 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureFlagsAttachedScope(t *testing.T) {
+	testCases := []struct {
+		name     string
+		flags    SignatureFlags
+		expected signature.AttachedScope
+	}{
+		{name: "includes nothing by default", flags: SignatureFlags{IncludeAttached: []string{"none"}}},
+		{name: "keeps --cosign-signatures to the cosign tag alone", flags: SignatureFlags{CopyCosignSignatures: true, IncludeAttached: []string{"none"}}},
+		{name: "includes the kinds provided", flags: SignatureFlags{IncludeAttached: []string{"sboms"}},
+			expected: signature.AttachedScope{SBOMs: true}},
+		{name: "includes every kind with all", flags: SignatureFlags{IncludeAttached: []string{"all", "signatures"}},
+			expected: signature.AttachedScope{Signatures: true, SBOMs: true, Others: true}},
+		{name: "adds the signatures with --cosign-signatures", flags: SignatureFlags{CopyCosignSignatures: true, IncludeAttached: []string{"sboms"}},
+			expected: signature.AttachedScope{Signatures: true, SBOMs: true}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scope, err := tc.flags.AttachedScope()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, scope)
+		})
+	}
+
+	_, err := SignatureFlags{IncludeAttached: []string{"signature"}}.AttachedScope()
+	require.EqualError(t, err, "Expected --include-attached 'signature' to be one of signatures, sboms, all or none")
+
+	_, err = SignatureFlags{IncludeAttached: []string{"none", "sboms"}}.AttachedScope()
+	require.EqualError(t, err, "Expected --include-attached none to not be provided with other kinds of attached artifacts")
+}
@@ -16,7 +16,7 @@ type TarFlags struct {
 func (t *TarFlags) Set(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&t.TarDst, "to-tar", "", "Location to write a tar file containing assets")
 	cmd.Flags().StringVar(&t.TarSrc, "tar", "", "Path to tar file which contains assets to be copied to a registry")
-	cmd.Flags().BoolVar(&t.Resume, "resume", false, "Resume the copy to tar. When set to true will try to read the tar and only download the missing blobs")
+	cmd.Flags().BoolVar(&t.Resume, "resume", false, "Resume the copy to tar. When set to true will read the tar, verifying the blobs it has by digest, and only download the missing or corrupted ones")
 }
 
 func (t TarFlags) IsSrc() bool { return t.TarSrc != "" }
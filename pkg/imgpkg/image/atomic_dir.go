@@ -0,0 +1,70 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// createSiblingDir creates an empty directory named <path>.tmp-<random>, in the same filesystem as path so that it
+// can be renamed to path. The parent directories are created when missing, and the directory is created with the
+// same mode as MkdirAll would use
+func createSiblingDir(path string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; ; attempt++ {
+		suffix := make([]byte, 6)
+		_, err := rand.Read(suffix)
+		if err != nil {
+			return "", err
+		}
+
+		tmpPath := path + ".tmp-" + hex.EncodeToString(suffix)
+		err = os.Mkdir(tmpPath, 0777)
+		if err == nil {
+			return tmpPath, nil
+		}
+		if !os.IsExist(err) || attempt >= 10 {
+			return "", err
+		}
+	}
+}
+
+// removeOnInterrupt removes path when the process is interrupted, before the process stops, and returns a function
+// to stop watching for interruptions once path is no longer temporary
+func removeOnInterrupt(path string) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			_ = os.RemoveAll(path)
+			signal.Stop(signals)
+			// without the channel the signal has its default behavior again, which stops the process
+			process, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				err = process.Signal(sig)
+			}
+			if err != nil {
+				os.Exit(1)
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveOnInterrupt(t *testing.T) {
+	if dir := os.Getenv("IMGPKG_TEST_INTERRUPTED_DIR"); dir != "" {
+		removeOnInterrupt(dir)
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+		time.Sleep(10 * time.Second)
+		return
+	}
+
+	t.Run("it removes the directory and stops the process when interrupted", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "output.tmp-123")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "config"), 0700))
+
+		cmd := exec.Command(os.Args[0], "-test.run", "^TestRemoveOnInterrupt$")
+		cmd.Env = append(os.Environ(), "IMGPKG_TEST_INTERRUPTED_DIR="+dir)
+		err := cmd.Run()
+
+		exitErr, ok := err.(*exec.ExitError)
+		require.True(t, ok, "expected the process to be stopped, got %v", err)
+		status := exitErr.Sys().(syscall.WaitStatus)
+		assert.True(t, status.Signaled())
+		assert.Equal(t, syscall.SIGINT, status.Signal())
+		assert.NoDirExists(t, dir)
+	})
+
+	t.Run("it keeps the directory once it stops watching", func(t *testing.T) {
+		dir := t.TempDir()
+
+		removeOnInterrupt(dir)()
+		assert.DirExists(t, dir)
+	})
+}
+
+func TestCreateSiblingDir(t *testing.T) {
+	parent := t.TempDir()
+	path := filepath.Join(parent, "missing", "output")
+
+	tmpPath, err := createSiblingDir(path)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Dir(path), filepath.Dir(tmpPath))
+	assert.Regexp(t, `^output\.tmp-[0-9a-f]{12}$`, filepath.Base(tmpPath))
+	assert.DirExists(t, tmpPath)
+}
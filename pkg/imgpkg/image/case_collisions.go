@@ -0,0 +1,66 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// detectCaseInsensitive checks if the filesystem of a directory is case-insensitive
+var detectCaseInsensitive = caseInsensitiveDir
+
+// caseCollisions keeps track of the extracted paths, and their parent directories, ignoring the case, to find
+// entries that would overwrite each other, or be merged, on a case-insensitive filesystem
+type caseCollisions struct {
+	// paths relative paths of the extracted entries and their directories, by their lowercase version
+	paths map[string]string
+}
+
+func newCaseCollisions() *caseCollisions {
+	return &caseCollisions{paths: map[string]string{}}
+}
+
+// Add records the entry at relPath, which uses '/' as separator, and fails when it, or one of its parent
+// directories, only differs in case from a path recorded before
+func (c *caseCollisions) Add(relPath string) error {
+	for current := relPath; current != "." && current != "/"; current = path.Dir(current) {
+		folded := strings.ToLower(current)
+		existing, found := c.paths[folded]
+		if !found {
+			c.paths[folded] = current
+			continue
+		}
+		if existing != current {
+			return fmt.Errorf("Entries '%s' and '%s' only differ in case and would overwrite each other in the case-insensitive "+
+				"output directory (hint: Use --allow-case-collisions to extract them anyway, keeping only one of them)", existing, relPath)
+		}
+	}
+	return nil
+}
+
+// caseInsensitiveDir checks if the filesystem of dir is case-insensitive, by creating a file and looking it up
+// with its name in uppercase
+func caseInsensitiveDir(dir string) (bool, error) {
+	probe, err := os.CreateTemp(dir, ".imgpkg-case-probe-")
+	if err != nil {
+		return false, fmt.Errorf("Checking if the output directory is case-insensitive: %s", err)
+	}
+	probePath := probe.Name()
+	_ = probe.Close()
+	defer os.Remove(probePath)
+
+	_, err = os.Lstat(filepath.Join(dir, strings.ToUpper(filepath.Base(probePath))))
+	switch {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, fmt.Errorf("Checking if the output directory is case-insensitive: %s", err)
+	}
+}
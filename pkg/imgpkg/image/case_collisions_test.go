@@ -0,0 +1,107 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaseCollisions(t *testing.T) {
+	t.Run("it fails when two entries only differ in case", func(t *testing.T) {
+		collisions := newCaseCollisions()
+		require.NoError(t, collisions.Add("README"))
+		require.NoError(t, collisions.Add("README"), "the same entry found twice is not a collision")
+
+		err := collisions.Add("readme")
+		require.ErrorContains(t, err, "Entries 'README' and 'readme' only differ in case and would overwrite each other in the case-insensitive output directory "+
+			"(hint: Use --allow-case-collisions to extract them anyway, keeping only one of them)")
+	})
+
+	t.Run("it fails when the parent directories only differ in case", func(t *testing.T) {
+		collisions := newCaseCollisions()
+		require.NoError(t, collisions.Add("Config/app.yml"))
+		require.NoError(t, collisions.Add("Config/db.yml"))
+
+		err := collisions.Add("config/other.yml")
+		require.ErrorContains(t, err, "Entries 'Config' and 'config/other.yml' only differ in case")
+	})
+}
+
+func TestDirImageCaseCollisions(t *testing.T) {
+	img := singleLayerImage(t, "README", "docs/readme")
+	collidingImg := singleLayerImage(t, "README", "readme")
+
+	detected := detectCaseInsensitive
+	detectCaseInsensitive = func(string) (bool, error) { return true, nil }
+	defer func() { detectCaseInsensitive = detected }()
+
+	t.Run("it fails when extracting colliding entries in a case-insensitive directory", func(t *testing.T) {
+		err := NewDirImage(t.TempDir(), collidingImg, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Entries 'README' and 'readme' only differ in case")
+	})
+
+	t.Run("it extracts entries in different directories", func(t *testing.T) {
+		require.NoError(t, NewDirImage(t.TempDir(), img, util.NewNoopLogger()).AsDirectory())
+	})
+
+	t.Run("it extracts colliding entries when they are allowed", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := DirImageOpts{AllowCaseCollisions: true}
+		require.NoError(t, NewDirImageWithOpts(folder, collidingImg, opts, util.NewNoopLogger()).AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "readme"))
+		require.NoError(t, err)
+	})
+}
+
+func TestCaseInsensitiveDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the case sensitivity of the temporary directory is only known on linux")
+	}
+
+	folder := t.TempDir()
+	caseInsensitive, err := caseInsensitiveDir(folder)
+	require.NoError(t, err)
+	assert.False(t, caseInsensitive)
+
+	files, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	assert.Empty(t, files, "the probe file is removed")
+}
+
+// singleLayerImage creates an image in memory with one layer containing a file for each of the names
+func singleLayerImage(t *testing.T, names ...string) regv1.Image {
+	buf := bytes.NewBuffer(nil)
+	tarWriter := tar.NewWriter(buf)
+	for _, name := range names {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))}))
+		_, err := tarWriter.Write([]byte(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+
+	layerBytes := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(layerBytes)), nil
+	})
+	require.NoError(t, err)
+
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumReader hashes the content of a file while it is extracted, so that the checksums do not need another
+// read of the extracted files. Returns the input unchanged when no checksums are written
+func (i *DirImage) checksumReader(input io.Reader) (io.Reader, hash.Hash) {
+	if i.checksums == nil {
+		return input, nil
+	}
+	hasher := sha256.New()
+	return io.TeeReader(input, hasher), hasher
+}
+
+// recordChecksum keeps the checksum of the file extracted at path
+func (i *DirImage) recordChecksum(path string, hasher hash.Hash) {
+	if hasher == nil {
+		return
+	}
+	i.checksums[path] = hex.EncodeToString(hasher.Sum(nil))
+}
+
+// recordHardlinkChecksum keeps the checksum of the target of the hardlink at path, since both have the same content
+func (i *DirImage) recordHardlinkChecksum(path, target string) {
+	if i.checksums == nil {
+		return
+	}
+	if sum, found := i.checksums[target]; found {
+		i.checksums[path] = sum
+	}
+}
+
+// writeChecksums writes the checksums of the extracted files in the format of sha256sum, sorted by the path of the
+// files relative to the output directory
+func (i *DirImage) writeChecksums() error {
+	lines := map[string]string{}
+	for path, sum := range i.checksums {
+		// an older layer might have replaced the file with another entry
+		info, err := os.Lstat(longPath(path))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		lines[i.relativeToDir(path)] = sum
+	}
+
+	var relPaths []string
+	for relPath := range lines {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	err := os.MkdirAll(filepath.Dir(i.opts.ChecksumsPath), 0777)
+	if err != nil {
+		return fmt.Errorf("Creating checksums directory: %s", err)
+	}
+
+	file, err := os.Create(i.opts.ChecksumsPath)
+	if err != nil {
+		return fmt.Errorf("Writing checksums: %s", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, relPath := range relPaths {
+		_, err = writer.WriteString(checksumLine(lines[relPath], relPath))
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("Writing checksums: %s", err)
+	}
+	return file.Close()
+}
+
+// checksumLine formats the line of a file like sha256sum does, names with backslashes or newlines are escaped and
+// the line starts with a backslash in that case
+func checksumLine(sum, relPath string) string {
+	if !strings.ContainsAny(relPath, "\\\n") {
+		return sum + "  " + relPath + "\n"
+	}
+	escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(relPath)
+	return "\\" + sum + "  " + escaped + "\n"
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithm used to compress the layer of the images created from files
+type Compression string
+
+const (
+	// GzipCompression compresses layers with gzip, used when none is selected
+	GzipCompression Compression = "gzip"
+	// ZstdCompression compresses layers with zstd, which only OCI media types describe
+	ZstdCompression Compression = "zstd"
+	// NoCompression keeps layers uncompressed
+	NoCompression Compression = "none"
+)
+
+// gzipHeaderOS OS byte of the gzip header of the layers, unknown, so that it does not depend on the system pushing them
+const gzipHeaderOS = 255
+
+// zstdModule module of the zstd encoder, whose version is listed with the contents of the layers compressed with it
+const zstdModule = "github.com/klauspost/compress"
+
+// defaultCompressionLevels levels used when no compression level is provided
+var defaultCompressionLevels = map[Compression]int{
+	GzipCompression: gzip.BestSpeed,
+	ZstdCompression: 3,
+}
+
+// compressionLevels range of the levels of each compression
+var compressionLevels = map[Compression][2]int{
+	GzipCompression: {gzip.BestSpeed, gzip.BestCompression},
+	ZstdCompression: {1, 22},
+}
+
+// compression returns the compression, and its level, of the layer
+func (o FileImageOpts) compression() (Compression, int, error) {
+	compression := o.Compression
+	if compression == "" {
+		compression = GzipCompression
+	}
+
+	if compression == NoCompression {
+		if o.CompressionLevel != 0 {
+			return "", 0, fmt.Errorf("Expected no compression level when layers are not compressed")
+		}
+		return compression, 0, nil
+	}
+
+	levels, found := compressionLevels[compression]
+	if !found {
+		return "", 0, fmt.Errorf("Expected compression '%s' to be one of %s, %s or %s", compression, GzipCompression, ZstdCompression, NoCompression)
+	}
+	if o.CompressionLevel == 0 {
+		return compression, defaultCompressionLevels[compression], nil
+	}
+	if o.CompressionLevel < levels[0] || o.CompressionLevel > levels[1] {
+		return "", 0, fmt.Errorf("Expected compression level %d to be between %d and %d for %s", o.CompressionLevel, levels[0], levels[1], compression)
+	}
+	return compression, o.CompressionLevel, nil
+}
+
+// LayerCompression parameters the layer of an image is compressed with, listed with its entries so that the setups of
+// two pushes expected to result in the same digest can be compared
+type LayerCompression struct {
+	Algorithm Compression `json:"algorithm"`
+	Level     int         `json:"level,omitempty"`
+	// Encoder library the layer is compressed with, with its version, and the parameters pinned for it
+	Encoder string `json:"encoder,omitempty"`
+}
+
+// layerCompression returns the parameters the layer is compressed with
+func (o FileImageOpts) layerCompression() (LayerCompression, error) {
+	compression, level, err := o.compression()
+	if err != nil {
+		return LayerCompression{}, err
+	}
+
+	params := LayerCompression{Algorithm: compression, Level: level}
+	switch compression {
+	case GzipCompression:
+		params.Encoder = fmt.Sprintf("compress/gzip %s, header without name, comment or modification time, with OS %d", runtime.Version(), gzipHeaderOS)
+	case ZstdCompression:
+		params.Encoder = fmt.Sprintf("%s/zstd %s, concurrency 1, with CRC, without zero frames", zstdModule, moduleVersion(zstdModule))
+	}
+	return params, nil
+}
+
+// String describes the parameters, as push --verbose-contents prints them
+func (c LayerCompression) String() string {
+	if c.Encoder == "" {
+		return string(c.Algorithm)
+	}
+	return fmt.Sprintf("%s level %d (%s)", c.Algorithm, c.Level, c.Encoder)
+}
+
+// moduleVersion returns the version of module imgpkg is built with, unknown when it is not recorded in the binary
+func moduleVersion(module string) string {
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		for _, dep := range info.Deps {
+			if dep.Path == module {
+				return dep.Version
+			}
+		}
+	}
+	return "(unknown version)"
+}
+
+// layerMediaType returns the media type of layers compressed with compression
+func layerMediaType(mediaTypes MediaTypes, compression Compression) types.MediaType {
+	switch {
+	case mediaTypes == OCIMediaTypes && compression == ZstdCompression:
+		return types.OCILayerZStd
+	case mediaTypes == OCIMediaTypes && compression == NoCompression:
+		return types.OCIUncompressedLayer
+	case mediaTypes == OCIMediaTypes:
+		return types.OCILayer
+	case compression == NoCompression:
+		return types.DockerUncompressedLayer
+	default:
+		return types.DockerLayer
+	}
+}
+
+// compressedFileLayer layer whose compressed blob is written to a file once, so that its digest is only computed
+// once and the blob read from the file when it is uploaded
+type compressedFileLayer struct {
+	diffID         v1.Hash
+	digest         v1.Hash
+	size           int64
+	mediaType      types.MediaType
+	path           string
+	compressedPath string
+}
+
+var _ v1.Layer = (*compressedFileLayer)(nil)
+
+// newCompressedFileLayer compresses the tar at path. The parameters of the encoders are pinned, so that the same
+// content, compression and level always result in the same digest
+func newCompressedFileLayer(path string, diffID v1.Hash, mediaType types.MediaType, compression Compression, level int) (*compressedFileLayer, error) {
+	compressedFile, err := os.CreateTemp("", "imgpkg-compressed-layer")
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &compressedFileLayer{diffID: diffID, mediaType: mediaType, path: path, compressedPath: compressedFile.Name()}
+	err = layer.compress(compressedFile, compression, level)
+	closeErr := compressedFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(compressedFile.Name())
+		return nil, fmt.Errorf("Compressing layer with %s: %s", compression, err)
+	}
+	return layer, nil
+}
+
+func (l *compressedFileLayer) compress(out io.Writer, compression Compression, level int) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	compressedOut := io.MultiWriter(out, hasher, counter)
+
+	encoder, err := newCompressor(compressedOut, compression, level)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(encoder, file)
+	if err != nil {
+		_ = encoder.Close()
+		return err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	l.digest = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(hasher.Sum(nil))}
+	l.size = counter.count
+	return nil
+}
+
+// newCompressor returns the encoder of compression, writing to out
+func newCompressor(out io.Writer, compression Compression, level int) (io.WriteCloser, error) {
+	switch compression {
+	case ZstdCompression:
+		return zstd.NewWriter(out,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithEncoderCRC(true),
+			zstd.WithSingleSegment(false),
+			zstd.WithZeroFrames(false))
+	case GzipCompression:
+		encoder, err := gzip.NewWriterLevel(out, level)
+		if err != nil {
+			return nil, err
+		}
+		// without name, comment, extra field or modification time
+		encoder.Header = gzip.Header{OS: gzipHeaderOS}
+		return encoder, nil
+	default:
+		return nopWriteCloser{out}, nil
+	}
+}
+
+func (l *compressedFileLayer) Digest() (v1.Hash, error)            { return l.digest, nil }
+func (l *compressedFileLayer) DiffID() (v1.Hash, error)            { return l.diffID, nil }
+func (l *compressedFileLayer) Size() (int64, error)                { return l.size, nil }
+func (l *compressedFileLayer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+
+func (l *compressedFileLayer) Compressed() (io.ReadCloser, error) { return os.Open(l.compressedPath) }
+
+func (l *compressedFileLayer) Uncompressed() (io.ReadCloser, error) { return os.Open(l.path) }
+
+type countingWriter struct {
+	count int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.count += int64(len(p))
+	return len(p), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,87 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image_test
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompressionDigests fails when a change of Go, or of the compression libraries, changes the blobs of the layers
+// compressed from the same tar, which would change the digests of the images published from the same files
+func TestCompressionDigests(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("test_assets", "img_tar.macos.new.tar"))
+	require.NoError(t, err)
+
+	newLayerImage := func(t *testing.T, opts image.FileImageOpts) *image.FileImage {
+		path := filepath.Join(t.TempDir(), "layer.tar")
+		require.NoError(t, os.WriteFile(path, fixture, 0600))
+		img, err := image.NewFileImageWithOpts(path, opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { img.Remove() })
+		return img
+	}
+
+	testCases := []struct {
+		name   string
+		opts   image.FileImageOpts
+		digest string
+	}{
+		{
+			name:   "gzip compressed while uploaded",
+			opts:   image.FileImageOpts{},
+			digest: "sha256:b1c245748b7a08942b03675745ed51903656d48f8f9b41388276dddb8591dbc0",
+		},
+		{
+			name:   "gzip with a level",
+			opts:   image.FileImageOpts{Compression: image.GzipCompression, CompressionLevel: 9},
+			digest: "sha256:5f6b85227c50e5b6288d095b426c5c74c45070f0d23f4277887bd5a04396c5e5",
+		},
+		{
+			name:   "zstd with the default level",
+			opts:   image.FileImageOpts{Compression: image.ZstdCompression},
+			digest: "sha256:65fd3c3e0236264668418ceb554c214a469974d05cb52d573c7f74150cff2314",
+		},
+		{
+			name:   "zstd with a level",
+			opts:   image.FileImageOpts{Compression: image.ZstdCompression, CompressionLevel: 19},
+			digest: "sha256:0514f72cdcc7acf385f1e63616ffb786d0a7dcf79adcd2cad3065a6ee06e2613",
+		},
+		{
+			name:   "no compression",
+			opts:   image.FileImageOpts{Compression: image.NoCompression},
+			digest: "sha256:cd20800f66352a1a35b05907b76329c4c45d3c9470f9ebe1a37cbc7264c46784",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			layers, err := newLayerImage(t, tc.opts).Layers()
+			require.NoError(t, err)
+			digest, err := layers[0].Digest()
+			require.NoError(t, err)
+			assert.Equal(t, tc.digest, digest.String())
+		})
+	}
+
+	t.Run("the gzip header has no modification time, and the same OS byte on every system", func(t *testing.T) {
+		for _, opts := range []image.FileImageOpts{{}, {Compression: image.GzipCompression, CompressionLevel: 9}} {
+			layers, err := newLayerImage(t, opts).Layers()
+			require.NoError(t, err)
+			compressed, err := layers[0].Compressed()
+			require.NoError(t, err)
+			defer compressed.Close()
+
+			reader, err := gzip.NewReader(compressed)
+			require.NoError(t, err)
+			assert.True(t, reader.Header.ModTime.IsZero())
+			assert.Equal(t, gzip.Header{OS: 255}, reader.Header)
+		}
+	})
+}
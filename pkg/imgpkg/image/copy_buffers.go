@@ -0,0 +1,47 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultCopyBufferSize size of the buffer used to write the content of the entries, see DirImageOpts.CopyBufferSize
+const defaultCopyBufferSize = 1024 * 1024
+
+// copyBufferPools pools of buffers by size, shared by every extraction, so that the content of each entry is
+// streamed to disk through a buffer of fixed size that is only allocated once per concurrent copy
+var copyBufferPools sync.Map
+
+// getCopyBuffer returns a buffer of the size from the pool, which must be handed back with putCopyBuffer
+func getCopyBuffer(size int) *[]byte {
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	pool, _ := copyBufferPools.LoadOrStore(size, &sync.Pool{New: func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}})
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+func putCopyBuffer(buf *[]byte) {
+	if pool, found := copyBufferPools.Load(len(*buf)); found {
+		pool.(*sync.Pool).Put(buf)
+	}
+}
+
+// copyEntry writes the content of an entry to dst through a pooled buffer. dst is wrapped so that the ReadFrom of
+// files, which copies with a buffer of its own when the source is not a file, is not used
+func (i *DirImage) copyEntry(dst io.Writer, content io.Reader) (int64, error) {
+	buf := getCopyBuffer(i.opts.CopyBufferSize)
+	defer putCopyBuffer(buf)
+	return io.CopyBuffer(onlyWriter{dst}, content, *buf)
+}
+
+// onlyWriter hides every method of the writer but Write
+type onlyWriter struct {
+	io.Writer
+}
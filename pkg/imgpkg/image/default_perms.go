@@ -0,0 +1,73 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultPermsMode decides the mode of the extracted entries when the permissions of the image are not preserved
+type DefaultPermsMode string
+
+const (
+	// DefaultPermsMirror copies the user permissions to group and other for the entries that have no group or other
+	// permissions, like the content pushed by root with a restrictive umask, so that it stays usable by other users.
+	// Entries with any group or other permission keep their mode. The umask applies to the result
+	DefaultPermsMirror DefaultPermsMode = "mirror"
+	// DefaultPermsStrict uses the modes of the image as they are, the umask applies to them
+	DefaultPermsStrict DefaultPermsMode = "strict"
+	// DefaultPermsMask uses the modes of the image without the permissions of DefaultPerms.Mask, which replaces the
+	// umask. The parent directories that are not in the image are created with the permissions that the mask allows
+	DefaultPermsMask DefaultPermsMode = "mask"
+)
+
+// DefaultPerms decides the mode of the extracted entries, it does not apply with DirImageOpts.PreservePermissions
+type DefaultPerms struct {
+	// Mode defaults to DefaultPermsMirror
+	Mode DefaultPermsMode `json:"mode,omitempty"`
+	// Mask permissions removed from the entries with DefaultPermsMask
+	Mask os.FileMode `json:"mask,omitempty"`
+}
+
+// ParseDefaultPerms parses one of mirror, strict or mask=VALUE, where VALUE is an octal mask like 022
+func ParseDefaultPerms(value string) (DefaultPerms, error) {
+	switch mode := DefaultPermsMode(value); mode {
+	case DefaultPermsMirror, DefaultPermsStrict:
+		return DefaultPerms{Mode: mode}, nil
+	}
+
+	mask, found := strings.CutPrefix(value, string(DefaultPermsMask)+"=")
+	if !found {
+		return DefaultPerms{}, fmt.Errorf("Expected default permissions '%s' to be one of %s, %s or %s=VALUE", value, DefaultPermsMirror, DefaultPermsStrict, DefaultPermsMask)
+	}
+	bits, err := strconv.ParseUint(mask, 8, 32)
+	if err != nil || os.FileMode(bits)&^os.ModePerm != 0 {
+		return DefaultPerms{}, fmt.Errorf("Expected mask '%s' to be an octal number between 000 and 777, like 022", mask)
+	}
+	return DefaultPerms{Mode: DefaultPermsMask, Mask: os.FileMode(bits)}, nil
+}
+
+// permMode returns the mode to create the entry with, before the umask is removed
+func (p DefaultPerms) permMode(mode os.FileMode) os.FileMode {
+	if p.Mode != DefaultPermsMirror && p.Mode != "" {
+		return mode
+	}
+	// the creator of the image intended to keep the permissions of entries that still have some for group or other
+	if mode&0077 > 0 {
+		return mode
+	}
+	userPermission := mode & 0700
+	return userPermission | userPermission>>3 | userPermission>>6
+}
+
+// umask returns the permissions removed from the extracted entries, given the umask of the process
+func (p DefaultPerms) umask(current os.FileMode) os.FileMode {
+	if p.Mode == DefaultPermsMask {
+		return p.Mask
+	}
+	return current
+}
@@ -0,0 +1,18 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// createDevice devices and named pipes are only created on Linux and macOS
+func createDevice(_ *tar.Header, _ string, _ os.FileMode) error {
+	return fmt.Errorf("Creating devices is not supported on %s", runtime.GOOS)
+}
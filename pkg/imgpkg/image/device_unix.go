@@ -0,0 +1,38 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package image
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// createDevice creates the character or block device, or the named pipe, of the entry at path. Creating devices
+// requires privileges, like running as root
+func createDevice(header *tar.Header, path string, perm os.FileMode) error {
+	mode := uint32(perm.Perm())
+	dev := int(unix.Mkdev(uint32(header.Devmajor), uint32(header.Devminor)))
+
+	var err error
+	switch header.Typeflag {
+	case tar.TypeFifo:
+		err = unix.Mkfifo(path, mode)
+	case tar.TypeChar:
+		err = unix.Mknod(path, mode|unix.S_IFCHR, dev)
+	case tar.TypeBlock:
+		err = unix.Mknod(path, mode|unix.S_IFBLK, dev)
+	default:
+		return fmt.Errorf("Unsupported device type '%c'", header.Typeflag)
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("%s (hint: Creating devices requires privileges, like running as root)", err)
+	}
+	return err
+}
@@ -5,14 +5,21 @@ package image
 
 import (
 	"archive/tar"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
 // Logger used to print messages
@@ -20,234 +27,1634 @@ type Logger interface {
 	Logf(msg string, args ...interface{})
 }
 
+// ProgressReporter receives updates with the number of compressed bytes read from a layer while it is extracted.
+// Start is called before each layer is extracted and End after it
+type ProgressReporter interface {
+	Start(ctx context.Context, progress <-chan regv1.Update)
+	End()
+}
+
+// DirImageOpts options that change how the image is extracted into disk
+type DirImageOpts struct {
+	// PreserveSymlinks recreates symlinks found in the image, as long as their target stays inside the output directory
+	PreserveSymlinks bool
+	// PreservePermissions applies the file and directory modes from the image verbatim, instead of following
+	// DefaultPerms and the umask. The setuid, setgid and sticky bits are only kept with PreserveSpecialBits
+	PreservePermissions bool
+	// DefaultPerms decides the mode of the extracted entries when the permissions are not preserved, defaults to
+	// copying the user permissions to group and other for the entries that have neither
+	DefaultPerms DefaultPerms
+	// PreserveSpecialBits keeps the setuid, setgid and sticky bits of the files and directories of the image,
+	// which are otherwise removed since they let binaries from third parties run with the privileges of their owner
+	PreserveSpecialBits bool
+	// AllowDevices creates the character and block devices, and named pipes, of the image instead of skipping them.
+	// Creating devices requires privileges, an entry that cannot be created fails the extraction. Only supported on
+	// Linux and macOS
+	AllowDevices bool
+	// NoClean extracts the image on top of the existing content of the output directory instead of removing it first.
+	// Only the files present in the image are overwritten, and an existing directory is never replaced by a file
+	// from the image, or vice versa
+	NoClean bool
+	// IncludePaths when provided only the entries that match one of the glob patterns, or are inside of a
+	// directory that matches, are extracted. Every pattern must match at least one entry.
+	// See PathGlobs for the supported syntax
+	IncludePaths []string
+	// ExcludedPaths entries whose path in the image is one of the paths, or is inside of one of them, are not
+	// extracted, the same way the files of a push are excluded. Paths are relative to the root of the image
+	ExcludedPaths []string
+	// Limits bound the size and number of entries extracted from the image
+	Limits ExtractLimits
+	// PreserveXattrs restores the extended attributes of the entries, like security capabilities, recorded in the
+	// PAX headers of the image. Only supported on Linux, attributes that cannot be set are skipped with a warning
+	PreserveXattrs bool
+	// Ownership changes the owner of the extracted entries
+	Ownership OwnershipOpts
+	// AllowCaseCollisions extracts entries whose paths only differ in case, like README and readme, even when the
+	// output directory is case-insensitive. Only one of the entries is kept in that case
+	AllowCaseCollisions bool
+	// Incremental records the extracted image in the PullStateFile of the output directory, and skips the extraction
+	// when the output directory was already extracted from the same image with the same options
+	Incremental bool
+	// Force extracts the image even when Incremental finds that the output directory is unchanged
+	Force bool
+	// ForceExtract extracts the image even when its manifest has the ExtractAnnotation set to false
+	ForceExtract bool
+	// SkipSpaceCheck extracts the image without checking first that the filesystem of the output directory has
+	// enough space available for it
+	SkipSpaceCheck bool
+	// IncludeMetadata writes the manifest, config and digest of the image in the MetadataDir of the output directory,
+	// or in MetadataPath when provided
+	IncludeMetadata bool
+	MetadataPath    string
+	// WindowsSafeNames renames the entries that Windows cannot create, like 'aux' or names ending with a dot,
+	// instead of failing. See escapeWindowsName for the renaming scheme. Only used on Windows
+	WindowsSafeNames bool
+	// LayerRetries number of times a layer is downloaded and extracted again after a transient network error, like a
+	// connection reset while it is read. LayerRetryBackoff is the wait before the first retry, doubled for each retry
+	LayerRetries      int
+	LayerRetryBackoff time.Duration
+	// ChecksumsPath when provided, a file in the format of sha256sum is written there with the checksums of the
+	// extracted regular files, computed while they are written
+	ChecksumsPath string
+	// Concurrency number of layers downloaded at the same time, while the layers downloaded before are extracted.
+	// The downloaded layers are kept in a temporary directory inside of the directory being extracted until they are
+	// extracted, taking up to the compressed size of that many layers. Layers are streamed one at a time when lower than 2
+	Concurrency int
+	// Progress when provided receives the progress of the extraction of each layer, using the layer size as the total
+	Progress ProgressReporter
+	// LayerStorePath when provided, the regular files of each layer are kept in this directory and the files of the
+	// output directory are hardlinks to them, so that layers shared by several images are only written once.
+	// See layerStore for when files are copied instead. Extracted files must not be changed in place, since the
+	// change would show in every directory linked to the same file
+	LayerStorePath string
+	// Context when provided stops the extraction once it is done, at the next read of a layer, removing the file being
+	// written and the partial output. The interruption signals are then left to the caller, which is expected to
+	// cancel the context, otherwise the temporary directories are removed when the process is interrupted
+	Context context.Context
+	// Stats when provided, the statistics of each image extracted with AsDirectory are added to it, so that a bundle
+	// and its images are reported together. The images sharing it must not be extracted concurrently
+	Stats *ExtractStats
+	// CopyBufferSize size of the buffer the content of each file is written through, 0 uses 1MiB. Only meant for
+	// tuning, the layers are always streamed from the registry to the files so memory usage does not grow with
+	// the size of the layers or of their files
+	CopyBufferSize int
+	// SpoolDir directory where AsTar keeps the compressed layers between reading them to find the entries of the
+	// merged view and writing those entries, the temporary directory of the system when empty
+	SpoolDir string
+}
+
 type DirImage struct {
 	dirPath     string
 	img         regv1.Image
 	shouldChown bool
+	opts        DirImageOpts
 	logger      Logger
+
+	skippedLinks int
+	// skippedHardlinks number of hardlinks left out because their target was not extracted
+	skippedHardlinks int
+	// skippedXattrs number of extended attributes that could not be set and the reason for the first one
+	skippedXattrs       int
+	skippedXattrsReason string
+	// skippedOwners number of entries whose owner could not be set and the reason for the first one
+	skippedOwners       int
+	skippedOwnersReason string
+	// renamedEntries number of entries renamed because Windows cannot create them
+	renamedEntries   int
+	skippedPaths     map[string]bool
+	extractedPaths   map[string]int
+	includePaths     PathGlobs
+	matchedPatterns  map[int]bool
+	pendingHardlinks []pendingHardlink
+	// shadowedFiles regular files of the layer being extracted replaced by newer layers, by path, kept until the end
+	// of the layer as the hardlinks of the layer need the content they had in it
+	shadowedFiles map[string]shadowedFile
+	shadowedDir   string
+	dirs          map[string]dirMetadata
+	// checksums sha256 of the extracted regular files, only kept when ChecksumsPath is provided
+	checksums map[string]string
+	// umask permissions removed from the directories created in the output directory
+	umask   os.FileMode
+	counter *extractCounter
+	// caseCollisions when the output directory is case-insensitive, finds the entries that would overwrite each other
+	caseCollisions *caseCollisions
+	// layerDigest digest of the layer being extracted, and its number, see whiteouts.Layer
+	layerDigest string
+	layer       int
+	// stats statistics of the layers already extracted, and entries counters of the layer being extracted
+	stats   ExtractStats
+	entries EntryStats
+}
+
+// pendingHardlink hardlink whose target was not yet extracted when the entry was found
+type pendingHardlink struct {
+	header *tar.Header
+	path   string
+	target string
+	// layer number of the layer of the hardlink, which only links to the content its target has in that layer
+	// or in older ones
+	layer int
+}
+
+// shadowedFile copy of a regular file replaced by a newer layer, and its checksum when checksums are kept
+type shadowedFile struct {
+	path     string
+	checksum string
 }
 
 // NewDirImage given an OCI Image representation creates a struct that will allow that image to be
 // extracted into the provided directory
 func NewDirImage(dirPath string, img regv1.Image, logger Logger) *DirImage {
-	return &DirImage{dirPath, img, os.Getuid() == 0, logger}
+	return NewDirImageWithOpts(dirPath, img, DirImageOpts{}, logger)
+}
+
+// NewDirImageWithOpts given an OCI Image representation creates a struct that will allow that image to be
+// extracted into the provided directory using the provided options
+func NewDirImageWithOpts(dirPath string, img regv1.Image, opts DirImageOpts, logger Logger) *DirImage {
+	shouldChown := os.Getuid() == 0
+	switch opts.Ownership.Mode {
+	case OwnershipPreserve:
+		shouldChown = true
+	case OwnershipCurrent:
+		shouldChown = false
+	}
+	return &DirImage{dirPath: dirPath, img: img, shouldChown: shouldChown, opts: opts, logger: logger}
 }
 
 // AsDirectory extracts the OCI image to the provided location in disk
 func (i *DirImage) AsDirectory() error {
+	err := i.checkExtractable()
+	if err != nil {
+		return err
+	}
+
+	includePaths, err := NewPathGlobs(i.opts.IncludePaths)
+	if err != nil {
+		return err
+	}
+	i.includePaths = includePaths
+	i.matchedPatterns = map[int]bool{}
+
+	if i.opts.Incremental && !i.opts.Force {
+		unchanged, digest, err := i.unchangedPullState()
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			i.logger.Logf("Skipped extraction, the output directory already contains image '%s' (hint: Use --force to extract it again)\n", digest)
+			return nil
+		}
+	}
+
+	if !i.opts.SkipSpaceCheck {
+		layers, err := i.img.Layers()
+		if err != nil {
+			return err
+		}
+		err = checkDiskSpace(i.dirPath, layers, i.spooledLayers(len(layers)))
+		if err != nil {
+			return err
+		}
+	}
+
+	if i.opts.NoClean {
+		// the recorded state would describe content that is about to be changed
+		err := i.removePullState()
+		if err != nil {
+			return err
+		}
+		return i.extract()
+	}
+
+	return i.extractAtomically()
+}
+
+// extractAtomically extracts the image into a temporary directory next to the output directory, which replaces the
+// output directory only once the image is fully extracted, so that a failed pull keeps the previous content.
+// When the temporary directory cannot be created or renamed, the image is extracted directly into the output directory
+func (i *DirImage) extractAtomically() error {
+	finalPath := filepath.Clean(i.dirPath)
+	tmpPath, err := createSiblingDir(finalPath)
+	if err != nil {
+		i.logger.Logf("Warning: Unable to create a temporary directory next to the output directory (%s), extracting directly into it\n", err)
+		return i.extractInPlace()
+	}
+	stopCleanup := i.removeOnInterrupt(tmpPath)
+	defer stopCleanup()
+
+	i.dirPath = tmpPath
+	err = i.extract()
+	i.dirPath = finalPath
+	if err != nil {
+		_ = os.RemoveAll(tmpPath)
+		return err
+	}
+
+	err = os.RemoveAll(finalPath)
+	if err != nil {
+		_ = os.RemoveAll(tmpPath)
+		return fmt.Errorf("Removing output directory: %s", err)
+	}
+	err = os.Rename(tmpPath, finalPath)
+	if err != nil {
+		// renaming fails across devices, for example when the output directory is in an overlay filesystem
+		_ = os.RemoveAll(tmpPath)
+		i.logger.Logf("Warning: Unable to move the extracted image into the output directory (%s), extracting directly into it\n", err)
+		return i.extractInPlace()
+	}
+	return nil
+}
+
+// extractInPlace removes the output directory and extracts the image into it
+func (i *DirImage) extractInPlace() error {
 	err := os.RemoveAll(i.dirPath)
 	if err != nil {
 		return fmt.Errorf("Removing output directory: %s", err)
 	}
+	return i.extract()
+}
 
-	err = os.MkdirAll(i.dirPath, 0777)
+// extract writes the layers of the image into the output directory
+func (i *DirImage) extract() error {
+	err := os.MkdirAll(i.dirPath, 0777)
 	if err != nil {
 		return fmt.Errorf("Creating output directory: %s", err)
 	}
 
-	layers, err := i.img.Layers()
+	umask, err := currentUmask(i.dirPath)
 	if err != nil {
 		return err
 	}
+	i.umask = i.opts.DefaultPerms.umask(umask)
 
-	fileMap := map[string]bool{}
+	layers, err := i.img.Layers()
+	if err != nil {
+		return err
+	}
 
-	// we iterate through the layers in reverse order because it makes handling
-	// whiteout layers more efficient, since we can just keep track of the removed
-	// files as we see .wh. layers and ignore those in previous layers.
-	for idx := len(layers) - 1; idx >= 0; idx-- {
-		imgLayer := layers[idx]
-		digest, err := imgLayer.Digest()
+	i.caseCollisions = nil
+	if !i.opts.AllowCaseCollisions {
+		caseInsensitive, err := detectCaseInsensitive(i.dirPath)
 		if err != nil {
 			return err
 		}
+		if caseInsensitive {
+			i.caseCollisions = newCaseCollisions()
+		}
+	}
 
-		i.logger.Logf("Extracting layer '%s' (%d/%d)\n", digest, len(layers)-idx, len(layers))
+	whiteouts := newWhiteouts()
+	start := time.Now()
+	i.stats = ExtractStats{Images: 1}
+	i.entries = EntryStats{}
+	i.skippedLinks = 0
+	i.skippedHardlinks = 0
+	i.skippedXattrs = 0
+	i.skippedOwners = 0
+	i.renamedEntries = 0
+	i.skippedPaths = map[string]bool{}
+	i.extractedPaths = map[string]int{}
+	i.pendingHardlinks = nil
+	i.dirs = map[string]dirMetadata{}
+	i.checksums = nil
+	if i.opts.ChecksumsPath != "" {
+		i.checksums = map[string]string{}
+	}
 
-		layerStream, err := imgLayer.Uncompressed()
+	var compressedSize int64
+	for _, layer := range layers {
+		size, err := layer.Size()
 		if err != nil {
 			return err
 		}
+		compressedSize += size
+	}
+	i.counter = &extractCounter{limits: i.opts.Limits.withDefaults(compressedSize)}
+
+	err = i.extractLayers(layers, whiteouts)
+	if err != nil {
+		if _, ok := err.(limitExceededError); ok || i.ctx().Err() != nil {
+			i.removePartialOutput()
+		}
+		return err
+	}
+
+	err = i.checkIncludePathsMatched()
+	if err != nil {
+		return err
+	}
 
-		defer layerStream.Close()
+	// hardlinks can point to files from older layers, that are extracted after the current one
+	err = i.createPendingHardlinks(true)
+	if err != nil {
+		return err
+	}
+	i.skipHardlinksToSkippedPaths()
+	if len(i.pendingHardlinks) > 0 {
+		link := i.pendingHardlinks[0]
+		return fmt.Errorf("Hardlink '%s' target '%s' was not found in the image", link.header.Name, link.header.Linkname)
+	}
+
+	err = i.applyDirMetadata()
+	if err != nil {
+		return err
+	}
+
+	// the hardlinks to older layers are only created once every layer is extracted
+	i.stats.add(ExtractStats{EntryStats: i.entries})
+	i.stats.Duration = time.Since(start)
+	imageDigest, err := i.img.Digest()
+	if err != nil {
+		return err
+	}
+	for idx := range i.stats.Layers {
+		i.stats.Layers[idx].ImageDigest = imageDigest.String()
+	}
+	i.logExtractStats()
+	i.logSkippedEntries()
+	if i.opts.Stats != nil {
+		i.opts.Stats.add(i.stats)
+	}
+
+	if i.opts.ChecksumsPath != "" {
+		err := i.writeChecksums()
+		if err != nil {
+			return err
+		}
+	}
 
-		err = i.writeLayer(fileMap, layerStream)
+	if i.opts.IncludeMetadata {
+		err := i.writeMetadata()
 		if err != nil {
 			return err
 		}
 	}
 
+	if i.opts.Incremental {
+		return i.writePullState()
+	}
 	return nil
 }
 
-// Taken from https://github.com/concourse/registry-image-resource/blob/b5481130ad61bc74e0a74f9b00b287b3a24bab88/cmd/in/unpack.go
+// logSkippedEntries reports the entries that were not extracted as they are in the image
+func (i *DirImage) logSkippedEntries() {
+	if i.skippedLinks > 0 {
+		hint := ""
+		if !i.opts.PreserveSymlinks {
+			hint = " (hint: use --preserve-symlinks to keep symlinks)"
+		}
+		i.logger.Logf("Skipped %d link(s) while extracting%s\n", i.skippedLinks, hint)
+	}
 
-func (i *DirImage) writeLayer(fileMap map[string]bool, stream io.Reader) error {
-	tarReader := tar.NewReader(stream)
+	if i.skippedHardlinks > 0 {
+		i.logger.Logf("Skipped %d hardlink(s) whose target was not extracted\n", i.skippedHardlinks)
+	}
 
-	for {
-		hdr, err := tarReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
+	if i.stats.SkippedDevices > 0 {
+		i.logger.Logf("Warning: Skipped %d device(s) and named pipe(s) while extracting (hint: Use --allow-devices to create them)\n", i.stats.SkippedDevices)
+	}
 
-		path := i.hydrateFilepath(hdr.Name)
-		base := filepath.Base(path)
+	if i.renamedEntries > 0 {
+		i.logger.Logf("Renamed %d entries whose names are not supported on Windows\n", i.renamedEntries)
+	}
 
-		const (
-			whiteoutPrefix = ".wh."
-		)
+	if i.skippedOwners > 0 {
+		i.logger.Logf("Warning: Skipped setting the owner of %d entries while extracting, first error was %s\n", i.skippedOwners, i.skippedOwnersReason)
+	}
 
-		if strings.HasPrefix(base, whiteoutPrefix) {
-			dir := filepath.Dir(path)
+	if i.skippedXattrs > 0 {
+		i.logger.Logf("Warning: Skipped %d extended attribute(s) not supported while extracting, first one was %s\n", i.skippedXattrs, i.skippedXattrsReason)
+	}
 
-			err := os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
-			if err != nil {
-				return nil
-			}
-			fileMap[base] = true
-			continue
+	if i.stats.StrippedSpecialBits > 0 {
+		i.logger.Logf("Warning: Removed the setuid, setgid and sticky bits of %d entries while extracting (hint: Use --preserve-special-bits to keep them)\n", i.stats.StrippedSpecialBits)
+	}
+}
+
+// spooledLayers returns the number of the layers of an image of count layers that are spooled in the directory being
+// extracted at the same time, downloaded with --concurrency while the layers before them are extracted
+func (i *DirImage) spooledLayers(count int) int {
+	if i.opts.Concurrency > 1 && count > 1 {
+		return i.opts.Concurrency
+	}
+	return 0
+}
+
+// extractLayers writes the layers of the image into the output directory
+func (i *DirImage) extractLayers(layers []regv1.Layer, whiteouts *whiteouts) error {
+	// we iterate through the layers in reverse order because it makes handling
+	// whiteout layers more efficient, since we can just keep track of the removed
+	// files as we see .wh. layers and ignore those in previous layers.
+	var orderedLayers []regv1.Layer
+	for idx := len(layers) - 1; idx >= 0; idx-- {
+		orderedLayers = append(orderedLayers, layers[idx])
+	}
+
+	var prefetcher *layerPrefetcher
+	if i.spooledLayers(len(orderedLayers)) > 0 {
+		var err error
+		// the layers are spooled in the directory being extracted, on the same filesystem as the extracted files,
+		// instead of the temporary directory of the system which might not have room for them
+		prefetcher, err = newLayerPrefetcher(i.ctx(), longPath(i.dirPath), orderedLayers, i.opts.Concurrency, i.removeOnInterrupt)
+		if err != nil {
+			return err
 		}
+		defer prefetcher.Close()
+	}
 
-		// check for a whited out parent directory
-		if inWhiteoutDir(fileMap, path) {
-			continue
+	for idx, imgLayer := range orderedLayers {
+		digest, err := imgLayer.Digest()
+		if err != nil {
+			return err
 		}
 
-		if fi, err := os.Lstat(path); err == nil {
-			if fi.IsDir() && hdr.Name == "." {
-				continue
+		size, err := imgLayer.Size()
+		if err != nil {
+			return err
+		}
+
+		i.logger.Logf("Extracting layer '%s' (%d/%d)\n", digest, idx+1, len(orderedLayers))
+
+		whiteouts.NextLayer()
+		layerStart := time.Now()
+		i.entries = EntryStats{}
+		checkpoint := i.layerCheckpoint()
+
+		for attempt := 1; ; attempt++ {
+			layer := imgLayer
+			// a retried layer is downloaded again instead of being read from its spool file
+			if prefetcher != nil && attempt == 1 {
+				layer, err = prefetcher.Layer(idx)
 			}
-			if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
-				if err := os.RemoveAll(path); err != nil {
-					return err
-				}
+			if err == nil {
+				err = i.extractLayer(whiteouts, digest.String(), layer)
+			}
+			if err == nil || attempt > i.opts.LayerRetries || !retryableLayerError(err) {
+				break
 			}
-		}
 
-		fileMap[hdr.Name] = true
-		err = i.extractTarEntry(hdr, tarReader)
+			delay := layerRetryDelay(i.opts.LayerRetryBackoff, attempt)
+			i.logger.Logf("Retrying layer '%s' in %s (attempt %d/%d) after error: %s\n", digest, delay, attempt+1, i.opts.LayerRetries+1, err)
+			select {
+			case <-time.After(delay):
+			case <-i.ctx().Done():
+				return i.ctx().Err()
+			}
+
+			// the entries extracted by the failed attempt are overwritten when the layer is extracted again
+			i.restoreCheckpoint(checkpoint)
+			err = nil
+		}
 		if err != nil {
 			return err
 		}
-	}
+		i.recordLayerStats(digest.String(), size, time.Since(layerStart))
 
+		if prefetcher != nil {
+			prefetcher.Release(idx)
+		}
+	}
 	return nil
 }
 
-func inWhiteoutDir(fileMap map[string]bool, file string) bool {
-	for {
-		if file == "" {
-			return false
-		}
-		dirname := filepath.Dir(file)
-		if file == dirname {
-			return false
+// recordLayerStats adds the counters of the layer that was just extracted to the statistics of the image
+func (i *DirImage) recordLayerStats(digest string, compressedSize int64, duration time.Duration) {
+	layer := LayerStats{EntryStats: i.entries, Digest: digest, CompressedSize: compressedSize, Duration: duration}
+	i.stats.Layers = append(i.stats.Layers, layer)
+	i.stats.EntryStats.add(i.entries)
+	i.entries = EntryStats{}
+}
+
+// extractLayer reads the layer and writes its entries into the output directory
+func (i *DirImage) extractLayer(whiteouts *whiteouts, digest string, imgLayer regv1.Layer) error {
+	layerStream, endProgress, err := i.layerStream(imgLayer)
+	if err != nil {
+		return err
+	}
+
+	err = i.writeLayer(whiteouts, digest, layerStream)
+	if endProgress != nil {
+		if err == nil {
+			// the tar might end before the compressed stream does, read the rest so that the progress reaches the layer size
+			_, err = io.Copy(io.Discard, layerStream)
 		}
-		if val, ok := fileMap[dirname]; ok && val {
-			return true
+		endProgress()
+	}
+	_ = layerStream.Close()
+	return err
+}
+
+// ctx returns the Context of the options, or a context that is never done
+func (i *DirImage) ctx() context.Context {
+	if i.opts.Context == nil {
+		return context.Background()
+	}
+	return i.opts.Context
+}
+
+// removeOnInterrupt removes the temporary path when the process is interrupted, unless the caller handles the
+// interruptions by canceling the Context, in which case the path is removed once the extraction stops
+func (i *DirImage) removeOnInterrupt(path string) func() {
+	if i.opts.Context != nil {
+		return func() {}
+	}
+	return removeOnInterrupt(path)
+}
+
+// removePartialOutput removes the content extracted before the extraction was stopped. When extracting on top of
+// existing content only the extracted files are removed, since the directories might have existed before
+func (i *DirImage) removePartialOutput() {
+	if !i.opts.NoClean {
+		_ = os.RemoveAll(i.dirPath)
+		return
+	}
+	for path := range i.extractedPaths {
+		if fi, err := os.Lstat(path); err == nil && !fi.IsDir() {
+			_ = os.Remove(path)
 		}
-		file = dirname
 	}
 }
 
-// Taken from https://github.com/concourse/go-archive/blob/f26802964d15194bddb07bf116ea567c56af973f/tarfs/extract.go
+// layerStream returns the uncompressed content of the layer. When a ProgressReporter is configured and the layer size
+// is known, the reporter is started and a function is returned to stop it once the layer is extracted
+func (i *DirImage) layerStream(layer regv1.Layer) (io.ReadCloser, func(), error) {
+	if i.opts.Progress == nil {
+		stream, err := layer.Uncompressed()
+		return stream, nil, err
+	}
 
-func (i *DirImage) extractTarEntry(header *tar.Header, input io.Reader) error {
-	path := i.hydrateFilepath(header.Name)
-	mode := header.FileInfo().Mode()
+	size, err := layer.Size()
+	if err != nil {
+		return nil, nil, err
+	}
+	if size <= 0 {
+		stream, err := layer.Uncompressed()
+		return stream, nil, err
+	}
 
-	// copy user permissions to group and other
-	userPermission := int64(mode & 0700)
-	permMode := os.FileMode(userPermission | userPermission>>3 | userPermission>>6)
+	updates := make(chan regv1.Update)
+	done := make(chan struct{})
+	endProgress := func() {
+		close(done)
+		i.opts.Progress.End()
+	}
+
+	// the reporter needs to be consuming updates before the stream is created, since
+	// detecting the compression of the layer already reads from it
+	i.opts.Progress.Start(i.ctx(), updates)
 
-	// By default, imgpkg will remove the permissions for group/all on all files.
-	// Here we are checking if these permissions are still present. If this is the case it means that the creator
-	// of the OCI image intended to keep the original permissions of the file. In this case we will honor the
-	// request by keeping the original permissions on the files
-	if mode&0077 > 0 {
-		permMode = mode
+	countedLayer, err := partial.CompressedToLayer(&progressLayer{layer: layer, size: size, updates: updates, done: done})
+	if err != nil {
+		endProgress()
+		return nil, nil, err
 	}
 
-	err := os.MkdirAll(filepath.Dir(path), 0777)
+	stream, err := countedLayer.Uncompressed()
 	if err != nil {
-		return err
+		endProgress()
+		return nil, nil, err
 	}
 
-	switch header.Typeflag {
-	case tar.TypeDir:
-		return nil
+	return stream, endProgress, nil
+}
+
+// progressLayer only exposes the compressed side of a layer, so that partial.CompressedToLayer
+// decompresses the stream that is being counted
+type progressLayer struct {
+	layer   regv1.Layer
+	size    int64
+	updates chan<- regv1.Update
+	done    <-chan struct{}
+}
+
+func (l *progressLayer) Digest() (regv1.Hash, error)         { return l.layer.Digest() }
+func (l *progressLayer) Size() (int64, error)                { return l.size, nil }
+func (l *progressLayer) MediaType() (types.MediaType, error) { return l.layer.MediaType() }
+
+func (l *progressLayer) Compressed() (io.ReadCloser, error) {
+	stream, err := l.layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	return &progressReader{ReadCloser: stream, total: l.size, updates: l.updates, done: l.done}, nil
+}
+
+// progressReader counts the bytes read and sends them as progress updates until done is closed
+type progressReader struct {
+	io.ReadCloser
+	complete int64
+	total    int64
+	updates  chan<- regv1.Update
+	done     <-chan struct{}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.complete += int64(n)
+		select {
+		case r.updates <- regv1.Update{Total: r.total, Complete: r.complete}:
+		case <-r.done:
+		}
+	}
+	return n, err
+}
+
+// Taken from https://github.com/concourse/registry-image-resource/blob/b5481130ad61bc74e0a74f9b00b287b3a24bab88/cmd/in/unpack.go
+
+func (i *DirImage) writeLayer(whiteouts *whiteouts, layerDigest string, stream io.Reader) error {
+	tarReader := tar.NewReader(&contextReader{ctx: i.ctx(), reader: stream})
+	i.layerDigest = layerDigest
+	i.layer = whiteouts.Layer()
+	defer i.removeShadowedFiles()
 
-	case tar.TypeReg, tar.TypeRegA:
-		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, permMode)
+	for {
+		hdr, err := tarReader.Next()
 		if err != nil {
+			if err == io.EOF {
+				break
+			}
 			return err
 		}
 
-		_, err = io.Copy(file, input)
+		if isMetadataEntry(hdr) {
+			continue
+		}
+		canonicalizeHeader(hdr)
+
+		err = i.counter.Add(layerDigest, hdr)
 		if err != nil {
-			_ = file.Close()
 			return err
 		}
 
-		err = file.Close()
+		path, err := i.entryPath(hdr)
 		if err != nil {
 			return err
 		}
 
-	case tar.TypeLink, tar.TypeSymlink:
-		// skipping symlinks as a security feature
-		return nil
-
-	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
-		// skipping devices
-		return nil
-
-	default:
-		return fmt.Errorf("Unsupported tar entry type '%c' for file '%s'", header.Typeflag, header.Name)
-	}
-
-	if runtime.GOOS != "windows" && i.shouldChown {
-		err = os.Lchown(path, header.Uid, header.Gid)
+		// writing or removing through a symlink could reach files outside of the output directory
+		link, err := i.symlinkInParents(path)
 		if err != nil {
 			return err
 		}
-	}
-
-	// must be done after everything
-	return lchtimes(header, path)
-}
+		if link != "" {
+			return fmt.Errorf("Entry '%s' is inside of the symlink '%s'", hdr.Name, i.relativeToDir(link))
+		}
 
-func lchtimes(header *tar.Header, path string) error {
-	aTime := header.AccessTime
-	mTime := header.ModTime
-	if aTime.Before(mTime) {
-		aTime = mTime
-	}
+		if whiteouts.Opaque(path) {
+			i.entries.Whiteouts++
+			continue
+		}
 
-	if header.Typeflag == tar.TypeLink {
-		if fi, err := os.Lstat(header.Linkname); err == nil && (fi.Mode()&os.ModeSymlink == 0) {
-			return os.Chtimes(path, aTime, mTime)
+		if whiteoutPath, ok := whiteouts.Whiteout(path); ok {
+			err := i.removeWhitedOutPath(whiteoutPath, whiteouts.Layer())
+			if err != nil {
+				return fmt.Errorf("Removing whited out path '%s': %s", i.relativeToDir(whiteoutPath), err)
+			}
+			i.skippedPaths[whiteoutPath] = true
+			i.entries.Whiteouts++
+			continue
 		}
-	} else if header.Typeflag != tar.TypeSymlink {
-		return os.Chtimes(path, aTime, mTime)
-	}
 
-	return nil
-}
+		// check for a whited out parent directory
+		if whiteouts.Hidden(path) {
+			i.skippedPaths[path] = true
+			// hardlinks of the layer, or of newer ones, still have the content of the whited out file
+			if hdr.FileInfo().Mode().IsRegular() {
+				err := i.stashShadowedFile(hdr, path, tarReader)
+				if err != nil {
+					return fmt.Errorf("Keeping file '%s' whited out by a newer layer: %s", hdr.Name, err)
+				}
+			}
+			continue
+		}
 
-// hydrateFilepath ensures that the file is correct based on the OS.
-func (i *DirImage) hydrateFilepath(fPath string) string {
-	var lPath string
+		// entries of newer layers win over the ones of older layers, within a layer the last entry for a path wins
+		if whiteouts.Replaced(path, hdr.Typeflag == tar.TypeDir) {
+			if _, extracted := i.extractedPaths[path]; !extracted {
+				i.skippedPaths[path] = true
+			}
+			if hdr.FileInfo().Mode().IsRegular() {
+				err := i.stashShadowedFile(hdr, path, tarReader)
+				if err != nil {
+					return fmt.Errorf("Keeping file '%s' replaced by a newer layer: %s", hdr.Name, err)
+				}
+			}
+			continue
+		}
+		whiteouts.Add(path, hdr.Typeflag == tar.TypeDir)
+
+		if !i.included(hdr.Name) {
+			i.skippedPaths[path] = true
+			continue
+		}
+
+		if i.excluded(hdr.Name) {
+			i.skippedPaths[path] = true
+			i.entries.Excluded++
+			continue
+		}
+
+		err = i.checkWindowsName(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		if i.caseCollisions != nil && path != filepath.Clean(i.dirPath) {
+			err := i.caseCollisions.Add(i.relativeToDir(path))
+			if err != nil {
+				return err
+			}
+		}
+
+		if fi, err := os.Lstat(longPath(path)); err == nil {
+			if fi.IsDir() && hdr.Name == "." {
+				// the output directory is only changed to match the image when keeping its permissions
+				if i.opts.PreservePermissions {
+					mode := hdr.FileInfo().Mode()
+					if mode&specialModeBits != 0 && !i.opts.PreserveSpecialBits {
+						mode &^= specialModeBits
+						i.entries.StrippedSpecialBits++
+					}
+					i.recordDir(path, hdr, mode)
+				}
+				continue
+			}
+			if _, extracted := i.extractedPaths[path]; i.opts.NoClean && !extracted {
+				err := conflictWithExistingPath(fi, hdr)
+				if err != nil {
+					return err
+				}
+			}
+			if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+				if err := os.RemoveAll(longPath(path)); err != nil {
+					return err
+				}
+			}
+		}
+
+		i.extractedPaths[path] = whiteouts.Layer()
+		err = i.extractTarEntry(hdr, tarReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	// hardlinks can point to files that only show up later in the same layer
+	return i.createPendingHardlinks(false)
+}
+
+// checkIncludePathsMatched ensures that every include path matched at least one entry of the image
+func (i *DirImage) checkIncludePathsMatched() error {
+	for idx, pattern := range i.opts.IncludePaths {
+		if !i.matchedPatterns[idx] {
+			return fmt.Errorf("Include path '%s' did not match any entry in the image", pattern)
+		}
+	}
+	return nil
+}
+
+// removeWhitedOutPath removes the content at path that was extracted from the current layer or existed in the
+// output directory before the extraction. Entries extracted from newer layers are kept, since the whiteout only
+// applies to older layers
+func (i *DirImage) removeWhitedOutPath(path string, layer int) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		// a newer layer might have replaced one of the parent directories with a file
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil
+		}
+		return err
+	}
+
+	if extractedLayer, found := i.extractedPaths[path]; found && extractedLayer != layer {
+		return nil
+	}
+	if !info.IsDir() {
+		return os.Remove(path)
+	}
+
+	children, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err := i.removeWhitedOutPath(filepath.Join(path, child.Name()), layer)
+		if err != nil {
+			return err
+		}
+	}
+
+	children, err = os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// included checks if the entry matches one of the include paths, and records the patterns it matched
+func (i *DirImage) included(name string) bool {
+	if i.includePaths.Empty() {
+		return true
+	}
+	matched := i.includePaths.MatchedPatterns(name)
+	for _, idx := range matched {
+		i.matchedPatterns[idx] = true
+	}
+	return len(matched) > 0
+}
+
+// excluded checks if the entry is one of the excluded paths, or is inside of one of them
+func (i *DirImage) excluded(name string) bool {
+	for _, excludedPath := range i.opts.ExcludedPaths {
+		excludedPath = canonicalEntryName(excludedPath)
+		if name == excludedPath || strings.HasPrefix(name, excludedPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictWithExistingPath checks if an entry from the image can replace a path that existed in the output directory
+// before the extraction, which is only allowed when neither or both are directories
+func conflictWithExistingPath(existing os.FileInfo, hdr *tar.Header) error {
+	switch {
+	case existing.IsDir() && hdr.Typeflag != tar.TypeDir:
+		return fmt.Errorf("Cannot replace existing directory '%s' with a file from the image", hdr.Name)
+	case !existing.IsDir() && hdr.Typeflag == tar.TypeDir:
+		return fmt.Errorf("Cannot replace existing file '%s' with a directory from the image", hdr.Name)
+	}
+	return nil
+}
+
+// specialModeBits setuid, setgid and sticky bits, see DirImageOpts.PreserveSpecialBits
+const specialModeBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// fileMode returns the mode a regular file has once extracted with permMode, the umask only applies when the
+// permissions are not preserved
+func (i *DirImage) fileMode(permMode os.FileMode) os.FileMode {
+	if i.opts.PreservePermissions {
+		return permMode
+	}
+	return permMode.Perm()&^i.umask | permMode&specialModeBits
+}
+
+// Taken from https://github.com/concourse/go-archive/blob/f26802964d15194bddb07bf116ea567c56af973f/tarfs/extract.go
+
+func (i *DirImage) extractTarEntry(header *tar.Header, input io.Reader) error {
+	path := i.hydrateFilepath(header.Name)
+	// the path used to change the filesystem, which can be longer than what Windows accepts by default
+	fsPath := longPath(path)
+	mode := header.FileInfo().Mode()
+	specialBits := mode & specialModeBits
+	if specialBits != 0 && !i.opts.PreserveSpecialBits {
+		mode &^= specialModeBits
+		specialBits = 0
+		if header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeLink {
+			i.entries.StrippedSpecialBits++
+		}
+	}
+
+	permMode := mode
+	if !i.opts.PreservePermissions {
+		permMode = i.opts.DefaultPerms.permMode(mode)
+	}
+	permMode |= specialBits
+
+	err := i.mkdirParentsInDir(header, path)
+	if err != nil {
+		return err
+	}
+
+	err = i.checkParentInDir(header, path)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		// the user needs to be able to write the contents of the directory, its mode from the image is only
+		// applied after all the layers are extracted
+		err := os.Mkdir(fsPath, permMode|0700)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+		// the directory kept from a previous layer or extraction must not be a symlink to somewhere else
+		info, err := os.Lstat(fsPath)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Clean(path) != filepath.Clean(i.dirPath) {
+			return fmt.Errorf("Entry '%s' is not a directory on disk", header.Name)
+		}
+		if i.opts.Ownership.enabled() || i.opts.Ownership.Mode == OwnershipPreserve {
+			err := i.chown(header, path)
+			if err != nil {
+				return err
+			}
+		}
+		dirMode := permMode &^ i.umask
+		if i.opts.PreservePermissions {
+			dirMode = mode
+		}
+		i.recordDir(path, header, dirMode)
+		i.entries.Directories++
+		if i.opts.PreserveXattrs {
+			return i.setXattrs(header, path)
+		}
+		return nil
+
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		i.entries.Files++
+		i.entries.Bytes += header.Size
+		content, hasher := i.checksumReader(input)
+		if i.usesLayerStore(header) {
+			var linked bool
+			linked, content = i.linkFromLayerStore(header, path, permMode, content)
+			if linked {
+				// the file of the store already has the metadata of the entry
+				i.recordChecksum(path, hasher)
+				return nil
+			}
+		}
+
+		file, err := openNoFollow(fsPath, permMode)
+		if err != nil {
+			if info, lstatErr := os.Lstat(fsPath); lstatErr == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("Entry '%s' is a symlink on disk, refusing to write through it", header.Name)
+			}
+			return err
+		}
+
+		if isSparse(header) {
+			err = i.writeSparse(file, content)
+		} else {
+			_, err = i.copyEntry(file, content)
+		}
+		if err != nil {
+			// the partial file would look like a complete one when extracting on top of existing content
+			_ = file.Close()
+			_ = os.Remove(fsPath)
+			return err
+		}
+
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+		i.recordChecksum(path, hasher)
+
+	case tar.TypeSymlink:
+		if i.skipsSymlinks() {
+			// skipping symlinks as a security feature
+			i.skippedLinks++
+			i.entries.SkippedSymlinks++
+			i.skippedPaths[path] = true
+			return nil
+		}
+
+		err := i.validateSymlink(path, header)
+		if err != nil {
+			return err
+		}
+
+		err = os.Symlink(header.Linkname, fsPath)
+		if err != nil {
+			return err
+		}
+		i.entries.Symlinks++
+
+	case tar.TypeLink:
+		target, inside, err := i.resolveInDir(i.dirPath, header.Linkname)
+		if err != nil {
+			return fmt.Errorf("Resolving hardlink '%s' target '%s': %s", header.Name, header.Linkname, err)
+		}
+		if !inside {
+			return fmt.Errorf("Hardlink '%s' target '%s' is outside of the output directory", header.Name, header.Linkname)
+		}
+
+		link := pendingHardlink{header: header, path: path, target: target, layer: i.layer}
+		created, err := i.createHardlinkInLayer(link, false)
+		if err != nil {
+			return err
+		}
+		if !created {
+			i.pendingHardlinks = append(i.pendingHardlinks, link)
+		}
+		return nil
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if !i.opts.AllowDevices {
+			// skipping devices
+			i.entries.SkippedDevices++
+			i.skippedPaths[path] = true
+			return nil
+		}
+
+		err := createDevice(header, fsPath, permMode)
+		if err != nil {
+			return fmt.Errorf("Creating device '%s': %s", header.Name, err)
+		}
+		i.entries.Devices++
+
+	default:
+		return fmt.Errorf("Unsupported tar entry type '%c' for file '%s'", header.Typeflag, header.Name)
+	}
+
+	err = i.chown(header, path)
+	if err != nil {
+		return err
+	}
+
+	// chmod is not affected by the umask, and is done after chown because chown clears the setuid and setgid bits
+	if (i.opts.PreservePermissions || specialBits != 0 || i.opts.DefaultPerms.Mode == DefaultPermsMask) && header.Typeflag != tar.TypeSymlink {
+		err = os.Chmod(fsPath, i.fileMode(permMode))
+		if err != nil {
+			return err
+		}
+	}
+
+	// chown clears security capabilities, so they are only set after it
+	if i.opts.PreserveXattrs {
+		err = i.setXattrs(header, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	// must be done after everything
+	err = lchtimes(header, fsPath)
+	if err != nil {
+		return err
+	}
+
+	if header.FileInfo().Mode().IsRegular() && i.usesLayerStore(header) {
+		i.addToLayerStore(header, path)
+	}
+	return nil
+}
+
+// chown sets the owner of the entry, which is the owner in the image when running as root or when preserving
+// the ownership, or the one provided in the options. Failures are only warnings with the auto ownership mode
+func (i *DirImage) chown(header *tar.Header, path string) error {
+	if runtime.GOOS == "windows" || i.opts.Ownership.Mode == OwnershipCurrent {
+		return nil
+	}
+
+	uid, gid := header.Uid, header.Gid
+	if i.opts.Ownership.enabled() {
+		var err error
+		uid, gid, err = i.opts.Ownership.owner(header.Name, header.Uid, header.Gid, os.Getuid(), os.Getgid())
+		if err != nil {
+			return err
+		}
+	} else if !i.shouldChown {
+		return nil
+	}
+
+	err := lchownFile(path, uid, gid)
+	if err != nil && i.chownFailureIsWarning() {
+		if i.skippedOwners == 0 {
+			i.skippedOwnersReason = err.Error()
+		}
+		i.skippedOwners++
+		return nil
+	}
+	return err
+}
+
+// chownFailureIsWarning checks if the extraction continues when the owner of an entry cannot be set, which is only
+// the case when the owner from the image is used because imgpkg is running as root
+func (i *DirImage) chownFailureIsWarning() bool {
+	mode := i.opts.Ownership.Mode
+	return (mode == "" || mode == OwnershipAuto) && !i.opts.Ownership.enabled()
+}
+
+// lchownFile changes the owner of files, it is a variable so that tests can check the owners set without being root
+var lchownFile = os.Lchown
+
+// skipsSymlinks checks if symlinks are left out of the extraction, which is the default as a security feature
+func (i *DirImage) skipsSymlinks() bool {
+	return !i.opts.PreserveSymlinks || runtime.GOOS == "windows"
+}
+
+// createPendingHardlinks creates the hardlinks whose target is already present, the remaining ones are kept as pending.
+// Once all the layers are extracted, hardlinks to files only found in newer layers link to them
+func (i *DirImage) createPendingHardlinks(allLayersExtracted bool) error {
+	var stillPending []pendingHardlink
+	for _, link := range i.pendingHardlinks {
+		// symlinks might have been extracted since the hardlink was found
+		symlink, err := i.symlinkInParents(link.path)
+		if err != nil {
+			return err
+		}
+		if symlink != "" {
+			return fmt.Errorf("Entry '%s' is inside of the symlink '%s'", link.header.Name, i.relativeToDir(symlink))
+		}
+		target, inside, err := i.resolveInDir(i.dirPath, link.header.Linkname)
+		if err != nil {
+			return fmt.Errorf("Resolving hardlink '%s' target '%s': %s", link.header.Name, link.header.Linkname, err)
+		}
+		if !inside {
+			return fmt.Errorf("Hardlink '%s' target '%s' is outside of the output directory", link.header.Name, link.header.Linkname)
+		}
+		link.target = target
+
+		created, err := i.createHardlinkInLayer(link, allLayersExtracted)
+		if err != nil {
+			return err
+		}
+		if !created {
+			stillPending = append(stillPending, link)
+		}
+	}
+	i.pendingHardlinks = stillPending
+	return nil
+}
+
+// createHardlinkInLayer creates the hardlink when its target is present with the content it has in the layer of
+// the hardlink, which is the file of the output directory unless a newer layer replaced or whited it out. In that
+// case the hardlink gets the content of the file kept from the layer being extracted, or stays pending until an
+// older layer has the file. Returns false when the hardlink is still pending
+func (i *DirImage) createHardlinkInLayer(link pendingHardlink, shadowedTargetOk bool) (bool, error) {
+	if _, err := os.Lstat(link.target); err != nil {
+		return i.createHardlinkToShadowedFile(link)
+	}
+
+	if layer, extracted := i.extractedPaths[link.target]; extracted && layer < link.layer && !shadowedTargetOk {
+		return i.createHardlinkToShadowedFile(link)
+	}
+
+	// hardlinks share ownership and times with the target, so there is nothing else to do
+	err := i.createHardlink(link.header, link.path, link.target)
+	if err != nil {
+		return false, err
+	}
+	i.entries.Hardlinks++
+	i.recordHardlinkChecksum(link.path, link.target)
+	return true, nil
+}
+
+// createHardlinkToShadowedFile links the hardlink to the copy of its target kept from the layer being extracted.
+// Returns false when the layer does not have the target
+func (i *DirImage) createHardlinkToShadowedFile(link pendingHardlink) (bool, error) {
+	shadowed, found := i.shadowedFiles[link.target]
+	if !found {
+		return false, nil
+	}
+	err := i.createHardlink(link.header, link.path, shadowed.path)
+	if err != nil {
+		return false, err
+	}
+	if i.checksums != nil {
+		i.checksums[link.path] = shadowed.checksum
+	}
+	i.entries.Hardlinks++
+	return true, nil
+}
+
+// stashShadowedFile keeps a copy of the regular file at path of the layer being extracted, which a newer layer
+// replaced or whited out, with the metadata it would have been extracted with, in case hardlinks of the layer point to it.
+// The copies are in a temporary directory of the output directory, so that the hardlinks can link to them
+func (i *DirImage) stashShadowedFile(header *tar.Header, path string, input io.Reader) error {
+	if i.shadowedDir == "" {
+		dir, err := os.MkdirTemp(longPath(i.dirPath), ".imgpkg-shadowed-")
+		if err != nil {
+			return err
+		}
+		i.shadowedDir = dir
+		i.shadowedFiles = map[string]shadowedFile{}
+	}
+
+	file, err := os.CreateTemp(i.shadowedDir, "file-")
+	if err != nil {
+		return err
+	}
+	content, hasher := i.checksumReader(input)
+	if isSparse(header) {
+		err = i.writeSparse(file, content)
+	} else {
+		_, err = i.copyEntry(file, content)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = i.chown(header, file.Name())
+	if err != nil {
+		return err
+	}
+	// chmod is done after chown because chown clears the setuid and setgid bits
+	err = os.Chmod(file.Name(), i.fileMode(i.entryPermMode(header)))
+	if err != nil {
+		return err
+	}
+	err = lchtimes(header, file.Name())
+	if err != nil {
+		return err
+	}
+
+	shadowed := shadowedFile{path: file.Name()}
+	if hasher != nil {
+		shadowed.checksum = hex.EncodeToString(hasher.Sum(nil))
+	}
+	i.shadowedFiles[path] = shadowed
+	return nil
+}
+
+// removeShadowedFiles removes the copies kept by stashShadowedFile, the hardlinks created to them keep their content
+func (i *DirImage) removeShadowedFiles() {
+	if i.shadowedDir == "" {
+		return
+	}
+	_ = os.RemoveAll(i.shadowedDir)
+	i.shadowedDir = ""
+	i.shadowedFiles = nil
+}
+
+// entryPermMode permissions a file is created with, before the umask, including its special bits when they are kept
+func (i *DirImage) entryPermMode(header *tar.Header) os.FileMode {
+	mode := header.FileInfo().Mode()
+	specialBits := mode & specialModeBits
+	if !i.opts.PreserveSpecialBits {
+		mode &^= specialModeBits
+		specialBits = 0
+	}
+	if !i.opts.PreservePermissions {
+		mode = i.opts.DefaultPerms.permMode(mode)
+	}
+	return mode | specialBits
+}
+
+// skipHardlinksToSkippedPaths drops the pending hardlinks whose target was intentionally not extracted
+// (i.e. symlinks, devices or whited out files), including hardlinks to other skipped hardlinks
+func (i *DirImage) skipHardlinksToSkippedPaths() {
+	for {
+		var stillPending []pendingHardlink
+		for _, link := range i.pendingHardlinks {
+			if i.skippedPaths[link.target] {
+				i.skippedHardlinks++
+				i.skippedPaths[link.path] = true
+				continue
+			}
+			stillPending = append(stillPending, link)
+		}
+
+		done := len(stillPending) == len(i.pendingHardlinks)
+		i.pendingHardlinks = stillPending
+		if done {
+			return
+		}
+	}
+}
+
+// createHardlink links path to target. When the filesystem does not support hardlinks the file is copied instead,
+// and as a copy does not share ownership or times with the target, those are applied from the header
+func (i *DirImage) createHardlink(header *tar.Header, path, target string) error {
+	path, target = longPath(path), longPath(target)
+	linkErr := linkFile(target, path)
+	if linkErr == nil {
+		return nil
+	}
+	if !hardlinkNotSupported(linkErr) {
+		return fmt.Errorf("Creating hardlink '%s': %s", header.Name, linkErr)
+	}
+
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("Creating hardlink '%s': %s", header.Name, linkErr)
+	}
+	if !targetInfo.Mode().IsRegular() {
+		return fmt.Errorf("Creating hardlink '%s': %s", header.Name, linkErr)
+	}
+
+	err = copyFile(target, path, targetInfo.Mode())
+	if err != nil {
+		return fmt.Errorf("Creating hardlink '%s' (copying the file after failing to link: %s): %s", header.Name, linkErr, err)
+	}
+
+	err = i.chown(header, path)
+	if err != nil {
+		return err
+	}
+
+	// chmod is not affected by the umask, and is done after chown because chown clears the setuid and setgid bits
+	err = os.Chmod(path, targetInfo.Mode())
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, targetInfo.ModTime(), targetInfo.ModTime())
+}
+
+// linkFile creates hardlinks, it is a variable so that tests can simulate filesystems without hardlinks
+var linkFile = os.Link
+
+// hardlinkNotSupported checks if the error returned when creating a hardlink means that the filesystem
+// cannot create the link, in which case copying the file is an acceptable replacement
+func hardlinkNotSupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EMLINK) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
+
+// copyFile copies the content of src into a new file in dst
+func copyFile(src, dst string, mode os.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dstFile, srcFile)
+	if err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+
+	return dstFile.Close()
+}
+
+func lchtimes(header *tar.Header, path string) error {
+	aTime := header.AccessTime
+	mTime := header.ModTime
+	if aTime.Before(mTime) {
+		aTime = mTime
+	}
+
+	if header.Typeflag != tar.TypeSymlink {
+		return os.Chtimes(path, aTime, mTime)
+	}
+
+	return nil
+}
+
+// validateSymlink ensures that the target of the symlink does not point outside of the extraction directory,
+// taking into account the symlinks that were already extracted
+func (i *DirImage) validateSymlink(path string, header *tar.Header) error {
+	if filepath.IsAbs(header.Linkname) || strings.HasPrefix(header.Linkname, "/") {
+		return fmt.Errorf("Symlink '%s' has an absolute target '%s'", header.Name, header.Linkname)
+	}
+
+	_, inside, err := i.resolveInDir(filepath.Dir(path), header.Linkname)
+	if err != nil {
+		return fmt.Errorf("Resolving symlink '%s' target '%s': %s", header.Name, header.Linkname, err)
+	}
+	if !inside {
+		return fmt.Errorf("Symlink '%s' target '%s' is outside of the output directory", header.Name, header.Linkname)
+	}
+	return nil
+}
+
+// maxSymlinksResolved limits the number of symlinks followed when resolving a path, to stop on symlink loops
+const maxSymlinksResolved = 255
+
+// resolveInDir resolves the image path target relative to the directory from, following the symlinks that already
+// exist on disk the same way the OS would. target is not cleaned beforehand because '..' after a symlink goes
+// to the parent of the symlink target. It returns false when target, or any of the symlinks it goes through,
+// points outside of the output directory. Components that do not exist yet are resolved lexically
+func (i *DirImage) resolveInDir(from, target string) (string, bool, error) {
+	root := filepath.Clean(i.dirPath)
+	if !isWithinDir(root, from) {
+		return "", false, nil
+	}
+
+	rel, err := filepath.Rel(root, filepath.Clean(from))
+	if err != nil {
+		return "", false, err
+	}
+
+	pending := append(strings.Split(rel, string(filepath.Separator)), i.imagePathComponents(target)...)
+	resolved := root
+	linksFollowed := 0
+	for len(pending) > 0 {
+		component := pending[0]
+		pending = pending[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == root {
+				return "", false, nil
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, component)
+		info, err := os.Lstat(next)
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", false, err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		linksFollowed++
+		if linksFollowed > maxSymlinksResolved {
+			return "", false, fmt.Errorf("Too many levels of symlinks")
+		}
+
+		linkTarget, err := os.Readlink(next)
+		if err != nil {
+			return "", false, err
+		}
+		if filepath.IsAbs(linkTarget) {
+			return "", false, nil
+		}
+		pending = append(splitImagePath(linkTarget), pending...)
+	}
+
+	return resolved, true, nil
+}
+
+// symlinkInParents returns the first symlink found on disk between the output directory and the parent of path
+func (i *DirImage) symlinkInParents(path string) (string, error) {
+	root := filepath.Clean(i.dirPath)
+	rel, err := filepath.Rel(root, filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return "", nil
+	}
+
+	current := root
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return current, nil
+		}
+	}
+	return "", nil
+}
+
+// entryPath returns the path in the output directory where the entry is extracted, failing when the entry
+// name, like '../file', would place it outside of the output directory
+func (i *DirImage) entryPath(header *tar.Header) (string, error) {
+	path := i.hydrateFilepath(header.Name)
+	if !isWithinDir(i.dirPath, path) {
+		return "", fmt.Errorf("Entry '%s' is outside of the output directory", header.Name)
+	}
+	return path, nil
+}
+
+// mkdirParentsInDir creates the missing parent directories of path one at a time, starting from the output
+// directory. Each existing component is checked with lstat, so that a symlink pointing outside of the output
+// directory is never followed to create directories there, while the directories above the output directory
+// are created as they are
+func (i *DirImage) mkdirParentsInDir(header *tar.Header, path string) error {
+	root := filepath.Clean(i.dirPath)
+	err := os.MkdirAll(longPath(root), 0777)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	// the user needs to be able to write the entries inside of the parent directories
+	parentMode := 0777&^i.umask | 0700
+	current := root
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, component)
+		err := os.Mkdir(longPath(current), parentMode)
+		if err == nil {
+			if i.opts.DefaultPerms.Mode == DefaultPermsMask {
+				// the umask of the process is replaced by the mask
+				err = os.Chmod(longPath(current), parentMode)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		info, err := os.Lstat(longPath(current))
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(current)
+			if err != nil {
+				return err
+			}
+			realRoot, err := filepath.EvalSymlinks(root)
+			if err != nil {
+				return err
+			}
+			if !isWithinDir(realRoot, resolved) {
+				return fmt.Errorf("Entry '%s' parent directory resolves to '%s', outside of the output directory", header.Name, resolved)
+			}
+			continue
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("Entry '%s' parent '%s' is not a directory", header.Name, i.relativeToDir(current))
+		}
+	}
+	return nil
+}
+
+// checkParentInDir resolves the symlinks of the parent directory of path, right before the entry is written, and
+// ensures it is still inside of the output directory
+func (i *DirImage) checkParentInDir(header *tar.Header, path string) error {
+	root, err := filepath.EvalSymlinks(i.dirPath)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if !isWithinDir(root, parent) {
+		return fmt.Errorf("Entry '%s' parent directory resolves to '%s', outside of the output directory", header.Name, parent)
+	}
+	return nil
+}
+
+// isWithinDir checks lexically if path is dir or one of its children
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// relativeToDir returns path relative to the output directory using forward slashes, the same way paths are in the image
+func (i *DirImage) relativeToDir(path string) string {
+	rel, err := filepath.Rel(i.dirPath, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// splitImagePath splits a path from the image in its components
+func splitImagePath(fPath string) []string {
 	// We need to check the existance of \ type paths in the images because in previous versions of imgpkg images that
 	// were created on Windows would have the path using \ instead of the new OS-agnostic version
 	if strings.Contains(fPath, "\\") {
-		lPath = filepath.Join(strings.Split(fPath, "\\")...)
-	} else {
-		lPath = filepath.Join(strings.Split(fPath, "/")...)
+		return strings.Split(fPath, "\\")
 	}
-	return filepath.Join(i.dirPath, lPath)
+	return strings.Split(fPath, "/")
+}
+
+// hydrateFilepath ensures that the file is correct based on the OS.
+func (i *DirImage) hydrateFilepath(fPath string) string {
+	return filepath.Join(i.dirPath, filepath.Join(i.imagePathComponents(fPath)...))
 }
@@ -0,0 +1,90 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirImageOwnershipModes(t *testing.T) {
+	// the names of the entries whose owner is changed are recorded
+	fakeChown := func(t *testing.T, chownErr error) *[]string {
+		var chowns []string
+		lchownFile = func(path string, uid, gid int) error {
+			chowns = append(chowns, filepath.Base(path))
+			return chownErr
+		}
+		t.Cleanup(func() { lchownFile = os.Lchown })
+		return &chowns
+	}
+	img := singleLayerImage(t, "a.txt", "b.txt")
+
+	t.Run("when preserving the ownership, it sets the owner of every entry even when not running as root", func(t *testing.T) {
+		chowns := fakeChown(t, nil)
+		dirImage := NewDirImageWithOpts(t.TempDir(), img, DirImageOpts{Ownership: OwnershipOpts{Mode: OwnershipPreserve}}, util.NewNoopLogger())
+
+		require.NoError(t, dirImage.AsDirectory())
+		assert.Equal(t, []string{"a.txt", "b.txt"}, *chowns)
+	})
+
+	t.Run("when preserving the ownership, it fails when the owner cannot be set", func(t *testing.T) {
+		fakeChown(t, fmt.Errorf("operation not permitted"))
+		dirImage := NewDirImageWithOpts(t.TempDir(), img, DirImageOpts{Ownership: OwnershipOpts{Mode: OwnershipPreserve}}, util.NewNoopLogger())
+
+		require.ErrorContains(t, dirImage.AsDirectory(), "operation not permitted")
+	})
+
+	t.Run("when keeping the current owner, it never sets the owner even when running as root", func(t *testing.T) {
+		chowns := fakeChown(t, nil)
+		dirImage := NewDirImageWithOpts(t.TempDir(), img, DirImageOpts{Ownership: OwnershipOpts{Mode: OwnershipCurrent}}, util.NewNoopLogger())
+		dirImage.shouldChown = true
+
+		require.NoError(t, dirImage.AsDirectory())
+		assert.Empty(t, *chowns)
+	})
+
+	t.Run("with the auto mode, it only sets the owner when running as root", func(t *testing.T) {
+		chowns := fakeChown(t, nil)
+		dirImage := NewDirImageWithOpts(t.TempDir(), img, DirImageOpts{}, util.NewNoopLogger())
+		dirImage.shouldChown = false
+
+		require.NoError(t, dirImage.AsDirectory())
+		assert.Empty(t, *chowns)
+
+		dirImage.shouldChown = true
+		require.NoError(t, dirImage.AsDirectory())
+		assert.Equal(t, []string{"a.txt", "b.txt"}, *chowns)
+	})
+
+	t.Run("with the auto mode, it warns when the owner cannot be set", func(t *testing.T) {
+		chowns := fakeChown(t, fmt.Errorf("operation not permitted"))
+		logs := bytes.NewBuffer(nil)
+		folder := t.TempDir()
+		dirImage := NewDirImageWithOpts(folder, img, DirImageOpts{Ownership: OwnershipOpts{Mode: OwnershipAuto}}, util.NewBufferLogger(logs))
+		dirImage.shouldChown = true
+
+		require.NoError(t, dirImage.AsDirectory())
+		assert.Equal(t, []string{"a.txt", "b.txt"}, *chowns)
+		assert.Contains(t, logs.String(), "Warning: Skipped setting the owner of 2 entries while extracting, first error was operation not permitted")
+		assert.FileExists(t, filepath.Join(folder, "b.txt"))
+	})
+
+	t.Run("with the auto mode, it fails when the owner provided in the options cannot be set", func(t *testing.T) {
+		fakeChown(t, fmt.Errorf("operation not permitted"))
+		opts := DirImageOpts{Ownership: OwnershipOpts{Chown: true, UID: 1234, GID: 5678}}
+		dirImage := NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger())
+
+		require.ErrorContains(t, dirImage.AsDirectory(), "operation not permitted")
+	})
+}
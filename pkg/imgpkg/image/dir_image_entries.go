@@ -0,0 +1,258 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// ListedEntry entry of the image that is extracted by AsDirectory
+type ListedEntry struct {
+	Path        string
+	Size        int64
+	Mode        os.FileMode
+	LayerDigest string
+}
+
+// Entries lists the entries that AsDirectory would extract, sorted by path, without writing anything to disk.
+// The layers are walked in the same way, so entries removed by whiteouts, or filtered out by the options, are not listed
+func (i *DirImage) Entries() ([]ListedEntry, error) {
+	entries, err := i.mergedEntries(false, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ListedEntry
+	for _, entry := range entries {
+		result = append(result, entry.ListedEntry)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Path < result[b].Path })
+
+	return result, nil
+}
+
+// layerEntry entry of the merged view of the layers, with the position where it is found in its layer
+type layerEntry struct {
+	ListedEntry
+	header *tar.Header
+	index  int
+}
+
+// mergedEntries walks the layers from the newest to the oldest and returns the entries that are part of the merged
+// view of the image, by hydrated path. When allTypes is true the symlinks and devices are kept, even if they
+// would not be extracted to disk. When spoolDir is provided, the compressed content of each layer is also written
+// to the file spoolPath returns while it is read, reporting the progress, so that the layers are only downloaded once
+func (i *DirImage) mergedEntries(allTypes bool, spoolDir string) (map[string]layerEntry, error) {
+	includePaths, err := NewPathGlobs(i.opts.IncludePaths)
+	if err != nil {
+		return nil, err
+	}
+	i.includePaths = includePaths
+	i.matchedPatterns = map[int]bool{}
+
+	layers, err := i.img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	whiteouts := newWhiteouts()
+	entries := newListedEntries()
+
+	for idx := len(layers) - 1; idx >= 0; idx-- {
+		imgLayer := layers[idx]
+		digest, err := imgLayer.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		if spoolDir == "" {
+			layerStream, err := imgLayer.Uncompressed()
+			if err != nil {
+				return nil, err
+			}
+			err = i.listLayer(whiteouts, digest.String(), layerStream, entries, allTypes)
+			_ = layerStream.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = i.listSpooledLayer(whiteouts, digest.String(), imgLayer, spoolPath(spoolDir, idx), entries, allTypes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = i.checkIncludePathsMatched()
+	if err != nil {
+		return nil, err
+	}
+
+	return entries.entries, nil
+}
+
+// listSpooledLayer lists the entries of the layer like listLayer, while writing its compressed content to path
+func (i *DirImage) listSpooledLayer(whiteouts *whiteouts, digest string, imgLayer regv1.Layer, path string, entries *listedEntries, allTypes bool) error {
+	layer, err := partial.CompressedToLayer(&spoolingLayer{layer: imgLayer, path: path})
+	if err != nil {
+		return err
+	}
+	layerStream, endProgress, err := i.layerStream(layer)
+	if err != nil {
+		return err
+	}
+	defer layerStream.Close()
+
+	err = i.listLayer(whiteouts, digest, layerStream, entries, allTypes)
+	if err == nil {
+		// the end of the tar is not the end of the layer, which is only complete in the spool file once fully read
+		_, err = io.Copy(io.Discard, layerStream)
+	}
+	if endProgress != nil {
+		endProgress()
+	}
+	return err
+}
+
+// listLayer records the entries of the layer in the same way writeLayer extracts them: entries found later replace
+// the ones with the same path, and removing a path also removes everything under it
+func (i *DirImage) listLayer(whiteouts *whiteouts, digest string, stream io.Reader, entries *listedEntries, allTypes bool) error {
+	tarReader := tar.NewReader(stream)
+	whiteouts.NextLayer()
+
+	for index := 0; ; index++ {
+		hdr, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if isMetadataEntry(hdr) {
+			continue
+		}
+		canonicalizeHeader(hdr)
+
+		path, err := i.entryPath(hdr)
+		if err != nil {
+			return err
+		}
+
+		if whiteouts.Opaque(path) {
+			continue
+		}
+
+		// whiteouts hide entries from older layers, which were not listed yet, and remove the ones from the same layer
+		if whiteoutPath, ok := whiteouts.Whiteout(path); ok {
+			entries.removeFromLayer(whiteoutPath, digest)
+			continue
+		}
+
+		if whiteouts.Hidden(path) || whiteouts.Replaced(path, hdr.Typeflag == tar.TypeDir) {
+			continue
+		}
+		whiteouts.Add(path, hdr.Typeflag == tar.TypeDir)
+
+		if !i.included(hdr.Name) || i.excluded(hdr.Name) || path == filepath.Clean(i.dirPath) {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse, tar.TypeLink:
+		case tar.TypeSymlink:
+			if !allTypes && i.skipsSymlinks() {
+				continue
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if !allTypes && !i.opts.AllowDevices {
+				continue
+			}
+		default:
+			return fmt.Errorf("Unsupported tar entry type '%c' for file '%s'", hdr.Typeflag, hdr.Name)
+		}
+
+		// an existing directory is kept when the entry is also a directory, everything else is replaced
+		existing, found := entries.entries[path]
+		if hdr.Typeflag == tar.TypeDir {
+			if found && existing.Mode.IsDir() {
+				continue
+			}
+			delete(entries.entries, path)
+		} else {
+			entries.remove(path)
+		}
+
+		entries.add(path, layerEntry{
+			ListedEntry: ListedEntry{
+				Path:        i.relativeToDir(path),
+				Size:        hdr.Size,
+				Mode:        hdr.FileInfo().Mode(),
+				LayerDigest: digest,
+			},
+			header: hdr,
+			index:  index,
+		})
+	}
+
+	return nil
+}
+
+// listedEntries entries of the merged view by path, with the paths found inside of each directory, whether or not
+// the directory has an entry, so that removing a directory only goes through the entries inside of it
+type listedEntries struct {
+	entries  map[string]layerEntry
+	children map[string]map[string]bool
+}
+
+func newListedEntries() *listedEntries {
+	return &listedEntries{entries: map[string]layerEntry{}, children: map[string]map[string]bool{}}
+}
+
+// add records the entry at path, and path as a child of each of its parent directories
+func (l *listedEntries) add(path string, entry layerEntry) {
+	l.entries[path] = entry
+	for child, parent := path, filepath.Dir(path); parent != child; child, parent = parent, filepath.Dir(parent) {
+		siblings, found := l.children[parent]
+		if !found {
+			siblings = map[string]bool{}
+			l.children[parent] = siblings
+		}
+		if siblings[child] {
+			return
+		}
+		siblings[child] = true
+	}
+}
+
+// remove removes the entry at path and all the entries inside of it
+func (l *listedEntries) remove(path string) {
+	for child := range l.children[path] {
+		l.remove(child)
+	}
+	delete(l.children, path)
+	delete(l.entries, path)
+	if siblings, found := l.children[filepath.Dir(path)]; found {
+		delete(siblings, path)
+	}
+}
+
+// removeFromLayer removes the entry at path and the entries inside of it that were found in the layer with digest
+func (l *listedEntries) removeFromLayer(path string, digest string) {
+	for child := range l.children[path] {
+		l.removeFromLayer(child, digest)
+	}
+	if entry, found := l.entries[path]; found && entry.LayerDigest == digest {
+		delete(l.entries, path)
+	}
+}
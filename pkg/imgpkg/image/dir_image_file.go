@@ -0,0 +1,247 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// maxCloseMatches number of paths suggested when the file requested from the image is not found
+const maxCloseMatches = 5
+
+// FileNotFoundError returned by WriteFile when the image does not have the file, with the paths of the image that
+// are the closest to it
+type FileNotFoundError struct {
+	Name    string
+	Matches []string
+}
+
+func (e FileNotFoundError) Error() string {
+	if len(e.Matches) == 0 {
+		return fmt.Sprintf("Expected file '%s' to be in the image", e.Name)
+	}
+	return fmt.Sprintf("Expected file '%s' to be in the image, close matches are:\n- %s", e.Name, strings.Join(e.Matches, "\n- "))
+}
+
+// WriteFile writes the content of the file at name in the merged view of the layers to out, without writing anything
+// to disk. The layers are walked from the newest to the oldest, so that whiteouts and replaced entries are respected,
+// and the walk stops at the first layer that has the file. A hardlink has the content its target has in the layer of
+// the hardlink. When name is a directory its immediate children are written instead, one per line, with a trailing
+// slash for directories
+func (i *DirImage) WriteFile(name string, out io.Writer) error {
+	err := i.checkExtractable()
+	if err != nil {
+		return err
+	}
+
+	path := i.hydrateFilepath(name)
+	if !isWithinDir(i.dirPath, path) {
+		return fmt.Errorf("Expected file '%s' to be inside of the image", name)
+	}
+
+	includePaths, err := NewPathGlobs(i.opts.IncludePaths)
+	if err != nil {
+		return err
+	}
+	i.includePaths = includePaths
+	i.matchedPatterns = map[int]bool{}
+
+	layers, err := i.img.Layers()
+	if err != nil {
+		return err
+	}
+
+	entry, layerIdx, entries, err := i.findEntry(layers, len(layers)-1, path)
+	if err != nil {
+		return err
+	}
+	if layerIdx >= 0 {
+		return i.writeFoundEntry(name, layers, layerIdx, entry, false, out)
+	}
+
+	children := immediateChildren(entries.entries, path)
+	if _, found := entries.entries[path]; found || len(children) > 0 || path == filepath.Clean(i.dirPath) {
+		for _, child := range children {
+			_, err := fmt.Fprintln(out, child)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var paths []string
+	for _, entry := range entries.entries {
+		paths = append(paths, entry.Path)
+	}
+	return FileNotFoundError{Name: name, Matches: closeMatches(i.relativeToDir(path), paths)}
+}
+
+// findEntry lists the layers from the one at position from to the oldest, which is the merged view of the image as
+// of that layer, stopping at the first layer that has an entry at path that is not a directory, since older layers
+// cannot change it. Returns the entry and the position of its layer, which is -1 when no layer has such an entry,
+// along with the entries listed
+func (i *DirImage) findEntry(layers []regv1.Layer, from int, path string) (layerEntry, int, *listedEntries, error) {
+	whiteouts := newWhiteouts()
+	entries := newListedEntries()
+
+	for idx := from; idx >= 0; idx-- {
+		digest, err := layers[idx].Digest()
+		if err != nil {
+			return layerEntry{}, -1, nil, err
+		}
+		layerStream, err := layers[idx].Uncompressed()
+		if err != nil {
+			return layerEntry{}, -1, nil, err
+		}
+		err = i.listLayer(whiteouts, digest.String(), layerStream, entries, true)
+		_ = layerStream.Close()
+		if err != nil {
+			return layerEntry{}, -1, nil, err
+		}
+
+		entry, found := entries.entries[path]
+		if found && !entry.Mode.IsDir() {
+			return entry, idx, entries, nil
+		}
+	}
+	return layerEntry{}, -1, entries, nil
+}
+
+// writeFoundEntry writes the content of the entry found at name in the layer at position layerIdx. A hardlink has the
+// content its target has in the layer of the hardlink, even when a newer layer replaces the target
+func (i *DirImage) writeFoundEntry(name string, layers []regv1.Layer, layerIdx int, entry layerEntry, fromHardlink bool, out io.Writer) error {
+	switch entry.header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		return i.writeLayerEntry(layers[layerIdx], entry, out)
+	case tar.TypeLink:
+		if fromHardlink {
+			return fmt.Errorf("Expected hardlink '%s' to point to a file", name)
+		}
+		target := i.hydrateFilepath(entry.header.Linkname)
+		if !isWithinDir(i.dirPath, target) {
+			return fmt.Errorf("Expected hardlink '%s' target '%s' to be inside of the image", name, entry.header.Linkname)
+		}
+		targetEntry, targetLayerIdx, _, err := i.findEntry(layers, layerIdx, target)
+		if err != nil {
+			return err
+		}
+		if targetLayerIdx < 0 {
+			return fmt.Errorf("Expected hardlink '%s' target '%s' to be in the image", name, entry.header.Linkname)
+		}
+		return i.writeFoundEntry(entry.header.Linkname, layers, targetLayerIdx, targetEntry, true, out)
+	case tar.TypeSymlink:
+		return fmt.Errorf("Expected '%s' to be a file, but it is a symlink to '%s'", name, entry.header.Linkname)
+	default:
+		return fmt.Errorf("Expected '%s' to be a file, but it is a device or a named pipe", name)
+	}
+}
+
+// writeLayerEntry reads the layer again until the entry and writes its content to out
+func (i *DirImage) writeLayerEntry(layer regv1.Layer, entry layerEntry, out io.Writer) error {
+	layerStream, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer layerStream.Close()
+
+	tarReader := tar.NewReader(layerStream)
+	for index := 0; index <= entry.index; index++ {
+		_, err := tarReader.Next()
+		if err != nil {
+			return fmt.Errorf("Reading entry '%s' from layer '%s': %s", entry.Path, entry.LayerDigest, err)
+		}
+	}
+	_, err = i.copyEntry(out, tarReader)
+	if err != nil {
+		return fmt.Errorf("Writing entry '%s': %s", entry.Path, err)
+	}
+	return nil
+}
+
+// immediateChildren returns the names of the entries directly inside of the directory at path, sorted, with a
+// trailing slash for directories. Directories that only exist because of the entries inside of them are included
+func immediateChildren(entries map[string]layerEntry, path string) []string {
+	prefix := path + string(filepath.Separator)
+	if strings.HasSuffix(path, string(filepath.Separator)) {
+		prefix = path
+	}
+
+	dirs := map[string]bool{}
+	for entryPath, entry := range entries {
+		rel, found := strings.CutPrefix(entryPath, prefix)
+		if !found || rel == "" {
+			continue
+		}
+		child, _, nested := strings.Cut(rel, string(filepath.Separator))
+		dirs[child] = dirs[child] || nested || entry.Mode.IsDir()
+	}
+
+	var children []string
+	for child, isDir := range dirs {
+		if isDir {
+			child += "/"
+		}
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children
+}
+
+// closeMatches returns the paths that are the most similar to target, either with the same file name or a small
+// edit distance, the closest first
+func closeMatches(target string, paths []string) []string {
+	maxDistance := len(target) / 4
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	distances := map[string]int{}
+	var matches []string
+	for _, path := range paths {
+		distance := editDistance(target, path)
+		if distance <= maxDistance || filepath.Base(path) == filepath.Base(target) {
+			distances[path] = distance
+			matches = append(matches, path)
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if distances[matches[a]] != distances[matches[b]] {
+			return distances[matches[a]] < distances[matches[b]]
+		}
+		return matches[a] < matches[b]
+	})
+	if len(matches) > maxCloseMatches {
+		matches = matches[:maxCloseMatches]
+	}
+	return matches
+}
+
+// editDistance number of characters inserted, removed or replaced to change a into b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for x := 1; x <= len(a); x++ {
+		current[0] = x
+		for y := 1; y <= len(b); y++ {
+			cost := 1
+			if a[x-1] == b[y-1] {
+				cost = 0
+			}
+			current[y] = min(previous[y]+1, current[y-1]+1, previous[y-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateHardlink(t *testing.T) {
+	failLinkWith := func(t *testing.T, linkErr error) {
+		linkFile = func(oldname, newname string) error {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: linkErr}
+		}
+		t.Cleanup(func() { linkFile = os.Link })
+	}
+	createTarget := func(t *testing.T, folder string) string {
+		target := filepath.Join(folder, "target")
+		require.NoError(t, os.WriteFile(target, []byte("content"), 0750))
+		modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		require.NoError(t, os.Chtimes(target, modTime, modTime))
+		return target
+	}
+
+	t.Run("when the filesystem does not support hardlinks, it copies the file", func(t *testing.T) {
+		failLinkWith(t, syscall.EXDEV)
+		folder := t.TempDir()
+		target := createTarget(t, folder)
+		dirImage := &DirImage{dirPath: folder, shouldChown: os.Getuid() == 0}
+		header := &tar.Header{Name: "link", Linkname: "target", Typeflag: tar.TypeLink, Uid: 1234, Gid: 5678}
+
+		require.NoError(t, dirImage.createHardlink(header, filepath.Join(folder, "link"), target))
+
+		content, err := os.ReadFile(filepath.Join(folder, "link"))
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(content))
+
+		info, err := os.Stat(filepath.Join(folder, "link"))
+		require.NoError(t, err)
+		targetInfo, err := os.Stat(target)
+		require.NoError(t, err)
+		assert.Equal(t, targetInfo.Mode(), info.Mode())
+		assert.Equal(t, targetInfo.ModTime(), info.ModTime())
+
+		if os.Getuid() == 0 {
+			stat := info.Sys().(*syscall.Stat_t)
+			assert.Equal(t, uint32(1234), stat.Uid)
+			assert.Equal(t, uint32(5678), stat.Gid)
+		}
+	})
+
+	t.Run("when the link fails for other reasons, it returns the link error", func(t *testing.T) {
+		failLinkWith(t, syscall.EACCES)
+		folder := t.TempDir()
+		target := createTarget(t, folder)
+		dirImage := &DirImage{dirPath: folder}
+		header := &tar.Header{Name: "link", Linkname: "target", Typeflag: tar.TypeLink}
+
+		err := dirImage.createHardlink(header, filepath.Join(folder, "link"), target)
+		require.ErrorContains(t, err, "Creating hardlink 'link'")
+		require.ErrorContains(t, err, "permission denied")
+
+		_, err = os.Lstat(filepath.Join(folder, "link"))
+		require.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestDirImageLayerStoreFallbacks(t *testing.T) {
+	img := singleLayerImage(t, "a.txt")
+	extract := func(t *testing.T, storeDir string, opts DirImageOpts) string {
+		folder := filepath.Join(t.TempDir(), "output")
+		opts.LayerStorePath = storeDir
+		require.NoError(t, NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+		return filepath.Join(folder, "a.txt")
+	}
+	sameFile := func(t *testing.T, path1, path2 string) bool {
+		info1, err := os.Stat(path1)
+		require.NoError(t, err)
+		info2, err := os.Stat(path2)
+		require.NoError(t, err)
+		return os.SameFile(info1, info2)
+	}
+
+	t.Run("when the store is on another filesystem, it writes the files", func(t *testing.T) {
+		linkFile = func(oldname, newname string) error {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+		}
+		t.Cleanup(func() { linkFile = os.Link })
+		storeDir := t.TempDir()
+
+		path := extract(t, storeDir, DirImageOpts{})
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "a.txt", string(content))
+
+		entries, err := os.ReadDir(storeDir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.Equal(t, "tmp", entry.Name(), "the store only has its temporary directory")
+		}
+	})
+
+	t.Run("it only links the files that have the owner the extraction sets", func(t *testing.T) {
+		lchownFile = func(string, int, int) error { return nil }
+		t.Cleanup(func() { lchownFile = os.Lchown })
+		storeDir := t.TempDir()
+		currentOwner := OwnershipOpts{Chown: true, UID: os.Getuid(), GID: os.Getgid()}
+		otherOwner := OwnershipOpts{Chown: true, UID: os.Getuid() + 1, GID: os.Getgid()}
+
+		path1 := extract(t, storeDir, DirImageOpts{Ownership: currentOwner})
+		path2 := extract(t, storeDir, DirImageOpts{Ownership: currentOwner})
+		path3 := extract(t, storeDir, DirImageOpts{Ownership: otherOwner})
+
+		assert.True(t, sameFile(t, path1, path2))
+		assert.False(t, sameFile(t, path1, path3))
+	})
+}
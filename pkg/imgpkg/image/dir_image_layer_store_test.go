@@ -0,0 +1,102 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirImageLayerStore(t *testing.T) {
+	baseLayer := []tarEntry{fileEntry("base/lib.so", "shared library"), fileEntry("base/config.yml", "config")}
+	app1 := imageFromLayers(t, baseLayer, []tarEntry{fileEntry("app", "app 1")})
+	app2 := imageFromLayers(t, baseLayer, []tarEntry{fileEntry("app", "app 2")})
+
+	extract := func(t *testing.T, storeDir string, img regv1.Image, opts image.DirImageOpts) string {
+		folder := filepath.Join(t.TempDir(), "output")
+		opts.LayerStorePath = storeDir
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+		return folder
+	}
+	sameFile := func(t *testing.T, path1, path2 string) bool {
+		info1, err := os.Stat(path1)
+		require.NoError(t, err)
+		info2, err := os.Stat(path2)
+		require.NoError(t, err)
+		return os.SameFile(info1, info2)
+	}
+	assertContent := func(t *testing.T, path, expected string) {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	t.Run("it hardlinks the files of the layers shared by several images", func(t *testing.T) {
+		storeDir := t.TempDir()
+		folder1 := extract(t, storeDir, app1, image.DirImageOpts{})
+		folder2 := extract(t, storeDir, app2, image.DirImageOpts{})
+
+		assert.True(t, sameFile(t, filepath.Join(folder1, "base", "lib.so"), filepath.Join(folder2, "base", "lib.so")))
+		assert.True(t, sameFile(t, filepath.Join(folder1, "base", "config.yml"), filepath.Join(folder2, "base", "config.yml")))
+		assert.False(t, sameFile(t, filepath.Join(folder1, "app"), filepath.Join(folder2, "app")))
+
+		assertContent(t, filepath.Join(folder2, "base", "lib.so"), "shared library")
+		assertContent(t, filepath.Join(folder1, "app"), "app 1")
+		assertContent(t, filepath.Join(folder2, "app"), "app 2")
+	})
+
+	t.Run("it copies the files when they need a different mode", func(t *testing.T) {
+		storeDir := t.TempDir()
+		entry := fileEntry("private", "private")
+		entry.header.Mode = 0600
+		img := imageFromLayers(t, []tarEntry{entry})
+		// without --preserve-permissions the user permissions are copied to group and other
+		folder1 := extract(t, storeDir, img, image.DirImageOpts{})
+		folder2 := extract(t, storeDir, img, image.DirImageOpts{PreservePermissions: true})
+
+		assert.False(t, sameFile(t, filepath.Join(folder1, "private"), filepath.Join(folder2, "private")))
+		info, err := os.Stat(filepath.Join(folder2, "private"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		info, err = os.Stat(filepath.Join(folder1, "private"))
+		require.NoError(t, err)
+		assert.NotEqual(t, os.FileMode(0600), info.Mode().Perm(), "the mode of the other extracted files does not change")
+	})
+
+	t.Run("it writes the content of the image when the file of the store was changed", func(t *testing.T) {
+		storeDir := t.TempDir()
+		folder1 := extract(t, storeDir, app1, image.DirImageOpts{})
+		// same size, different content
+		require.NoError(t, os.WriteFile(filepath.Join(folder1, "base", "lib.so"), []byte("shared librarx"), 0644))
+
+		folder2 := extract(t, storeDir, app2, image.DirImageOpts{})
+
+		assert.False(t, sameFile(t, filepath.Join(folder1, "base", "lib.so"), filepath.Join(folder2, "base", "lib.so")))
+		assertContent(t, filepath.Join(folder2, "base", "lib.so"), "shared library")
+		assert.True(t, sameFile(t, filepath.Join(folder1, "base", "config.yml"), filepath.Join(folder2, "base", "config.yml")))
+	})
+
+	t.Run("it records the checksums of the linked files", func(t *testing.T) {
+		storeDir := t.TempDir()
+		extract(t, storeDir, app1, image.DirImageOpts{})
+		checksumsPath := filepath.Join(t.TempDir(), "checksums.txt")
+		extract(t, storeDir, app2, image.DirImageOpts{ChecksumsPath: checksumsPath})
+
+		checksums, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte("shared library"))
+		assert.Contains(t, string(checksums), hex.EncodeToString(sum[:])+"  base/lib.so")
+	})
+}
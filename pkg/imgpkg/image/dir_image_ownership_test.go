@@ -0,0 +1,94 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image_test
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirImageOwnership(t *testing.T) {
+	ownedEntry := func(name string, typeflag byte, uid, gid int) tarEntry {
+		return tarEntry{header: tar.Header{Name: name, Typeflag: typeflag, Mode: 0755, Uid: uid, Gid: gid}}
+	}
+	img := imageFromLayers(t, []tarEntry{
+		ownedEntry("bin", tar.TypeDir, 0, 0),
+		ownedEntry("bin/tool", tar.TypeReg, 0, 10),
+		ownedEntry("data", tar.TypeReg, 1000, 1000),
+	})
+	assertOwner := func(t *testing.T, path string, uid, gid int) {
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(uid), stat.Uid, "uid of %s", path)
+		assert.Equal(t, uint32(gid), stat.Gid, "gid of %s", path)
+	}
+	requireRoot := func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("changing the owner to other users requires root")
+		}
+	}
+
+	t.Run("it maps the ids of the image to the ids in the host", func(t *testing.T) {
+		requireRoot(t)
+		folder := t.TempDir()
+		opts := image.DirImageOpts{Ownership: image.OwnershipOpts{
+			UIDMaps: []image.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			GIDMaps: []image.IDMap{{ContainerID: 0, HostID: 200000, Size: 100}, {ContainerID: 1000, HostID: 300000, Size: 1}},
+		}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertOwner(t, filepath.Join(folder, "bin"), 100000, 200000)
+		assertOwner(t, filepath.Join(folder, "bin", "tool"), 100000, 200010)
+		assertOwner(t, filepath.Join(folder, "data"), 101000, 300000)
+	})
+
+	t.Run("it sets the same owner to every entry", func(t *testing.T) {
+		requireRoot(t)
+		folder := t.TempDir()
+		opts := image.DirImageOpts{Ownership: image.OwnershipOpts{Chown: true, UID: 1234, GID: 5678}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		for _, path := range []string{"bin", "bin/tool", "data"} {
+			assertOwner(t, filepath.Join(folder, path), 1234, 5678)
+		}
+	})
+
+	t.Run("it uses the invoking user ids for the ids that are not mapped", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{Ownership: image.OwnershipOpts{
+			UIDMaps: []image.IDMap{{ContainerID: 5000, HostID: 100000, Size: 10}},
+			GIDMaps: []image.IDMap{{ContainerID: 5000, HostID: 100000, Size: 10}},
+		}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertOwner(t, filepath.Join(folder, "data"), os.Getuid(), os.Getgid())
+	})
+
+	t.Run("when the mappings are strict, it fails for the ids that are not mapped", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{Ownership: image.OwnershipOpts{
+			UIDMaps:      []image.IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			StrictIDMaps: true,
+		}}
+
+		dataImg := imageFromLayers(t, []tarEntry{ownedEntry("data", tar.TypeReg, 1000, 1000)})
+
+		err := image.NewDirImageWithOpts(folder, dataImg, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Entry 'data' uid 1000 is not in any of the provided uid mappings")
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirImageSparseFiles(t *testing.T) {
+	t.Run("it extracts GNU sparse files keeping the holes", func(t *testing.T) {
+		const size = 1000 * 1000 * 1000
+		img := imageFromTar(t, gnuSparseTar(t, "sparse.img", size, []sparseChunk{
+			{offset: 0, content: "start of the file"},
+			{offset: size - 512, content: "end of the file"},
+		}))
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		path := filepath.Join(folder, "sparse.img")
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, int64(size), info.Size())
+
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		start := make([]byte, 17)
+		_, err = file.ReadAt(start, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "start of the file", string(start))
+		end := make([]byte, 15)
+		_, err = file.ReadAt(end, size-512)
+		require.NoError(t, err)
+		assert.Equal(t, "end of the file", string(end))
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			assert.Less(t, stat.Blocks*512, int64(1024*1024), "the holes of the file do not use disk space")
+		}
+
+		entries, err := image.NewDirImage(folder, img, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, int64(size), entries[0].Size)
+	})
+}
+
+func TestDirImageMemoryUsage(t *testing.T) {
+	t.Run("it streams large files through a buffer of fixed size", func(t *testing.T) {
+		const size = 4 * 1024 * 1024 * 1024
+		img := imageFromTar(t, gnuSparseTar(t, "large.img", size, []sparseChunk{
+			{offset: 0, content: "start of the file"},
+			{offset: size - 512, content: "end of the file"},
+		}))
+		folder := t.TempDir()
+		opts := image.DirImageOpts{Limits: image.ExtractLimits{MaxSize: -1}, SkipSpaceCheck: true}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+		runtime.ReadMemStats(&after)
+
+		info, err := os.Stat(filepath.Join(folder, "large.img"))
+		require.NoError(t, err)
+		require.Equal(t, int64(size), info.Size())
+
+		const ceiling = 64 * 1024 * 1024
+		assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(ceiling), "the memory allocated does not grow with the size of the file")
+		assert.Less(t, after.HeapInuse, uint64(ceiling+before.HeapInuse))
+	})
+}
+
+// sparseChunk content of a sparse file that is not a hole
+type sparseChunk struct {
+	offset  int64
+	content string
+}
+
+// gnuSparseTar creates a tar with a single file in the old GNU sparse format, which is not supported by tar.Writer.
+// Each chunk is stored in a 512 bytes block and the rest of the file is a hole
+func gnuSparseTar(t *testing.T, name string, size int64, chunks []sparseChunk) []byte {
+	require.LessOrEqual(t, len(chunks), 4, "only the sparse map in the header is supported")
+	header := make([]byte, 512)
+	putOctal := func(field []byte, value int64) {
+		copy(field, fmt.Sprintf("%0*o", len(field)-1, value))
+	}
+	copy(header[0:100], name)
+	putOctal(header[100:108], 0644)
+	putOctal(header[108:116], 0)
+	putOctal(header[116:124], 0)
+	putOctal(header[124:136], int64(len(chunks)*512))
+	putOctal(header[136:148], 0)
+	header[156] = tar.TypeGNUSparse
+	copy(header[257:265], "ustar  \x00")
+	for idx, chunk := range chunks {
+		entry := header[386+idx*24 : 386+(idx+1)*24]
+		putOctal(entry[0:12], chunk.offset)
+		putOctal(entry[12:24], 512)
+	}
+	putOctal(header[483:495], size)
+
+	copy(header[148:156], "        ")
+	checksum := 0
+	for _, b := range header {
+		checksum += int(b)
+	}
+	copy(header[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+
+	buf := bytes.NewBuffer(header)
+	for _, chunk := range chunks {
+		block := make([]byte, 512)
+		copy(block, chunk.content)
+		buf.Write(block)
+	}
+	// end of archive
+	buf.Write(make([]byte, 1024))
+	return buf.Bytes()
+}
+
+// imageFromTar creates an image in memory with a single layer with the provided tar content
+func imageFromTar(t *testing.T, content []byte) regv1.Image {
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
@@ -0,0 +1,330 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// AsTar writes the merged view of the layers, after whiteouts are resolved, as a single tar stream, without
+// writing anything to disk. The entries are the ones AsDirectory would extract, except that symlinks and devices
+// are always kept since they are not created on disk
+//
+// The layers are walked from the newest to the oldest to decide which entries are part of the merged view, and then
+// read again from the oldest to the newest to write them, so that hardlinks come after their targets. Hardlinks whose
+// target is replaced or whited out by a newer layer are written as regular files with the content the target has in
+// their layer, as AsDirectory extracts them. The compressed layers are kept in DirImageOpts.SpoolDir in between, so
+// that they are only downloaded once
+func (i *DirImage) AsTar(out io.Writer) error {
+	err := i.checkExtractable()
+	if err != nil {
+		return err
+	}
+
+	spoolDir, err := os.MkdirTemp(i.opts.SpoolDir, "imgpkg-layers-")
+	if err != nil {
+		return fmt.Errorf("Creating layers spool directory: %s", err)
+	}
+	stopCleanup := i.removeOnInterrupt(spoolDir)
+	defer func() {
+		stopCleanup()
+		_ = os.RemoveAll(spoolDir)
+	}()
+
+	entries, err := i.mergedEntries(true, spoolDir)
+	if err != nil {
+		return err
+	}
+
+	layers, err := i.img.Layers()
+	if err != nil {
+		return err
+	}
+
+	output := &tarOutput{
+		writer:       tar.NewWriter(out),
+		entries:      entries,
+		written:      map[string]bool{},
+		linkTargets:  map[string]bool{},
+		targetCopies: map[string]targetCopy{},
+		spoolDir:     spoolDir,
+	}
+	for _, entry := range entries {
+		if entry.header.Typeflag == tar.TypeLink {
+			output.linkTargets[i.hydrateFilepath(entry.header.Linkname)] = true
+		}
+	}
+
+	for idx, imgLayer := range layers {
+		digest, err := imgLayer.Digest()
+		if err != nil {
+			return err
+		}
+
+		i.logger.Logf("Writing layer '%s' (%d/%d)\n", digest, idx+1, len(layers))
+
+		layer, err := partial.CompressedToLayer(&spooledLayer{layer: imgLayer, path: spoolPath(spoolDir, idx)})
+		if err != nil {
+			return err
+		}
+		layerStream, err := layer.Uncompressed()
+		if err != nil {
+			return err
+		}
+
+		err = i.writeTarLayer(output, digest.String(), &contextReader{ctx: i.ctx(), reader: layerStream})
+		_ = layerStream.Close()
+		if err != nil {
+			return err
+		}
+		// the layer is no longer needed, older layers were written before
+		_ = os.Remove(spoolPath(spoolDir, idx))
+	}
+
+	if output.skippedLinks > 0 {
+		i.logger.Logf("Warning: Skipped %d hardlink(s) whose target is not part of the image\n", output.skippedLinks)
+	}
+
+	return output.writer.Close()
+}
+
+// tarOutput merged view being written by AsTar
+type tarOutput struct {
+	writer  *tar.Writer
+	entries map[string]layerEntry
+	written map[string]bool
+	// linkTargets paths of the targets of the hardlinks of the merged view
+	linkTargets map[string]bool
+	// targetCopies content of the link targets, in the last layer written that has them, when that layer's entry is
+	// not part of the merged view, since a hardlink has the content its target has in the layer of the hardlink
+	targetCopies map[string]targetCopy
+	spoolDir     string
+	skippedLinks int
+}
+
+// targetCopy copy of the content of a link target in the spool directory, with its header
+type targetCopy struct {
+	header *tar.Header
+	path   string
+	size   int64
+}
+
+// writeTarLayer writes the entries of the layer that are part of the merged view. Directories are written the first
+// time the path is found as a directory, with the header from the layer that wins, so that they come before the
+// entries they contain. Hardlinks whose target is not written yet are written at the end of the layer, see
+// writeTarHardlinks
+func (i *DirImage) writeTarLayer(output *tarOutput, digest string, stream io.Reader) error {
+	tarReader := tar.NewReader(stream)
+	var links []tarHardlink
+
+	for index := 0; ; index++ {
+		hdr, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return i.writeTarHardlinks(output, links)
+			}
+			return err
+		}
+
+		if isMetadataEntry(hdr) {
+			continue
+		}
+		canonicalizeHeader(hdr)
+
+		path, err := i.entryPath(hdr)
+		if err != nil {
+			return err
+		}
+
+		entry, found := output.entries[path]
+		inMergedView := found && !output.written[path]
+		if inMergedView {
+			switch {
+			case entry.header.Typeflag == tar.TypeDir && hdr.Typeflag == tar.TypeDir:
+			case entry.LayerDigest == digest && entry.index == index:
+			default:
+				inMergedView = false
+			}
+		}
+		if !inMergedView {
+			if output.linkTargets[path] && hdr.FileInfo().Mode().IsRegular() {
+				err := output.copyTarget(path, hdr, tarReader)
+				if err != nil {
+					return fmt.Errorf("Keeping tar entry '%s': %s", hdr.Name, err)
+				}
+			}
+			continue
+		}
+
+		outHdr := *entry.header
+		outHdr.Name = entry.Path
+		if outHdr.Typeflag == tar.TypeDir {
+			outHdr.Name += "/"
+		}
+		if isSparse(&outHdr) {
+			// the content read has the holes filled with zeros, which is written as a regular file
+			outHdr.Typeflag = tar.TypeReg
+			outHdr.PAXRecords = withoutSparseRecords(outHdr.PAXRecords)
+		}
+
+		if outHdr.Typeflag == tar.TypeLink {
+			target := i.hydrateFilepath(outHdr.Linkname)
+			if !output.written[target] {
+				links = append(links, tarHardlink{path: path, entry: entry})
+				continue
+			}
+			outHdr.Linkname = i.relativeToDir(target)
+		}
+
+		err = output.writer.WriteHeader(&outHdr)
+		if err != nil {
+			return fmt.Errorf("Writing tar entry '%s': %s", outHdr.Name, err)
+		}
+
+		// only the entry that wins has its content written, directories found in other layers have none
+		if entry.LayerDigest == digest && entry.index == index {
+			_, err = i.copyEntry(output.writer, tarReader)
+			if err != nil {
+				return fmt.Errorf("Writing tar entry '%s': %s", outHdr.Name, err)
+			}
+		}
+		output.written[path] = true
+	}
+}
+
+// tarHardlink hardlink of the merged view found in the layer being written, at path
+type tarHardlink struct {
+	path  string
+	entry layerEntry
+}
+
+// writeTarHardlinks writes the hardlinks of a layer. A hardlink whose target is written links to it, since the target
+// written is the one of the layer of the hardlink or of an older layer, otherwise the hardlink is written as a regular
+// file with the content its target has in its layer. Hardlinks to other hardlinks wait for them to be written
+func (i *DirImage) writeTarHardlinks(output *tarOutput, links []tarHardlink) error {
+	for len(links) > 0 {
+		var stillPending []tarHardlink
+		for _, link := range links {
+			target := i.hydrateFilepath(link.entry.header.Linkname)
+			var err error
+			if output.written[target] {
+				outHdr := *link.entry.header
+				outHdr.Name = link.entry.Path
+				outHdr.Linkname = i.relativeToDir(target)
+				err = output.writer.WriteHeader(&outHdr)
+			} else if targetCopy, found := output.targetCopies[target]; found {
+				err = i.writeTargetCopy(output.writer, link.entry.Path, targetCopy)
+			} else {
+				stillPending = append(stillPending, link)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Writing tar entry '%s': %s", link.entry.Path, err)
+			}
+			output.written[link.path] = true
+		}
+
+		if len(stillPending) == len(links) {
+			output.skippedLinks += len(stillPending)
+			return nil
+		}
+		links = stillPending
+	}
+	return nil
+}
+
+// writeTargetCopy writes the copy of a link target as the regular file at name
+func (i *DirImage) writeTargetCopy(tarWriter *tar.Writer, name string, targetCopy targetCopy) error {
+	file, err := os.Open(targetCopy.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	outHdr := *targetCopy.header
+	outHdr.Name = name
+	outHdr.Typeflag = tar.TypeReg
+	outHdr.Size = targetCopy.size
+	outHdr.PAXRecords = withoutSparseRecords(outHdr.PAXRecords)
+	err = tarWriter.WriteHeader(&outHdr)
+	if err != nil {
+		return err
+	}
+	_, err = i.copyEntry(tarWriter, file)
+	return err
+}
+
+// copyTarget keeps the content of the link target at path found in the layer being written, replacing the copy
+// from an older layer
+func (o *tarOutput) copyTarget(path string, hdr *tar.Header, content io.Reader) error {
+	file, err := os.CreateTemp(o.spoolDir, "link-target-")
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if previous, found := o.targetCopies[path]; found {
+		_ = os.Remove(previous.path)
+	}
+	o.targetCopies[path] = targetCopy{header: hdr, path: file.Name(), size: size}
+	return nil
+}
+
+// spoolPath path of the spool file of the layer at position idx of the image
+func spoolPath(spoolDir string, idx int) string {
+	return filepath.Join(spoolDir, fmt.Sprintf("layer-%d", idx))
+}
+
+// spoolingLayer compressed layer whose content is written to a spool file while it is read, to be read again
+// from the file as a spooledLayer
+type spoolingLayer struct {
+	layer regv1.Layer
+	path  string
+}
+
+var _ partial.CompressedLayer = &spoolingLayer{}
+
+func (l *spoolingLayer) Digest() (regv1.Hash, error)         { return l.layer.Digest() }
+func (l *spoolingLayer) Size() (int64, error)                { return l.layer.Size() }
+func (l *spoolingLayer) MediaType() (types.MediaType, error) { return l.layer.MediaType() }
+
+func (l *spoolingLayer) Compressed() (io.ReadCloser, error) {
+	stream, err := l.layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(l.path)
+	if err != nil {
+		_ = stream.Close()
+		return nil, fmt.Errorf("Creating layer spool file: %s", err)
+	}
+	return &spoolingReader{Reader: io.TeeReader(stream, file), stream: stream, file: file}, nil
+}
+
+// spoolingReader reads the compressed content of a layer, writing it to the spool file
+type spoolingReader struct {
+	io.Reader
+	stream io.Closer
+	file   *os.File
+}
+
+func (r *spoolingReader) Close() error {
+	_ = r.stream.Close()
+	return r.file.Close()
+}
@@ -4,16 +4,29 @@
 package image_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -100,3 +113,2654 @@ func TestDirImage(t *testing.T) {
 		})
 	})
 }
+
+func TestDirImageDefaultPerms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not fully supported on windows")
+	}
+
+	// the modes expected before the umask of the process is removed, which does not apply to the mask
+	tests := []struct {
+		name    string
+		dir     bool
+		mode    os.FileMode
+		mirror  os.FileMode
+		strict  os.FileMode
+		mask027 os.FileMode
+	}{
+		{name: "private.txt", mode: 0600, mirror: 0666, strict: 0600, mask027: 0600},
+		{name: "shared.txt", mode: 0644, mirror: 0644, strict: 0644, mask027: 0640},
+		{name: "readonly.txt", mode: 0400, mirror: 0444, strict: 0400, mask027: 0400},
+		{name: "tool", mode: 0700, mirror: 0777, strict: 0700, mask027: 0700},
+		{name: "group-tool", mode: 0750, mirror: 0750, strict: 0750, mask027: 0750},
+		{name: "public-tool", mode: 0777, mirror: 0777, strict: 0777, mask027: 0750},
+		{name: "private-dir", dir: true, mode: 0700, mirror: 0777, strict: 0700, mask027: 0700},
+		{name: "group-dir", dir: true, mode: 0750, mirror: 0750, strict: 0750, mask027: 0750},
+		// parent directories that are not in the image
+		{name: "implicit", dir: true, mode: 0, mirror: 0777, strict: 0777, mask027: 0750},
+	}
+
+	var entries []tarEntry
+	for _, test := range tests {
+		switch {
+		case test.mode == 0:
+			entries = append(entries, fileEntry(test.name+"/file.txt", "file"))
+		case test.dir:
+			entries = append(entries, tarEntry{header: tar.Header{Name: test.name, Typeflag: tar.TypeDir, Mode: int64(test.mode)}})
+		default:
+			entries = append(entries, tarEntry{header: tar.Header{Name: test.name, Typeflag: tar.TypeReg, Mode: int64(test.mode), Size: 4}, content: "file"})
+		}
+	}
+	img := imageFromLayers(t, entries)
+
+	probe := filepath.Join(t.TempDir(), "probe")
+	require.NoError(t, os.Mkdir(probe, 0777))
+	info, err := os.Stat(probe)
+	require.NoError(t, err)
+	umask := 0777 &^ info.Mode().Perm()
+
+	mask027, err := image.ParseDefaultPerms("mask=027")
+	require.NoError(t, err)
+
+	for _, mode := range []struct {
+		defaultPerms image.DefaultPerms
+		expectedMode func(mirror, strict, mask027 os.FileMode) os.FileMode
+	}{
+		{image.DefaultPerms{}, func(mirror, _, _ os.FileMode) os.FileMode { return mirror &^ umask }},
+		{image.DefaultPerms{Mode: image.DefaultPermsMirror}, func(mirror, _, _ os.FileMode) os.FileMode { return mirror &^ umask }},
+		{image.DefaultPerms{Mode: image.DefaultPermsStrict}, func(_, strict, _ os.FileMode) os.FileMode { return strict &^ umask }},
+		{mask027, func(_, _, mask027 os.FileMode) os.FileMode { return mask027 }},
+	} {
+		t.Run(fmt.Sprintf("with default permissions '%s'", mode.defaultPerms.Mode), func(t *testing.T) {
+			folder := t.TempDir()
+			opts := image.DirImageOpts{DefaultPerms: mode.defaultPerms}
+			require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+			for _, test := range tests {
+				info, err := os.Stat(filepath.Join(folder, test.name))
+				require.NoError(t, err)
+				assert.Equal(t, test.dir, info.IsDir(), test.name)
+				assert.Equal(t, mode.expectedMode(test.mirror, test.strict, test.mask027), info.Mode().Perm(), test.name)
+			}
+		})
+	}
+}
+
+func TestParseDefaultPerms(t *testing.T) {
+	for value, expected := range map[string]image.DefaultPerms{
+		"mirror":   {Mode: image.DefaultPermsMirror},
+		"strict":   {Mode: image.DefaultPermsStrict},
+		"mask=022": {Mode: image.DefaultPermsMask, Mask: 0022},
+		"mask=7":   {Mode: image.DefaultPermsMask, Mask: 0007},
+	} {
+		defaultPerms, err := image.ParseDefaultPerms(value)
+		require.NoError(t, err)
+		assert.Equal(t, expected, defaultPerms)
+	}
+
+	_, err := image.ParseDefaultPerms("verbatim")
+	assert.EqualError(t, err, "Expected default permissions 'verbatim' to be one of mirror, strict or mask=VALUE")
+	for _, mask := range []string{"mask=", "mask=1000", "mask=089", "mask=-1"} {
+		_, err := image.ParseDefaultPerms(mask)
+		assert.ErrorContains(t, err, "to be an octal number between 000 and 777", mask)
+	}
+}
+
+func TestDirImageSymlinks(t *testing.T) {
+
+	t.Run("when --preserve-symlinks is not provided, it skips symlinks and reports them", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("config/nginx.conf", "conf"), symlinkEntry("nginx.conf", "config/nginx.conf")})
+		folder := t.TempDir()
+		output := bytes.NewBufferString("")
+
+		imgDir := image.NewDirImage(folder, img, util.NewBufferLogger(output))
+		require.NoError(t, imgDir.AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "nginx.conf"))
+		require.True(t, os.IsNotExist(err))
+		assert.Contains(t, output.String(), "Skipped 1 link(s) while extracting")
+	})
+
+	t.Run("when running on windows, symlinks are skipped", func(t *testing.T) {
+		if runtime.GOOS != "windows" {
+			t.Skip("only applies to windows")
+		}
+		img := imageFromLayers(t, []tarEntry{fileEntry("config/nginx.conf", "conf"), symlinkEntry("nginx.conf", "config/nginx.conf")})
+		folder := t.TempDir()
+		output := bytes.NewBufferString("")
+
+		imgDir := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{PreserveSymlinks: true}, util.NewBufferLogger(output))
+		require.NoError(t, imgDir.AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "nginx.conf"))
+		require.True(t, os.IsNotExist(err))
+		assert.Contains(t, output.String(), "Skipped 1 link(s) while extracting")
+	})
+
+	t.Run("when --preserve-symlinks is provided", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks are not recreated on windows")
+		}
+		opts := image.DirImageOpts{PreserveSymlinks: true}
+
+		t.Run("it recreates relative symlinks", func(t *testing.T) {
+			img := imageFromLayers(t, []tarEntry{
+				fileEntry("config/nginx.conf", "conf"),
+				symlinkEntry("nginx.conf", "config/nginx.conf"),
+				symlinkEntry("config/sub/up.conf", "../nginx.conf"),
+			})
+			folder := t.TempDir()
+
+			imgDir := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger())
+			require.NoError(t, imgDir.AsDirectory())
+
+			target, err := os.Readlink(filepath.Join(folder, "nginx.conf"))
+			require.NoError(t, err)
+			assert.Equal(t, "config/nginx.conf", target)
+			content, err := os.ReadFile(filepath.Join(folder, "config", "sub", "up.conf"))
+			require.NoError(t, err)
+			assert.Equal(t, "conf", string(content))
+		})
+
+		t.Run("it fails when symlink is absolute", func(t *testing.T) {
+			img := imageFromLayers(t, []tarEntry{symlinkEntry("passwd", "/etc/passwd")})
+			folder := t.TempDir()
+
+			imgDir := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger())
+			err := imgDir.AsDirectory()
+			require.ErrorContains(t, err, "Symlink 'passwd' has an absolute target '/etc/passwd'")
+		})
+
+		t.Run("it fails when symlink points outside of the output directory", func(t *testing.T) {
+			img := imageFromLayers(t, []tarEntry{symlinkEntry("config/escape", "../../outside")})
+			folder := t.TempDir()
+
+			imgDir := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger())
+			err := imgDir.AsDirectory()
+			require.ErrorContains(t, err, "Symlink 'config/escape' target '../../outside' is outside of the output directory")
+			_, err = os.Lstat(filepath.Join(folder, "config", "escape"))
+			require.True(t, os.IsNotExist(err))
+		})
+
+		t.Run("it fails when symlink points outside of the output directory through other symlinks", func(t *testing.T) {
+			img := imageFromLayers(t, []tarEntry{
+				{header: tar.Header{Name: "a", Typeflag: tar.TypeDir, Mode: 0755}},
+				symlinkEntry("a/x", ".."),
+				symlinkEntry("y", "a/x/.."),
+				fileEntry("y/pwned", "pwned"),
+			})
+			parent := t.TempDir()
+			folder := filepath.Join(parent, "output")
+
+			imgDir := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger())
+			err := imgDir.AsDirectory()
+			require.ErrorContains(t, err, "Symlink 'y' target 'a/x/..' is outside of the output directory")
+			_, err = os.Lstat(filepath.Join(parent, "pwned"))
+			require.True(t, os.IsNotExist(err))
+		})
+
+		t.Run("it fails when an entry is inside of a symlink", func(t *testing.T) {
+			img := imageFromLayers(t, []tarEntry{
+				{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+				symlinkEntry("link", "config"),
+				fileEntry("link/file.txt", "content"),
+			})
+			folder := t.TempDir()
+
+			imgDir := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger())
+			err := imgDir.AsDirectory()
+			require.ErrorContains(t, err, "Entry 'link/file.txt' is inside of the symlink 'link'")
+			_, err = os.Lstat(filepath.Join(folder, "config", "file.txt"))
+			require.True(t, os.IsNotExist(err))
+		})
+
+		t.Run("it fails when a whiteout is inside of a symlink", func(t *testing.T) {
+			img := imageFromLayers(t, []tarEntry{
+				{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+				fileEntry("config/keep.txt", "content"),
+				symlinkEntry("link", "config"),
+				fileEntry("link/.wh.keep.txt", ""),
+			})
+			folder := t.TempDir()
+			// extracting in place keeps the entries extracted before the failure
+			inPlaceOpts := opts
+			inPlaceOpts.NoClean = true
+
+			imgDir := image.NewDirImageWithOpts(folder, img, inPlaceOpts, util.NewNoopLogger())
+			err := imgDir.AsDirectory()
+			require.ErrorContains(t, err, "Entry 'link/.wh.keep.txt' is inside of the symlink 'link'")
+			_, err = os.Lstat(filepath.Join(folder, "config", "keep.txt"))
+			require.NoError(t, err)
+		})
+	})
+}
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+func fileEntry(name, content string) tarEntry {
+	return tarEntry{header: tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}, content: content}
+}
+
+func symlinkEntry(name, target string) tarEntry {
+	return tarEntry{header: tar.Header{Name: name, Linkname: target, Typeflag: tar.TypeSymlink, Mode: 0777}}
+}
+
+func hardlinkEntry(name, target string) tarEntry {
+	return tarEntry{header: tar.Header{Name: name, Linkname: target, Typeflag: tar.TypeLink, Mode: 0644}}
+}
+
+// imageFromLayers creates an image in memory where each of the provided lists of entries is a layer
+func imageFromLayers(t *testing.T, layers ...[]tarEntry) regv1.Image {
+	img := empty.Image
+	for _, entries := range layers {
+		buf := bytes.NewBuffer(nil)
+		tarWriter := tar.NewWriter(buf)
+		for _, entry := range entries {
+			hdr := entry.header
+			require.NoError(t, tarWriter.WriteHeader(&hdr))
+			_, err := tarWriter.Write([]byte(entry.content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tarWriter.Close())
+
+		layerBytes := buf.Bytes()
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(layerBytes)), nil
+		})
+		require.NoError(t, err)
+
+		img, err = mutate.AppendLayers(img, layer)
+		require.NoError(t, err)
+	}
+	return img
+}
+
+func TestDirImageHardlinks(t *testing.T) {
+	assertSameFile := func(t *testing.T, path1, path2 string) {
+		info1, err := os.Stat(path1)
+		require.NoError(t, err)
+		info2, err := os.Stat(path2)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(info1, info2), "expected %s and %s to be the same file", path1, path2)
+	}
+
+	t.Run("it creates hardlinks to files extracted before", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("bin/busybox", "binary"), hardlinkEntry("bin/ls", "bin/busybox"), hardlinkEntry("bin/cat", "bin/busybox")})
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assertSameFile(t, filepath.Join(folder, "bin", "busybox"), filepath.Join(folder, "bin", "ls"))
+		assertSameFile(t, filepath.Join(folder, "bin", "busybox"), filepath.Join(folder, "bin", "cat"))
+	})
+
+	t.Run("it creates hardlinks to files that show up later in the same layer", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{hardlinkEntry("bin/ls", "bin/busybox"), fileEntry("bin/busybox", "binary")})
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assertSameFile(t, filepath.Join(folder, "bin", "busybox"), filepath.Join(folder, "bin", "ls"))
+	})
+
+	t.Run("it creates hardlinks to files from older layers", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("bin/busybox", "binary")}, []tarEntry{hardlinkEntry("bin/ls", "bin/busybox")})
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		content, err := os.ReadFile(filepath.Join(folder, "bin", "ls"))
+		require.NoError(t, err)
+		assert.Equal(t, "binary", string(content))
+	})
+
+	t.Run("it links to the content the target has in the layer of the hardlink when a newer layer replaces it", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("bin/a", "old"), hardlinkEntry("bin/b", "bin/a"), hardlinkEntry("bin/c", "bin/a")},
+			[]tarEntry{fileEntry("bin/a", "new")})
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		for path, expected := range map[string]string{"bin/a": "new", "bin/b": "old", "bin/c": "old"} {
+			content, err := os.ReadFile(filepath.Join(folder, path))
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(content), path)
+		}
+		assertSameFile(t, filepath.Join(folder, "bin", "b"), filepath.Join(folder, "bin", "c"))
+
+		entries, err := os.ReadDir(folder)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "the copies of the replaced files are removed")
+	})
+
+	t.Run("it links to the content the target has in an older layer when a newer layer replaces it", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("bin/a", "old")},
+			[]tarEntry{hardlinkEntry("bin/b", "bin/a")},
+			[]tarEntry{fileEntry("bin/a", "new")})
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		content, err := os.ReadFile(filepath.Join(folder, "bin", "b"))
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+	})
+
+	t.Run("it links to the content the target has in the layer of the hardlink when a newer layer whites it out", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("bin/a", "old"), hardlinkEntry("bin/b", "bin/a")},
+			[]tarEntry{fileEntry("bin/.wh.a", "")})
+		folder := t.TempDir()
+		output := bytes.NewBufferString("")
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewBufferLogger(output)).AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "bin", "a"))
+		require.True(t, os.IsNotExist(err))
+		content, err := os.ReadFile(filepath.Join(folder, "bin", "b"))
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		assert.NotContains(t, output.String(), "Skipped")
+
+		entries, err := os.ReadDir(filepath.Join(folder, "bin"))
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "the copies of the whited out files are removed")
+		rootEntries, err := os.ReadDir(folder)
+		require.NoError(t, err)
+		require.Len(t, rootEntries, 1, "the copies of the whited out files are removed")
+	})
+
+	t.Run("it fails when the target is outside of the output directory", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{hardlinkEntry("passwd", "../../etc/passwd")})
+		folder := t.TempDir()
+
+		err := image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Hardlink 'passwd' target '../../etc/passwd' is outside of the output directory")
+	})
+
+	t.Run("it fails when the target does not exist in the image", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{hardlinkEntry("bin/ls", "bin/busybox")})
+		folder := t.TempDir()
+
+		err := image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Hardlink 'bin/ls' target 'bin/busybox' was not found in the image")
+	})
+
+	t.Run("it skips hardlinks to entries that are not extracted and reports them", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{
+			symlinkEntry("config.link", "config.txt"),
+			hardlinkEntry("config.hardlink", "config.link"),
+			hardlinkEntry("config.hardlink2", "config.hardlink"),
+			{header: tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644}},
+			hardlinkEntry("fifo.hardlink", "fifo"),
+			fileEntry("config.txt", "config"),
+		})
+		folder := t.TempDir()
+		output := bytes.NewBufferString("")
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewBufferLogger(output)).AsDirectory())
+
+		for _, path := range []string{"config.link", "config.hardlink", "config.hardlink2", "fifo.hardlink"} {
+			_, err := os.Lstat(filepath.Join(folder, path))
+			require.True(t, os.IsNotExist(err), "expected %s to not be extracted", path)
+		}
+		assert.Contains(t, output.String(), "Skipped 1 link(s) while extracting")
+		assert.Contains(t, output.String(), "Skipped 3 hardlink(s) whose target was not extracted")
+	})
+
+	t.Run("it fails when the target is outside of the output directory through symlinks", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks are not recreated on windows")
+		}
+		img := imageFromLayers(t, []tarEntry{
+			{header: tar.Header{Name: "a", Typeflag: tar.TypeDir, Mode: 0755}},
+			symlinkEntry("a/x", ".."),
+			hardlinkEntry("passwd", "a/x/../../etc/passwd"),
+		})
+		folder := t.TempDir()
+
+		imgDir := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{PreserveSymlinks: true}, util.NewNoopLogger())
+		err := imgDir.AsDirectory()
+		require.ErrorContains(t, err, "Hardlink 'passwd' target 'a/x/../../etc/passwd' is outside of the output directory")
+	})
+
+	t.Run("it links to the file a symlink inside of the output directory points to", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks are not recreated on windows")
+		}
+		img := imageFromLayers(t, []tarEntry{
+			fileEntry("config/app.conf", "conf"),
+			symlinkEntry("current", "config"),
+			hardlinkEntry("app.conf", "current/app.conf"),
+		})
+		folder := t.TempDir()
+
+		imgDir := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{PreserveSymlinks: true}, util.NewNoopLogger())
+		require.NoError(t, imgDir.AsDirectory())
+
+		assertSameFile(t, filepath.Join(folder, "config", "app.conf"), filepath.Join(folder, "app.conf"))
+	})
+}
+
+func TestDirImagePreservePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not fully supported on windows")
+	}
+
+	img := imageFromLayers(t, []tarEntry{
+		{header: tar.Header{Name: "secrets", Typeflag: tar.TypeDir, Mode: 0700}},
+		{header: tar.Header{Name: "secrets/key.pem", Typeflag: tar.TypeReg, Mode: 0600, Size: 3}, content: "key"},
+		{header: tar.Header{Name: "shared", Typeflag: tar.TypeDir, Mode: 0777 | 01000}},
+		{header: tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755 | 04000, Size: 4}, content: "tool"},
+		{header: tar.Header{Name: "readonly", Typeflag: tar.TypeDir, Mode: 0500}},
+		{header: tar.Header{Name: "readonly/file.txt", Typeflag: tar.TypeReg, Mode: 0400, Size: 4}, content: "text"},
+	})
+
+	t.Run("when preserving permissions and special bits, it applies the modes from the image verbatim", func(t *testing.T) {
+		folder := t.TempDir()
+		imgDir := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{PreservePermissions: true, PreserveSpecialBits: true}, util.NewNoopLogger())
+		require.NoError(t, imgDir.AsDirectory())
+		defer os.Chmod(filepath.Join(folder, "readonly"), 0700)
+
+		expectedModes := map[string]os.FileMode{
+			"secrets":           os.ModeDir | 0700,
+			"secrets/key.pem":   0600,
+			"shared":            os.ModeDir | os.ModeSticky | 0777,
+			"bin/tool":          os.ModeSetuid | 0755,
+			"readonly":          os.ModeDir | 0500,
+			"readonly/file.txt": 0400,
+		}
+		for path, expectedMode := range expectedModes {
+			info, err := os.Lstat(filepath.Join(folder, path))
+			require.NoError(t, err)
+			assert.Equal(t, expectedMode.String(), info.Mode().String(), fmt.Sprintf("validating file %s", path))
+		}
+	})
+
+	t.Run("when preserving permissions and special bits, it keeps the special bits of images pushed keeping permissions", func(t *testing.T) {
+		source := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(source, "tool"), []byte("tool"), 0755))
+		require.NoError(t, os.Chmod(filepath.Join(source, "tool"), os.ModeSetuid|0755))
+		require.NoError(t, os.Mkdir(filepath.Join(source, "shared"), 0777))
+		require.NoError(t, os.Chmod(filepath.Join(source, "shared"), os.ModeSticky|0777))
+
+		pushedImg, err := image.NewTarImage([]string{source}, nil, testLogger{}, image.TarImageOpts{KeepPermissions: true}).AsFileImage(nil)
+		require.NoError(t, err)
+
+		folder := t.TempDir()
+		imgDir := image.NewDirImageWithOpts(folder, pushedImg, image.DirImageOpts{PreservePermissions: true, PreserveSpecialBits: true}, util.NewNoopLogger())
+		require.NoError(t, imgDir.AsDirectory())
+
+		for _, path := range []string{"tool", "shared"} {
+			expectedInfo, err := os.Lstat(filepath.Join(source, path))
+			require.NoError(t, err)
+			info, err := os.Lstat(filepath.Join(folder, path))
+			require.NoError(t, err)
+			assert.Equal(t, expectedInfo.Mode().String(), info.Mode().String(), fmt.Sprintf("validating file %s", path))
+		}
+	})
+
+	t.Run("when not preserving permissions, it copies the user permissions to group and other", func(t *testing.T) {
+		folder := t.TempDir()
+		imgDir := image.NewDirImage(folder, img, util.NewNoopLogger())
+		require.NoError(t, imgDir.AsDirectory())
+
+		info, err := os.Lstat(filepath.Join(folder, "secrets", "key.pem"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0644).String(), info.Mode().String())
+	})
+}
+
+func TestDirImageSpecialBits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not fully supported on windows")
+	}
+
+	modes := map[string]int64{
+		"setuid":        0755 | 04000,
+		"setgid":        0750 | 02000,
+		"setuid-setgid": 0700 | 06000,
+		"none":          0755,
+	}
+	var entries []tarEntry
+	for name, mode := range modes {
+		entries = append(entries,
+			tarEntry{header: tar.Header{Name: "bin/" + name, Typeflag: tar.TypeReg, Mode: mode, Size: 4}, content: "tool"},
+			tarEntry{header: tar.Header{Name: "dirs/" + name, Typeflag: tar.TypeDir, Mode: mode}})
+	}
+	entries = append(entries, tarEntry{header: tar.Header{Name: "dirs/sticky", Typeflag: tar.TypeDir, Mode: 0777 | 01000}})
+	modes["sticky"] = 0777 | 01000
+	img := imageFromLayers(t, entries)
+	const specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+	for _, opts := range []image.DirImageOpts{
+		{},
+		{PreservePermissions: true},
+		{PreserveSpecialBits: true},
+		{PreservePermissions: true, PreserveSpecialBits: true},
+	} {
+		t.Run(fmt.Sprintf("with --preserve-permissions=%t and --preserve-special-bits=%t", opts.PreservePermissions, opts.PreserveSpecialBits), func(t *testing.T) {
+			folder := t.TempDir()
+			logs := bytes.NewBuffer(nil)
+			stats := &image.ExtractStats{}
+			opts.Stats = stats
+
+			require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewBufferLogger(logs)).AsDirectory())
+
+			for name, mode := range modes {
+				for _, path := range []string{"bin/" + name, "dirs/" + name} {
+					info, err := os.Lstat(filepath.Join(folder, path))
+					if os.IsNotExist(err) {
+						// the sticky bit is only set on a directory
+						continue
+					}
+					require.NoError(t, err)
+					imageMode := (&tar.Header{Mode: mode}).FileInfo().Mode()
+
+					if opts.PreserveSpecialBits {
+						assert.Equal(t, imageMode&specialBits, info.Mode()&specialBits, fmt.Sprintf("validating special bits of %s", path))
+					} else {
+						assert.Zero(t, info.Mode()&specialBits, fmt.Sprintf("validating special bits of %s", path))
+					}
+					if opts.PreservePermissions {
+						assert.Equal(t, imageMode.Perm(), info.Mode().Perm(), fmt.Sprintf("validating permissions of %s", path))
+					}
+				}
+			}
+
+			if opts.PreserveSpecialBits {
+				assert.Zero(t, stats.StrippedSpecialBits)
+				assert.NotContains(t, logs.String(), "Removed the setuid, setgid and sticky bits")
+			} else {
+				assert.Equal(t, 7, stats.StrippedSpecialBits)
+				assert.Contains(t, logs.String(), "Warning: Removed the setuid, setgid and sticky bits of 7 entries while extracting (hint: Use --preserve-special-bits to keep them)")
+			}
+		})
+	}
+}
+
+func TestDirImageDevices(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("devices are only created on linux and darwin")
+	}
+	img := imageFromLayers(t, []tarEntry{
+		{header: tar.Header{Name: "dev", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "dev/pipe", Typeflag: tar.TypeFifo, Mode: 0644}},
+		fileEntry("config.yml", "config"),
+	})
+	nullDevice := imageFromLayers(t, []tarEntry{
+		{header: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+	})
+
+	t.Run("without the option, it skips the devices and warns about them", func(t *testing.T) {
+		folder := t.TempDir()
+		logs := bytes.NewBuffer(nil)
+		stats := &image.ExtractStats{}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, image.DirImageOpts{Stats: stats}, util.NewBufferLogger(logs)).AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "dev", "pipe"))
+		require.True(t, os.IsNotExist(err))
+		assert.Equal(t, 1, stats.SkippedDevices)
+		assert.Contains(t, logs.String(), "Warning: Skipped 1 device(s) and named pipe(s) while extracting (hint: Use --allow-devices to create them)")
+	})
+
+	t.Run("it creates the named pipes", func(t *testing.T) {
+		folder := t.TempDir()
+		stats := &image.ExtractStats{}
+		opts := image.DirImageOpts{AllowDevices: true, Stats: stats}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		info, err := os.Lstat(filepath.Join(folder, "dev", "pipe"))
+		require.NoError(t, err)
+		assert.NotZero(t, info.Mode()&os.ModeNamedPipe)
+		assert.Equal(t, 1, stats.Devices)
+		assert.Equal(t, 0, stats.SkippedDevices)
+	})
+
+	t.Run("it lists the devices", func(t *testing.T) {
+		entries, err := image.NewDirImageWithOpts(t.TempDir(), img, image.DirImageOpts{AllowDevices: true}, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		assert.Equal(t, []string{"config.yml", "dev", "dev/pipe"}, paths)
+	})
+
+	t.Run("it creates character devices when privileged, and fails otherwise", func(t *testing.T) {
+		folder := t.TempDir()
+
+		err := image.NewDirImageWithOpts(folder, nullDevice, image.DirImageOpts{AllowDevices: true}, util.NewNoopLogger()).AsDirectory()
+		if os.Getuid() != 0 {
+			require.ErrorContains(t, err, "Creating device 'dev/null'")
+			return
+		}
+		require.NoError(t, err)
+
+		info, err := os.Lstat(filepath.Join(folder, "dev", "null"))
+		require.NoError(t, err)
+		assert.NotZero(t, info.Mode()&os.ModeCharDevice)
+	})
+}
+
+func TestDirImageDirectoryMetadata(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not fully supported on windows")
+	}
+
+	configTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	nestedTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	img := imageFromLayers(t, []tarEntry{
+		{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755, ModTime: configTime}},
+		{header: tar.Header{Name: "config/nested", Typeflag: tar.TypeDir, Mode: 0755, ModTime: nestedTime}},
+		fileEntry("config/nested/file.yml", "content"),
+		fileEntry("config/file.yml", "content"),
+		{header: tar.Header{Name: "empty", Typeflag: tar.TypeDir, Mode: 0755, ModTime: configTime}},
+		{header: tar.Header{Name: "readonly", Typeflag: tar.TypeDir, Mode: 0500, ModTime: configTime}},
+		{header: tar.Header{Name: "readonly/file.txt", Typeflag: tar.TypeReg, Mode: 0400, Size: 4}, content: "text"},
+	})
+
+	folder := t.TempDir()
+	imgDir := image.NewDirImage(folder, img, util.NewNoopLogger())
+	require.NoError(t, imgDir.AsDirectory())
+	defer os.Chmod(filepath.Join(folder, "readonly"), 0700)
+
+	t.Run("it applies the directory times after the directory contents are extracted", func(t *testing.T) {
+		expectedTimes := map[string]time.Time{
+			"config":        configTime,
+			"config/nested": nestedTime,
+			"empty":         configTime,
+			"readonly":      configTime,
+		}
+		for path, expectedTime := range expectedTimes {
+			info, err := os.Lstat(filepath.Join(folder, path))
+			require.NoError(t, err)
+			assert.True(t, info.IsDir(), fmt.Sprintf("validating directory %s", path))
+			assert.Equal(t, expectedTime, info.ModTime().UTC(), fmt.Sprintf("validating directory %s", path))
+		}
+	})
+
+	t.Run("it applies the normalized directory modes after the directory contents are extracted", func(t *testing.T) {
+		info, err := os.Lstat(filepath.Join(folder, "readonly"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0500), info.Mode().Perm()&0700)
+		assert.Equal(t, os.FileMode(0), info.Mode().Perm()&0222)
+
+		content, err := os.ReadFile(filepath.Join(folder, "readonly", "file.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "text", string(content))
+	})
+}
+
+func TestDirImageNoClean(t *testing.T) {
+	opts := image.DirImageOpts{NoClean: true}
+	prepareFolder := func(t *testing.T) string {
+		folder := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(folder, "other-image"), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "other-image", "file.txt"), []byte("other"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "config.yml"), []byte("old config"), 0600))
+		return folder
+	}
+
+	t.Run("it keeps the existing files and overwrites the files present in the image", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("config.yml", "new config"), fileEntry("new/file.txt", "new")})
+		folder := prepareFolder(t)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		for path, expectedContent := range map[string]string{"other-image/file.txt": "other", "config.yml": "new config", "new/file.txt": "new"} {
+			content, err := os.ReadFile(filepath.Join(folder, path))
+			require.NoError(t, err)
+			assert.Equal(t, expectedContent, string(content))
+		}
+	})
+
+	t.Run("it applies whiteouts to the existing files", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry(".wh.config.yml", "")})
+		folder := prepareFolder(t)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "config.yml"))
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Lstat(filepath.Join(folder, "other-image", "file.txt"))
+		require.NoError(t, err)
+	})
+
+	t.Run("it fails when an existing directory would be replaced by a file", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("other-image", "file")})
+		folder := prepareFolder(t)
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Cannot replace existing directory 'other-image' with a file from the image")
+		_, err = os.Lstat(filepath.Join(folder, "other-image", "file.txt"))
+		require.NoError(t, err)
+	})
+
+	t.Run("it fails when an existing file would be replaced by a directory", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{{header: tar.Header{Name: "config.yml", Typeflag: tar.TypeDir, Mode: 0755}}})
+		folder := prepareFolder(t)
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Cannot replace existing file 'config.yml' with a directory from the image")
+	})
+
+	t.Run("it keeps entries extracted from newer layers", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{{header: tar.Header{Name: "path", Typeflag: tar.TypeDir, Mode: 0755}}},
+			[]tarEntry{fileEntry("path", "file")},
+		)
+		folder := prepareFolder(t)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		content, err := os.ReadFile(filepath.Join(folder, "path"))
+		require.NoError(t, err)
+		assert.Equal(t, "file", string(content))
+	})
+
+	t.Run("without the option, it removes the existing content", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("config.yml", "new config")})
+		folder := prepareFolder(t)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "other-image"))
+		require.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestDirImageIncludePaths(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{
+		{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+		fileEntry("config/app.yml", "app"),
+		fileEntry("config/nested/db.yml", "db"),
+		fileEntry("config/nested/README.md", "readme"),
+		fileEntry("./docs/README.md", "docs"),
+		fileEntry("bin/tool", "tool"),
+	})
+	assertFiles := func(t *testing.T, folder string, expectedFiles []string) {
+		var files []string
+		err := filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expectedFiles, files)
+	}
+
+	t.Run("it extracts the directories that match", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{IncludePaths: []string{"config/"}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertFiles(t, folder, []string{"config/app.yml", "config/nested/db.yml", "config/nested/README.md"})
+	})
+
+	t.Run("it extracts the files that match globs with '**'", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{IncludePaths: []string{"**/*.yml", "docs/*.md"}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertFiles(t, folder, []string{"config/app.yml", "config/nested/db.yml", "docs/README.md"})
+	})
+
+	t.Run("it applies whiteouts on the included paths", func(t *testing.T) {
+		folder := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(folder, "config"), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "config", "old.yml"), []byte("old"), 0600))
+		imgWithWhiteout := imageFromLayers(t, []tarEntry{fileEntry("config/.wh.old.yml", ""), fileEntry("config/app.yml", "app")})
+		opts := image.DirImageOpts{IncludePaths: []string{"config/*.yml"}, NoClean: true}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, imgWithWhiteout, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertFiles(t, folder, []string{"config/app.yml"})
+	})
+
+	t.Run("it fails when a pattern does not match any entry", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{IncludePaths: []string{"config", "confg/**"}}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Include path 'confg/**' did not match any entry in the image")
+	})
+
+	t.Run("it fails when a pattern is invalid", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{IncludePaths: []string{"config/[a"}}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Invalid path pattern 'config/[a'")
+	})
+}
+
+func TestDirImageExcludedPaths(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{
+		fileEntry("config/app.yml", "older app"),
+		fileEntry(".git/HEAD", "ref"),
+	}, []tarEntry{
+		fileEntry("config/app.yml", "app"),
+		fileEntry("config/nested/db.yml", "db"),
+		fileEntry("./docs/README.md", "docs"),
+	})
+	extractedFiles := func(t *testing.T, folder string) []string {
+		var files []string
+		err := filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return files
+	}
+
+	t.Run("it does not extract the excluded files and the content of the excluded directories", func(t *testing.T) {
+		folder := t.TempDir()
+		stats := &image.ExtractStats{}
+		opts := image.DirImageOpts{ExcludedPaths: []string{".git", "./config/app.yml", "docs/"}, Stats: stats}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assert.ElementsMatch(t, []string{"config/nested/db.yml"}, extractedFiles(t, folder))
+		assert.Equal(t, 3, stats.Excluded, "the older config/app.yml is replaced by the newer one")
+	})
+
+	t.Run("it only matches the whole path", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{ExcludedPaths: []string{"config/nested/db", "conf"}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assert.ElementsMatch(t, []string{"config/app.yml", "config/nested/db.yml", "docs/README.md", ".git/HEAD"}, extractedFiles(t, folder))
+	})
+}
+
+func TestDirImageWhiteouts(t *testing.T) {
+	extractedFiles := func(t *testing.T, folder string) map[string]string {
+		files := map[string]string{}
+		err := filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				content, err := os.ReadFile(path)
+				require.NoError(t, err)
+				files[filepath.ToSlash(rel)] = string(content)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return files
+	}
+
+	t.Run("it only hides the whited out file and not files with the same name in other directories", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/config.yml", "a"), fileEntry("b/config.yml", "b"), fileEntry("config.yml", "root")},
+			[]tarEntry{fileEntry("a/.wh.config.yml", "")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"b/config.yml": "b", "config.yml": "root"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it hides the content of whited out directories", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/b/child.txt", "child"), fileEntry("a/b/nested/child.txt", "nested"), fileEntry("a/bb/child.txt", "other")},
+			[]tarEntry{fileEntry("a/.wh.b", "")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"a/bb/child.txt": "other"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it keeps files recreated by newer layers", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/b/child.txt", "old")},
+			[]tarEntry{fileEntry("a/.wh.b", "")},
+			[]tarEntry{fileEntry("a/b/child.txt", "new")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"a/b/child.txt": "new"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it ignores whiteouts inside of directories replaced by files in newer layers", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/b/child.txt", "child")},
+			[]tarEntry{fileEntry("a/b/.wh.child.txt", "")},
+			[]tarEntry{fileEntry("a/b", "file")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"a/b": "file"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it removes files whited out later in the same layer", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/config.yml", "older")},
+			[]tarEntry{fileEntry("a/config.yml", "newer"), fileEntry("a/.wh.config.yml", ""), fileEntry("a/other.yml", "other")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"a/other.yml": "other"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it keeps files recreated later in the same layer", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/config.yml", "older"), fileEntry("a/old.yml", "old")},
+			[]tarEntry{fileEntry("a/.wh.config.yml", ""), fileEntry("a/config.yml", "recreated"), fileEntry("a/config.yml", "last")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"a/config.yml": "last", "a/old.yml": "old"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it replaces directories with files later in the same layer", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/b/older.txt", "older")},
+			[]tarEntry{
+				{header: tar.Header{Name: "a/b", Typeflag: tar.TypeDir, Mode: 0755}},
+				fileEntry("a/b/child.txt", "child"),
+				fileEntry("a/b", "file"),
+			},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"a/b": "file"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it keeps the entries of newer layers over the ones of older layers", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("config.yml", "older"), fileEntry("a", "older file"), fileEntry("dir", "older file")},
+			[]tarEntry{fileEntry("config.yml", "newer"), fileEntry("a/child.txt", "child"), {header: tar.Header{Name: "dir", Typeflag: tar.TypeDir, Mode: 0755}}},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"config.yml": "newer", "a/child.txt": "child"}, extractedFiles(t, folder))
+		info, err := os.Lstat(filepath.Join(folder, "dir"))
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+	})
+
+	t.Run("it lists the last entry of a path within a layer", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/b/older.txt", "older"), fileEntry("config.yml", "older config")},
+			[]tarEntry{
+				fileEntry("config.yml", "config"),
+				fileEntry("a/b/child.txt", "child"),
+				fileEntry("a/b", "file"),
+				fileEntry("removed.yml", "removed"),
+				fileEntry(".wh.removed.yml", ""),
+			},
+		)
+
+		entries, err := image.NewDirImage(t.TempDir(), img, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+			if entry.Path == "config.yml" {
+				assert.Equal(t, int64(len("config")), entry.Size)
+			}
+		}
+		assert.Equal(t, []string{"a/b", "config.yml"}, paths)
+	})
+
+	t.Run("it does not list whited out files", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/config.yml", "a"), fileEntry("b/config.yml", "b")},
+			[]tarEntry{fileEntry("a/.wh.config.yml", "")},
+		)
+
+		entries, err := image.NewDirImage(t.TempDir(), img, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		require.Len(t, entries, 1)
+		assert.Equal(t, "b/config.yml", entries[0].Path)
+	})
+
+	t.Run("it fails when the whited out path cannot be removed", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Getuid() == 0 {
+			t.Skip("permissions do not prevent the removal")
+		}
+		folder := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(folder, "locked"), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "locked", "file.txt"), []byte("file"), 0600))
+		require.NoError(t, os.Chmod(filepath.Join(folder, "locked"), 0500))
+		t.Cleanup(func() { os.Chmod(filepath.Join(folder, "locked"), 0700) })
+		img := imageFromLayers(t, []tarEntry{fileEntry("locked/.wh.file.txt", "")})
+		opts := image.DirImageOpts{NoClean: true}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Removing whited out path 'locked/file.txt'")
+	})
+}
+
+func TestDirImageOpaqueWhiteouts(t *testing.T) {
+	img := imageFromLayers(t,
+		[]tarEntry{
+			{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+			fileEntry("config/app.yml", "app"),
+			fileEntry("config/db.yml", "db"),
+			fileEntry("config/nested/cache.yml", "cache"),
+			fileEntry("docs/README.md", "readme"),
+		},
+		[]tarEntry{
+			fileEntry("config/new.yml", "new"),
+			fileEntry("config/.wh..wh..opq", ""),
+			fileEntry("config/other.yml", "other"),
+		},
+	)
+	expectedFiles := []string{"config/new.yml", "config/other.yml", "docs/README.md"}
+
+	t.Run("it hides the content of the directory from older layers", func(t *testing.T) {
+		folder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		var files []string
+		err := filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expectedFiles, files)
+	})
+
+	t.Run("it does not list the content of the directory from older layers", func(t *testing.T) {
+		entries, err := image.NewDirImage(t.TempDir(), img, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		var files []string
+		for _, entry := range entries {
+			if !entry.Mode.IsDir() {
+				files = append(files, entry.Path)
+			}
+		}
+		assert.Equal(t, expectedFiles, files)
+	})
+}
+
+func TestDirImageEntryNames(t *testing.T) {
+	extractedFiles := func(t *testing.T, folder string) map[string]string {
+		files := map[string]string{}
+		err := filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				content, err := os.ReadFile(path)
+				require.NoError(t, err)
+				files[filepath.ToSlash(rel)] = string(content)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return files
+	}
+
+	t.Run("it applies whiteouts to entries named with a leading / or ./", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("/etc/passwd", "passwd"), fileEntry("./app/run.sh", "run"), fileEntry("app/config.yml", "config")},
+			[]tarEntry{fileEntry("etc/.wh.passwd", ""), fileEntry("/app/.wh.run.sh", ""), fileEntry("./app/new.sh", "new")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"app/config.yml": "config", "app/new.sh": "new"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it applies opaque whiteouts to entries named with a leading / or ./", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("/data/old.txt", "old"), fileEntry("data/other.txt", "other")},
+			[]tarEntry{fileEntry("./data/.wh..wh..opq", ""), fileEntry("/data/new.txt", "new")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"data/new.txt": "new"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it lists entries named with a leading / or ./ by their canonical path", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("/etc/hosts", "hosts"), fileEntry("./etc//resolv.conf", "resolv"), fileEntry("/etc/passwd", "passwd")},
+			[]tarEntry{fileEntry("./etc/.wh.passwd", "")},
+		)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		digest, err := layers[0].Digest()
+		require.NoError(t, err)
+
+		entries, err := image.NewDirImage(t.TempDir(), img, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		assert.Equal(t, []image.ListedEntry{
+			{Path: "etc/hosts", Size: 5, Mode: 0644, LayerDigest: digest.String()},
+			{Path: "etc/resolv.conf", Size: 6, Mode: 0644, LayerDigest: digest.String()},
+		}, entries)
+	})
+
+	t.Run("it matches include paths with entries named with a leading / or ./", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("/config/app.yml", "app"), fileEntry("./config/db.yml", "db"), fileEntry("/bin/tool", "tool")},
+		)
+		opts := image.DirImageOpts{IncludePaths: []string{"config/"}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"config/app.yml": "app", "config/db.yml": "db"}, extractedFiles(t, folder))
+	})
+
+	t.Run("it treats the root directory named / or ./ like .", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("permissions are not kept on windows")
+		}
+		modeOfOutput := func(t *testing.T, rootName string) os.FileMode {
+			folder := filepath.Join(t.TempDir(), "output")
+			img := imageFromLayers(t, []tarEntry{
+				{header: tar.Header{Name: rootName, Typeflag: tar.TypeDir, Mode: 0700}},
+				fileEntry("file.txt", "file"),
+			})
+
+			require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+			info, err := os.Stat(folder)
+			require.NoError(t, err)
+			return info.Mode().Perm()
+		}
+
+		expectedMode := modeOfOutput(t, ".")
+		assert.Equal(t, expectedMode, modeOfOutput(t, "./"))
+		assert.Equal(t, expectedMode, modeOfOutput(t, "/"))
+	})
+
+	t.Run("it resolves hardlink targets named with a leading / or ./", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("./bin/tool", "tool"), hardlinkEntry("/bin/alias", "/bin/tool"), hardlinkEntry("bin/other-alias", "./bin/tool")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, map[string]string{"bin/tool": "tool", "bin/alias": "tool", "bin/other-alias": "tool"}, extractedFiles(t, folder))
+	})
+}
+
+func TestDirImageEntries(t *testing.T) {
+	olderLayer := []tarEntry{
+		{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+		fileEntry("config/app.yml", "older app"),
+		fileEntry("bin/tool", "tool"),
+		symlinkEntry("config/link.yml", "app.yml"),
+	}
+	newerLayer := []tarEntry{
+		fileEntry("config/app.yml", "newer app"),
+		fileEntry("config/db.yml", "db"),
+		fileEntry("docs/README.md", "readme"),
+		fileEntry("docs/.wh.README.md", ""),
+	}
+	img := imageFromLayers(t, olderLayer, newerLayer)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	olderDigest, err := layers[0].Digest()
+	require.NoError(t, err)
+	newerDigest, err := layers[1].Digest()
+	require.NoError(t, err)
+
+	t.Run("it lists the entries that are extracted without writing to disk", func(t *testing.T) {
+		folder := filepath.Join(t.TempDir(), "output")
+
+		entries, err := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{}, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		assert.Equal(t, []image.ListedEntry{
+			{Path: "bin/tool", Size: 4, Mode: 0644, LayerDigest: olderDigest.String()},
+			{Path: "config", Mode: os.ModeDir | 0755, LayerDigest: olderDigest.String()},
+			{Path: "config/app.yml", Size: 9, Mode: 0644, LayerDigest: newerDigest.String()},
+			{Path: "config/db.yml", Size: 2, Mode: 0644, LayerDigest: newerDigest.String()},
+		}, entries)
+
+		_, err = os.Lstat(folder)
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("it lists the same files that are extracted", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{PreserveSymlinks: true}
+
+		entries, err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		var listedPaths, extractedPaths []string
+		for _, entry := range entries {
+			if !entry.Mode.IsDir() {
+				listedPaths = append(listedPaths, entry.Path)
+			}
+		}
+		err = filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				extractedPaths = append(extractedPaths, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, extractedPaths, listedPaths)
+	})
+
+	t.Run("it removes the entries inside of directories replaced or whited out in the same layer", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{
+			fileEntry("cache/a/b/c.txt", "c"),
+			fileEntry("cache/d.txt", "d"),
+			fileEntry("cache", "file"),
+			fileEntry("tmp/x/y.txt", "y"),
+			fileEntry("tmp/z.txt", "z"),
+			fileEntry(".wh.tmp", ""),
+		})
+
+		entries, err := image.NewDirImageWithOpts(t.TempDir(), img, image.DirImageOpts{}, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		assert.Equal(t, []string{"cache"}, paths)
+	})
+
+	t.Run("it only lists the entries that match the include paths", func(t *testing.T) {
+		opts := image.DirImageOpts{IncludePaths: []string{"config/*.yml"}}
+
+		entries, err := image.NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		assert.Equal(t, []string{"config/app.yml", "config/db.yml"}, paths)
+	})
+
+	t.Run("it fails when an include path does not match any entry", func(t *testing.T) {
+		opts := image.DirImageOpts{IncludePaths: []string{"missing/**"}}
+
+		_, err := image.NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger()).Entries()
+		require.ErrorContains(t, err, "Include path 'missing/**' did not match any entry in the image")
+	})
+}
+
+func TestDirImageWriteFile(t *testing.T) {
+	img := imageFromLayers(t,
+		[]tarEntry{
+			{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+			fileEntry("config/values.yml", "older values"),
+			fileEntry("config/removed.yml", "removed"),
+			fileEntry("bin/tool", "tool"),
+		},
+		[]tarEntry{
+			fileEntry("config/values.yml", "newer values"),
+			fileEntry("config/.wh.removed.yml", ""),
+			fileEntry("config/nested/db.yml", "db"),
+			hardlinkEntry("tool-link", "bin/tool"),
+			symlinkEntry("config/link.yml", "values.yml"),
+		},
+	)
+	writeFile := func(t *testing.T, name string) (string, error) {
+		out := bytes.NewBuffer(nil)
+		err := image.NewDirImage(string(filepath.Separator), img, util.NewNoopLogger()).WriteFile(name, out)
+		return out.String(), err
+	}
+
+	t.Run("it writes the content of the file from the newest layer that has it", func(t *testing.T) {
+		for _, name := range []string{"config/values.yml", "/config/values.yml", "./config/values.yml"} {
+			content, err := writeFile(t, name)
+			require.NoError(t, err)
+			assert.Equal(t, "newer values", content)
+		}
+	})
+
+	t.Run("it writes the content of files from older layers and of the targets of hardlinks", func(t *testing.T) {
+		content, err := writeFile(t, "bin/tool")
+		require.NoError(t, err)
+		assert.Equal(t, "tool", content)
+
+		content, err = writeFile(t, "tool-link")
+		require.NoError(t, err)
+		assert.Equal(t, "tool", content)
+	})
+
+	t.Run("it writes the content the target of a hardlink has in the layer of the hardlink when a newer layer replaces it", func(t *testing.T) {
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("bin/a", "old"), hardlinkEntry("bin/b", "bin/a")},
+			[]tarEntry{fileEntry("bin/a", "new")})
+		out := bytes.NewBuffer(nil)
+
+		require.NoError(t, image.NewDirImage(string(filepath.Separator), img, util.NewNoopLogger()).WriteFile("bin/b", out))
+		assert.Equal(t, "old", out.String())
+
+		folder := t.TempDir()
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+		extracted, err := os.ReadFile(filepath.Join(folder, "bin", "b"))
+		require.NoError(t, err)
+		assert.Equal(t, string(extracted), out.String())
+	})
+
+	t.Run("it lists the immediate children of directories", func(t *testing.T) {
+		content, err := writeFile(t, "config")
+		require.NoError(t, err)
+		assert.Equal(t, "link.yml\nnested/\nvalues.yml\n", content)
+
+		content, err = writeFile(t, "/")
+		require.NoError(t, err)
+		assert.Equal(t, "bin/\nconfig/\ntool-link\n", content)
+	})
+
+	t.Run("it fails with the close matches when the file is not in the image", func(t *testing.T) {
+		_, err := writeFile(t, "config/value.yml")
+		require.EqualError(t, err, "Expected file 'config/value.yml' to be in the image, close matches are:\n- config/values.yml")
+
+		_, err = writeFile(t, "config/removed.yml")
+		require.EqualError(t, err, "Expected file 'config/removed.yml' to be in the image")
+
+		_, err = writeFile(t, "other/db.yml")
+		var notFound image.FileNotFoundError
+		require.ErrorAs(t, err, &notFound)
+		assert.Equal(t, []string{"config/nested/db.yml"}, notFound.Matches)
+	})
+
+	t.Run("it fails when the path is a symlink", func(t *testing.T) {
+		_, err := writeFile(t, "config/link.yml")
+		require.EqualError(t, err, "Expected 'config/link.yml' to be a file, but it is a symlink to 'values.yml'")
+	})
+}
+
+func TestDirImageLimits(t *testing.T) {
+	manyEntries := []tarEntry{}
+	for idx := 0; idx < 100; idx++ {
+		manyEntries = append(manyEntries, fileEntry(fmt.Sprintf("dir/file-%d", idx), ""))
+	}
+	bigFile := fileEntry("big-file", strings.Repeat("0", 1024*1024))
+
+	t.Run("it fails and removes the output when there are too many entries", func(t *testing.T) {
+		folder := filepath.Join(t.TempDir(), "output")
+		img := imageFromLayers(t, manyEntries)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		digest, err := layers[0].Digest()
+		require.NoError(t, err)
+		opts := image.DirImageOpts{Limits: image.ExtractLimits{MaxEntries: 50}}
+
+		err = image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, fmt.Sprintf("Layer '%s' exceeds the maximum number of entries (50) at entry 'dir/file-50'", digest))
+
+		_, err = os.Lstat(folder)
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("it fails when a file is too big", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t, []tarEntry{fileEntry("small-file", "small"), bigFile})
+		opts := image.DirImageOpts{Limits: image.ExtractLimits{MaxFileSize: 1024}}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "entry 'big-file' size (1048576 bytes) exceeds the maximum file size (1024 bytes)")
+	})
+
+	t.Run("it fails when the layers extract to more than the maximum size", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t, []tarEntry{bigFile}, []tarEntry{fileEntry("other-file", strings.Repeat("1", 1024))})
+		opts := image.DirImageOpts{Limits: image.ExtractLimits{MaxSize: 1024 * 1024}}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "exceeds the maximum extract size (1048576 bytes) at entry 'big-file'")
+	})
+
+	t.Run("it only removes the extracted files when extracting on top of existing content", func(t *testing.T) {
+		folder := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "existing.txt"), []byte("existing"), 0600))
+		img := imageFromLayers(t, manyEntries)
+		opts := image.DirImageOpts{NoClean: true, Limits: image.ExtractLimits{MaxEntries: 50}}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "exceeds the maximum number of entries")
+
+		_, err = os.Lstat(filepath.Join(folder, "existing.txt"))
+		require.NoError(t, err)
+		files, err := os.ReadDir(filepath.Join(folder, "dir"))
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+
+	t.Run("by default it extracts images with compressible content", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t, manyEntries, []tarEntry{bigFile})
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+	})
+
+	t.Run("negative limits disable them", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t, manyEntries, []tarEntry{bigFile})
+		opts := image.DirImageOpts{Limits: image.ExtractLimits{MaxSize: -1, MaxEntries: -1}}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+	})
+}
+
+func TestDirImagePathTraversal(t *testing.T) {
+	assertNothingOutside := func(t *testing.T, parent string) {
+		files, err := os.ReadDir(parent)
+		require.NoError(t, err)
+		for _, file := range files {
+			assert.Equal(t, "output", file.Name())
+		}
+	}
+
+	maliciousEntries := map[string][]tarEntry{
+		"parent directory":                   {fileEntry("../evil.txt", "evil")},
+		"parent directory after a directory": {fileEntry("config/../../evil.txt", "evil")},
+		"windows parent directory":           {fileEntry("..\\evil.txt", "evil")},
+		"parent directory of a symlink":      {symlinkEntry("link", "."), fileEntry("link/../../evil.txt", "evil")},
+		"parent directory of a whiteout":     {fileEntry("../.wh.evil.txt", "")},
+		"parent directory of a hardlink":     {fileEntry("file.txt", "file"), hardlinkEntry("../evil.txt", "file.txt")},
+		"parent directory of a directory":    {{header: tar.Header{Name: "../evil", Typeflag: tar.TypeDir, Mode: 0755}}},
+	}
+	for name, entries := range maliciousEntries {
+		t.Run(fmt.Sprintf("it fails when an entry is in the %s", name), func(t *testing.T) {
+			parent := t.TempDir()
+			folder := filepath.Join(parent, "output")
+			opts := image.DirImageOpts{PreserveSymlinks: true}
+
+			err := image.NewDirImageWithOpts(folder, imageFromLayers(t, entries), opts, util.NewNoopLogger()).AsDirectory()
+			require.ErrorContains(t, err, "is outside of the output directory")
+
+			assertNothingOutside(t, parent)
+		})
+	}
+
+	t.Run("it does not write through a symlink of a newer layer pointing outside of the output directory", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks are not recreated on windows")
+		}
+		for _, target := range []string{"/tmp", "../outside"} {
+			parent := t.TempDir()
+			folder := filepath.Join(parent, "output")
+			require.NoError(t, os.MkdirAll(filepath.Join(parent, "outside"), 0700))
+			img := imageFromLayers(t, []tarEntry{fileEntry("evil/payload", "evil")}, []tarEntry{symlinkEntry("evil", target)})
+			opts := image.DirImageOpts{PreserveSymlinks: true}
+
+			err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+			require.Error(t, err)
+
+			require.NoFileExists(t, filepath.Join(parent, "outside", "payload"))
+			require.NoFileExists(t, "/tmp/payload")
+		}
+	})
+
+	t.Run("it does not write through an existing symlink pointing outside of the output directory", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("symlinks are not recreated on windows")
+		}
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		outside := filepath.Join(parent, "outside")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.MkdirAll(outside, 0700))
+		require.NoError(t, os.Symlink(outside, filepath.Join(folder, "evil")))
+		img := imageFromLayers(t, []tarEntry{fileEntry("evil/payload", "evil")})
+		opts := image.DirImageOpts{NoClean: true}
+
+		err := image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Entry 'evil/payload' is inside of the symlink 'evil'")
+
+		require.NoFileExists(t, filepath.Join(outside, "payload"))
+	})
+
+	t.Run("it extracts absolute paths inside of the output directory", func(t *testing.T) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		img := imageFromLayers(t, []tarEntry{fileEntry("/etc/file.txt", "file")})
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		content, err := os.ReadFile(filepath.Join(folder, "etc", "file.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "file", string(content))
+		assertNothingOutside(t, parent)
+	})
+
+	t.Run("it fails to list entries outside of the output directory", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("../evil.txt", "evil")})
+
+		_, err := image.NewDirImage(t.TempDir(), img, util.NewNoopLogger()).Entries()
+		require.ErrorContains(t, err, "Entry '../evil.txt' is outside of the output directory")
+	})
+}
+
+func TestDirImageProgress(t *testing.T) {
+	img := imageFromLayers(t,
+		[]tarEntry{fileEntry("first.txt", strings.Repeat("first layer content ", 1000))},
+		[]tarEntry{fileEntry("second.txt", strings.Repeat("second layer content ", 1000))},
+	)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+
+	reporter := &recordingProgressReporter{}
+	folder := t.TempDir()
+	imgDir := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{Progress: reporter}, util.NewNoopLogger())
+	require.NoError(t, imgDir.AsDirectory())
+
+	require.Len(t, reporter.lastUpdates, len(layers))
+	// layers are extracted from the newest to the oldest
+	for idx, update := range reporter.lastUpdates {
+		size, err := layers[len(layers)-1-idx].Size()
+		require.NoError(t, err)
+		assert.Equal(t, size, update.Total)
+		assert.Equal(t, size, update.Complete)
+	}
+
+	content, err := os.ReadFile(filepath.Join(folder, "second.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("second layer content ", 1000), string(content))
+}
+
+type recordingProgressReporter struct {
+	done        chan struct{}
+	last        regv1.Update
+	lastUpdates []regv1.Update
+}
+
+func (r *recordingProgressReporter) Start(_ context.Context, progress <-chan regv1.Update) {
+	r.done = make(chan struct{})
+	r.last = regv1.Update{}
+	go func() {
+		for {
+			select {
+			case <-r.done:
+				return
+			case update := <-progress:
+				r.last = update
+			}
+		}
+	}()
+}
+
+func (r *recordingProgressReporter) End() {
+	r.done <- struct{}{}
+	r.lastUpdates = append(r.lastUpdates, r.last)
+}
+
+func TestDirImageConcurrency(t *testing.T) {
+	var layers [][]tarEntry
+	for idx := 0; idx < 10; idx++ {
+		layers = append(layers, []tarEntry{
+			fileEntry(fmt.Sprintf("layer-%d.txt", idx), fmt.Sprintf("layer %d", idx)),
+			fileEntry("shared/.wh.removed.txt", ""),
+			fileEntry("shared/removed.txt", "removed"),
+		})
+	}
+	layers = append(layers, []tarEntry{fileEntry(".wh.layer-0.txt", "")})
+	img := imageFromLayers(t, layers...)
+	useTempDir := func(t *testing.T) string {
+		tmpDir := t.TempDir()
+		t.Setenv("TMPDIR", tmpDir)
+		t.Setenv("TMP", tmpDir)
+		return tmpDir
+	}
+
+	t.Run("it extracts the same content as extracting the layers one at a time, and removes the downloaded layers", func(t *testing.T) {
+		tmpDir := useTempDir(t)
+		sequentialFolder := t.TempDir()
+		concurrentFolder := t.TempDir()
+
+		require.NoError(t, image.NewDirImage(sequentialFolder, img, util.NewNoopLogger()).AsDirectory())
+		opts := image.DirImageOpts{Concurrency: 3}
+		require.NoError(t, image.NewDirImageWithOpts(concurrentFolder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		sequentialFiles, err := os.ReadDir(sequentialFolder)
+		require.NoError(t, err)
+		concurrentFiles, err := os.ReadDir(concurrentFolder)
+		require.NoError(t, err)
+		require.Equal(t, len(sequentialFiles), len(concurrentFiles))
+		for idx, file := range sequentialFiles {
+			assert.Equal(t, file.Name(), concurrentFiles[idx].Name())
+		}
+		_, err = os.Lstat(filepath.Join(concurrentFolder, "layer-0.txt"))
+		require.True(t, os.IsNotExist(err))
+
+		spooled, err := os.ReadDir(tmpDir)
+		require.NoError(t, err)
+		assert.Empty(t, spooled)
+	})
+
+	t.Run("it fails when a layer cannot be downloaded, and removes the downloaded layers", func(t *testing.T) {
+		tmpDir := useTempDir(t)
+		imgLayers, err := img.Layers()
+		require.NoError(t, err)
+		failingImg, err := mutate.AppendLayers(empty.Image, append(imgLayers[:5:5], failingLayer{imgLayers[5]})...)
+		require.NoError(t, err)
+		opts := image.DirImageOpts{Concurrency: 3}
+
+		err = image.NewDirImageWithOpts(t.TempDir(), failingImg, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Downloading layer")
+		require.ErrorContains(t, err, "connection reset")
+
+		spooled, err := os.ReadDir(tmpDir)
+		require.NoError(t, err)
+		assert.Empty(t, spooled)
+	})
+
+	t.Run("it keeps the downloaded layers in the directory being extracted instead of the temporary directory of the system", func(t *testing.T) {
+		t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "does-not-exist"))
+		t.Setenv("TMP", filepath.Join(t.TempDir(), "does-not-exist"))
+		outputFolder := filepath.Join(t.TempDir(), "output")
+
+		var lock sync.Mutex
+		var spoolDirs []string
+		imgLayers, err := img.Layers()
+		require.NoError(t, err)
+		var recordingLayers []regv1.Layer
+		for _, layer := range imgLayers {
+			recordingLayers = append(recordingLayers, downloadHookLayer{Layer: layer, onDownload: func() {
+				dirs, err := filepath.Glob(outputFolder + ".tmp-*/.imgpkg-layers-*")
+				require.NoError(t, err)
+				lock.Lock()
+				defer lock.Unlock()
+				spoolDirs = append(spoolDirs, dirs...)
+			}})
+		}
+		recordingImg, err := mutate.AppendLayers(empty.Image, recordingLayers...)
+		require.NoError(t, err)
+
+		opts := image.DirImageOpts{Concurrency: 3}
+		require.NoError(t, image.NewDirImageWithOpts(outputFolder, recordingImg, opts, util.NewNoopLogger()).AsDirectory())
+		assert.Len(t, spoolDirs, len(imgLayers))
+
+		leftOver, err := filepath.Glob(filepath.Join(filepath.Dir(outputFolder), "*", ".imgpkg-layers-*"))
+		require.NoError(t, err)
+		assert.Empty(t, leftOver)
+	})
+}
+
+// downloadHookLayer layer calling onDownload when its compressed content starts being downloaded
+type downloadHookLayer struct {
+	regv1.Layer
+	onDownload func()
+}
+
+func (l downloadHookLayer) Compressed() (io.ReadCloser, error) {
+	l.onDownload()
+	return l.Layer.Compressed()
+}
+
+// failingLayer layer whose content cannot be downloaded
+type failingLayer struct {
+	regv1.Layer
+}
+
+func (l failingLayer) Compressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("connection reset")
+}
+
+func (l failingLayer) Uncompressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("connection reset")
+}
+
+// flakyLayer layer whose uncompressed stream fails in the middle of the content, with err, the first failures times
+// it is read
+type flakyLayer struct {
+	regv1.Layer
+	failures *int
+	err      error
+}
+
+func (l flakyLayer) Uncompressed() (io.ReadCloser, error) {
+	stream, err := l.Layer.Uncompressed()
+	if err != nil || *l.failures == 0 {
+		return stream, err
+	}
+	*l.failures--
+	return &failingReader{ReadCloser: stream, remaining: 1500, err: l.err}, nil
+}
+
+// failingReader returns err once remaining bytes are read
+type failingReader struct {
+	io.ReadCloser
+	remaining int
+	err       error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, r.err
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+func TestDirImageLayerRetries(t *testing.T) {
+	img := imageFromLayers(t,
+		[]tarEntry{fileEntry("older.txt", "older")},
+		[]tarEntry{
+			hardlinkEntry("older-link.txt", "older.txt"),
+			fileEntry("newer.txt", "newer"),
+			fileEntry("large.txt", strings.Repeat("large", 1000)),
+		},
+	)
+	imgLayers, err := img.Layers()
+	require.NoError(t, err)
+
+	flakyImage := func(t *testing.T, failures int, err error) regv1.Image {
+		flakyImg, mutateErr := mutate.AppendLayers(empty.Image, imgLayers[0], flakyLayer{Layer: imgLayers[1], failures: &failures, err: err})
+		require.NoError(t, mutateErr)
+		return flakyImg
+	}
+
+	t.Run("it extracts the layer again after a transient error", func(t *testing.T) {
+		folder := t.TempDir()
+		logs := bytes.NewBuffer(nil)
+		opts := image.DirImageOpts{LayerRetries: 2, ChecksumsPath: filepath.Join(t.TempDir(), "SHA256SUMS")}
+
+		err := image.NewDirImageWithOpts(folder, flakyImage(t, 2, io.ErrUnexpectedEOF), opts, util.NewBufferLogger(logs)).AsDirectory()
+		require.NoError(t, err)
+
+		for name, expected := range map[string]string{
+			"older.txt":      "older",
+			"newer.txt":      "newer",
+			"large.txt":      strings.Repeat("large", 1000),
+			"older-link.txt": "older",
+		} {
+			content, err := os.ReadFile(filepath.Join(folder, name))
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(content))
+		}
+		assert.Contains(t, logs.String(), "(attempt 2/3) after error: unexpected EOF")
+		assert.Contains(t, logs.String(), "(attempt 3/3) after error: unexpected EOF")
+
+		checksums, err := os.ReadFile(opts.ChecksumsPath)
+		require.NoError(t, err)
+		assert.Len(t, strings.Split(strings.TrimSpace(string(checksums)), "\n"), 4)
+	})
+
+	t.Run("it fails once the retries are exhausted", func(t *testing.T) {
+		opts := image.DirImageOpts{LayerRetries: 1}
+		err := image.NewDirImageWithOpts(t.TempDir(), flakyImage(t, 2, io.ErrUnexpectedEOF), opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "unexpected EOF")
+	})
+
+	t.Run("it does not retry permanent errors", func(t *testing.T) {
+		logs := bytes.NewBuffer(nil)
+		opts := image.DirImageOpts{LayerRetries: 2}
+		err := image.NewDirImageWithOpts(t.TempDir(), flakyImage(t, 1, fmt.Errorf("digest mismatch")), opts, util.NewBufferLogger(logs)).AsDirectory()
+		require.ErrorContains(t, err, "digest mismatch")
+		assert.NotContains(t, logs.String(), "Retrying")
+	})
+}
+
+func TestDirImageAsTar(t *testing.T) {
+	olderLayer := []tarEntry{
+		{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}},
+		fileEntry("config/app.yml", "older app"),
+		fileEntry("config/removed.yml", "removed"),
+		fileEntry("bin/tool", "tool"),
+		hardlinkEntry("bin/tool-link", "bin/tool"),
+		symlinkEntry("config/link.yml", "app.yml"),
+	}
+	newerLayer := []tarEntry{
+		{header: tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0700}},
+		fileEntry("config/db.yml", "db"),
+		fileEntry("config/.wh.removed.yml", ""),
+		hardlinkEntry("config/removed-link.yml", "config/removed.yml"),
+	}
+	img := imageFromLayers(t, olderLayer, newerLayer)
+
+	readTar := func(t *testing.T, content []byte) ([]string, map[string]string) {
+		var names []string
+		contents := map[string]string{}
+		tarReader := tar.NewReader(bytes.NewReader(content))
+		for {
+			hdr, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, hdr.Name)
+			fileContent, err := io.ReadAll(tarReader)
+			require.NoError(t, err)
+			contents[hdr.Name] = string(fileContent)
+			if hdr.Typeflag == tar.TypeLink || hdr.Typeflag == tar.TypeSymlink {
+				contents[hdr.Name] = "-> " + hdr.Linkname
+			}
+		}
+		return names, contents
+	}
+
+	t.Run("it writes the merged view of the layers without writing to disk, with hardlinks after their targets", func(t *testing.T) {
+		folder := filepath.Join(t.TempDir(), "output")
+		out := bytes.NewBuffer(nil)
+		logs := bytes.NewBuffer(nil)
+
+		err := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{}, util.NewBufferLogger(logs)).AsTar(out)
+		require.NoError(t, err)
+
+		_, err = os.Lstat(folder)
+		require.True(t, os.IsNotExist(err))
+
+		names, contents := readTar(t, out.Bytes())
+		assert.Equal(t, []string{"config/", "config/app.yml", "bin/tool", "bin/tool-link", "config/link.yml", "config/db.yml", "config/removed-link.yml"}, names)
+		assert.Equal(t, "older app", contents["config/app.yml"])
+		assert.Equal(t, "db", contents["config/db.yml"])
+		assert.Equal(t, "tool", contents["bin/tool"])
+		assert.Equal(t, "-> bin/tool", contents["bin/tool-link"])
+		assert.Equal(t, "-> app.yml", contents["config/link.yml"])
+		assert.Equal(t, "removed", contents["config/removed-link.yml"], "the hardlink has the content of the whited out file")
+		assert.NotContains(t, logs.String(), "Skipped")
+
+		tarReader := tar.NewReader(bytes.NewReader(out.Bytes()))
+		hdr, err := tarReader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, int64(0700), hdr.Mode, "the directory uses the header of the newer layer")
+	})
+
+	t.Run("it writes the hardlinks with the content AsDirectory extracts", func(t *testing.T) {
+		testCases := map[string][][]tarEntry{
+			"hardlinks to files written before": {
+				{fileEntry("bin/busybox", "binary"), hardlinkEntry("bin/ls", "bin/busybox"), hardlinkEntry("bin/cat", "bin/ls")},
+			},
+			"hardlinks to files that show up later in the same layer": {
+				{hardlinkEntry("bin/ls", "bin/busybox"), fileEntry("bin/busybox", "binary")},
+			},
+			"hardlinks to files from older layers": {
+				{fileEntry("bin/busybox", "binary")},
+				{hardlinkEntry("bin/ls", "bin/busybox")},
+			},
+			"hardlinks whose target a newer layer replaces": {
+				{fileEntry("bin/a", "old"), hardlinkEntry("bin/b", "bin/a"), hardlinkEntry("bin/c", "bin/a")},
+				{fileEntry("bin/a", "new")},
+			},
+			"hardlinks whose target in an older layer a newer layer replaces": {
+				{fileEntry("bin/a", "old")},
+				{hardlinkEntry("bin/b", "bin/a")},
+				{fileEntry("bin/a", "new")},
+			},
+			"hardlinks whose target a newer layer whites out": {
+				{fileEntry("bin/a", "old"), hardlinkEntry("bin/b", "bin/a")},
+				{fileEntry("bin/.wh.a", "")},
+			},
+		}
+
+		for name, layers := range testCases {
+			t.Run(name, func(t *testing.T) {
+				img := imageFromLayers(t, layers...)
+				folder := t.TempDir()
+				require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+				extracted := map[string]string{}
+				require.NoError(t, filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+					if err != nil || entry.IsDir() {
+						return err
+					}
+					content, err := os.ReadFile(path)
+					relPath, _ := filepath.Rel(folder, path)
+					extracted[filepath.ToSlash(relPath)] = string(content)
+					return err
+				}))
+
+				out := bytes.NewBuffer(nil)
+				logs := bytes.NewBuffer(nil)
+				require.NoError(t, image.NewDirImage(t.TempDir(), img, util.NewBufferLogger(logs)).AsTar(out))
+
+				_, contents := readTar(t, out.Bytes())
+				written := map[string]string{}
+				for path, content := range contents {
+					if strings.HasSuffix(path, "/") {
+						continue
+					}
+					for strings.HasPrefix(content, "-> ") {
+						content = contents[strings.TrimPrefix(content, "-> ")]
+					}
+					written[path] = content
+				}
+				assert.Equal(t, extracted, written)
+				assert.NotContains(t, logs.String(), "Skipped")
+			})
+		}
+	})
+
+	t.Run("it skips hardlinks whose target is not part of the image and reports them", func(t *testing.T) {
+		img := imageFromLayers(t, []tarEntry{fileEntry("bin/busybox", "binary"), hardlinkEntry("bin/ls", "bin/missing")})
+		out := bytes.NewBuffer(nil)
+		logs := bytes.NewBuffer(nil)
+
+		require.NoError(t, image.NewDirImage(t.TempDir(), img, util.NewBufferLogger(logs)).AsTar(out))
+
+		names, _ := readTar(t, out.Bytes())
+		assert.Equal(t, []string{"bin/busybox"}, names)
+		assert.Contains(t, logs.String(), "Warning: Skipped 1 hardlink(s) whose target is not part of the image")
+	})
+
+	t.Run("it only writes the included paths", func(t *testing.T) {
+		out := bytes.NewBuffer(nil)
+
+		err := image.NewDirImageWithOpts(t.TempDir(), img, image.DirImageOpts{IncludePaths: []string{"bin/*"}}, util.NewNoopLogger()).AsTar(out)
+		require.NoError(t, err)
+
+		names, _ := readTar(t, out.Bytes())
+		assert.Equal(t, []string{"bin/tool", "bin/tool-link"}, names)
+	})
+
+	t.Run("it does not write the excluded entries", func(t *testing.T) {
+		out := bytes.NewBuffer(nil)
+
+		err := image.NewDirImageWithOpts(t.TempDir(), img, image.DirImageOpts{ExcludedPaths: []string{"config"}}, util.NewNoopLogger()).AsTar(out)
+		require.NoError(t, err)
+
+		names, _ := readTar(t, out.Bytes())
+		assert.Equal(t, []string{"bin/tool", "bin/tool-link"}, names)
+	})
+
+	t.Run("it reads each layer once, keeping them in the spool directory until they are written", func(t *testing.T) {
+		imgLayers, err := img.Layers()
+		require.NoError(t, err)
+		reads := map[int]int{}
+		var countedLayers []regv1.Layer
+		for idx, layer := range imgLayers {
+			countedLayers = append(countedLayers, countingLayer{Layer: layer, reads: reads, idx: idx})
+		}
+		countedImg, err := mutate.AppendLayers(empty.Image, countedLayers...)
+		require.NoError(t, err)
+		spoolDir := t.TempDir()
+		out := bytes.NewBuffer(nil)
+
+		err = image.NewDirImageWithOpts(t.TempDir(), countedImg, image.DirImageOpts{SpoolDir: spoolDir}, util.NewNoopLogger()).AsTar(out)
+		require.NoError(t, err)
+
+		names, _ := readTar(t, out.Bytes())
+		assert.Len(t, names, 7)
+		assert.Equal(t, map[int]int{0: 1, 1: 1}, reads)
+
+		spooled, err := os.ReadDir(spoolDir)
+		require.NoError(t, err)
+		assert.Empty(t, spooled)
+	})
+}
+
+// countingLayer layer that counts the times its content is read, in reads at idx
+type countingLayer struct {
+	regv1.Layer
+	reads map[int]int
+	idx   int
+}
+
+func (l countingLayer) Compressed() (io.ReadCloser, error) {
+	l.reads[l.idx]++
+	return l.Layer.Compressed()
+}
+
+func (l countingLayer) Uncompressed() (io.ReadCloser, error) {
+	l.reads[l.idx]++
+	return l.Layer.Uncompressed()
+}
+
+func TestDirImageIncremental(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{fileEntry("config.yml", "config")})
+	otherImg := imageFromLayers(t, []tarEntry{fileEntry("config.yml", "other config")})
+	opts := image.DirImageOpts{Incremental: true}
+
+	pullModified := func(t *testing.T) string {
+		folder := t.TempDir()
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+		require.FileExists(t, filepath.Join(folder, image.PullStateFile))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "config.yml"), []byte("modified"), 0600))
+		return folder
+	}
+	assertContent := func(t *testing.T, folder, expected string) {
+		content, err := os.ReadFile(filepath.Join(folder, "config.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	t.Run("it skips the extraction when the same image was pulled with the same options", func(t *testing.T) {
+		folder := pullModified(t)
+		logs := bytes.NewBuffer(nil)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewBufferLogger(logs)).AsDirectory())
+		assertContent(t, folder, "modified")
+		assert.Contains(t, logs.String(), "Skipped extraction, the output directory already contains image")
+	})
+
+	t.Run("it extracts when the image is different", func(t *testing.T) {
+		folder := pullModified(t)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, otherImg, opts, util.NewNoopLogger()).AsDirectory())
+		assertContent(t, folder, "other config")
+	})
+
+	t.Run("it extracts when the options are different", func(t *testing.T) {
+		folder := pullModified(t)
+
+		permissionsOpts := image.DirImageOpts{Incremental: true, PreservePermissions: true}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, permissionsOpts, util.NewNoopLogger()).AsDirectory())
+		assertContent(t, folder, "config")
+	})
+
+	t.Run("it extracts when forced", func(t *testing.T) {
+		folder := pullModified(t)
+
+		forcedOpts := image.DirImageOpts{Incremental: true, Force: true}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, forcedOpts, util.NewNoopLogger()).AsDirectory())
+		assertContent(t, folder, "config")
+		require.FileExists(t, filepath.Join(folder, image.PullStateFile))
+	})
+
+	t.Run("it removes the state when extracting on top of the directory without incremental", func(t *testing.T) {
+		folder := pullModified(t)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, otherImg, image.DirImageOpts{NoClean: true}, util.NewNoopLogger()).AsDirectory())
+		require.NoFileExists(t, filepath.Join(folder, image.PullStateFile))
+	})
+
+	t.Run("the state is not pushed", func(t *testing.T) {
+		folder := pullModified(t)
+
+		fileImg, err := image.NewTarImage([]string{folder}, nil, util.NewNoopLogger(), image.TarImageOpts{}).AsFileImage(nil)
+		require.NoError(t, err)
+		defer fileImg.Remove()
+
+		entries, err := image.NewDirImage(t.TempDir(), fileImg, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "config.yml", entries[0].Path)
+	})
+}
+
+func TestDirImageMetadata(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{fileEntry("config.yml", "config")})
+	manifest, err := img.RawManifest()
+	require.NoError(t, err)
+	config, err := img.RawConfigFile()
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	assertMetadata := func(t *testing.T, dir string) {
+		for name, expected := range map[string]string{
+			"manifest.json": string(manifest),
+			"config.json":   string(config),
+			"digest":        digest.String() + "\n",
+		} {
+			content, err := os.ReadFile(filepath.Join(dir, name))
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(content), fmt.Sprintf("validating file %s", name))
+		}
+	}
+
+	t.Run("it writes the manifest, config and digest in the output directory", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{IncludeMetadata: true}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertMetadata(t, filepath.Join(folder, image.MetadataDir))
+		require.FileExists(t, filepath.Join(folder, "config.yml"))
+	})
+
+	t.Run("it writes the metadata in the provided directory", func(t *testing.T) {
+		folder := t.TempDir()
+		metadataDir := filepath.Join(t.TempDir(), "metadata")
+		opts := image.DirImageOpts{IncludeMetadata: true, MetadataPath: metadataDir}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assertMetadata(t, metadataDir)
+		require.NoDirExists(t, filepath.Join(folder, image.MetadataDir))
+	})
+
+	t.Run("the metadata is not pushed", func(t *testing.T) {
+		folder := t.TempDir()
+		opts := image.DirImageOpts{IncludeMetadata: true}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		fileImg, err := image.NewTarImage([]string{folder}, nil, util.NewNoopLogger(), image.TarImageOpts{}).AsFileImage(nil)
+		require.NoError(t, err)
+		defer fileImg.Remove()
+
+		entries, err := image.NewDirImage(t.TempDir(), fileImg, util.NewNoopLogger()).Entries()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "config.yml", entries[0].Path)
+	})
+}
+
+func TestDirImageChecksums(t *testing.T) {
+	sha := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	img := imageFromLayers(t,
+		[]tarEntry{
+			fileEntry("b.txt", "b"),
+			{header: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+			fileEntry("dir/a.txt", "a"),
+			symlinkEntry("link", "b.txt"),
+			hardlinkEntry("hard", "b.txt"),
+		},
+		[]tarEntry{fileEntry("z.txt", "z")},
+	)
+
+	t.Run("it writes the checksums of the regular files sorted by path", func(t *testing.T) {
+		folder := t.TempDir()
+		checksumsPath := filepath.Join(t.TempDir(), "SHA256SUMS")
+		opts := image.DirImageOpts{ChecksumsPath: checksumsPath}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		content, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		expected := sha("b") + "  b.txt\n" +
+			sha("a") + "  dir/a.txt\n" +
+			sha("b") + "  hard\n" +
+			sha("z") + "  z.txt\n"
+		assert.Equal(t, expected, string(content))
+	})
+
+	t.Run("it skips the symlinks when they are preserved", func(t *testing.T) {
+		folder := t.TempDir()
+		checksumsPath := filepath.Join(t.TempDir(), "SHA256SUMS")
+		opts := image.DirImageOpts{ChecksumsPath: checksumsPath, PreserveSymlinks: true}
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		content, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "link")
+		assert.Contains(t, string(content), sha("b")+"  hard\n")
+	})
+}
+
+func TestDirImageAtomicExtraction(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{fileEntry("older.txt", "older")}, []tarEntry{fileEntry("newer.txt", "newer")})
+	imgLayers, err := img.Layers()
+	require.NoError(t, err)
+	failingImg, err := mutate.AppendLayers(empty.Image, imgLayers[0], failingLayer{imgLayers[1]})
+	require.NoError(t, err)
+
+	existingOutput := func(t *testing.T) (string, string) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "previous.txt"), []byte("previous"), 0600))
+		return parent, folder
+	}
+	assertOnlyOutput := func(t *testing.T, parent string) {
+		files, err := os.ReadDir(parent)
+		require.NoError(t, err)
+		require.Len(t, files, 1, "the temporary directory is removed")
+		assert.Equal(t, "output", files[0].Name())
+	}
+
+	t.Run("it replaces the previous content once the image is extracted", func(t *testing.T) {
+		parent, folder := existingOutput(t)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		require.NoFileExists(t, filepath.Join(folder, "previous.txt"))
+		require.FileExists(t, filepath.Join(folder, "older.txt"))
+		require.FileExists(t, filepath.Join(folder, "newer.txt"))
+		assertOnlyOutput(t, parent)
+	})
+
+	t.Run("it keeps the previous content when the extraction fails", func(t *testing.T) {
+		parent, folder := existingOutput(t)
+
+		err := image.NewDirImage(folder, failingImg, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "connection reset")
+
+		files, err := os.ReadDir(folder)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, "previous.txt", files[0].Name())
+		assertOnlyOutput(t, parent)
+	})
+
+	t.Run("it extracts on top of the previous content when not cleaning it", func(t *testing.T) {
+		parent, folder := existingOutput(t)
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, image.DirImageOpts{NoClean: true}, util.NewNoopLogger()).AsDirectory())
+
+		require.FileExists(t, filepath.Join(folder, "previous.txt"))
+		require.FileExists(t, filepath.Join(folder, "newer.txt"))
+		assertOnlyOutput(t, parent)
+	})
+}
+
+func TestDirImageCancellation(t *testing.T) {
+	img := imageFromLayers(t,
+		[]tarEntry{fileEntry("older.txt", "older")},
+		[]tarEntry{fileEntry("newer.txt", "newer"), fileEntry("large.txt", strings.Repeat("large", 1000))},
+	)
+	imgLayers, err := img.Layers()
+	require.NoError(t, err)
+
+	cancelingImage := func(t *testing.T) (regv1.Image, context.Context) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		cancelingImg, err := mutate.AppendLayers(empty.Image, imgLayers[0], cancelingLayer{Layer: imgLayers[1], cancel: cancel})
+		require.NoError(t, err)
+		return cancelingImg, ctx
+	}
+
+	t.Run("it stops and keeps the previous content when the context is canceled", func(t *testing.T) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "previous.txt"), []byte("previous"), 0600))
+		cancelingImg, ctx := cancelingImage(t)
+
+		err := image.NewDirImageWithOpts(folder, cancelingImg, image.DirImageOpts{Context: ctx}, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "context canceled")
+
+		files, err := os.ReadDir(folder)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, "previous.txt", files[0].Name())
+		files, err = os.ReadDir(parent)
+		require.NoError(t, err)
+		require.Len(t, files, 1, "the temporary directory is removed")
+	})
+
+	t.Run("it removes the file being written when extracting on top of existing content", func(t *testing.T) {
+		folder := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "existing.txt"), []byte("existing"), 0600))
+		cancelingImg, ctx := cancelingImage(t)
+		opts := image.DirImageOpts{Context: ctx, NoClean: true}
+
+		err := image.NewDirImageWithOpts(folder, cancelingImg, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "context canceled")
+
+		require.FileExists(t, filepath.Join(folder, "existing.txt"))
+		require.NoFileExists(t, filepath.Join(folder, "newer.txt"))
+		require.NoFileExists(t, filepath.Join(folder, "large.txt"))
+	})
+
+	t.Run("it stops downloading the layers ahead of the extraction once the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		opts := image.DirImageOpts{Context: ctx, Concurrency: 2}
+
+		err := image.NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "context canceled")
+	})
+
+	t.Run("it does not wait for the next retry once the context is canceled", func(t *testing.T) {
+		failures := 1
+		flakyImg, err := mutate.AppendLayers(empty.Image, imgLayers[0], flakyLayer{Layer: imgLayers[1], failures: &failures, err: io.ErrUnexpectedEOF})
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		opts := image.DirImageOpts{Context: ctx, LayerRetries: 1, LayerRetryBackoff: time.Hour}
+
+		start := time.Now()
+		err = image.NewDirImageWithOpts(t.TempDir(), flakyImg, opts, util.NewNoopLogger()).AsDirectory()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Minute)
+	})
+}
+
+// cancelingLayer layer that calls cancel in the middle of its uncompressed stream
+type cancelingLayer struct {
+	regv1.Layer
+	cancel context.CancelFunc
+}
+
+func (l cancelingLayer) Uncompressed() (io.ReadCloser, error) {
+	stream, err := l.Layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	return &cancelingReader{ReadCloser: stream, remaining: 1500, cancel: l.cancel}, nil
+}
+
+// cancelingReader calls cancel once remaining bytes are read
+type cancelingReader struct {
+	io.ReadCloser
+	remaining int
+	cancel    context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		r.cancel()
+	}
+	if len(p) > r.remaining && r.remaining > 0 {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+func TestDirImageStats(t *testing.T) {
+	img := imageFromLayers(t,
+		[]tarEntry{
+			{header: tar.Header{Name: "etc", Typeflag: tar.TypeDir, Mode: 0755}},
+			fileEntry("etc/config.yml", "config"),
+			fileEntry("etc/removed.yml", "removed"),
+			symlinkEntry("etc/link", "config.yml"),
+			{header: tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644}},
+		},
+		[]tarEntry{
+			fileEntry("etc/.wh.removed.yml", ""),
+			hardlinkEntry("config-link.yml", "etc/config.yml"),
+			fileEntry("app", "application"),
+		},
+	)
+	imgLayers, err := img.Layers()
+	require.NoError(t, err)
+	olderDigest, err := imgLayers[0].Digest()
+	require.NoError(t, err)
+	newerDigest, err := imgLayers[1].Digest()
+	require.NoError(t, err)
+
+	t.Run("it counts the entries written and skipped by each layer", func(t *testing.T) {
+		logs := bytes.NewBuffer(nil)
+		stats := &image.ExtractStats{}
+		opts := image.DirImageOpts{Stats: stats}
+
+		require.NoError(t, image.NewDirImageWithOpts(t.TempDir(), img, opts, util.NewBufferLogger(logs)).AsDirectory())
+
+		// the whited out file is not extracted
+		assert.Equal(t, image.EntryStats{
+			Files:           2,
+			Directories:     1,
+			Hardlinks:       1,
+			Bytes:           int64(len("config") + len("application")),
+			SkippedSymlinks: 1,
+			SkippedDevices:  1,
+			Whiteouts:       1,
+		}, stats.EntryStats)
+		assert.Equal(t, 1, stats.Images)
+
+		// the layers are extracted from the newest to the oldest
+		require.Len(t, stats.Layers, 2)
+		assert.Equal(t, newerDigest.String(), stats.Layers[0].Digest)
+		assert.Equal(t, image.EntryStats{Files: 1, Bytes: int64(len("application")), Whiteouts: 1}, stats.Layers[0].EntryStats)
+		assert.Equal(t, olderDigest.String(), stats.Layers[1].Digest)
+		// the hardlink is created once its target is extracted
+		assert.Equal(t, image.EntryStats{Files: 1, Directories: 1, Hardlinks: 1, Bytes: int64(len("config")), SkippedSymlinks: 1, SkippedDevices: 1}, stats.Layers[1].EntryStats)
+		imgDigest, err := img.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, imgDigest.String(), stats.Layers[1].ImageDigest)
+
+		assert.Contains(t, logs.String(), "Extracted 2 file(s) (17B), 1 directories, 0 symlink(s) and 1 hardlink(s) from 2 layer(s) in")
+		olderSize, err := imgLayers[0].Size()
+		require.NoError(t, err)
+		assert.Equal(t, olderSize, stats.Layers[1].CompressedSize)
+		assert.Contains(t, logs.String(), fmt.Sprintf("Layer '%s': %dB compressed, 1 file(s) (6B), 1 skipped symlink(s), 0 whiteout(s) in", olderDigest, olderSize))
+		assert.Regexp(t, `whiteout\(s\) in \S+ at \d+\.\d MB/s\n`, logs.String())
+
+		slowest, found := stats.SlowestLayer()
+		require.True(t, found)
+		assert.Contains(t, logs.String(), fmt.Sprintf("Slowest layer '%s' took", slowest.Digest))
+		assert.Contains(t, logs.String(), "Warning: Skipped 1 device(s) and named pipe(s) while extracting (hint: Use --allow-devices to create them)")
+	})
+
+	t.Run("it adds the statistics of every extracted image", func(t *testing.T) {
+		stats := &image.ExtractStats{}
+		opts := image.DirImageOpts{Stats: stats, PreserveSymlinks: true}
+
+		require.NoError(t, image.NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger()).AsDirectory())
+		require.NoError(t, image.NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, 2, stats.Images)
+		assert.Len(t, stats.Layers, 4)
+		assert.Equal(t, 4, stats.Files)
+		assert.Equal(t, 2, stats.Symlinks)
+		assert.Equal(t, 0, stats.SkippedSymlinks)
+	})
+
+	t.Run("it does not count the entries of a failed attempt at extracting a layer twice", func(t *testing.T) {
+		failures := 1
+		flakyImg, err := mutate.AppendLayers(empty.Image, imgLayers[0], flakyLayer{Layer: imgLayers[1], failures: &failures, err: io.ErrUnexpectedEOF})
+		require.NoError(t, err)
+		stats := &image.ExtractStats{}
+		opts := image.DirImageOpts{Stats: stats, LayerRetries: 1}
+
+		require.NoError(t, image.NewDirImageWithOpts(t.TempDir(), flakyImg, opts, util.NewNoopLogger()).AsDirectory())
+
+		assert.Equal(t, 2, stats.Files)
+		assert.Equal(t, 1, stats.Whiteouts)
+	})
+}
+
+func TestExtractStatsSlowestLayer(t *testing.T) {
+	stats := image.ExtractStats{Layers: []image.LayerStats{
+		{Digest: "sha256:fast", CompressedSize: 4 * 1000 * 1000, Duration: 2 * time.Second},
+		{Digest: "sha256:slow", CompressedSize: 30 * 1000 * 1000, Duration: 10 * time.Second},
+		{Digest: "sha256:empty"},
+	}}
+
+	slowest, found := stats.SlowestLayer()
+	require.True(t, found)
+	assert.Equal(t, "sha256:slow", slowest.Digest)
+	assert.Equal(t, 3.0, slowest.Throughput())
+	assert.Equal(t, 2.0, stats.Layers[0].Throughput())
+	assert.Equal(t, 0.0, stats.Layers[2].Throughput())
+
+	_, found = image.ExtractStats{}.SlowestLayer()
+	assert.False(t, found)
+}
+
+func TestDirImageTarFormats(t *testing.T) {
+	t.Run("it extracts entries with long paths and linknames, ignoring global PAX headers", func(t *testing.T) {
+		longDir := strings.Repeat("directory/", 29) + "config"
+		longPath := longDir + "/file.txt"
+		require.Len(t, longPath, 305)
+		img := imageFromLayers(t, []tarEntry{
+			{header: tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "built by a builder"}}},
+			fileEntry(longPath, "content"),
+			symlinkEntry("link.txt", longPath),
+		})
+		folder := t.TempDir()
+
+		err := image.NewDirImageWithOpts(folder, img, image.DirImageOpts{PreserveSymlinks: true}, util.NewNoopLogger()).AsDirectory()
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(folder, longPath))
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(content))
+		target, err := os.Readlink(filepath.Join(folder, "link.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, longPath, target)
+		require.NoFileExists(t, filepath.Join(folder, "pax_global_header"))
+	})
+}
+
+func TestDirImageLayerDirectories(t *testing.T) {
+	extractedFiles := func(t *testing.T, folder string) map[string]string {
+		files := map[string]string{}
+		err := filepath.Walk(folder, func(path string, info fs.FileInfo, err error) error {
+			require.NoError(t, err)
+			if !info.IsDir() {
+				rel, err := filepath.Rel(folder, path)
+				require.NoError(t, err)
+				content, err := os.ReadFile(path)
+				require.NoError(t, err)
+				files[filepath.ToSlash(rel)] = string(content)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return files
+	}
+	layerDirs := func(t *testing.T, img regv1.Image) []string {
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		var dirs []string
+		for idx, layer := range layers {
+			digest, err := layer.Digest()
+			require.NoError(t, err)
+			dirs = append(dirs, fmt.Sprintf("%d-%s", idx, digest.Hex[:12]))
+		}
+		return dirs
+	}
+
+	t.Run("it extracts each layer into its own directory keeping the whiteouts", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("a/config.yml", "old"), fileEntry("b/child.txt", "child")},
+			[]tarEntry{fileEntry("a/.wh.config.yml", ""), fileEntry("b/.wh..wh..opq", ""), fileEntry("a/new.yml", "new")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsLayerDirectories())
+
+		dirs := layerDirs(t, img)
+		assert.Equal(t, map[string]string{"a/config.yml": "old", "b/child.txt": "child"}, extractedFiles(t, filepath.Join(folder, dirs[0])))
+		assert.Equal(t, map[string]string{"a/.wh.config.yml": "", "b/.wh..wh..opq": "", "a/new.yml": "new"}, extractedFiles(t, filepath.Join(folder, dirs[1])))
+
+		content, err := os.ReadFile(filepath.Join(folder, image.LayersFile))
+		require.NoError(t, err)
+		var layersFile struct {
+			Image  string
+			Layers []struct {
+				Index     int
+				Digest    string
+				Directory string
+			}
+		}
+		require.NoError(t, json.Unmarshal(content, &layersFile))
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, digest.String(), layersFile.Image)
+		require.Len(t, layersFile.Layers, 2)
+		for idx, layer := range layersFile.Layers {
+			assert.Equal(t, idx, layer.Index)
+			assert.Equal(t, dirs[idx], layer.Directory)
+			assert.True(t, strings.HasPrefix(layer.Digest, "sha256:"+strings.TrimPrefix(dirs[idx], fmt.Sprintf("%d-", idx))))
+		}
+	})
+
+	t.Run("it removes the previous content of the directory", func(t *testing.T) {
+		folder := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "previous.txt"), []byte("previous"), 0600))
+		img := imageFromLayers(t, []tarEntry{fileEntry("file.txt", "file")})
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsLayerDirectories())
+
+		assert.NoFileExists(t, filepath.Join(folder, "previous.txt"))
+		assert.FileExists(t, filepath.Join(folder, layerDirs(t, img)[0], "file.txt"))
+	})
+
+	t.Run("it fails when an entry is outside of its layer directory", func(t *testing.T) {
+		folder := t.TempDir()
+		img := imageFromLayers(t, []tarEntry{fileEntry("../evil.txt", "evil")})
+
+		err := image.NewDirImage(folder, img, util.NewNoopLogger()).AsLayerDirectories()
+		require.ErrorContains(t, err, "Entry '../evil.txt' is outside of the output directory")
+		assert.NoFileExists(t, filepath.Join(folder, "evil.txt"))
+	})
+
+	t.Run("it skips hardlinks to files of other layers with a warning", func(t *testing.T) {
+		folder := t.TempDir()
+		logs := bytes.NewBuffer(nil)
+		img := imageFromLayers(t,
+			[]tarEntry{fileEntry("file.txt", "file")},
+			[]tarEntry{hardlinkEntry("link.txt", "file.txt"), fileEntry("other.txt", "other")},
+		)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewBufferLogger(logs)).AsLayerDirectories())
+
+		assert.Equal(t, map[string]string{"other.txt": "other"}, extractedFiles(t, filepath.Join(folder, layerDirs(t, img)[1])))
+		assert.Contains(t, logs.String(), "Skipped 1 hardlink(s) whose target is not in the layer, first one was 'link.txt'")
+	})
+}
+
+func TestDirImageExtractAnnotation(t *testing.T) {
+	annotatedImage := func(t *testing.T, value string) regv1.Image {
+		img := imageFromLayers(t, []tarEntry{fileEntry("config.yml", "config")})
+		return mutate.Annotations(img, map[string]string{image.ExtractAnnotation: value}).(regv1.Image)
+	}
+
+	t.Run("it refuses to extract images marked as not extractable", func(t *testing.T) {
+		img := annotatedImage(t, "false")
+		digest, err := img.Digest()
+		require.NoError(t, err)
+
+		folder := filepath.Join(t.TempDir(), "output")
+		err = image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory()
+		require.Error(t, err)
+		assert.Equal(t, fmt.Sprintf("Image '%s' is marked as not extractable by its annotation imgpkg.carvel.dev/extract: \"false\", "+
+			"its content is meant to be copied or relocated instead (hint: Use --force-extract to extract it anyway)", digest), err.Error())
+		assert.NoDirExists(t, folder)
+
+		err = image.NewDirImage(folder, img, util.NewNoopLogger()).AsTar(io.Discard)
+		assert.ErrorContains(t, err, "is marked as not extractable")
+		err = image.NewDirImage(string(filepath.Separator), img, util.NewNoopLogger()).WriteFile("config.yml", io.Discard)
+		assert.ErrorContains(t, err, "is marked as not extractable")
+		err = image.NewDirImage(folder, img, util.NewNoopLogger()).AsLayerDirectories()
+		assert.ErrorContains(t, err, "is marked as not extractable")
+		assert.NoDirExists(t, folder)
+	})
+
+	t.Run("it extracts images marked as not extractable with ForceExtract", func(t *testing.T) {
+		folder := t.TempDir()
+		err := image.NewDirImageWithOpts(folder, annotatedImage(t, "false"), image.DirImageOpts{ForceExtract: true}, util.NewNoopLogger()).AsDirectory()
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(folder, "config.yml"))
+	})
+
+	t.Run("it extracts images with other values of the annotation", func(t *testing.T) {
+		for _, value := range []string{"true", "", "yes"} {
+			folder := t.TempDir()
+			require.NoError(t, image.NewDirImage(folder, annotatedImage(t, value), util.NewNoopLogger()).AsDirectory())
+			assert.FileExists(t, filepath.Join(folder, "config.yml"))
+		}
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTarEntryParentInDir(t *testing.T) {
+	t.Run("it fails when the parent directory is replaced by a symlink outside of the output directory", func(t *testing.T) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		outside := filepath.Join(parent, "outside")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.MkdirAll(outside, 0700))
+		// simulates a symlink created after the entry parents were checked
+		require.NoError(t, os.Symlink(outside, filepath.Join(folder, "config")))
+		dirImage := &DirImage{dirPath: folder}
+		header := &tar.Header{Name: "config/evil.txt", Typeflag: tar.TypeReg, Mode: 0600, Size: 4}
+
+		err := dirImage.extractTarEntry(header, strings.NewReader("evil"))
+		require.ErrorContains(t, err, "Entry 'config/evil.txt' parent directory resolves to '"+outside+"', outside of the output directory")
+
+		_, err = os.Lstat(filepath.Join(outside, "evil.txt"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("it does not create the missing parent directories through a symlink outside of the output directory", func(t *testing.T) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		outside := filepath.Join(parent, "outside")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.MkdirAll(outside, 0700))
+		require.NoError(t, os.Symlink(outside, filepath.Join(folder, "config")))
+		dirImage := &DirImage{dirPath: folder}
+		header := &tar.Header{Name: "config/nested/evil.txt", Typeflag: tar.TypeReg, Mode: 0600, Size: 4}
+
+		err := dirImage.extractTarEntry(header, strings.NewReader("evil"))
+		require.ErrorContains(t, err, "Entry 'config/nested/evil.txt' parent directory resolves to '"+outside+"', outside of the output directory")
+
+		_, err = os.Lstat(filepath.Join(outside, "nested"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("it does not write a file through a symlink at its path", func(t *testing.T) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		outside := filepath.Join(parent, "outside.txt")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.WriteFile(outside, []byte("outside"), 0600))
+		// simulates a symlink created after the existing path was removed
+		require.NoError(t, os.Symlink(outside, filepath.Join(folder, "file.txt")))
+		dirImage := &DirImage{dirPath: folder}
+		header := &tar.Header{Name: "file.txt", Typeflag: tar.TypeReg, Mode: 0600, Size: 4}
+
+		err := dirImage.extractTarEntry(header, strings.NewReader("evil"))
+		require.ErrorContains(t, err, "Entry 'file.txt' is a symlink on disk, refusing to write through it")
+
+		content, err := os.ReadFile(outside)
+		require.NoError(t, err)
+		require.Equal(t, "outside", string(content))
+	})
+
+	t.Run("it does not use a symlink at the path of a directory", func(t *testing.T) {
+		parent := t.TempDir()
+		folder := filepath.Join(parent, "output")
+		outside := filepath.Join(parent, "outside")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.MkdirAll(outside, 0700))
+		require.NoError(t, os.Symlink(outside, filepath.Join(folder, "config")))
+		dirImage := &DirImage{dirPath: folder}
+		header := &tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0700}
+
+		err := dirImage.extractTarEntry(header, nil)
+		require.ErrorContains(t, err, "Entry 'config' is not a directory on disk")
+	})
+
+	t.Run("it extracts when the output directory is inside of a symlink", func(t *testing.T) {
+		parent := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(parent, "real", "output"), 0700))
+		require.NoError(t, os.Symlink(filepath.Join(parent, "real"), filepath.Join(parent, "link")))
+		dirImage := &DirImage{dirPath: filepath.Join(parent, "link", "output")}
+		header := &tar.Header{Name: "config/file.txt", Typeflag: tar.TypeReg, Mode: 0600, Size: 4}
+
+		require.NoError(t, dirImage.extractTarEntry(header, strings.NewReader("file")))
+
+		_, err := os.Lstat(filepath.Join(parent, "real", "output", "config", "file.txt"))
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package image_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestDirImagePreserveXattrs(t *testing.T) {
+	xattrEntry := func(name string, typeflag byte, xattrs map[string]string) tarEntry {
+		records := map[string]string{}
+		for key, value := range xattrs {
+			records["SCHILY.xattr."+key] = value
+		}
+		return tarEntry{header: tar.Header{Name: name, Typeflag: typeflag, Mode: 0755, Format: tar.FormatPAX, PAXRecords: records}}
+	}
+	img := imageFromLayers(t, []tarEntry{
+		xattrEntry("bin", tar.TypeDir, map[string]string{"user.imgpkg.dir": "dir value"}),
+		xattrEntry("bin/tool", tar.TypeReg, map[string]string{"user.imgpkg.first": "first value", "user.imgpkg.second": "second value"}),
+	})
+	getXattr := func(t *testing.T, path, name string) (string, error) {
+		value := make([]byte, 1024)
+		size, err := unix.Lgetxattr(path, name, value)
+		if err != nil {
+			return "", err
+		}
+		return string(value[:size]), nil
+	}
+	supportsXattrs := func(t *testing.T, folder string) {
+		probe := filepath.Join(folder, "probe")
+		require.NoError(t, os.WriteFile(probe, nil, 0600))
+		defer os.Remove(probe)
+		if err := unix.Lsetxattr(probe, "user.imgpkg.probe", []byte("probe"), 0); err != nil {
+			t.Skipf("filesystem does not support user extended attributes: %s", err)
+		}
+	}
+
+	t.Run("it restores the extended attributes from the PAX records", func(t *testing.T) {
+		folder := t.TempDir()
+		supportsXattrs(t, folder)
+		opts := image.DirImageOpts{PreserveXattrs: true}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, img, opts, util.NewNoopLogger()).AsDirectory())
+
+		for path, xattrs := range map[string]map[string]string{
+			"bin":      {"user.imgpkg.dir": "dir value"},
+			"bin/tool": {"user.imgpkg.first": "first value", "user.imgpkg.second": "second value"},
+		} {
+			for name, expectedValue := range xattrs {
+				value, err := getXattr(t, filepath.Join(folder, path), name)
+				require.NoError(t, err)
+				assert.Equal(t, expectedValue, value)
+			}
+		}
+	})
+
+	t.Run("without the option, extended attributes are not restored", func(t *testing.T) {
+		folder := t.TempDir()
+		supportsXattrs(t, folder)
+
+		require.NoError(t, image.NewDirImage(folder, img, util.NewNoopLogger()).AsDirectory())
+
+		_, err := getXattr(t, filepath.Join(folder, "bin", "tool"), "user.imgpkg.first")
+		require.True(t, errors.Is(err, unix.ENODATA))
+	})
+
+	t.Run("it skips the extended attributes that are not supported and reports them", func(t *testing.T) {
+		folder := t.TempDir()
+		unsupportedImg := imageFromLayers(t, []tarEntry{
+			xattrEntry("tool", tar.TypeReg, map[string]string{"unknown.imgpkg": "value"}),
+			xattrEntry("other-tool", tar.TypeReg, map[string]string{"unknown.imgpkg": "value"}),
+		})
+		output := bytes.NewBufferString("")
+		opts := image.DirImageOpts{PreserveXattrs: true}
+
+		require.NoError(t, image.NewDirImageWithOpts(folder, unsupportedImg, opts, util.NewBufferLogger(output)).AsDirectory())
+
+		_, err := os.Lstat(filepath.Join(folder, "tool"))
+		require.NoError(t, err)
+		assert.Contains(t, output.String(), "Warning: Skipped 2 extended attribute(s) not supported while extracting, first one was 'unknown.imgpkg' on 'tool'")
+	})
+}
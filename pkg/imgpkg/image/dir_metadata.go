@@ -0,0 +1,76 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// dirMetadata mode and times of a directory from the image, which are applied once all the layers are extracted,
+// so that restrictive modes do not prevent the creation of the directory contents and the creation of the
+// contents does not change the modification time
+type dirMetadata struct {
+	header      *tar.Header
+	mode        os.FileMode
+	layerDigest string
+}
+
+// recordDir keeps the metadata of the directory at path. The layers are extracted from the newest to the oldest, so
+// the first layer with the directory wins, while within that layer the last header found wins
+func (i *DirImage) recordDir(path string, header *tar.Header, mode os.FileMode) {
+	if dir, found := i.dirs[path]; found && dir.layerDigest != i.layerDigest {
+		return
+	}
+	i.dirs[path] = dirMetadata{header: header, mode: mode, layerDigest: i.layerDigest}
+}
+
+// applyDirMetadata sets the modes and times of the directories recorded during the extraction, starting with the
+// deepest directories, so that setting them on a directory does not change its parent
+func (i *DirImage) applyDirMetadata() error {
+	var paths []string
+	for path := range i.dirs {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(a, b int) bool { return len(paths[a]) > len(paths[b]) })
+
+	for _, dirPath := range paths {
+		path := longPath(dirPath)
+		// an older layer might have replaced the directory with another entry
+		info, err := os.Lstat(path)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		dir := i.dirs[dirPath]
+		err = os.Chmod(path, dir.mode)
+		if err != nil {
+			return err
+		}
+		err = lchtimes(dir.header, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentUmask finds the permissions removed from the files and directories created in dir, by creating a
+// directory and checking its mode, since the umask cannot be read without changing it for the whole process
+func currentUmask(dir string) (os.FileMode, error) {
+	probePath, err := createSiblingDir(filepath.Join(dir, ".imgpkg-umask-probe"))
+	if err != nil {
+		return 0, fmt.Errorf("Checking the permissions of new directories: %s", err)
+	}
+	defer os.Remove(probePath)
+
+	info, err := os.Stat(probePath)
+	if err != nil {
+		return 0, fmt.Errorf("Checking the permissions of new directories: %s", err)
+	}
+	return 0777 &^ info.Mode().Perm(), nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// errDiskSpaceUnknown returned on platforms where the available disk space cannot be read
+var errDiskSpaceUnknown = errors.New("available disk space is unknown")
+
+// availableDiskSpace returns the number of bytes available to the current user in the filesystem containing path
+var availableDiskSpace = diskSpace
+
+// checkDiskSpace fails when the filesystem containing dirPath does not have space for the layers. The uncompressed
+// size of the layers is not known before they are read, so their compressed size is used, which is a lower bound
+// for the space needed by the extracted files. When spooled layers are downloaded at the same time into dirPath while
+// the others are extracted, the space of the largest spooled compressed layers is also needed
+func checkDiskSpace(dirPath string, layers []regv1.Layer, spooled int) error {
+	var needed int64
+	var sizes []int64
+	for _, layer := range layers {
+		size, err := layer.Size()
+		if err != nil {
+			return err
+		}
+		needed += size
+		sizes = append(sizes, size)
+	}
+
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+	for idx := 0; idx < spooled && idx < len(sizes); idx++ {
+		needed += sizes[idx]
+	}
+
+	// the output directory might not exist yet, the closest existing parent is in the same filesystem
+	path := filepath.Clean(dirPath)
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	available, err := availableDiskSpace(path)
+	if err != nil {
+		if errors.Is(err, errDiskSpaceUnknown) {
+			return nil
+		}
+		return fmt.Errorf("Checking available disk space at '%s': %s", path, err)
+	}
+
+	if uint64(needed) > available {
+		return fmt.Errorf("Image needs ~%s, only %s available at %s (hint: Use --skip-space-check to extract anyway)",
+			formatBytes(uint64(needed)), formatBytes(available), dirPath)
+	}
+	return nil
+}
+
+// formatBytes formats a number of bytes with one decimal in the largest unit that keeps it above 1
+func formatBytes(bytes uint64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	value := float64(bytes)
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	idx := -1
+	for value >= unit && idx < len(units)-1 {
+		value /= unit
+		idx++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[idx])
+}
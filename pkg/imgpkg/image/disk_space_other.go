@@ -0,0 +1,11 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !freebsd && !windows
+
+package image
+
+// diskSpace the available disk space is only checked on Linux, macOS, FreeBSD and Windows
+func diskSpace(_ string) (uint64, error) {
+	return 0, errDiskSpaceUnknown
+}
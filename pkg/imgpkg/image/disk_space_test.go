@@ -0,0 +1,101 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	layers := []regv1.Layer{sizedLayer{size: 8 * 1000 * 1000 * 1000}, sizedLayer{size: 4400 * 1000 * 1000}}
+
+	fakeDiskSpace := func(t *testing.T, available uint64, err error) *string {
+		checkedPath := ""
+		previous := availableDiskSpace
+		availableDiskSpace = func(path string) (uint64, error) {
+			checkedPath = path
+			return available, err
+		}
+		t.Cleanup(func() { availableDiskSpace = previous })
+		return &checkedPath
+	}
+
+	t.Run("it fails when the layers do not fit in the available space", func(t *testing.T) {
+		fakeDiskSpace(t, 3100*1000*1000, nil)
+
+		err := checkDiskSpace("/out", layers, 0)
+		require.EqualError(t, err, "Image needs ~12.4GB, only 3.1GB available at /out (hint: Use --skip-space-check to extract anyway)")
+	})
+
+	t.Run("it succeeds when the layers fit in the available space", func(t *testing.T) {
+		fakeDiskSpace(t, 20*1000*1000*1000, nil)
+
+		require.NoError(t, checkDiskSpace("/out", layers, 0))
+	})
+
+	t.Run("it adds the space of the largest layers spooled at the same time", func(t *testing.T) {
+		fakeDiskSpace(t, 20*1000*1000*1000, nil)
+
+		err := checkDiskSpace("/out", layers, 1)
+		require.EqualError(t, err, "Image needs ~20.4GB, only 20.0GB available at /out (hint: Use --skip-space-check to extract anyway)")
+
+		err = checkDiskSpace("/out", layers, 4)
+		require.EqualError(t, err, "Image needs ~24.8GB, only 20.0GB available at /out (hint: Use --skip-space-check to extract anyway)")
+	})
+
+	t.Run("it checks the closest existing parent of the output directory", func(t *testing.T) {
+		checkedPath := fakeDiskSpace(t, 20*1000*1000*1000, nil)
+		parent := t.TempDir()
+
+		require.NoError(t, checkDiskSpace(filepath.Join(parent, "missing", "output"), layers, 0))
+		assert.Equal(t, parent, *checkedPath)
+	})
+
+	t.Run("it succeeds when the available space is unknown", func(t *testing.T) {
+		fakeDiskSpace(t, 0, errDiskSpaceUnknown)
+
+		require.NoError(t, checkDiskSpace("/out", layers, 0))
+	})
+
+	t.Run("it fails when the available space cannot be read", func(t *testing.T) {
+		fakeDiskSpace(t, 0, fmt.Errorf("permission denied"))
+
+		err := checkDiskSpace("/out", layers, 0)
+		require.ErrorContains(t, err, "Checking available disk space at '/': permission denied")
+	})
+
+	t.Run("it is skipped when extracting with the option to skip it", func(t *testing.T) {
+		fakeDiskSpace(t, 0, nil)
+		img := singleLayerImage(t, "file.txt")
+
+		err := NewDirImage(t.TempDir(), img, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "only 0B available")
+
+		opts := DirImageOpts{SkipSpaceCheck: true}
+		require.NoError(t, NewDirImageWithOpts(t.TempDir(), img, opts, util.NewNoopLogger()).AsDirectory())
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5KB", formatBytes(1500))
+	assert.Equal(t, "12.4GB", formatBytes(12400*1000*1000))
+}
+
+// sizedLayer layer that only knows its compressed size
+type sizedLayer struct {
+	regv1.Layer
+	size int64
+}
+
+func (l sizedLayer) Size() (int64, error) {
+	return l.size, nil
+}
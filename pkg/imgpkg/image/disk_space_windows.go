@@ -0,0 +1,22 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package image
+
+import "golang.org/x/sys/windows"
+
+func diskSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	err = windows.GetDiskFreeSpaceEx(pathPtr, &available, &total, &free)
+	if err != nil {
+		return 0, err
+	}
+	return available, nil
+}
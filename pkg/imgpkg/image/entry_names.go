@@ -0,0 +1,41 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"strings"
+)
+
+// canonicalizeHeader rewrites the name of the entry, and the target of a hardlink, in their canonical form, so that
+// the entries of every layer are matched with each other, with whiteouts and with include paths the same way no
+// matter how the image builder named them
+func canonicalizeHeader(hdr *tar.Header) {
+	hdr.Name = canonicalEntryName(hdr.Name)
+	if hdr.Typeflag == tar.TypeLink {
+		hdr.Linkname = canonicalEntryName(hdr.Linkname)
+	}
+}
+
+// canonicalEntryName returns the name of an entry relative to the root of the image, using forward slashes and
+// without leading '/' or './', so that '/etc/passwd', './etc/passwd' and 'etc/passwd' are the same entry. The root
+// itself is '.'. Only empty and '.' components are removed, '..' components are kept as they are since a symlink
+// extracted earlier can change what they point to, entries using them are checked when they are extracted
+func canonicalEntryName(name string) string {
+	// images created on Windows by previous versions of imgpkg use \ as separator
+	if strings.Contains(name, "\\") {
+		name = strings.ReplaceAll(name, "\\", "/")
+	}
+
+	var components []string
+	for _, component := range strings.Split(name, "/") {
+		if component != "" && component != "." {
+			components = append(components, component)
+		}
+	}
+	if len(components) == 0 {
+		return "."
+	}
+	return strings.Join(components, "/")
+}
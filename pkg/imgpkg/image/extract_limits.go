@@ -0,0 +1,87 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+const (
+	// DefaultMaxExtractSizeRatio when no maximum extract size is provided, the layers can be extracted up to
+	// this many times their compressed size
+	DefaultMaxExtractSizeRatio = 10
+	// DefaultMinMaxExtractSize the maximum extract size used by default is never lower than this, so that images
+	// with very compressible content, like text files, can still be extracted
+	DefaultMinMaxExtractSize = 1024 * 1024 * 1024
+	// DefaultMaxEntries maximum number of tar entries extracted from an image when none is provided
+	DefaultMaxEntries = 1000000
+)
+
+// ExtractLimits bound the content extracted from an image, to protect against layers that decompress to
+// sizes, or number of files, that would exhaust the disk. Zero values use the defaults and negative values
+// disable the limit
+type ExtractLimits struct {
+	// MaxSize maximum number of uncompressed bytes extracted from all the layers. Defaults to
+	// DefaultMaxExtractSizeRatio times the compressed size of the layers, and at least DefaultMinMaxExtractSize
+	MaxSize int64
+	// MaxFileSize maximum size of a single file. By default only MaxSize applies
+	MaxFileSize int64
+	// MaxEntries maximum number of tar entries read from all the layers. Defaults to DefaultMaxEntries
+	MaxEntries int
+}
+
+// withDefaults returns the limits that apply to layers with the provided compressed size
+func (l ExtractLimits) withDefaults(compressedSize int64) ExtractLimits {
+	if l.MaxSize == 0 {
+		l.MaxSize = compressedSize * DefaultMaxExtractSizeRatio
+		if l.MaxSize < DefaultMinMaxExtractSize {
+			l.MaxSize = DefaultMinMaxExtractSize
+		}
+	}
+	if l.MaxEntries == 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	return l
+}
+
+// extractCounter keeps track of the content extracted so far and checks it against the limits
+type extractCounter struct {
+	limits  ExtractLimits
+	size    int64
+	entries int
+}
+
+// Add counts the entry found in the layer, failing when it goes over one of the limits
+func (c *extractCounter) Add(layerDigest string, hdr *tar.Header) error {
+	c.entries++
+	if c.limits.MaxEntries > 0 && c.entries > c.limits.MaxEntries {
+		return limitExceededError{fmt.Sprintf("Layer '%s' exceeds the maximum number of entries (%d) at entry '%s' "+
+			"(hint: Use --max-entries to increase the limit)", layerDigest, c.limits.MaxEntries, hdr.Name)}
+	}
+
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA && hdr.Typeflag != tar.TypeGNUSparse {
+		return nil
+	}
+
+	if c.limits.MaxFileSize > 0 && hdr.Size > c.limits.MaxFileSize {
+		return limitExceededError{fmt.Sprintf("Layer '%s' entry '%s' size (%d bytes) exceeds the maximum file size (%d bytes) "+
+			"(hint: Use --max-file-size to increase the limit)", layerDigest, hdr.Name, hdr.Size, c.limits.MaxFileSize)}
+	}
+
+	c.size += hdr.Size
+	if c.limits.MaxSize > 0 && c.size > c.limits.MaxSize {
+		return limitExceededError{fmt.Sprintf("Layer '%s' exceeds the maximum extract size (%d bytes) at entry '%s' "+
+			"(hint: Use --max-extract-size to increase the limit)", layerDigest, c.limits.MaxSize, hdr.Name)}
+	}
+	return nil
+}
+
+type limitExceededError struct {
+	msg string
+}
+
+func (e limitExceededError) Error() string {
+	return e.msg
+}
@@ -0,0 +1,27 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractLimitsDefaults(t *testing.T) {
+	t.Run("the maximum size is 10 times the compressed size", func(t *testing.T) {
+		limits := ExtractLimits{}.withDefaults(1024 * 1024 * 1024)
+		assert.Equal(t, ExtractLimits{MaxSize: 10 * 1024 * 1024 * 1024, MaxEntries: DefaultMaxEntries}, limits)
+	})
+
+	t.Run("the maximum size is never lower than the minimum", func(t *testing.T) {
+		limits := ExtractLimits{}.withDefaults(1024)
+		assert.Equal(t, int64(DefaultMinMaxExtractSize), limits.MaxSize)
+	})
+
+	t.Run("provided limits are kept", func(t *testing.T) {
+		limits := ExtractLimits{MaxSize: -1, MaxFileSize: 10, MaxEntries: 5}.withDefaults(1024)
+		assert.Equal(t, ExtractLimits{MaxSize: -1, MaxFileSize: 10, MaxEntries: 5}, limits)
+	})
+}
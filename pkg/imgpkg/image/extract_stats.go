@@ -0,0 +1,114 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import "time"
+
+// EntryStats counters of the entries written, or skipped, while extracting
+type EntryStats struct {
+	Files       int
+	Directories int
+	Symlinks    int
+	Hardlinks   int
+	// Devices character and block devices, and named pipes, created with DirImageOpts.AllowDevices
+	Devices int
+	// Bytes size of the content of the regular files written
+	Bytes int64
+	// SkippedSymlinks symlinks left out of the extraction, see DirImageOpts.PreserveSymlinks
+	SkippedSymlinks int
+	// SkippedDevices character and block devices, and named pipes, left out of the extraction, see
+	// DirImageOpts.AllowDevices
+	SkippedDevices int
+	// Whiteouts whiteout and opaque whiteout entries applied to the content of older layers
+	Whiteouts int
+	// Excluded entries not extracted because of DirImageOpts.ExcludedPaths
+	Excluded int
+	// StrippedSpecialBits files and directories whose setuid, setgid and sticky bits were removed, see
+	// DirImageOpts.PreserveSpecialBits
+	StrippedSpecialBits int
+}
+
+func (s *EntryStats) add(other EntryStats) {
+	s.Files += other.Files
+	s.Directories += other.Directories
+	s.Symlinks += other.Symlinks
+	s.Hardlinks += other.Hardlinks
+	s.Devices += other.Devices
+	s.Bytes += other.Bytes
+	s.SkippedSymlinks += other.SkippedSymlinks
+	s.SkippedDevices += other.SkippedDevices
+	s.Whiteouts += other.Whiteouts
+	s.Excluded += other.Excluded
+	s.StrippedSpecialBits += other.StrippedSpecialBits
+}
+
+// LayerStats statistics of the extraction of a layer, the duration includes the retries of the layer. When layers are
+// downloaded concurrently, it only includes the part of the download that was not done while newer layers were extracted
+type LayerStats struct {
+	EntryStats
+	ImageDigest string
+	Digest      string
+	// CompressedSize size of the layer in the registry, which is also the total of the progress reported for it
+	CompressedSize int64
+	Duration       time.Duration
+}
+
+// Throughput returns the compressed megabytes of the layer extracted per second
+func (l LayerStats) Throughput() float64 {
+	if l.Duration <= 0 {
+		return 0
+	}
+	return float64(l.CompressedSize) / 1000 / 1000 / l.Duration.Seconds()
+}
+
+// ExtractStats statistics of the images extracted with DirImage.AsDirectory. The counters are the sum of the
+// counters of the layers, plus the hardlinks created once all the layers of an image are extracted
+type ExtractStats struct {
+	EntryStats
+	Images   int
+	Layers   []LayerStats
+	Duration time.Duration
+}
+
+// SlowestLayer returns the layer that took the longest to extract, if any
+func (s ExtractStats) SlowestLayer() (LayerStats, bool) {
+	if len(s.Layers) == 0 {
+		return LayerStats{}, false
+	}
+	slowest := s.Layers[0]
+	for _, layer := range s.Layers[1:] {
+		if layer.Duration > slowest.Duration {
+			slowest = layer
+		}
+	}
+	return slowest, true
+}
+
+func (s *ExtractStats) add(other ExtractStats) {
+	s.EntryStats.add(other.EntryStats)
+	s.Images += other.Images
+	s.Layers = append(s.Layers, other.Layers...)
+	s.Duration += other.Duration
+}
+
+// logExtractStats reports what was extracted from the image, and how long each layer took
+func (i *DirImage) logExtractStats() {
+	stats := i.stats
+	i.logger.Logf("Extracted %d file(s) (%s), %d directories, %d symlink(s) and %d hardlink(s) from %d layer(s) in %s, applied %d whiteout(s)\n",
+		stats.Files, formatBytes(uint64(stats.Bytes)), stats.Directories, stats.Symlinks, stats.Hardlinks,
+		len(stats.Layers), stats.Duration.Round(time.Millisecond), stats.Whiteouts)
+	if stats.Excluded > 0 {
+		i.logger.Logf("Excluded %d entries\n", stats.Excluded)
+	}
+	for _, layer := range stats.Layers {
+		i.logger.Logf("  Layer '%s': %s compressed, %d file(s) (%s), %d skipped symlink(s), %d whiteout(s) in %s at %.1f MB/s\n", layer.Digest,
+			formatBytes(uint64(layer.CompressedSize)), layer.Files, formatBytes(uint64(layer.Bytes)), layer.SkippedSymlinks, layer.Whiteouts,
+			layer.Duration.Round(time.Millisecond), layer.Throughput())
+	}
+	// a single layer is already the slowest one
+	if slowest, found := stats.SlowestLayer(); found && len(stats.Layers) > 1 {
+		i.logger.Logf("Slowest layer '%s' took %s for %s compressed at %.1f MB/s\n", slowest.Digest,
+			slowest.Duration.Round(time.Millisecond), formatBytes(uint64(slowest.CompressedSize)), slowest.Throughput())
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ExtractAnnotation manifest annotation marking the content of an image as not meant to be extracted when its value
+// is false, like images only consumed by copying or relocating them. Other values are ignored
+const ExtractAnnotation = "imgpkg.carvel.dev/extract"
+
+// checkExtractable errors when the manifest of the image marks it as not extractable, unless DirImageOpts.ForceExtract
+// is set
+func (i *DirImage) checkExtractable() error {
+	if i.opts.ForceExtract {
+		return nil
+	}
+	manifest, err := i.img.Manifest()
+	if err != nil {
+		return err
+	}
+	value, found := manifest.Annotations[ExtractAnnotation]
+	if !found {
+		return nil
+	}
+	extractable, err := strconv.ParseBool(value)
+	if err != nil || extractable {
+		return nil
+	}
+
+	digest, err := i.img.Digest()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Image '%s' is marked as not extractable by its annotation %s: \"%s\", its content is meant to be copied or relocated instead "+
+		"(hint: Use --force-extract to extract it anyway)", digest, ExtractAnnotation, value)
+}
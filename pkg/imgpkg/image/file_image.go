@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -20,44 +22,162 @@ import (
 type FileImage struct {
 	v1.Image
 	path string
+	// compressedPath compressed blob of the layer, when it is not compressed with the default gzip level
+	compressedPath string
+}
+
+// MediaTypes family of the media types of the manifest, config and layer of an image
+type MediaTypes string
+
+const (
+	// DockerMediaTypes Docker v2 schema 2 media types, used when none is selected
+	DockerMediaTypes MediaTypes = "docker"
+	// OCIMediaTypes OCI image spec media types, the only ones with annotations
+	OCIMediaTypes MediaTypes = "oci"
+)
+
+// FileImageOpts metadata of the image created from a file
+type FileImageOpts struct {
+	// MediaTypes media types of the image. When empty, OCIMediaTypes are used for images with annotations or zstd
+	// layers and DockerMediaTypes otherwise
+	MediaTypes MediaTypes
+	// Compression compression of the layer, GzipCompression when empty
+	Compression Compression
+	// CompressionLevel level of the compression, the default level of the compression when 0
+	CompressionLevel int
+	// Labels labels of the image config
+	Labels map[string]string
+	// Annotations annotations of the image manifest
+	Annotations map[string]string
+	// LayerAnnotations annotations of the descriptor of the single layer of the image
+	LayerAnnotations map[string]string
+	// Created time of the image config and of the modification of the files in the layer. When zero, the times are left
+	// zero, which keeps the image the same whenever it is built
+	Created time.Time
+	// Platform os, architecture and variant of the image config. When nil, they are left empty
+	Platform *v1.Platform
+	// Env environment variables of the image config, which are serialized sorted by name so that the same variables
+	// always result in the same digest
+	Env map[string]string
+	// WorkingDir working directory of the image config
+	WorkingDir string
+	// SizeLimits bound the size of the files the image is built with, when it is built from files
+	SizeLimits PushSizeLimits
+	// SecretScan scan of the files the image is built with for secrets, when it is built from files
+	SecretScan SecretScanOpts
+	// IncludeVCS adds the directories of version control systems, such as .git, which are left out otherwise, when
+	// the image is built from files
+	IncludeVCS bool
+	// ExclusionsFromGitignore leaves out the paths listed in the .gitignore file of each directory, as git does, when
+	// the image is built from files. The IgnoreFile decides before them, and the excluded paths leave paths out either way
+	ExclusionsFromGitignore bool
+	// AccurateSize compresses the layer before it is uploaded, when it is only compressed while uploaded otherwise, so
+	// that the size reported to OnSizeEstimated is exact
+	AccurateSize bool
+	// OnSizeEstimated is called with the size of the files the image is built with, once it is built and before it is
+	// uploaded, when it is built from files
+	OnSizeEstimated func(PushSizeEstimate)
+	// OnContentsListed is called with the entries of the layer, and the parameters it is compressed with, once the image
+	// is built and before it is uploaded, when it is built from files. The image is not built when it fails
+	OnContentsListed func(LayerContents) error
+	// LayerCache cache the compressed layer is reused from, and added to, when the image is built from files. The
+	// layer is always built when nil
+	LayerCache LayerCache
 }
 
 func NewFileImage(path string, labels map[string]string) (*FileImage, error) {
+	return NewFileImageWithOpts(path, FileImageOpts{Labels: labels})
+}
+
+// NewFileImageWithOpts creates an image with a single layer, the tar at path, and the metadata of opts. Annotations
+// are maps, which are serialized with their keys sorted so that the same annotations always result in the same digest
+func NewFileImageWithOpts(path string, opts FileImageOpts) (*FileImage, error) {
 	sha256, err := sha256Path(path)
 	if err != nil {
 		return nil, err
 	}
 
-	layer, err := partial.UncompressedToLayer(&UncompressedFileLayer{
-		diffID:    v1.Hash{Algorithm: "sha256", Hex: sha256},
-		mediaType: types.DockerLayer,
-		path:      path,
-	})
+	mediaTypes, err := opts.mediaTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	compression, level, err := opts.compression()
+	if err != nil {
+		return nil, err
+	}
+
+	diffID := v1.Hash{Algorithm: "sha256", Hex: sha256}
+	var layer v1.Layer
+	compressedPath := ""
+	if compression == GzipCompression && level == defaultCompressionLevels[GzipCompression] && opts.LayerCache == nil {
+		// compressed while it is uploaded, as images were always pushed
+		layer, err = partial.UncompressedToLayer(&UncompressedFileLayer{
+			diffID:    diffID,
+			mediaType: layerMediaType(mediaTypes, compression),
+			path:      path,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		compressedLayer, err := newCompressedFileLayer(path, diffID, layerMediaType(mediaTypes, compression), compression, level)
+		if err != nil {
+			return nil, err
+		}
+		layer = compressedLayer
+		compressedPath = compressedLayer.compressedPath
+	}
+
+	img, err := newLayerImage(layer, mediaTypes, opts)
 	if err != nil {
+		_ = removeIfSet(compressedPath)
 		return nil, err
 	}
+	return &FileImage{img, path, compressedPath}, nil
+}
+
+// newLayerImage creates an image with layer as its single layer, and the metadata of opts
+func newLayerImage(layer v1.Layer, mediaTypes MediaTypes, opts FileImageOpts) (v1.Image, error) {
+	base := empty.Image
+	if mediaTypes == OCIMediaTypes {
+		base = mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.OCIConfigJSON)
+	}
 
 	add := mutate.Addendum{
-		Layer: layer,
+		Layer:       layer,
+		Annotations: opts.LayerAnnotations,
 		History: v1.History{
 			Author:    "imgpkg",
 			CreatedBy: "imgpkg",
-			Created:   v1.Time{}, // static
+			Created:   v1.Time{Time: opts.Created}, // static unless provided
 		},
 	}
 
-	img, err := mutate.Append(empty.Image, add)
+	img, err := mutate.Append(base, add)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(labels) > 0 {
+	if len(opts.Labels) > 0 || !opts.Created.IsZero() || opts.Platform != nil || len(opts.Env) > 0 || opts.WorkingDir != "" {
 		cfg, err := img.ConfigFile()
 		if err != nil {
 			return nil, fmt.Errorf("Fetching image config: %s", err)
 		}
 
-		cfg.Config.Labels = labels
+		if len(opts.Labels) > 0 {
+			cfg.Config.Labels = opts.Labels
+		}
+		if len(opts.Env) > 0 {
+			cfg.Config.Env = sortedEnv(opts.Env)
+		}
+		cfg.Config.WorkingDir = opts.WorkingDir
+		cfg.Created = v1.Time{Time: opts.Created}
+		if opts.Platform != nil {
+			cfg.OS = opts.Platform.OS
+			cfg.Architecture = opts.Platform.Architecture
+			cfg.Variant = opts.Platform.Variant
+		}
 
 		img, err = mutate.ConfigFile(img, cfg)
 		if err != nil {
@@ -65,11 +185,65 @@ func NewFileImage(path string, labels map[string]string) (*FileImage, error) {
 		}
 	}
 
-	return &FileImage{img, path}, nil
+	if len(opts.Annotations) > 0 {
+		img = mutate.Annotations(img, opts.Annotations).(v1.Image)
+	}
+	return img, nil
+}
+
+// sortedEnv returns env as the KEY=VALUE entries of an image config, sorted by name
+func sortedEnv(env map[string]string) []string {
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entries []string
+	for _, name := range names {
+		entries = append(entries, name+"="+env[name])
+	}
+	return entries
+}
+
+// mediaTypes returns the media types of the image, Docker manifests do not have annotations nor zstd layers
+func (o FileImageOpts) mediaTypes() (MediaTypes, error) {
+	hasAnnotations := len(o.Annotations) > 0 || len(o.LayerAnnotations) > 0
+	switch o.MediaTypes {
+	case "":
+		if hasAnnotations || o.Compression == ZstdCompression {
+			return OCIMediaTypes, nil
+		}
+		return DockerMediaTypes, nil
+	case DockerMediaTypes:
+		if hasAnnotations {
+			return "", fmt.Errorf("Expected OCI media types for an image with annotations, Docker manifests do not have annotations")
+		}
+		if o.Compression == ZstdCompression {
+			return "", fmt.Errorf("Expected OCI media types for an image with zstd layers, Docker manifests do not have zstd layers")
+		}
+		return DockerMediaTypes, nil
+	case OCIMediaTypes:
+		return OCIMediaTypes, nil
+	default:
+		return "", fmt.Errorf("Expected media types '%s' to be one of %s or %s", o.MediaTypes, OCIMediaTypes, DockerMediaTypes)
+	}
 }
 
 func (i *FileImage) Remove() error {
-	return os.Remove(i.path)
+	err := removeIfSet(i.compressedPath)
+	if err != nil {
+		return err
+	}
+	// images with a cached layer have no file
+	return removeIfSet(i.path)
+}
+
+func removeIfSet(path string) error {
+	if path == "" {
+		return nil
+	}
+	return os.Remove(path)
 }
 
 func sha256Path(path string) (string, error) {
@@ -0,0 +1,13 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !freebsd
+
+package image
+
+import "os"
+
+// fileOwner the owner of files is only known on Linux, macOS and FreeBSD, the other platforms do not change it
+func fileOwner(_ os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile file at the root of the directories pushed, listing the paths that are not part of the image with the
+// gitignore syntax
+const IgnoreFile = ".imgpkgignore"
+
+// GitignoreFile file of the directories pushed listing, for git, the paths that are not part of the repository. Its
+// rules leave paths out of the image with FileImageOpts.ExclusionsFromGitignore
+const GitignoreFile = ".gitignore"
+
+// ignoreRule pattern of an ignore file, split in segments
+type ignoreRule struct {
+	segments []string
+	negated  bool
+	dirOnly  bool
+}
+
+// IgnoreRules rules of an ignore file, the last rule matching a path decides if the path is ignored. As with git, the
+// content of an ignored directory cannot be included again by a negated rule, since the directory is not walked
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+// ReadIgnoreFile reads the rules of the ignore file in dir, there are no rules when the file does not exist
+func ReadIgnoreFile(dir string) (IgnoreRules, error) {
+	return readIgnoreRules(filepath.Join(dir, IgnoreFile))
+}
+
+// ReadGitignoreFile reads the rules of the .gitignore file in dir, which apply to the paths relative to dir, there
+// are no rules when the file does not exist
+func ReadGitignoreFile(dir string) (IgnoreRules, error) {
+	return readIgnoreRules(filepath.Join(dir, GitignoreFile))
+}
+
+// readIgnoreRules reads the rules of the file at ignorePath, with the syntax of NewIgnoreRules
+func readIgnoreRules(ignorePath string) (IgnoreRules, error) {
+	file, err := os.Open(ignorePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return IgnoreRules{}, nil
+		}
+		return IgnoreRules{}, fmt.Errorf("Reading '%s': %s", ignorePath, err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return IgnoreRules{}, fmt.Errorf("Reading '%s': %s", ignorePath, err)
+	}
+
+	rules, err := NewIgnoreRules(lines)
+	if err != nil {
+		return IgnoreRules{}, fmt.Errorf("Parsing '%s': %s", ignorePath, err)
+	}
+	return rules, nil
+}
+
+// NewIgnoreRules parses the lines of an ignore file. Blank lines and lines starting with '#' are skipped, '!' negates
+// a pattern, a trailing '/' only matches directories, and a pattern with a '/' other than the trailing one is relative
+// to the root instead of matching at any depth. Segments use the path.Match syntax, and '**' matches any number of them
+func NewIgnoreRules(lines []string) (IgnoreRules, error) {
+	rules := IgnoreRules{}
+	for _, line := range lines {
+		pattern := trimIgnoreLine(line)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		switch {
+		case strings.HasPrefix(pattern, "!"):
+			rule.negated = true
+			pattern = pattern[1:]
+		case strings.HasPrefix(pattern, `\!`), strings.HasPrefix(pattern, `\#`):
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		anchored := strings.Contains(pattern, "/")
+
+		for _, segment := range strings.Split(pattern, "/") {
+			if segment == "" {
+				continue
+			}
+			if _, err := path.Match(segment, ""); err != nil {
+				return IgnoreRules{}, fmt.Errorf("Invalid pattern '%s': %s", line, err)
+			}
+			rule.segments = append(rule.segments, segment)
+		}
+		if len(rule.segments) == 0 {
+			return IgnoreRules{}, fmt.Errorf("Invalid pattern '%s': pattern is empty", line)
+		}
+		if !anchored {
+			rule.segments = append([]string{"**"}, rule.segments...)
+		}
+		// a trailing '**' matches the content of the directory, not the directory itself
+		if last := len(rule.segments) - 1; rule.segments[last] == "**" {
+			rule.segments = append(rule.segments[:last], "*", "**")
+		}
+		rules.rules = append(rules.rules, rule)
+	}
+	return rules, nil
+}
+
+// trimIgnoreLine removes the trailing spaces of line that are not escaped with a backslash
+func trimIgnoreLine(line string) string {
+	trimmed := strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		return trimmed[:len(trimmed)-1] + " "
+	}
+	return trimmed
+}
+
+// Ignored returns true when relPath, relative to the directory of the ignore file, is ignored
+func (r IgnoreRules) Ignored(relPath string, isDir bool) bool {
+	ignored, _ := r.match(relPath, isDir)
+	return ignored
+}
+
+// match returns if relPath is ignored, and if a rule matched it at all, so that the rules of a more specific ignore
+// file decide before the rules of the others
+func (r IgnoreRules) match(relPath string, isDir bool) (bool, bool) {
+	segments := splitImageName(filepath.ToSlash(relPath))
+	ignored := false
+	matched := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segments, segments) {
+			ignored = !rule.negated
+			matched = true
+		}
+	}
+	return ignored, matched
+}
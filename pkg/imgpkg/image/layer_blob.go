@@ -0,0 +1,142 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// WriteLayer writes the compressed blob of the layer of img with the provided digest to out, or the uncompressed
+// tar of the layer when uncompressed is true. The digest of the compressed blob is verified while it is read
+func WriteLayer(img regv1.Image, layerDigest string, uncompressed bool, out io.Writer) error {
+	digest, err := regv1.NewHash(layerDigest)
+	if err != nil {
+		return fmt.Errorf("Parsing layer digest '%s': %s", layerDigest, err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	var layer regv1.Layer
+	var available []string
+	for _, imgLayer := range layers {
+		imgLayerDigest, err := imgLayer.Digest()
+		if err != nil {
+			return err
+		}
+		if imgLayerDigest == digest {
+			layer = imgLayer
+			break
+		}
+		available = append(available, imgLayerDigest.String())
+	}
+	if layer == nil {
+		return fmt.Errorf("Expected layer '%s' to be part of the image, available layers are:\n- %s", digest, strings.Join(available, "\n- "))
+	}
+
+	compressed := &verifiedLayer{layer: layer, digest: digest}
+	verifiedReader, err := compressed.Compressed()
+	if err != nil {
+		return fmt.Errorf("Downloading layer '%s': %s", digest, err)
+	}
+	defer verifiedReader.Close()
+
+	stream := io.Reader(verifiedReader)
+	if uncompressed {
+		// the compressed stream is decompressed while it is verified
+		uncompressedLayer, err := partial.CompressedToLayer(&openedLayer{verifiedLayer: compressed, stream: verifiedReader})
+		if err != nil {
+			return err
+		}
+		uncompressedStream, err := uncompressedLayer.Uncompressed()
+		if err != nil {
+			return fmt.Errorf("Decompressing layer '%s': %s", digest, err)
+		}
+		defer uncompressedStream.Close()
+		stream = uncompressedStream
+	}
+
+	_, err = io.Copy(out, stream)
+	if err != nil {
+		return fmt.Errorf("Writing layer '%s': %s", digest, err)
+	}
+
+	// the decompression might not read the end of the compressed stream, which is needed to verify the digest
+	_, err = io.Copy(io.Discard, verifiedReader)
+	if err != nil {
+		return fmt.Errorf("Writing layer '%s': %s", digest, err)
+	}
+	return nil
+}
+
+// verifiedLayer only exposes the compressed side of a layer, whose content is checked against the digest
+type verifiedLayer struct {
+	layer  regv1.Layer
+	digest regv1.Hash
+	// ctx when provided stops the reads of the content once it is done
+	ctx context.Context
+}
+
+func (l *verifiedLayer) Digest() (regv1.Hash, error)         { return l.digest, nil }
+func (l *verifiedLayer) Size() (int64, error)                { return l.layer.Size() }
+func (l *verifiedLayer) MediaType() (types.MediaType, error) { return l.layer.MediaType() }
+
+func (l *verifiedLayer) Compressed() (io.ReadCloser, error) {
+	stream, err := l.layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := regv1.Hasher(l.digest.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if l.ctx != nil {
+		stream = struct {
+			io.Reader
+			io.Closer
+		}{&contextReader{ctx: l.ctx, reader: stream}, stream}
+	}
+	return &verifiedReader{ReadCloser: stream, hasher: hasher, digest: l.digest}, nil
+}
+
+// openedLayer layer whose compressed stream was already opened, so that it is only read once
+type openedLayer struct {
+	*verifiedLayer
+	stream io.ReadCloser
+}
+
+func (l *openedLayer) Compressed() (io.ReadCloser, error) {
+	return io.NopCloser(l.stream), nil
+}
+
+// verifiedReader hashes the content read and fails at the end of the stream when it does not match the digest
+type verifiedReader struct {
+	io.ReadCloser
+	hasher hash.Hash
+	digest regv1.Hash
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = r.hasher.Write(p[:n])
+	}
+	if err == io.EOF {
+		actual := regv1.Hash{Algorithm: r.digest.Algorithm, Hex: fmt.Sprintf("%x", r.hasher.Sum(nil))}
+		if actual != r.digest {
+			return n, fmt.Errorf("Expected layer digest to be '%s' but was '%s'", r.digest, actual)
+		}
+	}
+	return n, err
+}
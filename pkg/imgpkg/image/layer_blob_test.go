@@ -0,0 +1,83 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLayer(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{fileEntry("older.txt", "older")}, []tarEntry{fileEntry("newer.txt", "newer")})
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	olderDigest, err := layers[0].Digest()
+	require.NoError(t, err)
+	newerDigest, err := layers[1].Digest()
+	require.NoError(t, err)
+
+	t.Run("it writes the compressed blob of the layer", func(t *testing.T) {
+		out := bytes.NewBuffer(nil)
+		require.NoError(t, image.WriteLayer(img, newerDigest.String(), false, out))
+
+		digest, _, err := regv1.SHA256(bytes.NewReader(out.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, newerDigest, digest)
+	})
+
+	t.Run("it writes the tar of the layer when uncompressed", func(t *testing.T) {
+		out := bytes.NewBuffer(nil)
+		require.NoError(t, image.WriteLayer(img, olderDigest.String(), true, out))
+
+		tarReader := tar.NewReader(out)
+		hdr, err := tarReader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "older.txt", hdr.Name)
+		content, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		assert.Equal(t, "older", string(content))
+	})
+
+	t.Run("it fails listing the available layers when the layer is not part of the image", func(t *testing.T) {
+		missingDigest := "sha256:" + string(bytes.Repeat([]byte("a"), 64))
+		err := image.WriteLayer(img, missingDigest, false, io.Discard)
+		require.ErrorContains(t, err, "Expected layer '"+missingDigest+"' to be part of the image, available layers are:\n- "+olderDigest.String()+"\n- "+newerDigest.String())
+	})
+
+	t.Run("it fails when the digest is not valid", func(t *testing.T) {
+		err := image.WriteLayer(img, "not-a-digest", false, io.Discard)
+		require.ErrorContains(t, err, "Parsing layer digest 'not-a-digest'")
+	})
+
+	t.Run("it fails when the content does not match the digest", func(t *testing.T) {
+		otherLayers, err := imageFromLayers(t, []tarEntry{fileEntry("other.txt", "other")}).Layers()
+		require.NoError(t, err)
+		corruptedImg, err := mutate.AppendLayers(empty.Image, corruptedLayer{Layer: layers[0], content: otherLayers[0]})
+		require.NoError(t, err)
+
+		for _, uncompressed := range []bool{false, true} {
+			err = image.WriteLayer(corruptedImg, olderDigest.String(), uncompressed, io.Discard)
+			require.ErrorContains(t, err, "Expected layer digest to be '"+olderDigest.String()+"'")
+		}
+	})
+}
+
+// corruptedLayer layer that reports the digest of one layer and serves the content of another one
+type corruptedLayer struct {
+	regv1.Layer
+	content regv1.Layer
+}
+
+func (l corruptedLayer) Compressed() (io.ReadCloser, error) {
+	return l.content.Compressed()
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// layerCacheKeyVersion is part of the keys of the layers cached, it changes when the tars of the layers are written
+// differently, so that the layers cached before are not reused
+const layerCacheKeyVersion = "imgpkg-layer-v1"
+
+// LayerCache cache of the compressed layers of the images built from files, by the key of the files and of the
+// compression they are built with, so that pushing files that did not change does not compress them again
+type LayerCache interface {
+	// Layer returns the layer added with key, when it is still cached
+	Layer(key string) (CachedLayer, bool)
+	// Add adds layer, whose compressed blob is at compressedPath, with key
+	Add(key string, layer CachedLayer, compressedPath string) error
+	// Open opens the compressed blob of the layer with digest
+	Open(digest v1.Hash) (io.ReadCloser, error)
+}
+
+// CachedLayer layer in a LayerCache
+type CachedLayer struct {
+	Digest v1.Hash
+	DiffID v1.Hash
+	Size   int64
+}
+
+// layerCacheKey returns the key of the layer built from entries with opts, the hash of what its tar has, the path,
+// mode and content of each entry, and of its compression. The exclusions, and the modes of the files, are part of the
+// entries, so that a layer is only reused when it would be built the same. The files are scanned for secrets while
+// they are read
+func (i *TarImage) layerCacheKey(entries []tarTreeEntry, opts FileImageOpts) (string, error) {
+	compression, level, err := opts.compression()
+	if err != nil {
+		return "", err
+	}
+
+	// the tar and the compression could differ with another version of Go
+	key := sha256.New()
+	fmt.Fprintf(key, "%s %s\n%s %d\n%d\n", layerCacheKeyVersion, runtime.Version(), compression, level, opts.Created.Unix())
+
+	secretScan := newSecretScan(opts.SecretScan)
+	for _, entry := range entries {
+		relPath := filepath.ToSlash(entry.relPath)
+		if entry.info.IsDir() {
+			fmt.Fprintf(key, "dir %q %o\n", relPath, i.dirHeaderMode(dirMode(entry.info)))
+			continue
+		}
+
+		mode, err := fileMode(entry.fullPath, entry.info)
+		if err != nil {
+			return "", err
+		}
+		contentHash, err := i.fileContentHash(entry.fullPath, relPath, secretScan)
+		if err != nil {
+			return "", fmt.Errorf("Reading file '%s': %s", entry.fullPath, err)
+		}
+		fmt.Fprintf(key, "file %q %o %s\n", relPath, i.fileHeaderMode(mode), contentHash)
+	}
+
+	err = secretScan.err()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key.Sum(nil)), nil
+}
+
+// fileContentHash returns the hash of the content the file at relPath is added with
+func (i *TarImage) fileContentHash(fullPath, relPath string, secretScan *secretScan) (string, error) {
+	var content io.Reader
+	if replaced, found := i.replacedFiles[relPath]; found {
+		content = bytes.NewReader(replaced)
+	} else {
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		content = file
+	}
+
+	hasher := sha256.New()
+	_, err := io.Copy(hasher, secretScan.scan(relPath, content))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// addToLayerCache adds the compressed layer of the image to cache with key
+func (i *FileImage) addToLayerCache(cache LayerCache, key string) error {
+	if i.compressedPath == "" {
+		return fmt.Errorf("Expected the layer to be compressed before it is cached")
+	}
+
+	layers, err := i.Layers()
+	if err != nil {
+		return err
+	}
+	digest, err := layers[0].Digest()
+	if err != nil {
+		return err
+	}
+	diffID, err := layers[0].DiffID()
+	if err != nil {
+		return err
+	}
+	size, err := layers[0].Size()
+	if err != nil {
+		return err
+	}
+	return cache.Add(key, CachedLayer{Digest: digest, DiffID: diffID, Size: size}, i.compressedPath)
+}
+
+// newCachedFileImage creates an image with the cached layer, and the metadata of opts
+func newCachedFileImage(cached CachedLayer, opts FileImageOpts) (*FileImage, error) {
+	mediaTypes, err := opts.mediaTypes()
+	if err != nil {
+		return nil, err
+	}
+	compression, _, err := opts.compression()
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &cachedLayer{CachedLayer: cached, mediaType: layerMediaType(mediaTypes, compression), compression: compression, cache: opts.LayerCache}
+	img, err := newLayerImage(layer, mediaTypes, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &FileImage{Image: img}, nil
+}
+
+// cachedLayer layer whose compressed blob is read from a LayerCache
+type cachedLayer struct {
+	CachedLayer
+	mediaType   types.MediaType
+	compression Compression
+	cache       LayerCache
+}
+
+var _ v1.Layer = (*cachedLayer)(nil)
+
+func (l *cachedLayer) Digest() (v1.Hash, error)            { return l.CachedLayer.Digest, nil }
+func (l *cachedLayer) DiffID() (v1.Hash, error)            { return l.CachedLayer.DiffID, nil }
+func (l *cachedLayer) Size() (int64, error)                { return l.CachedLayer.Size, nil }
+func (l *cachedLayer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) { return l.cache.Open(l.CachedLayer.Digest) }
+
+// Uncompressed decompresses the cached blob
+func (l *cachedLayer) Uncompressed() (io.ReadCloser, error) {
+	blob, err := l.cache.Open(l.CachedLayer.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l.compression {
+	case GzipCompression:
+		reader, err := gzip.NewReader(blob)
+		if err != nil {
+			_ = blob.Close()
+			return nil, err
+		}
+		return decompressingReader{Reader: reader, blob: blob}, nil
+	case ZstdCompression:
+		decoder, err := zstd.NewReader(blob)
+		if err != nil {
+			_ = blob.Close()
+			return nil, err
+		}
+		return decompressingReader{Reader: decoder.IOReadCloser(), blob: blob}, nil
+	default:
+		return blob, nil
+	}
+}
+
+// decompressingReader reads the decompressed content of blob, closing it once read
+type decompressingReader struct {
+	io.Reader
+	blob io.Closer
+}
+
+func (r decompressingReader) Close() error {
+	if closer, ok := r.Reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return r.blob.Close()
+}
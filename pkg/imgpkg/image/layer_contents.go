@@ -0,0 +1,91 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LayerEntry entry of the tar of the layer of an image, as it was written, so that the entries of two images that
+// should have been the same can be compared to find the one that differs
+type LayerEntry struct {
+	Path string `json:"path"`
+	// Type file, dir or, for the entries of other types, their tar type flag
+	Type string `json:"type"`
+	// Mode permissions, and special bits, of the entry in the tar, once normalized
+	Mode string `json:"mode"`
+	Size int64  `json:"size"`
+	// SHA256 digest of the content of the files, empty for the other entries
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// LayerContents entries of the layer of an image, with the parameters it is compressed with
+type LayerContents struct {
+	Compression LayerCompression `json:"compression"`
+	Entries     []LayerEntry     `json:"entries"`
+}
+
+// reportContents reports to opts.OnContentsListed the entries of the layer of img, read back from the layer so that
+// they are the ones uploaded, including when the layer is reused from a LayerCache
+func reportContents(img *FileImage, opts FileImageOpts) error {
+	if opts.OnContentsListed == nil {
+		return nil
+	}
+
+	compression, err := opts.layerCompression()
+	if err != nil {
+		return err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	entries, err := layerEntries(layers[0])
+	if err != nil {
+		return fmt.Errorf("Listing the contents of the layer: %s", err)
+	}
+	return opts.OnContentsListed(LayerContents{Compression: compression, Entries: entries})
+}
+
+// layerEntries returns the entries of the tar of layer, in the order they are in it
+func layerEntries(layer v1.Layer) ([]LayerEntry, error) {
+	reader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := []LayerEntry{}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := LayerEntry{Path: header.Name, Type: string(header.Typeflag), Mode: fmt.Sprintf("%04o", header.Mode), Size: header.Size}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.Type = "dir"
+		case tar.TypeReg:
+			entry.Type = "file"
+			hash := sha256.New()
+			_, err := io.Copy(hash, tarReader)
+			if err != nil {
+				return nil, err
+			}
+			entry.SHA256 = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// layerPrefetcher downloads the compressed content of the layers into spool files, in the order they are
+// extracted, while the layers downloaded before are being extracted. At most concurrency layers are spooled at
+// the same time, and a spool file is removed as soon as its layer is extracted, which frees the slot for the
+// next layer
+type layerPrefetcher struct {
+	dir    string
+	slots  chan struct{}
+	layers []*spooledLayer
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// stopCleanup stops removing the spool files when the process is interrupted
+	stopCleanup func()
+}
+
+// newLayerPrefetcher starts downloading the layers, the order of the provided layers is the order of extraction.
+// The spool files are kept in a directory created in parentDir, the downloads stop when ctx is done, and onInterrupt
+// watches the spool directory for interruptions of the process
+func newLayerPrefetcher(ctx context.Context, parentDir string, layers []regv1.Layer, concurrency int, onInterrupt func(path string) func()) (*layerPrefetcher, error) {
+	dir, err := os.MkdirTemp(parentDir, ".imgpkg-layers-")
+	if err != nil {
+		return nil, fmt.Errorf("Creating layers spool directory: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &layerPrefetcher{dir: dir, slots: make(chan struct{}, concurrency), ctx: ctx, cancel: cancel, stopCleanup: onInterrupt(dir)}
+	for idx, layer := range layers {
+		p.layers = append(p.layers, &spooledLayer{
+			layer:    layer,
+			path:     filepath.Join(dir, fmt.Sprintf("layer-%d", idx)),
+			done:     make(chan struct{}),
+			released: make(chan struct{}),
+		})
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for _, layer := range p.layers {
+			// slots are taken in the extraction order, so the layer being extracted is always downloaded first
+			select {
+			case p.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			p.wg.Add(1)
+			go func(layer *spooledLayer) {
+				defer p.wg.Done()
+				layer.err = layer.download(ctx)
+				close(layer.done)
+
+				select {
+				case <-layer.released:
+				case <-ctx.Done():
+				}
+				<-p.slots
+			}(layer)
+		}
+	}()
+
+	return p, nil
+}
+
+// Layer waits for the layer at position idx to be downloaded and returns it, reading from the spool file
+func (p *layerPrefetcher) Layer(idx int) (regv1.Layer, error) {
+	layer := p.layers[idx]
+	select {
+	case <-layer.done:
+	case <-p.ctx.Done():
+		// the download of the layer might not have started
+		return nil, p.ctx.Err()
+	}
+	if layer.err != nil {
+		return nil, layer.err
+	}
+	return partial.CompressedToLayer(layer)
+}
+
+// Release removes the spool file of the layer at position idx, once it is extracted
+func (p *layerPrefetcher) Release(idx int) {
+	layer := p.layers[idx]
+	_ = os.Remove(layer.path)
+	close(layer.released)
+}
+
+// Close stops the downloads that are in progress and removes all the spool files
+func (p *layerPrefetcher) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	p.stopCleanup()
+	return os.RemoveAll(p.dir)
+}
+
+// spooledLayer layer whose compressed content is downloaded to a file before being read
+type spooledLayer struct {
+	layer    regv1.Layer
+	path     string
+	err      error
+	done     chan struct{}
+	released chan struct{}
+}
+
+var _ partial.CompressedLayer = &spooledLayer{}
+
+func (l *spooledLayer) Digest() (regv1.Hash, error)         { return l.layer.Digest() }
+func (l *spooledLayer) Size() (int64, error)                { return l.layer.Size() }
+func (l *spooledLayer) MediaType() (types.MediaType, error) { return l.layer.MediaType() }
+
+func (l *spooledLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+func (l *spooledLayer) download(ctx context.Context) error {
+	digest, err := l.layer.Digest()
+	if err != nil {
+		return err
+	}
+
+	stream, err := l.layer.Compressed()
+	if err != nil {
+		return fmt.Errorf("Downloading layer '%s': %w", digest, err)
+	}
+	defer stream.Close()
+
+	file, err := os.Create(l.path)
+	if err != nil {
+		return fmt.Errorf("Creating layer spool file: %s", err)
+	}
+
+	_, err = io.Copy(file, &contextReader{ctx: ctx, reader: stream})
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("Downloading layer '%s': %w", digest, err)
+	}
+	return file.Close()
+}
+
+// contextReader stops reading once the context is done
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
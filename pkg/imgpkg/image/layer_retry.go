@@ -0,0 +1,82 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// maxLayerRetryBackoff longest wait between two attempts at extracting a layer
+const maxLayerRetryBackoff = 30 * time.Second
+
+// layerCheckpoint bookkeeping of the extraction before a layer is extracted, which is restored when the layer is
+// extracted again after a failure. The maps recording the paths of the layer do not need to be restored, the entries
+// of the failed attempt are recorded again with the same values by the next one
+type layerCheckpoint struct {
+	counter          extractCounter
+	pendingHardlinks int
+	skippedLinks     int
+	skippedXattrs    int
+	skippedOwners    int
+	renamedEntries   int
+	entries          EntryStats
+}
+
+func (i *DirImage) layerCheckpoint() layerCheckpoint {
+	return layerCheckpoint{
+		counter:          *i.counter,
+		pendingHardlinks: len(i.pendingHardlinks),
+		skippedLinks:     i.skippedLinks,
+		skippedXattrs:    i.skippedXattrs,
+		skippedOwners:    i.skippedOwners,
+		renamedEntries:   i.renamedEntries,
+		entries:          i.entries,
+	}
+}
+
+func (i *DirImage) restoreCheckpoint(checkpoint layerCheckpoint) {
+	*i.counter = checkpoint.counter
+	i.pendingHardlinks = i.pendingHardlinks[:checkpoint.pendingHardlinks]
+	i.skippedLinks = checkpoint.skippedLinks
+	i.skippedXattrs = checkpoint.skippedXattrs
+	i.skippedOwners = checkpoint.skippedOwners
+	i.renamedEntries = checkpoint.renamedEntries
+	i.entries = checkpoint.entries
+}
+
+// retryableLayerError checks if the error reading a layer is a transient network failure, like a timeout, a
+// connection reset or a server error, after which downloading the layer again can succeed. Errors like missing
+// layers, failed authentication or a digest that does not match are permanent
+func retryableLayerError(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.StatusCode >= http.StatusInternalServerError || transportErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED)
+}
+
+// layerRetryDelay returns the wait before the provided retry, starting at 1, doubling the backoff for each retry
+func layerRetryDelay(backoff time.Duration, retry int) time.Duration {
+	delay := backoff
+	for n := 1; n < retry && delay < maxLayerRetryBackoff; n++ {
+		delay *= 2
+	}
+	if delay > maxLayerRetryBackoff {
+		return maxLayerRetryBackoff
+	}
+	return delay
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryableLayerError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{&transport.Error{StatusCode: http.StatusServiceUnavailable}, true},
+		{&transport.Error{StatusCode: http.StatusTooManyRequests}, true},
+		{&transport.Error{StatusCode: http.StatusNotFound}, false},
+		{&transport.Error{StatusCode: http.StatusUnauthorized}, false},
+		{fmt.Errorf("Downloading layer 'sha256:123': %w", &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}), true},
+		{io.ErrUnexpectedEOF, true},
+		{os.ErrDeadlineExceeded, true},
+		{fmt.Errorf("error verifying sha256 checksum"), false},
+		{fmt.Errorf("Downloading layer 'sha256:123': %s", io.ErrUnexpectedEOF), false},
+	} {
+		assert.Equal(t, tc.retryable, retryableLayerError(tc.err), fmt.Sprintf("checking error %s", tc.err))
+	}
+}
+
+func TestLayerRetryDelay(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, layerRetryDelay(100*time.Millisecond, 1))
+	assert.Equal(t, 400*time.Millisecond, layerRetryDelay(100*time.Millisecond, 3))
+	assert.Equal(t, maxLayerRetryBackoff, layerRetryDelay(time.Second, 10))
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// layerStoreCompareChunkSize size of the chunks compared between the content of an entry and its file in the store
+const layerStoreCompareChunkSize = 32 * 1024
+
+// layerStore directory of DirImageOpts.LayerStorePath, where the regular files extracted from each layer are kept
+// by layer digest and path, laid out as '<store>/sha256/<layer hex>/<path>'. The files of the output directories are
+// hardlinks to the files of the store, so that a layer shared by several images takes disk space only once
+//
+// Hardlinks share the mode, owner, times and extended attributes of the file, so a file of the store is only
+// linked when it already has the ones the extraction would set, otherwise the entry is written as a new file.
+// Files with extended attributes to restore are never linked. Failing to use the store never fails the extraction,
+// the entry is written as a new file instead, which is also what happens when the store and the output directory
+// are on different filesystems
+type layerStore struct {
+	dir string
+}
+
+// entryPath returns the path of the entry of the layer in the store
+func (s layerStore) entryPath(layerDigest string, components []string) (string, bool) {
+	algorithm, hex, found := strings.Cut(layerDigest, ":")
+	if !found || algorithm == "" || hex == "" {
+		return "", false
+	}
+	layerDir := filepath.Join(s.dir, algorithm, hex)
+	path := filepath.Join(append([]string{layerDir}, components...)...)
+	if path == layerDir || !isWithinDir(layerDir, path) {
+		return "", false
+	}
+	return path, true
+}
+
+func (s layerStore) tempDir() string { return filepath.Join(s.dir, "tmp") }
+
+// usesLayerStore checks if the regular file of the entry can be shared with the layer store
+func (i *DirImage) usesLayerStore(header *tar.Header) bool {
+	if i.opts.LayerStorePath == "" || i.layerDigest == "" {
+		return false
+	}
+	if i.opts.PreserveXattrs {
+		for key := range header.PAXRecords {
+			if strings.HasPrefix(key, paxXattrPrefix) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// linkFromLayerStore creates the file at path as a hardlink to the file of the store, when the store has the entry
+// with the same content and the metadata the extraction would set. The content of the entry is read in any case,
+// when the file is not linked the returned reader has the full content to write instead
+func (i *DirImage) linkFromLayerStore(header *tar.Header, path string, mode os.FileMode, content io.Reader) (bool, io.Reader) {
+	store := layerStore{i.opts.LayerStorePath}
+	storePath, ok := store.entryPath(i.layerDigest, i.imagePathComponents(header.Name))
+	if !ok {
+		return false, content
+	}
+	info, err := os.Lstat(longPath(storePath))
+	if err != nil || !info.Mode().IsRegular() || info.Size() != header.Size || !i.hasExtractedMetadata(header, info, mode) {
+		return false, content
+	}
+
+	file, err := os.Open(longPath(storePath))
+	if err != nil {
+		return false, content
+	}
+	same, remaining := sameContent(file, content)
+	if !same {
+		// the reader returned uses the file until the entry is written
+		return false, &closingReader{Reader: remaining, closer: file}
+	}
+
+	err = linkFile(longPath(storePath), longPath(path))
+	if err != nil {
+		// the content of the entry was already read, it is the same as the file of the store
+		return false, &closingReader{Reader: io.NewSectionReader(file, 0, info.Size()), closer: file}
+	}
+	_ = file.Close()
+	return true, nil
+}
+
+// addToLayerStore links the file extracted at path into the store, replacing the file the store had for the entry,
+// if any. Files the store cannot link, like when it is on another filesystem, are not added
+func (i *DirImage) addToLayerStore(header *tar.Header, path string) {
+	store := layerStore{i.opts.LayerStorePath}
+	storePath, ok := store.entryPath(i.layerDigest, i.imagePathComponents(header.Name))
+	if !ok {
+		return
+	}
+
+	err := os.MkdirAll(longPath(store.tempDir()), 0777)
+	if err != nil {
+		return
+	}
+	tempFile, err := os.CreateTemp(store.tempDir(), "entry-*")
+	if err != nil {
+		return
+	}
+	tempPath := tempFile.Name()
+	_ = tempFile.Close()
+	_ = os.Remove(tempPath)
+
+	// the file is linked into the temporary directory first, so that it shows up complete in the store
+	err = linkFile(longPath(path), longPath(tempPath))
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(longPath(filepath.Dir(storePath)), 0777)
+	if err == nil {
+		err = os.Rename(longPath(tempPath), longPath(storePath))
+	}
+	if err != nil {
+		_ = os.Remove(longPath(tempPath))
+	}
+}
+
+// hasExtractedMetadata checks if the file of the store has the mode, owner and modification time the entry gets
+// when it is extracted with the options of the extraction
+func (i *DirImage) hasExtractedMetadata(header *tar.Header, info os.FileInfo, mode os.FileMode) bool {
+	const modeBits = os.ModePerm | specialModeBits
+	// without preserving the permissions the file is created with the mode, which the umask applies to
+	expectedMode := i.fileMode(mode & modeBits)
+	if info.Mode()&modeBits != expectedMode {
+		return false
+	}
+
+	if !info.ModTime().Truncate(time.Second).Equal(header.ModTime.Truncate(time.Second)) {
+		return false
+	}
+
+	uid, gid, known := fileOwner(info)
+	if !known {
+		return true
+	}
+	expectedUID, expectedGID, err := i.extractedOwner(header)
+	if err != nil {
+		return false
+	}
+	return uid == expectedUID && gid == expectedGID
+}
+
+// extractedOwner returns the owner the entry gets when it is extracted, following the same rules as chown
+func (i *DirImage) extractedOwner(header *tar.Header) (int, int, error) {
+	if i.opts.Ownership.Mode == OwnershipCurrent {
+		return os.Getuid(), os.Getgid(), nil
+	}
+	if i.opts.Ownership.enabled() {
+		return i.opts.Ownership.owner(header.Name, header.Uid, header.Gid, os.Getuid(), os.Getgid())
+	}
+	if i.shouldChown {
+		return header.Uid, header.Gid, nil
+	}
+	return os.Getuid(), os.Getgid(), nil
+}
+
+// sameContent compares the content of the entry with the file, reading both until the end. When they differ,
+// the returned reader has the full content of the entry: the part read so far, which was the same as the file,
+// followed by the rest of the entry
+func sameContent(file *os.File, content io.Reader) (bool, io.Reader) {
+	entryChunk := make([]byte, layerStoreCompareChunkSize)
+	fileChunk := make([]byte, layerStoreCompareChunkSize)
+	var offset int64
+
+	for {
+		n, readErr := io.ReadFull(content, entryChunk)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return false, io.MultiReader(io.NewSectionReader(file, 0, offset), bytes.NewReader(entryChunk[:n]), &errReader{readErr})
+		}
+
+		m, fileErr := io.ReadFull(file, fileChunk[:n])
+		if fileErr != nil || !bytes.Equal(entryChunk[:n], fileChunk[:m]) {
+			return false, io.MultiReader(io.NewSectionReader(file, 0, offset), bytes.NewReader(entryChunk[:n]), content)
+		}
+		offset += int64(n)
+
+		if readErr != nil {
+			// the file must end with the entry
+			_, err := file.Read(fileChunk[:1])
+			if err != io.EOF {
+				return false, io.NewSectionReader(file, 0, offset)
+			}
+			return true, nil
+		}
+	}
+}
+
+// closingReader closes the file the reader depends on once it is read until the end
+type closingReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		_ = r.closer.Close()
+	}
+	return n, err
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(_ []byte) (int, error) { return 0, r.err }
@@ -0,0 +1,195 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LayersFile file written by AsLayerDirectories next to the layer directories, describing the layers of the image
+const LayersFile = "layers.json"
+
+// layerDirShortDigestLength number of characters of the layer digest used in the name of its directory
+const layerDirShortDigestLength = 12
+
+// layersFileContent content of the LayersFile, the layers are in the order of the image, from the oldest to the newest
+type layersFileContent struct {
+	Image  string         `json:"image"`
+	Layers []layerDirInfo `json:"layers"`
+}
+
+type layerDirInfo struct {
+	Index     int    `json:"index"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Directory string `json:"directory"`
+}
+
+// AsLayerDirectories extracts each layer of the image on its own into a '<index>-<short digest>' directory of the
+// output directory, the oldest layer having index 0, and writes the LayersFile. Whiteout files are extracted like
+// any other file instead of removing entries of older layers, so that the content of each layer can be inspected.
+// The output directory is always emptied first, and only the options about how entries are written apply,
+// IncludePaths, ExcludedPaths, Incremental, IncludeMetadata and ChecksumsPath are ignored
+func (i *DirImage) AsLayerDirectories() error {
+	err := i.checkExtractable()
+	if err != nil {
+		return err
+	}
+
+	layers, err := i.img.Layers()
+	if err != nil {
+		return err
+	}
+	digest, err := i.img.Digest()
+	if err != nil {
+		return err
+	}
+
+	if !i.opts.SkipSpaceCheck {
+		// the layers are written straight to their directories, none is spooled
+		err = checkDiskSpace(i.dirPath, layers, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.RemoveAll(i.dirPath)
+	if err != nil {
+		return fmt.Errorf("Removing layers directory: %s", err)
+	}
+	err = os.MkdirAll(i.dirPath, 0777)
+	if err != nil {
+		return fmt.Errorf("Creating layers directory: %s", err)
+	}
+	umask, err := currentUmask(i.dirPath)
+	if err != nil {
+		return err
+	}
+	i.umask = i.opts.DefaultPerms.umask(umask)
+
+	var compressedSize int64
+	for _, layer := range layers {
+		size, err := layer.Size()
+		if err != nil {
+			return err
+		}
+		compressedSize += size
+	}
+	// the limits apply to the whole image, not to each layer
+	counter := &extractCounter{limits: i.opts.Limits.withDefaults(compressedSize)}
+
+	content := layersFileContent{Image: digest.String()}
+	indexWidth := len(strconv.Itoa(len(layers) - 1))
+	for idx, layer := range layers {
+		info, err := layerInfo(idx, indexWidth, layer)
+		if err != nil {
+			return err
+		}
+
+		i.logger.Logf("Extracting layer '%s' (%d/%d) into '%s'\n", info.Digest, idx+1, len(layers), info.Directory)
+
+		layerImage := &DirImage{
+			dirPath:     filepath.Join(i.dirPath, info.Directory),
+			img:         i.img,
+			shouldChown: i.shouldChown,
+			opts:        i.opts,
+			logger:      i.logger,
+			umask:       i.umask,
+			counter:     counter,
+		}
+		err = layerImage.extractLayerDirectory(info.Digest, layer)
+		if err != nil {
+			return fmt.Errorf("Extracting layer '%s': %s", info.Digest, err)
+		}
+		content.Layers = append(content.Layers, info)
+	}
+
+	bs, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(i.dirPath, LayersFile), append(bs, '\n'), 0666)
+	if err != nil {
+		return fmt.Errorf("Writing layers file: %s", err)
+	}
+	return nil
+}
+
+func layerInfo(idx, indexWidth int, layer regv1.Layer) (layerDirInfo, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return layerDirInfo{}, err
+	}
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		return layerDirInfo{}, err
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return layerDirInfo{}, err
+	}
+
+	shortDigest := digest.Hex
+	if len(shortDigest) > layerDirShortDigestLength {
+		shortDigest = shortDigest[:layerDirShortDigestLength]
+	}
+	return layerDirInfo{
+		Index:     idx,
+		Digest:    digest.String(),
+		MediaType: string(mediaType),
+		Size:      size,
+		Directory: fmt.Sprintf("%0*d-%s", indexWidth, idx, shortDigest),
+	}, nil
+}
+
+// extractLayerDirectory extracts the layer into the output directory, with the same checks of the paths and types of
+// the entries as when the image is extracted, but keeping the whiteout files
+func (i *DirImage) extractLayerDirectory(digest string, layer regv1.Layer) error {
+	err := os.MkdirAll(i.dirPath, 0777)
+	if err != nil {
+		return err
+	}
+
+	if !i.opts.AllowCaseCollisions {
+		caseInsensitive, err := detectCaseInsensitive(i.dirPath)
+		if err != nil {
+			return err
+		}
+		if caseInsensitive {
+			i.caseCollisions = newCaseCollisions()
+		}
+	}
+	i.matchedPatterns = map[int]bool{}
+	i.skippedPaths = map[string]bool{}
+	i.extractedPaths = map[string]int{}
+	i.dirs = map[string]dirMetadata{}
+
+	whiteouts := newRawWhiteouts()
+	whiteouts.NextLayer()
+	err = i.extractLayer(whiteouts, digest, layer)
+	if err != nil {
+		return err
+	}
+
+	i.skipHardlinksToSkippedPaths()
+	if len(i.pendingHardlinks) > 0 {
+		// the target can be in an older layer, which is extracted into another directory
+		i.logger.Logf("Warning: Skipped %d hardlink(s) whose target is not in the layer, first one was '%s'\n",
+			len(i.pendingHardlinks), i.pendingHardlinks[0].header.Name)
+	}
+
+	err = i.applyDirMetadata()
+	if err != nil {
+		return err
+	}
+	i.logSkippedEntries()
+	return nil
+}
@@ -0,0 +1,11 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image
+
+// longPath only changes paths on Windows
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package image
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath length from which paths need the extended-length prefix, directories are limited to MAX_PATH (260)
+// minus the 12 characters of a file name in 8.3 format
+const maxPath = 248
+
+// longPath adds the extended-length prefix to paths longer than MAX_PATH, which most Windows APIs reject otherwise
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
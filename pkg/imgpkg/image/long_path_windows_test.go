@@ -0,0 +1,39 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongPath(t *testing.T) {
+	t.Run("it keeps the paths shorter than MAX_PATH", func(t *testing.T) {
+		assert.Equal(t, `C:\out\file.txt`, longPath(`C:\out\file.txt`))
+	})
+
+	t.Run("it adds the extended-length prefix to the paths longer than MAX_PATH", func(t *testing.T) {
+		path := `C:\out\` + strings.Repeat("a", 300)
+		assert.Equal(t, `\\?\`+path, longPath(path))
+		assert.Equal(t, `\\?\`+path, longPath(`\\?\`+path))
+	})
+
+	t.Run("it uses the UNC prefix for network paths", func(t *testing.T) {
+		path := `\\server\share\` + strings.Repeat("a", 300)
+		assert.Equal(t, `\\?\UNC\server\share\`+strings.Repeat("a", 300), longPath(path))
+	})
+
+	t.Run("it creates files with paths longer than MAX_PATH", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), strings.Repeat("a", 100), strings.Repeat("b", 100), strings.Repeat("c", 100))
+		require.NoError(t, os.MkdirAll(longPath(filepath.Dir(path)), 0777))
+		require.NoError(t, os.WriteFile(longPath(path), []byte("content"), 0666))
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MetadataDir directory written in the output directory with the manifest, config and digest of the extracted
+// image. It is not part of the image and directories with this name are never pushed
+const MetadataDir = ".imgpkg-meta"
+
+const (
+	metadataManifestFile = "manifest.json"
+	metadataConfigFile   = "config.json"
+	metadataDigestFile   = "digest"
+)
+
+// metadataPath returns the directory where the image metadata is written
+func (i *DirImage) metadataPath() string {
+	if i.opts.MetadataPath != "" {
+		return i.opts.MetadataPath
+	}
+	return filepath.Join(i.dirPath, MetadataDir)
+}
+
+// writeMetadata writes the manifest, config and digest of the image, as they are in the registry
+func (i *DirImage) writeMetadata() error {
+	manifest, err := i.img.RawManifest()
+	if err != nil {
+		return err
+	}
+	config, err := i.img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	digest, err := i.img.Digest()
+	if err != nil {
+		return err
+	}
+
+	dir := i.metadataPath()
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return fmt.Errorf("Creating metadata directory: %s", err)
+	}
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{metadataManifestFile, manifest},
+		{metadataConfigFile, config},
+		{metadataDigestFile, []byte(digest.String() + "\n")},
+	}
+	for _, file := range files {
+		err := os.WriteFile(filepath.Join(dir, file.name), file.content, 0666)
+		if err != nil {
+			return fmt.Errorf("Writing image metadata: %s", err)
+		}
+	}
+	return nil
+}
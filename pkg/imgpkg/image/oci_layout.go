@@ -0,0 +1,227 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// OCILayoutRefNameAnnotation annotation of the descriptors of the index of an OCI image layout with the reference the
+// image is meant for
+const OCILayoutRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// WriteOCILayout writes the manifest, config and compressed layer blobs of img as an OCI image layout in dirPath,
+// replacing its content. The digest of each layer is verified while it is written, and the layout is written in a
+// temporary directory next to dirPath first, so that a failed write keeps the previous content.
+// When ctx is provided the write stops at the next read of a layer once ctx is done, and the temporary directory is
+// removed. The interruption signals are then left to the caller, as with DirImageOpts.Context, otherwise the
+// temporary directory is removed when the process is interrupted
+func WriteOCILayout(ctx context.Context, dirPath string, img regv1.Image) error {
+	return writeOCILayout(ctx, dirPath, func(ctx context.Context, layoutPath layout.Path) error {
+		return appendVerifiedImage(ctx, layoutPath, img, true)
+	})
+}
+
+// WriteOCILayoutWithRefName writes img as WriteOCILayout does, recording refName in the OCILayoutRefNameAnnotation of
+// its descriptor, so that it can be copied to that reference later
+func WriteOCILayoutWithRefName(ctx context.Context, dirPath string, img regv1.Image, refName string) error {
+	return writeOCILayout(ctx, dirPath, func(ctx context.Context, layoutPath layout.Path) error {
+		return appendVerifiedImage(ctx, layoutPath, img, true, layout.WithAnnotations(map[string]string{OCILayoutRefNameAnnotation: refName}))
+	})
+}
+
+// WriteOCILayoutIndex writes the image index, and all the images it references, as an OCI image layout in dirPath,
+// the same way WriteOCILayout does
+func WriteOCILayoutIndex(ctx context.Context, dirPath string, index regv1.ImageIndex) error {
+	return writeOCILayout(ctx, dirPath, func(ctx context.Context, layoutPath layout.Path) error {
+		return appendVerifiedIndex(ctx, layoutPath, index, true, nil)
+	})
+}
+
+// OCILayoutEntry image, or image index, of an OCI image layout, with the annotations of its descriptor in the index of
+// the layout
+type OCILayoutEntry struct {
+	Image       regv1.Image
+	Index       regv1.ImageIndex
+	Annotations map[string]string
+}
+
+// WriteOCILayoutEntries writes the images and image indexes of entries as an OCI image layout in dirPath, the same way
+// WriteOCILayout does, the blobs they share being written once. Non-distributable layers are only written when
+// includeNonDistributable is true, the manifests referencing them either way so that the digests are kept
+func WriteOCILayoutEntries(ctx context.Context, dirPath string, entries []OCILayoutEntry, includeNonDistributable bool) error {
+	return writeOCILayout(ctx, dirPath, func(ctx context.Context, layoutPath layout.Path) error {
+		for _, entry := range entries {
+			var err error
+			if entry.Index != nil {
+				err = appendVerifiedIndex(ctx, layoutPath, entry.Index, includeNonDistributable, entry.Annotations)
+			} else {
+				err = appendVerifiedImage(ctx, layoutPath, entry.Image, includeNonDistributable, layout.WithAnnotations(entry.Annotations))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func writeOCILayout(ctx context.Context, dirPath string, write func(context.Context, layout.Path) error) error {
+	finalPath := filepath.Clean(dirPath)
+	tmpPath, err := createSiblingDir(finalPath)
+	if err != nil {
+		return fmt.Errorf("Creating OCI image layout directory: %s", err)
+	}
+	if ctx == nil {
+		stopCleanup := removeOnInterrupt(tmpPath)
+		defer stopCleanup()
+		ctx = context.Background()
+	}
+
+	layoutPath, err := layout.Write(tmpPath, empty.Index)
+	if err == nil {
+		err = write(ctx, layoutPath)
+	}
+	if err == nil {
+		// the layout is complete, but the caller is stopping
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmpPath)
+		return fmt.Errorf("Writing OCI image layout: %s", err)
+	}
+
+	err = os.RemoveAll(finalPath)
+	if err != nil {
+		_ = os.RemoveAll(tmpPath)
+		return fmt.Errorf("Removing output directory: %s", err)
+	}
+	return os.Rename(tmpPath, finalPath)
+}
+
+// writeVerifiedIndex writes the blobs of the images referenced by the index, of its nested indexes, and the manifest
+// of the index
+func writeVerifiedIndex(ctx context.Context, layoutPath layout.Path, index regv1.ImageIndex, includeNonDistributable bool) error {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range manifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			nestedIndex, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			err = writeVerifiedIndex(ctx, layoutPath, nestedIndex, includeNonDistributable)
+			if err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			img, err := index.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			verified, err := verifiedImage(ctx, img, includeNonDistributable)
+			if err != nil {
+				return err
+			}
+			err = layoutPath.WriteImage(verified)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		return err
+	}
+	rawManifest, err := index.RawManifest()
+	if err != nil {
+		return err
+	}
+	return layoutPath.WriteBlob(digest, io.NopCloser(bytes.NewReader(rawManifest)))
+}
+
+// appendVerifiedIndex writes the index as writeVerifiedIndex does, and adds it to the index of the layout with annotations
+func appendVerifiedIndex(ctx context.Context, layoutPath layout.Path, index regv1.ImageIndex, includeNonDistributable bool, annotations map[string]string) error {
+	err := writeVerifiedIndex(ctx, layoutPath, index, includeNonDistributable)
+	if err != nil {
+		return err
+	}
+
+	mediaType, err := index.MediaType()
+	if err != nil {
+		return err
+	}
+	digest, err := index.Digest()
+	if err != nil {
+		return err
+	}
+	size, err := index.Size()
+	if err != nil {
+		return err
+	}
+	return layoutPath.AppendDescriptor(regv1.Descriptor{MediaType: mediaType, Digest: digest, Size: size, Annotations: annotations})
+}
+
+func appendVerifiedImage(ctx context.Context, layoutPath layout.Path, img regv1.Image, includeNonDistributable bool, options ...layout.Option) error {
+	verified, err := verifiedImage(ctx, img, includeNonDistributable)
+	if err != nil {
+		return err
+	}
+	return layoutPath.AppendImage(verified, options...)
+}
+
+// verifiedImage returns the image with layers whose compressed content is checked against their digest when read, and
+// stops being read once ctx is done. The non-distributable layers are left out unless includeNonDistributable is true
+func verifiedImage(ctx context.Context, img regv1.Image, includeNonDistributable bool) (regv1.Image, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	var verifiedLayers []regv1.Layer
+	for _, imgLayer := range layers {
+		if !includeNonDistributable {
+			mediaType, err := imgLayer.MediaType()
+			if err != nil {
+				return nil, err
+			}
+			if !mediaType.IsDistributable() {
+				continue
+			}
+		}
+		digest, err := imgLayer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		layer, err := partial.CompressedToLayer(&verifiedLayer{layer: imgLayer, digest: digest, ctx: ctx})
+		if err != nil {
+			return nil, err
+		}
+		verifiedLayers = append(verifiedLayers, layer)
+	}
+	return &layersImage{Image: img, layers: verifiedLayers}, nil
+}
+
+// layersImage image whose layers are replaced by the provided ones
+type layersImage struct {
+	regv1.Image
+	layers []regv1.Layer
+}
+
+func (i *layersImage) Layers() ([]regv1.Layer, error) { return i.layers, nil }
@@ -0,0 +1,121 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOCILayout(t *testing.T) {
+	img := imageFromLayers(t, []tarEntry{fileEntry("older.txt", "older")}, []tarEntry{fileEntry("newer.txt", "newer")})
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+
+	t.Run("it writes the image with its blobs, replacing the content of the directory", func(t *testing.T) {
+		folder := filepath.Join(t.TempDir(), "layout")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "previous.txt"), []byte("previous"), 0600))
+
+		require.NoError(t, image.WriteOCILayout(context.Background(), folder, img))
+
+		assert.FileExists(t, filepath.Join(folder, "oci-layout"))
+		assert.NoFileExists(t, filepath.Join(folder, "previous.txt"))
+
+		index, err := layout.ImageIndexFromPath(folder)
+		require.NoError(t, err)
+		manifest, err := index.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, manifest.Manifests, 1)
+		assert.Equal(t, imgDigest, manifest.Manifests[0].Digest)
+
+		writtenImg, err := index.Image(imgDigest)
+		require.NoError(t, err)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			require.NoError(t, err)
+			_, err = writtenImg.LayerByDigest(digest)
+			require.NoError(t, err)
+			assert.FileExists(t, filepath.Join(folder, "blobs", digest.Algorithm, digest.Hex))
+		}
+	})
+
+	t.Run("it writes the image index with all its images", func(t *testing.T) {
+		index, err := random.Index(10, 2, 3)
+		require.NoError(t, err)
+		folder := filepath.Join(t.TempDir(), "layout")
+
+		require.NoError(t, image.WriteOCILayoutIndex(context.Background(), folder, index))
+
+		writtenIndex, err := layout.ImageIndexFromPath(folder)
+		require.NoError(t, err)
+		indexDigest, err := index.Digest()
+		require.NoError(t, err)
+		nestedIndex, err := writtenIndex.ImageIndex(indexDigest)
+		require.NoError(t, err)
+		manifest, err := nestedIndex.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, manifest.Manifests, 3)
+		for _, desc := range manifest.Manifests {
+			writtenImg, err := nestedIndex.Image(desc.Digest)
+			require.NoError(t, err)
+			layers, err := writtenImg.Layers()
+			require.NoError(t, err)
+			for _, layer := range layers {
+				digest, err := layer.Digest()
+				require.NoError(t, err)
+				assert.FileExists(t, filepath.Join(folder, "blobs", digest.Algorithm, digest.Hex))
+			}
+		}
+	})
+
+	t.Run("it fails when the content of a layer does not match its digest, keeping the previous content", func(t *testing.T) {
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		otherLayers, err := imageFromLayers(t, []tarEntry{fileEntry("other.txt", "other")}).Layers()
+		require.NoError(t, err)
+		corruptedImg, err := mutate.AppendLayers(empty.Image, corruptedLayer{Layer: layers[0], content: otherLayers[0]})
+		require.NoError(t, err)
+
+		folder := filepath.Join(t.TempDir(), "layout")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "previous.txt"), []byte("previous"), 0600))
+
+		err = image.WriteOCILayout(context.Background(), folder, corruptedImg)
+		require.ErrorContains(t, err, "Expected layer digest to be")
+		assert.FileExists(t, filepath.Join(folder, "previous.txt"))
+
+		entries, err := os.ReadDir(filepath.Dir(folder))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "the temporary directory is removed")
+	})
+
+	t.Run("it stops when the context is done, keeping the previous content", func(t *testing.T) {
+		folder := filepath.Join(t.TempDir(), "layout")
+		require.NoError(t, os.MkdirAll(folder, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "previous.txt"), []byte("previous"), 0600))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := image.WriteOCILayout(ctx, folder, img)
+		require.ErrorContains(t, err, "context canceled")
+		assert.FileExists(t, filepath.Join(folder, "previous.txt"))
+
+		entries, err := os.ReadDir(filepath.Dir(folder))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "the temporary directory is removed")
+	})
+}
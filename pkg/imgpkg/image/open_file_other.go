@@ -0,0 +1,14 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !freebsd
+
+package image
+
+import "os"
+
+// openNoFollow creates, or truncates, the regular file at path. Symlinks are never extracted on Windows, so the
+// only symlinks in the output directory are the ones that existed before the extraction
+func openNoFollow(path string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+}
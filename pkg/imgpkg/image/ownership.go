@@ -0,0 +1,116 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDMap maps a range of user or group ids from the image to ids in the host, the same way user namespaces do
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// ParseIDMap parses a mapping in the format container-id:host-id:size
+func ParseIDMap(value string) (IDMap, error) {
+	pieces := strings.Split(value, ":")
+	if len(pieces) != 3 {
+		return IDMap{}, fmt.Errorf("Expected id mapping '%s' to be in the format container-id:host-id:size", value)
+	}
+
+	var ids []int
+	for _, piece := range pieces {
+		id, err := strconv.Atoi(piece)
+		if err != nil || id < 0 {
+			return IDMap{}, fmt.Errorf("Expected id mapping '%s' to only contain positive numbers", value)
+		}
+		ids = append(ids, id)
+	}
+	if ids[2] == 0 {
+		return IDMap{}, fmt.Errorf("Expected id mapping '%s' size to be greater than 0", value)
+	}
+
+	return IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// OwnershipMode decides if the extracted entries are owned by the owner recorded in the image
+type OwnershipMode string
+
+const (
+	// OwnershipAuto sets the owner from the image only when running as root, failing to set it is only a warning.
+	// It is the mode used when none is provided
+	OwnershipAuto OwnershipMode = "auto"
+	// OwnershipPreserve always sets the owner from the image, failing when it cannot be set
+	OwnershipPreserve OwnershipMode = "preserve"
+	// OwnershipCurrent never changes the owner, the entries are owned by the invoking user
+	OwnershipCurrent OwnershipMode = "current"
+)
+
+// ParseOwnershipMode parses one of the supported ownership modes
+func ParseOwnershipMode(value string) (OwnershipMode, error) {
+	switch mode := OwnershipMode(value); mode {
+	case OwnershipAuto, OwnershipPreserve, OwnershipCurrent:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("Expected ownership mode '%s' to be one of %s, %s or %s", value, OwnershipPreserve, OwnershipCurrent, OwnershipAuto)
+	}
+}
+
+// OwnershipOpts change the owner of the extracted entries, instead of using the owner recorded in the image.
+// When provided the owner is changed even if imgpkg is not running as root
+type OwnershipOpts struct {
+	// Mode decides if the owner recorded in the image is used, defaults to OwnershipAuto.
+	// The maps and Chown cannot be used with OwnershipCurrent
+	Mode OwnershipMode
+	// UIDMaps and GIDMaps map the ids of the owner in the image to ids in the host
+	UIDMaps []IDMap
+	GIDMaps []IDMap
+	// StrictIDMaps fails when an id of the image is not in the maps, instead of using the id of the invoking user
+	StrictIDMaps bool
+	// Chown when set every entry is owned by UID and GID, the maps are ignored
+	Chown bool
+	UID   int
+	GID   int
+}
+
+// enabled checks if the owner of the entries is changed
+func (o OwnershipOpts) enabled() bool {
+	return o.Chown || len(o.UIDMaps) > 0 || len(o.GIDMaps) > 0
+}
+
+// owner returns the owner in the host of an entry owned by uid and gid in the image
+func (o OwnershipOpts) owner(name string, uid, gid int, invokingUID, invokingGID int) (int, int, error) {
+	if o.Chown {
+		return o.UID, o.GID, nil
+	}
+
+	hostUID, err := o.mapID(name, "uid", uid, o.UIDMaps, invokingUID)
+	if err != nil {
+		return 0, 0, err
+	}
+	hostGID, err := o.mapID(name, "gid", gid, o.GIDMaps, invokingGID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return hostUID, hostGID, nil
+}
+
+func (o OwnershipOpts) mapID(name, kind string, id int, maps []IDMap, invokingID int) (int, error) {
+	if len(maps) == 0 {
+		return id, nil
+	}
+	for _, idMap := range maps {
+		if id >= idMap.ContainerID && id < idMap.ContainerID+idMap.Size {
+			return idMap.HostID + id - idMap.ContainerID, nil
+		}
+	}
+	if o.StrictIDMaps {
+		return 0, fmt.Errorf("Entry '%s' %s %d is not in any of the provided %s mappings", name, kind, id, kind)
+	}
+	return invokingID, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"path"
+)
+
+// PathGlobs matches paths in the image, that always use forward slashes, against a list of glob patterns.
+// Patterns use the path.Match syntax for each segment, and a '**' segment matches any number of segments
+type PathGlobs struct {
+	patterns [][]string
+}
+
+// NewPathGlobs validates the provided patterns and creates a PathGlobs
+func NewPathGlobs(patterns []string) (PathGlobs, error) {
+	globs := PathGlobs{}
+	for _, pattern := range patterns {
+		segments := splitImageName(pattern)
+		if len(segments) == 0 {
+			return PathGlobs{}, fmt.Errorf("Invalid path pattern '%s': pattern is empty", pattern)
+		}
+		for _, segment := range segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return PathGlobs{}, fmt.Errorf("Invalid path pattern '%s': %s", pattern, err)
+			}
+		}
+		globs.patterns = append(globs.patterns, segments)
+	}
+	return globs, nil
+}
+
+// Empty returns true when there are no patterns
+func (g PathGlobs) Empty() bool {
+	return len(g.patterns) == 0
+}
+
+// MatchedPatterns returns the indexes of the patterns that match name, or a directory that contains name
+func (g PathGlobs) MatchedPatterns(name string) []int {
+	segments := splitImageName(name)
+	var matched []int
+	for idx, pattern := range g.patterns {
+		for count := 1; count <= len(segments); count++ {
+			if matchSegments(pattern, segments[:count]) {
+				matched = append(matched, idx)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for skip := 0; skip <= len(segments); skip++ {
+			if matchSegments(pattern[1:], segments[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	// patterns were validated on creation
+	matched, _ := path.Match(pattern[0], segments[0])
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
+
+// splitImageName splits a name from the image, or a pattern, in its segments
+// ignoring empty segments and the leading '/' or './'
+func splitImageName(name string) []string {
+	var segments []string
+	for _, segment := range splitImagePath(name) {
+		if segment == "" || segment == "." {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
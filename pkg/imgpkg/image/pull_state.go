@@ -0,0 +1,115 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PullStateFile file written in the output directory by incremental pulls, with the image that was extracted.
+// It is not part of the image and is never pushed
+const PullStateFile = ".imgpkg-pull-state"
+
+// pullState image and options used to extract the output directory
+type pullState struct {
+	Digest  string           `json:"digest"`
+	Options pullStateDirOpts `json:"options"`
+}
+
+// pullStateDirOpts options that change the content extracted from the same image
+type pullStateDirOpts struct {
+	PreserveSymlinks    bool          `json:"preserveSymlinks,omitempty"`
+	PreservePermissions bool          `json:"preservePermissions,omitempty"`
+	DefaultPerms        DefaultPerms  `json:"defaultPerms"`
+	PreserveSpecialBits bool          `json:"preserveSpecialBits,omitempty"`
+	PreserveXattrs      bool          `json:"preserveXattrs,omitempty"`
+	AllowDevices        bool          `json:"allowDevices,omitempty"`
+	IncludePaths        []string      `json:"includePaths,omitempty"`
+	ExcludedPaths       []string      `json:"excludedPaths,omitempty"`
+	Ownership           OwnershipOpts `json:"ownership"`
+	AllowCaseCollisions bool          `json:"allowCaseCollisions,omitempty"`
+	IncludeMetadata     bool          `json:"includeMetadata,omitempty"`
+	MetadataPath        string        `json:"metadataPath,omitempty"`
+	WindowsSafeNames    bool          `json:"windowsSafeNames,omitempty"`
+	ChecksumsPath       string        `json:"checksumsPath,omitempty"`
+}
+
+// pullState returns the state recorded after the image is extracted with the current options
+func (i *DirImage) pullState() (pullState, error) {
+	digest, err := i.img.Digest()
+	if err != nil {
+		return pullState{}, err
+	}
+
+	return pullState{
+		Digest: digest.String(),
+		Options: pullStateDirOpts{
+			PreserveSymlinks:    i.opts.PreserveSymlinks,
+			PreservePermissions: i.opts.PreservePermissions,
+			DefaultPerms:        i.opts.DefaultPerms,
+			PreserveSpecialBits: i.opts.PreserveSpecialBits,
+			PreserveXattrs:      i.opts.PreserveXattrs,
+			AllowDevices:        i.opts.AllowDevices,
+			IncludePaths:        i.opts.IncludePaths,
+			ExcludedPaths:       i.opts.ExcludedPaths,
+			Ownership:           i.opts.Ownership,
+			AllowCaseCollisions: i.opts.AllowCaseCollisions,
+			IncludeMetadata:     i.opts.IncludeMetadata,
+			MetadataPath:        i.opts.MetadataPath,
+			WindowsSafeNames:    i.opts.WindowsSafeNames,
+			ChecksumsPath:       i.opts.ChecksumsPath,
+		},
+	}, nil
+}
+
+// unchangedPullState checks if the output directory was already extracted from the same image with the same options,
+// and returns the digest of the image
+func (i *DirImage) unchangedPullState() (bool, string, error) {
+	state, err := i.pullState()
+	if err != nil {
+		return false, "", err
+	}
+	current, err := json.Marshal(state)
+	if err != nil {
+		return false, "", err
+	}
+
+	recorded, err := os.ReadFile(filepath.Join(i.dirPath, PullStateFile))
+	if err != nil {
+		// a missing or unreadable state only means the image is extracted again
+		return false, state.Digest, nil
+	}
+	return string(recorded) == string(current), state.Digest, nil
+}
+
+// writePullState records the image extracted into the output directory
+func (i *DirImage) writePullState() error {
+	state, err := i.pullState()
+	if err != nil {
+		return err
+	}
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(i.dirPath, PullStateFile), stateBytes, 0600)
+	if err != nil {
+		return fmt.Errorf("Writing pull state: %s", err)
+	}
+	return nil
+}
+
+// removePullState removes the recorded state before the output directory is changed, so that it never describes
+// content that was only partially extracted
+func (i *DirImage) removePullState() error {
+	err := os.Remove(filepath.Join(i.dirPath, PullStateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Removing pull state: %s", err)
+	}
+	return nil
+}
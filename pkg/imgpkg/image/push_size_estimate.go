@@ -0,0 +1,135 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// sizeSampleChunks number of the chunks of the tar of the layer compressed to estimate its compressed size
+	sizeSampleChunks = 64
+	// sizeSampleChunkSize size of each of those chunks, the whole tar being compressed when it is smaller than all of them
+	sizeSampleChunkSize = 128 * 1024
+)
+
+// PushSizeEstimate size of the files an image is built with, and of its compressed layer, known before it is uploaded
+type PushSizeEstimate struct {
+	// Files number of files
+	Files int
+	// Size size of the files together
+	Size int64
+	// CompressedSize size of the compressed layer. It is estimated by compressing chunks of the layer unless Accurate
+	CompressedSize int64
+	// Accurate CompressedSize is the size of the whole layer compressed, see FileImageOpts.AccurateSize
+	Accurate bool
+}
+
+// reportSizeEstimate reports to opts.OnSizeEstimated the size of the files added to img
+func (i *TarImage) reportSizeEstimate(img *FileImage, opts FileImageOpts) error {
+	if opts.OnSizeEstimated == nil {
+		return nil
+	}
+
+	estimate := PushSizeEstimate{Files: len(i.pushedFiles)}
+	for _, file := range i.pushedFiles {
+		estimate.Size += file.size
+	}
+
+	var err error
+	estimate.CompressedSize, estimate.Accurate, err = img.compressedSize(opts)
+	if err != nil {
+		return fmt.Errorf("Estimating the compressed size of the layer: %s", err)
+	}
+	opts.OnSizeEstimated(estimate)
+	return nil
+}
+
+// compressedSize returns the size of the compressed layer of the image, and whether it is exact. The size of the
+// layers compressed when they are built, or reused from a LayerCache, is known, the other layers are only compressed
+// when they are uploaded, so their size is estimated from chunks of the tar, unless accurate
+func (i *FileImage) compressedSize(opts FileImageOpts) (int64, bool, error) {
+	layers, err := i.Layers()
+	if err != nil {
+		return 0, false, err
+	}
+
+	if opts.AccurateSize || i.compressedPath != "" || i.path == "" {
+		// compresses the layer to a counting writer when it is only compressed when uploaded
+		size, err := layers[0].Size()
+		return size, true, err
+	}
+
+	compression, level, err := opts.compression()
+	if err != nil {
+		return 0, false, err
+	}
+	size, err := estimateCompressedSize(i.path, compression, level)
+	return size, false, err
+}
+
+// estimateCompressedSize estimates the size of the tar at path once compressed, from the ratio of sizeSampleChunks
+// chunks spread evenly across it
+func estimateCompressedSize(path string, compression Compression, level int) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() == 0 {
+		return 0, nil
+	}
+
+	counter := &countingWriter{}
+	encoder, err := newCompressor(counter, compression, level)
+	if err != nil {
+		return 0, err
+	}
+
+	var sampled int64
+	stride := info.Size() / sizeSampleChunks
+	for idx := int64(0); idx < sizeSampleChunks && sampled < info.Size(); idx++ {
+		offset := sampled
+		if stride > sizeSampleChunkSize {
+			offset = idx * stride
+		}
+		n, err := io.Copy(encoder, io.NewSectionReader(file, offset, sizeSampleChunkSize))
+		if err != nil {
+			_ = encoder.Close()
+			return 0, err
+		}
+		sampled += n
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return 0, err
+	}
+	return int64(float64(counter.count) / float64(sampled) * float64(info.Size())), nil
+}
+
+// String describes the estimate, as push reports it before uploading
+func (e PushSizeEstimate) String() string {
+	compressed := "estimated compressed: ~" + formatBytes(uint64(e.CompressedSize))
+	if e.Accurate {
+		compressed = "compressed: " + formatBytes(uint64(e.CompressedSize))
+	}
+	return fmt.Sprintf("%s across %s file(s), %s", formatBytes(uint64(e.Size)), formatCount(e.Files), compressed)
+}
+
+// formatCount formats count with a comma between each group of thousands
+func formatCount(count int) string {
+	digits := fmt.Sprintf("%d", count)
+	for idx := len(digits) - 3; idx > 0; idx -= 3 {
+		digits = digits[:idx] + "," + digits[idx:]
+	}
+	return digits
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultWarnTotalSize total size of the files of an image above which a warning is logged, when no maximum
+	// total size is provided
+	DefaultWarnTotalSize = 1000 * 1000 * 1000
+	// maxLargestFiles number of the largest files listed when the files are over a limit
+	maxLargestFiles = 10
+)
+
+// PushSizeLimits bound the size of the files an image is built with. The files are checked once the paths left out
+// are known, and before the image is built, so that nothing is uploaded when they are too large. Zero disables a limit
+type PushSizeLimits struct {
+	// MaxFileSize maximum size of a single file
+	MaxFileSize int64
+	// MaxTotalSize maximum size of all the files together. A warning is logged above DefaultWarnTotalSize when not provided
+	MaxTotalSize int64
+}
+
+// pushedFile file added to the image, at path, with its size
+type pushedFile struct {
+	path string
+	size int64
+}
+
+// check checks the files against the limits, listing the largest files over them so that they can be left out
+func (l PushSizeLimits) check(files []pushedFile, logger Logger) error {
+	sort.SliceStable(files, func(i, j int) bool { return files[i].size > files[j].size })
+
+	var totalSize int64
+	var tooLarge []pushedFile
+	for _, file := range files {
+		totalSize += file.size
+		if l.MaxFileSize > 0 && file.size > l.MaxFileSize {
+			tooLarge = append(tooLarge, file)
+		}
+	}
+
+	hint := fmt.Sprintf("(hint: Use --file-exclusion or %s to leave files out)", IgnoreFile)
+	if len(tooLarge) > 0 {
+		return fmt.Errorf("Expected files pushed to be at most %s (--max-file-size), but %d file(s) are larger:\n%s\n%s",
+			formatBytes(uint64(l.MaxFileSize)), len(tooLarge), largestFiles(tooLarge), hint)
+	}
+	if l.MaxTotalSize > 0 && totalSize > l.MaxTotalSize {
+		return fmt.Errorf("Expected files pushed to be at most %s in total (--max-total-size), but they are %s. The largest are:\n%s\n%s",
+			formatBytes(uint64(l.MaxTotalSize)), formatBytes(uint64(totalSize)), largestFiles(files), hint)
+	}
+	if l.MaxTotalSize == 0 && totalSize > DefaultWarnTotalSize {
+		logger.Logf("Warning: Pushing %s of files, the largest being:\n%s\n(hint: Use --max-total-size to fail instead, or --file-exclusion or %s to leave files out)\n",
+			formatBytes(uint64(totalSize)), largestFiles(files), IgnoreFile)
+	}
+	return nil
+}
+
+// largestFiles lists the first maxLargestFiles of files, sorted from the largest
+func largestFiles(files []pushedFile) string {
+	var lines []string
+	for idx, file := range files {
+		if idx == maxLargestFiles {
+			lines = append(lines, fmt.Sprintf("- and %d more", len(files)-maxLargestFiles))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", file.path, formatBytes(uint64(file.size))))
+	}
+	return strings.Join(lines, "\n")
+}
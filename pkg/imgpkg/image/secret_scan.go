@@ -0,0 +1,151 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxSecretScanBytes bytes of each file scanned for secrets, the rest of larger files is not, so that the time the
+	// scan takes does not depend on the size of the files
+	maxSecretScanBytes = 1000 * 1000
+	// secretScanOverlap bytes of the content read before kept when scanning what is read next, so that secrets read
+	// in two parts are found. It is larger than the secrets of secretRules
+	secretScanOverlap = 256
+)
+
+// secretRule secrets that match pattern, reported with name
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// secretRules the secrets files are scanned for, whose format makes them unlikely to be anything else
+var secretRules = []secretRule{
+	{name: "aws-access-key-id", pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "private-key", pattern: regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`)},
+	{name: "github-token", pattern: regexp.MustCompile(`\b(gh[pousr]_[0-9A-Za-z]{36}|github_pat_[0-9A-Za-z_]{82})\b`)},
+	{name: "gitlab-token", pattern: regexp.MustCompile(`\bglpat-[0-9A-Za-z_-]{20}\b`)},
+	{name: "slack-token", pattern: regexp.MustCompile(`\bxox[abposr]-[0-9A-Za-z-]{10,72}`)},
+	{name: "google-api-key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "stripe-secret-key", pattern: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,99}\b`)},
+}
+
+// SecretScanOpts scan of the files added to an image for secrets, such as credentials in a .env file, so that the
+// image is not pushed with them. The first maxSecretScanBytes of each file are scanned while they are added
+type SecretScanOpts struct {
+	// Enabled scans the files, nothing is scanned otherwise
+	Enabled bool
+	// AllowedPaths globs of the paths in the image, with '/', that are not scanned. A glob matching a directory
+	// matches all its content, and a glob without '/' matches the name of a file or directory at any depth
+	AllowedPaths []string
+}
+
+// secretScan secrets found in the files added to an image, nil when the files are not scanned
+type secretScan struct {
+	opts  SecretScanOpts
+	files []*secretFileScan
+}
+
+func newSecretScan(opts SecretScanOpts) *secretScan {
+	if !opts.Enabled {
+		return nil
+	}
+	return &secretScan{opts: opts}
+}
+
+// scan returns a reader of content, the file at relPath in the image, that scans what is read
+func (s *secretScan) scan(relPath string, content io.Reader) io.Reader {
+	if s == nil || s.allowed(relPath) {
+		return content
+	}
+	file := &secretFileScan{relPath: relPath, content: content, rules: map[string]bool{}}
+	s.files = append(s.files, file)
+	return file
+}
+
+// allowed checks if relPath, or one of the directories it is in, matches one of the globs of SecretScanOpts.AllowedPaths
+func (s *secretScan) allowed(relPath string) bool {
+	for _, glob := range s.opts.AllowedPaths {
+		glob = strings.TrimSuffix(glob, "/")
+		for p := relPath; p != "." && p != "/"; p = path.Dir(p) {
+			name := p
+			if !strings.Contains(glob, "/") {
+				name = path.Base(p)
+			}
+			if matched, _ := path.Match(glob, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// err returns the files with secrets and the rules they matched, nil when no secret was found
+func (s *secretScan) err() error {
+	if s == nil {
+		return nil
+	}
+
+	var found []string
+	for _, file := range s.files {
+		if len(file.rules) == 0 {
+			continue
+		}
+		var rules []string
+		for rule := range file.rules {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		found = append(found, fmt.Sprintf("%s (%s)", file.relPath, strings.Join(rules, ", ")))
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	sort.Strings(found)
+	return fmt.Errorf("Expected files pushed to not have secrets (--scan-secrets), but %d file(s) have:\n- %s\n"+
+		"(hint: Remove the secrets, or use --scan-secrets-allow for the paths expected to have them)", len(found), strings.Join(found, "\n- "))
+}
+
+// secretFileScan scan of the file at relPath, as its content is read
+type secretFileScan struct {
+	relPath string
+	content io.Reader
+	// rules names of the secretRules matched
+	rules   map[string]bool
+	scanned int
+	// tail end of the content scanned before
+	tail []byte
+}
+
+// Read reads content, scanning what is read until maxSecretScanBytes are
+func (f *secretFileScan) Read(p []byte) (int, error) {
+	n, err := f.content.Read(p)
+	if remaining := maxSecretScanBytes - f.scanned; remaining > 0 && n > 0 {
+		chunk := p[:n]
+		if len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		f.scanned += len(chunk)
+
+		window := append(f.tail, chunk...)
+		for _, rule := range secretRules {
+			if !f.rules[rule.name] && rule.pattern.Match(window) {
+				f.rules[rule.name] = true
+			}
+		}
+		if len(window) > secretScanOverlap {
+			window = window[len(window)-secretScanOverlap:]
+		}
+		f.tail = append([]byte(nil), window...)
+	}
+	return n, err
+}
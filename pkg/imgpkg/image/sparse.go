@@ -0,0 +1,116 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// sparseChunkSize size of the chunks of zeros that are skipped, instead of written, when extracting sparse files
+const sparseChunkSize = 32 * 1024
+
+// sparseZeros chunk of zeros the content of sparse files is compared with, it is never written to
+var sparseZeros = make([]byte, sparseChunkSize)
+
+// isSparse checks if the entry is a sparse file, in the old GNU format or in the GNU format recorded in PAX records.
+// The tar reader expands the holes of sparse files into zeros
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isMetadataEntry checks if the entry only carries PAX metadata. The tar reader applies the PAX headers to the
+// entry that follows them, but returns the global ones as entries
+func isMetadataEntry(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader
+}
+
+// writeSparse writes the content of a sparse file, seeking over the chunks of zeros so that the filesystem can
+// keep them as holes. Filesystems without support for holes fill them with zeros
+func (i *DirImage) writeSparse(file *os.File, input io.Reader) error {
+	pooled := getCopyBuffer(i.opts.CopyBufferSize)
+	defer putCopyBuffer(pooled)
+	buf := *pooled
+	var size int64
+
+	for {
+		n, err := io.ReadFull(input, buf)
+		for offset := 0; offset < n; offset += sparseChunkSize {
+			chunk := buf[offset:min(offset+sparseChunkSize, n)]
+			if bytes.Equal(chunk, sparseZeros[:len(chunk)]) {
+				_, seekErr := file.Seek(int64(len(chunk)), io.SeekCurrent)
+				if seekErr != nil {
+					return seekErr
+				}
+			} else {
+				_, writeErr := file.Write(chunk)
+				if writeErr != nil {
+					return writeErr
+				}
+			}
+		}
+		size += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// a file ending with a hole is only extended by the truncate
+	return file.Truncate(size)
+}
+
+// holeWriter writes to file as writeSparse does, seeking over the chunks of zeros so that the filesystem can keep them
+// as holes. The tar of the layers built from files is written with it, so that large files that are mostly zeros, such
+// as disk images, do not take their whole size on disk once more before being compressed
+type holeWriter struct {
+	file *os.File
+	size int64
+}
+
+func (w *holeWriter) Write(p []byte) (int, error) {
+	for offset := 0; offset < len(p); offset += sparseChunkSize {
+		chunk := p[offset:min(offset+sparseChunkSize, len(p))]
+		var err error
+		if len(chunk) == sparseChunkSize && bytes.Equal(chunk, sparseZeros) {
+			_, err = w.file.Seek(int64(len(chunk)), io.SeekCurrent)
+		} else {
+			_, err = w.file.Write(chunk)
+		}
+		if err != nil {
+			return offset, err
+		}
+		w.size += int64(len(chunk))
+	}
+	return len(p), nil
+}
+
+// Close extends the file to the size written, a file ending with a hole is only extended by the truncate
+func (w *holeWriter) Close() error {
+	return w.file.Truncate(w.size)
+}
+
+// withoutSparseRecords returns the PAX records that do not describe the sparse map of a file
+func withoutSparseRecords(records map[string]string) map[string]string {
+	result := map[string]string{}
+	for key, value := range records {
+		if !strings.HasPrefix(key, "GNU.sparse.") {
+			result[key] = value
+		}
+	}
+	return result
+}
@@ -5,35 +5,115 @@ package image
 
 import (
 	"archive/tar"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
 
+// vcsDirs names of the directories of version control systems, whose history is left out of the images, at any
+// depth, unless FileImageOpts.IncludeVCS
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true}
+
 type TarImage struct {
-	files           []string
-	excludePaths    []string
-	logger          Logger
-	keepPermissions bool
+	files        []string
+	excludePaths []string
+	logger       Logger
+	opts         TarImageOpts
+	// replacedFiles content of the files, by their path in the image, added instead of the content on disk
+	replacedFiles map[string][]byte
+	// includeVCS adds the paths of vcsDirs, set from FileImageOpts.IncludeVCS
+	includeVCS bool
+	// exclusionsFromGitignore leaves out the paths of the GitignoreFile of each directory, set from
+	// FileImageOpts.ExclusionsFromGitignore
+	exclusionsFromGitignore bool
+	// excludedVCS paths of vcsDirs left out, reported once the image is built
+	excludedVCS map[string]bool
+	// pushedFiles files added to the image, once they are checked against the size limits
+	pushedFiles []pushedFile
+}
+
+// TarImageOpts how the files and directories of the paths pushed are added to the image
+type TarImageOpts struct {
+	// KeepPermissions keeps the permissions of the group and others, only the permissions of the owner are kept otherwise
+	KeepPermissions bool
+	// IncludeIgnoreFile adds the IgnoreFile of each directory, which is left out otherwise
+	IncludeIgnoreFile bool
+	// Symlinks how the symlinks found in the directories are added
+	Symlinks SymlinkOpts
+	// Strict fails when files at the same path have different content or a file and a directory are at the same path,
+	// instead of the file of a path replacing the one at the same path in the paths before it
+	Strict bool
+}
+
+// SymlinkOpts how the symlinks found in the directories pushed are added to the image
+type SymlinkOpts struct {
+	// Follow adds the file, or directory tree, a symlink points to in place of the symlink. Symlinks are skipped otherwise
+	Follow bool
+	// AllowedRoots directories, besides the directory pushed, the symlinks followed can point into
+	AllowedRoots []string
+}
+
+// NewTarImage creates a struct that will allow users to create a representation of a set of paths as an OCI Image.
+// The paths listed in the IgnoreFile of each directory are left out, as well as the IgnoreFile unless
+// TarImageOpts.IncludeIgnoreFile. The paths are layered in order, a file of a path replacing the one at the same path
+// in the paths before it, unless TarImageOpts.Strict.
+// Every directory is added, empty ones included, with the mode and time of addDirToTar, so that pull recreates them.
+// The entries are named with '/' and, on Windows, get the modes of windowsFileMode and windowsDirMode, so that the
+// same files result in the same image wherever they are pushed from
+func NewTarImage(files []string, excludePaths []string, logger Logger, opts TarImageOpts) *TarImage {
+	return &TarImage{files: files, excludePaths: excludePaths, logger: logger, opts: opts}
 }
 
-// NewTarImage creates a struct that will allow users to create a representation of a set of paths as an OCI Image
-func NewTarImage(files []string, excludePaths []string, logger Logger, keepPermissions bool) *TarImage {
-	return &TarImage{files, excludePaths, logger, keepPermissions}
+// ReplaceFiles adds the content of files, by their path in the image, instead of the content of those files. Files
+// that are not part of the image are not added
+func (i *TarImage) ReplaceFiles(files map[string][]byte) {
+	i.replacedFiles = files
 }
 
 // AsFileImage Creates an OCI Image representation of the provided folders
 func (i *TarImage) AsFileImage(labels map[string]string) (*FileImage, error) {
+	return i.AsFileImageWithOpts(FileImageOpts{Labels: labels})
+}
+
+// AsFileImageWithOpts Creates an OCI Image representation of the provided folders with the metadata of opts. With
+// FileImageOpts.LayerCache, the layer built before from the same files is reused, see layerCacheKey
+func (i *TarImage) AsFileImageWithOpts(opts FileImageOpts) (*FileImage, error) {
+	i.includeVCS = opts.IncludeVCS
+	i.exclusionsFromGitignore = opts.ExclusionsFromGitignore
+	entries, err := i.tarEntries(i.files, opts.SizeLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := ""
+	if opts.LayerCache != nil {
+		cacheKey, err = i.layerCacheKey(entries, opts)
+		if err != nil {
+			return nil, err
+		}
+		if cached, found := opts.LayerCache.Layer(cacheKey); found {
+			i.logger.Logf("Reusing layer %s, built from the same files, from the cache\n", cached.Digest)
+			fileImg, err := newCachedFileImage(cached, opts)
+			if err != nil {
+				return nil, err
+			}
+			return fileImg, i.reportBuilt(fileImg, opts)
+		}
+		// the files were scanned while computing the key
+		opts.SecretScan = SecretScanOpts{}
+	}
+
 	tmpFile, err := os.CreateTemp("", "imgpkg-tar-image")
 	if err != nil {
 		return nil, err
 	}
 
-	err = i.createTarball(tmpFile, i.files)
+	err = i.createTarball(tmpFile, entries, opts.Created, opts.SecretScan)
 	if err != nil {
 		_ = tmpFile.Close()
 		_ = os.Remove(tmpFile.Name())
@@ -47,98 +127,312 @@ func (i *TarImage) AsFileImage(labels map[string]string) (*FileImage, error) {
 		return nil, err
 	}
 
-	fileImg, err := NewFileImage(tmpFile.Name(), labels)
+	fileImg, err := NewFileImageWithOpts(tmpFile.Name(), opts)
 	if err != nil {
 		_ = os.Remove(tmpFile.Name())
 		return nil, err
 	}
 
+	if cacheKey != "" {
+		// the image is pushed even when the layer cannot be cached
+		_ = fileImg.addToLayerCache(opts.LayerCache, cacheKey)
+	}
+
+	err = i.reportBuilt(fileImg, opts)
+	if err != nil {
+		_ = fileImg.Remove()
+		return nil, err
+	}
 	return fileImg, nil
 }
 
-func (i *TarImage) createTarball(file *os.File, filePaths []string) error {
-	tarWriter := tar.NewWriter(file)
-	defer tarWriter.Close()
+// reportBuilt reports the size, and the entries, of the image built from the files to the callbacks of opts
+func (i *TarImage) reportBuilt(img *FileImage, opts FileImageOpts) error {
+	err := i.reportSizeEstimate(img, opts)
+	if err != nil {
+		return err
+	}
+	return reportContents(img, opts)
+}
 
+// tarEntries returns the files and directories of filePaths added to the image, sorted, once they are checked against
+// sizeLimits
+func (i *TarImage) tarEntries(filePaths []string, sizeLimits PushSizeLimits) ([]tarTreeEntry, error) {
+	tree := newTarTree()
+	var skippedSymlinks []string
 	for _, path := range filePaths {
 		info, err := os.Stat(path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if info.IsDir() {
-			// Walk is deterministic according to https://golang.org/pkg/path/filepath/#Walk
-			err := filepath.Walk(path, func(walkedPath string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				relPath, err := filepath.Rel(path, walkedPath)
-				if err != nil {
-					return err
-				}
-				if info.IsDir() {
-					if i.isExcluded(relPath) {
-						return filepath.SkipDir
-					}
-					return i.addDirToTar(path, relPath, tarWriter)
-				}
-				if (info.Mode() & os.ModeType) != 0 {
-					return fmt.Errorf("Expected file '%s' to be a regular file", walkedPath)
-				}
-				return i.addFileToTar(walkedPath, relPath, info, tarWriter)
-			})
+			walk, err := i.newTarWalk(path, tree)
 			if err != nil {
-				return fmt.Errorf("Adding file '%s' to tar: %s", path, err)
+				return nil, err
 			}
-		} else {
-			err := i.addFileToTar(path, filepath.Base(path), info, tarWriter)
+
+			err = i.addDirTree(walk, path, ".", info, walk.roots[0], nil)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("Adding file '%s' to tar: %s", path, err)
+			}
+			skippedSymlinks = append(skippedSymlinks, walk.skippedSymlinks...)
+		} else if !i.isLeftOut(filepath.Base(path)) {
+			err := tree.add(tarTreeEntry{fullPath: path, relPath: filepath.Base(path), info: info})
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	return nil
+	if len(skippedSymlinks) > 0 {
+		i.logger.Logf("Warning: Skipped %d symlink(s) while pushing (hint: Use --follow-symlinks to add the content they point to): %s\n",
+			len(skippedSymlinks), strings.Join(skippedSymlinks, ", "))
+	}
+	i.reportExcludedVCS()
+	if i.opts.Strict && len(tree.conflicts) > 0 {
+		return nil, fmt.Errorf("Expected the paths provided to not have different files at the same path (hint: Remove --strict to use the file of the last path):\n- %s",
+			strings.Join(tree.conflicts, "\n- "))
+	}
+
+	entries := tree.sorted()
+	var files []pushedFile
+	for _, entry := range entries {
+		if !entry.info.IsDir() {
+			files = append(files, pushedFile{path: entry.fullPath, size: entry.info.Size()})
+		}
+	}
+	err := sizeLimits.check(files, i.logger)
+	if err != nil {
+		return nil, err
+	}
+	i.pushedFiles = files
+	return entries, nil
 }
 
-func (i *TarImage) addDirToTar(fullPath string, relPath string, tarWriter *tar.Writer) error {
-	if i.isExcluded(relPath) {
-		panic("Unreachable") // directories excluded above
+// createTarball writes entries to file, modTime being the modification time of all of them. The files are scanned for
+// secrets as they are written when secretScanOpts enable it
+func (i *TarImage) createTarball(file *os.File, entries []tarTreeEntry, modTime time.Time, secretScanOpts SecretScanOpts) error {
+	holes := &holeWriter{file: file}
+	tarWriter := tar.NewWriter(holes)
+
+	secretScan := newSecretScan(secretScanOpts)
+	for _, entry := range entries {
+		var err error
+		if entry.info.IsDir() {
+			err = i.addDirToTar(entry.fullPath, entry.relPath, entry.info, modTime, tarWriter)
+		} else {
+			err = i.addFileToTar(entry.fullPath, entry.relPath, entry.info, modTime, tarWriter, secretScan)
+		}
+		if err != nil {
+			return fmt.Errorf("Adding file '%s' to tar: %s", entry.fullPath, err)
+		}
 	}
 
-	i.logger.Logf("dir: %s\n", relPath)
+	err := secretScan.err()
+	if err != nil {
+		return err
+	}
+	return closeLayerTar(tarWriter, holes)
+}
+
+// closeLayerTar writes the end of the tar of a layer, and extends the file to it
+func closeLayerTar(tarWriter *tar.Writer, holes *holeWriter) error {
+	err := tarWriter.Close()
+	if err != nil {
+		return err
+	}
+	return holes.Close()
+}
+
+// writeLayerHeader writes the header of an entry of the tar of a layer. The format is left to the tar writer, which
+// uses USTAR when the entry fits its fields and PAX otherwise, such as for files larger than 8GiB, names longer than
+// USTAR allows or ids too large for it, and never GNU. Only the modification time, to the second, is kept, so that
+// the PAX records do not add times that would change the digest of the same files
+func writeLayerHeader(tarWriter *tar.Writer, header *tar.Header) error {
+	header.Format = tar.FormatUnknown
+	header.ModTime = header.ModTime.Truncate(time.Second)
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	return tarWriter.WriteHeader(header)
+}
+
+// tarWalk state of the walk of one of the directories pushed
+type tarWalk struct {
+	tree        *tarTree
+	ignoreRules IgnoreRules
+	// gitignoreRules rules of the GitignoreFile of the directories walked, by their path relative to the directory pushed
+	gitignoreRules map[string]IgnoreRules
+	// roots real paths of the directory pushed, and of SymlinkOpts.AllowedRoots, the targets of symlinks followed are in
+	roots           []string
+	skippedSymlinks []string
+}
+
+func (i *TarImage) newTarWalk(path string, tree *tarTree) (*tarWalk, error) {
+	ignoreRules, err := ReadIgnoreFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	walk := &tarWalk{tree: tree, ignoreRules: ignoreRules, gitignoreRules: map[string]IgnoreRules{}}
+	for _, root := range append([]string{path}, i.opts.Symlinks.AllowedRoots...) {
+		realRoot, err := realPath(root)
+		if err != nil {
+			return nil, fmt.Errorf("Resolving directory '%s': %s", root, err)
+		}
+		walk.roots = append(walk.roots, realRoot)
+	}
+	return walk, nil
+}
 
-	// Ensure that images will always have the same path format
-	if runtime.GOOS == "windows" {
-		relPath = strings.ReplaceAll(relPath, "\\", "/")
+// addDirTree adds the directory at fullPath, whose real path is realDir, and its content to the tree of the walk.
+// parentDirs are the real paths of the directories it is in, so that symlinks to one of them are not followed forever
+func (i *TarImage) addDirTree(walk *tarWalk, fullPath, relPath string, info os.FileInfo, realDir string, parentDirs []string) error {
+	err := walk.tree.add(tarTreeEntry{fullPath: fullPath, relPath: relPath, info: info})
+	if err != nil {
+		return err
 	}
+	parentDirs = append(parentDirs, realDir)
 
-	folderPermission := int64(0700)
-	if i.keepPermissions {
-		fInfo, err := os.Stat(fullPath)
+	if i.exclusionsFromGitignore {
+		gitignoreRules, err := ReadGitignoreFile(fullPath)
 		if err != nil {
-			return fmt.Errorf("Unable to stat the folder '%s': %s", fullPath, err)
+			return err
 		}
-		folderPermission = int64(fInfo.Mode())
+		walk.gitignoreRules[relPath] = gitignoreRules
 	}
 
-	header := &tar.Header{
-		Name:     relPath,
-		Mode:     folderPermission, // static
-		ModTime:  time.Time{},      // static
-		Typeflag: tar.TypeDir,
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return err
 	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(fullPath, entry.Name())
+		entryRelPath := filepath.Join(relPath, entry.Name())
+		entryRealPath := filepath.Join(realDir, entry.Name())
 
-	return tarWriter.WriteHeader(header)
+		info, err := os.Lstat(entryPath)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !i.opts.Symlinks.Follow {
+				if !i.isIgnored(walk, entryRelPath, false) && !i.isExcluded(entryRelPath) {
+					walk.skippedSymlinks = append(walk.skippedSymlinks, entryPath)
+				}
+				continue
+			}
+			entryPath, info, err = i.followSymlink(walk, entryPath, parentDirs)
+			if err != nil {
+				return err
+			}
+			entryRealPath = entryPath
+		}
+
+		if i.isIgnored(walk, entryRelPath, info.IsDir()) {
+			continue
+		}
+		if info.IsDir() {
+			// the metadata written by pull is not part of the image
+			if i.isExcluded(entryRelPath) || entry.Name() == MetadataDir {
+				continue
+			}
+			err = i.addDirTree(walk, entryPath, entryRelPath, info, entryRealPath, parentDirs)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if (info.Mode() & os.ModeType) != 0 {
+			return fmt.Errorf("Expected file '%s' to be a regular file", entryPath)
+		}
+		if i.isLeftOut(entryRelPath) {
+			continue
+		}
+		err = walk.tree.add(tarTreeEntry{fullPath: entryPath, relPath: entryRelPath, info: info})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (i *TarImage) addFileToTar(fullPath, relPath string, info os.FileInfo, tarWriter *tar.Writer) error {
+// followSymlink returns the real path, and the info, of the target of the symlink at linkPath. The target has to be in
+// one of the roots of the walk, and cannot be one of the directories the symlink is in
+func (i *TarImage) followSymlink(walk *tarWalk, linkPath string, parentDirs []string) (string, os.FileInfo, error) {
+	target, err := realPath(linkPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("Following symlink '%s': %s", linkPath, err)
+	}
+
+	allowed := false
+	for _, root := range walk.roots {
+		if isInDir(target, root) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", nil, fmt.Errorf("Expected symlink '%s' to point inside of the directory pushed or of --follow-symlinks-root, but it points to '%s'", linkPath, target)
+	}
+
+	for _, parentDir := range parentDirs {
+		if target == parentDir {
+			return "", nil, fmt.Errorf("Expected symlink '%s' to not point to one of the directories it is in, but it points to '%s'", linkPath, target)
+		}
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("Following symlink '%s': %s", linkPath, err)
+	}
+	return target, info, nil
+}
+
+// realPath returns the absolute path of path with all its symlinks resolved
+func realPath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(absPath)
+}
+
+// isInDir checks if path is dir or is inside of it, both paths being clean
+func isInDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func (i *TarImage) addDirToTar(fullPath string, relPath string, info os.FileInfo, modTime time.Time, tarWriter *tar.Writer) error {
 	if i.isExcluded(relPath) {
-		return nil
+		panic("Unreachable") // directories excluded above
+	}
+
+	i.logger.Logf("dir: %s\n", relPath)
+
+	header := &tar.Header{
+		Name:     filepath.ToSlash(relPath),      // same on every platform
+		Mode:     i.dirHeaderMode(dirMode(info)), // static
+		ModTime:  modTime,                        // static unless provided
+		Typeflag: tar.TypeDir,
 	}
 
+	return writeLayerHeader(tarWriter, header)
+}
+
+func (i *TarImage) addFileToTar(fullPath, relPath string, info os.FileInfo, modTime time.Time, tarWriter *tar.Writer, secretScan *secretScan) error {
 	i.logger.Logf("file: %s\n", relPath)
 
+	// same on every platform
+	relPath = filepath.ToSlash(relPath)
+	mode, err := fileMode(fullPath, info)
+	if err != nil {
+		return err
+	}
+	if content, found := i.replacedFiles[relPath]; found {
+		return i.addReplacedFileToTar(relPath, content, mode, modTime, tarWriter, secretScan)
+	}
+
 	file, err := os.Open(fullPath)
 	if err != nil {
 		return err
@@ -146,37 +440,149 @@ func (i *TarImage) addFileToTar(fullPath, relPath string, info os.FileInfo, tarW
 
 	defer file.Close()
 
-	// Ensure that images will always have the same path format
-	if runtime.GOOS == "windows" {
-		relPath = strings.ReplaceAll(relPath, "\\", "/")
-	}
-	filePermission := int64(info.Mode() & 0700)
-	if i.keepPermissions {
-		filePermission = int64(info.Mode())
-	}
-
 	header := &tar.Header{
 		Name:     relPath,
 		Size:     info.Size(),
-		Mode:     filePermission, // static
-		ModTime:  time.Time{},    // static
+		Mode:     i.fileHeaderMode(mode), // static
+		ModTime:  modTime,                // static unless provided
 		Typeflag: tar.TypeReg,
 	}
 
-	err = tarWriter.WriteHeader(header)
+	err = writeLayerHeader(tarWriter, header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tarWriter, secretScan.scan(relPath, file))
+	return err
+}
+
+// addReplacedFileToTar adds the file at relPath with content, keeping the mode of the file it replaces
+func (i *TarImage) addReplacedFileToTar(relPath string, content []byte, mode os.FileMode, modTime time.Time, tarWriter *tar.Writer, secretScan *secretScan) error {
+	err := writeLayerHeader(tarWriter, &tar.Header{
+		Name:     relPath,
+		Size:     int64(len(content)),
+		Mode:     i.fileHeaderMode(mode), // static
+		ModTime:  modTime,                // static unless provided
+		Typeflag: tar.TypeReg,
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = io.Copy(tarWriter, file)
+	_, err = io.Copy(tarWriter, secretScan.scan(relPath, bytes.NewReader(content)))
 	return err
 }
 
+// dirHeaderMode returns the mode of a directory in the image, only the owner having access unless TarImageOpts.KeepPermissions
+func (i *TarImage) dirHeaderMode(mode os.FileMode) int64 {
+	if i.opts.KeepPermissions {
+		return int64(mode) | tarSpecialBits(mode)
+	}
+	return 0700
+}
+
+// fileHeaderMode returns the mode of a file in the image, only the permissions of the owner being kept unless TarImageOpts.KeepPermissions
+func (i *TarImage) fileHeaderMode(mode os.FileMode) int64 {
+	if i.opts.KeepPermissions {
+		return int64(mode) | tarSpecialBits(mode)
+	}
+	return int64(mode & 0700)
+}
+
+// dirMode returns the mode of the directory pushed, see windowsDirMode for directories pushed from Windows
+func dirMode(info os.FileInfo) os.FileMode {
+	if normalizesWindowsModes {
+		return windowsDirMode(info.Mode())
+	}
+	return info.Mode()
+}
+
+// fileMode returns the mode of the file at fullPath pushed, see windowsFileMode for files pushed from Windows
+func fileMode(fullPath string, info os.FileInfo) (os.FileMode, error) {
+	if normalizesWindowsModes {
+		return windowsFileMode(fullPath, info.Mode())
+	}
+	return info.Mode(), nil
+}
+
+// isIgnored checks if the path is left out by the ignore file of the directory or, with exclusionsFromGitignore, by
+// the GitignoreFile of the directories it is in. The most specific rules decide: the ignore file before the
+// GitignoreFile, and the GitignoreFile of a directory before the ones of its parents. Paths excluded with
+// excludePaths are checked separately, so that they are excluded even when the ignore file includes them again
+func (i *TarImage) isIgnored(walk *tarWalk, relPath string, isDir bool) bool {
+	if relPath == IgnoreFile && !isDir && !i.opts.IncludeIgnoreFile {
+		return true
+	}
+	if ignored, matched := walk.ignoreRules.match(relPath, isDir); matched {
+		return ignored
+	}
+
+	for dir := filepath.Dir(relPath); ; dir = filepath.Dir(dir) {
+		if rules, found := walk.gitignoreRules[dir]; found {
+			dirRelPath, err := filepath.Rel(dir, relPath)
+			if err == nil {
+				if ignored, matched := rules.match(dirRelPath, isDir); matched {
+					return ignored
+				}
+			}
+		}
+		if dir == "." {
+			return false
+		}
+	}
+}
+
+// isLeftOut checks if the file at relPath is not part of the image
+func (i *TarImage) isLeftOut(relPath string) bool {
+	// the state of incremental pulls only describes the directory it was pulled into
+	return i.isExcluded(relPath) || filepath.Base(relPath) == PullStateFile
+}
+
 func (i *TarImage) isExcluded(relPath string) bool {
+	// the paths excluded use '/' on every platform
+	relPath = filepath.ToSlash(relPath)
 	for _, path := range i.excludePaths {
-		if path == relPath {
+		if filepath.ToSlash(path) == relPath {
 			return true
 		}
 	}
+
+	if !i.includeVCS && vcsDirs[filepath.Base(relPath)] {
+		if i.excludedVCS == nil {
+			i.excludedVCS = map[string]bool{}
+		}
+		i.excludedVCS[relPath] = true
+		return true
+	}
 	return false
 }
+
+// reportExcludedVCS prints the paths of vcsDirs left out, in a single line
+func (i *TarImage) reportExcludedVCS() {
+	if len(i.excludedVCS) == 0 {
+		return
+	}
+	var paths []string
+	for path := range i.excludedVCS {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	i.logger.Logf("Left out %d version control path(s): %s (hint: Use --include-vcs to push them)\n", len(paths), strings.Join(paths, ", "))
+}
+
+// tarSpecialBits returns the setuid, setgid and sticky bits in the format used by tar headers,
+// since os.FileMode keeps them outside of the permission bits
+func tarSpecialBits(mode os.FileMode) int64 {
+	var bits int64
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
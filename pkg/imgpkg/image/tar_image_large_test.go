@@ -0,0 +1,107 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package image
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarImageLargeEntries(t *testing.T) {
+	// sub-second times are not kept, so that they are not recorded in PAX records
+	modTime := time.Unix(1700000000, 500)
+
+	createTarball := func(t *testing.T, folder string) *os.File {
+		tarImage := NewTarImage([]string{folder}, nil, util.NewNoopLogger(), TarImageOpts{})
+		entries, err := tarImage.tarEntries(tarImage.files, PushSizeLimits{})
+		require.NoError(t, err)
+
+		file, err := os.CreateTemp(t.TempDir(), "layer")
+		require.NoError(t, err)
+		t.Cleanup(func() { file.Close() })
+		require.NoError(t, tarImage.createTarball(file, entries, modTime, SecretScanOpts{}))
+		_, err = file.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		return file
+	}
+	readHeaders := func(t *testing.T, file *os.File) map[string]*tar.Header {
+		headers := map[string]*tar.Header{}
+		tarReader := tar.NewReader(file)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return headers
+			}
+			require.NoError(t, err)
+			headers[header.Name] = header
+		}
+	}
+
+	t.Run("it writes files larger than 8GiB in PAX format, without using their size on disk", func(t *testing.T) {
+		const size = 8*1024*1024*1024 + 1024*1024
+		folder := t.TempDir()
+		modelPath := filepath.Join(folder, "model.bin")
+		model, err := os.Create(modelPath)
+		require.NoError(t, err)
+		_, err = model.WriteString("start of the model")
+		require.NoError(t, err)
+		_, err = model.WriteAt([]byte("end of the model"), size-16)
+		require.NoError(t, err)
+		require.NoError(t, model.Close())
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "config.yml"), []byte("model: model.bin\n"), 0600))
+
+		file := createTarball(t, folder)
+		info, err := file.Stat()
+		require.NoError(t, err)
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			assert.Less(t, stat.Blocks*512, int64(64*1024*1024), "the zeros of the file do not use disk space in the tar")
+		}
+
+		headers := readHeaders(t, file)
+		require.Contains(t, headers, "model.bin")
+		assert.Equal(t, int64(size), headers["model.bin"].Size)
+		assert.Equal(t, tar.FormatPAX, headers["model.bin"].Format)
+		assert.Equal(t, map[string]string{"size": "8590983168"}, headers["model.bin"].PAXRecords)
+		assert.True(t, time.Unix(1700000000, 0).Equal(headers["model.bin"].ModTime))
+
+		// the entries that fit USTAR keep its format
+		assert.Equal(t, tar.FormatUSTAR, headers["config.yml"].Format)
+		assert.Equal(t, tar.FormatUSTAR, headers["."].Format)
+	})
+
+	t.Run("it writes names longer than USTAR allows in PAX format, and pull extracts them", func(t *testing.T) {
+		folder := t.TempDir()
+		longDir := filepath.Join(strings.Repeat("a", 100), strings.Repeat("b", 100), strings.Repeat("c", 100))
+		require.NoError(t, os.MkdirAll(filepath.Join(folder, longDir), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(folder, longDir, "config.yml"), []byte("key: value\n"), 0600))
+
+		name := filepath.ToSlash(filepath.Join(longDir, "config.yml"))
+		headers := readHeaders(t, createTarball(t, folder))
+		require.Contains(t, headers, name)
+		assert.Equal(t, tar.FormatPAX, headers[name].Format)
+		assert.Equal(t, map[string]string{"path": name}, headers[name].PAXRecords)
+
+		img, err := NewTarImage([]string{folder}, nil, util.NewNoopLogger(), TarImageOpts{}).AsFileImageWithOpts(FileImageOpts{})
+		require.NoError(t, err)
+		defer img.Remove()
+
+		pullFolder := t.TempDir()
+		require.NoError(t, NewDirImage(pullFolder, img, util.NewNoopLogger()).AsDirectory())
+		content, err := os.ReadFile(filepath.Join(pullFolder, longDir, "config.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, "key: value\n", string(content))
+	})
+}
@@ -0,0 +1,418 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TarStreamStdin path of the tar read from stdin
+const TarStreamStdin = "-"
+
+// tarStreamEntry entry of the tar, at relPath once its name is normalized. data is the offset of its content in the tar
+type tarStreamEntry struct {
+	relPath string
+	header  *tar.Header
+	data    int64
+}
+
+// TarStream tar provided as the content of an image, instead of the directories pushed. The entries are indexed when
+// the tar is opened, so that they can be added to the image sorted without extracting them, and a tar read from stdin
+// is copied to a temporary file first since it cannot be read twice
+type TarStream struct {
+	name     string
+	file     *os.File
+	isCopied bool
+	entries  map[string]tarStreamEntry
+}
+
+// OpenTarStream opens the tar at tarPath, or the one read from stdin when tarPath is TarStreamStdin, and indexes its
+// entries. Leading '/' and './' are removed from the names of the entries, the directories entries are in are added
+// when the tar does not have them, an entry replaces the one with the same name before it, as tar does, and hardlinks
+// are resolved to the regular files they link to
+func OpenTarStream(tarPath string, stdin io.Reader) (*TarStream, error) {
+	stream := &TarStream{name: tarPath, entries: map[string]tarStreamEntry{".": {relPath: ".", header: &tar.Header{Typeflag: tar.TypeDir, Mode: 0755}}}}
+
+	var err error
+	if tarPath == TarStreamStdin {
+		stream.file, err = copyToTempFile(stdin)
+		stream.isCopied = true
+	} else {
+		stream.file, err = os.Open(tarPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Opening tar '%s': %s", tarPath, err)
+	}
+
+	err = stream.index()
+	if err != nil {
+		_ = stream.Close()
+		return nil, fmt.Errorf("Reading tar '%s': %s", tarPath, err)
+	}
+	return stream, nil
+}
+
+func copyToTempFile(reader io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "imgpkg-tar-stream")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(file, reader)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *TarStream) index() error {
+	tarReader := tar.NewReader(s.file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// global headers only hold metadata of the entries after them
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		relPath, err := tarStreamRelPath(header.Name)
+		if err != nil {
+			return err
+		}
+		// the reader stops at the content of the entry it returns
+		data, err := s.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		entry := tarStreamEntry{relPath: relPath, header: header, data: data}
+		if header.Typeflag == tar.TypeLink {
+			entry, err = s.resolveHardlink(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = s.add(entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tarStreamRelPath returns the path name is at in the image, failing when it is outside of the tar
+func tarStreamRelPath(name string) (string, error) {
+	relPath := path.Clean(strings.TrimLeft(name, "/"))
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", fmt.Errorf("Expected entry '%s' to be inside of the tar", name)
+	}
+	return relPath, nil
+}
+
+// resolveHardlink returns the regular file link links to, at the path of link, as the content of hardlinks is added
+// as the regular files they are in the directories pushed. The target is the entry at Linkname when the tar reaches
+// the link, an entry replacing it after the link does not change the content of the link
+func (s *TarStream) resolveHardlink(link tarStreamEntry) (tarStreamEntry, error) {
+	targetPath, err := tarStreamRelPath(link.header.Linkname)
+	if err != nil {
+		return tarStreamEntry{}, err
+	}
+
+	target, found := s.entries[targetPath]
+	if !found || target.header.Typeflag != tar.TypeReg {
+		return tarStreamEntry{}, fmt.Errorf("Expected hardlink '%s' to link to a regular file before it in the tar, but '%s' is not one", link.header.Name, link.header.Linkname)
+	}
+
+	header := *target.header
+	header.Name = link.header.Name
+	return tarStreamEntry{relPath: link.relPath, header: &header, data: target.data}, nil
+}
+
+// add adds entry, and the directories it is in the tar did not have before it. A file and a directory cannot be at the
+// same path, since the content of the directory would end up in the file
+func (s *TarStream) add(entry tarStreamEntry) error {
+	if dir := path.Dir(entry.relPath); entry.relPath != "." {
+		if _, found := s.entries[dir]; !found {
+			err := s.add(tarStreamEntry{relPath: dir, header: &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}})
+			if err != nil {
+				return err
+			}
+		}
+		if s.entries[dir].header.Typeflag != tar.TypeDir {
+			return fmt.Errorf("Expected '%s' to be a directory since entry '%s' is in it", dir, entry.header.Name)
+		}
+	}
+
+	if existing, found := s.entries[entry.relPath]; found && (existing.header.Typeflag == tar.TypeDir) != (entry.header.Typeflag == tar.TypeDir) {
+		return fmt.Errorf("Expected entry '%s' to not be both a file and a directory", entry.header.Name)
+	}
+	s.entries[entry.relPath] = entry
+	return nil
+}
+
+// Paths returns the paths of the entries of the tar, and of the directories they are in, sorted
+func (s *TarStream) Paths() []string {
+	var paths []string
+	for _, entry := range s.sorted() {
+		paths = append(paths, entry.relPath)
+	}
+	return paths
+}
+
+// sorted returns the entries in the order of the entries of the directories pushed, see tarTree.sorted
+func (s *TarStream) sorted() []tarStreamEntry {
+	entries := make([]tarStreamEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return lessTarPath(entries[i].relPath, entries[j].relPath)
+	})
+	return entries
+}
+
+// content returns a reader of the content of the entry
+func (s *TarStream) content(entry tarStreamEntry) io.Reader {
+	return io.NewSectionReader(s.file, entry.data, entry.header.Size)
+}
+
+// Name returns the path of the tar, TarStreamStdin when it was read from stdin
+func (s *TarStream) Name() string {
+	return s.name
+}
+
+// ReadFile returns the content of the regular file at relPath in the tar
+func (s *TarStream) ReadFile(relPath string) ([]byte, error) {
+	entry, found := s.entries[relPath]
+	if !found || entry.header.Typeflag != tar.TypeReg {
+		return nil, fmt.Errorf("Expected tar '%s' to have the file '%s'", s.name, relPath)
+	}
+	return io.ReadAll(s.content(entry))
+}
+
+// Close closes the tar, removing it when it was copied from stdin
+func (s *TarStream) Close() error {
+	err := s.file.Close()
+	if s.isCopied {
+		_ = os.Remove(s.file.Name())
+	}
+	return err
+}
+
+// TarStreamImage image with the entries of a TarStream as its content. The entries are added as the files and
+// directories of the directories pushed are: sorted, with the permissions and the modification time of TarImage
+// and leaving out the same paths. Hardlinks are added as the regular files they link to, symlinks are skipped, and
+// other entries that are not regular files are rejected
+type TarStreamImage struct {
+	*TarImage
+	stream *TarStream
+}
+
+// NewTarStreamImage creates a struct that will allow users to create a representation of the entries of stream as an OCI Image.
+// TarImageOpts.Symlinks and TarImageOpts.Strict do not apply to the entries of a tar
+func NewTarStreamImage(stream *TarStream, excludePaths []string, logger Logger, opts TarImageOpts) *TarStreamImage {
+	return &TarStreamImage{TarImage: NewTarImage(nil, excludePaths, logger, opts), stream: stream}
+}
+
+// AsFileImageWithOpts Creates an OCI Image representation of the entries of the tar with the metadata of opts
+func (i *TarStreamImage) AsFileImageWithOpts(opts FileImageOpts) (*FileImage, error) {
+	tmpFile, err := os.CreateTemp("", "imgpkg-tar-image")
+	if err != nil {
+		return nil, err
+	}
+
+	i.includeVCS = opts.IncludeVCS
+	err = i.createTarball(tmpFile, opts.Created, opts.SizeLimits, opts.SecretScan)
+	if err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return nil, err
+	}
+
+	// Close file explicitly to make sure all data is flushed
+	err = tmpFile.Close()
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return nil, err
+	}
+
+	fileImg, err := NewFileImageWithOpts(tmpFile.Name(), opts)
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return nil, err
+	}
+
+	err = i.reportBuilt(fileImg, opts)
+	if err != nil {
+		_ = fileImg.Remove()
+		return nil, err
+	}
+	return fileImg, nil
+}
+
+func (i *TarStreamImage) createTarball(file *os.File, modTime time.Time, sizeLimits PushSizeLimits, secretScanOpts SecretScanOpts) error {
+	ignoreRules, err := i.readIgnoreFile()
+	if err != nil {
+		return err
+	}
+	// the entries of the tar are not walked, only the ignore file at its root applies
+	walk := &tarWalk{ignoreRules: ignoreRules}
+
+	var entries []tarStreamEntry
+	var skippedSymlinks []string
+	// directories left out, with all their content
+	leftOutDirs := map[string]bool{}
+	for _, entry := range i.stream.sorted() {
+		if leftOutDirs[path.Dir(entry.relPath)] {
+			leftOutDirs[entry.relPath] = true
+			continue
+		}
+		relPath := filepath.FromSlash(entry.relPath)
+
+		switch entry.header.Typeflag {
+		case tar.TypeDir:
+			if entry.relPath != "." && (i.isIgnored(walk, relPath, true) || i.isExcluded(relPath) || path.Base(entry.relPath) == MetadataDir) {
+				leftOutDirs[entry.relPath] = true
+				continue
+			}
+		case tar.TypeSymlink:
+			if !i.isIgnored(walk, relPath, false) && !i.isExcluded(relPath) {
+				skippedSymlinks = append(skippedSymlinks, entry.relPath)
+			}
+			continue
+		case tar.TypeReg:
+			if isSparseTarEntry(entry.header) {
+				return fmt.Errorf("Expected entry '%s' of tar to not be a sparse file", entry.header.Name)
+			}
+			if i.isIgnored(walk, relPath, false) || i.isLeftOut(relPath) {
+				continue
+			}
+		default:
+			return fmt.Errorf("Expected entry '%s' of tar to be a regular file", entry.header.Name)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(skippedSymlinks) > 0 {
+		i.logger.Logf("Warning: Skipped %d symlink(s) of the tar while pushing: %s\n", len(skippedSymlinks), strings.Join(skippedSymlinks, ", "))
+	}
+	i.reportExcludedVCS()
+
+	var files []pushedFile
+	for _, entry := range entries {
+		if entry.header.Typeflag == tar.TypeReg {
+			files = append(files, pushedFile{path: entry.relPath, size: entry.header.Size})
+		}
+	}
+	err = sizeLimits.check(files, i.logger)
+	if err != nil {
+		return err
+	}
+	i.pushedFiles = files
+
+	holes := &holeWriter{file: file}
+	tarWriter := tar.NewWriter(holes)
+
+	secretScan := newSecretScan(secretScanOpts)
+	for _, entry := range entries {
+		err := i.addEntryToTar(entry, modTime, tarWriter, secretScan)
+		if err != nil {
+			return fmt.Errorf("Adding entry '%s' of tar: %s", entry.relPath, err)
+		}
+	}
+
+	err = secretScan.err()
+	if err != nil {
+		return err
+	}
+	return closeLayerTar(tarWriter, holes)
+}
+
+// readIgnoreFile reads the rules of the IgnoreFile at the root of the tar, there are no rules when the tar does not have it
+func (i *TarStreamImage) readIgnoreFile() (IgnoreRules, error) {
+	entry, found := i.stream.entries[IgnoreFile]
+	if !found || entry.header.Typeflag == tar.TypeDir {
+		return IgnoreRules{}, nil
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(i.stream.content(entry))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return IgnoreRules{}, fmt.Errorf("Reading '%s' of tar: %s", IgnoreFile, err)
+	}
+
+	rules, err := NewIgnoreRules(lines)
+	if err != nil {
+		return IgnoreRules{}, fmt.Errorf("Parsing '%s' of tar: %s", IgnoreFile, err)
+	}
+	return rules, nil
+}
+
+func (i *TarStreamImage) addEntryToTar(entry tarStreamEntry, modTime time.Time, tarWriter *tar.Writer, secretScan *secretScan) error {
+	info := entry.header.FileInfo()
+	if info.IsDir() {
+		i.logger.Logf("dir: %s\n", entry.relPath)
+		return writeLayerHeader(tarWriter, &tar.Header{
+			Name:     entry.relPath,
+			Mode:     i.dirHeaderMode(info.Mode()), // static
+			ModTime:  modTime,                      // static unless provided
+			Typeflag: tar.TypeDir,
+		})
+	}
+
+	i.logger.Logf("file: %s\n", entry.relPath)
+	if content, found := i.replacedFiles[entry.relPath]; found {
+		return i.addReplacedFileToTar(entry.relPath, content, info.Mode(), modTime, tarWriter, secretScan)
+	}
+
+	err := writeLayerHeader(tarWriter, &tar.Header{
+		Name:     entry.relPath,
+		Size:     entry.header.Size,
+		Mode:     i.fileHeaderMode(info.Mode()), // static
+		ModTime:  modTime,                       // static unless provided
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tarWriter, secretScan.scan(entry.relPath, i.stream.content(entry)))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("Expected tar to have the %d bytes of the entry: %s", entry.header.Size, err)
+	}
+	return err
+}
+
+// isSparseTarEntry checks if the entry is a sparse file, whose content in the tar is not the content of the file
+func isSparseTarEntry(header *tar.Header) bool {
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/image"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarStreamImage(t *testing.T) {
+	logger := testLogger{}
+
+	t.Run("has the same digest as the directory with the same files", func(t *testing.T) {
+		folder := t.TempDir()
+		for _, file := range []string{"config/app.yml", "values.yml", ".git/HEAD"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(folder, filepath.Dir(file)), 0700))
+			require.NoError(t, os.WriteFile(filepath.Join(folder, file), []byte(file), 0600))
+		}
+		dirImg, err := image.NewTarImage([]string{folder}, []string{".git"}, logger, image.TarImageOpts{}).AsFileImage(nil)
+		require.NoError(t, err)
+		defer dirImg.Remove()
+
+		// entries unsorted, with other times and permissions, and without some of the directories
+		stream := openTarStream(t, []tar.Header{
+			{Name: "./values.yml", Typeflag: tar.TypeReg, Mode: 0644, ModTime: time.Now()},
+			{Name: ".git/HEAD", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "./config/app.yml", Typeflag: tar.TypeReg, Mode: 0640, ModTime: time.Unix(1000, 0)},
+			{Name: "./", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()},
+		})
+		tarImg, err := image.NewTarStreamImage(stream, []string{".git"}, logger, image.TarImageOpts{}).AsFileImageWithOpts(image.FileImageOpts{})
+		require.NoError(t, err)
+		defer tarImg.Remove()
+
+		dirDigest, err := dirImg.Digest()
+		require.NoError(t, err)
+		tarDigest, err := tarImg.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, dirDigest, tarDigest)
+	})
+
+	t.Run("leaves out the paths of the ignore file of the tar", func(t *testing.T) {
+		stream := openTarStream(t, []tar.Header{
+			{Name: image.IgnoreFile, Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "build/out.txt", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "debug.log", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+		}, "build/\n*.log\n")
+		files := tarStreamImageFiles(t, image.NewTarStreamImage(stream, nil, logger, image.TarImageOpts{}))
+		assert.Equal(t, map[string]string{".": "", "config.yml": "config.yml"}, files)
+	})
+
+	t.Run("skips symlinks and reports them", func(t *testing.T) {
+		stream := openTarStream(t, []tar.Header{
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "values.yml", Typeflag: tar.TypeSymlink, Linkname: "config.yml"},
+		})
+		logger := &recordingLogger{}
+		files := tarStreamImageFiles(t, image.NewTarStreamImage(stream, nil, logger, image.TarImageOpts{}))
+		assert.Equal(t, map[string]string{".": "", "config.yml": "config.yml"}, files)
+		assert.Contains(t, logger.messages, "Warning: Skipped 1 symlink(s) of the tar while pushing: values.yml\n")
+	})
+
+	t.Run("has the same digest as the directory with the same hardlinks", func(t *testing.T) {
+		folder := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(folder, "config.yml"), []byte("config.yml"), 0600))
+		require.NoError(t, os.Link(filepath.Join(folder, "config.yml"), filepath.Join(folder, "same-config.yml")))
+		dirImg, err := image.NewTarImage([]string{folder}, nil, logger, image.TarImageOpts{}).AsFileImage(nil)
+		require.NoError(t, err)
+		defer dirImg.Remove()
+
+		stream := openTarStream(t, []tar.Header{
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "same-config.yml", Typeflag: tar.TypeLink, Linkname: "config.yml"},
+		})
+		tarImg, err := image.NewTarStreamImage(stream, nil, logger, image.TarImageOpts{}).AsFileImageWithOpts(image.FileImageOpts{})
+		require.NoError(t, err)
+		defer tarImg.Remove()
+
+		dirDigest, err := dirImg.Digest()
+		require.NoError(t, err)
+		tarDigest, err := tarImg.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, dirDigest, tarDigest)
+	})
+
+	t.Run("adds hardlinks with the content of their target when the tar reaches them", func(t *testing.T) {
+		stream := openTarStream(t, []tar.Header{
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "./same-config.yml", Typeflag: tar.TypeLink, Linkname: "./config.yml"},
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+		}, "old", "", "new")
+		files := tarStreamImageFiles(t, image.NewTarStreamImage(stream, nil, logger, image.TarImageOpts{}))
+		assert.Equal(t, map[string]string{".": "", "config.yml": "new", "same-config.yml": "old"}, files)
+	})
+
+	t.Run("fails when an entry is not a regular file, a directory, a symlink or a hardlink", func(t *testing.T) {
+		stream := openTarStream(t, []tar.Header{
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "config.fifo", Typeflag: tar.TypeFifo, Mode: 0600},
+		})
+		_, err := image.NewTarStreamImage(stream, nil, logger, image.TarImageOpts{}).AsFileImageWithOpts(image.FileImageOpts{})
+		require.EqualError(t, err, "Expected entry 'config.fifo' of tar to be a regular file")
+	})
+}
+
+func TestOpenTarStream(t *testing.T) {
+	t.Run("reads the tar from stdin and adds the directories the entries are in", func(t *testing.T) {
+		stream, err := image.OpenTarStream(image.TarStreamStdin, bytes.NewReader(tarBytes(t, []tar.Header{
+			{Name: "config/db/db.yml", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "/values.yml", Typeflag: tar.TypeReg, Mode: 0600},
+		})))
+		require.NoError(t, err)
+		defer stream.Close()
+		assert.Equal(t, []string{".", "config", "config/db", "config/db/db.yml", "values.yml"}, stream.Paths())
+	})
+
+	t.Run("fails when an entry is outside of the tar", func(t *testing.T) {
+		_, err := image.OpenTarStream(image.TarStreamStdin, bytes.NewReader(tarBytes(t, []tar.Header{
+			{Name: "../secrets/token", Typeflag: tar.TypeReg, Mode: 0600},
+		})))
+		require.EqualError(t, err, "Reading tar '-': Expected entry '../secrets/token' to be inside of the tar")
+	})
+
+	t.Run("fails when a file and a directory are at the same path", func(t *testing.T) {
+		_, err := image.OpenTarStream(image.TarStreamStdin, bytes.NewReader(tarBytes(t, []tar.Header{
+			{Name: "config", Typeflag: tar.TypeReg, Mode: 0600},
+			{Name: "config/app.yml", Typeflag: tar.TypeReg, Mode: 0600},
+		})))
+		require.EqualError(t, err, "Reading tar '-': Expected 'config' to be a directory since entry 'config/app.yml' is in it")
+	})
+
+	t.Run("fails when a hardlink does not link to a regular file before it", func(t *testing.T) {
+		_, err := image.OpenTarStream(image.TarStreamStdin, bytes.NewReader(tarBytes(t, []tar.Header{
+			{Name: "same-config.yml", Typeflag: tar.TypeLink, Linkname: "config.yml"},
+			{Name: "config.yml", Typeflag: tar.TypeReg, Mode: 0600},
+		})))
+		require.EqualError(t, err, "Reading tar '-': Expected hardlink 'same-config.yml' to link to a regular file before it in the tar, but 'config.yml' is not one")
+	})
+}
+
+// openTarStream opens a tar with the entries of headers, see tarBytes
+func openTarStream(t *testing.T, headers []tar.Header, contents ...string) *image.TarStream {
+	tarPath := filepath.Join(t.TempDir(), "content.tar")
+	require.NoError(t, os.WriteFile(tarPath, tarBytes(t, headers, contents...), 0600))
+
+	stream, err := image.OpenTarStream(tarPath, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { stream.Close() })
+	return stream
+}
+
+// tarBytes returns a tar with the entries of headers, the content of each file being its path unless provided in contents
+func tarBytes(t *testing.T, headers []tar.Header, contents ...string) []byte {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for idx, header := range headers {
+		content := []byte(strings.TrimLeft(strings.TrimPrefix(header.Name, "./"), "/"))
+		if idx < len(contents) {
+			content = []byte(contents[idx])
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(content))
+		}
+		require.NoError(t, tarWriter.WriteHeader(&header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := tarWriter.Write(content)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tarWriter.Close())
+	return buf.Bytes()
+}
+
+func tarStreamImageFiles(t *testing.T, tarImage *image.TarStreamImage) map[string]string {
+	img, err := tarImage.AsFileImageWithOpts(image.FileImageOpts{})
+	require.NoError(t, err)
+	defer img.Remove()
+
+	return fileImageFiles(t, img)
+}
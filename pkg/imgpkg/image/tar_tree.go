@@ -0,0 +1,144 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// tarTreeEntry file or directory at relPath in the image, read from fullPath
+type tarTreeEntry struct {
+	fullPath string
+	relPath  string
+	info     os.FileInfo
+}
+
+// tarTree files and directories of all the paths pushed. The paths are layered in the order they are provided, so a
+// file replaces the one at the same path added before it, and a file and a directory at the same path replace each other
+type tarTree struct {
+	entries   map[string]tarTreeEntry
+	conflicts []string
+}
+
+func newTarTree() *tarTree {
+	return &tarTree{entries: map[string]tarTreeEntry{}}
+}
+
+// add adds entry, replacing what is at its path. Replacing a file with different content, or a file with a directory, is
+// recorded as a conflict. Directories at the same path are merged, the last one providing the permissions
+func (t *tarTree) add(entry tarTreeEntry) error {
+	existing, found := t.entries[entry.relPath]
+	if found {
+		switch {
+		case existing.info.IsDir() && entry.info.IsDir():
+		case existing.info.IsDir():
+			t.conflicts = append(t.conflicts, fmt.Sprintf("'%s' is a directory in '%s' and a file in '%s'", entry.relPath, existing.fullPath, entry.fullPath))
+			t.removeDirContent(entry.relPath)
+		case entry.info.IsDir():
+			t.conflicts = append(t.conflicts, fmt.Sprintf("'%s' is a file in '%s' and a directory in '%s'", entry.relPath, existing.fullPath, entry.fullPath))
+		default:
+			same, err := sameFileContent(existing, entry)
+			if err != nil {
+				return err
+			}
+			if !same {
+				t.conflicts = append(t.conflicts, fmt.Sprintf("'%s' is different in '%s' and '%s'", entry.relPath, existing.fullPath, entry.fullPath))
+			}
+		}
+	}
+	t.entries[entry.relPath] = entry
+	return nil
+}
+
+// removeDirContent removes the entries inside of the directory at relPath
+func (t *tarTree) removeDirContent(relPath string) {
+	prefix := relPath + string(filepath.Separator)
+	if relPath == "." {
+		prefix = ""
+	}
+	for entryPath := range t.entries {
+		if entryPath != relPath && strings.HasPrefix(entryPath, prefix) {
+			delete(t.entries, entryPath)
+		}
+	}
+}
+
+// sorted returns the entries with each directory followed by its content, and the entries of a directory ordered by
+// name, so that the image does not depend on the order the paths are provided in or the file system lists them in.
+// This is the order filepath.Walk visits a single directory in, so its image stays the same
+func (t *tarTree) sorted() []tarTreeEntry {
+	entries := make([]tarTreeEntry, 0, len(t.entries))
+	for _, entry := range t.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return lessTarPath(entries[i].relPath, entries[j].relPath)
+	})
+	return entries
+}
+
+// lessTarPath compares paths one segment at a time, the root being before everything else
+func lessTarPath(a, b string) bool {
+	aSegments := tarPathSegments(a)
+	bSegments := tarPathSegments(b)
+	for k := 0; k < len(aSegments) && k < len(bSegments); k++ {
+		if aSegments[k] != bSegments[k] {
+			return aSegments[k] < bSegments[k]
+		}
+	}
+	return len(aSegments) < len(bSegments)
+}
+
+func tarPathSegments(relPath string) []string {
+	if relPath == "." {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(relPath), "/")
+}
+
+// sameFileContent checks if the files of both entries have the same content
+func sameFileContent(a, b tarTreeEntry) (bool, error) {
+	if a.fullPath == b.fullPath {
+		return true, nil
+	}
+	if a.info.Size() != b.info.Size() {
+		return false, nil
+	}
+
+	aFile, err := os.Open(a.fullPath)
+	if err != nil {
+		return false, err
+	}
+	defer aFile.Close()
+	bFile, err := os.Open(b.fullPath)
+	if err != nil {
+		return false, err
+	}
+	defer bFile.Close()
+
+	aBuf := make([]byte, 32*1024)
+	bBuf := make([]byte, 32*1024)
+	for {
+		aRead, aErr := io.ReadFull(aFile, aBuf)
+		bRead, bErr := io.ReadFull(bFile, bBuf)
+		if !bytes.Equal(aBuf[:aRead], bBuf[:bRead]) {
+			return false, nil
+		}
+		if aErr == io.EOF || aErr == io.ErrUnexpectedEOF {
+			return bErr == io.EOF || bErr == io.ErrUnexpectedEOF, nil
+		}
+		if aErr != nil {
+			return false, aErr
+		}
+		if bErr != nil {
+			return false, bErr
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	// opaqueWhiteout marks a directory whose content from older layers is hidden
+	opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// whiteouts keeps track of the entries removed by whiteout files, and of the entries found in each layer, while the
+// layers are walked from the newest to the oldest, so that entries deleted or replaced by a newer layer can be
+// ignored when they are found in older layers. Within a layer the entries are applied in order, the last one for a
+// path wins
+type whiteouts struct {
+	// removedPaths paths removed by a whiteout and the layer where the whiteout was found
+	removedPaths map[string]int
+	// opaqueDirs directories with an opaque whiteout and the layer where it was found
+	opaqueDirs map[string]int
+	// layerPaths paths of the entries found so far, including their parent directories, and the newest layer with
+	// an entry at the path
+	layerPaths map[string]layerPath
+	layer      int
+	// raw whiteout files are kept as regular entries instead of removing paths
+	raw bool
+}
+
+func newWhiteouts() *whiteouts {
+	return &whiteouts{removedPaths: map[string]int{}, opaqueDirs: map[string]int{}, layerPaths: map[string]layerPath{}}
+}
+
+// layerPath layer where an entry was found and whether the entry is a directory
+type layerPath struct {
+	layer int
+	dir   bool
+}
+
+// newRawWhiteouts creates whiteouts that never hide an entry, so that whiteout files are extracted like any other
+// file. Used when each layer is extracted on its own
+func newRawWhiteouts() *whiteouts {
+	w := newWhiteouts()
+	w.raw = true
+	return w
+}
+
+// NextLayer must be called before the entries of each layer are processed
+func (w *whiteouts) NextLayer() {
+	w.layer++
+}
+
+// Layer returns the number of the layer being processed, starting at 1 for the newest layer
+func (w *whiteouts) Layer() int {
+	return w.layer
+}
+
+// Opaque when the entry at path is an opaque whiteout, records that the content of its directory from older
+// layers is hidden. Entries from the layer with the opaque whiteout are still extracted
+func (w *whiteouts) Opaque(path string) bool {
+	if w.raw || filepath.Base(path) != opaqueWhiteout {
+		return false
+	}
+
+	dir := filepath.Dir(path)
+	if _, found := w.opaqueDirs[dir]; !found {
+		w.opaqueDirs[dir] = w.layer
+	}
+	return true
+}
+
+// Whiteout when the entry at path is a whiteout file, records that the path it removes is hidden in older layers
+// and returns it
+func (w *whiteouts) Whiteout(path string) (string, bool) {
+	base := filepath.Base(path)
+	if w.raw || !strings.HasPrefix(base, whiteoutPrefix) {
+		return "", false
+	}
+
+	removedPath := filepath.Join(filepath.Dir(path), strings.TrimPrefix(base, whiteoutPrefix))
+	if _, found := w.removedPaths[removedPath]; !found {
+		w.removedPaths[removedPath] = w.layer
+	}
+	// entries found earlier in the same layer are deleted, the ones of newer layers are kept
+	if recorded, found := w.layerPaths[removedPath]; found && recorded.layer == w.layer {
+		w.forgetChildren(removedPath)
+		delete(w.layerPaths, removedPath)
+	}
+	return removedPath, true
+}
+
+// Hidden checks if a newer layer removed the entry at path, one of its parent directories, or made one of its
+// parent directories opaque
+func (w *whiteouts) Hidden(path string) bool {
+	if layer, found := w.removedPaths[path]; found && layer != w.layer {
+		return true
+	}
+	for dir := filepath.Dir(path); dir != path; path, dir = dir, filepath.Dir(dir) {
+		if layer, found := w.removedPaths[dir]; found && layer != w.layer {
+			return true
+		}
+		if layer, found := w.opaqueDirs[dir]; found && layer != w.layer {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaced checks if a newer layer has an entry at path, unless both entries are directories, or an entry that is
+// not a directory at one of its parent directories
+func (w *whiteouts) Replaced(path string, dir bool) bool {
+	if recorded, found := w.layerPaths[path]; found && recorded.layer != w.layer && !(recorded.dir && dir) {
+		return true
+	}
+	for parent := filepath.Dir(path); parent != path; path, parent = parent, filepath.Dir(parent) {
+		if recorded, found := w.layerPaths[parent]; found && recorded.layer != w.layer && !recorded.dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Add records the entry at path found in the current layer, and its parent directories. An entry that replaces a
+// directory found earlier in the same layer also replaces the entries found inside of it
+func (w *whiteouts) Add(path string, dir bool) {
+	recorded, found := w.layerPaths[path]
+	switch {
+	case found && recorded.layer != w.layer:
+		// only a directory of a newer layer can be found again, it stays recorded for the newer layer
+	case found && recorded.dir && !dir:
+		w.forgetChildren(path)
+		w.layerPaths[path] = layerPath{layer: w.layer, dir: dir}
+	default:
+		w.layerPaths[path] = layerPath{layer: w.layer, dir: dir}
+	}
+
+	for parent := filepath.Dir(path); parent != path; path, parent = parent, filepath.Dir(parent) {
+		if _, found := w.layerPaths[parent]; found {
+			break
+		}
+		w.layerPaths[parent] = layerPath{layer: w.layer, dir: true}
+	}
+}
+
+// forgetChildren removes the entries found in the current layer inside of the directory at path
+func (w *whiteouts) forgetChildren(path string) {
+	prefix := path + string(filepath.Separator)
+	for childPath, recorded := range w.layerPaths {
+		if recorded.layer == w.layer && strings.HasPrefix(childPath, prefix) {
+			delete(w.layerPaths, childPath)
+		}
+	}
+}
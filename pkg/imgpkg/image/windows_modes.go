@@ -0,0 +1,65 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// normalizesWindowsModes gives the files and directories pushed the modes of windowsFileMode and windowsDirMode, it
+// is a variable so that tests can check it on other platforms
+var normalizesWindowsModes = runtime.GOOS == "windows"
+
+// windowsExecutableExts extensions of the files that are executable when pushed from Windows
+var windowsExecutableExts = map[string]bool{".sh": true, ".bash": true, ".exe": true}
+
+// windowsFileMode returns the mode the file at fullPath is pushed with from Windows, where the mode of a file only
+// tells if it is read-only: 0644, or 0755 when it has an extension of windowsExecutableExts or starts with '#!', without
+// the write permission when read-only. These are the modes the same files usually have on other platforms, so that
+// the image has the same digest wherever it is pushed from
+func windowsFileMode(fullPath string, mode os.FileMode) (os.FileMode, error) {
+	perm := os.FileMode(0644)
+	executable, err := isWindowsExecutable(fullPath)
+	if err != nil {
+		return 0, err
+	}
+	if executable {
+		perm = 0755
+	}
+	if mode&0200 == 0 {
+		perm &^= 0222
+	}
+	return mode&^os.ModePerm | perm, nil
+}
+
+// windowsDirMode returns the mode a directory is pushed with from Windows, 0755 as it usually is on other platforms
+func windowsDirMode(mode os.FileMode) os.FileMode {
+	return mode&^os.ModePerm | 0755
+}
+
+func isWindowsExecutable(fullPath string) (bool, error) {
+	if windowsExecutableExts[strings.ToLower(filepath.Ext(fullPath))] {
+		return true, nil
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	shebang := make([]byte, 2)
+	_, err = io.ReadFull(file, shebang)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(shebang) == "#!", nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowsModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the modes of the files on other platforms cannot be set on windows")
+	}
+
+	// the same files, with the modes they have when checked out on linux and on windows
+	files := map[string]string{
+		"bin/run":            "#!/bin/sh\necho run\n",
+		"scripts/install.sh": "echo install\n",
+		"tools/tool.EXE":     "MZ",
+		"config/app.yml":     "app: 1\n",
+		"config/empty.yml":   "",
+		"readonly.yml":       "read: only\n",
+	}
+	linuxModes := map[string]os.FileMode{"bin/run": 0755, "scripts/install.sh": 0755, "tools/tool.EXE": 0755, "readonly.yml": 0444}
+	windowsModes := map[string]os.FileMode{"readonly.yml": 0444}
+
+	newFolder := func(fileModes map[string]os.FileMode, defaultMode, dirMode os.FileMode) string {
+		folder := t.TempDir()
+		for file, content := range files {
+			path := filepath.Join(folder, filepath.FromSlash(file))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+			mode, found := fileModes[file]
+			if !found {
+				mode = defaultMode
+			}
+			require.NoError(t, os.Chmod(path, mode))
+		}
+		for _, dir := range []string{".", "bin", "scripts", "tools", "config"} {
+			require.NoError(t, os.Chmod(filepath.Join(folder, dir), dirMode))
+		}
+		return folder
+	}
+	linuxFolder := newFolder(linuxModes, 0644, 0755)
+	windowsFolder := newFolder(windowsModes, 0666, 0777)
+
+	layerDiffID := func(folder string, keepPermissions bool) string {
+		img, err := NewTarImage([]string{folder}, nil, util.NewNoopLogger(), TarImageOpts{KeepPermissions: keepPermissions}).AsFileImage(nil)
+		require.NoError(t, err)
+		defer img.Remove()
+
+		cfg, err := img.ConfigFile()
+		require.NoError(t, err)
+		require.Len(t, cfg.RootFS.DiffIDs, 1)
+		return cfg.RootFS.DiffIDs[0].String()
+	}
+
+	// committed so that a change of the content of images pushed from any platform is noticed
+	expectedDiffIDs := map[bool]string{
+		false: "sha256:b8a60513f3fc7b8104b7f8929471601cc7b397ba736a0f58128dc8e4fc2e8e87",
+		true:  "sha256:a97fc02a08c5c94e1f94172c8a56adce71497f5f1342f5899a2ba7552abb2e5e",
+	}
+
+	for _, keepPermissions := range []bool{false, true} {
+		linuxDiffID := layerDiffID(linuxFolder, keepPermissions)
+
+		normalizesWindowsModes = true
+		windowsDiffID := layerDiffID(windowsFolder, keepPermissions)
+		normalizesWindowsModes = false
+
+		assert.Equal(t, expectedDiffIDs[keepPermissions], linuxDiffID, "keeping permissions: %t", keepPermissions)
+		assert.Equal(t, linuxDiffID, windowsDiffID, "keeping permissions: %t", keepPermissions)
+	}
+}
+
+func TestWindowsFileMode(t *testing.T) {
+	folder := t.TempDir()
+	for file, content := range map[string]string{"run": "#!/usr/bin/env bash\n", "install.Sh": "", "hash.txt": "#", "app.yml": "app: 1\n"} {
+		require.NoError(t, os.WriteFile(filepath.Join(folder, file), []byte(content), 0600))
+	}
+
+	for file, expected := range map[string]os.FileMode{"run": 0755, "install.Sh": 0755, "hash.txt": 0644, "app.yml": 0644} {
+		mode, err := windowsFileMode(filepath.Join(folder, file), 0666)
+		require.NoError(t, err)
+		assert.Equal(t, expected, mode, "mode of '%s'", file)
+	}
+
+	mode, err := windowsFileMode(filepath.Join(folder, "run"), 0444)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0555), mode)
+
+	assert.Equal(t, os.ModeDir|0755, windowsDirMode(os.ModeDir|0777))
+}
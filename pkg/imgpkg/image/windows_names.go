@@ -0,0 +1,93 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// checksWindowsNames checks the names of the entries against the names Windows cannot create, it is a variable so
+// that tests can check it on other platforms
+var checksWindowsNames = runtime.GOOS == "windows"
+
+// windowsReservedNames device names that cannot be used as file names on Windows, even with an extension
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// unsupportedWindowsName checks if a path component is a reserved device name, like 'aux' or 'con.txt', or ends
+// with a dot or a space, which Windows cannot create
+func unsupportedWindowsName(component string) bool {
+	if component == "" || component == "." || component == ".." {
+		return false
+	}
+	if strings.HasSuffix(component, ".") || strings.HasSuffix(component, " ") {
+		return true
+	}
+	device, _, _ := strings.Cut(component, ".")
+	return windowsReservedNames[strings.ToLower(strings.TrimRight(device, " "))]
+}
+
+// escapeWindowsName renames a component that Windows cannot create by replacing the last character of the device
+// name, or the trailing dots and spaces, with its '%XX' hexadecimal code, so 'aux' becomes 'au%78' and 'file.'
+// becomes 'file%2E'
+func escapeWindowsName(component string) string {
+	if !unsupportedWindowsName(component) {
+		return component
+	}
+
+	trimmed := strings.TrimRight(component, ". ")
+	if trimmed != component {
+		var escaped strings.Builder
+		escaped.WriteString(trimmed)
+		for _, char := range component[len(trimmed):] {
+			escaped.WriteString(fmt.Sprintf("%%%02X", char))
+		}
+		return escapeWindowsName(escaped.String())
+	}
+
+	device, rest, found := strings.Cut(component, ".")
+	if found {
+		rest = "." + rest
+	}
+	last := len(device) - 1
+	return fmt.Sprintf("%s%%%02X%s", device[:last], device[last], rest)
+}
+
+// checkWindowsName fails for entries with names Windows cannot create, unless safe names are used, in which case
+// the renamed entries are counted
+func (i *DirImage) checkWindowsName(name string) error {
+	if !checksWindowsNames {
+		return nil
+	}
+	for _, component := range splitImagePath(name) {
+		if !unsupportedWindowsName(component) {
+			continue
+		}
+		if i.opts.WindowsSafeNames {
+			i.renamedEntries++
+			return nil
+		}
+		return fmt.Errorf("Entry '%s' cannot be created on Windows, '%s' is a reserved name or ends with a dot or a space "+
+			"(hint: Use --windows-safe-names to rename it)", name, component)
+	}
+	return nil
+}
+
+// imagePathComponents splits a path from the image in the components used on disk, which are renamed when Windows
+// cannot create them and safe names are used
+func (i *DirImage) imagePathComponents(fPath string) []string {
+	components := splitImagePath(fPath)
+	if !checksWindowsNames || !i.opts.WindowsSafeNames {
+		return components
+	}
+	for idx, component := range components {
+		components[idx] = escapeWindowsName(component)
+	}
+	return components
+}
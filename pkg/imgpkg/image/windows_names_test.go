@@ -0,0 +1,121 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHydrateFilepath(t *testing.T) {
+	dirImage := &DirImage{dirPath: "out"}
+
+	for name, expected := range map[string]string{
+		"config/values.yml":        filepath.Join("out", "config", "values.yml"),
+		"config\\values.yml":       filepath.Join("out", "config", "values.yml"),
+		"./config/nested/a.yml":    filepath.Join("out", "config", "nested", "a.yml"),
+		".\\config\\nested\\a.yml": filepath.Join("out", "config", "nested", "a.yml"),
+		".":                        "out",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, expected, dirImage.hydrateFilepath(name))
+		})
+	}
+
+	t.Run("when safe names are used on Windows, it renames the components Windows cannot create", func(t *testing.T) {
+		useWindowsNames(t)
+		dirImage := &DirImage{dirPath: "out", opts: DirImageOpts{WindowsSafeNames: true}}
+
+		assert.Equal(t, filepath.Join("out", "co%6E", "au%78.yml"), dirImage.hydrateFilepath("con\\aux.yml"))
+	})
+}
+
+func TestEscapeWindowsName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"values.yml": "values.yml",
+		"auxiliary":  "auxiliary",
+		"aux":        "au%78",
+		"AUX":        "AU%58",
+		"con.txt":    "co%6E.txt",
+		"nul.tar.gz": "nu%6C.tar.gz",
+		"com1":       "com%31",
+		"lpt9.log":   "lpt%39.log",
+		"file.":      "file%2E",
+		"dir ":       "dir%20",
+		"file. ":     "file%2E%20",
+		"aux.":       "aux%2E",
+		"..":         "..",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, expected, escapeWindowsName(name))
+			assert.False(t, unsupportedWindowsName(escapeWindowsName(name)))
+		})
+	}
+}
+
+func TestDirImageWindowsNames(t *testing.T) {
+	useWindowsNames(t)
+	img := windowsNamesImage(t)
+
+	t.Run("it fails with the entry Windows cannot create", func(t *testing.T) {
+		err := NewDirImage(t.TempDir(), img, util.NewNoopLogger()).AsDirectory()
+		require.ErrorContains(t, err, "Entry 'config/aux.yml' cannot be created on Windows, 'aux.yml' is a reserved name or ends with a dot or a space "+
+			"(hint: Use --windows-safe-names to rename it)")
+	})
+
+	t.Run("when using safe names, it renames the entries Windows cannot create", func(t *testing.T) {
+		folder := t.TempDir()
+		logs := bytes.NewBuffer(nil)
+		opts := DirImageOpts{WindowsSafeNames: true}
+		require.NoError(t, NewDirImageWithOpts(folder, img, opts, util.NewBufferLogger(logs)).AsDirectory())
+
+		for _, path := range []string{"config/au%78.yml", "config/link.yml", "config/values.yml"} {
+			content, err := os.ReadFile(filepath.Join(folder, path))
+			require.NoError(t, err)
+			assert.Equal(t, "aux", string(content))
+		}
+		assert.Contains(t, logs.String(), "Renamed 1 entries whose names are not supported on Windows")
+	})
+}
+
+// useWindowsNames checks the names of the entries as if running on Windows
+func useWindowsNames(t *testing.T) {
+	checksWindowsNames = true
+	t.Cleanup(func() { checksWindowsNames = false })
+}
+
+func windowsNamesImage(t *testing.T) regv1.Image {
+	buf := bytes.NewBuffer(nil)
+	tarWriter := tar.NewWriter(buf)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "config/aux.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}))
+	_, err := tarWriter.Write([]byte("aux"))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "config/link.yml", Linkname: "config/aux.yml", Typeflag: tar.TypeLink, Mode: 0644}))
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "config\\values.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}))
+	_, err = tarWriter.Write([]byte("aux"))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+
+	layerBytes := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(layerBytes)), nil
+	})
+	require.NoError(t, err)
+
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
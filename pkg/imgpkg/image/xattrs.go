@@ -0,0 +1,51 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+// errXattrsNotSupported returned when extended attributes cannot be set on the current platform
+var errXattrsNotSupported = errors.New("extended attributes are not supported on this platform")
+
+// setXattrs restores the extended attributes recorded in the PAX records of the entry. Attributes that the
+// platform, or the filesystem of the output directory, does not support are skipped and reported at the end
+func (i *DirImage) setXattrs(header *tar.Header, path string) error {
+	var names []string
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, paxXattrPrefix) {
+			names = append(names, strings.TrimPrefix(key, paxXattrPrefix))
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := setXattr(path, name, header.PAXRecords[paxXattrPrefix+name])
+		if err != nil {
+			if !xattrNotSupported(err) {
+				return fmt.Errorf("Setting extended attribute '%s' on '%s': %s", name, header.Name, err)
+			}
+			if i.skippedXattrs == 0 {
+				i.skippedXattrsReason = fmt.Sprintf("'%s' on '%s': %s", name, header.Name, err)
+			}
+			i.skippedXattrs++
+		}
+	}
+	return nil
+}
+
+// xattrNotSupported checks if the error means that the attribute cannot be set in the current environment,
+// like filesystems without extended attributes, or namespaces that require privileges
+func xattrNotSupported(err error) bool {
+	return errors.Is(err, errXattrsNotSupported) || errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,11 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package image
+
+import "golang.org/x/sys/unix"
+
+// setXattr sets the extended attribute without following symlinks
+func setXattr(path, name, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
@@ -0,0 +1,11 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package image
+
+// setXattr extended attributes are only restored on Linux
+func setXattr(_, _, _ string) error {
+	return errXattrsNotSupported
+}
@@ -8,9 +8,9 @@ import (
 	"fmt"
 	"io"
 
-	"carvel.dev/imgpkg/pkg/imgpkg/imageutils/gzip"
 	"carvel.dev/imgpkg/pkg/imgpkg/imageutils/verify"
 	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
@@ -53,16 +53,26 @@ func (l DescribedCompressedLayer) Compressed() (io.ReadCloser, error) {
 	return rc, nil
 }
 
-// Uncompressed returns a reader for the Layer uncompressed
+// Uncompressed returns a reader for the Layer uncompressed, the compression of the layer, gzip, zstd or none, is
+// detected from its content
 func (l DescribedCompressedLayer) Uncompressed() (io.ReadCloser, error) {
-	rc, err := l.Compressed()
+	layer, err := partial.CompressedToLayer(compressedOnlyLayer{l})
 	if err != nil {
 		return nil, err
 	}
+	return layer.Uncompressed()
+}
 
-	return gzip.UnzipReadCloser(rc)
+// compressedOnlyLayer hides the uncompressed side of the layer, so that partial.CompressedToLayer decompresses it
+type compressedOnlyLayer struct {
+	layer DescribedCompressedLayer
 }
 
+func (l compressedOnlyLayer) Digest() (regv1.Hash, error)         { return l.layer.Digest() }
+func (l compressedOnlyLayer) Compressed() (io.ReadCloser, error)  { return l.layer.Compressed() }
+func (l compressedOnlyLayer) Size() (int64, error)                { return l.layer.Size() }
+func (l compressedOnlyLayer) MediaType() (types.MediaType, error) { return l.layer.MediaType() }
+
 // Size returns the size of the Layer
 func (l DescribedCompressedLayer) Size() (int64, error) { return l.desc.Size, nil }
 
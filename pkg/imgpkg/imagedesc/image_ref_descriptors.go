@@ -17,7 +17,6 @@ import (
 	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
 	regtran "github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	regtypes "github.com/google/go-containerregistry/pkg/v1/types"
-	"golang.org/x/sync/errgroup"
 )
 
 type Registry interface {
@@ -54,7 +53,9 @@ func NewImageRefDescriptorsFromBytes(data []byte) (*ImageRefDescriptors, error)
 	return &ImageRefDescriptors{descs: descs}, nil
 }
 
-func NewImageRefDescriptors(refs []Metadata, registry Registry) (*ImageRefDescriptors, error) {
+// NewImageRefDescriptors reads the manifests of the images, concurrency of them at a time. The images that cannot be
+// read are all reported in the error
+func NewImageRefDescriptors(refs []Metadata, registry Registry, concurrency int) (*ImageRefDescriptors, error) {
 	registry = errRegistry{registry}
 
 	imageRefDescs := &ImageRefDescriptors{
@@ -63,51 +64,60 @@ func NewImageRefDescriptors(refs []Metadata, registry Registry) (*ImageRefDescri
 	}
 
 	var imageRefDescsLock sync.Mutex
-	var wg errgroup.Group
-	buildThrottle := util.NewThrottle(10)
+	var wg sync.WaitGroup
+	var errs util.ImageErrors
+	buildThrottle := util.NewThrottle(concurrency)
 
 	for _, ref := range refs {
 		ref := ref //copy
 
-		wg.Go(func() error {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 			buildThrottle.Take()
 			defer buildThrottle.Done()
 
-			regDesc, err := registry.Get(ref.Ref)
-			if err != nil {
-				return err
-			}
+			errs.Add(imageRefDescs.build(ref, &imageRefDescsLock))
+		}()
+	}
 
-			var td ImageOrImageIndexDescriptor
+	wg.Wait()
 
-			if imageRefDescs.isImageIndex(regDesc.Descriptor) {
-				imgIndexTd, err := imageRefDescs.buildImageIndex(ref, regDesc.Descriptor)
+	return imageRefDescs, errs.Err()
+}
 
-				if err != nil {
-					return err
-				}
+// build reads the manifest of the image, or index, and adds its descriptor
+func (ids *ImageRefDescriptors) build(ref Metadata, lock *sync.Mutex) error {
 
-				td = ImageOrImageIndexDescriptor{ImageIndex: &imgIndexTd}
-			} else {
-				img, err := imageRefDescs.buildImage(ref)
-				if err != nil {
-					return err
-				}
+	regDesc, err := ids.registry.Get(ref.Ref)
+	if err != nil {
+		return err
+	}
 
-				td = ImageOrImageIndexDescriptor{Image: &img}
-			}
+	var td ImageOrImageIndexDescriptor
 
-			imageRefDescsLock.Lock()
-			imageRefDescs.descs = append(imageRefDescs.descs, td)
-			imageRefDescsLock.Unlock()
+	if ids.isImageIndex(regDesc.Descriptor) {
+		imgIndexTd, err := ids.buildImageIndex(ref, regDesc.Descriptor)
+
+		if err != nil {
+			return err
+		}
+
+		td = ImageOrImageIndexDescriptor{ImageIndex: &imgIndexTd}
+	} else {
+		img, err := ids.buildImage(ref)
+		if err != nil {
+			return err
+		}
 
-			return nil
-		})
+		td = ImageOrImageIndexDescriptor{Image: &img}
 	}
 
-	err := wg.Wait()
+	lock.Lock()
+	ids.descs = append(ids.descs, td)
+	lock.Unlock()
 
-	return imageRefDescs, err
+	return nil
 }
 
 func (ids *ImageRefDescriptors) Descriptors() []ImageOrImageIndexDescriptor {
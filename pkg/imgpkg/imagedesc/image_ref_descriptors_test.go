@@ -0,0 +1,41 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package imagedesc_test
+
+import (
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/imagedesc"
+	"carvel.dev/imgpkg/test/helpers"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewImageRefDescriptors(t *testing.T) {
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{})
+	defer fakeRegistry.CleanUp()
+	image := fakeRegistry.WithRandomImage("library/image")
+	reg := fakeRegistry.Build()
+
+	imageRef, err := name.NewDigest(image.RefDigest)
+	require.NoError(t, err)
+	missingRef1 := imageRef.Context().Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	missingRef2 := imageRef.Context().Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+
+	t.Run("it describes every image", func(t *testing.T) {
+		ids, err := imagedesc.NewImageRefDescriptors([]imagedesc.Metadata{{Ref: imageRef}}, reg, 2)
+		require.NoError(t, err)
+		require.Len(t, ids.Descriptors(), 1)
+		assert.Equal(t, image.Digest, ids.Descriptors()[0].Image.Manifest.Digest)
+	})
+
+	t.Run("it reports every image that cannot be read, not only the first one", func(t *testing.T) {
+		_, err := imagedesc.NewImageRefDescriptors([]imagedesc.Metadata{{Ref: missingRef1}, {Ref: imageRef}, {Ref: missingRef2}}, reg, 2)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "2 images failed:")
+		assert.Contains(t, err.Error(), "Working with "+missingRef1.Name())
+		assert.Contains(t, err.Error(), "Working with "+missingRef2.Name())
+	})
+}
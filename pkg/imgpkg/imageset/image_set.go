@@ -63,7 +63,7 @@ func (i ImageSet) Export(foundImages *UnprocessedImageRefs,
 		refs = append(refs, imagedesc.Metadata{Ref: ref, Tag: img.Tag, Labels: img.Labels, OrigRef: img.OrigRef})
 	}
 
-	ids, err := imagedesc.NewImageRefDescriptors(refs, imagesMetadata)
+	ids, err := imagedesc.NewImageRefDescriptors(refs, imagesMetadata, i.metadataConcurrency())
 	if err != nil {
 		return nil, fmt.Errorf("Collecting packaging metadata: %s", err)
 	}
@@ -78,7 +78,7 @@ func (i *ImageSet) Import(imgOrIndexes []imagedesc.ImageOrIndex,
 
 	i.logger.Logf("importing %d images...\n", len(imgOrIndexes))
 
-	importThrottle := util.NewThrottle(i.concurrency)
+	importThrottle := util.NewThrottle(i.metadataConcurrency())
 
 	imageOrIndexesToWrite := map[regname.Reference]regremote.Taggable{}
 	var imageOrIndexesToWriteLock = &sync.Mutex{}
@@ -91,7 +91,7 @@ func (i *ImageSet) Import(imgOrIndexes []imagedesc.ImageOrIndex,
 			defer importThrottle.Done()
 			tag, taggable, err := i.getImageOrImageIndexForMultiWrite(item, importRepo, registry)
 			if err != nil {
-				errCh <- err
+				errCh <- fmt.Errorf("Preparing the upload of %s: %s", item.Ref(), err)
 				return
 			}
 			imageOrIndexesToWriteLock.Lock()
@@ -136,14 +136,23 @@ func (i *ImageSet) Import(imgOrIndexes []imagedesc.ImageOrIndex,
 	return importedImages, nil
 }
 
+// checkForAnyAsyncErrors waits for every image to be processed, and reports the errors of all those that failed
 func checkForAnyAsyncErrors(imgOrIndexes []imagedesc.ImageOrIndex, errCh chan error) error {
+	var errs util.ImageErrors
 	for i := 0; i < len(imgOrIndexes); i++ {
-		err := <-errCh
-		if err != nil {
-			return err
-		}
+		errs.Add(<-errCh)
 	}
-	return nil
+	return errs.Err()
+}
+
+// metadataConcurrency number of images whose manifests are read, or whose existence is checked, in parallel. These
+// requests are small compared to the blobs, which --concurrency is about, so they are never done fewer at a time than
+// the 10 they always were
+func (i ImageSet) metadataConcurrency() int {
+	if i.concurrency > 10 {
+		return i.concurrency
+	}
+	return 10
 }
 
 func (i ImageSet) getImageOrImageIndexForMultiWrite(item imagedesc.ImageOrIndex, importRepo regname.Repository, registry registry.ImagesReaderWriter) (regname.Tag, regremote.Taggable, error) {
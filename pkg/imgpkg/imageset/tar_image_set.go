@@ -4,6 +4,7 @@
 package imageset
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -33,6 +34,13 @@ func (i TarImageSet) Export(foundImages *UnprocessedImageRefs, outputPath string
 		return nil, err
 	}
 
+	if resume {
+		resumed, err := i.resumeInPlace(ids, outputPath, imageLayerWriterCheck)
+		if resumed || err != nil {
+			return ids, err
+		}
+	}
+
 	var outputFile *os.File
 	var alreadyDownloadedLayers []v1.Layer
 
@@ -122,6 +130,36 @@ func (i TarImageSet) Export(foundImages *UnprocessedImageRefs, outputPath string
 	return ids, err
 }
 
+// resumeInPlace writes the layers the tar at outputPath is missing, or has corrupted, in place when it was written with
+// the same images, leaving the layers it has as they are. It returns false, without writing the tar, when there is no
+// tar at outputPath or it was written with other images
+func (i TarImageSet) resumeInPlace(ids *imagedesc.ImageRefDescriptors, outputPath string, imageLayerWriterCheck imagetar.ImageLayerWriterFilter) (bool, error) {
+	manifest, writtenLayers, err := imagetar.NewTarReader(outputPath).WrittenLayers()
+	if err != nil {
+		return false, nil
+	}
+
+	idsBytes, err := ids.AsBytes()
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(manifest, idsBytes) {
+		return false, nil
+	}
+
+	i.logger.Logf("Going to reuse %d layers from the tar already in disk\n", len(writtenLayers))
+
+	outputFileOpener := func() (io.WriteCloser, error) {
+		return os.OpenFile(outputPath, os.O_RDWR, 0755)
+	}
+
+	i.logger.Logf("writing layers...\n")
+
+	opts := imagetar.TarWriterOpts{Concurrency: i.concurrency, WrittenLayers: writtenLayers}
+
+	return true, imagetar.NewTarWriter(ids, outputFileOpener, opts, i.logger, imageLayerWriterCheck, nil).Write()
+}
+
 // Import Copy tar with Images to the Registry
 func (i *TarImageSet) Import(path string, importRepo regname.Repository, registry registry.ImagesReaderWriter) (*ProcessedImages, error) {
 	imgOrIndexes, err := imagetar.NewTarReader(path).Read()
@@ -11,6 +11,30 @@ import (
 	regname "github.com/google/go-containerregistry/pkg/name"
 )
 
+// RootBundleLabelKey label of the bundle that was copied, as opposed to its images and nested bundles, in the tars
+// created by copy --to-tar
+const RootBundleLabelKey = "dev.carvel.imgpkg.copy.root-bundle"
+
+// AttachedKindLabelKey label of the signatures, SBOMs and other artifacts copied because they are attached to an
+// image, with their kind
+const AttachedKindLabelKey = "dev.carvel.imgpkg.copy.attached-kind"
+
+// AttachedToLabelKey label of the artifacts copied because they are attached to an image, with the digest of the
+// image, which is in the same repository as they are
+const AttachedToLabelKey = "dev.carvel.imgpkg.copy.attached-to"
+
+// OmittedImagesLabelKey label of the bundle that was copied, with the images of its images lock, and of those of its
+// nested bundles, filtered out of the copy, comma separated
+const OmittedImagesLabelKey = "dev.carvel.imgpkg.copy.omitted-images"
+
+// OrigTagLabelKey label of the images of a bundle, or of an images lock, with the tag reference they were resolved
+// from, such as postgres:14.9, kept in tars so that copy --preserve-tags can tag them after they are imported
+const OrigTagLabelKey = "dev.carvel.imgpkg.copy.orig-tag"
+
+// PreservedTagLabelKey label of the images copied with --preserve-tags, with the tag derived from OrigTagLabelKey
+// they were given in the destination repository
+const PreservedTagLabelKey = "dev.carvel.imgpkg.copy.preserved-tag"
+
 type UnprocessedImageRef struct {
 	DigestRef string
 	Tag       string
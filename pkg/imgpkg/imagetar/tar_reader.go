@@ -4,8 +4,11 @@
 package imagetar
 
 import (
+	"archive/tar"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/imagedesc"
 	"carvel.dev/imgpkg/pkg/imgpkg/imageutils/verify"
@@ -60,6 +63,66 @@ func (r TarReader) PresentLayers() ([]v1.Layer, error) {
 	return result, nil
 }
 
+// WrittenEntry entry of a layer in a tar, from the offset of its header to the end of its contents, padding included
+type WrittenEntry struct {
+	Offset int64
+	End    int64
+	Size   int64
+}
+
+// WrittenLayers reads the manifest of a tar and the entries of its layers, an entry being kept only when its contents
+// match the digest in its name. The tar is read up to its end, or up to the first entry it does not fully contain
+// when the tar was not fully written
+func (r TarReader) WrittenLayers() ([]byte, map[string]WrittenEntry, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var manifest []byte
+	written := map[string]WrittenEntry{}
+	src := &countingReader{reader: file}
+	tf := tar.NewReader(src)
+	for {
+		offset := blockAligned(src.count)
+		hdr, err := tf.Next()
+		if err != nil {
+			// io.EOF at the end of the tar, or an error reading an entry it does not fully contain
+			break
+		}
+
+		if hdr.Name == "manifest.json" {
+			manifest, err = io.ReadAll(tf)
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		digest, err := v1.NewHash(strings.Replace(strings.TrimSuffix(hdr.Name, ".tar.gz"), "-", ":", 1))
+		if err != nil {
+			// skips the contents of entries that are not layers, so that the offset of the next one is counted
+			_, err = io.Copy(io.Discard, tf)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		contents, err := verify.ReadCloser(io.NopCloser(tf), hdr.Size, digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = io.Copy(io.Discard, contents)
+		if err != nil {
+			// contents that were not fully written, or were corrupted, are written again
+			continue
+		}
+		written[hdr.Name] = WrittenEntry{Offset: offset, End: blockAligned(src.count), Size: hdr.Size}
+	}
+	return manifest, written, nil
+}
+
 func (r TarReader) presentLayersForImage(img v1.Image) ([]v1.Layer, error) {
 	var result []v1.Layer
 	layers, err := img.Layers()
@@ -148,3 +211,20 @@ func (r TarReader) getIdsFromManifest(file tarFile) (*imagedesc.ImageRefDescript
 	}
 	return ids, nil
 }
+
+// countingReader counts the bytes read, which the tar reader reads a block at a time
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// blockAligned rounds offset up to the 512 bytes blocks of tar entries
+func blockAligned(offset int64) int64 {
+	return (offset + 511) / 512 * 512
+}
@@ -23,6 +23,9 @@ type Logger interface {
 
 type TarWriterOpts struct {
 	Concurrency int
+	// WrittenLayers layers the destination already has, which are not written again when they are at the offset they
+	// would be written at
+	WrittenLayers map[string]WrittenEntry
 }
 
 type TarWriter struct {
@@ -94,7 +97,24 @@ func (w *TarWriter) Write() error {
 		}
 	}
 
-	return w.writeLayers()
+	err = w.writeLayers()
+	if err != nil {
+		return err
+	}
+
+	err = w.tf.Close()
+	if err != nil {
+		return err
+	}
+	// a destination that is written again may have been longer
+	if seekableDst, isSeekable := w.dst.(*os.File); isSeekable {
+		end, err := seekableDst.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("Find current pos: %s", err)
+		}
+		return seekableDst.Truncate(end)
+	}
+	return nil
 }
 
 func (w *TarWriter) writeImageIndex(td imagedesc.ImageIndexDescriptor) error {
@@ -142,7 +162,8 @@ func (w *TarWriter) writeLayers() error {
 
 	seekableDst, isSeekable := w.dst.(*os.File)
 	isInflatable := (w.opts.Concurrency > 1) && isSeekable
-	writtenLayers := map[string]writtenLayer{}
+	writtenLayers := map[string]bool{}
+	layersToFillIn := map[string]writtenLayer{}
 
 	// Inflate tar file so that multiple writes can happen in parallel
 	for _, imgLayer := range w.layersToWrite {
@@ -154,9 +175,10 @@ func (w *TarWriter) writeLayers() error {
 		name := digest.Algorithm + "-" + digest.Hex + ".tar.gz"
 
 		// Dedup layers
-		if _, found := writtenLayers[name]; found {
+		if writtenLayers[name] {
 			continue
 		}
+		writtenLayers[name] = true
 
 		err = w.tf.Flush()
 		if err != nil {
@@ -167,30 +189,36 @@ func (w *TarWriter) writeLayers() error {
 		var currPos int64
 
 		if isSeekable {
-			currPos, err = seekableDst.Seek(0, 1)
+			currPos, err = seekableDst.Seek(0, io.SeekCurrent)
 			if err != nil {
 				return fmt.Errorf("Find current pos: %s", err)
 			}
-		}
 
-		if isInflatable {
-			stream = nil
-		} else {
-			for _, layer := range w.layersFromOtherSource {
-				d, err := layer.Digest()
+			// The layout of the archive being deterministic, a layer the destination has at the same offset is
+			// left as is
+			if written, found := w.opts.WrittenLayers[name]; found && written.Offset == currPos && written.Size == imgLayer.Size {
+				_, err = seekableDst.Seek(written.End, io.SeekStart)
 				if err != nil {
-					return fmt.Errorf("Retrieving digest: %s", err)
-				}
-				if d.String() == imgLayer.Digest {
-					stream, err = layer.Compressed()
-					if err != nil {
-						return fmt.Errorf("Retrieve layer from file: %s", err)
-					}
-					break
+					return fmt.Errorf("Seeking to offset: %s", err)
 				}
+				w.logger.Logf("skipped: file '%s' already in the tar\n", name)
+				continue
 			}
+		}
 
-			if stream == nil {
+		stream, err = w.layerFromOtherSource(imgLayer)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			if isInflatable {
+				layersToFillIn[name] = writtenLayer{
+					Name:   name,
+					Layer:  imgLayer,
+					Offset: currPos,
+				}
+			} else {
 				foundLayer, err := w.ids.FindLayer(imgLayer)
 				if err != nil {
 					return err
@@ -207,12 +235,6 @@ func (w *TarWriter) writeLayers() error {
 		if err != nil {
 			return fmt.Errorf("Writing tar entry: %s", err)
 		}
-
-		writtenLayers[name] = writtenLayer{
-			Name:   name,
-			Layer:  imgLayer,
-			Offset: currPos,
-		}
 	}
 
 	err := w.tf.Flush()
@@ -221,12 +243,30 @@ func (w *TarWriter) writeLayers() error {
 	}
 
 	if isInflatable {
-		return w.fillInLayers(writtenLayers)
+		return w.fillInLayers(layersToFillIn)
 	}
 
 	return nil
 }
 
+// layerFromOtherSource opens the layer when it is one of layersFromOtherSource, returns nil otherwise
+func (w *TarWriter) layerFromOtherSource(imgLayer imagedesc.ImageLayerDescriptor) (io.Reader, error) {
+	for _, layer := range w.layersFromOtherSource {
+		d, err := layer.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("Retrieving digest: %s", err)
+		}
+		if d.String() == imgLayer.Digest {
+			stream, err := layer.Compressed()
+			if err != nil {
+				return nil, fmt.Errorf("Retrieve layer from file: %s", err)
+			}
+			return stream, nil
+		}
+	}
+	return nil, nil
+}
+
 func (w *TarWriter) fillInLayers(writtenLayers map[string]writtenLayer) error {
 	var sortedWrittenLayers []writtenLayer
 
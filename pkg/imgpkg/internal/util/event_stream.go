@@ -0,0 +1,184 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Types of the events written by EventStream
+const (
+	// EventPhase the command started a phase, such as building, uploading or extracting
+	EventPhase = "phase"
+	// EventProgress bytes transferred of a blob, or of the images of the phase
+	EventProgress = "progress"
+	// EventRetry a request to the registry failed and is sent again
+	EventRetry = "retry"
+	// EventRateLimited the registry rate limits the requests, which are paused
+	EventRateLimited = "rateLimited"
+	// EventError a blob, or an image, failed to be transferred, the command can still succeed
+	EventError = "error"
+	// EventDone terminal event of a command that succeeded, with the digest and the stats
+	EventDone = "done"
+	// EventFailed terminal event of a command that failed, with its error
+	EventFailed = "failed"
+)
+
+// StreamEvent event written by EventStream, one JSON object per line. push, copy and pull write the same events so
+// that a single consumer handles the three commands
+type StreamEvent struct {
+	Command string    `json:"command"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	// Phase the command is in, set on every event once a phase started
+	Phase string `json:"phase,omitempty"`
+	// Image reference of the image the event is about, when the phase is about one
+	Image string `json:"image,omitempty"`
+	// Blob digest of the blob the event is about, only set when the progress of each blob is reported
+	Blob       string `json:"blob,omitempty"`
+	BytesDone  int64  `json:"bytesDone,omitempty"`
+	BytesTotal int64  `json:"bytesTotal,omitempty"`
+	// Host of the registry that is retried or that rate limits the requests
+	Host  string `json:"host,omitempty"`
+	Retry int    `json:"retry,omitempty"`
+	// WaitSeconds wait asked for by a registry rate limiting the requests
+	WaitSeconds float64 `json:"waitSeconds,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	// Digest reference of the image, or bundle, pushed, copied or pulled, set on the terminal event
+	Digest string      `json:"digest,omitempty"`
+	Stats  *EventStats `json:"stats,omitempty"`
+}
+
+// EventStats totals of the command, set on the terminal event. The fields a command does not count are omitted
+type EventStats struct {
+	Images       int   `json:"images,omitempty"`
+	Blobs        int   `json:"blobs,omitempty"`
+	BlobsMounted int   `json:"blobsMounted,omitempty"`
+	BlobsSkipped int   `json:"blobsSkipped,omitempty"`
+	Files        int   `json:"files,omitempty"`
+	Bytes        int64 `json:"bytes,omitempty"`
+	Retries      int   `json:"retries"`
+	// BandwidthLimit bytes per second of --bandwidth-limit, BytesTransferred and BytesPerSecond being what was
+	// uploaded and downloaded under it
+	BandwidthLimit   int64   `json:"bandwidthLimit,omitempty"`
+	BytesTransferred int64   `json:"bytesTransferred,omitempty"`
+	BytesPerSecond   float64 `json:"bytesPerSecond,omitempty"`
+	// DurationSeconds since the stream was created, when the command started
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// EventStream writes the events of a command as newline-delimited JSON, for CI systems displaying the progress in
+// their own UI. The events are written to stderr, stdout being kept for the outputs of the command. A nil
+// EventStream writes nothing, so that the commands report to it whether the stream is enabled or not
+type EventStream struct {
+	out     io.Writer
+	command string
+	started time.Time
+
+	lock    sync.Mutex
+	phase   string
+	image   string
+	retries int
+}
+
+// NewEventStream constructs an EventStream writing the events of command to out
+func NewEventStream(out io.Writer, command string) *EventStream {
+	return &EventStream{out: out, command: command, started: time.Now()}
+}
+
+// Phase reports that the command started phase, about image when it is not empty. The following events are part of it
+func (s *EventStream) Phase(phase, image string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.phase = phase
+	s.image = image
+	s.lock.Unlock()
+	s.write(StreamEvent{Type: EventPhase})
+}
+
+// Retry reports that a request to host failed with reason, and is sent again, retry starting at 1
+func (s *EventStream) Retry(host string, retry int, reason string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.retries++
+	s.lock.Unlock()
+	s.write(StreamEvent{Type: EventRetry, Host: host, Retry: retry, Error: reason})
+}
+
+// RateLimited reports that host rate limits the requests, which are paused for wait
+func (s *EventStream) RateLimited(host string, wait time.Duration) {
+	if s == nil {
+		return
+	}
+	s.write(StreamEvent{Type: EventRateLimited, Host: host, WaitSeconds: wait.Seconds()})
+}
+
+// NewProgress constructs a ProgressLogger writing progress events at most once per interval, about blob when it is
+// not empty, and about image, or the image of the phase when it is empty
+func (s *EventStream) NewProgress(image, blob string, interval time.Duration) ProgressLogger {
+	if s == nil {
+		return NewNoopProgressBar()
+	}
+	return &PeriodicProgressLogger{
+		interval: interval,
+		report: func(update regv1.Update) {
+			event := StreamEvent{Type: EventProgress, Image: image, Blob: blob, BytesDone: update.Complete, BytesTotal: update.Total}
+			if update.Error != nil {
+				event.Type = EventError
+				event.Error = update.Error.Error()
+			}
+			s.write(event)
+		},
+	}
+}
+
+// Finish writes the terminal event, failed with err when it is not nil, otherwise done with digest and stats. The
+// terminal event is not part of a phase
+func (s *EventStream) Finish(digest string, stats EventStats, err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.phase = ""
+	s.image = ""
+	s.lock.Unlock()
+	if err != nil {
+		s.write(StreamEvent{Type: EventFailed, Error: err.Error()})
+		return
+	}
+
+	s.lock.Lock()
+	stats.Retries = s.retries
+	s.lock.Unlock()
+	stats.DurationSeconds = time.Since(s.started).Seconds()
+	s.write(StreamEvent{Type: EventDone, Digest: digest, Stats: &stats})
+}
+
+// write writes event as a line, with the command, the time and the phase it is part of
+func (s *EventStream) write(event StreamEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	event.Command = s.command
+	event.Time = time.Now().UTC()
+	event.Phase = s.phase
+	if event.Image == "" {
+		event.Image = s.image
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		// Progress reporting is best effort, failing to encode it should not stop the operation
+		return
+	}
+	_, _ = s.out.Write(append(eventBytes, '\n'))
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream(t *testing.T) {
+	readEvents := func(t *testing.T, buf *bytes.Buffer) []util.StreamEvent {
+		var events []util.StreamEvent
+		scanner := bufio.NewScanner(buf)
+		for scanner.Scan() {
+			var event util.StreamEvent
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), "every line is a JSON event")
+			assert.False(t, event.Time.IsZero())
+			event.Time = time.Time{}
+			events = append(events, event)
+		}
+		return events
+	}
+
+	t.Run("it writes the events of each phase, followed by the terminal event with the stats", func(t *testing.T) {
+		buf := bytes.NewBufferString("")
+		stream := util.NewEventStream(buf, "push")
+
+		stream.Phase("upload", "registry.io/repo:v1")
+		progress := stream.NewProgress("", "sha256:abc", time.Hour)
+		updates := make(chan regv1.Update)
+		progress.Start(context.Background(), updates)
+		updates <- regv1.Update{Total: 100, Complete: 100}
+		progress.End()
+		stream.Retry("registry.io", 1, "503 Service Unavailable")
+		stream.RateLimited("registry.io", 2*time.Second)
+		stream.Finish("registry.io/repo@sha256:def", util.EventStats{Images: 1, Blobs: 2, Bytes: 100}, nil)
+
+		events := readEvents(t, buf)
+		require.Len(t, events, 5)
+		assert.Equal(t, util.StreamEvent{Command: "push", Type: util.EventPhase, Phase: "upload", Image: "registry.io/repo:v1"}, events[0])
+		assert.Equal(t, util.StreamEvent{Command: "push", Type: util.EventProgress, Phase: "upload", Image: "registry.io/repo:v1",
+			Blob: "sha256:abc", BytesDone: 100, BytesTotal: 100}, events[1])
+		assert.Equal(t, util.StreamEvent{Command: "push", Type: util.EventRetry, Phase: "upload", Image: "registry.io/repo:v1",
+			Host: "registry.io", Retry: 1, Error: "503 Service Unavailable"}, events[2])
+		assert.Equal(t, util.StreamEvent{Command: "push", Type: util.EventRateLimited, Phase: "upload", Image: "registry.io/repo:v1",
+			Host: "registry.io", WaitSeconds: 2}, events[3])
+
+		done := events[4]
+		assert.Equal(t, util.EventDone, done.Type)
+		assert.Equal(t, "", done.Phase, "the terminal event is not part of a phase")
+		assert.Equal(t, "registry.io/repo@sha256:def", done.Digest)
+		require.NotNil(t, done.Stats)
+		assert.GreaterOrEqual(t, done.Stats.DurationSeconds, 0.0)
+		done.Stats.DurationSeconds = 0
+		assert.Equal(t, util.EventStats{Images: 1, Blobs: 2, Bytes: 100, Retries: 1}, *done.Stats)
+	})
+
+	t.Run("it writes a failed terminal event with the error", func(t *testing.T) {
+		buf := bytes.NewBufferString("")
+		stream := util.NewEventStream(buf, "pull")
+
+		stream.Phase("extract", "registry.io/repo:v1")
+		stream.Finish("", util.EventStats{}, fmt.Errorf("Extracting layer: unexpected EOF"))
+
+		events := readEvents(t, buf)
+		require.Len(t, events, 2)
+		assert.Equal(t, util.StreamEvent{Command: "pull", Type: util.EventFailed, Error: "Extracting layer: unexpected EOF"}, events[1])
+	})
+
+	t.Run("a nil stream writes nothing", func(t *testing.T) {
+		var stream *util.EventStream
+		stream.Phase("upload", "")
+		stream.Retry("registry.io", 1, "503 Service Unavailable")
+		stream.Finish("", util.EventStats{}, nil)
+
+		progress := stream.NewProgress("", "", time.Second)
+		updates := make(chan regv1.Update)
+		progress.Start(context.Background(), updates)
+		updates <- regv1.Update{Total: 100, Complete: 100}
+		progress.End()
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ImageErrors errors of the images of an operation, collected so that every image that failed is reported, instead
+// of the first one only. Safe for concurrent use
+type ImageErrors struct {
+	lock sync.Mutex
+	errs []error
+}
+
+// Add the error of an image, nil errors are ignored
+func (e *ImageErrors) Add(err error) {
+	if err == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+// Err returns nil when no image failed, the error of the image when only one did, and an error listing the errors of
+// every image otherwise
+func (e *ImageErrors) Err() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch len(e.errs) {
+	case 0:
+		return nil
+	case 1:
+		return e.errs[0]
+	}
+
+	var msgs []string
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	// images fail in the order they are processed in parallel
+	sort.Strings(msgs)
+
+	msg := fmt.Sprintf("%d images failed:", len(msgs))
+	for _, errMsg := range msgs {
+		msg += "\n- " + errMsg
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageErrors(t *testing.T) {
+	t.Run("when no image failed, it returns nil", func(t *testing.T) {
+		var errs ImageErrors
+		errs.Add(nil)
+		assert.NoError(t, errs.Err())
+	})
+
+	t.Run("when one image failed, it returns its error", func(t *testing.T) {
+		var errs ImageErrors
+		imgErr := errors.New("Working with registry.io/img1: not found")
+		errs.Add(imgErr)
+		assert.Equal(t, imgErr, errs.Err())
+	})
+
+	t.Run("when several images failed, it lists their errors", func(t *testing.T) {
+		var errs ImageErrors
+		errs.Add(errors.New("Working with registry.io/img2: denied"))
+		errs.Add(nil)
+		errs.Add(errors.New("Working with registry.io/img1: not found"))
+		require.EqualError(t, errs.Err(), `2 images failed:
+- Working with registry.io/img1: not found
+- Working with registry.io/img2: denied`)
+	})
+}
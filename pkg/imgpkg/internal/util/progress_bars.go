@@ -0,0 +1,124 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	pb "github.com/cheggaaa/pb/v3"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ProgressBars displays the progress bars of the blobs transferred at the same time, one per line. The bars are
+// rendered together, moving the cursor back to the first one, so that they do not overwrite each other
+type ProgressBars struct {
+	out io.Writer
+
+	lock sync.Mutex
+	bars []*pb.ProgressBar
+	// lines number of lines written by the last rendering
+	lines int
+}
+
+// NewProgressBars constructs ProgressBars displayed in out
+func NewProgressBars(out io.Writer) *ProgressBars {
+	return &ProgressBars{out: out}
+}
+
+// NewBlobProgressBar constructs a ProgressLogger that displays the progress of blob in a bar of b, prefixed with the
+// blob, with the rate and the estimated time left
+func (b *ProgressBars) NewBlobProgressBar(logger LoggerWithLevels, blob, errorMessagePrefix string) ProgressLogger {
+	return &groupedProgressBar{bars: b, logger: logger, blob: blob, errorMessagePrefix: errorMessagePrefix}
+}
+
+// add starts displaying a bar for a blob of total bytes
+func (b *ProgressBars) add(prefix string, total int64) *pb.ProgressBar {
+	bar := pb.Full.New(0)
+	bar.SetTotal(total)
+	bar.Set("prefix", prefix)
+	bar.Set(pb.Bytes, true)
+	// the bar is rendered by ProgressBars instead of by a goroutine of its own
+	bar.Set(pb.Static, true)
+	bar.Start()
+
+	b.lock.Lock()
+	b.bars = append(b.bars, bar)
+	b.lock.Unlock()
+	return bar
+}
+
+// render writes all the bars, over the ones written by the last rendering
+func (b *ProgressBars) render() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var out strings.Builder
+	if b.lines > 0 {
+		fmt.Fprintf(&out, "\033[%dA", b.lines)
+	}
+	for _, bar := range b.bars {
+		fmt.Fprintf(&out, "\r%s\033[K\n", bar.String())
+	}
+	b.lines = len(b.bars)
+	fmt.Fprint(b.out, out.String())
+}
+
+// groupedProgressBar ProgressLogger of a blob displayed by ProgressBars
+type groupedProgressBar struct {
+	bars               *ProgressBars
+	logger             LoggerWithLevels
+	blob               string
+	errorMessagePrefix string
+
+	cancelFunc context.CancelFunc
+	done       chan struct{}
+	bar        *pb.ProgressBar
+}
+
+// Start displays the bar once the size of the blob is known
+func (l *groupedProgressBar) Start(ctx context.Context, progressChan <-chan regv1.Update) {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	l.cancelFunc = cancelFunc
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update := <-progressChan:
+				if update.Error != nil {
+					l.logger.Errorf("%s: %s\n", l.errorMessagePrefix, update.Error)
+					continue
+				}
+
+				if update.Total == 0 {
+					return
+				}
+				if l.bar == nil {
+					l.bar = l.bars.add(l.blob, update.Total)
+				}
+				l.bar.SetCurrent(update.Complete)
+				l.bars.render()
+			}
+		}
+	}()
+}
+
+// End displays the final state of the bar, which stays displayed with the bars of the other blobs
+func (l *groupedProgressBar) End() {
+	if l.cancelFunc != nil {
+		l.cancelFunc()
+		<-l.done
+	}
+	if l.bar != nil {
+		l.bar.Finish()
+		l.bars.render()
+	}
+}
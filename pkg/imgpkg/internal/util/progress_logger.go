@@ -5,8 +5,11 @@ package util
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	pb "github.com/cheggaaa/pb/v3"
 	regv1 "github.com/google/go-containerregistry/pkg/v1"
@@ -23,12 +26,86 @@ type ProgressLogger interface {
 // writing to a registry via ggcr
 func NewProgressBar(logger LoggerWithLevels, finalMessage, errorMessagePrefix string) ProgressLogger {
 	if isatty.IsTerminal(os.Stdout.Fd()) {
-		return &ProgressBarLogger{logger: logger, finalMessage: finalMessage, errorMessagePrefix: errorMessagePrefix}
+		return NewTTYProgressBar(logger, finalMessage, errorMessagePrefix)
 	}
 
 	return &ProgressBarNoTTYLogger{logger: logger, finalMessage: finalMessage}
 }
 
+// NewTTYProgressBar constructor to build a ProgressLogger that always displays a progress bar,
+// even when stdout is not detected as a terminal (i.e. when --tty is provided)
+func NewTTYProgressBar(logger LoggerWithLevels, finalMessage, errorMessagePrefix string) ProgressLogger {
+	return &ProgressBarLogger{logger: logger, finalMessage: finalMessage, errorMessagePrefix: errorMessagePrefix}
+}
+
+// NewTTYProgressBarWithOutput constructs a ProgressLogger that displays the progress bar in out, used when stdout
+// is reserved for the content produced by the command
+func NewTTYProgressBarWithOutput(logger LoggerWithLevels, out io.Writer, finalMessage, errorMessagePrefix string) ProgressLogger {
+	return &ProgressBarLogger{logger: logger, out: out, finalMessage: finalMessage, errorMessagePrefix: errorMessagePrefix}
+}
+
+// NewProgressLines constructs a ProgressLogger that logs a line with the progress at most once per interval,
+// for outputs where a progress bar cannot be displayed
+func NewProgressLines(logger Logger, prefix string, interval time.Duration) ProgressLogger {
+	return &PeriodicProgressLogger{
+		interval: interval,
+		report: func(update regv1.Update) {
+			if update.Error != nil {
+				logger.Logf("%s: %s\n", prefix, update.Error)
+				return
+			}
+			logger.Logf("%s %d/%d bytes (%d%%)\n", prefix, update.Complete, update.Total, update.Complete*100/update.Total)
+		},
+	}
+}
+
+// ProgressEvent structured progress update written by the ProgressLogger built with NewProgressJSON
+type ProgressEvent struct {
+	Type string `json:"type"`
+	// Image reference of the image the progress is about, only set when several images report progress
+	Image string `json:"image,omitempty"`
+	// Blob digest of the blob the progress is about, only set when the progress of each blob is reported
+	Blob     string `json:"blob,omitempty"`
+	Complete int64  `json:"complete"`
+	Total    int64  `json:"total"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewProgressJSON constructs a ProgressLogger that logs one JSON encoded ProgressEvent per line
+// at most once per interval, so that the progress can be consumed by scripts
+func NewProgressJSON(logger Logger, interval time.Duration) ProgressLogger {
+	return NewImageProgressJSON(logger, "", interval)
+}
+
+// NewImageProgressJSON constructs a ProgressLogger like NewProgressJSON, with events attributed to the provided image
+func NewImageProgressJSON(logger Logger, image string, interval time.Duration) ProgressLogger {
+	return newProgressJSON(logger, ProgressEvent{Image: image}, interval)
+}
+
+// NewBlobProgressJSON constructs a ProgressLogger like NewProgressJSON, with events attributed to the provided blob
+func NewBlobProgressJSON(logger Logger, blob string, interval time.Duration) ProgressLogger {
+	return newProgressJSON(logger, ProgressEvent{Blob: blob}, interval)
+}
+
+func newProgressJSON(logger Logger, about ProgressEvent, interval time.Duration) ProgressLogger {
+	return &PeriodicProgressLogger{
+		interval: interval,
+		report: func(update regv1.Update) {
+			event := ProgressEvent{Type: "progress", Image: about.Image, Blob: about.Blob, Complete: update.Complete, Total: update.Total}
+			if update.Error != nil {
+				event.Type = "error"
+				event.Error = update.Error.Error()
+			}
+			eventBytes, err := json.Marshal(event)
+			if err != nil {
+				// Progress reporting is best effort, failing to encode it should not stop the operation
+				return
+			}
+			logger.Logf("%s\n", eventBytes)
+		},
+	}
+}
+
 // NewNoopProgressBar constructs a Noop Progress bar that will not display anything
 func NewNoopProgressBar() ProgressLogger {
 	return &ProgressBarNoTTYLogger{}
@@ -39,6 +116,7 @@ type ProgressBarLogger struct {
 	cancelFunc         context.CancelFunc
 	bar                *pb.ProgressBar
 	logger             LoggerWithLevels
+	out                io.Writer
 	finalMessage       string
 	errorMessagePrefix string
 }
@@ -47,10 +125,15 @@ type ProgressBarLogger struct {
 func (l *ProgressBarLogger) Start(ctx context.Context, progressChan <-chan regv1.Update) {
 	ctx, cancelFunc := context.WithCancel(ctx)
 	l.cancelFunc = cancelFunc
-	// Add a new empty line to separate the progress bar from prior output
-	fmt.Println()
 	l.bar = pb.New64(0)
 	l.bar.Set(pb.Bytes, true)
+	// Add a new empty line to separate the progress bar from prior output
+	if l.out != nil {
+		fmt.Fprintln(l.out)
+		l.bar.SetWriter(l.out)
+	} else {
+		fmt.Println()
+	}
 
 	go func() {
 		for {
@@ -117,3 +200,60 @@ func (l *ProgressBarNoTTYLogger) End() {
 		l.logger.Logf(l.finalMessage)
 	}
 }
+
+// PeriodicProgressLogger reports the progress at most once per interval and always reports the last update when it ends
+type PeriodicProgressLogger struct {
+	cancelFunc context.CancelFunc
+	done       chan struct{}
+	interval   time.Duration
+	report     func(update regv1.Update)
+}
+
+// Start consuming the progress channel and reporting the updates periodically
+func (l *PeriodicProgressLogger) Start(ctx context.Context, progressChan <-chan regv1.Update) {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	l.cancelFunc = cancelFunc
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		lastReported := time.Now()
+		var last regv1.Update
+		pending := false
+		for {
+			select {
+			case <-ctx.Done():
+				if pending {
+					l.report(last)
+				}
+				return
+			case update := <-progressChan:
+				if update.Error != nil {
+					l.report(update)
+					continue
+				}
+				if update.Total == 0 {
+					continue
+				}
+
+				last = update
+				pending = true
+				if time.Since(lastReported) >= l.interval || update.Complete >= update.Total {
+					l.report(update)
+					lastReported = time.Now()
+					pending = false
+				}
+			}
+		}
+	}()
+}
+
+// End stops consuming the progress channel and reports the last update that was not reported yet
+func (l *PeriodicProgressLogger) End() {
+	if l.cancelFunc == nil {
+		return
+	}
+	l.cancelFunc()
+	<-l.done
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressLines(t *testing.T) {
+	t.Run("it only logs the final progress when the interval did not elapse", func(t *testing.T) {
+		buf := bytes.NewBufferString("")
+		progress := util.NewProgressLines(util.NewBufferLogger(buf), "Extracted", time.Hour)
+
+		updates := make(chan regv1.Update)
+		progress.Start(context.Background(), updates)
+		updates <- regv1.Update{Total: 100, Complete: 10}
+		updates <- regv1.Update{Total: 100, Complete: 50}
+		progress.End()
+
+		require.Equal(t, "Extracted 50/100 bytes (50%)\n", buf.String())
+	})
+
+	t.Run("it logs when the progress completes", func(t *testing.T) {
+		buf := bytes.NewBufferString("")
+		progress := util.NewProgressLines(util.NewBufferLogger(buf), "Extracted", time.Hour)
+
+		updates := make(chan regv1.Update)
+		progress.Start(context.Background(), updates)
+		updates <- regv1.Update{Total: 100, Complete: 10}
+		updates <- regv1.Update{Total: 100, Complete: 100}
+		progress.End()
+
+		require.Equal(t, "Extracted 100/100 bytes (100%)\n", buf.String())
+	})
+
+	t.Run("it logs every update when the interval elapsed", func(t *testing.T) {
+		buf := bytes.NewBufferString("")
+		progress := util.NewProgressLines(util.NewBufferLogger(buf), "Extracted", 0)
+
+		updates := make(chan regv1.Update)
+		progress.Start(context.Background(), updates)
+		updates <- regv1.Update{Total: 100, Complete: 10}
+		updates <- regv1.Update{Total: 100, Complete: 100}
+		progress.End()
+
+		require.Equal(t, "Extracted 10/100 bytes (10%)\nExtracted 100/100 bytes (100%)\n", buf.String())
+	})
+}
+
+func TestProgressJSON(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	progress := util.NewProgressJSON(util.NewBufferLogger(buf), 0)
+
+	updates := make(chan regv1.Update)
+	progress.Start(context.Background(), updates)
+	updates <- regv1.Update{Total: 100, Complete: 10}
+	updates <- regv1.Update{Error: fmt.Errorf("some error")}
+	updates <- regv1.Update{Total: 100, Complete: 100}
+	progress.End()
+
+	var events []util.ProgressEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		event := util.ProgressEvent{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+
+	assert.Equal(t, []util.ProgressEvent{
+		{Type: "progress", Complete: 10, Total: 100},
+		{Type: "error", Error: "some error"},
+		{Type: "progress", Complete: 100, Total: 100},
+	}, events)
+}
+
+func TestBlobProgressJSON(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	progress := util.NewBlobProgressJSON(util.NewBufferLogger(buf), "sha256:abc", 0)
+
+	updates := make(chan regv1.Update)
+	progress.Start(context.Background(), updates)
+	updates <- regv1.Update{Total: 100, Complete: 100}
+	progress.End()
+
+	event := util.ProgressEvent{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &event))
+	assert.Equal(t, util.ProgressEvent{Type: "progress", Blob: "sha256:abc", Complete: 100, Total: 100}, event)
+}
@@ -92,6 +92,23 @@ func (tagGen RepoBasedTagGenerator) GenerateTag(item imagedigest.DigestWrap, imp
 	return uploadTagRef, nil
 }
 
+// maxPreservedTagLength length of the tags built by BuildPreservedTag, leaving room under the 128 characters of a tag
+// for the suffixes added when tags collide
+const maxPreservedTagLength = 120
+
+// BuildPreservedTag builds the tag given by copy --preserve-tags to an image from the tag reference it was resolved
+// from, the last part of its repository and its tag, postgres-14.9 for index.docker.io/library/postgres:14.9
+func BuildPreservedTag(origTag regname.Tag) string {
+	repoPath := strings.Split(origTag.RepositoryStr(), "/")
+
+	regex := regexp.MustCompile(`^[^a-zA-Z0-9_]+|[^a-zA-Z0-9\._-]+`)
+	tag := regex.ReplaceAllString(repoPath[len(repoPath)-1]+"-"+origTag.TagStr(), "")
+	if len(tag) > maxPreservedTagLength {
+		tag = tag[:maxPreservedTagLength]
+	}
+	return tag
+}
+
 // BuildDefaultUploadTagRef Builds a tag from the digest Algorithm and Digest
 func BuildDefaultUploadTagRef(item WithDigest, importRepo regname.Repository) (regname.Tag, error) {
 	digest, err := item.Digest()
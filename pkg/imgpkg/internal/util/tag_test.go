@@ -0,0 +1,32 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package util_test
+
+import (
+	"strings"
+	"testing"
+
+	util "carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPreservedTag(t *testing.T) {
+	for _, test := range []struct {
+		origTag     string
+		expectedTag string
+	}{
+		{origTag: "postgres:14.9", expectedTag: "postgres-14.9"},
+		{origTag: "index.docker.io/library/postgres:14.9", expectedTag: "postgres-14.9"},
+		{origTag: "registry.example.com:5000/team/app_server:v1.2.3-rc.1", expectedTag: "app_server-v1.2.3-rc.1"},
+		{origTag: "registry.example.com/app:" + strings.Repeat("a", 128), expectedTag: "app-" + strings.Repeat("a", 116)},
+	} {
+		t.Run(test.origTag, func(t *testing.T) {
+			origTag, err := regname.NewTag(test.origTag)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedTag, util.BuildPreservedTag(origTag))
+		})
+	}
+}
@@ -24,6 +24,43 @@ type BundleLock struct {
 type BundleRef struct {
 	Image string `json:"image,omitempty"` // This generated yaml, but due to lib we need to use `json`
 	Tag   string `json:"tag,omitempty"`   // This generated yaml, but due to lib we need to use `json`
+	// AdditionalTags tags the bundle was also tagged with when it was pushed
+	AdditionalTags []string `json:"additionalTags,omitempty"` // This generated yaml, but due to lib we need to use `json`
+	// Annotations of the manifest of the bundle when it was pushed
+	Annotations map[string]string `json:"annotations,omitempty"` // This generated yaml, but due to lib we need to use `json`
+	// AlsoPushedTo the other repositories the bundle was pushed to, with the same digest, by push --also-push-to
+	AlsoPushedTo []BundleDestinationRef `json:"alsoPushedTo,omitempty"` // This generated yaml, but due to lib we need to use `json`
+	// Attachments the artifacts referencing the bundle pushed by push --attach, with the same digests in the repositories
+	// of AlsoPushedTo
+	Attachments []BundleAttachmentRef `json:"attachments,omitempty"` // This generated yaml, but due to lib we need to use `json`
+	// PreservedTags tags given by copy --preserve-tags to the images of the bundle, derived from the tags they were
+	// resolved from
+	PreservedTags []PreservedTagRef `json:"preservedTags,omitempty"` // This generated yaml, but due to lib we need to use `json`
+	// OmittedImages images of the bundle, and of its nested bundles, filtered out of the copy by --include-image and
+	// --exclude-image, which are not in the repository of the bundle
+	OmittedImages []string `json:"omittedImages,omitempty"` // This generated yaml, but due to lib we need to use `json`
+}
+
+// BundleDestinationRef reference of the bundle in another repository it was pushed to
+type BundleDestinationRef struct {
+	Image string `json:"image"`         // This generated yaml, but due to lib we need to use `json`
+	Tag   string `json:"tag,omitempty"` // This generated yaml, but due to lib we need to use `json`
+	// AdditionalTags tags the bundle was also tagged with in this repository
+	AdditionalTags []string `json:"additionalTags,omitempty"` // This generated yaml, but due to lib we need to use `json`
+}
+
+// BundleAttachmentRef reference of an artifact, such as an SBOM, attached to the bundle
+type BundleAttachmentRef struct {
+	Image     string `json:"image"`     // This generated yaml, but due to lib we need to use `json`
+	MediaType string `json:"mediaType"` // This generated yaml, but due to lib we need to use `json`
+}
+
+// PreservedTagRef reference of an image of the bundle in the repository it was copied to, with the tag derived from the
+// tag reference it was resolved from
+type PreservedTagRef struct {
+	Image     string `json:"image"`     // This generated yaml, but due to lib we need to use `json`
+	Tag       string `json:"tag"`       // This generated yaml, but due to lib we need to use `json`
+	OrigImage string `json:"origImage"` // This generated yaml, but due to lib we need to use `json`
 }
 
 func NewBundleLockFromPath(path string) (BundleLock, error) {
@@ -65,21 +102,26 @@ func (b BundleLock) Validate() error {
 }
 
 func (b BundleLock) AsBytes() ([]byte, error) {
+	return b.AsBytesInFormat(YAMLLockFormat)
+}
+
+// AsBytesInFormat returns the lock in format
+func (b BundleLock) AsBytesInFormat(format LockFormat) ([]byte, error) {
 	err := b.Validate()
 	if err != nil {
 		return nil, fmt.Errorf("Validating bundle lock: %s", err)
 	}
 
-	bs, err := yaml.Marshal(b)
-	if err != nil {
-		return nil, fmt.Errorf("Marshaling config: %s", err)
-	}
-
-	return []byte(fmt.Sprintf("---\n%s", bs)), nil
+	return marshalLock(b, format)
 }
 
 func (b BundleLock) WriteToPath(path string) error {
-	bs, err := b.AsBytes()
+	return b.WriteToPathInFormat(path, YAMLLockFormat)
+}
+
+// WriteToPathInFormat writes the lock in format to path
+func (b BundleLock) WriteToPathInFormat(path string, format LockFormat) error {
+	bs, err := b.AsBytesInFormat(format)
 	if err != nil {
 		return err
 	}
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBundleLockNonDigestUnmarshalError(t *testing.T) {
@@ -42,3 +44,52 @@ another-key: invalid
 		t.Fatalf("Expected error for unknown key, got: %s", err)
 	}
 }
+
+func TestBundleLockAsBytesInFormat(t *testing.T) {
+	lock := lockconfig.BundleLock{
+		LockVersion: lockconfig.LockVersion{APIVersion: lockconfig.BundleLockAPIVersion, Kind: lockconfig.BundleLockKind},
+		Bundle: lockconfig.BundleRef{
+			Image:       "repo/bundle@sha256:477c34d98f9e090a4441cf82d2f1f03e64c8eb730e8c1ef39a8595e685d4df65",
+			Tag:         "v1",
+			Annotations: map[string]string{"url": "https://example.com/?a=1&b=2"},
+		},
+	}
+
+	jsonLock, err := lock.AsBytesInFormat(lockconfig.JSONLockFormat)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "apiVersion": "imgpkg.carvel.dev/v1alpha1",
+  "kind": "BundleLock",
+  "bundle": {
+    "image": "repo/bundle@sha256:477c34d98f9e090a4441cf82d2f1f03e64c8eb730e8c1ef39a8595e685d4df65",
+    "tag": "v1",
+    "annotations": {
+      "url": "https://example.com/?a=1&b=2"
+    }
+  }
+}
+`, string(jsonLock))
+
+	readLock, err := lockconfig.NewBundleLockFromBytes(jsonLock)
+	require.NoError(t, err)
+	assert.Equal(t, lock, readLock)
+
+	yamlLock, err := lock.AsBytesInFormat(lockconfig.YAMLLockFormat)
+	require.NoError(t, err)
+	defaultLock, err := lock.AsBytes()
+	require.NoError(t, err)
+	assert.Equal(t, string(defaultLock), string(yamlLock))
+}
+
+func TestNewLockFormat(t *testing.T) {
+	format, err := lockconfig.NewLockFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, lockconfig.YAMLLockFormat, format)
+
+	format, err = lockconfig.NewLockFormat("json")
+	require.NoError(t, err)
+	assert.Equal(t, lockconfig.JSONLockFormat, format)
+
+	_, err = lockconfig.NewLockFormat("toml")
+	require.EqualError(t, err, "Expected lock format 'toml' to be yaml or json")
+}
@@ -4,7 +4,21 @@
 package lockconfig
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// LockFormat format the locks are written in
+type LockFormat string
+
+const (
+	// YAMLLockFormat writes the locks as YAML documents, used when no format is selected
+	YAMLLockFormat LockFormat = "yaml"
+	// JSONLockFormat writes the locks as JSON with the same fields as the YAML, so they can be read back as locks
+	JSONLockFormat LockFormat = "json"
 )
 
 type LockVersion struct {
@@ -23,3 +37,36 @@ func NewLockFromPath(path string) (*BundleLock, *ImagesLock, error) {
 	}
 	return nil, nil, fmt.Errorf("Trying to read bundle or images lock file: %s", err)
 }
+
+// NewLockFormat returns the format named format, YAMLLockFormat when format is empty
+func NewLockFormat(format string) (LockFormat, error) {
+	switch LockFormat(format) {
+	case "", YAMLLockFormat:
+		return YAMLLockFormat, nil
+	case JSONLockFormat:
+		return JSONLockFormat, nil
+	default:
+		return "", fmt.Errorf("Expected lock format '%s' to be %s or %s", format, YAMLLockFormat, JSONLockFormat)
+	}
+}
+
+// marshalLock returns lock in format. Both formats have the fields of the json tags, in the same order
+func marshalLock(lock interface{}, format LockFormat) ([]byte, error) {
+	if format == JSONLockFormat {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(lock)
+		if err != nil {
+			return nil, fmt.Errorf("Marshaling config: %s", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	bs, err := yaml.Marshal(lock)
+	if err != nil {
+		return nil, fmt.Errorf("Marshaling config: %s", err)
+	}
+	return []byte(fmt.Sprintf("---\n%s", bs)), nil
+}
@@ -97,6 +97,11 @@ func (i ImagesLock) Validate() error {
 }
 
 func (i ImagesLock) AsBytes() ([]byte, error) {
+	return i.AsBytesInFormat(YAMLLockFormat)
+}
+
+// AsBytesInFormat returns the lock in format
+func (i ImagesLock) AsBytesInFormat(format LockFormat) ([]byte, error) {
 	err := i.Validate()
 	if err != nil {
 		return nil, fmt.Errorf("Validating images lock: %s", err)
@@ -111,16 +116,16 @@ func (i ImagesLock) AsBytes() ([]byte, error) {
 	updatedImagesLock := i
 	updatedImagesLock.Images = imgRefs
 
-	bs, err := yaml.Marshal(updatedImagesLock)
-	if err != nil {
-		return nil, fmt.Errorf("Marshaling config: %s", err)
-	}
-
-	return []byte(fmt.Sprintf("---\n%s", bs)), nil
+	return marshalLock(updatedImagesLock, format)
 }
 
 func (i ImagesLock) WriteToPath(path string) error {
-	bs, err := i.AsBytes()
+	return i.WriteToPathInFormat(path, YAMLLockFormat)
+}
+
+// WriteToPathInFormat writes the lock in format to path
+func (i ImagesLock) WriteToPathInFormat(path string, format LockFormat) error {
+	bs, err := i.AsBytesInFormat(format)
 	if err != nil {
 		return err
 	}
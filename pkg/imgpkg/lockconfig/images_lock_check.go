@@ -0,0 +1,158 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lockconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+)
+
+// ImagesLockCheckOpts what CheckImagesLock accepts besides a valid images lock
+type ImagesLockCheckOpts struct {
+	// AllowTags accepts images referenced by tag, which are resolved to digests before the lock is used
+	AllowTags bool
+}
+
+// CheckImagesLock checks that data, read from path, is an images lock before it is pushed, so that the problems are
+// not found when the bundle is copied or pulled. Every problem found is reported with its line: YAML that cannot be
+// parsed, an unknown apiVersion, kind or field, images that are not referenced by digest and images listed more than once
+func CheckImagesLock(path string, data []byte, opts ImagesLockCheckOpts) error {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("Parsing images lock '%s': %s", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("Expected images lock '%s' to have apiVersion '%s' and kind '%s', but it is empty", path, ImagesLockAPIVersion, ImagesLockKind)
+	}
+
+	check := imagesLockCheck{opts: opts, imageLines: map[string]int{}}
+	check.checkLock(doc.Content[0])
+	if len(check.problems) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(check.problems, func(i, j int) bool { return check.problems[i].line < check.problems[j].line })
+	var problems []string
+	for _, problem := range check.problems {
+		problems = append(problems, fmt.Sprintf("line %d: %s", problem.line, problem.msg))
+	}
+	return fmt.Errorf("Validating images lock '%s':\n- %s", path, strings.Join(problems, "\n- "))
+}
+
+// imagesLockProblem problem found at line of an images lock
+type imagesLockProblem struct {
+	line int
+	msg  string
+}
+
+// imagesLockCheck problems found in the nodes of an images lock
+type imagesLockCheck struct {
+	opts     ImagesLockCheckOpts
+	problems []imagesLockProblem
+	// imageLines line of each image, by its fully qualified reference
+	imageLines map[string]int
+}
+
+func (c *imagesLockCheck) addProblem(node *yaml.Node, msg string, args ...interface{}) {
+	c.problems = append(c.problems, imagesLockProblem{line: node.Line, msg: fmt.Sprintf(msg, args...)})
+}
+
+func (c *imagesLockCheck) checkLock(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		c.addProblem(node, "Expected images lock to be a map")
+		return
+	}
+
+	found := map[string]bool{}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key, value := node.Content[idx], node.Content[idx+1]
+		found[key.Value] = true
+		switch key.Value {
+		case "apiVersion":
+			if value.Value != ImagesLockAPIVersion {
+				c.addProblem(value, "Expected apiVersion to be '%s', but it is '%s'", ImagesLockAPIVersion, value.Value)
+			}
+		case "kind":
+			if value.Value != ImagesLockKind {
+				c.addProblem(value, "Expected kind to be '%s', but it is '%s'", ImagesLockKind, value.Value)
+			}
+		case "images":
+			c.checkImages(value)
+		default:
+			c.addProblem(key, "Unknown field '%s'", key.Value)
+		}
+	}
+
+	for _, field := range []string{"apiVersion", "kind"} {
+		if !found[field] {
+			c.addProblem(node, "Expected images lock to have the field '%s'", field)
+		}
+	}
+}
+
+func (c *imagesLockCheck) checkImages(node *yaml.Node) {
+	if node.Tag == "!!null" {
+		return
+	}
+	if node.Kind != yaml.SequenceNode {
+		c.addProblem(node, "Expected images to be a list")
+		return
+	}
+
+	for _, imageNode := range node.Content {
+		if imageNode.Kind != yaml.MappingNode {
+			c.addProblem(imageNode, "Expected image to be a map with the field 'image'")
+			continue
+		}
+
+		var image *yaml.Node
+		for idx := 0; idx+1 < len(imageNode.Content); idx += 2 {
+			key, value := imageNode.Content[idx], imageNode.Content[idx+1]
+			switch key.Value {
+			case "image":
+				image = value
+			case "annotations":
+				if value.Kind != yaml.MappingNode && value.Tag != "!!null" {
+					c.addProblem(value, "Expected annotations to be a map")
+				}
+			default:
+				c.addProblem(key, "Unknown field '%s'", key.Value)
+			}
+		}
+		if image == nil {
+			c.addProblem(imageNode, "Expected image to have the field 'image'")
+			continue
+		}
+		c.checkImage(image)
+	}
+}
+
+func (c *imagesLockCheck) checkImage(node *yaml.Node) {
+	var ref regname.Reference
+	var err error
+	if c.opts.AllowTags {
+		ref, err = regname.ParseReference(node.Value)
+	} else {
+		ref, err = regname.NewDigest(node.Value)
+	}
+	if err != nil {
+		hint := ""
+		if _, tagErr := regname.NewTag(node.Value); tagErr == nil && !c.opts.AllowTags {
+			hint = " (hint: Use --resolve-tags to resolve the tag to a digest)"
+		}
+		c.addProblem(node, "Expected image '%s' to be referenced by digest: %s%s", node.Value, err, hint)
+		return
+	}
+
+	if line, found := c.imageLines[ref.Name()]; found {
+		c.addProblem(node, "Expected image '%s' to be listed once, but it is also at line %d", node.Value, line)
+		return
+	}
+	c.imageLines[ref.Name()] = node.Line
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lockconfig_test
+
+import (
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckImagesLock(t *testing.T) {
+	t.Run("accepts images referenced by digest", func(t *testing.T) {
+		data := `
+apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: index.docker.io/library/nginx@sha256:4c8b96d4fffdfae29258d94a22ae4ad1fe36139d47288b8960d9958d1e63a9d0
+  annotations:
+    kbld.carvel.dev/id: nginx
+`
+		err := lockconfig.CheckImagesLock(".imgpkg/images.yml", []byte(data), lockconfig.ImagesLockCheckOpts{})
+		require.NoError(t, err)
+	})
+
+	t.Run("reports every problem with its line", func(t *testing.T) {
+		data := `apiVersion: imgpkg.carvel.dev/v1alpha2
+kind: ImagesLock
+images:
+- image: nginx:v1
+- image: index.docker.io/library/nginx@sha256:4c8b96d4fffdfae29258d94a22ae4ad1fe36139d47288b8960d9958d1e63a9d0
+- image: nginx@sha256:4c8b96d4fffdfae29258d94a22ae4ad1fe36139d47288b8960d9958d1e63a9d0
+  annotation: {}
+`
+		err := lockconfig.CheckImagesLock(".imgpkg/images.yml", []byte(data), lockconfig.ImagesLockCheckOpts{})
+		require.EqualError(t, err, `Validating images lock '.imgpkg/images.yml':
+- line 1: Expected apiVersion to be 'imgpkg.carvel.dev/v1alpha1', but it is 'imgpkg.carvel.dev/v1alpha2'
+- line 4: Expected image 'nginx:v1' to be referenced by digest: a digest must contain exactly one '@' separator (e.g. registry/repository@digest) saw: nginx:v1 (hint: Use --resolve-tags to resolve the tag to a digest)
+- line 6: Expected image 'nginx@sha256:4c8b96d4fffdfae29258d94a22ae4ad1fe36139d47288b8960d9958d1e63a9d0' to be listed once, but it is also at line 5
+- line 7: Unknown field 'annotation'`)
+	})
+
+	t.Run("accepts images referenced by tag when allowed", func(t *testing.T) {
+		data := `
+apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: nginx:v1
+- image: index.docker.io/library/nginx:v1
+`
+		err := lockconfig.CheckImagesLock(".imgpkg/images.yml", []byte(data), lockconfig.ImagesLockCheckOpts{AllowTags: true})
+		require.EqualError(t, err, `Validating images lock '.imgpkg/images.yml':
+- line 6: Expected image 'index.docker.io/library/nginx:v1' to be listed once, but it is also at line 5`)
+	})
+
+	t.Run("fails when the kind is missing", func(t *testing.T) {
+		data := `
+apiVersion: imgpkg.carvel.dev/v1alpha1
+`
+		err := lockconfig.CheckImagesLock(".imgpkg/images.yml", []byte(data), lockconfig.ImagesLockCheckOpts{})
+		require.EqualError(t, err, `Validating images lock '.imgpkg/images.yml':
+- line 2: Expected images lock to have the field 'kind'`)
+	})
+
+	t.Run("fails when the images lock is empty", func(t *testing.T) {
+		err := lockconfig.CheckImagesLock(".imgpkg/images.yml", nil, lockconfig.ImagesLockCheckOpts{})
+		require.EqualError(t, err, "Expected images lock '.imgpkg/images.yml' to have apiVersion 'imgpkg.carvel.dev/v1alpha1' and kind 'ImagesLock', but it is empty")
+	})
+
+	t.Run("fails when the images lock is not YAML", func(t *testing.T) {
+		err := lockconfig.CheckImagesLock(".imgpkg/images.yml", []byte("images: [\n"), lockconfig.ImagesLockCheckOpts{})
+		require.ErrorContains(t, err, "Parsing images lock '.imgpkg/images.yml': yaml: line 1:")
+	})
+}
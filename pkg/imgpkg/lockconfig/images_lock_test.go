@@ -74,3 +74,36 @@ kind: ImagesLock
 		assert.Contains(t, subject.Images[0].Locations(), "some.image.io/test@sha256:4c8b96d4fffdfae29258d94a22ae4ad1fe36139d47288b8960d9958d1e63a9d0")
 	})
 }
+
+func TestImagesLockAsBytesInFormat(t *testing.T) {
+	data := `
+apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: index.docker.io/repo/app@sha256:477c34d98f9e090a4441cf82d2f1f03e64c8eb730e8c1ef39a8595e685d4df65
+  annotations:
+    kbld.carvel.dev/id: app
+`
+	lock, err := lockconfig.NewImagesLockFromBytes([]byte(data))
+	require.NoError(t, err)
+
+	jsonLock, err := lock.AsBytesInFormat(lockconfig.JSONLockFormat)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "apiVersion": "imgpkg.carvel.dev/v1alpha1",
+  "kind": "ImagesLock",
+  "images": [
+    {
+      "image": "index.docker.io/repo/app@sha256:477c34d98f9e090a4441cf82d2f1f03e64c8eb730e8c1ef39a8595e685d4df65",
+      "annotations": {
+        "kbld.carvel.dev/id": "app"
+      }
+    }
+  ]
+}
+`, string(jsonLock))
+
+	readLock, err := lockconfig.NewImagesLockFromBytes(jsonLock)
+	require.NoError(t, err)
+	assert.Equal(t, lock, readLock)
+}
@@ -5,9 +5,6 @@ package plainimage
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 
 	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
 	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
@@ -18,9 +15,13 @@ import (
 
 // Contents of the OCI Image
 type Contents struct {
-	paths               []string
-	excludedPaths       []string
-	preservePermissions bool
+	paths         []string
+	excludedPaths []string
+	tarImageOpts  ctlimg.TarImageOpts
+	// tar content of the image instead of paths, nil when the paths are pushed
+	tar *ctlimg.TarStream
+	// replacedFiles content of files, by their path in the image, pushed instead of their content
+	replacedFiles map[string][]byte
 }
 
 // ImagesWriter defines the needed functions to write to the registry
@@ -30,20 +31,35 @@ type ImagesWriter interface {
 }
 
 // NewContents creates the struct that represent an OCI Image based on the provided paths
-func NewContents(paths []string, excludedPaths []string, preservePermissions bool) Contents {
-	return Contents{paths: paths, excludedPaths: excludedPaths, preservePermissions: preservePermissions}
+func NewContents(paths []string, excludedPaths []string, tarImageOpts ctlimg.TarImageOpts) Contents {
+	return Contents{paths: paths, excludedPaths: excludedPaths, tarImageOpts: tarImageOpts}
 }
 
-// Push the OCI Image to the registry
-func (i Contents) Push(uploadRef regname.Tag, labels map[string]string, writer ImagesWriter, logger Logger) (string, error) {
-	err := i.validate()
-	if err != nil {
-		return "", err
-	}
+// NewContentsFromTar creates the struct that represent an OCI Image based on the entries of the provided tar
+func NewContentsFromTar(tar *ctlimg.TarStream, excludedPaths []string, tarImageOpts ctlimg.TarImageOpts) Contents {
+	return Contents{tar: tar, excludedPaths: excludedPaths, tarImageOpts: tarImageOpts}
+}
 
-	tarImg := ctlimg.NewTarImage(i.paths, i.excludedPaths, logger, i.preservePermissions)
+// WithReplacedFiles returns the contents with the content of files, by their path in the image, pushed instead of the
+// content of those files
+func (i Contents) WithReplacedFiles(files map[string][]byte) Contents {
+	i.replacedFiles = files
+	return i
+}
 
-	img, err := tarImg.AsFileImage(labels)
+// Push the OCI Image, with the labels and annotations of imageOpts, to the registry
+func (i Contents) Push(uploadRef regname.Tag, imageOpts ctlimg.FileImageOpts, writer ImagesWriter, logger Logger) (string, error) {
+	var img *ctlimg.FileImage
+	var err error
+	if i.tar != nil {
+		tarImg := ctlimg.NewTarStreamImage(i.tar, i.excludedPaths, logger, i.tarImageOpts)
+		tarImg.ReplaceFiles(i.replacedFiles)
+		img, err = tarImg.AsFileImageWithOpts(imageOpts)
+	} else {
+		tarImg := ctlimg.NewTarImage(i.paths, i.excludedPaths, logger, i.tarImageOpts)
+		tarImg.ReplaceFiles(i.replacedFiles)
+		img, err = tarImg.AsFileImageWithOpts(imageOpts)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -73,47 +89,3 @@ func (i Contents) Push(uploadRef regname.Tag, labels map[string]string, writer I
 
 	return fmt.Sprintf("%s@%s", uploadRef.Context(), digest), nil
 }
-
-func (i Contents) validate() error {
-	return i.checkRepeatedPaths()
-}
-
-func (i Contents) checkRepeatedPaths() error {
-	imageRootPaths := make(map[string][]string)
-	for _, flagPath := range i.paths {
-		err := filepath.Walk(flagPath, func(currPath string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			imageRootPath, err := filepath.Rel(flagPath, currPath)
-			if err != nil {
-				return err
-			}
-
-			if imageRootPath == "." {
-				if info.IsDir() {
-					return nil
-				}
-				imageRootPath = filepath.Base(flagPath)
-			}
-			imageRootPaths[imageRootPath] = append(imageRootPaths[imageRootPath], currPath)
-			return nil
-		})
-
-		if err != nil {
-			return err
-		}
-	}
-
-	var repeatedPaths []string
-	for _, v := range imageRootPaths {
-		if len(v) > 1 {
-			repeatedPaths = append(repeatedPaths, v...)
-		}
-	}
-	if len(repeatedPaths) > 0 {
-		return fmt.Errorf("Found duplicate paths: %s", strings.Join(repeatedPaths, ", "))
-	}
-	return nil
-}
@@ -145,6 +145,19 @@ func (i *PlainImage) Fetch() (regv1.Image, error) {
 	return i.fetchedImage, nil
 }
 
+// MediaType media type of the manifest of the image
+func (i *PlainImage) MediaType() (string, error) {
+	img, err := i.Fetch()
+	if err != nil {
+		return "", err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return "", fmt.Errorf("Getting image media type: %s", err)
+	}
+	return string(mediaType), nil
+}
+
 // IsImage checks if the provided reference is an OCI Image
 func (i *PlainImage) IsImage() (bool, error) {
 	img, err := i.Fetch()
@@ -164,6 +177,11 @@ func (i *PlainImage) IsImage() (bool, error) {
 
 // Pull the OCI Image to disk
 func (i *PlainImage) Pull(outputPath string, logger Logger) error {
+	return i.PullWithOpts(outputPath, ctlimg.DirImageOpts{}, logger)
+}
+
+// PullWithOpts the OCI Image to disk using the provided extraction options
+func (i *PlainImage) PullWithOpts(outputPath string, opts ctlimg.DirImageOpts, logger Logger) error {
 	img, err := i.Fetch()
 	if err != nil {
 		return err
@@ -175,7 +193,7 @@ func (i *PlainImage) Pull(outputPath string, logger Logger) error {
 
 	logger.Logf("Pulling image '%s'\n", i.DigestRef())
 
-	err = ctlimg.NewDirImage(outputPath, img, logger).AsDirectory()
+	err = ctlimg.NewDirImageWithOpts(outputPath, img, opts, logger).AsDirectory()
 	if err != nil {
 		return fmt.Errorf("Extracting image into directory: %s", err)
 	}
@@ -183,6 +201,28 @@ func (i *PlainImage) Pull(outputPath string, logger Logger) error {
 	return nil
 }
 
+// PullLayersWithOpts extracts each layer of the OCI Image into its own directory of layersPath, see
+// DirImage.AsLayerDirectories
+func (i *PlainImage) PullLayersWithOpts(layersPath string, opts ctlimg.DirImageOpts, logger Logger) error {
+	img, err := i.Fetch()
+	if err != nil {
+		return err
+	}
+
+	if img == nil {
+		panic("Not supported Pull on pre fetched PlainImage")
+	}
+
+	logger.Logf("Pulling the layers of image '%s'\n", i.DigestRef())
+
+	err = ctlimg.NewDirImageWithOpts(layersPath, img, opts, logger).AsLayerDirectories()
+	if err != nil {
+		return fmt.Errorf("Extracting image layers into directory: %s", err)
+	}
+
+	return nil
+}
+
 func IsNotAnImageError(err error) bool {
 	if err == nil {
 		return false
@@ -0,0 +1,170 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxBandwidthChunk most bytes read from a request or a response before waiting for the limit, so that the
+// concurrent transfers take turns
+const maxBandwidthChunk = 32 * 1024
+
+// NewBandwidthLimiter creates a BandwidthLimiter allowing bytesPerSecond bytes to be transferred each second
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	// a tenth of a second of transfer can happen at once, so that the limit is kept over a few seconds
+	burst := float64(bytesPerSecond) / 10
+	if burst < 1 {
+		burst = 1
+	}
+	// the bucket starts empty, so that the average rate of the transfers never exceeds the limit
+	return &BandwidthLimiter{bytesPerSecond: bytesPerSecond, burst: burst, now: time.Now, sleep: sleepContext}
+}
+
+// BandwidthLimiter token bucket limiting the bytes uploaded to, and downloaded from, the registries sharing it, the
+// concurrent transfers sharing the limit
+type BandwidthLimiter struct {
+	bytesPerSecond int64
+	burst          float64
+	now            func() time.Time
+	sleep          func(ctx context.Context, duration time.Duration) error
+
+	lock        sync.Mutex
+	tokens      float64
+	refilled    time.Time
+	transferred int64
+	first, last time.Time
+}
+
+// BandwidthStats bytes transferred under the limit of a BandwidthLimiter
+type BandwidthStats struct {
+	BytesPerSecondLimit int64
+	Bytes               int64
+	// Duration from the start of the first transfer to the end of the last one
+	Duration time.Duration
+}
+
+// BytesPerSecond average rate of the transfers
+func (s BandwidthStats) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// String summary of the transfers
+func (s BandwidthStats) String() string {
+	return fmt.Sprintf("Transferred %d bytes at %.0f bytes/s on average, under the bandwidth limit of %d bytes/s",
+		s.Bytes, s.BytesPerSecond(), s.BytesPerSecondLimit)
+}
+
+// BytesPerSecond the limit
+func (l *BandwidthLimiter) BytesPerSecond() int64 {
+	return l.bytesPerSecond
+}
+
+// Stats of the bytes transferred so far
+func (l *BandwidthLimiter) Stats() BandwidthStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return BandwidthStats{BytesPerSecondLimit: l.bytesPerSecond, Bytes: l.transferred, Duration: l.last.Sub(l.first)}
+}
+
+// wait takes n bytes from the bucket, waiting until they would have been available when the bucket does not have
+// them. The bytes taken before being available are owed, making the following transfers wait for them
+func (l *BandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.lock.Lock()
+	now := l.now()
+	if l.first.IsZero() {
+		l.first = now
+		l.refilled = now
+	}
+	l.tokens += now.Sub(l.refilled).Seconds() * float64(l.bytesPerSecond)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.refilled = now
+	l.tokens -= float64(n)
+	l.transferred += int64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.bytesPerSecond) * float64(time.Second))
+	}
+	if end := now.Add(wait); end.After(l.last) {
+		l.last = end
+	}
+	l.lock.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	return l.sleep(ctx, wait)
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewBandwidthLimitRoundTripper creates a RoundTripper that reads the bodies of the requests, and of the responses,
+// under the limit of limiter
+func NewBandwidthLimitRoundTripper(parent http.RoundTripper, limiter *BandwidthLimiter) *BandwidthLimitRoundTripper {
+	return &BandwidthLimitRoundTripper{parent: parent, limiter: limiter}
+}
+
+// BandwidthLimitRoundTripper RoundTripper limiting the bytes uploaded and downloaded per second
+type BandwidthLimitRoundTripper struct {
+	parent  http.RoundTripper
+	limiter *BandwidthLimiter
+}
+
+// RoundTrip sends the request with its body read under the limit, and returns the response with its body read under
+// the limit
+func (b *BandwidthLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		body := req.Body
+		req = req.Clone(req.Context())
+		req.Body = &bandwidthLimitedBody{ReadCloser: body, limiter: b.limiter, ctx: req.Context()}
+	}
+
+	resp, err := b.parent.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &bandwidthLimitedBody{ReadCloser: resp.Body, limiter: b.limiter, ctx: req.Context()}
+	}
+	return resp, nil
+}
+
+type bandwidthLimitedBody struct {
+	io.ReadCloser
+	limiter *BandwidthLimiter
+	ctx     context.Context
+}
+
+func (b *bandwidthLimitedBody) Read(p []byte) (int, error) {
+	if len(p) > maxBandwidthChunk {
+		p = p[:maxBandwidthChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
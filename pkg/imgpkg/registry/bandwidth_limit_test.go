@@ -0,0 +1,75 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestBandwidthLimitRoundTripper(t *testing.T) {
+	const bytesPerSecond = 512 * 1024
+
+	t.Run("the concurrent uploads share the limit", func(t *testing.T) {
+		server := createRetryServer(t, func(_ http.ResponseWriter, _ *http.Request) bool { return false })
+		defer server.Close()
+
+		limiter := registry.NewBandwidthLimiter(bytesPerSecond)
+		reg, err := registry.NewSimpleRegistry(registry.Opts{BandwidthLimiter: limiter})
+		require.NoError(t, err)
+
+		start := time.Now()
+		var group errgroup.Group
+		for _, repo := range []string{"repo1", "repo2"} {
+			ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/" + repo + ":tag")
+			require.NoError(t, err)
+			img := imageWithBlob(t, randomBytes(t, bytesPerSecond/2))
+			group.Go(func() error { return reg.WriteImage(ref, img, nil) })
+		}
+		require.NoError(t, group.Wait())
+
+		stats := limiter.Stats()
+		assert.Greater(t, stats.Bytes, int64(bytesPerSecond))
+		assert.GreaterOrEqual(t, time.Since(start), time.Duration(float64(stats.Bytes)/bytesPerSecond*0.95*float64(time.Second)))
+		assert.InEpsilon(t, bytesPerSecond, stats.BytesPerSecond(), 0.05)
+	})
+
+	t.Run("the downloads are limited", func(t *testing.T) {
+		server := createRetryServer(t, func(_ http.ResponseWriter, _ *http.Request) bool { return false })
+		defer server.Close()
+		blob := randomBytes(t, bytesPerSecond)
+		ref := writeImage(t, server, imageWithBlob(t, blob))
+
+		limiter := registry.NewBandwidthLimiter(bytesPerSecond)
+		reg, err := registry.NewSimpleRegistry(registry.Opts{BandwidthLimiter: limiter})
+		require.NoError(t, err)
+
+		start := time.Now()
+		img, err := reg.Image(ref)
+		require.NoError(t, err)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		content, err := layers[0].Compressed()
+		require.NoError(t, err)
+		downloaded, err := io.ReadAll(content)
+		require.NoError(t, err)
+		require.NoError(t, content.Close())
+		assert.Equal(t, blob, downloaded)
+
+		stats := limiter.Stats()
+		assert.GreaterOrEqual(t, stats.Bytes, int64(len(blob)))
+		assert.GreaterOrEqual(t, time.Since(start), 950*time.Millisecond)
+		assert.InEpsilon(t, bytesPerSecond, stats.BytesPerSecond(), 0.05)
+		assert.Equal(t, bytesPerSecond, int(stats.BytesPerSecondLimit))
+	})
+}
@@ -0,0 +1,327 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// staleCacheTempFileAge age after which a partial download left in the cache by an interrupted process is removed
+const staleCacheTempFileAge = 24 * time.Hour
+
+// blobPathMatcher matches the path of the registry API used to download a blob
+var blobPathMatcher = regexp.MustCompile(`\A/v2/.+/blobs/(sha256:[a-f0-9]{64})\z`)
+
+// BlobCache on-disk cache of the blobs downloaded from registries, shared by the imgpkg processes using the same
+// directory. Blobs are stored by digest, so a layer is only downloaded once no matter how many images or
+// repositories contain it. The layers built by push from files are added to it as well, see AddBuiltLayer
+//
+// The entries are written to a temporary file and renamed into place once their digest is verified, and they are
+// verified again before being read, so a corrupted entry is discarded instead of being used. The lock file of the
+// directory keeps Prune from removing entries while other processes add or read them
+type BlobCache struct {
+	dir string
+}
+
+// CachePruneResult entries removed by BlobCache.Prune
+type CachePruneResult struct {
+	RemovedEntries int
+	RemovedSize    int64
+	Size           int64
+}
+
+// NewBlobCache creates a cache stored in dir, which is created when the first blob is added
+func NewBlobCache(dir string) *BlobCache {
+	return &BlobCache{dir: dir}
+}
+
+// Prune removes the least recently used entries until the size of the cache is at most maxSize
+func (c *BlobCache) Prune(maxSize int64) (CachePruneResult, error) {
+	if _, err := os.Stat(c.dir); os.IsNotExist(err) {
+		return CachePruneResult{}, nil
+	}
+
+	unlock, err := c.lock(true)
+	if err != nil {
+		return CachePruneResult{}, err
+	}
+	defer unlock()
+
+	c.removeStaleTempFiles()
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var result CachePruneResult
+
+	blobsDir := c.blobsDir()
+	files, err := os.ReadDir(blobsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return CachePruneResult{}, fmt.Errorf("Reading cache directory: %s", err)
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		entries = append(entries, entry{filepath.Join(blobsDir, file.Name()), info.Size(), info.ModTime()})
+		result.Size += info.Size()
+	}
+
+	// entries are touched when they are read, the oldest ones were used the least recently
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	for _, entry := range entries {
+		if result.Size <= maxSize {
+			break
+		}
+		err := os.Remove(entry.path)
+		if err != nil {
+			// on windows an entry that is being read by another process cannot be removed
+			if os.IsNotExist(err) {
+				result.Size -= entry.size
+			}
+			continue
+		}
+		result.RemovedEntries++
+		result.RemovedSize += entry.size
+		result.Size -= entry.size
+	}
+	c.removeOrphanBuiltLayers()
+	return result, nil
+}
+
+// Open returns the cached content of the blob, after verifying it matches the digest. A corrupted entry is removed
+// and reported as missing
+func (c *BlobCache) Open(digest regv1.Hash) (*os.File, int64, bool) {
+	unlock, err := c.lock(false)
+	if err != nil {
+		return nil, 0, false
+	}
+	file, err := os.Open(c.blobPath(digest))
+	unlock()
+	if err != nil {
+		return nil, 0, false
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err == nil && hex.EncodeToString(hasher.Sum(nil)) == digest.Hex {
+		_, err = file.Seek(0, io.SeekStart)
+		if err == nil {
+			now := time.Now()
+			_ = os.Chtimes(file.Name(), now, now)
+			return file, size, true
+		}
+	}
+
+	_ = file.Close()
+	if err == nil {
+		_ = os.Remove(c.blobPath(digest))
+	}
+	return nil, 0, false
+}
+
+// newCachingReader copies the blob read from reader to the cache, the entry is added once the whole blob is read and
+// its digest verified. Failing to write the cache does not fail the download, the blob is just not cached
+func (c *BlobCache) newCachingReader(digest regv1.Hash, reader io.ReadCloser) io.ReadCloser {
+	err := os.MkdirAll(c.tempDir(), 0777)
+	if err != nil {
+		return reader
+	}
+	file, err := os.CreateTemp(c.tempDir(), digest.Hex+"-*")
+	if err != nil {
+		return reader
+	}
+	return &cachingReader{reader: reader, file: file, hasher: sha256.New(), digest: digest, cache: c}
+}
+
+func (c *BlobCache) add(digest regv1.Hash, tempPath string) error {
+	err := os.MkdirAll(c.blobsDir(), 0777)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.Rename(tempPath, c.blobPath(digest))
+}
+
+func (c *BlobCache) lock(exclusive bool) (func(), error) {
+	err := os.MkdirAll(c.dir, 0777)
+	if err != nil {
+		return nil, fmt.Errorf("Creating cache directory: %s", err)
+	}
+	unlock, err := lockFile(filepath.Join(c.dir, "lock"), exclusive)
+	if err != nil {
+		return nil, fmt.Errorf("Locking cache directory: %s", err)
+	}
+	return unlock, nil
+}
+
+func (c *BlobCache) removeStaleTempFiles() {
+	files, err := os.ReadDir(c.tempDir())
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if err == nil && time.Since(info.ModTime()) > staleCacheTempFileAge {
+			_ = os.Remove(filepath.Join(c.tempDir(), file.Name()))
+		}
+	}
+}
+
+func (c *BlobCache) blobsDir() string { return filepath.Join(c.dir, "blobs", "sha256") }
+func (c *BlobCache) tempDir() string  { return filepath.Join(c.dir, "tmp") }
+
+func (c *BlobCache) blobPath(digest regv1.Hash) string {
+	return filepath.Join(c.blobsDir(), digest.Hex)
+}
+
+// cachingReader writes the blob to a temporary file of the cache while it is downloaded
+type cachingReader struct {
+	reader io.ReadCloser
+	file   *os.File
+	hasher hash.Hash
+	digest regv1.Hash
+	cache  *BlobCache
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if r.file != nil && n > 0 {
+		_, writeErr := r.file.Write(p[:n])
+		if writeErr != nil {
+			r.discard()
+		} else {
+			r.hasher.Write(p[:n])
+		}
+	}
+	if err == io.EOF && r.file != nil {
+		r.finish()
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if r.file != nil {
+		// the blob was not read until the end
+		r.discard()
+	}
+	return r.reader.Close()
+}
+
+func (r *cachingReader) finish() {
+	tempPath := r.file.Name()
+	err := r.file.Close()
+	r.file = nil
+	if err != nil || hex.EncodeToString(r.hasher.Sum(nil)) != r.digest.Hex {
+		_ = os.Remove(tempPath)
+		return
+	}
+	if r.cache.add(r.digest, tempPath) != nil {
+		_ = os.Remove(tempPath)
+	}
+}
+
+func (r *cachingReader) discard() {
+	_ = r.file.Close()
+	_ = os.Remove(r.file.Name())
+	r.file = nil
+}
+
+// NewBlobCacheRoundTripper creates a RoundTripper that serves the blobs found in the cache without contacting the
+// registry, and adds the blobs it downloads to the cache
+func NewBlobCacheRoundTripper(parent http.RoundTripper, cache *BlobCache) *BlobCacheRoundTripper {
+	return &BlobCacheRoundTripper{
+		parent: parent,
+		cache:  cache,
+	}
+}
+
+// BlobCacheRoundTripper RoundTripper that reads and writes blobs through a BlobCache
+type BlobCacheRoundTripper struct {
+	parent http.RoundTripper
+	cache  *BlobCache
+}
+
+// RoundTrip serves the download of a blob from the cache when possible, otherwise calls the parent RoundTrip
+func (b *BlobCacheRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return b.parent.RoundTrip(req)
+	}
+	digest, found := requestedBlob(req)
+	if !found {
+		return b.parent.RoundTrip(req)
+	}
+
+	if file, size, found := b.cache.Open(digest); found {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: http.Header{
+				"Content-Length":        []string{strconv.FormatInt(size, 10)},
+				"Content-Type":          []string{"application/octet-stream"},
+				"Docker-Content-Digest": []string{digest.String()},
+			},
+			ContentLength: size,
+			Body:          file,
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := b.parent.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	resp.Body = b.cache.newCachingReader(digest, resp.Body)
+	return resp, nil
+}
+
+// requestedBlob returns the digest of the blob downloaded by the request. Registries often redirect the download to
+// a storage service, in that case the digest is found in the request that was redirected
+func requestedBlob(req *http.Request) (regv1.Hash, bool) {
+	for ; req != nil; req = previousRequest(req) {
+		match := blobPathMatcher.FindStringSubmatch(req.URL.Path)
+		if len(match) == 0 {
+			continue
+		}
+		digest, err := regv1.NewHash(match[1])
+		if err != nil {
+			return regv1.Hash{}, false
+		}
+		return digest, true
+	}
+	return regv1.Hash{}, false
+}
+
+func previousRequest(req *http.Request) *http.Request {
+	if req.Response == nil {
+		return nil
+	}
+	return req.Response.Request
+}
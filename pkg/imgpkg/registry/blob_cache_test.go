@@ -0,0 +1,204 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobCacheRoundTripper(t *testing.T) {
+	blob := []byte("some layer content")
+	sum := sha256.Sum256(blob)
+	digestHex := hex.EncodeToString(sum[:])
+	blobPath := "/v2/repo/blobs/sha256:" + digestHex
+
+	newServer := func(t *testing.T, downloads *int32) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/other-repo/blobs/sha256:" + digestHex:
+				http.Redirect(w, r, "/storage/"+digestHex, http.StatusTemporaryRedirect)
+			case blobPath, "/storage/" + digestHex:
+				atomic.AddInt32(downloads, 1)
+				w.Write(blob)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	get := func(t *testing.T, client *http.Client, url string) []byte {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		content, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return content
+	}
+
+	t.Run("it downloads a blob once and then serves it from the cache", func(t *testing.T) {
+		var downloads int32
+		server := newServer(t, &downloads)
+		cacheDir := t.TempDir()
+		client := &http.Client{Transport: registry.NewBlobCacheRoundTripper(http.DefaultTransport, registry.NewBlobCache(cacheDir))}
+
+		require.Equal(t, blob, get(t, client, server.URL+blobPath))
+		require.Equal(t, blob, get(t, client, server.URL+blobPath))
+		assert.Equal(t, int32(1), downloads)
+
+		cached, err := os.ReadFile(filepath.Join(cacheDir, "blobs", "sha256", digestHex))
+		require.NoError(t, err)
+		assert.Equal(t, blob, cached)
+	})
+
+	t.Run("it caches blobs downloaded after a redirect", func(t *testing.T) {
+		var downloads int32
+		server := newServer(t, &downloads)
+		client := &http.Client{Transport: registry.NewBlobCacheRoundTripper(http.DefaultTransport, registry.NewBlobCache(t.TempDir()))}
+
+		require.Equal(t, blob, get(t, client, server.URL+"/v2/other-repo/blobs/sha256:"+digestHex))
+		require.Equal(t, blob, get(t, client, server.URL+blobPath))
+		assert.Equal(t, int32(1), downloads)
+	})
+
+	t.Run("it discards a corrupted entry and downloads the blob again", func(t *testing.T) {
+		var downloads int32
+		server := newServer(t, &downloads)
+		cacheDir := t.TempDir()
+		entryPath := filepath.Join(cacheDir, "blobs", "sha256", digestHex)
+		require.NoError(t, os.MkdirAll(filepath.Dir(entryPath), 0700))
+		require.NoError(t, os.WriteFile(entryPath, []byte("some corrupted content"), 0600))
+		client := &http.Client{Transport: registry.NewBlobCacheRoundTripper(http.DefaultTransport, registry.NewBlobCache(cacheDir))}
+
+		require.Equal(t, blob, get(t, client, server.URL+blobPath))
+		assert.Equal(t, int32(1), downloads)
+
+		cached, err := os.ReadFile(entryPath)
+		require.NoError(t, err)
+		assert.Equal(t, blob, cached)
+	})
+
+	t.Run("it does not cache a blob that was not read until the end", func(t *testing.T) {
+		var downloads int32
+		server := newServer(t, &downloads)
+		cacheDir := t.TempDir()
+		client := &http.Client{Transport: registry.NewBlobCacheRoundTripper(http.DefaultTransport, registry.NewBlobCache(cacheDir))}
+
+		resp, err := client.Get(server.URL + blobPath)
+		require.NoError(t, err)
+		_, err = resp.Body.Read(make([]byte, 4))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.NoFileExists(t, filepath.Join(cacheDir, "blobs", "sha256", digestHex))
+		tempFiles, err := os.ReadDir(filepath.Join(cacheDir, "tmp"))
+		require.NoError(t, err)
+		assert.Empty(t, tempFiles)
+	})
+
+	t.Run("it does not cache a blob that does not match its digest", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("not the expected content"))
+		}))
+		defer server.Close()
+		cacheDir := t.TempDir()
+		client := &http.Client{Transport: registry.NewBlobCacheRoundTripper(http.DefaultTransport, registry.NewBlobCache(cacheDir))}
+
+		get(t, client, server.URL+blobPath)
+		require.NoFileExists(t, filepath.Join(cacheDir, "blobs", "sha256", digestHex))
+	})
+}
+
+func TestBlobCachePrune(t *testing.T) {
+	cacheDir := t.TempDir()
+	blobsDir := filepath.Join(cacheDir, "blobs", "sha256")
+	require.NoError(t, os.MkdirAll(blobsDir, 0700))
+
+	// the entries are used from the oldest to the newest
+	for idx, name := range []string{"oldest", "middle", "newest"} {
+		path := filepath.Join(blobsDir, name)
+		require.NoError(t, os.WriteFile(path, make([]byte, 100), 0600))
+		usedAt := time.Now().Add(time.Duration(idx-3) * time.Hour)
+		require.NoError(t, os.Chtimes(path, usedAt, usedAt))
+	}
+
+	result, err := registry.NewBlobCache(cacheDir).Prune(150)
+	require.NoError(t, err)
+	assert.Equal(t, registry.CachePruneResult{RemovedEntries: 2, RemovedSize: 200, Size: 100}, result)
+	assert.FileExists(t, filepath.Join(blobsDir, "newest"))
+	assert.NoFileExists(t, filepath.Join(blobsDir, "oldest"))
+	assert.NoFileExists(t, filepath.Join(blobsDir, "middle"))
+
+	t.Run("when the cache does not exist it does nothing", func(t *testing.T) {
+		result, err := registry.NewBlobCache(filepath.Join(cacheDir, "missing")).Prune(0)
+		require.NoError(t, err)
+		assert.Equal(t, registry.CachePruneResult{}, result)
+		assert.NoDirExists(t, filepath.Join(cacheDir, "missing"))
+	})
+}
+
+func TestBlobCacheBuiltLayers(t *testing.T) {
+	cacheDir := t.TempDir()
+	cache := registry.NewBlobCache(cacheDir)
+
+	blob := []byte("compressed layer content")
+	blobPath := filepath.Join(t.TempDir(), "layer.tar.gz")
+	require.NoError(t, os.WriteFile(blobPath, blob, 0600))
+	digest, _, err := regv1.SHA256(bytes.NewReader(blob))
+	require.NoError(t, err)
+	diffID, _, err := regv1.SHA256(bytes.NewReader([]byte("layer content")))
+	require.NoError(t, err)
+
+	key := strings.Repeat("ab", 32)
+	layer := registry.BuiltLayer{Digest: digest, DiffID: diffID, Size: int64(len(blob))}
+	require.NoError(t, cache.AddBuiltLayer(key, layer, blobPath))
+
+	cachedLayer, found := cache.BuiltLayer(key)
+	require.True(t, found)
+	assert.Equal(t, layer, cachedLayer)
+
+	// the blob is one of the blobs of the cache, so that pulling the image does not download it
+	file, size, found := cache.Open(digest)
+	require.True(t, found)
+	file.Close()
+	assert.Equal(t, int64(len(blob)), size)
+
+	t.Run("when the key is not cached, it is not found", func(t *testing.T) {
+		_, found := cache.BuiltLayer(strings.Repeat("cd", 32))
+		assert.False(t, found)
+		_, found = cache.BuiltLayer("../" + key)
+		assert.False(t, found)
+	})
+
+	t.Run("when the blob does not have the digest of the layer, it is not added", func(t *testing.T) {
+		otherLayer := registry.BuiltLayer{Digest: diffID, DiffID: diffID, Size: int64(len(blob))}
+		require.ErrorContains(t, cache.AddBuiltLayer(strings.Repeat("ef", 32), otherLayer, blobPath), "to have digest")
+	})
+
+	t.Run("when the blob is pruned, the layer is not found anymore", func(t *testing.T) {
+		_, err := cache.Prune(0)
+		require.NoError(t, err)
+		_, found := cache.BuiltLayer(key)
+		assert.False(t, found)
+		assert.NoFileExists(t, filepath.Join(cacheDir, "built-layers", key+".json"))
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// blobUploadPathMatcher matches the path of the registry API used to start the upload, or the mount, of a blob
+var blobUploadPathMatcher = regexp.MustCompile(`\A/v2/.+/blobs/uploads/\z`)
+
+// NewBlobMountRoundTripper creates a RoundTripper that calls onMounted with the digest of each blob the registry
+// mounted from another repository instead of having it uploaded
+func NewBlobMountRoundTripper(parent http.RoundTripper, onMounted func(digest string)) *BlobMountRoundTripper {
+	return &BlobMountRoundTripper{
+		parent:    parent,
+		onMounted: onMounted,
+	}
+}
+
+// BlobMountRoundTripper RoundTripper that reports the cross-repository blob mounts done by the registry
+type BlobMountRoundTripper struct {
+	parent    http.RoundTripper
+	onMounted func(digest string)
+}
+
+// RoundTrip calls the parent RoundTrip, the registry answering 201 Created to a POST with the mount and from
+// parameters when it mounted the blob
+func (b *BlobMountRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := b.parent.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost || !blobUploadPathMatcher.MatchString(req.URL.Path) {
+		return resp, err
+	}
+
+	query := req.URL.Query()
+	if resp.StatusCode == http.StatusCreated && query.Get("mount") != "" && query.Get("from") != "" {
+		b.onMounted(query.Get("mount"))
+	}
+	return resp, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry_test
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobMountRoundTripper(t *testing.T) {
+	t.Run("it reports the blobs the registry mounted, and not the ones it had uploaded", func(t *testing.T) {
+		blob := randomBytes(t, 100)
+		img := imageWithBlob(t, blob)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		layerDigest, err := layers[0].Digest()
+		require.NoError(t, err)
+
+		// the registry mounts the layer, the config being uploaded
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPost || r.URL.Query().Get("mount") != layerDigest.String() {
+				return false
+			}
+			w.Header().Set("Docker-Content-Digest", layerDigest.String())
+			w.WriteHeader(http.StatusCreated)
+			return true
+		})
+		defer server.Close()
+
+		var mounted []string
+		lock := &sync.Mutex{}
+		reg, err := registry.NewSimpleRegistry(registry.Opts{RetryCount: 1, RetryBackoff: time.Millisecond, OnBlobMounted: func(digest string) {
+			lock.Lock()
+			defer lock.Unlock()
+			mounted = append(mounted, digest)
+		}})
+		require.NoError(t, err)
+
+		host := strings.TrimPrefix(server.URL, "http://")
+		ref, err := name.ParseReference(host + "/app:tag")
+		require.NoError(t, err)
+		require.NoError(t, reg.WriteImage(ref, mountableImage{Image: img, from: name.MustParseReference("localhost/base:tag")}, nil))
+
+		assert.Equal(t, []string{layerDigest.String()}, mounted)
+	})
+}
+
+// mountableImage image whose layers are mounted from the repository of from
+type mountableImage struct {
+	regv1.Image
+	from name.Reference
+}
+
+func (i mountableImage) Layers() ([]regv1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var mountableLayers []regv1.Layer
+	for _, layer := range layers {
+		mountableLayers = append(mountableLayers, &remote.MountableLayer{Layer: layer, Reference: i.from})
+	}
+	return mountableLayers, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// builtLayerKeyMatcher matches the keys of the layers built, which are the hex of a sha256
+var builtLayerKeyMatcher = regexp.MustCompile(`\A[a-f0-9]{64}\z`)
+
+// BuiltLayer layer of an image built by push from files, whose compressed blob is one of the blobs of the cache, so
+// that pushing the same files reuses it, and pulling it does not download it
+type BuiltLayer struct {
+	Digest regv1.Hash `json:"digest"`
+	DiffID regv1.Hash `json:"diffID"`
+	Size   int64      `json:"size"`
+}
+
+// BuiltLayer returns the layer added with key by AddBuiltLayer, when its blob is still in the cache. The blob is
+// touched, so that Prune removes the layers pushed the least recently first
+func (c *BlobCache) BuiltLayer(key string) (BuiltLayer, bool) {
+	if !builtLayerKeyMatcher.MatchString(key) {
+		return BuiltLayer{}, false
+	}
+
+	unlock, err := c.lock(false)
+	if err != nil {
+		return BuiltLayer{}, false
+	}
+	defer unlock()
+
+	content, err := os.ReadFile(c.builtLayerPath(key))
+	if err != nil {
+		return BuiltLayer{}, false
+	}
+	var layer BuiltLayer
+	err = json.Unmarshal(content, &layer)
+	if err != nil || layer.Digest.Algorithm != "sha256" {
+		_ = os.Remove(c.builtLayerPath(key))
+		return BuiltLayer{}, false
+	}
+
+	info, err := os.Stat(c.blobPath(layer.Digest))
+	if err != nil || info.Size() != layer.Size {
+		_ = os.Remove(c.builtLayerPath(key))
+		return BuiltLayer{}, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(c.blobPath(layer.Digest), now, now)
+	return layer, true
+}
+
+// AddBuiltLayer adds the compressed blob of layer, at path, to the cache, recording it with key
+func (c *BlobCache) AddBuiltLayer(key string, layer BuiltLayer, path string) error {
+	if !builtLayerKeyMatcher.MatchString(key) {
+		return fmt.Errorf("Expected key '%s' of the layer to be the hex of a sha256", key)
+	}
+
+	err := os.MkdirAll(c.tempDir(), 0777)
+	if err != nil {
+		return fmt.Errorf("Creating cache directory: %s", err)
+	}
+	err = c.copyBlob(layer.Digest, path)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(layer)
+	if err != nil {
+		return err
+	}
+	entryFile, err := os.CreateTemp(c.tempDir(), key+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = entryFile.Write(content)
+	closeErr := entryFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.MkdirAll(c.builtLayersDir(), 0777)
+	}
+	if err == nil {
+		var unlock func()
+		unlock, err = c.lock(false)
+		if err == nil {
+			err = os.Rename(entryFile.Name(), c.builtLayerPath(key))
+			unlock()
+		}
+	}
+	if err != nil {
+		_ = os.Remove(entryFile.Name())
+		return fmt.Errorf("Adding layer to cache: %s", err)
+	}
+	return nil
+}
+
+// copyBlob copies the blob at path to the cache, once its digest is verified
+func (c *BlobCache) copyBlob(digest regv1.Hash, path string) error {
+	blob, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	file, err := os.CreateTemp(c.tempDir(), digest.Hex+"-*")
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), blob)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(hasher.Sum(nil)) != digest.Hex {
+		err = fmt.Errorf("Expected blob '%s' to have digest '%s'", path, digest)
+	}
+	if err == nil {
+		err = c.add(digest, file.Name())
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("Adding blob to cache: %s", err)
+	}
+	return nil
+}
+
+// removeOrphanBuiltLayers removes the layers whose blob is not in the cache anymore
+func (c *BlobCache) removeOrphanBuiltLayers() {
+	files, err := os.ReadDir(c.builtLayersDir())
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		path := filepath.Join(c.builtLayersDir(), file.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var layer BuiltLayer
+		if json.Unmarshal(content, &layer) == nil && layer.Digest.Algorithm == "sha256" {
+			if _, err := os.Stat(c.blobPath(layer.Digest)); err == nil {
+				continue
+			}
+		}
+		_ = os.Remove(path)
+	}
+}
+
+func (c *BlobCache) builtLayersDir() string { return filepath.Join(c.dir, "built-layers") }
+
+func (c *BlobCache) builtLayerPath(key string) string {
+	return filepath.Join(c.builtLayersDir(), key+".json")
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// existencePathMatcher matches the paths of the registry API of a blob or a manifest of a repository, which are
+// requested with HEAD to check whether the registry has them
+var existencePathMatcher = regexp.MustCompile(`\A/v2/.+/(blobs|manifests)/[^/]+\z`)
+
+// NewForceUploadRoundTripper creates a RoundTripper that has blobs and manifests uploaded even when the registry
+// already has them
+func NewForceUploadRoundTripper(parent http.RoundTripper) *ForceUploadRoundTripper {
+	return &ForceUploadRoundTripper{parent: parent}
+}
+
+// ForceUploadRoundTripper RoundTripper that answers the HEAD requests checking whether the registry has a blob or a
+// manifest as not found, without sending them, and that removes the mount parameters of the uploads, so that every
+// blob and manifest is uploaded. The reads of a manifest by HEAD fall back to GET
+type ForceUploadRoundTripper struct {
+	parent http.RoundTripper
+}
+
+// RoundTrip calls the parent RoundTrip, unless req checks whether the registry has a blob or a manifest
+func (f *ForceUploadRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodHead && existencePathMatcher.MatchString(req.URL.Path) {
+		return &http.Response{
+			Status:     "404 Not Found",
+			StatusCode: http.StatusNotFound,
+			Proto:      req.Proto,
+			ProtoMajor: req.ProtoMajor,
+			ProtoMinor: req.ProtoMinor,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	if req.Method == http.MethodPost && blobUploadPathMatcher.MatchString(req.URL.Path) {
+		query := req.URL.Query()
+		if query.Has("mount") || query.Has("from") {
+			query.Del("mount")
+			query.Del("from")
+			req = req.Clone(req.Context())
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+	return f.parent.RoundTrip(req)
+}
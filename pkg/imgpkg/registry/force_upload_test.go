@@ -0,0 +1,55 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForceUploadRoundTripper(t *testing.T) {
+	t.Run("it uploads the blobs and the manifest the registry already has, without mounting them", func(t *testing.T) {
+		img := imageWithBlob(t, randomBytes(t, 100))
+		var requests []string
+		server := createRetryServer(t, func(_ http.ResponseWriter, r *http.Request) bool {
+			requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+			return false
+		})
+		defer server.Close()
+		ref := writeImage(t, server, img)
+
+		reg, err := registry.NewSimpleRegistry(registry.Opts{RetryCount: 1, RetryBackoff: time.Millisecond, ForceUpload: true})
+		require.NoError(t, err)
+		requests = nil
+		require.NoError(t, reg.WriteImage(ref, mountableImage{Image: img, from: name.MustParseReference("localhost/base:tag")}, nil))
+
+		var uploads, manifestPuts int
+		for _, request := range requests {
+			assert.False(t, strings.HasPrefix(request, http.MethodHead), "Expected no HEAD request to be sent, but '%s' was", request)
+			assert.NotContains(t, request, "mount=")
+			if strings.HasPrefix(request, http.MethodPost) && strings.Contains(request, "/blobs/uploads/") {
+				uploads++
+			}
+			if strings.HasPrefix(request, http.MethodPut) && strings.Contains(request, "/manifests/") {
+				manifestPuts++
+			}
+		}
+		// the layer and the config
+		assert.Equal(t, 2, uploads)
+		assert.Equal(t, 1, manifestPuts)
+
+		digest, err := reg.Digest(ref)
+		require.NoError(t, err)
+		expectedDigest, err := img.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, expectedDigest, digest)
+	})
+}
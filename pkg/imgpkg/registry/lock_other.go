@@ -0,0 +1,17 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin && !freebsd && !windows
+
+package registry
+
+import "os"
+
+// lockFile files are only locked on Linux, macOS, FreeBSD and Windows, elsewhere the file is only created
+func lockFile(path string, _ bool) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = file.Close() }, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimitMaxWait longest wait asked for by a registry rate limiting the requests that is waited for, when
+	// none is provided
+	defaultRateLimitMaxWait = 5 * time.Minute
+	// minEpochReset values of X-RateLimit-Reset above it are times since the Unix epoch instead of seconds to wait
+	minEpochReset = 1000000000
+)
+
+// rateLimitRoundTripper pauses the requests to a registry that rate limits them, answering 429 Too Many Requests or
+// having no request left, until the time the registry provides, bounded by maxWait. Once a registry answered 429, its
+// requests are sent one at a time until one of them succeeds, so that the requests waiting do not all fail again
+type rateLimitRoundTripper struct {
+	inner   http.RoundTripper
+	maxWait time.Duration
+	// onRateLimited called with the host of the registry and the wait, when its requests are paused
+	onRateLimited func(host string, wait time.Duration)
+
+	lock  sync.Mutex
+	hosts map[string]*hostRateLimit
+}
+
+// hostRateLimit rate limit state of the requests to a registry
+type hostRateLimit struct {
+	// pausedUntil no request is sent before this time
+	pausedUntil time.Time
+	// throttled set from a 429 Too Many Requests until a request succeeds, the requests are sent one at a time while it is
+	throttled bool
+	// slot taken by the request sent while throttled
+	slot chan struct{}
+}
+
+func newRateLimitRoundTripper(inner http.RoundTripper, maxWait time.Duration, onRateLimited func(host string, wait time.Duration)) *rateLimitRoundTripper {
+	if maxWait <= 0 {
+		maxWait = defaultRateLimitMaxWait
+	}
+	return &rateLimitRoundTripper{inner: inner, maxWait: maxWait, onRateLimited: onRateLimited, hosts: map[string]*hostRateLimit{}}
+}
+
+// RoundTrip sends req once the requests to the registry are not paused, and records the rate limit of the response
+func (r *rateLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Host
+
+	pausedUntil, slot := r.state(host)
+	if delay := time.Until(pausedUntil); delay > 0 {
+		err := sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+		_, slot = r.state(host)
+	}
+	if slot != nil {
+		select {
+		case slot <- struct{}{}:
+			defer func() { <-slot }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	resp, err := r.inner.RoundTrip(req)
+	if err == nil {
+		r.record(host, resp)
+	}
+	return resp, err
+}
+
+// state returns the time until which the requests to host are paused, and the slot requests take while it is throttled
+func (r *rateLimitRoundTripper) state(host string) (time.Time, chan struct{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	limit, found := r.hosts[host]
+	if !found {
+		return time.Time{}, nil
+	}
+	if limit.throttled {
+		return limit.pausedUntil, limit.slot
+	}
+	return limit.pausedUntil, nil
+}
+
+// record pauses the requests to host when resp is a 429 Too Many Requests, or says no request is left, and ends the
+// throttling of host once a request succeeds
+func (r *rateLimitRoundTripper) record(host string, resp *http.Response) {
+	tooManyRequests := resp.StatusCode == http.StatusTooManyRequests
+	delay, found := time.Duration(0), false
+	if tooManyRequests || noRequestLeft(resp) {
+		delay, found = rateLimitDelay(resp, r.maxWait)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	limit, exists := r.hosts[host]
+	if !exists {
+		if !tooManyRequests && !found {
+			return
+		}
+		limit = &hostRateLimit{slot: make(chan struct{}, 1)}
+		r.hosts[host] = limit
+	}
+	switch {
+	case tooManyRequests:
+		limit.throttled = true
+	case resp.StatusCode < http.StatusInternalServerError:
+		limit.throttled = false
+	}
+
+	pausedUntil := time.Now().Add(delay)
+	if !found || delay == 0 || !pausedUntil.After(limit.pausedUntil) {
+		return
+	}
+	// the requests already paused are not reported again
+	if time.Now().After(limit.pausedUntil) && r.onRateLimited != nil {
+		r.onRateLimited(host, delay)
+	}
+	limit.pausedUntil = pausedUntil
+}
+
+// rateLimitDelay returns the wait asked for by the registry in resp, with Retry-After or, when it does not have it, with
+// the reset of its rate limit, bounded by maxWait
+func rateLimitDelay(resp *http.Response, maxWait time.Duration) (time.Duration, bool) {
+	delay, found := retryAfterDelay(resp)
+	if !found {
+		delay, found = rateLimitReset(resp)
+	}
+	if delay > maxWait {
+		delay = maxWait
+	}
+	return delay, found
+}
+
+// noRequestLeft checks if the RateLimit-Remaining, X-RateLimit-Remaining or RateLimit header of resp says that no
+// request is left until the rate limit resets
+func noRequestLeft(resp *http.Response) bool {
+	remaining, found := rateLimitField(resp, "remaining")
+	return found && remaining == 0
+}
+
+// rateLimitReset returns the wait until the rate limit of the registry resets, from the RateLimit-Reset or
+// X-RateLimit-Reset header of resp, in seconds or, for X-RateLimit-Reset, as a time since the Unix epoch
+func rateLimitReset(resp *http.Response) (time.Duration, bool) {
+	reset, found := rateLimitField(resp, "reset")
+	if !found {
+		return 0, false
+	}
+	if reset > minEpochReset {
+		delay := time.Until(time.Unix(reset, 0))
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return time.Duration(reset) * time.Second, true
+}
+
+// rateLimitField returns the number of field, remaining or reset, from the RateLimit-<Field> or X-RateLimit-<Field>
+// headers (format: 0;w=21600), or from the RateLimit header (format: limit=100, remaining=0, reset=30)
+func rateLimitField(resp *http.Response, field string) (int64, bool) {
+	name := strings.ToUpper(field[:1]) + field[1:]
+	for _, header := range []string{"RateLimit-" + name, "X-RateLimit-" + name} {
+		value := resp.Header.Get(header)
+		if value == "" {
+			continue
+		}
+		if number, err := strconv.ParseInt(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]), 10, 64); err == nil && number >= 0 {
+			return number, true
+		}
+	}
+
+	for _, param := range strings.Split(resp.Header.Get("RateLimit"), ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || key != field {
+			continue
+		}
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil && number >= 0 {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// sleep waits for delay, or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -4,8 +4,10 @@
 package registry
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -40,11 +42,40 @@ type Opts struct {
 
 	ResponseHeaderTimeout time.Duration
 	RetryCount            int
+	// RetryBackoff wait before the first retry of a request, doubled for each following retry. Defaults to 100ms
+	RetryBackoff time.Duration
+	// UploadChunkSize size of the chunks blobs are uploaded in, an upload failing in a chunk is resumed from it. Defaults to 32MiB
+	UploadChunkSize int64
+	// RateLimitMaxWait longest wait asked for by a registry rate limiting the requests, with Retry-After or
+	// RateLimit-Reset, that is waited for. Defaults to 5 minutes
+	RateLimitMaxWait time.Duration
+	// OnRateLimited called with the host of the registry and the wait, when it rate limits the requests
+	OnRateLimited func(host string, wait time.Duration)
+	// OnRetry called with the host of the registry, the retry, starting at 1, and why the request failed, before a
+	// request is sent again
+	OnRetry func(host string, retry int, reason string)
+	// OnBlobMounted called with the digest of each blob the registry mounted from another repository instead of having it uploaded
+	OnBlobMounted func(digest string)
+	// ForceUpload uploads every blob and manifest written, even those the registry already has, without mounting blobs
+	// from other repositories
+	ForceUpload bool
+	// MaxConnectionsPerRegistry most connections opened to each registry at once, requests waiting for one of them
+	// to be available. Unlimited when 0
+	MaxConnectionsPerRegistry int
+	// BandwidthLimiter limits the bytes uploaded and downloaded per second, shared with the other registries it is
+	// provided to. No limit when nil
+	BandwidthLimiter *BandwidthLimiter
+
+	// CacheDir directory of the cache of the downloaded blobs, shared with other imgpkg processes. No cache is used when empty
+	CacheDir string
 
 	EnvironFunc     func() []string
 	ActiveKeychains []auth.IAASKeychain
 
 	SessionID string
+
+	// Context when provided stops the requests to the registry once it is done
+	Context context.Context
 }
 
 // DeepCopy the options to a new struct
@@ -59,8 +90,17 @@ func (o Opts) DeepCopy() Opts {
 		Anon:                          o.Anon,
 		EnableIaasAuthProviders:       o.EnableIaasAuthProviders,
 		ResponseHeaderTimeout:         o.ResponseHeaderTimeout,
+		MaxConnectionsPerRegistry:     o.MaxConnectionsPerRegistry,
+		BandwidthLimiter:              o.BandwidthLimiter,
 		RetryCount:                    o.RetryCount,
+		RetryBackoff:                  o.RetryBackoff,
+		RateLimitMaxWait:              o.RateLimitMaxWait,
+		OnRateLimited:                 o.OnRateLimited,
+		OnRetry:                       o.OnRetry,
+		CacheDir:                      o.CacheDir,
+		ForceUpload:                   o.ForceUpload,
 		EnvironFunc:                   o.EnvironFunc,
+		Context:                       o.Context,
 	}
 	for _, path := range o.CACertPaths {
 		result.CACertPaths = append(result.CACertPaths, path)
@@ -78,11 +118,14 @@ type Registry interface {
 	Index(reference regname.Reference) (regv1.ImageIndex, error)
 	Image(reference regname.Reference) (regv1.Image, error)
 	FirstImageExists(digests []string) (string, error)
+	Referrers(digest regname.Digest) (regv1.ImageIndex, error)
 
 	MultiWrite(imageOrIndexesToUpload map[regname.Reference]regremote.Taggable, concurrency int, updatesCh chan regv1.Update) error
 	WriteImage(regname.Reference, regv1.Image, chan regv1.Update) error
+	WriteLayer(repo regname.Repository, layer regv1.Layer) error
 	WriteIndex(reference regname.Reference, index regv1.ImageIndex) error
 	WriteTag(tag regname.Tag, taggable regremote.Taggable) error
+	CheckPushPermission(repo regname.Repository) error
 
 	ListTags(repo regname.Repository) ([]string, error)
 
@@ -132,6 +175,8 @@ type SimpleRegistry struct {
 	authn           map[string]regauthn.Authenticator
 	roundTrippers   RoundTripperStorage
 	transportAccess *sync.Mutex
+	// ctx stops the requests to the registry, including the ones creating the RoundTrippers
+	ctx context.Context
 }
 
 // NewBasicRegistry does not provide any special behavior and all the options as passed as is to the underlying library
@@ -183,14 +228,23 @@ func NewSimpleRegistryWithTransport(opts Opts, rTripper http.RoundTripper) (*Sim
 		tries = 1
 	}
 
+	backoff := opts.RetryBackoff
+	if backoff == 0 {
+		backoff = 100 * time.Millisecond
+	}
+
 	retryBackoff := regremote.Backoff{
-		Duration: 100 * time.Millisecond,
+		Duration: backoff,
 		Factor:   2,
 		Jitter:   0,
 		Steps:    tries,
 		Cap:      1 * time.Second,
 	}
-	regRemoteOptions = append(regRemoteOptions, regremote.WithRetryBackoff(retryBackoff))
+	// The requests are retried by RetryRoundTripper, uploads are only started again when the registry lost them
+	regRemoteOptions = append(regRemoteOptions, regremote.WithRetryBackoff(retryBackoff), regremote.WithRetryPredicate(restartableUploadError))
+	if opts.Context != nil {
+		regRemoteOptions = append(regRemoteOptions, regremote.WithContext(opts.Context))
+	}
 
 	baseRoundTripper := rTripper
 	if logs.Enabled(logs.Debug) {
@@ -203,16 +257,42 @@ func NewSimpleRegistryWithTransport(opts Opts, rTripper http.RoundTripper) (*Sim
 	}
 	baseRoundTripper = NewImgpkgRoundTripper(baseRoundTripper, sessionID)
 
-	// Wrap the transport in something that can retry network flakes.
-	baseRoundTripper = transport.NewRetry(baseRoundTripper, transport.WithRetryBackoff(retryBackoff))
+	// the blobs read from the cache are not limited, only what is transferred with the registry
+	if opts.BandwidthLimiter != nil {
+		baseRoundTripper = NewBandwidthLimitRoundTripper(baseRoundTripper, opts.BandwidthLimiter)
+	}
+
+	if opts.CacheDir != "" {
+		baseRoundTripper = NewBlobCacheRoundTripper(baseRoundTripper, NewBlobCache(opts.CacheDir))
+	}
+
+	baseRoundTripper = NewRetryRoundTripper(baseRoundTripper, RetryOpts{
+		Tries:            tries,
+		Backoff:          backoff,
+		UploadChunkSize:  opts.UploadChunkSize,
+		RateLimitMaxWait: opts.RateLimitMaxWait,
+		OnRateLimited:    opts.OnRateLimited,
+		OnRetry:          opts.OnRetry,
+	})
+
+	if opts.OnBlobMounted != nil {
+		baseRoundTripper = NewBlobMountRoundTripper(baseRoundTripper, opts.OnBlobMounted)
+	}
+	if opts.ForceUpload {
+		baseRoundTripper = NewForceUploadRoundTripper(baseRoundTripper)
+	}
+
+	roundTrippers := NewMultiRoundTripperStorage(baseRoundTripper)
+	roundTrippers.ctx = opts.Context
 
 	return &SimpleRegistry{
 		remoteOpts:      regRemoteOptions,
 		refOpts:         refOpts,
 		keychain:        keychain,
-		roundTrippers:   NewMultiRoundTripperStorage(baseRoundTripper),
+		roundTrippers:   roundTrippers,
 		authn:           map[string]regauthn.Authenticator{},
 		transportAccess: &sync.Mutex{},
+		ctx:             opts.Context,
 	}, nil
 }
 
@@ -237,7 +317,9 @@ func (r SimpleRegistry) CloneWithSingleAuth(imageRef regname.Tag) (Registry, err
 
 	var singleRt RoundTripperStorage = NewNoopRoundTripperStorage()
 	if rt != nil {
-		singleRt = NewSingleTripperStorage(rt)
+		storage := NewSingleTripperStorage(rt)
+		storage.ctx = r.ctx
+		singleRt = storage
 	}
 
 	return &SimpleRegistry{
@@ -247,6 +329,7 @@ func (r SimpleRegistry) CloneWithSingleAuth(imageRef regname.Tag) (Registry, err
 		roundTrippers:   singleRt,
 		authn:           map[string]regauthn.Authenticator{},
 		transportAccess: &sync.Mutex{},
+		ctx:             r.ctx,
 	}, nil
 }
 
@@ -260,6 +343,7 @@ func (r SimpleRegistry) CloneWithLogger(_ util.ProgressLogger) Registry {
 		roundTrippers:   r.roundTrippers,
 		authn:           map[string]regauthn.Authenticator{},
 		transportAccess: &sync.Mutex{},
+		ctx:             r.ctx,
 	}
 }
 
@@ -367,6 +451,23 @@ func (r *SimpleRegistry) Digest(ref regname.Reference) (regv1.Hash, error) {
 	return desc.Digest, nil
 }
 
+// Referrers Retrieve the index of the manifests whose subject is digest, with the referrers API of the registry or,
+// when it does not have it, with the sha256-<digest> tag of the referrers tag schema
+func (r *SimpleRegistry) Referrers(digest regname.Digest) (regv1.ImageIndex, error) {
+	if err := r.validateRef(digest); err != nil {
+		return nil, err
+	}
+	overriddenRef, err := regname.NewDigest(digest.String(), r.refOpts...)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := r.readOpts(overriddenRef)
+	if err != nil {
+		return nil, err
+	}
+	return regremote.Referrers(overriddenRef, opts...)
+}
+
 // Image Retrieve the regv1.Image struct for an Image reference
 func (r *SimpleRegistry) Image(ref regname.Reference) (regv1.Image, error) {
 	if err := r.validateRef(ref); err != nil {
@@ -384,7 +485,7 @@ func (r *SimpleRegistry) Image(ref regname.Reference) (regv1.Image, error) {
 	return regremote.Image(overriddenRef, opts...)
 }
 
-// MultiWrite Upload multiple Images in Parallel to the Registry
+// MultiWrite Upload multiple Images in Parallel to the Registry, reporting every image that failed to be written
 func (r *SimpleRegistry) MultiWrite(imageOrIndexesToUpload map[regname.Reference]regremote.Taggable, concurrency int, updatesCh chan regv1.Update) error {
 	overriddenImageOrIndexesToUploadRef := map[regname.Reference]regremote.Taggable{}
 
@@ -410,7 +511,44 @@ func (r *SimpleRegistry) MultiWrite(imageOrIndexesToUpload map[regname.Reference
 	if updatesCh != nil {
 		rOpts = append(rOpts, regremote.WithProgress(updatesCh))
 	}
-	return regremote.MultiWrite(overriddenImageOrIndexesToUploadRef, rOpts...)
+	// the images share the pusher, so that the blobs they have in common are uploaded once, but unlike
+	// regremote.MultiWrite an image failing does not stop the others, so that every image that failed is reported
+	pusher, err := regremote.NewPusher(rOpts...)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	throttle := util.NewThrottle(concurrency)
+	var errs util.ImageErrors
+	var wg sync.WaitGroup
+	for ref, taggable := range overriddenImageOrIndexesToUploadRef {
+		ref, taggable := ref, taggable
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle.Take()
+			defer throttle.Done()
+
+			if err := pusher.Push(ctx, ref, taggable); err != nil {
+				errs.Add(fmt.Errorf("Writing %s: %s", ref, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = errs.Err()
+	if updatesCh != nil {
+		if err != nil {
+			updatesCh <- regv1.Update{Error: err}
+		}
+		close(updatesCh)
+	}
+	return err
 }
 
 // WriteImage Upload Image to registry
@@ -438,6 +576,68 @@ func (r *SimpleRegistry) WriteImage(ref regname.Reference, img regv1.Image, upda
 	return nil
 }
 
+// WriteLayer Upload the blob of a layer to the repository, unless the registry already has it, so that the images
+// referencing it can be written once all their blobs are uploaded
+func (r *SimpleRegistry) WriteLayer(repo regname.Repository, layer regv1.Layer) error {
+	digest, err := layer.Digest()
+	if err != nil {
+		return err
+	}
+	ref := repo.Digest(digest.String())
+	if err := r.validateRef(ref); err != nil {
+		return err
+	}
+	overriddenRef, err := regname.ParseReference(ref.String(), r.refOpts...)
+	if err != nil {
+		return err
+	}
+
+	opts, err := r.writeOpts(overriddenRef)
+	if err != nil {
+		return err
+	}
+	err = regremote.WriteLayer(overriddenRef.Context(), layer, opts...)
+	if err != nil {
+		return fmt.Errorf("Writing layer: %s", err)
+	}
+
+	return nil
+}
+
+// CheckPushPermission Initiate, and cancel, the upload of a blob to the repository, so that a repository that does not
+// exist, or that the credentials cannot push to, is reported before any blob is uploaded. The other failures, such as
+// an unreachable registry, are left to the upload, which retries them
+func (r *SimpleRegistry) CheckPushPermission(repo regname.Repository) error {
+	overriddenRef, err := regname.ParseReference(repo.Name(), r.refOpts...)
+	if err != nil {
+		return err
+	}
+	keychain := r.keychain
+	if keychain == nil {
+		keychain = regauthn.NewMultiKeychain()
+	}
+	baseRoundTripper := r.roundTrippers.BaseRoundTripper()
+	if baseRoundTripper == nil {
+		baseRoundTripper = http.DefaultTransport
+	}
+
+	err = regremote.CheckPushPermission(overriddenRef, keychain, baseRoundTripper)
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return nil
+	}
+	switch transportErr.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("Pushing to '%s' is not authorized, the credentials are missing or the token lacks push scope: %s", repo.Name(), err)
+	case http.StatusForbidden:
+		return fmt.Errorf("Pushing to '%s' is denied, the token lacks push scope: %s", repo.Name(), err)
+	case http.StatusNotFound:
+		return fmt.Errorf("Repository '%s' does not exist and the registry does not auto-create repositories: %s", repo.Name(), err)
+	default:
+		return nil
+	}
+}
+
 // Index Retrieve regv1.ImageIndex struct for an Index reference
 func (r *SimpleRegistry) Index(ref regname.Reference) (regv1.ImageIndex, error) {
 	if err := r.validateRef(ref); err != nil {
@@ -556,6 +756,11 @@ func newHTTPTransport(opts Opts) (*http.Transport, error) {
 	clonedDefaultTransport := http.DefaultTransport.(*http.Transport).Clone()
 	clonedDefaultTransport.ForceAttemptHTTP2 = false
 	clonedDefaultTransport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	if opts.MaxConnectionsPerRegistry > 0 {
+		clonedDefaultTransport.MaxConnsPerHost = opts.MaxConnectionsPerRegistry
+		// keeps the connections open between the transfers, instead of the default of 2
+		clonedDefaultTransport.MaxIdleConnsPerHost = opts.MaxConnectionsPerRegistry
+	}
 	clonedDefaultTransport.TLSClientConfig = &tls.Config{
 		RootCAs:            pool,
 		InsecureSkipVerify: opts.VerifyCerts == false,
@@ -10,7 +10,9 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -18,6 +20,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
 )
 
 func TestRegistry_Digest(t *testing.T) {
@@ -108,6 +111,83 @@ func TestRegistry_TransportHeaders(t *testing.T) {
 	})
 }
 
+func TestRegistry_MaxConnectionsPerRegistry(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := createServer(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Docker-Content-Digest", "sha256:477c34d98f9e090a4441cf82d2f1f03e64c8eb730e8c1ef39a8595e685d4df65")
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	})
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	subject, err := registry.NewSimpleRegistry(registry.Opts{MaxConnectionsPerRegistry: 2})
+	require.NoError(t, err)
+
+	var wg errgroup.Group
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Go(func() error {
+			imgRef, err := name.ParseReference(fmt.Sprintf("%s/repo:tag-%d", u.Host, i))
+			if err != nil {
+				return err
+			}
+			_, err = subject.Digest(imgRef)
+			return err
+		})
+	}
+	require.NoError(t, wg.Wait())
+	assert.LessOrEqual(t, maxInFlight, 2)
+}
+
+func TestRegistry_MultiWrite(t *testing.T) {
+	t.Run("when the uploads of several images fail, it writes the other images and reports every image that failed", func(t *testing.T) {
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPost || !strings.Contains(r.URL.Path, "/failing-") {
+				return false
+			}
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		})
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		reg, err := registry.NewSimpleRegistry(registry.Opts{})
+		require.NoError(t, err)
+
+		images := map[name.Reference]regremote.Taggable{}
+		for _, repo := range []string{"failing-1", "working", "failing-2"} {
+			ref, err := name.ParseReference(host + "/" + repo + ":tag")
+			require.NoError(t, err)
+			images[ref] = imageWithBlob(t, randomBytes(t, 100))
+		}
+
+		err = reg.MultiWrite(images, 1, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "2 images failed")
+		assert.Contains(t, err.Error(), "Writing "+host+"/failing-1:tag")
+		assert.Contains(t, err.Error(), "Writing "+host+"/failing-2:tag")
+		assert.NotContains(t, err.Error(), "/working")
+
+		workingRef, err := name.ParseReference(host + "/working:tag")
+		require.NoError(t, err)
+		_, err = reg.Digest(workingRef)
+		require.NoError(t, err)
+	})
+}
+
 func createServer(handler func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
 	response := []byte("doesn't matter")
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -146,6 +226,81 @@ func TestRegistry_Get(t *testing.T) {
 
 }
 
+func TestRegistry_CheckPushPermission(t *testing.T) {
+	t.Run("when the upload of a blob can be started, it cancels it", func(t *testing.T) {
+		cancelled := make(chan string, 1)
+		server := createServer(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPost && r.URL.Path == "/v2/repo/blobs/uploads/":
+				w.Header().Set("Location", "/v2/repo/blobs/uploads/some-upload")
+				w.WriteHeader(http.StatusAccepted)
+			case r.Method == http.MethodDelete:
+				cancelled <- r.URL.Path
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		})
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		subject, err := registry.NewSimpleRegistry(registry.Opts{})
+		require.NoError(t, err)
+
+		repo, err := name.NewRepository(u.Host + "/repo")
+		require.NoError(t, err)
+		require.NoError(t, subject.CheckPushPermission(repo))
+
+		select {
+		case path := <-cancelled:
+			assert.Equal(t, "/v2/repo/blobs/uploads/some-upload", path)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the upload to be cancelled")
+		}
+	})
+
+	errorCases := []struct {
+		name          string
+		statusCode    int
+		expectedError string
+	}{
+		{
+			name:          "when the registry does not authorize the push",
+			statusCode:    http.StatusUnauthorized,
+			expectedError: "Pushing to '%s/repo' is not authorized, the credentials are missing or the token lacks push scope",
+		},
+		{
+			name:          "when the registry denies the push",
+			statusCode:    http.StatusForbidden,
+			expectedError: "Pushing to '%s/repo' is denied, the token lacks push scope",
+		},
+		{
+			name:          "when the repository does not exist",
+			statusCode:    http.StatusNotFound,
+			expectedError: "Repository '%s/repo' does not exist and the registry does not auto-create repositories",
+		},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name+", it fails with an actionable error", func(t *testing.T) {
+			server := createServer(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCode)
+			})
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			subject, err := registry.NewSimpleRegistry(registry.Opts{})
+			require.NoError(t, err)
+
+			repo, err := name.NewRepository(u.Host + "/repo")
+			require.NoError(t, err)
+			err = subject.CheckPushPermission(repo)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprintf(tc.expectedError, u.Host))
+		})
+	}
+}
+
 func TestInsecureRegistryFlag(t *testing.T) {
 	tests := []struct {
 		fName string
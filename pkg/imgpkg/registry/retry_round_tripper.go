@@ -0,0 +1,452 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	// maxRetryBackoff longest wait between two attempts at a request, unless the registry asks for a longer one
+	maxRetryBackoff = 30 * time.Second
+	// defaultUploadChunkSize size of the chunks blobs are uploaded in when none is provided
+	defaultUploadChunkSize = 32 * 1024 * 1024
+)
+
+// errChunkDone returned when the transport reads the chunk of a blob after its request is done
+var errChunkDone = errors.New("Chunk upload is done")
+
+// RetryOpts retry policy of RetryRoundTripper
+type RetryOpts struct {
+	// Tries number of times each request is sent, at least once
+	Tries int
+	// Backoff wait before the first retry of a request, doubled for each following retry
+	Backoff time.Duration
+	// UploadChunkSize size of the chunks blobs are uploaded in. Defaults to 32MiB
+	UploadChunkSize int64
+	// RateLimitMaxWait longest wait asked for by a registry rate limiting the requests that is waited for before
+	// retrying. Defaults to 5 minutes
+	RateLimitMaxWait time.Duration
+	// OnRateLimited called with the host of the registry and the wait, when it rate limits the requests
+	OnRateLimited func(host string, wait time.Duration)
+	// OnRetry called with the host of the registry, the retry, starting at 1, and why the request failed, before the
+	// request is sent again
+	OnRetry func(host string, retry int, reason string)
+}
+
+// RetryRoundTripper retries the requests to the registry that fail with a network error, a 429 Too Many Requests or
+// a server error, waiting for as long as the Retry-After, or RateLimit-Reset, header of the response asks for, up to
+// RetryOpts.RateLimitMaxWait. Other errors, like a failed authentication or a missing repository, are returned right
+// away, retrying them would not change the result
+//
+// The requests to a registry rate limiting them are paused, and sent one at a time until one of them succeeds, see
+// rateLimitRoundTripper
+//
+// Blobs are uploaded in chunks, so that when a chunk fails the upload is resumed from the last offset the registry
+// acknowledged, instead of uploading the blob again from the start
+type RetryRoundTripper struct {
+	inner http.RoundTripper
+	opts  RetryOpts
+}
+
+var _ http.RoundTripper = &RetryRoundTripper{}
+
+// NewRetryRoundTripper creates a RetryRoundTripper sending the requests with inner
+func NewRetryRoundTripper(inner http.RoundTripper, opts RetryOpts) *RetryRoundTripper {
+	if opts.Tries < 1 {
+		opts.Tries = 1
+	}
+	if opts.UploadChunkSize <= 0 {
+		opts.UploadChunkSize = defaultUploadChunkSize
+	}
+	if opts.RateLimitMaxWait <= 0 {
+		opts.RateLimitMaxWait = defaultRateLimitMaxWait
+	}
+	return &RetryRoundTripper{inner: newRateLimitRoundTripper(inner, opts.RateLimitMaxWait, opts.OnRateLimited), opts: opts}
+}
+
+// RoundTrip sends the request, retrying it when it fails with a retryable error and it can be sent again
+func (r *RetryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isBlobUpload(req) {
+		return r.uploadChunks(req)
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	attemptReq := req
+	for attempt := 1; ; attempt++ {
+		resp, err := r.inner.RoundTrip(attemptReq)
+		if attempt >= r.opts.Tries || !replayable || !retryableResponse(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		r.retried(req, attempt, resp, err)
+		err = r.wait(req.Context(), attempt, resp)
+		if err != nil {
+			return nil, err
+		}
+		attemptReq, err = rewindRequest(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retried reports to RetryOpts.OnRetry that req is sent again for the provided retry, after failing with resp or err
+func (r *RetryRoundTripper) retried(req *http.Request, retry int, resp *http.Response, err error) {
+	if r.opts.OnRetry == nil {
+		return
+	}
+	reason := ""
+	switch {
+	case err != nil:
+		reason = err.Error()
+	case resp != nil:
+		reason = resp.Status
+	}
+	r.opts.OnRetry(req.URL.Host, retry, reason)
+}
+
+// wait waits before the provided retry, starting at 1, for as long as the registry asks for in resp, up to
+// RetryOpts.RateLimitMaxWait, or for the backoff doubled for each retry otherwise
+func (r *RetryRoundTripper) wait(ctx context.Context, retry int, resp *http.Response) error {
+	delay := r.opts.Backoff
+	for n := 1; n < retry && delay < maxRetryBackoff; n++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	if resp != nil {
+		if retryAfter, found := rateLimitDelay(resp, r.opts.RateLimitMaxWait); found {
+			delay = retryAfter
+		}
+		drainAndClose(resp)
+	}
+
+	return sleep(ctx, delay)
+}
+
+// uploadChunks sends the blob of a PATCH request in chunks, each one with the range of the blob it has. When a chunk
+// fails, the upload is resumed from the offset the registry acknowledged. Registries that do not accept the first chunk
+// get the blob in a single request, which is not retried since the registry can have kept part of it
+func (r *RetryRoundTripper) uploadChunks(req *http.Request) (*http.Response, error) {
+	upload := &chunkedUpload{req: req, size: req.ContentLength, location: req.URL, body: req.Body}
+	defer upload.close()
+
+	for attempt := 1; ; attempt++ {
+		end := upload.offset + r.opts.UploadChunkSize
+		if end > upload.size {
+			end = upload.size
+		}
+
+		resp, err := upload.send(r.inner, end, true)
+		if err == nil && (resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusCreated) {
+			offset := upload.offset
+			upload.acknowledge(resp, end)
+			if upload.offset >= upload.size {
+				return resp, nil
+			}
+			drainAndClose(resp)
+			if upload.offset > offset {
+				attempt = 0
+			}
+			continue
+		}
+
+		if err == nil && !upload.started && (resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+			drainAndClose(resp)
+			return upload.send(r.inner, upload.size, false)
+		}
+		if attempt >= r.opts.Tries || !retryableResponse(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		r.retried(req, attempt, resp, err)
+		err = r.wait(req.Context(), attempt, resp)
+		if err != nil {
+			return nil, err
+		}
+		upload.resume(r.inner)
+	}
+}
+
+// chunkedUpload state of the upload of a blob in chunks
+type chunkedUpload struct {
+	req      *http.Request
+	size     int64
+	location *url.URL
+	// offset of the blob up to which the registry acknowledged the chunks
+	offset int64
+	// started is set once the registry acknowledged a chunk
+	started bool
+
+	body io.ReadCloser
+	// bodyOffset of the blob body is read from
+	bodyOffset int64
+	lock       sync.Mutex
+}
+
+// send sends the blob from the acknowledged offset to end, with the range of the blob in the Content-Range header when
+// withRange is set
+func (u *chunkedUpload) send(inner http.RoundTripper, end int64, withRange bool) (*http.Response, error) {
+	err := u.seek(u.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk := &chunkReader{upload: u, remaining: end - u.offset}
+	defer chunk.finish()
+
+	chunkReq := u.newRequest(u.req.Method, chunk)
+	chunkReq.ContentLength = end - u.offset
+	if withRange {
+		chunkReq.Header.Set("Content-Range", fmt.Sprintf("%d-%d", u.offset, end-1))
+	}
+	return inner.RoundTrip(chunkReq)
+}
+
+// acknowledge records the offset up to which the registry has the blob, as provided in the Range header of resp, and
+// the location the upload continues at
+func (u *chunkedUpload) acknowledge(resp *http.Response, end int64) {
+	u.started = true
+	previousOffset := u.offset
+	u.offset = end
+	if offset, found := acknowledgedOffset(resp); found && offset > previousOffset && offset <= u.size {
+		u.offset = offset
+	}
+	u.updateLocation(resp)
+}
+
+// resume gets the offset up to which the registry has the blob, which is after the acknowledged one when the registry
+// kept part of the chunk that failed. The acknowledged offset is kept when the registry does not provide the status of
+// the upload
+func (u *chunkedUpload) resume(inner http.RoundTripper) {
+	resp, err := inner.RoundTrip(u.newRequest(http.MethodGet, nil))
+	if err != nil {
+		return
+	}
+	defer drainAndClose(resp)
+
+	if resp.StatusCode != http.StatusNoContent {
+		return
+	}
+	if offset, found := acknowledgedOffset(resp); found && offset > u.offset && offset <= u.size {
+		u.offset = offset
+	}
+	u.updateLocation(resp)
+}
+
+func (u *chunkedUpload) newRequest(method string, body io.ReadCloser) *http.Request {
+	req := u.req.Clone(u.req.Context())
+	req.Method = method
+	req.URL = u.location
+	req.Host = u.location.Host
+	req.Body = body
+	req.GetBody = nil
+	req.ContentLength = 0
+	req.Header.Del("Content-Range")
+	if body == nil {
+		req.Header.Del("Content-Type")
+	}
+	return req
+}
+
+func (u *chunkedUpload) updateLocation(resp *http.Response) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	u.location = u.location.ResolveReference(locationURL)
+}
+
+// seek positions the body at offset, opening the blob again when offset was already read
+func (u *chunkedUpload) seek(offset int64) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if offset < u.bodyOffset {
+		_ = u.body.Close()
+		body, err := u.req.GetBody()
+		if err != nil {
+			return err
+		}
+		u.body = body
+		u.bodyOffset = 0
+	}
+	discarded, err := io.CopyN(io.Discard, u.body, offset-u.bodyOffset)
+	u.bodyOffset += discarded
+	return err
+}
+
+func (u *chunkedUpload) close() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	_ = u.body.Close()
+}
+
+// chunkReader reads a chunk of the blob. Once the request of the chunk is done, the transport can still be reading it,
+// so the reads stop, to not move the body read by the next chunk
+type chunkReader struct {
+	upload    *chunkedUpload
+	remaining int64
+	done      bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	c.upload.lock.Lock()
+	defer c.upload.lock.Unlock()
+
+	if c.done {
+		return 0, errChunkDone
+	}
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.upload.body.Read(p)
+	c.remaining -= int64(n)
+	c.upload.bodyOffset += int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close does nothing, the body of the blob is closed once the upload is done
+func (c *chunkReader) Close() error { return nil }
+
+func (c *chunkReader) finish() {
+	c.upload.lock.Lock()
+	defer c.upload.lock.Unlock()
+	c.done = true
+}
+
+// isBlobUpload checks if req uploads a blob whose size is known in a single PATCH request, which can be split in chunks
+func isBlobUpload(req *http.Request) bool {
+	return req.Method == http.MethodPatch && strings.Contains(req.URL.Path, "/blobs/uploads/") &&
+		req.GetBody != nil && req.ContentLength > 0 && req.Header.Get("Content-Range") == ""
+}
+
+// rewindRequest copies req with its body opened again
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	rewound := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		rewound.Body = body
+	}
+	return rewound, nil
+}
+
+// retryableResponse checks if the request failed with a transient error, a network failure, a 429 Too Many Requests
+// or a server error, after which sending it again can succeed
+func retryableResponse(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return retryableNetworkError(err)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// retryableNetworkError checks if err is a timeout or a connection that was closed while the request was sent
+func retryableNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var temporaryErr interface{ Temporary() bool }
+	if errors.As(err, &temporaryErr) && temporaryErr.Temporary() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) || errors.Is(err, net.ErrClosed)
+}
+
+// retryAfterDelay returns the wait asked for by the Retry-After header of a 429 Too Many Requests or a 503 Service
+// Unavailable response, either in seconds or as a date
+func retryAfterDelay(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	retryAfter := resp.Header.Get("Retry-After")
+	if retryAfter == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// acknowledgedOffset returns the offset after the last byte of the blob the registry has, from the Range header of
+// the response to an upload. Registries send 0-0 when they do not have any byte yet
+func acknowledgedOffset(resp *http.Response) (int64, bool) {
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Range"), "%d-%d", &start, &end); err != nil || start != 0 {
+		return 0, false
+	}
+	if end == 0 {
+		return 0, true
+	}
+	return end + 1, true
+}
+
+// restartableUploadError checks if the registry lost the upload of a blob, or does not have the offset it was resumed
+// from, in which case the upload is started again
+func restartableUploadError(err error) bool {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+	if transportErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return true
+	}
+	for _, diagnostic := range transportErr.Errors {
+		if diagnostic.Code == transport.BlobUploadInvalidErrorCode || diagnostic.Code == transport.BlobUploadUnknownErrorCode {
+			return true
+		}
+	}
+	return false
+}
+
+func drainAndClose(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,373 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	regregistry "github.com/google/go-containerregistry/pkg/registry"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryRoundTripper(t *testing.T) {
+	t.Run("when the upload of a chunk is interrupted, it resumes the upload from the last acknowledged chunk", func(t *testing.T) {
+		var contentRanges []string
+		interrupted := false
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPatch {
+				return false
+			}
+			contentRanges = append(contentRanges, r.Header.Get("Content-Range"))
+			if interrupted || !strings.HasPrefix(r.Header.Get("Content-Range"), "1024-") {
+				return false
+			}
+
+			interrupted = true
+			_, err := io.CopyN(io.Discard, r.Body, 100)
+			require.NoError(t, err)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+			return true
+		})
+		defer server.Close()
+
+		blob := randomBytes(t, 3000)
+		img := imageWithBlob(t, blob)
+		ref := writeImage(t, server, img)
+
+		// the config blob is uploaded at the same time, in a single chunk
+		config, err := img.RawConfigFile()
+		require.NoError(t, err)
+		var layerContentRanges []string
+		for _, contentRange := range contentRanges {
+			if contentRange != fmt.Sprintf("0-%d", len(config)-1) {
+				layerContentRanges = append(layerContentRanges, contentRange)
+			}
+		}
+
+		assert.True(t, interrupted)
+		assert.Equal(t, []string{"0-1023", "1024-2047", "1024-2047", "2048-2999"}, layerContentRanges)
+		assertImageHasBlob(t, ref, blob)
+	})
+
+	t.Run("when the registry does not accept chunks, it uploads the blob in a single request", func(t *testing.T) {
+		var contentRanges []string
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPatch {
+				return false
+			}
+			contentRanges = append(contentRanges, r.Header.Get("Content-Range"))
+			if r.Header.Get("Content-Range") == "" {
+				return false
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return true
+		})
+		defer server.Close()
+
+		blob := randomBytes(t, 3000)
+		ref := writeImage(t, server, imageWithBlob(t, blob))
+
+		assert.Contains(t, contentRanges, "")
+		assert.NotContains(t, contentRanges, "1024-2047")
+		assertImageHasBlob(t, ref, blob)
+	})
+
+	t.Run("when the registry fails with a server error, it retries the upload of the manifest", func(t *testing.T) {
+		manifestPuts := 0
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+				return false
+			}
+			manifestPuts++
+			if manifestPuts > 2 {
+				return false
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		})
+		defer server.Close()
+
+		writeImage(t, server, imageWithBlob(t, randomBytes(t, 100)))
+		assert.Equal(t, 3, manifestPuts)
+	})
+
+	t.Run("when a request is retried, it reports the retry and why the request failed", func(t *testing.T) {
+		manifestPuts := 0
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+				return false
+			}
+			manifestPuts++
+			if manifestPuts > 2 {
+				return false
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		})
+		defer server.Close()
+
+		var retries []string
+		reg, err := registry.NewSimpleRegistry(registry.Opts{
+			RetryCount:   3,
+			RetryBackoff: time.Millisecond,
+			OnRetry: func(host string, retry int, reason string) {
+				retries = append(retries, fmt.Sprintf("%s %d %s", host, retry, reason))
+			},
+		})
+		require.NoError(t, err)
+		ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/repo:tag")
+		require.NoError(t, err)
+
+		require.NoError(t, reg.WriteImage(ref, imageWithBlob(t, randomBytes(t, 100)), nil))
+		host := strings.TrimPrefix(server.URL, "http://")
+		assert.Equal(t, []string{host + " 1 503 Service Unavailable", host + " 2 503 Service Unavailable"}, retries)
+	})
+
+	t.Run("when the registry rate limits the requests, it waits for as long as Retry-After asks for", func(t *testing.T) {
+		var manifestPutTimes []time.Time
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+				return false
+			}
+			manifestPutTimes = append(manifestPutTimes, time.Now())
+			if len(manifestPutTimes) > 1 {
+				return false
+			}
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return true
+		})
+		defer server.Close()
+
+		writeImage(t, server, imageWithBlob(t, randomBytes(t, 100)))
+		require.Len(t, manifestPutTimes, 2)
+		assert.GreaterOrEqual(t, manifestPutTimes[1].Sub(manifestPutTimes[0]), time.Second)
+	})
+
+	t.Run("when the registry rate limits the requests without Retry-After, it waits for RateLimit-Reset and reports it", func(t *testing.T) {
+		var manifestPutTimes []time.Time
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+				return false
+			}
+			manifestPutTimes = append(manifestPutTimes, time.Now())
+			if len(manifestPutTimes) > 1 {
+				return false
+			}
+			w.Header().Set("RateLimit-Limit", "100;w=60")
+			w.Header().Set("RateLimit-Remaining", "0")
+			w.Header().Set("RateLimit-Reset", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return true
+		})
+		defer server.Close()
+
+		var rateLimitedHosts []string
+		var rateLimitedWaits []time.Duration
+		reg, err := registry.NewSimpleRegistry(registry.Opts{
+			RetryCount:   3,
+			RetryBackoff: time.Millisecond,
+			OnRateLimited: func(host string, wait time.Duration) {
+				rateLimitedHosts = append(rateLimitedHosts, host)
+				rateLimitedWaits = append(rateLimitedWaits, wait)
+			},
+		})
+		require.NoError(t, err)
+		ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/repo:tag")
+		require.NoError(t, err)
+
+		require.NoError(t, reg.WriteImage(ref, imageWithBlob(t, randomBytes(t, 100)), nil))
+		require.Len(t, manifestPutTimes, 2)
+		assert.GreaterOrEqual(t, manifestPutTimes[1].Sub(manifestPutTimes[0]), time.Second)
+		assert.Equal(t, []string{strings.TrimPrefix(server.URL, "http://")}, rateLimitedHosts)
+		assert.Equal(t, []time.Duration{time.Second}, rateLimitedWaits)
+	})
+
+	t.Run("when the registry asks for a wait longer than RateLimitMaxWait, it only waits for RateLimitMaxWait", func(t *testing.T) {
+		var manifestPutTimes []time.Time
+		server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+				return false
+			}
+			manifestPutTimes = append(manifestPutTimes, time.Now())
+			if len(manifestPutTimes) > 1 {
+				return false
+			}
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return true
+		})
+		defer server.Close()
+
+		reg, err := registry.NewSimpleRegistry(registry.Opts{RetryCount: 3, RetryBackoff: time.Millisecond, RateLimitMaxWait: 500 * time.Millisecond})
+		require.NoError(t, err)
+		ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/repo:tag")
+		require.NoError(t, err)
+
+		require.NoError(t, reg.WriteImage(ref, imageWithBlob(t, randomBytes(t, 100)), nil))
+		require.Len(t, manifestPutTimes, 2)
+		assert.GreaterOrEqual(t, manifestPutTimes[1].Sub(manifestPutTimes[0]), 500*time.Millisecond)
+		assert.Less(t, manifestPutTimes[1].Sub(manifestPutTimes[0]), 10*time.Second)
+	})
+
+	t.Run("when the registry rate limits the requests, it sends them one at a time until one succeeds", func(t *testing.T) {
+		lock := &sync.Mutex{}
+		statusCode, inFlight, maxInFlight := http.StatusTooManyRequests, 0, 0
+		retryRoundTripper := registry.NewRetryRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			respStatusCode := statusCode
+			lock.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+			return &http.Response{StatusCode: respStatusCode, Header: http.Header{"Retry-After": []string{"0"}}, Body: http.NoBody, Request: req}, nil
+		}), registry.RetryOpts{Tries: 1})
+
+		sendConcurrently := func() int {
+			lock.Lock()
+			maxInFlight = 0
+			lock.Unlock()
+
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req, err := http.NewRequest(http.MethodGet, "http://registry.local/v2/repo/manifests/tag", nil)
+					require.NoError(t, err)
+					resp, err := retryRoundTripper.RoundTrip(req)
+					require.NoError(t, err)
+					resp.Body.Close()
+				}()
+			}
+			wg.Wait()
+
+			lock.Lock()
+			defer lock.Unlock()
+			return maxInFlight
+		}
+
+		assert.Greater(t, sendConcurrently(), 1)
+		assert.Equal(t, 1, sendConcurrently())
+
+		lock.Lock()
+		statusCode = http.StatusOK
+		lock.Unlock()
+		// the request ending the throttling is still sent alone
+		sendConcurrently()
+		assert.Greater(t, sendConcurrently(), 1)
+	})
+
+	for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		t.Run(fmt.Sprintf("when the registry fails with %d, it does not retry", statusCode), func(t *testing.T) {
+			manifestPuts := 0
+			server := createRetryServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/manifests/") {
+					return false
+				}
+				manifestPuts++
+				w.WriteHeader(statusCode)
+				return true
+			})
+			defer server.Close()
+
+			reg, err := registry.NewSimpleRegistry(registry.Opts{RetryCount: 3, RetryBackoff: time.Millisecond})
+			require.NoError(t, err)
+			ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/repo:tag")
+			require.NoError(t, err)
+
+			err = reg.WriteImage(ref, imageWithBlob(t, randomBytes(t, 100)), nil)
+			require.Error(t, err)
+			assert.Equal(t, 1, manifestPuts)
+		})
+	}
+}
+
+// createRetryServer creates a registry whose requests are handled by handler first, the registry handling those for
+// which handler returns false
+func createRetryServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	reg := regregistry.New(regregistry.Logger(log.New(io.Discard, "", 0)))
+	lock := &sync.Mutex{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		handled := handler(w, r)
+		lock.Unlock()
+		if !handled {
+			reg.ServeHTTP(w, r)
+		}
+	}))
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func writeImage(t *testing.T, server *httptest.Server, img regv1.Image) name.Reference {
+	reg, err := registry.NewSimpleRegistry(registry.Opts{RetryCount: 3, RetryBackoff: time.Millisecond, UploadChunkSize: 1024})
+	require.NoError(t, err)
+	ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+
+	require.NoError(t, reg.WriteImage(ref, img, nil))
+	return ref
+}
+
+func imageWithBlob(t *testing.T, blob []byte) regv1.Image {
+	img, err := mutate.AppendLayers(empty.Image, static.NewLayer(blob, types.DockerLayer))
+	require.NoError(t, err)
+	return img
+}
+
+func assertImageHasBlob(t *testing.T, ref name.Reference, blob []byte) {
+	reg, err := registry.NewSimpleRegistry(registry.Opts{})
+	require.NoError(t, err)
+	img, err := reg.Image(ref)
+	require.NoError(t, err)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+
+	compressed, err := layers[0].Compressed()
+	require.NoError(t, err)
+	defer compressed.Close()
+	uploadedBlob, err := io.ReadAll(compressed)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(blob, uploadedBlob), "Expected the uploaded blob to be the same as the one pushed")
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	return content
+}
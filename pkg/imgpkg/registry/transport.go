@@ -42,6 +42,8 @@ type MultiRoundTripperStorage struct {
 	baseRoundTripper http.RoundTripper
 	transports       map[string]map[string]map[string]http.RoundTripper
 	readWriteAccess  *sync.Mutex
+	// ctx stops the requests made to create the RoundTripper
+	ctx context.Context
 }
 
 // BaseRoundTripper retrieves the base RoundTripper used by the store
@@ -89,7 +91,7 @@ func (r *MultiRoundTripperStorage) CreateRoundTripper(reg regname.Registry, auth
 	r.readWriteAccess.Lock()
 	defer r.readWriteAccess.Unlock()
 
-	rt, err := transport.NewWithContext(context.Background(), reg, auth, r.baseRoundTripper, []string{scope})
+	rt, err := transport.NewWithContext(contextOrBackground(r.ctx), reg, auth, r.baseRoundTripper, []string{scope})
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create round tripper: %s", err)
 	}
@@ -119,6 +121,8 @@ type SingleTripperStorage struct {
 	baseRoundTripper http.RoundTripper
 	transport        http.RoundTripper
 	readWriteAccess  *sync.Mutex
+	// ctx stops the requests made to create the RoundTripper
+	ctx context.Context
 }
 
 // RoundTripper Retrieve the RoundTripper to be used for a particular registry and repository or nil if it cannot be found
@@ -142,7 +146,7 @@ func (r *SingleTripperStorage) CreateRoundTripper(reg regname.Registry, auth aut
 	r.readWriteAccess.Lock()
 	defer r.readWriteAccess.Unlock()
 
-	rt, err := transport.NewWithContext(context.Background(), reg, auth, r.baseRoundTripper, []string{scope})
+	rt, err := transport.NewWithContext(contextOrBackground(r.ctx), reg, auth, r.baseRoundTripper, []string{scope})
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create round tripper: %s", err)
 	}
@@ -152,6 +156,13 @@ func (r *SingleTripperStorage) CreateRoundTripper(reg regname.Registry, auth aut
 	return rt, nil
 }
 
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
 // NoopRoundTripperStorage does not store any http.RoundTripper
 type NoopRoundTripperStorage struct{}
 
@@ -58,6 +58,11 @@ func (w *WithProgress) MultiWrite(imageOrIndexesToUpload map[regname.Reference]r
 	return w.delegate.MultiWrite(imageOrIndexesToUpload, concurrency, uploadProgress)
 }
 
+// Referrers Retrieve the index of the manifests whose subject is digest
+func (w *WithProgress) Referrers(digest regname.Digest) (regv1.ImageIndex, error) {
+	return w.delegate.Referrers(digest)
+}
+
 // WriteImage Upload Image to registry
 func (w *WithProgress) WriteImage(reference regname.Reference, image regv1.Image, _ chan regv1.Update) error {
 	uploadProgress := make(chan regv1.Update)
@@ -67,6 +72,11 @@ func (w *WithProgress) WriteImage(reference regname.Reference, image regv1.Image
 	return w.delegate.WriteImage(reference, image, uploadProgress)
 }
 
+// WriteLayer Upload the blob of a layer to the repository
+func (w *WithProgress) WriteLayer(repo regname.Repository, layer regv1.Layer) error {
+	return w.delegate.WriteLayer(repo, layer)
+}
+
 // WriteIndex Uploads the Index manifest to the registry
 func (w *WithProgress) WriteIndex(reference regname.Reference, index regv1.ImageIndex) error {
 	return w.delegate.WriteIndex(reference, index)
@@ -77,6 +87,11 @@ func (w *WithProgress) WriteTag(tag regname.Tag, taggable remote.Taggable) error
 	return w.delegate.WriteTag(tag, taggable)
 }
 
+// CheckPushPermission Check that the repository can be pushed to, before uploading blobs
+func (w *WithProgress) CheckPushPermission(repo regname.Repository) error {
+	return w.delegate.CheckPushPermission(repo)
+}
+
 // ListTags Retrieve all tags associated with a Repository
 func (w *WithProgress) ListTags(repo regname.Repository) ([]string, error) {
 	return w.delegate.ListTags(repo)
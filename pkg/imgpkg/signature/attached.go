@@ -0,0 +1,236 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package signature
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"golang.org/x/sync/errgroup"
+)
+
+// AttachedKind kind of an artifact attached to an image
+type AttachedKind string
+
+const (
+	// SignatureAttachedKind cosign and notation signatures, and sigstore bundles
+	SignatureAttachedKind AttachedKind = "signature"
+	// SBOMAttachedKind SPDX, CycloneDX and syft SBOMs
+	SBOMAttachedKind AttachedKind = "sbom"
+	// AttestationAttachedKind in-toto attestations, which are how SBOMs are usually attached by cosign
+	AttestationAttachedKind AttachedKind = "attestation"
+	// ReferrerAttachedKind referrers of any other artifact type
+	ReferrerAttachedKind AttachedKind = "referrer"
+)
+
+// cosignTagSuffixes suffixes of the tags cosign attaches artifacts to an image with, sha256-<hex>.<suffix>
+var cosignTagSuffixes = []struct {
+	suffix string
+	kind   AttachedKind
+}{
+	{".sig", SignatureAttachedKind},
+	{".att", AttestationAttachedKind},
+	{".sbom", SBOMAttachedKind},
+}
+
+// AttachedScope kinds of the attached artifacts that are found
+type AttachedScope struct {
+	Signatures bool
+	// SBOMs SBOMs and attestations
+	SBOMs bool
+	// Others referrers of any other artifact type
+	Others bool
+}
+
+// Any returns whether any kind of attached artifact is found
+func (s AttachedScope) Any() bool {
+	return s.Signatures || s.SBOMs || s.Others
+}
+
+func (s AttachedScope) includes(kind AttachedKind) bool {
+	switch kind {
+	case SignatureAttachedKind:
+		return s.Signatures
+	case SBOMAttachedKind, AttestationAttachedKind:
+		return s.SBOMs
+	default:
+		return s.Others
+	}
+}
+
+// AttachedReader Interface that knows how to find the artifacts attached to an image in a registry
+type AttachedReader interface {
+	DigestReader
+	Referrers(digest regname.Digest) (regv1.ImageIndex, error)
+}
+
+// Attached retriever of the artifacts attached to images, with the referrers API, or the referrers tag schema when
+// the registry does not have it, and with the tags of cosign
+type Attached struct {
+	registry    AttachedReader
+	scope       AttachedScope
+	concurrency int
+}
+
+// NewAttached constructs the retriever of the artifacts of scope attached to images
+func NewAttached(reg AttachedReader, scope AttachedScope, concurrency int) *Attached {
+	return &Attached{registry: reg, scope: scope, concurrency: concurrency}
+}
+
+// Fetch Retrieve the artifacts attached to the images provided, and to the artifacts attached to them, labeled with
+// their kind and the digest of the image they are attached to. The artifacts the registry denies access to are left out
+func (a *Attached) Fetch(images *imageset.UnprocessedImageRefs) (*imageset.UnprocessedImageRefs, error) {
+	attached := imageset.NewUnprocessedImageRefs()
+	seen := map[string]bool{}
+	pending := images.All()
+	for _, ref := range pending {
+		seen[ref.DigestRef] = true
+	}
+
+	// signatures of SBOMs are attached to the SBOMs, and not to the images
+	for len(pending) > 0 {
+		found, err := a.fetchAll(pending)
+		if err != nil {
+			return nil, err
+		}
+
+		pending = nil
+		for _, ref := range found {
+			if seen[ref.DigestRef] {
+				continue
+			}
+			seen[ref.DigestRef] = true
+			attached.Add(ref)
+			pending = append(pending, ref)
+		}
+	}
+	return attached, nil
+}
+
+// fetchAll retrieves the artifacts attached to each of images
+func (a *Attached) fetchAll(images []imageset.UnprocessedImageRef) ([]imageset.UnprocessedImageRef, error) {
+	lock := &sync.Mutex{}
+	var attached []imageset.UnprocessedImageRef
+
+	throttle := util.NewThrottle(a.concurrency)
+	var wg errgroup.Group
+
+	for _, ref := range images {
+		ref := ref //copy
+		wg.Go(func() error {
+			subject, err := regname.NewDigest(ref.DigestRef)
+			if err != nil {
+				return fmt.Errorf("Parsing '%s': %s", ref.DigestRef, err)
+			}
+
+			throttle.Take()
+			defer throttle.Done()
+
+			found, err := a.attachedTo(subject)
+			if err != nil {
+				var deniedErr AccessDeniedErr
+				if errors.As(err, &deniedErr) {
+					return nil
+				}
+				return fmt.Errorf("Fetching the artifacts attached to image '%s': %s", subject.Name(), err)
+			}
+
+			lock.Lock()
+			attached = append(attached, found...)
+			lock.Unlock()
+			return nil
+		})
+	}
+
+	err := wg.Wait()
+	return attached, err
+}
+
+// attachedTo retrieves the artifacts attached to subject, the ones found with a cosign tag keep it
+func (a *Attached) attachedTo(subject regname.Digest) ([]imageset.UnprocessedImageRef, error) {
+	var attached []imageset.UnprocessedImageRef
+	foundDigests := map[string]bool{}
+	add := func(digest string, tag string, kind AttachedKind) {
+		if foundDigests[digest] {
+			return
+		}
+		foundDigests[digest] = true
+		attached = append(attached, imageset.UnprocessedImageRef{
+			DigestRef: subject.Context().Digest(digest).Name(),
+			Tag:       tag,
+			Labels: map[string]string{
+				imageset.AttachedKindLabelKey: string(kind),
+				imageset.AttachedToLabelKey:   subject.DigestStr(),
+			},
+		})
+	}
+
+	for _, cosignTag := range cosignTagSuffixes {
+		if !a.scope.includes(cosignTag.kind) {
+			continue
+		}
+		tag := subject.Context().Tag(strings.Replace(subject.DigestStr(), ":", "-", 1) + cosignTag.suffix)
+		digest, err := a.registry.Digest(tag)
+		if err != nil {
+			if isStatusCode(err, http.StatusNotFound) {
+				continue
+			}
+			if isStatusCode(err, http.StatusForbidden) {
+				return nil, AccessDeniedErr{imageRef: tag.String()}
+			}
+			return nil, err
+		}
+		add(digest.String(), tag.TagStr(), cosignTag.kind)
+	}
+
+	referrers, err := a.registry.Referrers(subject)
+	if err != nil {
+		if isStatusCode(err, http.StatusForbidden) {
+			return nil, AccessDeniedErr{imageRef: subject.String()}
+		}
+		return nil, err
+	}
+	referrersManifest, err := referrers.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrersManifest.Manifests {
+		kind := attachedKind(referrer.ArtifactType)
+		if a.scope.includes(kind) {
+			add(referrer.Digest.String(), "", kind)
+		}
+	}
+	return attached, nil
+}
+
+// attachedKind returns the kind of the artifacts of artifactType, which is the media type of the config of the
+// artifact when it does not have one
+func attachedKind(artifactType string) AttachedKind {
+	switch {
+	case strings.Contains(artifactType, "spdx"), strings.Contains(artifactType, "cyclonedx"),
+		strings.Contains(artifactType, "syft"), strings.Contains(artifactType, "cosign.artifact.sbom"):
+		return SBOMAttachedKind
+	case strings.Contains(artifactType, "in-toto"), strings.Contains(artifactType, "dsse.envelope"),
+		strings.Contains(artifactType, "cosign.artifact.att"):
+		return AttestationAttachedKind
+	case strings.Contains(artifactType, "notary.signature"), strings.Contains(artifactType, "sigstore.bundle"),
+		strings.Contains(artifactType, "cosign.artifact.sig"), strings.Contains(artifactType, "cosign.simplesigning"):
+		return SignatureAttachedKind
+	default:
+		return ReferrerAttachedKind
+	}
+}
+
+func isStatusCode(err error, statusCode int) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == statusCode
+}
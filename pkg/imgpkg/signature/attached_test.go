@@ -0,0 +1,97 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package signature_test
+
+import (
+	"strings"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
+	"carvel.dev/imgpkg/test/helpers"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttached_Fetch(t *testing.T) {
+	logger := &helpers.Logger{}
+	regBuilder := helpers.NewFakeRegistry(t, logger)
+	img := regBuilder.WithRandomImage("some-image")
+	reg := regBuilder.Build()
+	defer regBuilder.CleanUp()
+
+	imgDigest, err := name.NewDigest(img.RefDigest)
+	require.NoError(t, err)
+
+	writeTagged := func(t *testing.T, subject name.Digest, suffix string) string {
+		attachedImg, err := random.Image(100, 1)
+		require.NoError(t, err)
+		tag := subject.Context().Tag(strings.Replace(subject.DigestStr(), ":", "-", 1) + suffix)
+		require.NoError(t, remote.Write(tag, attachedImg))
+		digest, err := attachedImg.Digest()
+		require.NoError(t, err)
+		return subject.Context().Digest(digest.String()).Name()
+	}
+	writeReferrer := func(t *testing.T, subject name.Digest, artifactType types.MediaType) string {
+		subjectDesc, err := remote.Head(subject)
+		require.NoError(t, err)
+		referrerImg, err := random.Image(100, 1)
+		require.NoError(t, err)
+		referrerImg = mutate.ConfigMediaType(mutate.MediaType(referrerImg, types.OCIManifestSchema1), artifactType)
+		referrerImg = mutate.Subject(referrerImg, *subjectDesc).(v1.Image)
+		digest, err := partial.Digest(referrerImg)
+		require.NoError(t, err)
+		ref := subject.Context().Digest(digest.String())
+		require.NoError(t, remote.Write(ref, referrerImg))
+		return ref.Name()
+	}
+	attachedRef := func(digestRef, tag string, kind signature.AttachedKind, subject name.Digest) imageset.UnprocessedImageRef {
+		return imageset.UnprocessedImageRef{DigestRef: digestRef, Tag: tag, Labels: map[string]string{
+			imageset.AttachedKindLabelKey: string(kind),
+			imageset.AttachedToLabelKey:   subject.DigestStr(),
+		}}
+	}
+
+	sigRef := writeTagged(t, imgDigest, ".sig")
+	attRef := writeTagged(t, imgDigest, ".att")
+	sbomRef := writeReferrer(t, imgDigest, "application/spdx+json")
+	sbomDigest, err := name.NewDigest(sbomRef)
+	require.NoError(t, err)
+	sbomSigRef := writeTagged(t, sbomDigest, ".sig")
+	otherRef := writeReferrer(t, imgDigest, "application/vnd.example.report+json")
+
+	images := imageset.NewUnprocessedImageRefs()
+	images.Add(imageset.UnprocessedImageRef{DigestRef: img.RefDigest})
+
+	t.Run("it finds the artifacts attached with cosign tags and as referrers, and the ones attached to them", func(t *testing.T) {
+		subject := signature.NewAttached(reg, signature.AttachedScope{Signatures: true, SBOMs: true, Others: true}, 2)
+		attached, err := subject.Fetch(images)
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []imageset.UnprocessedImageRef{
+			attachedRef(sigRef, strings.Replace(imgDigest.DigestStr(), ":", "-", 1)+".sig", signature.SignatureAttachedKind, imgDigest),
+			attachedRef(attRef, strings.Replace(imgDigest.DigestStr(), ":", "-", 1)+".att", signature.AttestationAttachedKind, imgDigest),
+			attachedRef(sbomRef, "", signature.SBOMAttachedKind, imgDigest),
+			attachedRef(otherRef, "", signature.ReferrerAttachedKind, imgDigest),
+			attachedRef(sbomSigRef, strings.Replace(sbomDigest.DigestStr(), ":", "-", 1)+".sig", signature.SignatureAttachedKind, sbomDigest),
+		}, attached.All())
+	})
+
+	t.Run("it only finds the kinds of artifacts of the scope", func(t *testing.T) {
+		subject := signature.NewAttached(reg, signature.AttachedScope{Signatures: true}, 2)
+		attached, err := subject.Fetch(images)
+		require.NoError(t, err)
+
+		assert.Equal(t, []imageset.UnprocessedImageRef{
+			attachedRef(sigRef, strings.Replace(imgDigest.DigestStr(), ":", "-", 1)+".sig", signature.SignatureAttachedKind, imgDigest),
+		}, attached.All())
+	})
+}
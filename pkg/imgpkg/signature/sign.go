@@ -0,0 +1,134 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package signature
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/signature/cosign"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// SimpleSigningMediaType media type of the layers of the cosign signatures, whose content is the payload signed
+	SimpleSigningMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation annotation of the layers of the cosign signatures, with the base64 of the signature
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// simpleSigningType type of the payloads of the cosign signatures
+	simpleSigningType = "cosign container image signature"
+)
+
+// SignatureRegistry registry the signatures are read from and written to
+type SignatureRegistry interface {
+	Image(reference regname.Reference) (regv1.Image, error)
+	WriteImage(reference regname.Reference, img regv1.Image, updates chan regv1.Update) error
+}
+
+// Signer signs images with cosign compatible signatures, which cosign verify checks with the public key of key
+type Signer struct {
+	key      crypto.Signer
+	registry SignatureRegistry
+}
+
+// NewSigner constructor for Signer
+func NewSigner(key crypto.Signer, reg SignatureRegistry) *Signer {
+	return &Signer{key: key, registry: reg}
+}
+
+// Sign signs the image imageRef, writing the signature image to the tag cosign reads the signatures of the image from,
+// sha256-<digest>.sig, in the repository of the image. The signatures the image already has are kept
+func (s *Signer) Sign(imageRef regname.Digest) (regname.Tag, error) {
+	digest, err := regv1.NewHash(imageRef.DigestStr())
+	if err != nil {
+		return regname.Tag{}, fmt.Errorf("Converting to hash: %s", err)
+	}
+	sigTag := imageRef.Context().Tag(cosign.Munge(regv1.Descriptor{Digest: digest}))
+
+	payload, err := newSimpleSigningPayload(imageRef)
+	if err != nil {
+		return regname.Tag{}, err
+	}
+	sig, err := s.sign(payload)
+	if err != nil {
+		return regname.Tag{}, fmt.Errorf("Signing image '%s': %s", imageRef.Name(), err)
+	}
+
+	sigImg, err := s.signatures(sigTag)
+	if err != nil {
+		return regname.Tag{}, err
+	}
+	sigImg, err = mutate.Append(sigImg, mutate.Addendum{
+		Layer:       static.NewLayer(payload, SimpleSigningMediaType),
+		Annotations: map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		return regname.Tag{}, err
+	}
+
+	err = s.registry.WriteImage(sigTag, sigImg, nil)
+	if err != nil {
+		return regname.Tag{}, fmt.Errorf("Writing signature '%s': %s", sigTag.Name(), err)
+	}
+	return sigTag, nil
+}
+
+// signatures returns the image of the signatures the image already has at sigTag, an empty one when it has none
+func (s *Signer) signatures(sigTag regname.Tag) (regv1.Image, error) {
+	img, err := s.registry.Image(sigTag)
+	if err == nil {
+		return img, nil
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+		return mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.OCIConfigJSON), nil
+	}
+	return nil, fmt.Errorf("Reading signatures '%s': %s", sigTag.Name(), err)
+}
+
+// sign signs payload, hashed with sha256 unless the key is an Ed25519 key, which signs the payload itself
+func (s *Signer) sign(payload []byte) ([]byte, error) {
+	if _, ok := s.key.Public().(ed25519.PublicKey); ok {
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	hash := sha256.Sum256(payload)
+	return s.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+// simpleSigningPayload payload of the cosign signatures, in the simple signing format
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// newSimpleSigningPayload returns the payload signed for imageRef
+func newSimpleSigningPayload(imageRef regname.Digest) ([]byte, error) {
+	var payload simpleSigningPayload
+	payload.Critical.Identity.DockerReference = imageRef.Context().Name()
+	payload.Critical.Image.DockerManifestDigest = imageRef.DigestStr()
+	payload.Critical.Type = simpleSigningType
+	return json.Marshal(payload)
+}
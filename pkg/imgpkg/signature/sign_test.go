@@ -0,0 +1,177 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package signature_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/signature"
+	"carvel.dev/imgpkg/test/helpers"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("it writes a cosign signature of the image to its .sig tag", func(t *testing.T) {
+		regBuilder := helpers.NewFakeRegistry(t, &helpers.Logger{})
+		img := regBuilder.WithRandomImage("some-image")
+		reg := regBuilder.Build()
+		defer regBuilder.CleanUp()
+
+		imgDigest, err := name.NewDigest(img.RefDigest)
+		require.NoError(t, err)
+		sigTag, err := signature.NewSigner(key, reg).Sign(imgDigest)
+		require.NoError(t, err)
+		assert.Equal(t, imgDigest.Context().Name()+":"+strings.Replace(imgDigest.DigestStr(), ":", "-", 1)+".sig", sigTag.Name())
+
+		sigImg, err := reg.Image(sigTag)
+		require.NoError(t, err)
+		signatures := imageSignatures(t, sigImg)
+		require.Len(t, signatures, 1)
+
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(signatures[0].payload, &payload))
+		assert.Equal(t, map[string]interface{}{
+			"critical": map[string]interface{}{
+				"identity": map[string]interface{}{"docker-reference": imgDigest.Context().Name()},
+				"image":    map[string]interface{}{"docker-manifest-digest": imgDigest.DigestStr()},
+				"type":     "cosign container image signature",
+			},
+			"optional": nil,
+		}, payload)
+
+		hash := sha256.Sum256(signatures[0].payload)
+		assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, hash[:], signatures[0].signature), "Expected signature to be verified with the public key")
+	})
+
+	t.Run("it keeps the signatures the image already has", func(t *testing.T) {
+		regBuilder := helpers.NewFakeRegistry(t, &helpers.Logger{})
+		img := regBuilder.WithRandomImage("some-image")
+		reg := regBuilder.Build()
+		defer regBuilder.CleanUp()
+
+		_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		imgDigest, err := name.NewDigest(img.RefDigest)
+		require.NoError(t, err)
+		_, err = signature.NewSigner(key, reg).Sign(imgDigest)
+		require.NoError(t, err)
+		sigTag, err := signature.NewSigner(otherKey, reg).Sign(imgDigest)
+		require.NoError(t, err)
+
+		sigImg, err := reg.Image(sigTag)
+		require.NoError(t, err)
+		signatures := imageSignatures(t, sigImg)
+		require.Len(t, signatures, 2)
+
+		hash := sha256.Sum256(signatures[0].payload)
+		assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, hash[:], signatures[0].signature))
+		assert.True(t, ed25519.Verify(otherKey.Public().(ed25519.PublicKey), signatures[1].payload, signatures[1].signature))
+	})
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyDir := t.TempDir()
+
+	noPassphrase := func() ([]byte, error) { return nil, fmt.Errorf("Expected passphrase to not be asked for") }
+	passphrase := func(pass string) signature.KeyPassphrase {
+		return func() ([]byte, error) { return []byte(pass), nil }
+	}
+
+	t.Run("it loads a key encrypted by cosign generate-key-pair with its passphrase", func(t *testing.T) {
+		keys, err := cosign.GenerateKeyPair(func(bool) ([]byte, error) { return []byte("secret"), nil })
+		require.NoError(t, err)
+		publicKey, err := cosign.PemToECDSAKey(keys.PublicBytes)
+		require.NoError(t, err)
+		keyPath := filepath.Join(keyDir, "cosign.key")
+		require.NoError(t, os.WriteFile(keyPath, keys.PrivateBytes, 0600))
+
+		signer, err := signature.LoadSigningKey(keyPath, passphrase("secret"))
+		require.NoError(t, err)
+		assert.True(t, publicKey.Equal(signer.Public()))
+
+		_, err = signature.LoadSigningKey(keyPath, passphrase("not-secret"))
+		require.ErrorContains(t, err, "hint: Check that the passphrase is the one of the key")
+	})
+
+	t.Run("it loads a key that is not encrypted from an environment variable", func(t *testing.T) {
+		t.Setenv("IMGPKG_TEST_SIGN_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+
+		signer, err := signature.LoadSigningKey("env://IMGPKG_TEST_SIGN_KEY", noPassphrase)
+		require.NoError(t, err)
+		assert.True(t, key.Equal(signer))
+
+		_, err = signature.LoadSigningKey("env://IMGPKG_TEST_MISSING_SIGN_KEY", noPassphrase)
+		require.EqualError(t, err, "Expected environment variable 'IMGPKG_TEST_MISSING_SIGN_KEY' to have the key")
+	})
+
+	t.Run("it fails when the key is in a KMS", func(t *testing.T) {
+		_, err := signature.LoadSigningKey("awskms:///alias/my-key", noPassphrase)
+		require.EqualError(t, err, "Expected key 'awskms:///alias/my-key' to be a file or env://<variable>, keys of a KMS are not supported (hint: Sign the image pushed with cosign sign)")
+	})
+
+	t.Run("it fails when the key is a public key", func(t *testing.T) {
+		publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		keyPath := filepath.Join(keyDir, "cosign.pub")
+		require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600))
+
+		_, err = signature.LoadSigningKey(keyPath, noPassphrase)
+		require.EqualError(t, err, fmt.Sprintf("Expected key '%s' to be a private key, but it is a 'PUBLIC KEY'", keyPath))
+	})
+}
+
+type imageSignature struct {
+	payload   []byte
+	signature []byte
+}
+
+// imageSignatures returns the payloads and signatures of the layers of the cosign signature image sigImg
+func imageSignatures(t *testing.T, sigImg v1.Image) []imageSignature {
+	manifest, err := sigImg.Manifest()
+	require.NoError(t, err)
+	layers, err := sigImg.Layers()
+	require.NoError(t, err)
+
+	var signatures []imageSignature
+	for idx, layer := range layers {
+		assert.Equal(t, signature.SimpleSigningMediaType, manifest.Layers[idx].MediaType)
+
+		reader, err := layer.Compressed()
+		require.NoError(t, err)
+		payload, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+
+		sig, err := base64.StdEncoding.DecodeString(manifest.Layers[idx].Annotations[signature.SignatureAnnotation])
+		require.NoError(t, err)
+		signatures = append(signatures, imageSignature{payload: payload, signature: sig})
+	}
+	return signatures
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package signature
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+)
+
+// envKeyPrefix prefix of the keys provided in an environment variable, env://<variable>, as cosign does
+const envKeyPrefix = "env://"
+
+// KeyPassphrase returns the passphrase of an encrypted key, only called when the key is encrypted
+type KeyPassphrase func() ([]byte, error)
+
+// LoadSigningKey loads the private key of keyRef, the path of a PEM file or env://<variable> with the PEM in the
+// variable. The key is either encrypted as cosign generate-key-pair does, decrypted by cosign with the passphrase asked
+// to passphrase, or not encrypted (PKCS #8, EC or PKCS #1). Keys of a KMS (awskms://, gcpkms://, azurekms://,
+// hashivault://...) are out of scope, the images pushed can be signed with them by cosign sign
+func LoadSigningKey(keyRef string, passphrase KeyPassphrase) (crypto.Signer, error) {
+	var content []byte
+	switch {
+	case strings.HasPrefix(keyRef, envKeyPrefix):
+		variable := strings.TrimPrefix(keyRef, envKeyPrefix)
+		value, found := os.LookupEnv(variable)
+		if !found || value == "" {
+			return nil, fmt.Errorf("Expected environment variable '%s' to have the key", variable)
+		}
+		content = []byte(value)
+
+	case strings.Contains(keyRef, "://"):
+		return nil, fmt.Errorf("Expected key '%s' to be a file or env://<variable>, keys of a KMS are not supported (hint: Sign the image pushed with cosign sign)", keyRef)
+
+	default:
+		var err error
+		content, err = os.ReadFile(keyRef)
+		if err != nil {
+			return nil, fmt.Errorf("Reading key: %s", err)
+		}
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("Expected key '%s' to be PEM encoded", keyRef)
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case cosign.SigstorePrivateKeyPemType, cosign.CosignPrivateKeyPemType:
+		return loadEncryptedKey(keyRef, content, passphrase)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Expected key '%s' to be a private key, but it is a '%s'", keyRef, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Parsing key '%s': %s", keyRef, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Expected key '%s' to be an ECDSA, RSA or Ed25519 key, but it is a %T", keyRef, key)
+	}
+	return signer, nil
+}
+
+// loadEncryptedKey loads the key encrypted by cosign in content with cosign, which decrypts it with the passphrase
+func loadEncryptedKey(keyRef string, content []byte, passphrase KeyPassphrase) (crypto.Signer, error) {
+	pass, err := passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("Decrypting key '%s': %s", keyRef, err)
+	}
+	key, err := cosign.LoadPrivateKey(content, pass)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypting key '%s': %s (hint: Check that the passphrase is the one of the key)", keyRef, err)
+	}
+
+	// the signers of cosign are crypto.Signers of the key, hashing with sha256 unless it is an Ed25519 key
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Expected key '%s' to be an ECDSA, RSA or Ed25519 key, but it is a %T", keyRef, key)
+	}
+	return signer, nil
+}
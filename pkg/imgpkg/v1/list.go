@@ -0,0 +1,86 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+)
+
+// ListEntries Lists the entries that pulling the image referenced by imageRef would extract, without writing to disk.
+// The same checks as Pull are done to ensure the reference is a bundle or an image, and for bundles only the
+// bundle contents are listed, not the contents of nested bundles
+func ListEntries(imageRef string, pullOptions PullOpts, registryOpts registry.Opts) ([]ctlimg.ListedEntry, error) {
+	reg, err := registry.NewSimpleRegistry(registryOpts)
+	if err != nil {
+		return nil, err
+	}
+	return ListEntriesWithRegistry(imageRef, pullOptions, reg)
+}
+
+// ListEntriesWithRegistry Lists the entries that pulling the image referenced by imageRef would extract, without writing to disk
+func ListEntriesWithRegistry(imageRef string, pullOptions PullOpts, reg registry.Registry) ([]ctlimg.ListedEntry, error) {
+	dirImage, err := fetchDirImage(imageRef, pullOptions, reg)
+	if err != nil {
+		return nil, err
+	}
+	return dirImage.Entries()
+}
+
+// fetchDirImage fetches the image referenced by imageRef, after checking it is a bundle or an image as requested, to
+// read its contents without extracting it to disk
+func fetchDirImage(imageRef string, pullOptions PullOpts, reg registry.Registry) (*ctlimg.DirImage, error) {
+	imageRef, err := resolvePlatform(imageRef, pullOptions.Platform, pullOptions.Logger, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	isBundle, err := checkIsBundle(imageRef, pullOptions, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	extractOpts := pullOptions.ExtractOpts
+	if isBundle && pullOptions.IsBundle && len(extractOpts.IncludePaths) > 0 {
+		// the bundle metadata is always extracted
+		extractOpts.IncludePaths = append(append([]string{}, extractOpts.IncludePaths...), bundle.ImgpkgDir)
+	}
+
+	plainImg := plainimage.NewPlainImage(imageRef, reg)
+	img, err := plainImg.Fetch()
+	if err != nil {
+		if plainimage.IsNotAnImageError(err) {
+			return nil, fmt.Errorf("Unable to pull non-images, such as image indexes. (hint: provide a specific digest to the image instead)")
+		}
+		return nil, err
+	}
+
+	// nothing is written, the root of the filesystem is only used to resolve the paths of the entries
+	return ctlimg.NewDirImageWithOpts(string(filepath.Separator), img, extractOpts, pullOptions.Logger), nil
+}
+
+// checkIsBundle checks if the image referenced by imageRef is a bundle, failing when it is not what pullOptions expects
+func checkIsBundle(imageRef string, pullOptions PullOpts, reg registry.Registry) (bool, error) {
+	imagesLockReader := bundle.NewImagesLockReader()
+	bundleToCheck := bundle.NewBundleFromRef(imageRef, reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
+	isBundle, err := bundleToCheck.IsBundle()
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case isBundle && pullOptions.AsImage: // Reading the OCI Image of a Bundle
+	case isBundle && pullOptions.IsBundle: // Reading a Bundle
+	case !isBundle && pullOptions.IsBundle: // Reading an Image as a Bundle
+		return false, &ErrIsNotBundle{}
+	case isBundle && !pullOptions.IsBundle: // Reading a Bundle as if it where an OCI Image
+		return false, &ErrIsBundle{}
+	}
+	return isBundle, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1_test
+
+import (
+	"errors"
+	"testing"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
+	v1 "carvel.dev/imgpkg/pkg/imgpkg/v1"
+	"carvel.dev/imgpkg/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListEntries(t *testing.T) {
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	img := fakeRegistry.WithRandomImage("some/image")
+	bundleInfo := fakeRegistry.WithRandomBundleAndImages("some/bundle", []lockconfig.ImageRef{{Image: img.RefDigest}})
+	defer fakeRegistry.CleanUp()
+	reg := fakeRegistry.Build()
+	uiLogger := util.NewNoopLevelLogger()
+
+	t.Run("it lists the bundle contents, including the bundle metadata when include paths are provided", func(t *testing.T) {
+		opts := v1.PullOpts{Logger: uiLogger, IsBundle: true}
+		opts.ExtractOpts.IncludePaths = []string{"random.txt"}
+
+		entries, err := v1.ListEntriesWithRegistry(bundleInfo.RefDigest, opts, reg)
+		require.NoError(t, err)
+
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		assert.Contains(t, paths, "random.txt")
+		assert.Contains(t, paths, ".imgpkg/images.yml")
+	})
+
+	t.Run("it fails when listing an image as a bundle", func(t *testing.T) {
+		_, err := v1.ListEntriesWithRegistry(img.RefDigest, v1.PullOpts{Logger: uiLogger, IsBundle: true}, reg)
+		require.True(t, errors.Is(err, &v1.ErrIsNotBundle{}))
+	})
+
+	t.Run("it fails when listing a bundle as an image", func(t *testing.T) {
+		_, err := v1.ListEntriesWithRegistry(bundleInfo.RefDigest, v1.PullOpts{Logger: uiLogger}, reg)
+		require.True(t, errors.Is(err, &v1.ErrIsBundle{}))
+	})
+}
@@ -8,9 +8,12 @@ import (
 	"path/filepath"
 
 	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
 	"carvel.dev/imgpkg/pkg/imgpkg/registry"
 	"github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 // Logger Interface used for logging
@@ -29,6 +32,20 @@ type PullOpts struct {
 	AsImage bool
 	// IsBundle the image being pulled is a Bundle
 	IsBundle bool
+	// ExtractOpts options that control how the image is written to disk
+	ExtractOpts ctlimg.DirImageOpts
+	// Platform selects the image pulled when the reference points to an image index. When not provided
+	// image indexes cannot be pulled
+	Platform *regv1.Platform
+	// LayersDir when provided, each layer of the image is also extracted on its own into a directory of LayersDir,
+	// without applying the whiteouts of newer layers. Only supported for images, the output path can be empty
+	// to only extract the layers
+	LayersDir string
+	// NestedBundlesMaxDepth limits the levels of nested bundles pulled by PullRecursive, 0 pulls all of them
+	NestedBundlesMaxDepth int
+	// ImagesLockFormat format of the ImagesLock of the bundles rewritten in .imgpkg/images.yml, when their images are
+	// in the repository of the bundle. Defaults to YAML
+	ImagesLockFormat lockconfig.LockFormat
 }
 
 // ImagesLockInfo Information about the ImagesLock file
@@ -81,6 +98,8 @@ type PullStatus struct {
 	BundleInfo
 	IsBundle  bool `json:"-"`
 	Cacheable bool `json:"cacheable"`
+	// MediaType media type of the manifest of the image, or bundle, pulled
+	MediaType string `json:"mediaType,omitempty"`
 }
 
 // Pull Download the contents of the image referenced by imageRef to the folder outputPath
@@ -94,8 +113,19 @@ func Pull(imageRef string, outputPath string, pullOptions PullOpts, registryOpts
 
 // PullWithRegistry Download the contents of the image referenced by imageRef to the folder outputPath
 func PullWithRegistry(imageRef string, outputPath string, pullOptions PullOpts, reg registry.Registry) (PullStatus, error) {
+	resolvedRef, err := resolvePlatform(imageRef, pullOptions.Platform, pullOptions.Logger, reg)
+	if err != nil {
+		return PullStatus{}, err
+	}
+
 	imagesLockReader := bundle.NewImagesLockReader()
-	bundleToPull := bundle.NewBundleFromRef(imageRef, reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
+	bundleToPull := bundle.NewBundleFromRef(resolvedRef, reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
+	return pullBundleOrImage(imageRef, bundleToPull, plainimage.NewPlainImage(resolvedRef, reg), outputPath, pullOptions)
+}
+
+// pullBundleOrImage pulls bundleToPull as a bundle, or as an image when pullOptions expect one, plainImg being the
+// image of bundleToPull
+func pullBundleOrImage(imageRef string, bundleToPull *bundle.Bundle, plainImg *plainimage.PlainImage, outputPath string, pullOptions PullOpts) (PullStatus, error) {
 	isBundle, err := bundleToPull.IsBundle()
 	if err != nil {
 		return PullStatus{}, err
@@ -103,7 +133,7 @@ func PullWithRegistry(imageRef string, outputPath string, pullOptions PullOpts,
 
 	switch {
 	case isBundle && pullOptions.AsImage: // Trying to pull the OCI Image of a Bundle
-		st, err := pullImage(imageRef, outputPath, pullOptions, reg)
+		st, err := pullImage(imageRef, plainImg, outputPath, pullOptions)
 		if err != nil {
 			return PullStatus{}, err
 		}
@@ -111,13 +141,16 @@ func PullWithRegistry(imageRef string, outputPath string, pullOptions PullOpts,
 		return st, nil
 
 	case isBundle && pullOptions.IsBundle: // Trying to pull a Bundle
+		if pullOptions.LayersDir != "" {
+			return PullStatus{}, fmt.Errorf("Extracting the layers of a bundle is not supported (hint: Pull the bundle as an image to extract its layers)")
+		}
 		return pullBundle(imageRef, bundleToPull, outputPath, pullOptions, false)
 
 	case !isBundle && pullOptions.IsBundle: // Trying to pull an Image as a Bundle
 		return PullStatus{}, &ErrIsNotBundle{}
 
 	case !isBundle && !pullOptions.IsBundle: // Trying to pull an OCI Image
-		return pullImage(imageRef, outputPath, pullOptions, reg)
+		return pullImage(imageRef, plainImg, outputPath, pullOptions)
 
 	case isBundle && !pullOptions.IsBundle: // Trying to pull a Bundle as if it where an OCI Image
 		return PullStatus{}, &ErrIsBundle{}
@@ -140,8 +173,13 @@ func PullRecursive(imageRef string, outputPath string, pullOptions PullOpts, reg
 // PullRecursiveWithRegistry Downloads the contents of the Bundle and Nested Bundles referenced by imageRef to the folder outputPath.
 // This functions should error out when imageRef does not point to a Bundle
 func PullRecursiveWithRegistry(imageRef string, outputPath string, pullOptions PullOpts, reg registry.Registry) (PullStatus, error) {
+	resolvedRef, err := resolvePlatform(imageRef, pullOptions.Platform, pullOptions.Logger, reg)
+	if err != nil {
+		return PullStatus{}, err
+	}
+
 	imagesLockReader := bundle.NewImagesLockReader()
-	bundleToPull := bundle.NewBundleFromRef(imageRef, reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
+	bundleToPull := bundle.NewBundleFromRef(resolvedRef, reg, imagesLockReader, bundle.NewRegistryFetcher(reg, imagesLockReader))
 	isBundle, err := bundleToPull.IsBundle()
 	if err != nil {
 		return PullStatus{}, err
@@ -156,7 +194,14 @@ func PullRecursiveWithRegistry(imageRef string, outputPath string, pullOptions P
 // pullBundle Downloads the contents of the Bundle Image referenced by imageRef to the folder outputPath.
 // This functions should error out when imageRef does not point to a Bundle
 func pullBundle(imgRef string, bundleToPull *bundle.Bundle, outputPath string, pullOptions PullOpts, pullNestedBundles bool) (PullStatus, error) {
-	isRootBundleRelocated, err := bundleToPull.Pull(outputPath, pullOptions.Logger, pullNestedBundles)
+	var isRootBundleRelocated bool
+	var err error
+	bundleToPull.SetImagesLockFormat(pullOptions.ImagesLockFormat)
+	if pullNestedBundles {
+		isRootBundleRelocated, err = bundleToPull.PullNestedWithOpts(outputPath, pullOptions.ExtractOpts, pullOptions.Logger, pullOptions.NestedBundlesMaxDepth)
+	} else {
+		isRootBundleRelocated, err = bundleToPull.PullWithOpts(outputPath, pullOptions.ExtractOpts, pullOptions.Logger, false)
+	}
 	if err != nil {
 		return PullStatus{}, err
 	}
@@ -166,6 +211,11 @@ func pullBundle(imgRef string, bundleToPull *bundle.Bundle, outputPath string, p
 		return PullStatus{}, err
 	}
 
+	mediaType, err := bundleToPull.MediaType()
+	if err != nil {
+		return PullStatus{}, err
+	}
+
 	bInfo := buildBundleInfoFromBundle(bundleToPull, isRootBundleRelocated)
 	return PullStatus{
 		BundleInfo: BundleInfo{
@@ -178,11 +228,13 @@ func pullBundle(imgRef string, bundleToPull *bundle.Bundle, outputPath string, p
 		},
 		Cacheable: isCacheable,
 		IsBundle:  true,
+		MediaType: mediaType,
 	}, nil
 }
 
-func pullImage(imageRef string, outputPath string, pullOptions PullOpts, reg registry.Registry) (PullStatus, error) {
-	plainImg := plainimage.NewPlainImage(imageRef, reg)
+// pullImage extracts plainImg, imageRef is the reference provided by the user, which can point to the image index
+// plainImg was selected from
+func pullImage(imageRef string, plainImg *plainimage.PlainImage, outputPath string, pullOptions PullOpts) (PullStatus, error) {
 	isImage, err := plainImg.IsImage()
 	if err != nil {
 		return PullStatus{}, err
@@ -191,11 +243,23 @@ func pullImage(imageRef string, outputPath string, pullOptions PullOpts, reg reg
 		return PullStatus{}, fmt.Errorf("Unable to pull non-images, such as image indexes. (hint: provide a specific digest to the image instead)")
 	}
 
-	err = plainImg.Pull(outputPath, pullOptions.Logger)
+	if outputPath != "" {
+		err = plainImg.PullWithOpts(outputPath, pullOptions.ExtractOpts, pullOptions.Logger)
+		if err != nil {
+			return PullStatus{}, err
+		}
+	}
+	if pullOptions.LayersDir != "" {
+		err = plainImg.PullLayersWithOpts(pullOptions.LayersDir, pullOptions.ExtractOpts, pullOptions.Logger)
+		if err != nil {
+			return PullStatus{}, err
+		}
+	}
+	isCacheable, err := isCacheable(imageRef, true)
 	if err != nil {
 		return PullStatus{}, err
 	}
-	isCacheable, err := isCacheable(imageRef, true)
+	mediaType, err := plainImg.MediaType()
 	if err != nil {
 		return PullStatus{}, err
 	}
@@ -205,6 +269,7 @@ func pullImage(imageRef string, outputPath string, pullOptions PullOpts, reg reg
 		},
 		Cacheable: isCacheable,
 		IsBundle:  false,
+		MediaType: mediaType,
 	}, nil
 }
 
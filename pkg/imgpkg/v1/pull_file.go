@@ -0,0 +1,30 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"io"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+)
+
+// PullFile Writes the content of the file at name, in the merged view of the layers of the image referenced by
+// imageRef, to out without extracting anything else. When name is a directory its immediate children are listed
+// instead. The same checks as Pull are done to ensure the reference is a bundle or an image
+func PullFile(imageRef string, name string, out io.Writer, pullOptions PullOpts, registryOpts registry.Opts) error {
+	reg, err := registry.NewSimpleRegistry(registryOpts)
+	if err != nil {
+		return err
+	}
+	return PullFileWithRegistry(imageRef, name, out, pullOptions, reg)
+}
+
+// PullFileWithRegistry Writes the content of the file at name of the image referenced by imageRef to out
+func PullFileWithRegistry(imageRef string, name string, out io.Writer, pullOptions PullOpts, reg registry.Registry) error {
+	dirImage, err := fetchDirImage(imageRef, pullOptions, reg)
+	if err != nil {
+		return err
+	}
+	return dirImage.WriteFile(name, out)
+}
@@ -0,0 +1,360 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/bundle"
+	"carvel.dev/imgpkg/pkg/imgpkg/imagedesc"
+	"carvel.dev/imgpkg/pkg/imgpkg/imageset"
+	"carvel.dev/imgpkg/pkg/imgpkg/imagetar"
+	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// PullFromTar Extracts the contents of the image, or bundle, referenced by imageRef from the tar created by
+// copy --to-tar at tarPath to the folder outputPath, without a registry. imageRef is the reference the image had when
+// it was copied, or only its digest, and can be empty to pull the bundle that was copied into the tar. The manifest,
+// config and layers of the images are verified against their digests
+func PullFromTar(tarPath string, imageRef string, outputPath string, pullOptions PullOpts) (PullStatus, error) {
+	return pullFromTar(tarPath, imageRef, outputPath, pullOptions, false)
+}
+
+// PullRecursiveFromTar Extracts the contents of the bundle referenced by imageRef, and of its nested bundles, from
+// the tar created by copy --to-tar at tarPath to the folder outputPath. See PullFromTar
+func PullRecursiveFromTar(tarPath string, imageRef string, outputPath string, pullOptions PullOpts) (PullStatus, error) {
+	return pullFromTar(tarPath, imageRef, outputPath, pullOptions, true)
+}
+
+func pullFromTar(tarPath string, imageRef string, outputPath string, pullOptions PullOpts, pullNestedBundles bool) (PullStatus, error) {
+	images, err := newTarImages(tarPath)
+	if err != nil {
+		return PullStatus{}, err
+	}
+	img, err := images.find(imageRef)
+	if err != nil {
+		return PullStatus{}, err
+	}
+	if imageRef == "" {
+		imageRef = img.ref.String()
+	}
+
+	if img.index != nil && pullOptions.Platform != nil {
+		selectedRef, err := selectPlatform(img.ref.String(), img.ref, img.index, pullOptions.Platform, pullOptions.Logger)
+		if err != nil {
+			return PullStatus{}, err
+		}
+		img, err = images.find(selectedRef)
+		if err != nil {
+			return PullStatus{}, err
+		}
+	}
+	if img.image == nil {
+		return PullStatus{}, fmt.Errorf("Unable to pull non-images, such as image indexes. (hint: provide a specific digest to the image instead)")
+	}
+
+	imagesLockReader := bundle.NewImagesLockReader()
+	plainImg := plainimage.NewFetchedPlainImageWithTag(img.ref.String(), img.tag, img.image)
+	// the nested bundles are in the tar too, copy --to-tar includes them
+	bundleToPull := bundle.NewBundle(plainImg, images, imagesLockReader, bundle.NewFetcherFromProcessedImages(images.processedImages(), images, imagesLockReader))
+
+	if !pullNestedBundles {
+		return pullBundleOrImage(imageRef, bundleToPull, plainImg, outputPath, pullOptions)
+	}
+	isBundle, err := bundleToPull.IsBundle()
+	if err != nil {
+		return PullStatus{}, err
+	}
+	if !isBundle {
+		return PullStatus{}, &ErrIsNotBundle{}
+	}
+	return pullBundle(imageRef, bundleToPull, outputPath, pullOptions, true)
+}
+
+// tarImage image, or image index, of a tar created by copy --to-tar
+type tarImage struct {
+	ref     regname.Digest
+	tag     string
+	labels  map[string]string
+	origRef string
+	image   regv1.Image
+	index   regv1.ImageIndex
+}
+
+// tarImages images of a tar created by copy --to-tar. It provides the image metadata bundles use to find their
+// images, an image exists in a repository when it was copied from that repository
+type tarImages struct {
+	path   string
+	images []tarImage
+}
+
+var _ bundle.ImagesMetadata = &tarImages{}
+
+// newTarImages reads the images of the tar at path, and verifies their manifests and configs against their digests.
+// The layers are verified when they are read
+func newTarImages(path string) (*tarImages, error) {
+	imgOrIndexes, err := imagetar.NewTarReader(path).Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reading tar '%s': %s", path, err)
+	}
+
+	images := &tarImages{path: path}
+	for _, item := range imgOrIndexes {
+		switch {
+		case item.Image != nil:
+			err = images.addImage(*item.Image, item.Labels, item.OrigRef)
+		case item.Index != nil:
+			err = images.addIndex(*item.Index, item.Labels, item.OrigRef)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+func (t *tarImages) addImage(img imagedesc.ImageWithRef, labels map[string]string, origRef string) error {
+	ref, err := regname.NewDigest(img.Ref())
+	if err != nil {
+		return err
+	}
+	err = verifyTarImage(img)
+	if err != nil {
+		return fmt.Errorf("Verifying image '%s' of tar '%s': %s", ref, t.path, err)
+	}
+	t.images = append(t.images, tarImage{ref: ref, tag: img.Tag(), labels: labels, origRef: origRef, image: img})
+	return nil
+}
+
+// addIndex adds the index and the images it references, so that they can be pulled by digest
+func (t *tarImages) addIndex(index imagedesc.ImageIndexWithRef, labels map[string]string, origRef string) error {
+	ref, err := regname.NewDigest(index.Ref())
+	if err != nil {
+		return err
+	}
+	raw, err := index.RawManifest()
+	if err != nil {
+		return err
+	}
+	err = verifyTarDigest("manifest", raw, ref.DigestStr())
+	if err != nil {
+		return fmt.Errorf("Verifying image index '%s' of tar '%s': %s", ref, t.path, err)
+	}
+	t.images = append(t.images, tarImage{ref: ref, tag: index.Tag(), labels: labels, origRef: origRef, index: index})
+
+	described, ok := index.(imagedesc.DescribedImageIndex)
+	if !ok {
+		return nil
+	}
+	for _, img := range described.Images() {
+		if imgWithRef, ok := img.(imagedesc.ImageWithRef); ok {
+			err := t.addImage(imgWithRef, nil, "")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, nestedIndex := range described.Indexes() {
+		if indexWithRef, ok := nestedIndex.(imagedesc.ImageIndexWithRef); ok {
+			err := t.addIndex(indexWithRef, nil, "")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the image referenced by imageRef, which can be only a digest. Digest references match the image with
+// the same digest whatever its repository, since its content is verified against the digest. When imageRef is empty
+// the bundle copied into the tar is returned
+func (t *tarImages) find(imageRef string) (tarImage, error) {
+	if imageRef == "" {
+		return t.rootBundle()
+	}
+
+	if digest, err := regv1.NewHash(imageRef); err == nil {
+		for _, img := range t.images {
+			if img.ref.DigestStr() == digest.String() {
+				return img, nil
+			}
+		}
+		return tarImage{}, fmt.Errorf("Expected image '%s' to be in tar '%s'", imageRef, t.path)
+	}
+
+	ref, err := regname.ParseReference(imageRef, regname.WeakValidation)
+	if err != nil {
+		return tarImage{}, err
+	}
+	if digestRef, ok := ref.(regname.Digest); ok {
+		return t.find(digestRef.DigestStr())
+	}
+	img, found := t.lookup(ref)
+	if !found {
+		return tarImage{}, fmt.Errorf("Expected image '%s' to be in tar '%s' (hint: Use the digest of the image, tags are only known for the images copied by tag)", imageRef, t.path)
+	}
+	return img, nil
+}
+
+// rootBundle returns the bundle copied into the tar, as opposed to its images and nested bundles
+func (t *tarImages) rootBundle() (tarImage, error) {
+	var bundles []tarImage
+	for _, img := range t.images {
+		if _, found := img.labels[imageset.RootBundleLabelKey]; found {
+			bundles = append(bundles, img)
+		}
+	}
+	if len(bundles) != 1 {
+		return tarImage{}, fmt.Errorf("Expected tar '%s' to contain a bundle copied with -b (hint: Use -b or -i to select the image to pull)", t.path)
+	}
+	return bundles[0], nil
+}
+
+// lookup returns the image copied from the repository of ref, with the digest or the tag of ref
+func (t *tarImages) lookup(ref regname.Reference) (tarImage, bool) {
+	for _, img := range t.images {
+		if img.ref.Context().Name() != ref.Context().Name() {
+			continue
+		}
+		switch typedRef := ref.(type) {
+		case regname.Digest:
+			if img.ref.DigestStr() == typedRef.DigestStr() {
+				return img, true
+			}
+		case regname.Tag:
+			if img.tag != "" && img.tag == typedRef.TagStr() {
+				return img, true
+			}
+		}
+	}
+	return tarImage{}, false
+}
+
+// processedImages returns the images of the tar the way copy returns them, so that nested bundles are found in the tar
+func (t *tarImages) processedImages() []imageset.ProcessedImage {
+	var processedImages []imageset.ProcessedImage
+	for _, img := range t.images {
+		processedImages = append(processedImages, imageset.ProcessedImage{
+			UnprocessedImageRef: imageset.UnprocessedImageRef{DigestRef: img.ref.String(), Tag: img.tag, Labels: img.labels, OrigRef: img.origRef},
+			DigestRef:           img.ref.String(),
+			Image:               img.image,
+			ImageIndex:          img.index,
+		})
+	}
+	return processedImages
+}
+
+// Get is not supported, the images are only read through Image
+func (t *tarImages) Get(ref regname.Reference) (*regremote.Descriptor, error) {
+	return nil, fmt.Errorf("Fetching the descriptor of '%s' from tar '%s' is not supported", ref, t.path)
+}
+
+// Image returns the image copied from the repository of ref
+func (t *tarImages) Image(ref regname.Reference) (regv1.Image, error) {
+	img, found := t.lookup(ref)
+	if !found || img.image == nil {
+		return nil, t.notFoundErr(ref)
+	}
+	return img.image, nil
+}
+
+// Digest returns the digest of the image, or image index, copied from the repository of ref
+func (t *tarImages) Digest(ref regname.Reference) (regv1.Hash, error) {
+	img, found := t.lookup(ref)
+	if !found {
+		return regv1.Hash{}, t.notFoundErr(ref)
+	}
+	return regv1.NewHash(img.ref.DigestStr())
+}
+
+// FirstImageExists returns the first of the references that was copied into the tar
+func (t *tarImages) FirstImageExists(digests []string) (string, error) {
+	for _, digest := range digests {
+		ref, err := regname.NewDigest(digest)
+		if err != nil {
+			return "", err
+		}
+		if _, found := t.lookup(ref); found {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("Expected one of the locations of the image to be in tar '%s': %v", t.path, digests)
+}
+
+// notFoundErr returns the error a registry returns for a missing image, which the bundles rely on to know that an
+// image was not relocated
+func (t *tarImages) notFoundErr(ref regname.Reference) error {
+	return &transport.Error{
+		Errors: []transport.Diagnostic{{
+			Code:    transport.ManifestUnknownErrorCode,
+			Message: fmt.Sprintf("image '%s' is not in tar '%s'", ref, t.path),
+		}},
+		StatusCode: http.StatusNotFound,
+	}
+}
+
+// verifyTarImage checks that the manifest and the config of the image match their digests, and that the layers of the
+// image are the ones of its manifest
+func verifyTarImage(img regv1.Image) error {
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	raw, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	err = verifyTarDigest("manifest", raw, digest.String())
+	if err != nil {
+		return err
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	err = verifyTarDigest("config", rawConfig, manifest.Config.Digest.String())
+	if err != nil {
+		return err
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	if len(layers) != len(manifest.Layers) {
+		return fmt.Errorf("Expected %d layer(s) as in the manifest, found %d", len(manifest.Layers), len(layers))
+	}
+	for idx, layer := range layers {
+		layerDigest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if layerDigest != manifest.Layers[idx].Digest {
+			return fmt.Errorf("Expected layer %d to be '%s' as in the manifest, found '%s'", idx, manifest.Layers[idx].Digest, layerDigest)
+		}
+	}
+	return nil
+}
+
+func verifyTarDigest(kind string, content []byte, expected string) error {
+	computed, _, err := regv1.SHA256(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if computed.String() != expected {
+		return fmt.Errorf("Expected %s to match digest '%s', computed '%s'", kind, expected, computed)
+	}
+	return nil
+}
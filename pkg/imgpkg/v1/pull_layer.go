@@ -0,0 +1,52 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"fmt"
+	"io"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/internal/util"
+	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// PullLayerOpts Options that select the layer written by PullLayer
+type PullLayerOpts struct {
+	// Digest of the compressed layer blob
+	Digest string
+	// Uncompressed writes the tar of the layer instead of the compressed blob
+	Uncompressed bool
+	// Platform selects the image the layer is read from when the reference points to an image index
+	Platform *regv1.Platform
+}
+
+// PullLayer Writes one layer of the image referenced by imageRef to out, without extracting it
+func PullLayer(imageRef string, out io.Writer, layerOpts PullLayerOpts, registryOpts registry.Opts) error {
+	reg, err := registry.NewSimpleRegistry(registryOpts)
+	if err != nil {
+		return err
+	}
+	return PullLayerWithRegistry(imageRef, out, layerOpts, reg)
+}
+
+// PullLayerWithRegistry Writes one layer of the image referenced by imageRef to out, without extracting it
+func PullLayerWithRegistry(imageRef string, out io.Writer, layerOpts PullLayerOpts, reg registry.Registry) error {
+	imageRef, err := resolvePlatform(imageRef, layerOpts.Platform, util.NewNoopLevelLogger(), reg)
+	if err != nil {
+		return err
+	}
+
+	img, err := plainimage.NewPlainImage(imageRef, reg).Fetch()
+	if err != nil {
+		if plainimage.IsNotAnImageError(err) {
+			return fmt.Errorf("Unable to pull non-images, such as image indexes. (hint: provide a specific digest to the image instead)")
+		}
+		return err
+	}
+
+	return ctlimg.WriteLayer(img, layerOpts.Digest, layerOpts.Uncompressed, out)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"fmt"
+
+	ctlimg "carvel.dev/imgpkg/pkg/imgpkg/image"
+	"carvel.dev/imgpkg/pkg/imgpkg/plainimage"
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+// PullOCILayoutOpts Options that control how PullToOCILayout writes the image
+type PullOCILayoutOpts struct {
+	// IncludeIndex writes image indexes, with all the images they reference, instead of failing
+	IncludeIndex bool
+}
+
+// PullToOCILayout Writes the image referenced by imageRef as an OCI image layout in outputPath, keeping its manifest,
+// config and compressed layers as they are in the registry. The same checks as Pull are done to ensure the reference
+// is a bundle or an image, and for bundles only the bundle image is written, not the images it references
+func PullToOCILayout(imageRef string, outputPath string, pullOptions PullOpts, layoutOpts PullOCILayoutOpts, registryOpts registry.Opts) error {
+	reg, err := registry.NewSimpleRegistry(registryOpts)
+	if err != nil {
+		return err
+	}
+	return PullToOCILayoutWithRegistry(imageRef, outputPath, pullOptions, layoutOpts, reg)
+}
+
+// PullToOCILayoutWithRegistry Writes the image referenced by imageRef as an OCI image layout in outputPath
+func PullToOCILayoutWithRegistry(imageRef string, outputPath string, pullOptions PullOpts, layoutOpts PullOCILayoutOpts, reg registry.Registry) error {
+	if !layoutOpts.IncludeIndex {
+		var err error
+		imageRef, err = resolvePlatform(imageRef, pullOptions.Platform, pullOptions.Logger, reg)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := checkIsBundle(imageRef, pullOptions, reg)
+	if err != nil {
+		return err
+	}
+
+	plainImg := plainimage.NewPlainImage(imageRef, reg)
+	img, err := plainImg.Fetch()
+	if err != nil {
+		if !plainimage.IsNotAnImageError(err) {
+			return err
+		}
+		if !layoutOpts.IncludeIndex {
+			return fmt.Errorf("Unable to write image indexes as an OCI image layout (hint: Use --include-index to write the index with all its images, or provide a specific digest to the image instead)")
+		}
+		return pullIndexToOCILayout(plainImg.DigestRef(), outputPath, pullOptions, reg)
+	}
+
+	pullOptions.Logger.Logf("Writing image '%s' as an OCI image layout to '%s'\n", plainImg.DigestRef(), outputPath)
+	return ctlimg.WriteOCILayout(pullOptions.ExtractOpts.Context, outputPath, img)
+}
+
+func pullIndexToOCILayout(indexRef string, outputPath string, pullOptions PullOpts, reg registry.Registry) error {
+	ref, err := regname.ParseReference(indexRef, regname.WeakValidation)
+	if err != nil {
+		return err
+	}
+	index, err := reg.Index(ref)
+	if err != nil {
+		return fmt.Errorf("Fetching image index: %s", err)
+	}
+
+	pullOptions.Logger.Logf("Writing image index '%s' as an OCI image layout to '%s'\n", indexRef, outputPath)
+	return ctlimg.WriteOCILayoutIndex(pullOptions.ExtractOpts.Context, outputPath, index)
+}
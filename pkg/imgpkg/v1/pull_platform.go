@@ -0,0 +1,68 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// resolvePlatform returns the digest reference of the image for platform when imageRef points to an image index,
+// so that the image is what gets pulled and reported. Other references, or a nil platform, are returned unchanged
+func resolvePlatform(imageRef string, platform *regv1.Platform, logger Logger, reg registry.Registry) (string, error) {
+	if platform == nil {
+		return imageRef, nil
+	}
+
+	ref, err := regname.ParseReference(imageRef, regname.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := reg.Get(ref)
+	if err != nil {
+		return "", fmt.Errorf("Fetching image: %s", err)
+	}
+	if !desc.MediaType.IsIndex() {
+		return imageRef, nil
+	}
+
+	index, err := reg.Index(ref)
+	if err != nil {
+		return "", fmt.Errorf("Fetching image index: %s", err)
+	}
+	return selectPlatform(imageRef, ref, index, platform, logger)
+}
+
+// selectPlatform returns the digest reference of the image of the index for platform, in the repository of ref
+func selectPlatform(imageRef string, ref regname.Reference, index regv1.ImageIndex, platform *regv1.Platform, logger Logger) (string, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return "", fmt.Errorf("Fetching image index: %s", err)
+	}
+
+	var available []string
+	for _, childDesc := range manifest.Manifests {
+		if !childDesc.MediaType.IsImage() || childDesc.Platform == nil {
+			continue
+		}
+		if childDesc.Platform.Satisfies(*platform) {
+			imageDigestRef := ref.Context().Digest(childDesc.Digest.String()).String()
+			logger.Logf("Selected image '%s' for platform '%s' from image index '%s'\n", imageDigestRef, childDesc.Platform, imageRef)
+			return imageDigestRef, nil
+		}
+		available = append(available, childDesc.Platform.String())
+	}
+
+	if len(available) == 0 {
+		// without platforms there is nothing to select, pulling the index fails as usual
+		return imageRef, nil
+	}
+	return "", fmt.Errorf("Image index '%s' has no image for platform '%s', available platforms are %s (hint: Use --platform to select one of them)",
+		imageRef, platform, strings.Join(available, ", "))
+}
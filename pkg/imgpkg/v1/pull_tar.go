@@ -0,0 +1,30 @@
+// Copyright 2024 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1
+
+import (
+	"io"
+
+	"carvel.dev/imgpkg/pkg/imgpkg/registry"
+)
+
+// PullAsTar Writes the contents of the image referenced by imageRef to out as a single tar stream, with the merged
+// view of all the layers. The same checks as Pull are done to ensure the reference is a bundle or an image, and for
+// bundles only the bundle contents are written, the images lock file is not updated and nested bundles are not included
+func PullAsTar(imageRef string, out io.Writer, pullOptions PullOpts, registryOpts registry.Opts) error {
+	reg, err := registry.NewSimpleRegistry(registryOpts)
+	if err != nil {
+		return err
+	}
+	return PullAsTarWithRegistry(imageRef, out, pullOptions, reg)
+}
+
+// PullAsTarWithRegistry Writes the contents of the image referenced by imageRef to out as a single tar stream
+func PullAsTarWithRegistry(imageRef string, out io.Writer, pullOptions PullOpts, reg registry.Registry) error {
+	dirImage, err := fetchDirImage(imageRef, pullOptions, reg)
+	if err != nil {
+		return err
+	}
+	return dirImage.AsTar(out)
+}
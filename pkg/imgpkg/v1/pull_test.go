@@ -16,6 +16,7 @@ import (
 	"carvel.dev/imgpkg/test/helpers"
 	regname "github.com/google/go-containerregistry/pkg/name"
 	regv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -53,6 +54,7 @@ func TestPullImage(t *testing.T) {
 				ImageRef: randomImg.RefDigest,
 			},
 			Cacheable: false,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  false,
 		}, status)
 	})
@@ -74,6 +76,7 @@ func TestPullImage(t *testing.T) {
 				ImageRef: randomImg.RefDigest,
 			},
 			Cacheable: true,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  false,
 		}, status)
 	})
@@ -107,6 +110,55 @@ func TestPullImage(t *testing.T) {
 	})
 }
 
+func TestPullImageFromIndex(t *testing.T) {
+	indexName := "some/index"
+	fakeRegistry := helpers.NewFakeRegistry(t, &helpers.Logger{LogLevel: helpers.LogDebug})
+	index := fakeRegistry.WithPlatformsImageIndex(indexName,
+		regv1.Platform{OS: "linux", Architecture: "amd64"},
+		regv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	)
+	uiLogger := util.NewNoopLevelLogger()
+
+	defer fakeRegistry.CleanUp()
+	fakeRegistry.Build()
+
+	indexManifest, err := index.ImageIndex.IndexManifest()
+	require.NoError(t, err)
+	arm64Digest := indexManifest.Manifests[1].Digest.String()
+
+	t.Run("pulls the image for the platform and reports its digest", func(t *testing.T) {
+		opts := v1.PullOpts{
+			Logger:   uiLogger,
+			Platform: &regv1.Platform{OS: "linux", Architecture: "arm64"},
+		}
+		status, err := v1.Pull(fakeRegistry.ReferenceOnTestServer(indexName), t.TempDir(), opts, registry.Opts{})
+		require.NoError(t, err)
+		require.Equal(t, v1.PullStatus{
+			BundleInfo: v1.BundleInfo{
+				ImageRef: fakeRegistry.ReferenceOnTestServer(indexName) + "@" + arm64Digest,
+			},
+			Cacheable: false,
+			MediaType: string(types.DockerManifestSchema2),
+			IsBundle:  false,
+		}, status)
+	})
+
+	t.Run("fails listing the available platforms when none matches", func(t *testing.T) {
+		opts := v1.PullOpts{
+			Logger:   uiLogger,
+			Platform: &regv1.Platform{OS: "windows", Architecture: "amd64"},
+		}
+		_, err := v1.Pull(fakeRegistry.ReferenceOnTestServer(indexName), t.TempDir(), opts, registry.Opts{})
+		require.ErrorContains(t, err, "has no image for platform 'windows/amd64', available platforms are linux/amd64, linux/arm64/v8")
+	})
+
+	t.Run("fails when no platform is provided", func(t *testing.T) {
+		opts := v1.PullOpts{Logger: uiLogger}
+		_, err := v1.Pull(fakeRegistry.ReferenceOnTestServer(indexName), t.TempDir(), opts, registry.Opts{})
+		require.ErrorContains(t, err, "Unable to pull non-images, such as image indexes")
+	})
+}
+
 func TestPullBundle(t *testing.T) {
 	bundleName := "some/bundle"
 	collocatedBundle := "some/collocated-bundle"
@@ -144,6 +196,7 @@ func TestPullBundle(t *testing.T) {
 				},
 			},
 			Cacheable: false,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -166,6 +219,7 @@ func TestPullBundle(t *testing.T) {
 				ImageRef: randomBundle,
 			},
 			Cacheable: true,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -191,6 +245,7 @@ func TestPullBundle(t *testing.T) {
 				},
 			},
 			Cacheable: true,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -213,6 +268,7 @@ func TestPullBundle(t *testing.T) {
 				ImageRef: collocatedBundleRef,
 			},
 			Cacheable: true,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -237,6 +293,7 @@ func TestPullBundle(t *testing.T) {
 				ImageRef: collocatedBundleRef,
 			},
 			Cacheable: false,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -316,6 +373,7 @@ func TestPullBundleRecursively(t *testing.T) {
 				}},
 			},
 			Cacheable: false,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -389,6 +447,7 @@ func TestPullBundleRecursively(t *testing.T) {
 				}},
 			},
 			Cacheable: true,
+			MediaType: string(types.DockerManifestSchema2),
 			IsBundle:  true,
 		}, status)
 
@@ -24,6 +24,10 @@ func OptsFromEnv(base registry.Opts, readEnv func(string) (string, bool)) regist
 		opts.Token, _ = readEnv("IMGPKG_TOKEN")
 	}
 
+	if len(opts.CacheDir) == 0 {
+		opts.CacheDir, _ = readEnv("IMGPKG_CACHE_DIR")
+	}
+
 	if anon, _ := readEnv("IMGPKG_ANON"); anon == "true" {
 		opts.Anon = true
 	}
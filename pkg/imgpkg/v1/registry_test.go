@@ -61,6 +61,22 @@ func TestOptsFromEnv(t *testing.T) {
 		require.Equal(t, registry.Opts{Token: "should-use"}, result)
 	})
 
+	t.Run("when the cache directory is define it does not overwrite it", func(t *testing.T) {
+		env := envFake{values: map[string]string{"IMGPKG_CACHE_DIR": "not-used"}}
+		opts := registry.Opts{
+			CacheDir: "/some/cache",
+		}
+		result := v1.OptsFromEnv(opts, env.Value)
+		require.Equal(t, opts, result)
+	})
+
+	t.Run("when the cache directory is NOT define it uses value from the environment", func(t *testing.T) {
+		env := envFake{values: map[string]string{"IMGPKG_CACHE_DIR": "/should/use"}}
+		opts := registry.Opts{}
+		result := v1.OptsFromEnv(opts, env.Value)
+		require.Equal(t, registry.Opts{CacheDir: "/should/use"}, result)
+	})
+
 	t.Run("when anonymous mode is activated via environment variable it set it", func(t *testing.T) {
 		env := envFake{values: map[string]string{"IMGPKG_ANON": "true"}}
 		opts := registry.Opts{}
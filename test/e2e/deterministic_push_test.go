@@ -4,10 +4,18 @@
 package e2e
 
 import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"carvel.dev/imgpkg/test/helpers"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,3 +41,156 @@ func TestDeterministicPush(t *testing.T) {
 
 	require.Equal(t, tag1Digest, tag2Digest, "Digests do not match, hence non-deterministic")
 }
+
+func TestDeterministicPushWithLabels(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Image pushed on windows results in a different sha due to backslashes used on filesystem. Skipping for now until fixed.")
+	}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	assetsPath := "assets/simple-app"
+	buildURLFile := filepath.Join(env.Assets.CreateTempFolder("labels"), "build-url")
+	require.NoError(t, os.WriteFile(buildURLFile, []byte("https://ci.example.com/builds/42\n"), 0600))
+
+	out := imgpkg.Run([]string{"push", "--tty", "-i", env.Image + ":tag1", "-f", assetsPath,
+		"--label", "git-sha=abc123", "--label", "team=platform", "--label", "build-url=@" + buildURLFile})
+	tag1Digest := helpers.ExtractDigest(t, out)
+
+	// the labels are sorted when the config is serialized, the order of the flags does not matter
+	out = imgpkg.Run([]string{"push", "--tty", "-i", env.Image + ":tag2", "-f", assetsPath,
+		"--label", "build-url=https://ci.example.com/builds/42", "--label", "team=platform", "-l", "git-sha=abc123"})
+	tag2Digest := helpers.ExtractDigest(t, out)
+
+	require.Equal(t, tag1Digest, tag2Digest, "Digests do not match, hence non-deterministic")
+
+	out = imgpkg.Run([]string{"push", "--tty", "-i", env.Image + ":tag3", "-f", assetsPath})
+	require.NotEqual(t, tag1Digest, helpers.ExtractDigest(t, out), "Labels are expected to change the digest")
+}
+
+func TestDeterministicPushWithConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Image pushed on windows results in a different sha due to backslashes used on filesystem. Skipping for now until fixed.")
+	}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	assetsPath := "assets/simple-app"
+	configFile := filepath.Join(env.Assets.CreateTempFolder("config-file"), "image-config.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{"Env": ["CHART=app"], "Labels": {"team": "platform"}, "WorkingDir": "/charts"}`), 0600))
+
+	out := imgpkg.Run([]string{"push", "--tty", "-i", env.Image + ":tag1", "-f", assetsPath,
+		"--env", "CONTENT_TYPE=helm-chart", "--env", "ZONE=eu", "--config-file", configFile})
+	tag1Digest := helpers.ExtractDigest(t, out)
+
+	// the environment variables are sorted by name, the order they are provided in does not matter
+	out = imgpkg.Run([]string{"push", "--tty", "-i", env.Image + ":tag2", "-f", assetsPath,
+		"--env", "ZONE=eu", "--config-file", configFile, "--env", "CONTENT_TYPE=helm-chart"})
+	tag2Digest := helpers.ExtractDigest(t, out)
+
+	require.Equal(t, tag1Digest, tag2Digest, "Digests do not match, hence non-deterministic")
+
+	ref, err := name.ParseReference(env.Image + ":tag1")
+	require.NoError(t, err)
+	img, err := remote.Image(ref)
+	require.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, []string{"CHART=app", "CONTENT_TYPE=helm-chart", "ZONE=eu"}, cfg.Config.Env)
+	require.Equal(t, map[string]string{"team": "platform"}, cfg.Config.Labels)
+	require.Equal(t, "/charts", cfg.Config.WorkingDir)
+
+	out = imgpkg.Run([]string{"push", "--tty", "-i", env.Image + ":tag3", "-f", assetsPath, "--env", "ZONE=us", "--config-file", configFile, "--env", "CONTENT_TYPE=helm-chart"})
+	require.NotEqual(t, tag1Digest, helpers.ExtractDigest(t, out), "Environment variables are expected to change the digest")
+}
+
+func TestDeterministicPushWithSourceDateEpoch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Image pushed on windows results in a different sha due to backslashes used on filesystem. Skipping for now until fixed.")
+	}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, &helpers.Logger{})
+	registry.Build()
+	defer registry.CleanUp()
+
+	assetsPath := "assets/simple-app"
+	imageRef := registry.ReferenceOnTestServer("repo/source-date-epoch")
+
+	push := func(tag string, envVars []string, args ...string) v1.Image {
+		out, err := imgpkg.RunWithOpts(append([]string{"push", "--tty", "-i", imageRef + ":" + tag, "-f", assetsPath}, args...), helpers.RunOpts{EnvVars: envVars})
+		require.NoError(t, err)
+		digestRef, err := name.NewDigest(imageRef + "@" + helpers.ExtractDigest(t, out))
+		require.NoError(t, err)
+		img, err := remote.Image(digestRef)
+		require.NoError(t, err)
+		return img
+	}
+
+	img1 := push("tag1", []string{"SOURCE_DATE_EPOCH=1700000000"})
+	img2 := push("tag2", []string{"SOURCE_DATE_EPOCH=1700000000"})
+	require.Equal(t, imageDigest(t, img1), imageDigest(t, img2), "Digests do not match, hence non-deterministic")
+
+	// --build-timestamp has the same effect, and is used over SOURCE_DATE_EPOCH
+	img3 := push("tag3", []string{"SOURCE_DATE_EPOCH=1"}, "--build-timestamp", "1700000000")
+	require.Equal(t, imageDigest(t, img1), imageDigest(t, img3))
+
+	img4 := push("tag4", []string{"SOURCE_DATE_EPOCH=1800000000"})
+	require.NotEqual(t, imageDigest(t, img1), imageDigest(t, img4), "SOURCE_DATE_EPOCH is expected to change the digest")
+
+	// only the times differ, the layer having the modification times of the files
+	cfg1, err := img1.ConfigFile()
+	require.NoError(t, err)
+	cfg4, err := img4.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1700000000, 0).UTC(), cfg1.Created.Time.UTC())
+	require.Equal(t, time.Unix(1800000000, 0).UTC(), cfg4.Created.Time.UTC())
+	cfg1.Created, cfg4.Created = v1.Time{}, v1.Time{}
+	cfg1.History[0].Created, cfg4.History[0].Created = v1.Time{}, v1.Time{}
+	cfg1.RootFS.DiffIDs, cfg4.RootFS.DiffIDs = nil, nil
+	require.Equal(t, cfg1, cfg4)
+
+	files1 := layerModTimes(t, img1)
+	files4 := layerModTimes(t, img4)
+	require.NotEmpty(t, files1)
+	require.Equal(t, len(files1), len(files4))
+	for path, modTime := range files1 {
+		require.Equal(t, time.Unix(1700000000, 0).UTC(), modTime, "modification time of '%s'", path)
+		require.Equal(t, time.Unix(1800000000, 0).UTC(), files4[path], "modification time of '%s'", path)
+	}
+}
+
+func imageDigest(t *testing.T, img v1.Image) v1.Hash {
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	return digest
+}
+
+// layerModTimes returns the modification time of each entry of the single layer of img
+func layerModTimes(t *testing.T, img v1.Image) map[string]time.Time {
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	layerStream, err := layers[0].Uncompressed()
+	require.NoError(t, err)
+	defer layerStream.Close()
+
+	modTimes := map[string]time.Time{}
+	tarReader := tar.NewReader(layerStream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		modTimes[header.Name] = header.ModTime.UTC()
+	}
+	return modTimes
+}
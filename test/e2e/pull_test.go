@@ -4,12 +4,16 @@
 package e2e
 
 import (
+	"archive/tar"
 	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,6 +21,7 @@ import (
 	"carvel.dev/imgpkg/pkg/imgpkg/lockconfig"
 	"carvel.dev/imgpkg/test/helpers"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -213,6 +218,567 @@ func TestPullImageFromSlowServerShouldTimeout(t *testing.T) {
 	assert.Contains(t, actualErrOut.String(), "timeout awaiting response headers")
 }
 
+func TestPullImageWithManyLayersConcurrently(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("many-layers-image", 25)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	// every layer download takes some time, so that the downloads started together are in flight at the same time
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	registry.WithCustomHandler(func(writer http.ResponseWriter, request *http.Request) bool {
+		if request.Method != http.MethodGet || !strings.Contains(request.URL.Path, "/blobs/") {
+			return false
+		}
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return false
+	})
+
+	pull := func(concurrency string) (string, int) {
+		lock.Lock()
+		maxInFlight = 0
+		lock.Unlock()
+
+		outDir := env.Assets.CreateTempFolder("pull-concurrency-" + concurrency)
+		imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", outDir, "--concurrency", concurrency, "--no-cache"})
+
+		lock.Lock()
+		defer lock.Unlock()
+		return outDir, maxInFlight
+	}
+
+	sequentialDir, sequentialMaxInFlight := pull("1")
+	concurrentDir, concurrentMaxInFlight := pull("5")
+	logger.Debugf("pulled 25 layers with at most %d downloads at once with concurrency 1 and %d with concurrency 5\n", sequentialMaxInFlight, concurrentMaxInFlight)
+
+	sequentialFiles, err := os.ReadDir(sequentialDir)
+	require.NoError(t, err)
+	concurrentFiles, err := os.ReadDir(concurrentDir)
+	require.NoError(t, err)
+	require.Len(t, concurrentFiles, len(sequentialFiles))
+	for idx, file := range sequentialFiles {
+		assert.Equal(t, file.Name(), concurrentFiles[idx].Name())
+	}
+
+	assert.Equal(t, 1, sequentialMaxInFlight)
+	assert.Greater(t, concurrentMaxInFlight, 1)
+	assert.LessOrEqual(t, concurrentMaxInFlight, 5)
+}
+
+func TestPullImageAsTarToStdout(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("tar-image", 3)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	pullDir := env.Assets.CreateTempFolder("pull-as-tar")
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir})
+	extractedFiles, err := os.ReadDir(pullDir)
+	require.NoError(t, err)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	_, err = imgpkg.RunWithOpts([]string{"pull", "--tty", "-i", image.RefDigest, "-o", "-"}, helpers.RunOpts{
+		StderrWriter: stderr,
+		StdoutWriter: stdout,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, stderr.String(), "Succeeded")
+
+	var tarFiles []string
+	tarReader := tar.NewReader(stdout)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		tarFiles = append(tarFiles, hdr.Name)
+	}
+	rest, err := io.ReadAll(stdout)
+	require.NoError(t, err)
+	assert.Empty(t, bytes.Trim(rest, "\x00"), "only the tar is written to stdout")
+
+	require.Len(t, tarFiles, len(extractedFiles))
+	for _, file := range extractedFiles {
+		assert.Contains(t, tarFiles, file.Name())
+	}
+}
+
+func TestPullFileToStdout(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("file-image", 2)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	pullDir := env.Assets.CreateTempFolder("pull-file")
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir})
+	extractedFiles, err := os.ReadDir(pullDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, extractedFiles)
+
+	t.Run("it writes the content of the file to stdout", func(t *testing.T) {
+		name := extractedFiles[0].Name()
+		expected, err := os.ReadFile(filepath.Join(pullDir, name))
+		require.NoError(t, err)
+
+		stdout := bytes.NewBuffer(nil)
+		_, err = imgpkg.RunWithOpts([]string{"pull", "-i", image.RefDigest, "--file", "/" + name, "-o", "-"}, helpers.RunOpts{StdoutWriter: stdout})
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), stdout.String())
+	})
+
+	t.Run("it lists the files of the root directory", func(t *testing.T) {
+		stdout := bytes.NewBuffer(nil)
+		_, err = imgpkg.RunWithOpts([]string{"pull", "-i", image.RefDigest, "--file", "/", "-o", "-"}, helpers.RunOpts{StdoutWriter: stdout})
+		require.NoError(t, err)
+		for _, file := range extractedFiles {
+			assert.Contains(t, stdout.String(), file.Name()+"\n")
+		}
+	})
+
+	t.Run("it fails when the file is not in the image", func(t *testing.T) {
+		stderr := bytes.NewBuffer(nil)
+		_, err := imgpkg.RunWithOpts([]string{"pull", "-i", image.RefDigest, "--file", "not-in-the-image.txt", "-o", "-"}, helpers.RunOpts{
+			AllowError:   true,
+			StderrWriter: stderr,
+		})
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "Expected file 'not-in-the-image.txt' to be in the image")
+	})
+}
+
+func TestPullLayersDir(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("layers-dir-image", 3)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	assertLayersDir := func(t *testing.T, layersDir string) {
+		content, err := os.ReadFile(filepath.Join(layersDir, "layers.json"))
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `"directory": "0-`)
+		assert.Contains(t, string(content), `"directory": "2-`)
+
+		entries, err := os.ReadDir(layersDir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 4, "a directory per layer and the layers file")
+	}
+
+	t.Run("it extracts the layers next to the merged image", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("layers-dir-output")
+		layersDir := env.Assets.CreateTempFolder("layers-dir-layers")
+		imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir, "--layers-dir", layersDir})
+
+		files, err := os.ReadDir(pullDir)
+		require.NoError(t, err)
+		assert.NotEmpty(t, files)
+		assertLayersDir(t, layersDir)
+	})
+
+	t.Run("it only extracts the layers when no output is provided", func(t *testing.T) {
+		layersDir := env.Assets.CreateTempFolder("layers-dir-only")
+		imgpkg.Run([]string{"pull", "-i", image.RefDigest, "--layers-dir", layersDir})
+
+		assertLayersDir(t, layersDir)
+	})
+}
+
+func TestPullVerifiesDigest(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("verified-image", 2)
+	otherImage := registry.WithRandomImageWithLayers("other-image", 1)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	taggedRef := strings.Split(image.RefDigest, "@")[0] + ":" + image.Tag
+
+	t.Run("it pulls the image when it resolves to the expected digest and prints the digest", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("verified-digest")
+		out := imgpkg.Run([]string{"pull", "-i", taggedRef, "--expected-digest", image.Digest, "-o", pullDir, "--json"})
+
+		assert.Contains(t, out, `"digest": "`+image.Digest+`"`)
+		files, err := os.ReadDir(pullDir)
+		require.NoError(t, err)
+		assert.NotEmpty(t, files)
+	})
+
+	t.Run("it does not extract the image when it resolves to another digest", func(t *testing.T) {
+		pullDir := filepath.Join(env.Assets.CreateTempFolder("wrong-digest"), "output")
+		stderr := bytes.NewBufferString("")
+		_, err := imgpkg.RunWithOpts([]string{"pull", "-i", taggedRef, "--expected-digest", otherImage.Digest, "-o", pullDir},
+			helpers.RunOpts{AllowError: true, StderrWriter: stderr})
+
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "to resolve to digest '"+otherImage.Digest+"'")
+		assert.Contains(t, stderr.String(), "but it resolves to digest '"+image.Digest+"'")
+		assert.NoDirExists(t, pullDir)
+	})
+
+	t.Run("it verifies the digest recorded in an ImagesLock", func(t *testing.T) {
+		lockPath := filepath.Join(env.Assets.CreateTempFolder("verified-lock"), "images.yml")
+		require.NoError(t, os.WriteFile(lockPath, []byte(fmt.Sprintf(`apiVersion: imgpkg.carvel.dev/v1alpha1
+kind: ImagesLock
+images:
+- image: %s
+`, image.RefDigest)), 0600))
+
+		pullDir := env.Assets.CreateTempFolder("verified-lock-output")
+		imgpkg.Run([]string{"pull", "-i", taggedRef, "--lock", lockPath, "-o", pullDir})
+
+		files, err := os.ReadDir(pullDir)
+		require.NoError(t, err)
+		assert.NotEmpty(t, files)
+	})
+}
+
+func TestPullDedupe(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("dedupe-image", 2)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	dedupeDir := env.Assets.CreateTempFolder("dedupe-store")
+	pullDir1 := env.Assets.CreateTempFolder("dedupe-output-1")
+	pullDir2 := env.Assets.CreateTempFolder("dedupe-output-2")
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir1, "--dedupe", "hardlink", "--dedupe-dir", dedupeDir})
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir2, "--dedupe", "hardlink", "--dedupe-dir", dedupeDir})
+
+	linkedFiles := 0
+	err := filepath.Walk(pullDir1, func(path string, info os.FileInfo, err error) error {
+		require.NoError(t, err)
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(pullDir1, path)
+		require.NoError(t, err)
+		otherInfo, err := os.Stat(filepath.Join(pullDir2, rel))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(info, otherInfo), "file '%s' is shared by both output directories", rel)
+		linkedFiles++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, linkedFiles)
+}
+
+func TestPullReportsExtractStats(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("stats-image", 2)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	t.Run("it logs a summary of the extraction", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("stats-output")
+		out := imgpkg.Run([]string{"pull", "--tty", "-i", image.RefDigest, "-o", pullDir})
+
+		assert.Contains(t, out, "from 2 layer(s) in")
+		assert.Contains(t, out, "Layer 'sha256:")
+	})
+
+	t.Run("it prints the statistics of each layer with --json", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("stats-json-output")
+		out := imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir, "--json"})
+
+		assert.Contains(t, out, `"skipped_symlinks": "0"`)
+		assert.Contains(t, out, `"image": "`+image.Digest+`"`)
+		assert.Contains(t, out, `"layers": "2"`)
+	})
+}
+
+func TestPullPrintsJSONResult(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("result-image", 2)
+	bundleInfo := registry.WithRandomBundle("result-bundle")
+	registry.Build()
+	defer registry.ResetHandler()
+
+	t.Run("it prints the digest, the output and the layers of the image with --json", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("result-output")
+		out := imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", pullDir, "--json"})
+
+		layers, err := image.Image.Layers()
+		require.NoError(t, err)
+		assert.Contains(t, out, `"reference": "`+image.RefDigest+`"`)
+		assert.Contains(t, out, `"digest": "`+image.Digest+`"`)
+		assert.Contains(t, out, `"media_type": "application/vnd.`)
+		assert.Contains(t, out, `"bundle": "false"`)
+		assert.Contains(t, out, `"output": "`+pullDir+`"`)
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			require.NoError(t, err)
+			assert.Contains(t, out, digest.String())
+		}
+	})
+
+	t.Run("it writes the error with its code to stderr with --json", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("result-error-output")
+		var stderr bytes.Buffer
+		_, err := imgpkg.RunWithOpts([]string{"pull", "-i", bundleInfo.RefDigest, "-o", pullDir, "--json"}, helpers.RunOpts{AllowError: true, StderrWriter: &stderr})
+		require.Error(t, err)
+
+		assert.Contains(t, stderr.String(), `"code":"image-is-bundle"`)
+		assert.Contains(t, stderr.String(), `"error":"Expected bundle flag when pulling a bundle (hint: Use -b instead of -i for bundles)"`)
+	})
+}
+
+func TestPullUsesLayerCache(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("layer-cache-image", 2)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	var blobDownloads int32
+	registry.WithHandlerFunc(func(_ http.ResponseWriter, request *http.Request) bool {
+		if request.Method == http.MethodGet && strings.Contains(request.URL.Path, "/blobs/") {
+			atomic.AddInt32(&blobDownloads, 1)
+		}
+		return false
+	})
+
+	cacheDir := env.Assets.CreateTempFolder("layer-cache")
+
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", env.Assets.CreateTempFolder("layer-cache-pull-1"), "--cache-dir", cacheDir})
+	require.NotZero(t, atomic.LoadInt32(&blobDownloads))
+
+	atomic.StoreInt32(&blobDownloads, 0)
+	secondPullDir := env.Assets.CreateTempFolder("layer-cache-pull-2")
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", secondPullDir, "--cache-dir", cacheDir})
+	assert.Zero(t, atomic.LoadInt32(&blobDownloads), "the layers are read from the cache")
+	files, err := os.ReadDir(secondPullDir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	imgpkg.Run([]string{"copy", "-i", image.RefDigest, "--to-tar", filepath.Join(env.Assets.CreateTempFolder("layer-cache-copy"), "image.tar"), "--cache-dir", cacheDir})
+	assert.Zero(t, atomic.LoadInt32(&blobDownloads), "the layers copied are read from the cache")
+
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", env.Assets.CreateTempFolder("layer-cache-pull-3"), "--cache-dir", cacheDir, "--no-cache"})
+	assert.NotZero(t, atomic.LoadInt32(&blobDownloads), "the cache is not used with --no-cache")
+
+	atomic.StoreInt32(&blobDownloads, 0)
+	imgpkg.Run([]string{"cache", "prune", "--max-size", "0", "--cache-dir", cacheDir})
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "-o", env.Assets.CreateTempFolder("layer-cache-pull-4"), "--cache-dir", cacheDir})
+	assert.NotZero(t, atomic.LoadInt32(&blobDownloads), "the layers are downloaded again after the cache is pruned")
+}
+
+func TestPullImagesFile(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image1 := registry.WithRandomImageWithLayers("images-file-image-1", 2)
+	image2 := registry.WithRandomImageWithLayers("images-file-image-2", 2)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	pullDir := env.Assets.CreateTempFolder("pull-images-file")
+	missingImage := registry.ReferenceOnTestServer("images-file-missing") + ":latest"
+	imagesFile := filepath.Join(pullDir, "images.yml")
+	require.NoError(t, os.WriteFile(imagesFile, []byte(fmt.Sprintf(`images:
+- image: %s
+  output: %s
+- image: %s
+  output: %s
+- image: %s
+  output: %s
+`, image1.RefDigest, filepath.Join(pullDir, "image-1"), missingImage, filepath.Join(pullDir, "missing"), image2.RefDigest, filepath.Join(pullDir, "image-2"))), 0600))
+
+	out := bytes.NewBuffer(nil)
+	_, err := imgpkg.RunWithOpts([]string{"pull", "--tty", "--images-file", imagesFile, "--images-concurrency", "1"}, helpers.RunOpts{
+		AllowError:   true,
+		StderrWriter: out,
+		StdoutWriter: out,
+	})
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "Failed to pull 1 of 3 images")
+	assert.Contains(t, out.String(), missingImage+" | Error: ")
+
+	for _, dir := range []string{"image-1", "image-2"} {
+		files, err := os.ReadDir(filepath.Join(pullDir, dir))
+		require.NoError(t, err)
+		assert.NotEmpty(t, files, "the other images are pulled when one of them fails")
+	}
+
+	require.NoError(t, os.RemoveAll(filepath.Join(pullDir, "image-2")))
+	out.Reset()
+	_, err = imgpkg.RunWithOpts([]string{"pull", "--tty", "--images-file", imagesFile, "--images-concurrency", "1", "--fail-fast"}, helpers.RunOpts{
+		AllowError:   true,
+		StderrWriter: out,
+		StdoutWriter: out,
+	})
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "Failed to pull 1 of 3 images, 1 were skipped after the first failure")
+	assert.NoDirExists(t, filepath.Join(pullDir, "image-2"))
+}
+
+func TestPullImageLayer(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("layer-image", 3)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	layers, err := image.Image.Layers()
+	require.NoError(t, err)
+	layerDigest, err := layers[1].Digest()
+	require.NoError(t, err)
+
+	layerPath := filepath.Join(env.Assets.CreateTempFolder("pull-layer"), "layer.tar.gz")
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "--layer", layerDigest.String(), "-o", layerPath})
+
+	layerFile, err := os.Open(layerPath)
+	require.NoError(t, err)
+	defer layerFile.Close()
+	digest, _, err := v1.SHA256(layerFile)
+	require.NoError(t, err)
+	require.Equal(t, layerDigest, digest)
+
+	stdout := bytes.NewBuffer(nil)
+	_, err = imgpkg.RunWithOpts([]string{"pull", "-i", image.RefDigest, "--layer", layerDigest.String(), "--uncompressed", "-o", "-"}, helpers.RunOpts{
+		StdoutWriter: stdout,
+	})
+	require.NoError(t, err)
+	diffID, _, err := v1.SHA256(stdout)
+	require.NoError(t, err)
+	expectedDiffID, err := layers[1].DiffID()
+	require.NoError(t, err)
+	require.Equal(t, expectedDiffID, diffID)
+
+	out := bytes.NewBuffer(nil)
+	_, err = imgpkg.RunWithOpts([]string{"pull", "-i", image.RefDigest, "--layer", "sha256:" + strings.Repeat("0", 64), "-o", layerPath}, helpers.RunOpts{
+		AllowError:   true,
+		StderrWriter: out,
+		StdoutWriter: out,
+	})
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "to be part of the image, available layers are:")
+	assert.Contains(t, out.String(), layerDigest.String())
+}
+
+func TestPullImageToOCILayout(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImageWithLayers("layout-image", 3)
+	imageIndex := registry.WithARandomImageIndex("layout-image-index", 2)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	layoutDir := filepath.Join(env.Assets.CreateTempFolder("pull-oci-layout"), "layout")
+	imgpkg.Run([]string{"pull", "-i", image.RefDigest, "--to-oci-layout", layoutDir})
+
+	index, err := layout.ImageIndexFromPath(layoutDir)
+	require.NoError(t, err)
+	expectedDigest, err := image.Image.Digest()
+	require.NoError(t, err)
+	layoutImg, err := index.Image(expectedDigest)
+	require.NoError(t, err)
+	layers, err := image.Image.Layers()
+	require.NoError(t, err)
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		require.NoError(t, err)
+		_, err = layoutImg.LayerByDigest(digest)
+		require.NoError(t, err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	_, err = imgpkg.RunWithOpts([]string{"pull", "-i", imageIndex.RefDigest, "--to-oci-layout", layoutDir}, helpers.RunOpts{
+		AllowError:   true,
+		StderrWriter: out,
+		StdoutWriter: out,
+	})
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "Unable to write image indexes as an OCI image layout (hint: Use --include-index")
+
+	imgpkg.Run([]string{"pull", "-i", imageIndex.RefDigest, "--to-oci-layout", layoutDir, "--include-index"})
+
+	index, err = layout.ImageIndexFromPath(layoutDir)
+	require.NoError(t, err)
+	expectedIndexDigest, err := imageIndex.ImageIndex.Digest()
+	require.NoError(t, err)
+	_, err = index.ImageIndex(expectedIndexDigest)
+	require.NoError(t, err)
+}
+
 func TestPullImageIndexShouldError(t *testing.T) {
 	logger := &helpers.Logger{}
 
@@ -280,3 +846,60 @@ func TestPullImageOfBundle(t *testing.T) {
 		imgpkg.RunWithOpts([]string{"pull", "--tty", "-i", randomBundle.RefDigest, "-o", pullDir, "--image-is-bundle-check=false"}, helpers.RunOpts{})
 	})
 }
+
+func TestPullFromTar(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: helpers.Logger{}, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	image := registry.WithRandomImage("repo/image")
+	bundleInfo := registry.WithBundleFromPath("repo/bundle", "assets/bundle").WithImageRefs([]lockconfig.ImageRef{
+		{Image: image.RefDigest},
+	})
+	registry.Build()
+	defer registry.CleanUp()
+
+	tarPath := filepath.Join(env.Assets.CreateTempFolder("pull-from-tar"), "bundle.tar")
+	imgpkg.Run([]string{"copy", "-b", bundleInfo.RefDigest, "--to-tar", tarPath})
+	// nothing is read from the registry anymore
+	registry.CleanUp()
+
+	t.Run("pulls the bundle copied into the tar when no reference is provided", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("pull-from-tar-bundle")
+		imgpkg.Run([]string{"pull", "--tar", tarPath, "-o", pullDir})
+
+		assert.FileExists(t, filepath.Join(pullDir, "config.yml"))
+		imagesLock, err := lockconfig.NewImagesLockFromPath(filepath.Join(pullDir, ".imgpkg", "images.yml"))
+		require.NoError(t, err)
+		require.Len(t, imagesLock.Images, 1)
+		assert.Equal(t, image.RefDigest, imagesLock.Images[0].Image)
+	})
+
+	t.Run("pulls the bundle by its original reference", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("pull-from-tar-bundle-ref")
+		imgpkg.Run([]string{"pull", "--tar", tarPath, "-b", bundleInfo.RefDigest, "-o", pullDir})
+		assert.FileExists(t, filepath.Join(pullDir, "config.yml"))
+	})
+
+	t.Run("pulls an image of the bundle by its digest", func(t *testing.T) {
+		pullDir := env.Assets.CreateTempFolder("pull-from-tar-image")
+		imgpkg.Run([]string{"pull", "--tar", tarPath, "-i", image.Digest, "-o", pullDir})
+		files, err := os.ReadDir(pullDir)
+		require.NoError(t, err)
+		assert.NotEmpty(t, files)
+	})
+
+	t.Run("fails when the image is not in the tar", func(t *testing.T) {
+		out := bytes.NewBufferString("")
+		_, err := imgpkg.RunWithOpts([]string{"pull", "--tar", tarPath, "-i", "sha256:" + strings.Repeat("a", 64), "-o", env.Assets.CreateTempFolder("pull-from-tar-missing")}, helpers.RunOpts{
+			AllowError:   true,
+			StderrWriter: out,
+			StdoutWriter: out,
+		})
+		require.Error(t, err)
+		assert.Contains(t, out.String(), fmt.Sprintf("Expected image 'sha256:%s' to be in tar '%s'", strings.Repeat("a", 64), tarPath))
+	})
+}
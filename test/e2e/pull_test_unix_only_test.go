@@ -247,4 +247,69 @@ func TestPull(t *testing.T) {
 		assert.Equal(t, os.FileMode(0050).String(), (info.Mode() & 0070).String(), "group permission doesnt match")
 		assert.Equal(t, os.FileMode(0005).String(), (info.Mode() & 0007).String(), "other permission doesnt match")
 	})
+
+	t.Run("Image - when --preserve-permissions flag is provided on push and pull it keeps the exact modes", func(t *testing.T) {
+		folder := env.Assets.CreateTempFolder("mixed-modes-image")
+		env.Assets.AddFolder(filepath.Join(folder, "secrets"), 0700)
+		env.Assets.AddFileToFolderWithPermissions(filepath.Join(folder, "secrets", "private-key"), "some key", 0600)
+		env.Assets.AddFolder(filepath.Join(folder, "public"), 0755)
+		env.Assets.AddFileToFolderWithPermissions(filepath.Join(folder, "public", "script.sh"), "some script", 0750)
+		env.Assets.AddFileToFolderWithPermissions(filepath.Join(folder, "public", "read-only"), "some text", 0444)
+		env.Assets.AddFileToFolderWithPermissions(filepath.Join(folder, "public", "setuid-tool"), "some tool", 0755)
+		require.NoError(t, os.Chmod(filepath.Join(folder, "public", "setuid-tool"), os.ModeSetuid|0755))
+		env.Assets.AddFolder(filepath.Join(folder, "shared"), 0777)
+		require.NoError(t, os.Chmod(filepath.Join(folder, "shared"), os.ModeSticky|0777))
+
+		out := imgpkg.Run([]string{"push", "--tty", "-i", env.Image, "-f", folder, "--preserve-permissions=true"})
+		imgDigest := fmt.Sprintf("@%s", helpers.ExtractDigest(t, out))
+
+		pullDir := filepath.Join(env.Assets.CreateTempFolder("pull-dir-mixed-modes-image"), "pull-dir")
+		imageRef := fmt.Sprintf("%s%s", env.Image, imgDigest)
+
+		imgpkg.Run([]string{"pull", "-i", imageRef, "-o", pullDir, "--preserve-permissions", "--preserve-special-bits"})
+
+		for _, path := range []string{"secrets", "secrets/private-key", "public", "public/script.sh", "public/read-only", "public/setuid-tool", "shared"} {
+			expectedInfo, err := os.Stat(filepath.Join(folder, path))
+			require.NoError(t, err)
+			info, err := os.Stat(filepath.Join(pullDir, path))
+			require.NoError(t, err)
+			assert.Equal(t, expectedInfo.Mode().String(), info.Mode().String(), fmt.Sprintf("mode of %s doesnt match", path))
+		}
+	})
+}
+
+func TestPullThenPushKeepsTheDigest(t *testing.T) {
+	logger := &helpers.Logger{}
+
+	env := helpers.BuildEnv(t)
+	imgpkg := helpers.Imgpkg{T: t, L: *logger, ImgpkgPath: env.ImgpkgPath}
+	defer env.Cleanup()
+
+	registry := helpers.NewFakeRegistry(t, logger)
+	registry.Build()
+	defer registry.ResetHandler()
+
+	for _, flags := range [][]string{nil, {"--preserve-permissions"}} {
+		t.Run(fmt.Sprintf("with flags %v", flags), func(t *testing.T) {
+			folder := env.Assets.CreateTempFolder("round-trip")
+			env.Assets.AddFolder(filepath.Join(folder, "config", "nested"), 0755)
+			env.Assets.AddFileToFolder(filepath.Join(folder, "config", "nested", "values.yml"), "some values")
+			env.Assets.AddFolder(filepath.Join(folder, "empty"), 0755)
+			env.Assets.AddFolder(filepath.Join(folder, "secrets"), 0755)
+			env.Assets.AddFileToFolderWithPermissions(filepath.Join(folder, "secrets", "key.pem"), "some key", 0600)
+			require.NoError(t, os.Chmod(filepath.Join(folder, "secrets"), 0700))
+
+			imageRef := registry.ReferenceOnTestServer("round-trip")
+			out := imgpkg.Run(append([]string{"push", "--tty", "-i", imageRef + ":pushed", "-f", folder}, flags...))
+			pushedDigest := helpers.ExtractDigest(t, out)
+
+			pullDir := env.Assets.CreateTempFolder("round-trip-pull")
+			// the metadata written by pull is not pushed
+			imgpkg.Run(append([]string{"pull", "-i", imageRef + "@" + pushedDigest, "-o", pullDir, "--include-metadata"}, flags...))
+			require.DirExists(t, filepath.Join(pullDir, ".imgpkg-meta"))
+
+			out = imgpkg.Run(append([]string{"push", "--tty", "-i", imageRef + ":repushed", "-f", pullDir}, flags...))
+			require.Equal(t, pushedDigest, helpers.ExtractDigest(t, out), "pushing the pulled directory should result in the same image")
+		})
+	}
 }
@@ -28,6 +28,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	regname "github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
@@ -710,6 +711,23 @@ func (r *FakeTestRegistryBuilder) WithImageIndex(imageIndexName string, images .
 	return r.updateState(imageIndexName, nil, index, "", "")
 }
 
+// WithPlatformsImageIndex Adds an image index with a random image for each of the provided platforms
+func (r *FakeTestRegistryBuilder) WithPlatformsImageIndex(imageIndexName string, platforms ...v1.Platform) *ImageOrImageIndexWithTarPath {
+	var index v1.ImageIndex = empty.Index
+	for _, platform := range platforms {
+		image, err := random.Image(500, 1)
+		require.NoError(r.t, err)
+
+		platform := platform
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: v1.Descriptor{Platform: &platform},
+		})
+	}
+
+	return r.updateState(imageIndexName, nil, index, "", "")
+}
+
 func (r *FakeTestRegistryBuilder) RemoveImage(imageRef string) {
 	u, err := url.Parse(r.server.URL)
 	assert.NoError(r.t, err)